@@ -0,0 +1,138 @@
+package app
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/solution9th/S3Adapter/internal/config"
+	"github.com/solution9th/S3Adapter/internal/gerror"
+	"github.com/solution9th/S3Adapter/internal/sign"
+
+	"github.com/haozibi/zlog"
+)
+
+// Admin 管理接口的凭证和创建应用时允许使用的后端引擎、地区
+type Admin struct {
+	accessKey, secretKey string
+
+	// engines, regions 为 nil 时不限制
+	engines, regions map[string]bool
+
+	// keyGracePeriod 轮换后旧 key 的有效时间
+	keyGracePeriod time.Duration
+}
+
+// NewAdmin 创建管理凭证，没有配置 AccessKey 或 SecretKey 时返回 nil，不能调用管理接口
+func NewAdmin(cfg config.Admin) *Admin {
+
+	if cfg.AccessKey == "" || cfg.SecretKey == "" {
+		return nil
+	}
+
+	grace := cfg.KeyGracePeriod
+	if grace <= 0 {
+		grace = defaultKeyGracePeriod
+	}
+
+	return &Admin{
+		accessKey:      cfg.AccessKey,
+		secretKey:      cfg.SecretKey,
+		engines:        toSet(cfg.Engines),
+		regions:        toSet(cfg.Regions),
+		keyGracePeriod: grace,
+	}
+}
+
+func toSet(list []string) map[string]bool {
+	if len(list) == 0 {
+		return nil
+	}
+	m := make(map[string]bool, len(list))
+	for _, v := range list {
+		m[v] = true
+	}
+	return m
+}
+
+// allow 是否允许使用 engine 和 region 创建应用
+func (ad *Admin) allow(engine, region string) bool {
+
+	if ad == nil {
+		return false
+	}
+	if ad.engines != nil && !ad.engines[engine] {
+		return false
+	}
+	if ad.regions != nil && !ad.regions[region] {
+		return false
+	}
+	return true
+}
+
+// authAdmin 验证管理接口的签名，只接受管理 key 以 AWS Sign V4 签名的请求，
+// 支持 Authorization 和 URL 预签名，没有配置管理 key 时拒绝所有请求
+func (a *API) authAdmin(r *http.Request) gerror.APIErrorCode {
+
+	if a.Admin == nil {
+		zlog.ZWarn().Msg("[Admin] admin key is not configured")
+		return gerror.ErrAccessDenied
+	}
+
+	var authStr string
+	switch sign.GetRequestAuthType(r) {
+	case sign.AuthTypeSigned:
+		authStr = r.Header.Get("Authorization")
+	case sign.AuthTypePresigned:
+		authStr = AWS4HMACSHA256 + " Credential=" + r.URL.Query().Get("X-Amz-Credential") + ",SignedHeaders=" + r.URL.Query().Get("X-Amz-SignedHeaders") + ",Signature=" + r.URL.Query().Get("X-Amz-Signature")
+	default:
+		return gerror.ErrAccessDenied
+	}
+
+	auth, err := sign.NewAuthSign(authStr)
+	if err != nil {
+		zlog.ZError().Msg(err.Error())
+		return gerror.ErrAccessDenied
+	}
+
+	region := auth.GetRegion()
+	if !validRegion(region) {
+		return gerror.ErrInvalidRegion
+	}
+
+	if auth.GetAccessKey() != a.Admin.accessKey {
+		zlog.ZWarn().Str("AK", auth.GetAccessKey()).Msg("[Admin] not admin key")
+		return gerror.ErrAccessDenied
+	}
+
+	sv4 := sign.NewSignV4(a.Admin.accessKey, a.Admin.secretKey, region, r)
+	if sign.GetRequestAuthType(r) == sign.AuthTypePresigned {
+		return sv4.VerifyURL(time.Now())
+	}
+	return sv4.Verify(time.Now(), authStr)
+}
+
+// allowBackends 检查应用和 Tier、Mirror、Erasure 中所有的后端引擎和地区是否在允许的范围内
+func (a *API) allowBackends(p CreateApplicationConfiguration) bool {
+
+	allow := a.Admin.allow(p.Engine, p.Region)
+
+	if p.Tier != nil {
+		allow = allow && a.Admin.allow(p.Tier.Engine, p.Tier.Region)
+	}
+
+	if p.Mirror != nil {
+		allow = allow && a.Admin.allow(p.Mirror.Engine, p.Mirror.Region)
+	}
+
+	if p.Erasure != nil {
+		for _, b := range p.Erasure.Backends {
+			allow = allow && a.Admin.allow(b.Engine, b.Region)
+		}
+	}
+
+	for _, m := range p.RegionMappings {
+		allow = allow && a.Admin.allow(p.Engine, m.EngineRegion)
+	}
+
+	return allow
+}
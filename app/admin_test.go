@@ -0,0 +1,111 @@
+package app
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/solution9th/S3Adapter/internal/config"
+	"github.com/solution9th/S3Adapter/internal/sign"
+	"github.com/solution9th/S3Adapter/mocks/mock_db"
+
+	"github.com/golang/mock/gomock"
+	"github.com/gorilla/mux"
+	"github.com/smartystreets/goconvey/convey"
+)
+
+// signRequest 使用 ak, sk 以 AWS Sign V4 签名 r
+func signRequest(r *http.Request, ak, sk string) {
+	now := time.Now().UTC()
+	r.Header.Set("X-Amz-Date", now.Format(sign.TimeISO8601BasicFormat))
+	authStr, _ := sign.NewSignV4(ak, sk, GlobalRegion, r).Signature(now)
+	r.Header.Set("Authorization", authStr)
+}
+
+func TestPutApplicationAdmin(t *testing.T) {
+
+	const body = "<CreateApplicationConfiguration><AccessKey>123</AccessKey><SecretKey>123</SecretKey><Engine>s3</Engine><Region>us-east-1</Region><AppName>Test</AppName></CreateApplicationConfiguration>"
+
+	convey.Convey("PutApplication admin", t, func() {
+
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		mockDB := mock_db.NewMockDB(ctrl)
+		a := &API{DB: mockDB, Admin: NewAdmin(config.Admin{
+			AccessKey: "admin", SecretKey: "admin-secret", Engines: []string{"s3"}})}
+
+		r := mux.NewRouter()
+		NewAPIRouter(r, a)
+
+		do := func(body, ak, sk string) *httptest.ResponseRecorder {
+			req := httptest.NewRequest(http.MethodPut, "/", strings.NewReader(body))
+			if ak != "" {
+				signRequest(req, ak, sk)
+			}
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+			return w
+		}
+
+		convey.Convey("success: signed by admin", func() {
+			mockDB.EXPECT().CountInfo("123", "123", "s3").Return(0, nil)
+			mockDB.EXPECT().SaveInfo(gomock.Any()).Return(1, nil)
+
+			w := do(body, "admin", "admin-secret")
+			convey.So(w.Code, convey.ShouldEqual, http.StatusOK)
+			convey.So(w.Body.String(), convey.ShouldContainSubstring, "<CreateApplicationResult")
+		})
+
+		convey.Convey("err: unsigned", func() {
+			w := do(body, "", "")
+			convey.So(w.Code, convey.ShouldEqual, http.StatusForbidden)
+			convey.So(w.Body.String(), convey.ShouldContainSubstring, "AccessDenied")
+		})
+
+		convey.Convey("err: not admin key", func() {
+			w := do(body, "app", "admin-secret")
+			convey.So(w.Code, convey.ShouldEqual, http.StatusForbidden)
+		})
+
+		convey.Convey("err: wrong secret", func() {
+			w := do(body, "admin", "wrong")
+			convey.So(w.Code, convey.ShouldEqual, http.StatusForbidden)
+		})
+
+		convey.Convey("err: engine not allowed", func() {
+			mockDB.EXPECT().CountInfo("123", "123", "cos").Return(0, nil)
+
+			w := do(strings.Replace(body, "<Engine>s3</Engine>", "<Engine>cos</Engine>", 1), "admin", "admin-secret")
+			convey.So(w.Code, convey.ShouldEqual, http.StatusForbidden)
+			convey.So(w.Body.String(), convey.ShouldContainSubstring, "EngineNotAllowed")
+		})
+
+		convey.Convey("err: admin not configured", func() {
+			a.Admin = nil
+
+			w := do(body, "admin", "admin-secret")
+			convey.So(w.Code, convey.ShouldEqual, http.StatusForbidden)
+		})
+	})
+}
+
+func TestAdminAllow(t *testing.T) {
+
+	convey.Convey("allow", t, func() {
+
+		ad := NewAdmin(config.Admin{AccessKey: "a", SecretKey: "b", Regions: []string{"us-east-1"}})
+		convey.So(ad.allow("s3", "us-east-1"), convey.ShouldBeTrue)
+		convey.So(ad.allow("cos", "us-east-1"), convey.ShouldBeTrue)
+		convey.So(ad.allow("s3", "ap-beijing"), convey.ShouldBeFalse)
+
+		a := &API{Admin: ad}
+		p := CreateApplicationConfiguration{Engine: "s3", Region: "us-east-1",
+			Mirror: &MirrorConfiguration{Engine: "cos", Region: "ap-beijing"}}
+		convey.So(a.allowBackends(p), convey.ShouldBeFalse)
+
+		convey.So(NewAdmin(config.Admin{AccessKey: "a"}), convey.ShouldBeNil)
+	})
+}
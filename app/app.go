@@ -1,13 +1,26 @@
 package app
 
 import (
+	"context"
 	"net/http"
 	_ "net/http/pprof"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
+	"github.com/solution9th/S3Adapter/internal"
+	"github.com/solution9th/S3Adapter/internal/accesslog"
+	"github.com/solution9th/S3Adapter/internal/cache"
 	"github.com/solution9th/S3Adapter/internal/config"
 	"github.com/solution9th/S3Adapter/internal/db"
 	"github.com/solution9th/S3Adapter/internal/db/mysql"
+	"github.com/solution9th/S3Adapter/internal/gateway"
+	"github.com/solution9th/S3Adapter/internal/gateway/mirror"
+	"github.com/solution9th/S3Adapter/internal/gateway/tiered"
+	"github.com/solution9th/S3Adapter/internal/metrics"
+	"github.com/solution9th/S3Adapter/internal/secret"
+	"github.com/solution9th/S3Adapter/internal/trace"
 
 	"github.com/gorilla/mux"
 	"github.com/haozibi/zlog"
@@ -16,6 +29,8 @@ import (
 var (
 	// GlobalRegion service Region
 	GlobalRegion = ""
+	// Regions 除 GlobalRegion 外签名可以使用的地区，比如 SDK 默认的 us-east-1
+	Regions []string
 	// EndPointDomain endpoint
 	EndPointDomain = ""
 )
@@ -25,6 +40,7 @@ func Run(cfg config.Config) error {
 
 	EndPointDomain = cfg.Server.EndPoint
 	GlobalRegion = cfg.Server.Region
+	Regions = cfg.Server.Regions
 	pprofPort := cfg.Server.PprofPort
 
 	a, err := NewAPP(cfg)
@@ -33,20 +49,80 @@ func Run(cfg config.Config) error {
 		return err
 	}
 
+	if err := registerPlugins(cfg.Plugins); err != nil {
+		zlog.ZError().Msg("[Init] error:" + err.Error())
+		return err
+	}
+
 	if pprofPort != "" {
+		// pprof 使用 http.DefaultServeMux，指标也放在这个端口，不对外暴露
+		http.Handle("/metrics", metrics.Handler())
 		go func() {
 			zlog.ZDebug().Str("pprof", pprofPort).Msg("[pprof]")
 			http.ListenAndServe(":"+pprofPort, nil)
 		}()
 	}
 
+	if a.Pool != nil {
+		go a.Pool.Run(context.Background())
+	}
+
+	if cfg.Tracing.Endpoint != "" {
+		e := trace.NewExporter(cfg.Tracing.Endpoint, cfg.Tracing.ServiceName, cfg.Tracing.Interval)
+		trace.SetExporter(e)
+		go e.Run(context.Background())
+		zlog.ZInfo().Str("Endpoint", cfg.Tracing.Endpoint).Msg("[Trace]")
+	}
+
+	if cfg.Tiering.Interval > 0 {
+		go tiered.NewMover(a.DB, a.resolveTiered, cfg.Tiering.Interval, cfg.Tiering.Batch).Run(context.Background())
+	}
+
+	if cfg.Mirror.Interval > 0 {
+		go mirror.NewRepairer(a.DB, a.resolveMirror, cfg.Mirror.Interval, cfg.Mirror.Batch).Run(context.Background())
+	}
+
+	if cfg.AccessLog.Path != "" {
+		f, err := accesslog.NewRotatingFile(cfg.AccessLog.Path, cfg.AccessLog.MaxSize<<20, cfg.AccessLog.MaxBackups)
+		if err != nil {
+			zlog.ZError().Msg("[Init] error:" + err.Error())
+			return err
+		}
+		defer f.Close()
+		a.AccessLog = accesslog.New(f)
+		zlog.ZDebug().Str("Path", cfg.AccessLog.Path).Msg("[AccessLog]")
+	}
+
 	httpPort := cfg.Server.HTTPPort
 
 	r := mux.NewRouter()
 	NewAPIRouter(r, a)
 
-	zlog.ZInfo().Str("Port", httpPort).Msg("listen...")
-	err = http.ListenAndServe(":"+httpPort, r)
+	tlsConfig, err := newTLSConfig(cfg.TLS)
+	if err != nil {
+		zlog.ZError().Msg("[Init] error:" + err.Error())
+		return err
+	}
+
+	srv := &http.Server{Addr: ":" + httpPort, Handler: r, TLSConfig: tlsConfig}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		a.shutdown(srv, cfg.Health.ShutdownDelay)
+	}()
+
+	if tlsConfig != nil {
+		zlog.ZInfo().Str("Port", httpPort).Bool("ClientCert", tlsConfig.ClientCAs != nil).Msg("listen https...")
+		err = srv.ListenAndServeTLS(cfg.TLS.CertFile, cfg.TLS.KeyFile)
+	} else {
+		zlog.ZInfo().Str("Port", httpPort).Msg("listen...")
+		err = srv.ListenAndServe()
+	}
+	if err == http.ErrServerClosed {
+		<-done
+		return nil
+	}
 	if err != nil {
 		zlog.ZFatal().Msg(err.Error())
 		return err
@@ -54,8 +130,48 @@ func Run(cfg config.Config) error {
 	return nil
 }
 
+// shutdown 收到退出信号后先报告未就绪，等待 delay 让负载均衡摘除本实例，
+// 再停止接收新请求并等待正在处理的请求结束
+func (a *API) shutdown(srv *http.Server, delay time.Duration) {
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
+	s := <-sig
+	signal.Stop(sig)
+
+	zlog.ZInfo().Str("Signal", s.String()).Str("Delay", delay.String()).Msg("[Shutdown] draining")
+	a.Health.Drain()
+	time.Sleep(delay)
+
+	if err := srv.Shutdown(context.Background()); err != nil {
+		zlog.ZError().Msg("[Shutdown] error:" + err.Error())
+	}
+	zlog.ZInfo().Msg("[Shutdown] done")
+}
+
 type API struct {
 	DB db.DB
+
+	// Pool 后端客户端缓存，为 nil 时每个请求创建新的客户端
+	Pool *internal.Pool
+
+	// Cache 应用信息缓存，为 nil 时每次查询数据库
+	Cache *cache.LRU
+
+	// AccessLog S3 格式的访问日志，为 nil 时不记录
+	AccessLog *accesslog.Logger
+
+	// Health 就绪检查，为 nil 时只检查 MySQL
+	Health *Health
+
+	// Admin 管理凭证，为 nil 时不能创建应用
+	Admin *Admin
+
+	// STS 临时凭证的配置，为 nil 时使用默认配置
+	STS *STS
+
+	// Network 可信代理，为 nil 时不读取 X-Forwarded-For
+	Network *Network
 }
 
 // NewAPP 初始化 APP
@@ -72,7 +188,57 @@ var NewAPP = func(cfg config.Config) (*API, error) {
 		zlog.NewBasicLog(f)
 	}
 
-	a := &API{}
+	gateway.SetTransport(gateway.TransportConfig{
+		MaxIdleConns:          cfg.Client.MaxIdleConns,
+		MaxIdleConnsPerHost:   cfg.Client.MaxIdleConnsPerHost,
+		IdleConnTimeout:       cfg.Client.IdleConnTimeout,
+		DialTimeout:           cfg.Client.DialTimeout,
+		TLSHandshakeTimeout:   cfg.Client.TLSHandshakeTimeout,
+		ResponseHeaderTimeout: cfg.Client.ResponseHeaderTimeout,
+	})
+
+	a := &API{Health: NewHealth(cfg.Health), Admin: NewAdmin(cfg.Admin)}
+	if a.Admin == nil {
+		zlog.ZWarn().Msg("[Admin] admin key is not configured, PutApplication is disabled")
+	}
+
+	sts, err := NewSTS(cfg.STS)
+	if err != nil {
+		return nil, err
+	}
+	a.STS = sts
+
+	if a.Network, err = NewNetwork(cfg.Network); err != nil {
+		return nil, err
+	}
+
+	if cfg.Client.IdleTimeout > 0 {
+		a.Pool = internal.NewPool(cfg.Client.IdleTimeout)
+	}
+
+	if cfg.Cache.Size > 0 {
+		a.Cache = cache.New(cfg.Cache.Size, cfg.Cache.TTL, cfg.Cache.NegativeTTL)
+	}
+
+	c, err := secret.Load(cfg.Encryption.MasterKey, cfg.Encryption.KeyFile)
+	if err != nil {
+		return nil, err
+	}
+	if c == nil {
+		zlog.ZWarn().Msg("[Encryption] master key is not configured, keys are stored in plaintext")
+	}
+
+	d, err := newDB(cfg.MySQL, c)
+	if err != nil {
+		return nil, err
+	}
+	a.DB = db.Instrument(d)
+
+	return a, nil
+}
+
+// newDB 连接数据库并创建表，c 不为 nil 时加密保存 key
+func newDB(cfg config.MySQL, c *secret.Cipher) (db.DB, error) {
 
 	dbType := "mysql"
 
@@ -81,26 +247,49 @@ var NewAPP = func(cfg config.Config) (*API, error) {
 	switch dbType {
 	case "mysql":
 		apiConfig = map[string]interface{}{
-			"dbname":   cfg.MySQL.DBName,
-			"user":     cfg.MySQL.User,
-			"password": cfg.MySQL.Passwd,
-			"host":     cfg.MySQL.Host,
-			"port":     cfg.MySQL.Port,
+			"dbname":   cfg.DBName,
+			"user":     cfg.User,
+			"password": cfg.Passwd,
+			"host":     cfg.Host,
+			"port":     cfg.Port,
 		}
 	}
 
 	tableName := "info"
 
-	a.DB = mysql.NewDB(tableName)
-	err := a.DB.LinkDB(apiConfig)
+	d := mysql.NewDB(tableName, c)
+	err := d.LinkDB(apiConfig)
 	if err != nil {
 		return nil, err
 	}
 
-	err = a.DB.AddTable()
+	err = d.AddTable()
 	if err != nil {
 		return nil, err
 	}
 
-	return a, nil
+	return d, nil
+}
+
+// Rekey 把数据库中的 key 从旧的主密钥加密改为使用配置的主密钥加密，返回修改的行数
+//
+// 旧的主密钥为空时原来是明文，配置的主密钥为空时改为明文保存
+func Rekey(cfg config.Config, oldMasterKey, oldKeyFile string) (int, error) {
+
+	old, err := secret.Load(oldMasterKey, oldKeyFile)
+	if err != nil {
+		return 0, err
+	}
+
+	c, err := secret.Load(cfg.Encryption.MasterKey, cfg.Encryption.KeyFile)
+	if err != nil {
+		return 0, err
+	}
+
+	d, err := newDB(cfg.MySQL, c)
+	if err != nil {
+		return 0, err
+	}
+
+	return d.(*mysql.MySQLFunc).Rekey(old)
 }
@@ -1,6 +1,10 @@
 package app
 
 import (
+	"context"
+
+	"github.com/solution9th/S3Adapter/internal/db"
+	"github.com/solution9th/S3Adapter/internal/gateway/tiered"
 	"github.com/solution9th/S3Adapter/internal/gerror"
 
 	"github.com/haozibi/zlog"
@@ -13,15 +17,92 @@ type CreateApplicationConfiguration struct {
 	Engine    string `xml:"Engine"`
 	AppName   string `xml:"AppName"`
 	AppRemark string `xml:"AppRemark"`
+
+	// Tier 可选的冷存储配置
+	Tier *TierConfiguration `xml:"Tier"`
+
+	// Mirror 可选的镜像引擎配置，不能和 Tier 同时使用
+	Mirror *MirrorConfiguration `xml:"Mirror"`
+
+	// Erasure 可选的纠删码配置，不能和 Tier、Mirror 同时使用
+	Erasure *ErasureConfiguration `xml:"Erasure"`
+
+	// RegionMappings 可选的地区映射，以其他地区签名的请求访问对应的后端地区
+	RegionMappings []RegionMapping `xml:"RegionMapping"`
 }
 
+// deleteInfo 删除应用，oak 和 osk 为应用创建时的 key
 func (a *API) deleteInfo(oak, osk string) error {
 
-	err := a.DB.DeleteInfo(oak, osk)
+	keys, err := a.appKeys(context.Background(), oak)
+	if err != nil {
+		return err
+	}
+
+	err = a.DB.DeleteInfo(oak, osk)
 	if err != nil {
 		zlog.ZError().Str("Method", "deleteInfo").Msg(err.Error())
+		return err
 	}
-	return err
+
+	// 删除应用后不能再使用缓存的应用信息和客户端
+	a.invalidateApp(oak, keys)
+	return nil
+}
+
+// appKeys 应用 id 轮换后的 key、应用下所有用户的 key、没有过期的临时凭证和客户端证书，
+// 都是应用缓存的 key
+func (a *API) appKeys(ctx context.Context, id string) ([]string, error) {
+
+	d := db.WithContext(ctx, a.DB)
+
+	keys, err := d.ListKey(id)
+	if err != nil {
+		zlog.ZError().Str("Method", "listKey").Msg(err.Error())
+		return nil, err
+	}
+
+	users, err := d.ListUser(id)
+	if err != nil {
+		zlog.ZError().Str("Method", "listUser").Msg(err.Error())
+		return nil, err
+	}
+
+	sessions, err := d.ListSession(id)
+	if err != nil {
+		zlog.ZError().Str("Method", "listSession").Msg(err.Error())
+		return nil, err
+	}
+
+	certs, err := d.ListClientCert(id)
+	if err != nil {
+		zlog.ZError().Str("Method", "listClientCert").Msg(err.Error())
+		return nil, err
+	}
+
+	list := make([]string, 0, len(keys)+len(users)+len(sessions)+len(certs))
+	for _, k := range keys {
+		list = append(list, k.AccessKey)
+	}
+	for _, u := range users {
+		list = append(list, u.AccessKey)
+	}
+	for _, s := range sessions {
+		list = append(list, s.AccessKey)
+	}
+	for _, c := range certs {
+		list = append(list, certCachePrefix+c.Identity)
+	}
+	return list, nil
+}
+
+// invalidateApp 删除应用 id 和 keys 的缓存以及已经创建的后端客户端
+func (a *API) invalidateApp(id string, keys []string) {
+	a.Cache.Remove(id)
+	for _, k := range keys {
+		a.Cache.Remove(k)
+	}
+	a.Pool.Invalidate(id)
 }
 
 func (a *API) saveInfo(p CreateApplicationConfiguration) (ak, sk string, errCode gerror.APIErrorCode) {
@@ -40,6 +121,27 @@ func (a *API) saveInfo(p CreateApplicationConfiguration) (ak, sk string, errCode
 		return "", "", gerror.ErrInvalidRequestParameter
 	}
 
+	if p.Tier != nil && !p.Tier.validate() {
+		return "", "", gerror.ErrInvalidRequestParameter
+	}
+
+	if p.Mirror != nil && (p.Tier != nil || !p.Mirror.validate()) {
+		return "", "", gerror.ErrInvalidRequestParameter
+	}
+
+	if p.Erasure != nil && (p.Tier != nil || p.Mirror != nil || !p.Erasure.validate()) {
+		return "", "", gerror.ErrInvalidRequestParameter
+	}
+
+	if !validateRegionMappings(p.RegionMappings) {
+		return "", "", gerror.ErrInvalidRequestParameter
+	}
+
+	if !a.allowBackends(p) {
+		zlog.ZWarn().Str("Engine", p.Engine).Str("Region", p.Region).Msg("[Admin] engine not allowed")
+		return "", "", gerror.ErrEngineNotAllowed
+	}
+
 	ak = genAccessKey()
 	sk = genSecretKey()
 
@@ -60,5 +162,53 @@ func (a *API) saveInfo(p CreateApplicationConfiguration) (ak, sk string, errCode
 		return "", "", gerror.ErrInternalError
 	}
 
+	// 可能缓存了"不存在"
+	a.Cache.Remove(ak)
+
+	if p.Tier != nil {
+		if err = a.saveTier(ak, p.Tier); err != nil {
+			zlog.ZError().Str("Method", "saveTier").Msg(err.Error())
+			a.deleteInfo(ak, sk)
+			return "", "", gerror.ErrInternalError
+		}
+	}
+
+	if p.Mirror != nil {
+		if err = a.saveMirror(ak, p.Mirror); err != nil {
+			zlog.ZError().Str("Method", "saveMirror").Msg(err.Error())
+			a.deleteInfo(ak, sk)
+			return "", "", gerror.ErrInternalError
+		}
+	}
+
+	if p.Erasure != nil {
+		if err = a.saveErasure(ak, p.Erasure); err != nil {
+			zlog.ZError().Str("Method", "saveErasure").Msg(err.Error())
+			a.deleteInfo(ak, sk)
+			return "", "", gerror.ErrInternalError
+		}
+	}
+
+	if err = a.saveRegions(ak, p.RegionMappings); err != nil {
+		zlog.ZError().Str("Method", "saveRegions").Msg(err.Error())
+		a.deleteInfo(ak, sk)
+		return "", "", gerror.ErrInternalError
+	}
+
 	return
 }
+
+func (a *API) saveTier(oak string, t *TierConfiguration) error {
+
+	data := make(map[string]interface{})
+	data["os_access_key"] = oak
+	data["cold_engine"] = t.Engine
+	data["cold_region"] = t.Region
+	data["cold_access_key"] = t.AccessKey
+	data["cold_secret_key"] = t.SecretKey
+	data["cold_storage_class"] = t.StorageClass
+	data["rules"] = tiered.Rules(t.Rules).String()
+
+	_, err := a.DB.SaveTier(data)
+	return err
+}
@@ -0,0 +1,136 @@
+package app
+
+import (
+	"context"
+	"encoding/xml"
+	"net/http"
+
+	"github.com/solution9th/S3Adapter/internal/db"
+	"github.com/solution9th/S3Adapter/internal/gerror"
+	"github.com/solution9th/S3Adapter/internal/reqinfo"
+
+	"github.com/haozibi/zlog"
+)
+
+// UpdateBackendConfiguration 修改应用后端凭证的请求
+type UpdateBackendConfiguration struct {
+	// ApplicationAccessKey 应用任意一个 key
+	ApplicationAccessKey string `xml:"ApplicationAccessKey"`
+
+	// AccessKey, SecretKey 新的后端凭证
+	AccessKey string `xml:"AccessKey"`
+	SecretKey string `xml:"SecretKey"`
+
+	// Region 新的后端地区，为空时不修改
+	Region string `xml:"Region"`
+}
+
+// UpdateBackend 修改应用的后端凭证和地区，应用的 key 不变，需要使用管理 key 签名
+//
+// 请求:
+//
+//	POST /?updateBackend
+//	<UpdateBackendConfiguration>
+//		<ApplicationAccessKey></ApplicationAccessKey>
+//		<AccessKey></AccessKey>
+//		<SecretKey></SecretKey>
+//		<Region></Region>
+//	</UpdateBackendConfiguration>
+func (a *API) UpdateBackend(w http.ResponseWriter, r *http.Request) {
+
+	ctx := newContext(w, r, "UpdateBackend")
+
+	reqinfo.ZDebug(ctx).Str("Method", "UpdateBackend").Msg("[debug]")
+
+	if errCode := a.authAdmin(r); errCode != gerror.ErrNone {
+		writeErrorResponseXML(ctx, w,
+			gerror.GetError(errCode, nil))
+		return
+	}
+
+	var c UpdateBackendConfiguration
+	if r.Body != nil {
+		err := xml.NewDecoder(r.Body).Decode(&c)
+		if err != nil {
+			reqinfo.ZError(ctx).Str("Method", "XMLDecode").Msg(err.Error())
+			writeErrorResponseXML(ctx, w,
+				gerror.GetError(gerror.ErrMalformedXML, err))
+			return
+		}
+	}
+
+	if errCode := a.updateBackend(ctx, c); errCode != gerror.ErrNone {
+		writeErrorResponseXML(ctx, w,
+			gerror.GetError(errCode, nil))
+		return
+	}
+
+	writeSuccessResponseHeadersOnly(w)
+}
+
+// updateBackend 修改应用的 engine_access_key、engine_secret_key 和 engine_region，
+// 修改后删除应用的缓存和已经创建的后端客户端，下次请求使用新的凭证
+func (a *API) updateBackend(ctx context.Context, c UpdateBackendConfiguration) gerror.APIErrorCode {
+
+	if c.ApplicationAccessKey == "" || c.AccessKey == "" || c.SecretKey == "" {
+		return gerror.ErrInvalidRequestParameter
+	}
+
+	m, err := a.lookupApp(ctx, c.ApplicationAccessKey)
+	if err == db.ErrNotFound {
+		return gerror.ErrInvalidAccessKeyID
+	}
+	if err != nil {
+		return gerror.ErrInternalError
+	}
+	id := m.OsAccessKey
+
+	region := c.Region
+	if region == "" {
+		region = m.EngineRegion
+	}
+
+	if !a.Admin.allow(m.EngineType, region) {
+		zlog.ZWarn().Str("Engine", m.EngineType).Str("Region", region).Msg("[Admin] engine not allowed")
+		return gerror.ErrEngineNotAllowed
+	}
+
+	d := db.WithContext(ctx, a.DB)
+
+	// 和创建应用一样，一组后端凭证只能属于一个应用
+	if c.AccessKey != m.EngineAccessKey || c.SecretKey != m.EngineSecretKey {
+		num, err := d.CountInfo(c.AccessKey, c.SecretKey, m.EngineType)
+		if err != nil {
+			zlog.ZError().Str("Method", "countInfo").Msg(err.Error())
+			return gerror.ErrInternalError
+		}
+		if num >= 1 {
+			return gerror.ErrAccessKeyCreated
+		}
+	}
+
+	// 用户的 key 也缓存了后端凭证
+	keys, err := a.appKeys(ctx, id)
+	if err != nil {
+		return gerror.ErrInternalError
+	}
+
+	err = d.UpdateInfo(id, map[string]interface{}{
+		"engine_access_key": c.AccessKey,
+		"engine_secret_key": c.SecretKey,
+		"engine_region":     region,
+	})
+	if err == db.ErrNotFound {
+		return gerror.ErrInvalidAccessKeyID
+	}
+	if err != nil {
+		zlog.ZError().Str("Method", "updateInfo").Msg(err.Error())
+		return gerror.ErrInternalError
+	}
+
+	a.invalidateApp(id, keys)
+
+	zlog.ZInfo().Str("App", id).Str("Engine", m.EngineType).Str("Region", region).Msg("[Admin] update backend")
+
+	return gerror.ErrNone
+}
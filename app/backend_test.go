@@ -0,0 +1,119 @@
+package app
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/solution9th/S3Adapter/internal/cache"
+	"github.com/solution9th/S3Adapter/internal/config"
+	"github.com/solution9th/S3Adapter/internal/db"
+	"github.com/solution9th/S3Adapter/internal/db/mysql"
+	"github.com/solution9th/S3Adapter/internal/gerror"
+	"github.com/solution9th/S3Adapter/mocks/mock_db"
+
+	"github.com/golang/mock/gomock"
+	"github.com/gorilla/mux"
+	"github.com/smartystreets/goconvey/convey"
+)
+
+func TestUpdateBackend(t *testing.T) {
+
+	convey.Convey("updateBackend", t, func() {
+
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		mockDB := mock_db.NewMockDB(ctrl)
+		a := &API{
+			DB:    mockDB,
+			Cache: cache.New(10, 0, 0),
+			Admin: NewAdmin(config.Admin{AccessKey: "admin", SecretKey: "admin-secret", Regions: []string{"r1", "r2"}}),
+		}
+
+		info := mysql.Info{OsAccessKey: "oak", OsScrectKey: "osk", EngineType: "s3",
+			EngineAccessKey: "ak", EngineSecretKey: "sk", EngineRegion: "r1"}
+
+		convey.Convey("success", func() {
+			var data map[string]interface{}
+
+			mockDB.EXPECT().GetInfo("new").Return(info, nil)
+			mockDB.EXPECT().CountInfo("ak2", "sk2", "s3").Return(0, nil)
+			mockDB.EXPECT().ListKey("oak").Return([]db.Key{{OsAccessKey: "oak", AccessKey: "oak"}, {OsAccessKey: "oak", AccessKey: "new"}}, nil)
+			mockDB.EXPECT().ListUser("oak").Return(nil, nil)
+			mockDB.EXPECT().ListSession("oak").Return(nil, nil)
+			mockDB.EXPECT().ListClientCert("oak").Return(nil, nil)
+			mockDB.EXPECT().UpdateInfo("oak", gomock.Any()).Do(func(_ string, d map[string]interface{}) {
+				data = d
+			}).Return(nil)
+
+			a.Cache.Add("new", info)
+
+			errCode := a.updateBackend(context.Background(), UpdateBackendConfiguration{
+				ApplicationAccessKey: "new", AccessKey: "ak2", SecretKey: "sk2"})
+			convey.So(errCode, convey.ShouldEqual, gerror.ErrNone)
+			convey.So(data, convey.ShouldResemble, map[string]interface{}{
+				"engine_access_key": "ak2", "engine_secret_key": "sk2", "engine_region": "r1"})
+
+			_, _, hit := a.Cache.Get("new")
+			convey.So(hit, convey.ShouldBeFalse)
+		})
+
+		convey.Convey("err: region not allowed", func() {
+			mockDB.EXPECT().GetInfo("oak").Return(info, nil)
+
+			errCode := a.updateBackend(context.Background(), UpdateBackendConfiguration{
+				ApplicationAccessKey: "oak", AccessKey: "ak2", SecretKey: "sk2", Region: "r3"})
+			convey.So(errCode, convey.ShouldEqual, gerror.ErrEngineNotAllowed)
+		})
+
+		convey.Convey("err: credentials used by another app", func() {
+			mockDB.EXPECT().GetInfo("oak").Return(info, nil)
+			mockDB.EXPECT().CountInfo("ak2", "sk2", "s3").Return(1, nil)
+
+			errCode := a.updateBackend(context.Background(), UpdateBackendConfiguration{
+				ApplicationAccessKey: "oak", AccessKey: "ak2", SecretKey: "sk2", Region: "r2"})
+			convey.So(errCode, convey.ShouldEqual, gerror.ErrAccessKeyCreated)
+		})
+
+		convey.Convey("err: missing params", func() {
+			errCode := a.updateBackend(context.Background(), UpdateBackendConfiguration{ApplicationAccessKey: "oak"})
+			convey.So(errCode, convey.ShouldEqual, gerror.ErrInvalidRequestParameter)
+		})
+
+		convey.Convey("api", func() {
+			r := mux.NewRouter()
+			NewAPIRouter(r, a)
+
+			body := "<UpdateBackendConfiguration><ApplicationAccessKey>oak</ApplicationAccessKey>" +
+				"<AccessKey>ak</AccessKey><SecretKey>sk</SecretKey><Region>r2</Region></UpdateBackendConfiguration>"
+
+			convey.Convey("success", func() {
+				mockDB.EXPECT().GetInfo("oak").Return(info, nil)
+				mockDB.EXPECT().ListKey("oak").Return(nil, nil)
+				mockDB.EXPECT().ListUser("oak").Return(nil, nil)
+				mockDB.EXPECT().ListSession("oak").Return(nil, nil)
+				mockDB.EXPECT().ListClientCert("oak").Return(nil, nil)
+				mockDB.EXPECT().UpdateInfo("oak", gomock.Any()).Return(nil)
+
+				req := httptest.NewRequest(http.MethodPost, "/?updateBackend", strings.NewReader(body))
+				signRequest(req, "admin", "admin-secret")
+				w := httptest.NewRecorder()
+				r.ServeHTTP(w, req)
+
+				convey.So(w.Code, convey.ShouldEqual, http.StatusOK)
+			})
+
+			convey.Convey("err: not admin", func() {
+				req := httptest.NewRequest(http.MethodPost, "/?updateBackend", strings.NewReader(body))
+				signRequest(req, "oak", "osk")
+				w := httptest.NewRecorder()
+				r.ServeHTTP(w, req)
+
+				convey.So(w.Code, convey.ShouldEqual, http.StatusForbidden)
+			})
+		})
+	})
+}
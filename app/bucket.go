@@ -7,20 +7,21 @@ import (
 	"strconv"
 
 	"github.com/solution9th/S3Adapter/internal/gerror"
+	"github.com/solution9th/S3Adapter/internal/reqinfo"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/gorilla/mux"
-	"github.com/haozibi/zlog"
 )
 
 const (
 	maxObjectList = 1000
 )
 
-// PutApplication 创建应用，换取 key,(暂时没有签名)
+// PutApplication 创建应用，换取 key，需要使用管理 key 以 AWS Sign V4 签名
 //
-// 独有方法，新获得的 key，主要是为了区分 后端存储引擎 和 具体的 key
+// 独有方法，新获得的 key，主要是为了区分 后端存储引擎 和 具体的 key，
+// 配置了允许的引擎和地区时，应用和 Tier、Mirror、Erasure 的后端都需要在允许的范围内
 // 请求:
 // 	<CreateApplicationConfiguration>
 // 		<AccessKey></AccessKey>
@@ -29,8 +30,34 @@ const (
 // 		<Region></Region>
 // 		<AppName></AppName>
 // 		<AppRemark></AppRemark>
+// 		<Tier>
+// 			<Engine></Engine>
+// 			<AccessKey></AccessKey>
+// 			<SecretKey></SecretKey>
+// 			<Region></Region>
+// 			<StorageClass></StorageClass>
+// 			<Rule><Prefix></Prefix><MinSize></MinSize><MinAgeDays></MinAgeDays></Rule>
+// 		</Tier>
+// 		<Mirror>
+// 			<Engine></Engine>
+// 			<AccessKey></AccessKey>
+// 			<SecretKey></SecretKey>
+// 			<Region></Region>
+// 		</Mirror>
+// 		<Erasure>
+// 			<DataShards></DataShards>
+// 			<ParityShards></ParityShards>
+// 			<Backend><Engine></Engine><AccessKey></AccessKey><SecretKey></SecretKey><Region></Region></Backend>
+// 		</Erasure>
 // 	</CreateApplicationConfiguration>
 //
+// Tier 可选，配置后应用的引擎作为热存储，对象按照 Rule 迁移到冷存储
+//
+// Mirror 可选，配置后写入同时作用于应用的引擎和镜像引擎，不能和 Tier 同时使用
+//
+// Erasure 可选，对象编码为 DataShards+ParityShards 个分片，第一个分片保存在应用的引擎，
+// 其余分片依次保存在 Backend 中，不能和 Tier、Mirror 同时使用
+//
 // 响应:
 // 	<?xml version="1.0" encoding="UTF-8"?>
 // 	<CreateApplicationResult xmlns="http://s3.amazonaws.com/doc/2006-03-01/">
@@ -41,13 +68,19 @@ func (a *API) PutApplication(w http.ResponseWriter, r *http.Request) {
 
 	ctx := newContext(w, r, "PutApplication")
 
-	zlog.ZDebug().Str("Method", "PutApplication").Msg("[debug]")
+	reqinfo.ZDebug(ctx).Str("Method", "PutApplication").Msg("[debug]")
+
+	if errCode := a.authAdmin(r); errCode != gerror.ErrNone {
+		writeErrorResponseXML(ctx, w,
+			gerror.GetError(errCode, nil))
+		return
+	}
 
 	var configLocation CreateApplicationConfiguration
 	if r.Body != nil {
 		err := xml.NewDecoder(r.Body).Decode(&configLocation)
 		if err != nil {
-			zlog.ZError().Str("Method", "XMLDecode").Msg(err.Error())
+			reqinfo.ZError(ctx).Str("Method", "XMLDecode").Msg(err.Error())
 			writeErrorResponseXML(ctx, w,
 				gerror.GetError(gerror.ErrMalformedXML, err))
 			return
@@ -80,7 +113,7 @@ func (a *API) DeleteApplication(w http.ResponseWriter, r *http.Request) {
 
 	ctx := newContext(w, r, "DeleteApplication")
 
-	zlog.ZDebug().Str("Method", "DeleteApplication").Msg("[debug]")
+	reqinfo.ZDebug(ctx).Str("Method", "DeleteApplication").Msg("[debug]")
 
 	if errCode := a.Auth(r); errCode != gerror.ErrNone {
 		if errCode == gerror.ErrRequestTimeTooSkewed {
@@ -99,7 +132,11 @@ func (a *API) DeleteApplication(w http.ResponseWriter, r *http.Request) {
 	// 	return
 	// }
 
-	err := a.deleteInfo(auth.oak, auth.osk)
+	// 使用轮换后的 key 时需要应用创建时的 key 删除
+	m, err := a.getInfo(ctx, auth.id)
+	if err == nil {
+		err = a.deleteInfo(auth.id, m.OsScrectKey)
+	}
 	if err != nil {
 		writeErrorResponseXML(ctx, w,
 			gerror.GetError(gerror.ErrServerNotInitialized, nil))
@@ -129,7 +166,7 @@ func (a *API) HeadBucket(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	bucket := vars["bucket"]
 
-	zlog.ZDebug().Str("Bucket", bucket).Str("Method", "HeadBucket").Msg("[debug]")
+	reqinfo.ZDebug(ctx).Str("Bucket", bucket).Str("Method", "HeadBucket").Msg("[debug]")
 
 	if errCode := a.Auth(r); errCode != gerror.ErrNone {
 		if errCode == gerror.ErrRequestTimeTooSkewed {
@@ -181,7 +218,7 @@ func (a *API) GetBucketV2(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	bucket := vars["bucket"]
 
-	zlog.ZDebug().Str("Bucket", bucket).Str("Method", "GetBucketV2").Msg("[debug]")
+	reqinfo.ZDebug(ctx).Str("Bucket", bucket).Str("Method", "GetBucketV2").Msg("[debug]")
 
 	if errCode := a.Auth(r); errCode != gerror.ErrNone {
 		if errCode == gerror.ErrRequestTimeTooSkewed {
@@ -280,7 +317,7 @@ func (a *API) GetBucketV1(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	bucket := vars["bucket"]
 
-	zlog.ZDebug().Str("Bucket", bucket).Str("Method", "GetBucketV1").Msg("[debug]")
+	reqinfo.ZDebug(ctx).Str("Bucket", bucket).Str("Method", "GetBucketV1").Msg("[debug]")
 
 	if errCode := a.Auth(r); errCode != gerror.ErrNone {
 		if errCode == gerror.ErrRequestTimeTooSkewed {
@@ -366,7 +403,7 @@ func (a *API) PutBucket(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	bucket := vars["bucket"]
 
-	zlog.ZDebug().Str("Bucket", bucket).Str("Method", "PutBucket").Msg("[debug]")
+	reqinfo.ZDebug(ctx).Str("Bucket", bucket).Str("Method", "PutBucket").Msg("[debug]")
 
 	if errCode := a.Auth(r); errCode != gerror.ErrNone {
 		if errCode == gerror.ErrRequestTimeTooSkewed {
@@ -394,7 +431,7 @@ func (a *API) PutBucket(w http.ResponseWriter, r *http.Request) {
 		err := xml.NewDecoder(r.Body).Decode(&configLocation)
 		if err != nil {
 			if err != io.EOF {
-				zlog.ZError().Err(err).Msg("[xml]")
+				reqinfo.ZError(ctx).Err(err).Msg("[xml]")
 				writeErrorResponseXML(ctx, w,
 					gerror.GetError(gerror.ErrMalformedXML, err))
 				return
@@ -451,7 +488,7 @@ func (a *API) DeleteBucket(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	bucket := vars["bucket"]
 
-	zlog.ZDebug().Str("Bucket", bucket).Str("Method", "DeleteBucket").Msg("[debug]")
+	reqinfo.ZDebug(ctx).Str("Bucket", bucket).Str("Method", "DeleteBucket").Msg("[debug]")
 
 	if errCode := a.Auth(r); errCode != gerror.ErrNone {
 		if errCode == gerror.ErrRequestTimeTooSkewed {
@@ -491,7 +528,7 @@ func (a *API) ListBuckets(w http.ResponseWriter, r *http.Request) {
 
 	ctx := newContext(w, r, "ListBuckets")
 
-	zlog.ZDebug().Str("Method", "ListBuckets").Msg("[debug]")
+	reqinfo.ZDebug(ctx).Str("Method", "ListBuckets").Msg("[debug]")
 
 	if errCode := a.Auth(r); errCode != gerror.ErrNone {
 		if errCode == gerror.ErrRequestTimeTooSkewed {
@@ -5,9 +5,11 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"reflect"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/solution9th/S3Adapter/internal/config"
 	"github.com/solution9th/S3Adapter/internal/db"
 	"github.com/solution9th/S3Adapter/internal/db/mysql"
 	"github.com/solution9th/S3Adapter/internal/gateway"
@@ -41,44 +43,71 @@ func newExpect(t *testing.T, db db.DB) *httpexpect.Expect {
 
 func TestPutApplication(t *testing.T) {
 
+	const body = "<CreateApplicationConfiguration><AccessKey>123</AccessKey><SecretKey>123</SecretKey><Engine>s3</Engine><Region>us-east-1</Region><AppName>Test</AppName><AppRemark>Test</AppRemark></CreateApplicationConfiguration>"
+
 	convey.Convey("PutApplication", t, func() {
 
 		ctrl := gomock.NewController(t)
 		defer ctrl.Finish()
 
+		// 创建应用需要使用管理 key 签名
+		put := func(mockDB db.DB, body, ak, sk string) *httptest.ResponseRecorder {
+			r := mux.NewRouter()
+			NewAPIRouter(r, &API{DB: mockDB, Admin: NewAdmin(config.Admin{AccessKey: "admin", SecretKey: "admin-secret"})})
+
+			req := httptest.NewRequest(http.MethodPut, "/", strings.NewReader(body))
+			if ak != "" {
+				signRequest(req, ak, sk)
+			}
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+			return w
+		}
+
 		convey.Convey("success: all success", func() {
 
 			mockDB := mock_db.NewMockDB(ctrl)
 			mockDB.EXPECT().CountInfo(gomock.Any(), gomock.Any(), gomock.Any()).Return(0, nil)
 			mockDB.EXPECT().SaveInfo(gomock.Any()).Return(1, nil)
 
-			e := newExpect(t, mockDB)
+			w := put(mockDB, body, "admin", "admin-secret")
+			convey.So(w.Code, convey.ShouldEqual, http.StatusOK)
+		})
 
-			e.PUT("/").WithBytes([]byte("<CreateApplicationConfiguration><AccessKey>123</AccessKey><SecretKey>123</SecretKey><Engine>s3</Engine><AppName>Test</AppName><AppRemark>Test</AppRemark></CreateApplicationConfiguration>")).Expect().Status(200)
+		convey.Convey("err: unsigned", func() {
+			w := put(mock_db.NewMockDB(ctrl), body, "", "")
+			convey.So(w.Code, convey.ShouldEqual, http.StatusForbidden)
 		})
 
-		convey.Convey("err: xml decode", func() {
-			e := newExpect(t, nil)
+		convey.Convey("err: wrongly signed", func() {
+			// 签名错误，或者使用应用的 key 签名
+			w := put(mock_db.NewMockDB(ctrl), body, "admin", "wrong-secret")
+			convey.So(w.Code, convey.ShouldEqual, http.StatusForbidden)
 
-			e.PUT("/").WithBytes([]byte("<>")).Expect().Status(http.StatusBadRequest)
+			w = put(mock_db.NewMockDB(ctrl), body, "oak", "osk")
+			convey.So(w.Code, convey.ShouldEqual, http.StatusForbidden)
+		})
+
+		convey.Convey("err: xml decode", func() {
+			w := put(nil, "<>", "admin", "admin-secret")
+			convey.So(w.Code, convey.ShouldEqual, http.StatusBadRequest)
 		})
 
 		convey.Convey("err: saveInfo db count info", func() {
 			mockDB := mock_db.NewMockDB(ctrl)
 			mockDB.EXPECT().CountInfo(gomock.Any(), gomock.Any(), gomock.Any()).Return(0, fmt.Errorf("db: count info error"))
 
-			e := newExpect(t, mockDB)
-
-			e.PUT("/").WithBytes([]byte("<CreateApplicationConfiguration><AccessKey>123</AccessKey><SecretKey>123</SecretKey><Engine>s3</Engine><AppName>Test</AppName><AppRemark>Test</AppRemark></CreateApplicationConfiguration>")).Expect().Status(http.StatusServiceUnavailable)
+			w := put(mockDB, body, "admin", "admin-secret")
+			convey.So(w.Code, convey.ShouldEqual, http.StatusInternalServerError)
 		})
 
 		convey.Convey("err: saveInfo num > 1", func() {
 			mockDB := mock_db.NewMockDB(ctrl)
 			mockDB.EXPECT().CountInfo(gomock.Any(), gomock.Any(), gomock.Any()).Return(1, nil)
 
-			e := newExpect(t, mockDB)
-
-			e.PUT("/").WithBytes([]byte("<CreateApplicationConfiguration><AccessKey>123</AccessKey><SecretKey>123</SecretKey><Engine>s3</Engine><AppName>Test</AppName><AppRemark>Test</AppRemark></CreateApplicationConfiguration>")).Expect().Status(http.StatusServiceUnavailable)
+			w := put(mockDB, body, "admin", "admin-secret")
+			convey.So(w.Code, convey.ShouldEqual, http.StatusBadRequest)
+			convey.So(w.Body.String(), convey.ShouldContainSubstring, "ErrAccessKeyCreated")
 		})
 
 		convey.Convey("err: saveInfo db saveInfo", func() {
@@ -86,9 +115,8 @@ func TestPutApplication(t *testing.T) {
 			mockDB.EXPECT().CountInfo(gomock.Any(), gomock.Any(), gomock.Any()).Return(0, nil)
 			mockDB.EXPECT().SaveInfo(gomock.Any()).Return(0, fmt.Errorf("db: save info error"))
 
-			e := newExpect(t, mockDB)
-
-			e.PUT("/").WithBytes([]byte("<CreateApplicationConfiguration><AccessKey>123</AccessKey><SecretKey>123</SecretKey><Engine>s3</Engine><AppName>Test</AppName><AppRemark>Test</AppRemark></CreateApplicationConfiguration>")).Expect().Status(http.StatusServiceUnavailable)
+			w := put(mockDB, body, "admin", "admin-secret")
+			convey.So(w.Code, convey.ShouldEqual, http.StatusInternalServerError)
 		})
 
 	})
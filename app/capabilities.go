@@ -0,0 +1,52 @@
+package app
+
+import (
+	"net/http"
+
+	"github.com/solution9th/S3Adapter/internal/gateway"
+	"github.com/solution9th/S3Adapter/internal/gerror"
+	"github.com/solution9th/S3Adapter/internal/reqinfo"
+)
+
+// CapabilitiesResult GET /?capabilities 的响应
+type CapabilitiesResult struct {
+	Engine     string   `xml:"Engine"`
+	Operations []string `xml:"Operation"`
+	Features   []string `xml:"Feature"`
+}
+
+// GetCapabilities 返回应用的引擎支持的方法和特性
+//
+// 配置了分层、镜像或者纠删码时，返回组合之后的能力
+func (a *API) GetCapabilities(w http.ResponseWriter, r *http.Request) {
+
+	ctx := newContext(w, r, "GetCapabilities")
+
+	reqinfo.ZDebug(ctx).Str("Method", "GetCapabilities").Msg("[debug]")
+
+	if errCode := a.Auth(r); errCode != gerror.ErrNone {
+		if errCode == gerror.ErrRequestTimeTooSkewed {
+			writeErrorRequestTimeTooSkewed(ctx, w, r)
+			return
+		}
+		writeErrorResponseXML(ctx, w,
+			gerror.GetError(errCode, nil))
+		return
+	}
+
+	info := a.getAuthorizationInfo(r)
+	gProto := a.GetGateway(r)
+	if info == nil || gProto == nil {
+		writeErrorResponseXML(ctx, w,
+			gerror.GetError(gerror.ErrServerNotInitialized, nil))
+		return
+	}
+
+	caps := gateway.CapabilitiesOf(gProto)
+
+	formatWriteXML(w, http.StatusOK, "CapabilitiesResult", CapabilitiesResult{
+		Engine:     info.engine,
+		Operations: caps.Operations,
+		Features:   caps.Features,
+	}, true)
+}
@@ -0,0 +1,378 @@
+package app
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/xml"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/solution9th/S3Adapter/internal/config"
+	"github.com/solution9th/S3Adapter/internal/db"
+	"github.com/solution9th/S3Adapter/internal/db/mysql"
+	"github.com/solution9th/S3Adapter/internal/gerror"
+	"github.com/solution9th/S3Adapter/internal/reqinfo"
+
+	"github.com/haozibi/zlog"
+)
+
+const (
+	// certCachePrefix 客户端证书在应用缓存中的 key 前缀，不会和 AccessKey 冲突
+	certCachePrefix = "x509:"
+
+	// maxCertIdentities 一个证书最多查找的 identity 数量
+	maxCertIdentities = 32
+
+	maxIdentityLength = 255
+)
+
+// identityPrefixes 客户端证书映射支持的 identity 类型
+var identityPrefixes = []string{"uri:", "dns:", "email:", "ip:", "subject:"}
+
+// newTLSConfig 创建 HTTPS 配置，没有配置证书时返回 nil，使用 HTTP
+func newTLSConfig(cfg config.TLS) (*tls.Config, error) {
+
+	if cfg.CertFile == "" || cfg.KeyFile == "" {
+		if cfg.ClientCAFile != "" || cfg.RequireClientCert {
+			return nil, errors.New("tls: client certificate requires certfile and keyfile")
+		}
+		return nil, nil
+	}
+
+	c := &tls.Config{MinVersion: tls.VersionTLS12}
+
+	if cfg.ClientCAFile == "" {
+		if cfg.RequireClientCert {
+			return nil, errors.New("tls: requireclientcert requires clientcafile")
+		}
+		return c, nil
+	}
+
+	pem, err := ioutil.ReadFile(cfg.ClientCAFile)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, errors.New("tls: no certificate in clientcafile")
+	}
+
+	c.ClientCAs = pool
+	c.ClientAuth = tls.VerifyClientCertIfGiven
+	if cfg.RequireClientCert {
+		c.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return c, nil
+}
+
+// certIdentities 证书的 identity，按 URI、DNS、Email、IP SAN 和 subject 的顺序
+func certIdentities(c *x509.Certificate) []string {
+
+	var ids []string
+	for _, u := range c.URIs {
+		ids = append(ids, "uri:"+u.String())
+	}
+	for _, name := range c.DNSNames {
+		ids = append(ids, "dns:"+strings.ToLower(name))
+	}
+	for _, email := range c.EmailAddresses {
+		ids = append(ids, "email:"+email)
+	}
+	for _, ip := range c.IPAddresses {
+		ids = append(ids, "ip:"+ip.String())
+	}
+	ids = append(ids, "subject:"+c.Subject.String())
+
+	if len(ids) > maxCertIdentities {
+		ids = ids[:maxCertIdentities]
+	}
+	return ids
+}
+
+// normalizeIdentity 检查 identity 的类型，dns 转换为小写
+func normalizeIdentity(id string) (string, bool) {
+
+	if len(id) > maxIdentityLength {
+		return "", false
+	}
+	for _, prefix := range identityPrefixes {
+		if strings.HasPrefix(id, prefix) && len(id) > len(prefix) {
+			if prefix == "dns:" {
+				id = strings.ToLower(id)
+			}
+			return id, true
+		}
+	}
+	return "", false
+}
+
+// getCertInfo 验证通过的客户端证书映射到的应用，没有证书或者没有映射时返回 nil
+func (a *API) getCertInfo(r *http.Request) *authInfo {
+
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		return nil
+	}
+
+	identity, m, err := a.getCertApp(r.Context(), certIdentities(r.TLS.VerifiedChains[0][0]))
+	if err != nil {
+		if err != db.ErrNotFound {
+			zlog.ZError().Msg("[Cert] error: " + err.Error())
+		}
+		return nil
+	}
+
+	info := newAuthInfo(m, m.OsAccessKey)
+	info.identity = identity
+	setLabels(r, info)
+	return info
+}
+
+// getCertApp 按顺序查找第一个有映射的 identity 和对应的应用，每个 identity 的结果单独缓存，
+// 遇到没有缓存的 identity 时查询数据库
+func (a *API) getCertApp(ctx context.Context, ids []string) (string, mysql.Info, error) {
+
+	cached := true
+	for _, id := range ids {
+		v, negative, hit := a.Cache.Get(certCachePrefix + id)
+		if !hit {
+			cached = false
+			break
+		}
+		if !negative {
+			return id, v.(mysql.Info), nil
+		}
+	}
+	if cached {
+		return "", mysql.Info{}, db.ErrNotFound
+	}
+
+	certs, err := db.WithContext(ctx, a.DB).GetClientCert(ids)
+	if err != nil {
+		return "", mysql.Info{}, err
+	}
+
+	apps := make(map[string]string, len(certs))
+	for _, c := range certs {
+		apps[c.Identity] = c.OsAccessKey
+	}
+
+	for _, id := range ids {
+		oak, ok := apps[id]
+		if !ok {
+			a.Cache.AddNegative(certCachePrefix + id)
+			continue
+		}
+
+		m, err := a.getInfo(ctx, oak)
+		if err != nil {
+			return "", mysql.Info{}, err
+		}
+		a.Cache.Add(certCachePrefix+id, m)
+		return id, m, nil
+	}
+	return "", mysql.Info{}, db.ErrNotFound
+}
+
+// ClientCertConfiguration 把客户端证书映射到应用的请求
+type ClientCertConfiguration struct {
+	// ApplicationAccessKey 应用任意一个 key
+	ApplicationAccessKey string `xml:"ApplicationAccessKey"`
+
+	// Identity 证书的 SAN 或者 subject，比如 uri:spiffe://example.org/svc-a
+	Identity string `xml:"Identity"`
+}
+
+// ListClientCertsResult 应用所有的客户端证书映射
+type ListClientCertsResult struct {
+	ClientCerts []ClientCertInfo `xml:"ClientCert"`
+}
+
+// ClientCertInfo 一个客户端证书映射
+type ClientCertInfo struct {
+	Identity   string    `xml:"Identity"`
+	CreateTime time.Time `xml:"CreateTime"`
+}
+
+// CreateClientCert 把客户端证书映射到应用，使用这个证书的请求不需要签名，可以访问应用所有的 bucket，但是不能删除应用，
+// 需要使用管理 key 签名
+//
+// 请求:
+//
+//	POST /?createClientCert
+//	<ClientCertConfiguration>
+//		<ApplicationAccessKey></ApplicationAccessKey>
+//		<Identity></Identity>
+//	</ClientCertConfiguration>
+func (a *API) CreateClientCert(w http.ResponseWriter, r *http.Request) {
+
+	ctx := newContext(w, r, "CreateClientCert")
+
+	reqinfo.ZDebug(ctx).Str("Method", "CreateClientCert").Msg("[debug]")
+
+	if errCode := a.authAdmin(r); errCode != gerror.ErrNone {
+		writeErrorResponseXML(ctx, w,
+			gerror.GetError(errCode, nil))
+		return
+	}
+
+	var c ClientCertConfiguration
+	if r.Body != nil {
+		err := xml.NewDecoder(r.Body).Decode(&c)
+		if err != nil {
+			reqinfo.ZError(ctx).Str("Method", "XMLDecode").Msg(err.Error())
+			writeErrorResponseXML(ctx, w,
+				gerror.GetError(gerror.ErrMalformedXML, err))
+			return
+		}
+	}
+
+	if errCode := a.createClientCert(ctx, c); errCode != gerror.ErrNone {
+		writeErrorResponseXML(ctx, w,
+			gerror.GetError(errCode, nil))
+		return
+	}
+
+	writeSuccessResponseHeadersOnly(w)
+}
+
+// ListClientCerts 列出应用所有的客户端证书映射，需要使用管理 key 签名
+//
+// 请求:
+//
+//	GET /?clientCerts&accessKey=<应用任意一个 key>
+func (a *API) ListClientCerts(w http.ResponseWriter, r *http.Request) {
+
+	ctx := newContext(w, r, "ListClientCerts")
+
+	reqinfo.ZDebug(ctx).Str("Method", "ListClientCerts").Msg("[debug]")
+
+	if errCode := a.authAdmin(r); errCode != gerror.ErrNone {
+		writeErrorResponseXML(ctx, w,
+			gerror.GetError(errCode, nil))
+		return
+	}
+
+	id, errCode := a.lookupAppID(ctx, r.URL.Query().Get("accessKey"))
+	if errCode != gerror.ErrNone {
+		writeErrorResponseXML(ctx, w,
+			gerror.GetError(errCode, nil))
+		return
+	}
+
+	certs, err := db.WithContext(ctx, a.DB).ListClientCert(id)
+	if err != nil {
+		reqinfo.ZError(ctx).Str("Method", "ListClientCert").Msg(err.Error())
+		writeErrorResponseXML(ctx, w,
+			gerror.GetError(gerror.ErrInternalError, nil))
+		return
+	}
+
+	var result ListClientCertsResult
+	for _, c := range certs {
+		result.ClientCerts = append(result.ClientCerts, ClientCertInfo{
+			Identity:   c.Identity,
+			CreateTime: c.CreateTime,
+		})
+	}
+
+	formatWriteXML(w, http.StatusOK, "", result, true)
+}
+
+// DeleteClientCert 删除应用的客户端证书映射，需要使用管理 key 签名
+//
+// 请求:
+//
+//	DELETE /?clientCert&accessKey=<应用任意一个 key>&identity=<identity>
+func (a *API) DeleteClientCert(w http.ResponseWriter, r *http.Request) {
+
+	ctx := newContext(w, r, "DeleteClientCert")
+
+	reqinfo.ZDebug(ctx).Str("Method", "DeleteClientCert").Msg("[debug]")
+
+	if errCode := a.authAdmin(r); errCode != gerror.ErrNone {
+		writeErrorResponseXML(ctx, w,
+			gerror.GetError(errCode, nil))
+		return
+	}
+
+	errCode := a.deleteClientCert(ctx, r.URL.Query().Get("accessKey"), r.URL.Query().Get("identity"))
+	if errCode != gerror.ErrNone {
+		writeErrorResponseXML(ctx, w,
+			gerror.GetError(errCode, nil))
+		return
+	}
+
+	writeSuccessResponseHeadersOnly(w)
+}
+
+func (a *API) createClientCert(ctx context.Context, c ClientCertConfiguration) gerror.APIErrorCode {
+
+	identity, ok := normalizeIdentity(c.Identity)
+	if !ok {
+		return gerror.ErrInvalidRequestParameter
+	}
+
+	id, errCode := a.lookupAppID(ctx, c.ApplicationAccessKey)
+	if errCode != gerror.ErrNone {
+		return errCode
+	}
+
+	d := db.WithContext(ctx, a.DB)
+
+	certs, err := d.GetClientCert([]string{identity})
+	if err != nil {
+		zlog.ZError().Str("Method", "getClientCert").Msg(err.Error())
+		return gerror.ErrInternalError
+	}
+	if len(certs) > 0 {
+		return gerror.ErrClientCertAlreadyExists
+	}
+
+	_, err = d.SaveClientCert(map[string]interface{}{
+		"os_access_key": id,
+		"identity":      identity,
+	})
+	if err != nil {
+		zlog.ZError().Str("Method", "saveClientCert").Msg(err.Error())
+		return gerror.ErrInternalError
+	}
+
+	// 可能缓存了"不存在"
+	a.Cache.Remove(certCachePrefix + identity)
+
+	zlog.ZInfo().Str("App", id).Str("Identity", identity).Msg("[Admin] create client cert")
+
+	return gerror.ErrNone
+}
+
+func (a *API) deleteClientCert(ctx context.Context, oak, identity string) gerror.APIErrorCode {
+
+	identity, ok := normalizeIdentity(identity)
+	if !ok {
+		return gerror.ErrInvalidRequestParameter
+	}
+
+	id, errCode := a.lookupAppID(ctx, oak)
+	if errCode != gerror.ErrNone {
+		return errCode
+	}
+
+	err := db.WithContext(ctx, a.DB).DeleteClientCert(id, identity)
+	if err == db.ErrNotFound {
+		return gerror.ErrNoSuchClientCert
+	}
+	if err != nil {
+		zlog.ZError().Str("Method", "deleteClientCert").Msg(err.Error())
+		return gerror.ErrInternalError
+	}
+
+	a.Cache.Remove(certCachePrefix + identity)
+
+	zlog.ZInfo().Str("App", id).Str("Identity", identity).Msg("[Admin] delete client cert")
+
+	return gerror.ErrNone
+}
@@ -0,0 +1,286 @@
+package app
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/solution9th/S3Adapter/internal/cache"
+	"github.com/solution9th/S3Adapter/internal/config"
+	"github.com/solution9th/S3Adapter/internal/db"
+	"github.com/solution9th/S3Adapter/internal/db/mysql"
+	"github.com/solution9th/S3Adapter/internal/gerror"
+	"github.com/solution9th/S3Adapter/internal/reqinfo"
+	"github.com/solution9th/S3Adapter/mocks/mock_db"
+
+	"github.com/golang/mock/gomock"
+	"github.com/gorilla/mux"
+	"github.com/smartystreets/goconvey/convey"
+)
+
+// testClientCert 生成 CA 签发的客户端证书，返回 CA 的 PEM 和客户端证书
+func testClientCert() ([]byte, *x509.Certificate) {
+
+	caKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test-ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	caDER, _ := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	ca, _ := x509.ParseCertificate(caDER)
+
+	u, _ := url.Parse("spiffe://example.org/svc-a")
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	template := &x509.Certificate{
+		SerialNumber:   big.NewInt(2),
+		Subject:        pkix.Name{CommonName: "svc-a", Organization: []string{"Example"}},
+		NotBefore:      time.Now().Add(-time.Hour),
+		NotAfter:       time.Now().Add(time.Hour),
+		KeyUsage:       x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:    []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		URIs:           []*url.URL{u},
+		DNSNames:       []string{"SVC-A.example.org"},
+		EmailAddresses: []string{"svc-a@example.org"},
+		IPAddresses:    []net.IP{net.ParseIP("10.0.0.5")},
+	}
+	der, _ := x509.CreateCertificate(rand.Reader, template, ca, &key.PublicKey, caKey)
+	leaf, _ := x509.ParseCertificate(der)
+
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caDER}), leaf
+}
+
+func TestCertIdentities(t *testing.T) {
+
+	convey.Convey("certIdentities", t, func() {
+
+		_, leaf := testClientCert()
+		convey.So(certIdentities(leaf), convey.ShouldResemble, []string{
+			"uri:spiffe://example.org/svc-a",
+			"dns:svc-a.example.org",
+			"email:svc-a@example.org",
+			"ip:10.0.0.5",
+			"subject:CN=svc-a,O=Example",
+		})
+
+		id, ok := normalizeIdentity("dns:SVC-A.example.org")
+		convey.So(ok, convey.ShouldBeTrue)
+		convey.So(id, convey.ShouldEqual, "dns:svc-a.example.org")
+
+		for _, id := range []string{"", "dns:", "cn:svc-a", "subject:" + strings.Repeat("a", 250)} {
+			_, ok = normalizeIdentity(id)
+			convey.So(ok, convey.ShouldBeFalse)
+		}
+	})
+}
+
+func TestNewTLSConfig(t *testing.T) {
+
+	convey.Convey("newTLSConfig", t, func() {
+
+		c, err := newTLSConfig(config.TLS{})
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(c, convey.ShouldBeNil)
+
+		_, err = newTLSConfig(config.TLS{ClientCAFile: "ca.crt"})
+		convey.So(err, convey.ShouldNotBeNil)
+
+		c, err = newTLSConfig(config.TLS{CertFile: "server.crt", KeyFile: "server.key"})
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(c.ClientAuth, convey.ShouldEqual, tls.NoClientCert)
+
+		_, err = newTLSConfig(config.TLS{CertFile: "server.crt", KeyFile: "server.key", RequireClientCert: true})
+		convey.So(err, convey.ShouldNotBeNil)
+
+		dir, _ := ioutil.TempDir("", "s3adapter-tls")
+		defer os.RemoveAll(dir)
+
+		caPEM, _ := testClientCert()
+		caFile := filepath.Join(dir, "ca.crt")
+		ioutil.WriteFile(caFile, caPEM, 0600)
+
+		c, err = newTLSConfig(config.TLS{CertFile: "server.crt", KeyFile: "server.key", ClientCAFile: caFile})
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(c.ClientAuth, convey.ShouldEqual, tls.VerifyClientCertIfGiven)
+		convey.So(c.ClientCAs, convey.ShouldNotBeNil)
+
+		c, err = newTLSConfig(config.TLS{CertFile: "server.crt", KeyFile: "server.key", ClientCAFile: caFile, RequireClientCert: true})
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(c.ClientAuth, convey.ShouldEqual, tls.RequireAndVerifyClientCert)
+
+		badFile := filepath.Join(dir, "bad.crt")
+		ioutil.WriteFile(badFile, []byte("not a certificate"), 0600)
+		_, err = newTLSConfig(config.TLS{CertFile: "server.crt", KeyFile: "server.key", ClientCAFile: badFile})
+		convey.So(err, convey.ShouldNotBeNil)
+	})
+}
+
+func TestClientCertAuth(t *testing.T) {
+
+	convey.Convey("client cert", t, func() {
+
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		mockDB := mock_db.NewMockDB(ctrl)
+		a := &API{DB: mockDB, Cache: cache.New(10, time.Minute, time.Minute),
+			Admin: NewAdmin(config.Admin{AccessKey: "admin", SecretKey: "admin-secret"})}
+
+		app := mysql.Info{OsAccessKey: "oak", OsScrectKey: "osk", EngineType: "s3", EngineAccessKey: "ak", EngineSecretKey: "sk", AppName: "photos"}
+		mockDB.EXPECT().GetInfo("oak").Return(app, nil).AnyTimes()
+
+		_, leaf := testClientCert()
+		ids := certIdentities(leaf)
+
+		withCert := func(req *http.Request, verified bool) *http.Request {
+			req.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{leaf}}
+			if verified {
+				req.TLS.VerifiedChains = [][]*x509.Certificate{{leaf}}
+			}
+			return req
+		}
+
+		request := func(verified bool) *http.Request {
+			req := httptest.NewRequest(http.MethodGet, "/photos/1.jpg", nil)
+			req = mux.SetURLVars(req, map[string]string{"bucket": "photos", "object": "1.jpg"})
+			req = req.WithContext(reqinfo.NewContext(req.Context(), &reqinfo.ReqInfo{API: "GetObject"}))
+			return withCert(req, verified)
+		}
+
+		convey.Convey("Auth", func() {
+			// 没有 TLS 或者证书没有验证
+			convey.So(a.Auth(httptest.NewRequest(http.MethodGet, "/photos/1.jpg", nil)), convey.ShouldEqual, gerror.ErrAllAccessDisabled)
+			convey.So(a.Auth(request(false)), convey.ShouldEqual, gerror.ErrAllAccessDisabled)
+
+			// 第一个有映射的 identity，结果缓存
+			mockDB.EXPECT().GetClientCert(ids).Return([]db.ClientCert{
+				{OsAccessKey: "oak", Identity: "subject:CN=svc-a,O=Example"},
+				{OsAccessKey: "oak", Identity: "dns:svc-a.example.org"},
+			}, nil).Times(1)
+
+			convey.So(a.Auth(request(true)), convey.ShouldEqual, gerror.ErrNone)
+			convey.So(a.Auth(request(true)), convey.ShouldEqual, gerror.ErrNone)
+
+			info := a.getCertInfo(request(true))
+			convey.So(info.oak, convey.ShouldEqual, "oak")
+			convey.So(info.app, convey.ShouldEqual, "photos")
+			convey.So(info.identity, convey.ShouldEqual, "dns:svc-a.example.org")
+
+			// 客户端证书不能删除应用，也不能调用管理接口
+			r := mux.NewRouter()
+			NewAPIRouter(r, a)
+			for _, req := range []*http.Request{
+				httptest.NewRequest(http.MethodDelete, "/", nil),
+				httptest.NewRequest(http.MethodGet, "/?clientCerts&accessKey=oak", nil),
+				httptest.NewRequest(http.MethodDelete, "/?clientCert&accessKey=oak&identity=dns:svc-a.example.org", nil),
+			} {
+				w := httptest.NewRecorder()
+				r.ServeHTTP(w, withCert(req, true))
+				convey.So(w.Code, convey.ShouldEqual, http.StatusForbidden)
+			}
+		})
+
+		convey.Convey("signed requests ignore the client cert", func() {
+			app2 := mysql.Info{OsAccessKey: "oak2", OsScrectKey: "osk2", EngineType: "s3", EngineAccessKey: "ak", EngineSecretKey: "sk", AppName: "videos"}
+			mockDB.EXPECT().GetInfo("oak2").Return(app2, nil).AnyTimes()
+			mockDB.EXPECT().GetInfo("unknown").Return(mysql.Info{}, db.ErrNotFound).AnyTimes()
+
+			// 预签名 URL 使用 X-Amz-Credential 中的 key，不查询证书映射
+			presigned := func(ak string) *http.Request {
+				req := httptest.NewRequest(http.MethodGet, "/photos/1.jpg?X-Amz-Algorithm=AWS4-HMAC-SHA256&X-Amz-Credential="+ak+
+					"%2F20200101%2Fus-east-1%2Fs3%2Faws4_request&X-Amz-Date=20200101T000000Z&X-Amz-Expires=60&X-Amz-SignedHeaders=host&X-Amz-Signature=00", nil)
+				return withCert(req, true)
+			}
+			info := a.getAuthorizationInfo(presigned("oak2"))
+			convey.So(info, convey.ShouldNotBeNil)
+			convey.So(info.oak, convey.ShouldEqual, "oak2")
+			convey.So(info.identity, convey.ShouldBeEmpty)
+			convey.So(a.getAuthorizationInfo(presigned("unknown")), convey.ShouldBeNil)
+
+			// 签名的 key 不存在时不回退到证书
+			req := httptest.NewRequest(http.MethodGet, "/photos/1.jpg", nil)
+			signRequest(req, "unknown", "secret")
+			convey.So(a.getAuthorizationInfo(withCert(req, true)), convey.ShouldBeNil)
+		})
+
+		convey.Convey("Auth without mapping", func() {
+			mockDB.EXPECT().GetClientCert(ids).Return(nil, nil).Times(1)
+
+			convey.So(a.Auth(request(true)), convey.ShouldEqual, gerror.ErrAllAccessDisabled)
+			convey.So(a.Auth(request(true)), convey.ShouldEqual, gerror.ErrAllAccessDisabled)
+		})
+
+		convey.Convey("admin", func() {
+			r := mux.NewRouter()
+			NewAPIRouter(r, a)
+
+			do := func(method, target, body string) *httptest.ResponseRecorder {
+				req := httptest.NewRequest(method, target, strings.NewReader(body))
+				signRequest(req, "admin", "admin-secret")
+				w := httptest.NewRecorder()
+				r.ServeHTTP(w, req)
+				return w
+			}
+
+			const body = "<ClientCertConfiguration><ApplicationAccessKey>oak</ApplicationAccessKey>" +
+				"<Identity>dns:SVC-A.example.org</Identity></ClientCertConfiguration>"
+
+			// 之前缓存的"不存在"在创建后失效
+			a.Cache.AddNegative("x509:dns:svc-a.example.org")
+
+			mockDB.EXPECT().GetClientCert([]string{"dns:svc-a.example.org"}).Return(nil, nil)
+			mockDB.EXPECT().SaveClientCert(map[string]interface{}{"os_access_key": "oak", "identity": "dns:svc-a.example.org"}).Return(1, nil)
+			w := do(http.MethodPost, "/?createClientCert", body)
+			convey.So(w.Code, convey.ShouldEqual, http.StatusOK)
+			_, _, hit := a.Cache.Get("x509:dns:svc-a.example.org")
+			convey.So(hit, convey.ShouldBeFalse)
+
+			mockDB.EXPECT().GetClientCert([]string{"dns:svc-a.example.org"}).Return([]db.ClientCert{{OsAccessKey: "oak", Identity: "dns:svc-a.example.org"}}, nil)
+			w = do(http.MethodPost, "/?createClientCert", body)
+			convey.So(w.Code, convey.ShouldEqual, http.StatusConflict)
+
+			w = do(http.MethodPost, "/?createClientCert", "<ClientCertConfiguration><ApplicationAccessKey>oak</ApplicationAccessKey>"+
+				"<Identity>cn:svc-a</Identity></ClientCertConfiguration>")
+			convey.So(w.Code, convey.ShouldEqual, http.StatusBadRequest)
+
+			mockDB.EXPECT().ListClientCert("oak").Return([]db.ClientCert{{OsAccessKey: "oak", Identity: "dns:svc-a.example.org"}}, nil)
+			w = do(http.MethodGet, "/?clientCerts&accessKey=oak", "")
+			convey.So(w.Code, convey.ShouldEqual, http.StatusOK)
+			convey.So(w.Body.String(), convey.ShouldContainSubstring, "<Identity>dns:svc-a.example.org</Identity>")
+
+			mockDB.EXPECT().DeleteClientCert("oak", "dns:svc-a.example.org").Return(nil)
+			w = do(http.MethodDelete, "/?clientCert&accessKey=oak&identity=dns:svc-a.example.org", "")
+			convey.So(w.Code, convey.ShouldEqual, http.StatusOK)
+
+			mockDB.EXPECT().DeleteClientCert("oak", "dns:svc-a.example.org").Return(db.ErrNotFound)
+			w = do(http.MethodDelete, "/?clientCert&accessKey=oak&identity=dns:svc-a.example.org", "")
+			convey.So(w.Code, convey.ShouldEqual, http.StatusNotFound)
+
+			// 需要管理 key
+			req := httptest.NewRequest(http.MethodGet, "/?clientCerts&accessKey=oak", nil)
+			signRequest(req, "oak", "osk")
+			w = httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+			convey.So(w.Code, convey.ShouldEqual, http.StatusForbidden)
+		})
+	})
+}
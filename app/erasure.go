@@ -0,0 +1,167 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+
+	"github.com/solution9th/S3Adapter/internal"
+	"github.com/solution9th/S3Adapter/internal/auth"
+	"github.com/solution9th/S3Adapter/internal/config"
+	"github.com/solution9th/S3Adapter/internal/db"
+	"github.com/solution9th/S3Adapter/internal/gateway"
+	"github.com/solution9th/S3Adapter/internal/gateway/erasure"
+
+	"github.com/haozibi/zlog"
+)
+
+const (
+	// maxErasureShards 纠删码分片总数上限，每个分片需要一个引擎
+	maxErasureShards = 16
+)
+
+var errErasureNotFound = errors.New("application has no erasure configuration")
+
+// BackendConfiguration 保存分片的引擎
+type BackendConfiguration struct {
+	AccessKey string `xml:"AccessKey" json:"access_key"`
+	SecretKey string `xml:"SecretKey" json:"secret_key"`
+	Region    string `xml:"Region" json:"region"`
+	Engine    string `xml:"Engine" json:"engine"`
+}
+
+func (b *BackendConfiguration) validate() bool {
+
+	if b.Engine == "" || b.AccessKey == "" || b.SecretKey == "" || b.Region == "" {
+		return false
+	}
+
+	_, ok := internal.GatewayMap[b.Engine]
+	return ok
+}
+
+// ErasureConfiguration 纠删码配置，第一个分片保存在应用本身的引擎，
+// 其余分片依次保存在 Backend 中，Backend 的数量为 DataShards+ParityShards-1
+type ErasureConfiguration struct {
+	DataShards   int                    `xml:"DataShards"`
+	ParityShards int                    `xml:"ParityShards"`
+	Backends     []BackendConfiguration `xml:"Backend"`
+}
+
+func (e *ErasureConfiguration) validate() bool {
+
+	if e.DataShards <= 0 || e.ParityShards <= 0 || e.DataShards+e.ParityShards > maxErasureShards {
+		return false
+	}
+
+	if len(e.Backends) != e.DataShards+e.ParityShards-1 {
+		return false
+	}
+
+	for _, b := range e.Backends {
+		if !b.validate() {
+			return false
+		}
+	}
+	return true
+}
+
+func (a *API) saveErasure(oak string, e *ErasureConfiguration) error {
+
+	backends, err := json.Marshal(e.Backends)
+	if err != nil {
+		return err
+	}
+
+	data := make(map[string]interface{})
+	data["os_access_key"] = oak
+	data["data_shards"] = e.DataShards
+	data["parity_shards"] = e.ParityShards
+	data["backends"] = string(backends)
+
+	_, err = a.DB.SaveErasure(data)
+	return err
+}
+
+// newErasure 应用有纠删码配置时，把应用本身的引擎和配置的引擎组合成纠删码存储
+func (a *API) newErasure(oak string, g gateway.S3Protocol) (gateway.S3Protocol, error) {
+
+	e, err := a.DB.GetErasure(oak)
+	if err == db.ErrNotFound {
+		return g, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var list []BackendConfiguration
+	if err := json.Unmarshal([]byte(e.Backends), &list); err != nil {
+		return nil, err
+	}
+
+	backends := []gateway.S3Protocol{g}
+	for _, b := range list {
+		p, err := a.Pool.Get(oak, b.Engine, auth.Credentials{
+			AccessKey: b.AccessKey, SecretKey: b.SecretKey},
+			b.Region)
+		if err != nil {
+			return nil, err
+		}
+		backends = append(backends, p)
+	}
+
+	return erasure.New(backends, e.DataShards, e.ParityShards)
+}
+
+// resolveErasure 根据 oak 获得纠删码存储，没有纠删码配置时返回 nil
+func (a *API) resolveErasure(oak string) (*erasure.Erasure, error) {
+
+	_, ak, sk, engine, region := a.getSecretKeyEngine(oak)
+	if engine == "" {
+		return nil, errApplicationNotFound
+	}
+
+	g, err := a.Pool.Get(oak, engine, auth.Credentials{
+		AccessKey: ak, SecretKey: sk},
+		region)
+	if err != nil {
+		return nil, err
+	}
+
+	g, err = a.newErasure(oak, g)
+	if err != nil {
+		return nil, err
+	}
+
+	e, _ := g.(*erasure.Erasure)
+	return e, nil
+}
+
+// Heal 重建应用缺失的分片，bucket 为空时修复所有 bucket
+func Heal(cfg config.Config, oak, bucket string) (erasure.HealResult, error) {
+
+	a, err := NewAPP(cfg)
+	if err != nil {
+		return erasure.HealResult{}, err
+	}
+
+	if err := registerPlugins(cfg.Plugins); err != nil {
+		return erasure.HealResult{}, err
+	}
+
+	e, err := a.resolveErasure(oak)
+	if err != nil {
+		return erasure.HealResult{}, err
+	}
+	if e == nil {
+		return erasure.HealResult{}, errErasureNotFound
+	}
+
+	ctx := context.Background()
+
+	zlog.ZInfo().Str("OAK", oak).Str("Bucket", bucket).Msg("[Erasure] heal start")
+	if bucket != "" {
+		return e.HealBucket(ctx, bucket)
+	}
+	return e.Heal(ctx)
+}
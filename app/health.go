@@ -0,0 +1,159 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/solution9th/S3Adapter/internal/auth"
+	"github.com/solution9th/S3Adapter/internal/config"
+	"github.com/solution9th/S3Adapter/internal/db"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+const (
+	healthOK           = "ok"
+	healthError        = "error"
+	healthShuttingDown = "shutting_down"
+
+	// defaultHealthTimeout 没有配置时每个依赖的检查超时时间
+	defaultHealthTimeout = 2 * time.Second
+
+	// canaryPrefix 检查用的后端客户端在 Pool 中的分组，不会与应用的 AccessKey 冲突
+	canaryPrefix = "canary:"
+)
+
+// Health 就绪检查的配置和状态，为 nil 时只检查 MySQL
+type Health struct {
+	timeout  time.Duration
+	canaries []config.Canary
+
+	draining int32
+}
+
+// NewHealth 创建就绪检查
+func NewHealth(cfg config.Health) *Health {
+	timeout := cfg.Timeout
+	if timeout <= 0 {
+		timeout = defaultHealthTimeout
+	}
+	return &Health{timeout: timeout, canaries: cfg.Canaries}
+}
+
+// Drain 开始退出，之后 /readyz 总是返回 503
+func (h *Health) Drain() {
+	if h != nil {
+		atomic.StoreInt32(&h.draining, 1)
+	}
+}
+
+func (h *Health) isDraining() bool {
+	return h != nil && atomic.LoadInt32(&h.draining) == 1
+}
+
+// HealthCheck 一个依赖的检查结果
+type HealthCheck struct {
+	Status  string `json:"status"`
+	Latency string `json:"latency"`
+	Error   string `json:"error,omitempty"`
+}
+
+// HealthResult /healthz 和 /readyz 的返回值
+type HealthResult struct {
+	Status string                 `json:"status"`
+	Checks map[string]HealthCheck `json:"checks,omitempty"`
+}
+
+// Healthz 进程存活检查，不检查依赖
+func (a *API) Healthz(w http.ResponseWriter, r *http.Request) {
+	writeHealth(w, http.StatusOK, HealthResult{Status: healthOK})
+}
+
+// Readyz 就绪检查，检查 MySQL 和配置的后端引擎，任意一个失败时返回 503
+func (a *API) Readyz(w http.ResponseWriter, r *http.Request) {
+
+	if a.Health.isDraining() {
+		writeHealth(w, http.StatusServiceUnavailable, HealthResult{Status: healthShuttingDown})
+		return
+	}
+
+	timeout := defaultHealthTimeout
+	var canaries []config.Canary
+	if a.Health != nil {
+		timeout, canaries = a.Health.timeout, a.Health.canaries
+	}
+
+	checks := map[string]func(ctx context.Context) error{
+		"mysql": func(ctx context.Context) error {
+			return db.WithContext(ctx, a.DB).Ping(ctx)
+		},
+	}
+	for _, c := range canaries {
+		c := c
+		checks[canaryPrefix+c.Name] = func(ctx context.Context) error {
+			return a.probe(ctx, c)
+		}
+	}
+
+	result := HealthResult{Status: healthOK, Checks: make(map[string]HealthCheck, len(checks))}
+
+	var (
+		mu sync.Mutex
+		wg sync.WaitGroup
+	)
+	for name, check := range checks {
+		wg.Add(1)
+		go func(name string, check func(ctx context.Context) error) {
+			defer wg.Done()
+
+			ctx, cancel := context.WithTimeout(r.Context(), timeout)
+			defer cancel()
+
+			start := time.Now()
+			err := check(ctx)
+			c := HealthCheck{Status: healthOK, Latency: time.Since(start).String()}
+			if err != nil {
+				c.Status, c.Error = healthError, err.Error()
+			}
+
+			mu.Lock()
+			result.Checks[name] = c
+			if err != nil {
+				result.Status = healthError
+			}
+			mu.Unlock()
+		}(name, check)
+	}
+	wg.Wait()
+
+	code := http.StatusOK
+	if result.Status != healthOK {
+		code = http.StatusServiceUnavailable
+	}
+	writeHealth(w, code, result)
+}
+
+// probe 对 canary 的 bucket 调用 HeadBucket
+func (a *API) probe(ctx context.Context, c config.Canary) error {
+
+	g, err := a.Pool.Get(canaryPrefix+c.Name, c.Engine, auth.Credentials{
+		AccessKey: c.AccessKey, SecretKey: c.SecretKey},
+		c.Region)
+	if err != nil {
+		return err
+	}
+
+	_, _, err = g.HeadBucketWithContext(ctx, &s3.HeadBucketInput{Bucket: aws.String(c.Bucket)})
+	return err
+}
+
+func writeHealth(w http.ResponseWriter, code int, result HealthResult) {
+	body, _ := json.Marshal(result)
+	w.Header().Set("Cache-Control", "no-store")
+	writeResponse(w, code, body, mimeJSON)
+}
@@ -0,0 +1,95 @@
+package app
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/solution9th/S3Adapter/internal"
+	"github.com/solution9th/S3Adapter/internal/config"
+	"github.com/solution9th/S3Adapter/internal/gateway"
+	"github.com/solution9th/S3Adapter/mocks/mock_db"
+	"github.com/solution9th/S3Adapter/mocks/mock_gateway"
+
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/golang/mock/gomock"
+	"github.com/gorilla/mux"
+	"github.com/smartystreets/goconvey/convey"
+)
+
+func TestReadyz(t *testing.T) {
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	proto := mock_gateway.NewMockS3Protocol(ctrl)
+	internal.GatewayMap["canary-test"] = func() gateway.Gateway {
+		g := mock_gateway.NewMockGateway(ctrl)
+		g.EXPECT().Name().Return("canary-test").AnyTimes()
+		g.EXPECT().Production().Return(true).AnyTimes()
+		g.EXPECT().Capabilities().Return(gateway.FullCapabilities()).AnyTimes()
+		g.EXPECT().NewS3Protocol(gomock.Any(), gomock.Any(), gomock.Any()).Return(proto, nil).AnyTimes()
+		return g
+	}
+	defer delete(internal.GatewayMap, "canary-test")
+
+	convey.Convey("readyz", t, func() {
+
+		mockDB := mock_db.NewMockDB(ctrl)
+		a := &API{
+			DB: mockDB,
+			Health: NewHealth(config.Health{Canaries: []config.Canary{
+				{Name: "main", Engine: "canary-test", Bucket: "canary"},
+			}}),
+		}
+		r := mux.NewRouter()
+		NewAPIRouter(r, a)
+
+		get := func(path string) (int, HealthResult) {
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+			var result HealthResult
+			json.Unmarshal(w.Body.Bytes(), &result)
+			return w.Code, result
+		}
+
+		convey.Convey("ready", func() {
+			mockDB.EXPECT().Ping(gomock.Any()).Return(nil)
+			proto.EXPECT().HeadBucketWithContext(gomock.Any(), gomock.Any()).
+				Return(&s3.HeadBucketOutput{}, &http.Response{Header: make(http.Header)}, nil)
+
+			code, result := get("/readyz")
+			convey.So(code, convey.ShouldEqual, http.StatusOK)
+			convey.So(result.Status, convey.ShouldEqual, healthOK)
+			convey.So(result.Checks["mysql"].Status, convey.ShouldEqual, healthOK)
+			convey.So(result.Checks["canary:main"].Status, convey.ShouldEqual, healthOK)
+		})
+
+		convey.Convey("mysql down", func() {
+			mockDB.EXPECT().Ping(gomock.Any()).Return(errors.New("connection refused"))
+			proto.EXPECT().HeadBucketWithContext(gomock.Any(), gomock.Any()).
+				Return(&s3.HeadBucketOutput{}, &http.Response{Header: make(http.Header)}, nil)
+
+			code, result := get("/readyz")
+			convey.So(code, convey.ShouldEqual, http.StatusServiceUnavailable)
+			convey.So(result.Status, convey.ShouldEqual, healthError)
+			convey.So(result.Checks["mysql"].Error, convey.ShouldEqual, "connection refused")
+			convey.So(result.Checks["canary:main"].Status, convey.ShouldEqual, healthOK)
+		})
+
+		convey.Convey("shutting down", func() {
+			a.Health.Drain()
+
+			code, result := get("/readyz")
+			convey.So(code, convey.ShouldEqual, http.StatusServiceUnavailable)
+			convey.So(result.Status, convey.ShouldEqual, healthShuttingDown)
+
+			// 退出过程中进程仍然存活
+			code, result = get("/healthz")
+			convey.So(code, convey.ShouldEqual, http.StatusOK)
+			convey.So(result.Status, convey.ShouldEqual, healthOK)
+		})
+	})
+}
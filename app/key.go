@@ -0,0 +1,255 @@
+package app
+
+import (
+	"context"
+	"encoding/xml"
+	"net/http"
+	"time"
+
+	"github.com/solution9th/S3Adapter/internal/db"
+	"github.com/solution9th/S3Adapter/internal/db/mysql"
+	"github.com/solution9th/S3Adapter/internal/gerror"
+	"github.com/solution9th/S3Adapter/internal/reqinfo"
+
+	"github.com/haozibi/zlog"
+)
+
+// defaultKeyGracePeriod 没有配置时轮换后旧 key 的有效时间
+const defaultKeyGracePeriod = 24 * time.Hour
+
+// RotateKeyConfiguration 轮换应用 key 的请求
+type RotateKeyConfiguration struct {
+	// AccessKey 应用任意一个 key
+	AccessKey string `xml:"AccessKey"`
+
+	// GracePeriodSeconds 旧 key 继续有效的秒数，为空时使用配置，为 0 时立即失效
+	GracePeriodSeconds *int64 `xml:"GracePeriodSeconds"`
+}
+
+// RotateKeyResult 轮换应用 key 的结果
+type RotateKeyResult struct {
+	AccessKey string `xml:"AccessKey"`
+	SecretKey string `xml:"SecretKey"`
+
+	// Expiration 旧 key 的过期时间
+	Expiration time.Time `xml:"Expiration"`
+}
+
+// ListKeysResult 应用所有的 key，不包括 SecretKey
+type ListKeysResult struct {
+	Keys []KeyInfo `xml:"Key"`
+}
+
+// KeyInfo 应用的一个 key
+type KeyInfo struct {
+	AccessKey string `xml:"AccessKey"`
+
+	// Expiration 过期时间，不过期时为空
+	Expiration *time.Time `xml:"Expiration,omitempty"`
+	Expired    bool       `xml:"Expired"`
+	CreateTime time.Time  `xml:"CreateTime"`
+}
+
+// RotateKey 为应用创建新的 key，旧的 key 在宽限期后失效，需要使用管理 key 签名
+//
+// 请求:
+//
+//	POST /?rotateKey
+//	<RotateKeyConfiguration>
+//		<AccessKey></AccessKey>
+//		<GracePeriodSeconds></GracePeriodSeconds>
+//	</RotateKeyConfiguration>
+//
+// 响应:
+//
+//	<RotateKeyResult xmlns="http://s3.amazonaws.com/doc/2006-03-01/">
+//		<AccessKey></AccessKey>
+//		<SecretKey></SecretKey>
+//		<Expiration></Expiration>
+//	</RotateKeyResult>
+func (a *API) RotateKey(w http.ResponseWriter, r *http.Request) {
+
+	ctx := newContext(w, r, "RotateKey")
+
+	reqinfo.ZDebug(ctx).Str("Method", "RotateKey").Msg("[debug]")
+
+	if errCode := a.authAdmin(r); errCode != gerror.ErrNone {
+		writeErrorResponseXML(ctx, w,
+			gerror.GetError(errCode, nil))
+		return
+	}
+
+	var c RotateKeyConfiguration
+	if r.Body != nil {
+		err := xml.NewDecoder(r.Body).Decode(&c)
+		if err != nil {
+			reqinfo.ZError(ctx).Str("Method", "XMLDecode").Msg(err.Error())
+			writeErrorResponseXML(ctx, w,
+				gerror.GetError(gerror.ErrMalformedXML, err))
+			return
+		}
+	}
+
+	grace := a.Admin.keyGracePeriod
+	if c.GracePeriodSeconds != nil {
+		grace = time.Duration(*c.GracePeriodSeconds) * time.Second
+	}
+	if c.AccessKey == "" || grace < 0 {
+		writeErrorResponseXML(ctx, w,
+			gerror.GetError(gerror.ErrInvalidRequestParameter, nil))
+		return
+	}
+
+	result, errCode := a.rotateKey(ctx, c.AccessKey, grace)
+	if errCode != gerror.ErrNone {
+		writeErrorResponseXML(ctx, w,
+			gerror.GetError(errCode, nil))
+		return
+	}
+
+	formatWriteXML(w, http.StatusOK, "", result, true)
+}
+
+// ListKeys 列出应用所有的 key，需要使用管理 key 签名
+//
+// 请求:
+//
+//	GET /?keys&accessKey=<应用任意一个 key>
+func (a *API) ListKeys(w http.ResponseWriter, r *http.Request) {
+
+	ctx := newContext(w, r, "ListKeys")
+
+	reqinfo.ZDebug(ctx).Str("Method", "ListKeys").Msg("[debug]")
+
+	if errCode := a.authAdmin(r); errCode != gerror.ErrNone {
+		writeErrorResponseXML(ctx, w,
+			gerror.GetError(errCode, nil))
+		return
+	}
+
+	oak := r.URL.Query().Get("accessKey")
+	if oak == "" {
+		writeErrorResponseXML(ctx, w,
+			gerror.GetError(gerror.ErrInvalidRequestParameter, nil))
+		return
+	}
+
+	m, err := a.lookupApp(ctx, oak)
+	if err == db.ErrNotFound {
+		writeErrorResponseXML(ctx, w,
+			gerror.GetError(gerror.ErrInvalidAccessKeyID, nil))
+		return
+	}
+	if err != nil {
+		writeErrorResponseXML(ctx, w,
+			gerror.GetError(gerror.ErrInternalError, nil))
+		return
+	}
+
+	keys, err := db.WithContext(ctx, a.DB).ListKey(m.OsAccessKey)
+	if err != nil {
+		reqinfo.ZError(ctx).Str("Method", "ListKey").Msg(err.Error())
+		writeErrorResponseXML(ctx, w,
+			gerror.GetError(gerror.ErrInternalError, nil))
+		return
+	}
+
+	var result ListKeysResult
+	now := time.Now()
+	if len(keys) == 0 {
+		// 没有轮换过，只有应用创建时的 key
+		result.Keys = append(result.Keys, KeyInfo{AccessKey: m.OsAccessKey, CreateTime: m.CreateTime})
+	}
+	for _, k := range keys {
+		result.Keys = append(result.Keys, KeyInfo{
+			AccessKey:  k.AccessKey,
+			Expiration: k.ExpireTime,
+			Expired:    k.Expired(now),
+			CreateTime: k.CreateTime,
+		})
+	}
+
+	formatWriteXML(w, http.StatusOK, "", result, true)
+}
+
+// lookupApp 不使用缓存查找 oak 所属的应用，oak 为应用下用户的 key 或者临时凭证时返回 db.ErrNotFound
+func (a *API) lookupApp(ctx context.Context, oak string) (m mysql.Info, err error) {
+
+	mm, err := db.WithContext(ctx, a.DB).GetInfo(oak)
+	if err != nil {
+		if err != db.ErrNotFound {
+			reqinfo.ZError(ctx).Str("OAK", oak).Msg("[DB] error: " + err.Error())
+		}
+		return m, err
+	}
+	m = mm.(mysql.Info)
+	if m.UserName != "" || m.SessionToken != "" {
+		return mysql.Info{}, db.ErrNotFound
+	}
+	m.OsAccessKey = appID(m, oak)
+	return m, nil
+}
+
+// rotateKey 为 oak 所属的应用创建新的 key，应用其他没有过期的 key 在 grace 后过期
+//
+// 第一次轮换时先把应用创建时的 key 保存到 key 表，之后查找它时也会检查过期时间
+func (a *API) rotateKey(ctx context.Context, oak string, grace time.Duration) (result RotateKeyResult, errCode gerror.APIErrorCode) {
+
+	m, err := a.lookupApp(ctx, oak)
+	if err == db.ErrNotFound {
+		return result, gerror.ErrInvalidAccessKeyID
+	}
+	if err != nil {
+		return result, gerror.ErrInternalError
+	}
+	id := m.OsAccessKey
+
+	d := db.WithContext(ctx, a.DB)
+
+	keys, err := d.ListKey(id)
+	if err != nil {
+		zlog.ZError().Str("Method", "listKey").Msg(err.Error())
+		return result, gerror.ErrInternalError
+	}
+
+	if len(keys) == 0 {
+		// 没有轮换过时 oak 一定是应用创建时的 key，m.OsScrectKey 为它的 SecretKey
+		_, err = d.SaveKey(map[string]interface{}{
+			"os_access_key": id,
+			"access_key":    id,
+			"secret_key":    m.OsScrectKey,
+		})
+		if err != nil {
+			zlog.ZError().Str("Method", "saveKey").Msg(err.Error())
+			return result, gerror.ErrInternalError
+		}
+		keys = append(keys, db.Key{OsAccessKey: id, AccessKey: id})
+	}
+
+	expire := time.Now().Add(grace).UTC().Truncate(time.Second)
+	if err = d.ExpireKey(id, expire); err != nil {
+		zlog.ZError().Str("Method", "expireKey").Msg(err.Error())
+		return result, gerror.ErrInternalError
+	}
+
+	ak, sk := genAccessKey(), genSecretKey()
+	_, err = d.SaveKey(map[string]interface{}{
+		"os_access_key": id,
+		"access_key":    ak,
+		"secret_key":    sk,
+	})
+	if err != nil {
+		zlog.ZError().Str("Method", "saveKey").Msg(err.Error())
+		return result, gerror.ErrInternalError
+	}
+
+	// 缓存中的旧 key 没有过期时间
+	for _, k := range keys {
+		a.Cache.Remove(k.AccessKey)
+	}
+	a.Cache.Remove(ak)
+
+	zlog.ZInfo().Str("App", id).Str("AK", ak).Str("Expiration", expire.Format(time.RFC3339)).Msg("[Admin] rotate key")
+
+	return RotateKeyResult{AccessKey: ak, SecretKey: sk, Expiration: expire}, gerror.ErrNone
+}
@@ -0,0 +1,166 @@
+package app
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/solution9th/S3Adapter/internal/config"
+	"github.com/solution9th/S3Adapter/internal/db"
+	"github.com/solution9th/S3Adapter/internal/db/mysql"
+	"github.com/solution9th/S3Adapter/internal/gerror"
+	"github.com/solution9th/S3Adapter/mocks/mock_db"
+
+	"github.com/golang/mock/gomock"
+	"github.com/gorilla/mux"
+	"github.com/smartystreets/goconvey/convey"
+)
+
+func TestRotateKey(t *testing.T) {
+
+	convey.Convey("rotateKey", t, func() {
+
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		mockDB := mock_db.NewMockDB(ctrl)
+		a := &API{DB: mockDB}
+
+		info := mysql.Info{OsAccessKey: "oak", OsScrectKey: "osk", EngineType: "s3"}
+
+		convey.Convey("first rotation saves the original key", func() {
+			var saved []map[string]interface{}
+			var expire time.Time
+
+			mockDB.EXPECT().GetInfo("oak").Return(info, nil)
+			mockDB.EXPECT().ListKey("oak").Return(nil, nil)
+			mockDB.EXPECT().SaveKey(gomock.Any()).Do(func(data map[string]interface{}) {
+				saved = append(saved, data)
+			}).Return(1, nil).Times(2)
+			mockDB.EXPECT().ExpireKey("oak", gomock.Any()).Do(func(_ string, t time.Time) {
+				expire = t
+			}).Return(nil)
+
+			result, errCode := a.rotateKey(context.Background(), "oak", time.Hour)
+			convey.So(errCode, convey.ShouldEqual, gerror.ErrNone)
+			convey.So(saved, convey.ShouldHaveLength, 2)
+			convey.So(saved[0], convey.ShouldResemble, map[string]interface{}{
+				"os_access_key": "oak", "access_key": "oak", "secret_key": "osk"})
+			convey.So(saved[1]["access_key"], convey.ShouldEqual, result.AccessKey)
+			convey.So(saved[1]["secret_key"], convey.ShouldEqual, result.SecretKey)
+			convey.So(result.Expiration, convey.ShouldEqual, expire)
+			convey.So(expire, convey.ShouldHappenWithin, time.Minute, time.Now().Add(time.Hour))
+		})
+
+		convey.Convey("rotate with a rotated key", func() {
+			rotated := info
+			rotated.OsScrectKey = "new-secret"
+
+			mockDB.EXPECT().GetInfo("new").Return(rotated, nil)
+			mockDB.EXPECT().ListKey("oak").Return([]db.Key{{OsAccessKey: "oak", AccessKey: "oak"}, {OsAccessKey: "oak", AccessKey: "new"}}, nil)
+			mockDB.EXPECT().ExpireKey("oak", gomock.Any()).Return(nil)
+			mockDB.EXPECT().SaveKey(gomock.Any()).Return(3, nil).Times(1)
+
+			_, errCode := a.rotateKey(context.Background(), "new", 0)
+			convey.So(errCode, convey.ShouldEqual, gerror.ErrNone)
+		})
+
+		convey.Convey("unknown key", func() {
+			mockDB.EXPECT().GetInfo("none").Return(mysql.Info{}, db.ErrNotFound)
+
+			_, errCode := a.rotateKey(context.Background(), "none", time.Hour)
+			convey.So(errCode, convey.ShouldEqual, gerror.ErrInvalidAccessKeyID)
+		})
+	})
+}
+
+func TestRotateKeyAPI(t *testing.T) {
+
+	convey.Convey("RotateKey", t, func() {
+
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		mockDB := mock_db.NewMockDB(ctrl)
+		a := &API{DB: mockDB, Admin: NewAdmin(config.Admin{AccessKey: "admin", SecretKey: "admin-secret"})}
+
+		r := mux.NewRouter()
+		NewAPIRouter(r, a)
+
+		convey.Convey("success", func() {
+			mockDB.EXPECT().GetInfo("oak").Return(mysql.Info{OsAccessKey: "oak", OsScrectKey: "osk"}, nil)
+			mockDB.EXPECT().ListKey("oak").Return([]db.Key{{OsAccessKey: "oak", AccessKey: "oak"}}, nil)
+			mockDB.EXPECT().ExpireKey("oak", gomock.Any()).Return(nil)
+			mockDB.EXPECT().SaveKey(gomock.Any()).Return(2, nil)
+
+			req := httptest.NewRequest(http.MethodPost, "/?rotateKey", strings.NewReader(
+				"<RotateKeyConfiguration><AccessKey>oak</AccessKey><GracePeriodSeconds>0</GracePeriodSeconds></RotateKeyConfiguration>"))
+			signRequest(req, "admin", "admin-secret")
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+
+			convey.So(w.Code, convey.ShouldEqual, http.StatusOK)
+			convey.So(w.Body.String(), convey.ShouldContainSubstring, "<RotateKeyResult")
+		})
+
+		convey.Convey("err: not admin", func() {
+			req := httptest.NewRequest(http.MethodPost, "/?rotateKey", strings.NewReader(
+				"<RotateKeyConfiguration><AccessKey>oak</AccessKey></RotateKeyConfiguration>"))
+			signRequest(req, "oak", "osk")
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+
+			convey.So(w.Code, convey.ShouldEqual, http.StatusForbidden)
+		})
+
+		convey.Convey("list keys", func() {
+			expire := time.Now().Add(-time.Minute)
+			mockDB.EXPECT().GetInfo("new").Return(mysql.Info{OsAccessKey: "oak"}, nil)
+			mockDB.EXPECT().ListKey("oak").Return([]db.Key{
+				{OsAccessKey: "oak", AccessKey: "oak", SecretKey: "osk", ExpireTime: &expire},
+				{OsAccessKey: "oak", AccessKey: "new", SecretKey: "nsk"},
+			}, nil)
+
+			req := httptest.NewRequest(http.MethodGet, "/?keys&accessKey=new", nil)
+			signRequest(req, "admin", "admin-secret")
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+
+			convey.So(w.Code, convey.ShouldEqual, http.StatusOK)
+			body := w.Body.String()
+			convey.So(body, convey.ShouldContainSubstring, "<AccessKey>oak</AccessKey>")
+			convey.So(body, convey.ShouldContainSubstring, "<Expired>true</Expired>")
+			convey.So(body, convey.ShouldNotContainSubstring, "osk")
+		})
+	})
+}
+
+func TestExpiredKey(t *testing.T) {
+
+	convey.Convey("expired key", t, func() {
+
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		mockDB := mock_db.NewMockDB(ctrl)
+		a := &API{DB: mockDB}
+
+		expire := time.Now().Add(-time.Second)
+		mockDB.EXPECT().GetInfo("oak").Return(mysql.Info{
+			OsAccessKey: "oak", OsScrectKey: "osk", EngineType: "s3",
+			EngineAccessKey: "ak", EngineSecretKey: "sk", KeyExpireTime: &expire}, nil).AnyTimes()
+
+		req := httptest.NewRequest(http.MethodGet, "/bucket", nil)
+		signRequest(req, "oak", "osk")
+
+		convey.So(a.getAuthorizationInfo(req), convey.ShouldBeNil)
+		convey.So(a.Auth(req), convey.ShouldEqual, gerror.ErrAllAccessDisabled)
+
+		// 后台任务使用应用创建时的 key 查找，不检查过期时间
+		_, ak, sk, engine, _ := a.getSecretKeyEngine("oak")
+		convey.So([]string{ak, sk, engine}, convey.ShouldResemble, []string{"ak", "sk", "s3"})
+	})
+}
@@ -1,12 +1,21 @@
 package app
 
 import (
+	"errors"
 	"fmt"
+	"io"
+	"net"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
 
+	"github.com/solution9th/S3Adapter/internal/accesslog"
+	"github.com/solution9th/S3Adapter/internal/metrics"
+	"github.com/solution9th/S3Adapter/internal/reqinfo"
+	"github.com/solution9th/S3Adapter/internal/trace"
+
 	"github.com/gorilla/mux"
-	"github.com/haozibi/zlog"
 )
 
 const (
@@ -14,6 +23,18 @@ const (
 	responseAMZIDKey     = "x-amz-id-2"
 )
 
+// requestMiddleware 生成请求 ID，保存在请求的 context 中
+func requestMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		info := &reqinfo.ReqInfo{
+			RequestID:  getRequestID(),
+			RemoteAddr: r.RemoteAddr,
+		}
+		w.Header().Set(responseRequestIDKey, info.RequestID)
+		next.ServeHTTP(w, r.WithContext(reqinfo.NewContext(r.Context(), info)))
+	})
+}
+
 func loggingMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		t1 := time.Now()
@@ -23,6 +44,217 @@ func loggingMiddleware(next http.Handler) http.Handler {
 
 		vars := mux.Vars(r)
 
-		zlog.ZDebug().Str("Method", r.Method).Str("Host", r.Host).Str("URL", r.RequestURI).Str("From", r.RemoteAddr).Str("UA", r.UserAgent()).Str("Bucket", vars["bucket"]).Str("Object", vars["object"]).Str("Time", fmt.Sprintf("%v", t2)).Msg("[http]")
+		api := ""
+		if info := reqinfo.FromContext(r.Context()); info != nil {
+			api = info.API
+		}
+
+		reqinfo.ZDebug(r.Context()).Str("Method", r.Method).Str("API", api).Str("Host", r.Host).Str("URL", r.RequestURI).Str("From", r.RemoteAddr).Str("UA", r.UserAgent()).Str("Bucket", vars["bucket"]).Str("Object", vars["object"]).Str("Time", fmt.Sprintf("%v", t2)).Msg("[http]")
+	})
+}
+
+// traceMiddleware 读取请求头中的 traceparent，为每个请求记录 server span，
+// 需要在 requestMiddleware 之后
+func traceMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		ctx := trace.Extract(r.Context(), r.Header)
+		ctx, span := trace.Start(ctx, "HTTP "+r.Method, trace.KindServer)
+		if span == nil {
+			next.ServeHTTP(w, r.WithContext(ctx))
+			return
+		}
+		defer span.End()
+
+		sw := &statusWriter{ResponseWriter: w}
+		next.ServeHTTP(sw, r.WithContext(ctx))
+
+		span.SetAttribute("http.method", r.Method)
+		span.SetAttribute("http.target", r.URL.Path)
+		span.SetAttribute("http.status_code", sw.status())
+
+		info := reqinfo.FromContext(ctx)
+		if info == nil {
+			return
+		}
+		if info.API != "" {
+			span.SetName(info.API)
+		}
+		span.SetAttribute("s3adapter.request_id", info.RequestID)
+		span.SetAttribute("s3adapter.app", info.App)
+		span.SetAttribute("s3adapter.engine", info.Engine)
+		span.SetAttribute("s3.bucket", info.Bucket)
+		if info.ErrorCode != "" {
+			span.SetError(errors.New(info.ErrorCode))
+		} else if sw.status() >= http.StatusInternalServerError {
+			span.SetError(errors.New(http.StatusText(sw.status())))
+		}
 	})
 }
+
+// metricsMiddleware 记录请求数、错误码、处理时间和收发的字节数，
+// 需要在 requestMiddleware 之后，标签在 handler 中填写
+func metricsMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		start := time.Now()
+
+		body := &countingReader{ReadCloser: r.Body}
+		if r.Body != nil {
+			r.Body = body
+		}
+		sw := &statusWriter{ResponseWriter: w}
+
+		next.ServeHTTP(sw, r)
+
+		info := reqinfo.FromContext(r.Context())
+		if info == nil {
+			return
+		}
+
+		metrics.Requests.Inc(info.App, info.Engine, info.API, strconv.Itoa(sw.status()))
+		if info.ErrorCode != "" {
+			metrics.Errors.Inc(info.App, info.Engine, info.API, info.ErrorCode)
+		}
+		metrics.RequestDuration.Observe(metrics.Since(start), info.App, info.Engine, info.API)
+		metrics.ReceivedBytes.Add(float64(body.n), info.App, info.Engine, info.API)
+		metrics.SentBytes.Add(float64(sw.n), info.App, info.Engine, info.API)
+	})
+}
+
+// statusWriter 记录状态码、写入的字节数和开始发送响应的时间
+type statusWriter struct {
+	http.ResponseWriter
+	code int
+	n    int64
+
+	header time.Time
+}
+
+func (w *statusWriter) WriteHeader(code int) {
+	if w.code == 0 {
+		w.code = code
+		w.header = time.Now()
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *statusWriter) Write(b []byte) (int, error) {
+	if w.code == 0 {
+		w.code = http.StatusOK
+		w.header = time.Now()
+	}
+	n, err := w.ResponseWriter.Write(b)
+	w.n += int64(n)
+	return n, err
+}
+
+func (w *statusWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *statusWriter) status() int {
+	if w.code == 0 {
+		return http.StatusOK
+	}
+	return w.code
+}
+
+// countingReader 记录读取的字节数
+type countingReader struct {
+	io.ReadCloser
+	n int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	r.n += int64(n)
+	return n, err
+}
+
+// accessLogMiddleware 以 S3 服务器访问日志的格式记录请求，没有配置访问日志时不记录
+func (a *API) accessLogMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		if a.AccessLog == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		start := time.Now()
+
+		body := &countingReader{ReadCloser: r.Body}
+		if r.Body != nil {
+			r.Body = body
+		}
+		sw := &statusWriter{ResponseWriter: w}
+
+		next.ServeHTTP(sw, r)
+
+		e := accesslog.Entry{
+			Time:           start,
+			RemoteIP:       a.Network.clientIP(r),
+			RequestURI:     r.Method + " " + r.RequestURI + " " + r.Proto,
+			Status:         sw.status(),
+			BytesSent:      sw.n,
+			ObjectSize:     objectSize(r, sw, body.n),
+			TotalTime:      time.Since(start),
+			TurnAroundTime: -1,
+			Referrer:       r.Referer(),
+			UserAgent:      r.UserAgent(),
+		}
+		if !sw.header.IsZero() {
+			e.TurnAroundTime = sw.header.Sub(start)
+		}
+		if info := reqinfo.FromContext(r.Context()); info != nil {
+			e.BucketOwner = info.AccessKey
+			e.Requester = info.AccessKey
+			e.Bucket = info.Bucket
+			e.Key = info.Object
+			e.RequestID = info.RequestID
+			e.ErrorCode = info.ErrorCode
+			e.Operation = accesslog.Operation(r.Method, info.Bucket, info.Object, info.API == "CopyObject")
+		}
+
+		if err := a.AccessLog.Log(e); err != nil {
+			reqinfo.ZError(r.Context()).Msg("[AccessLog] error:" + err.Error())
+		}
+	})
+}
+
+// remoteIP 客户端的 IP，去掉端口
+func remoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// objectSize 对象的大小，PutObject 为请求体大小，GetObject、HeadObject 为响应的对象大小，其他请求返回 -1
+func objectSize(r *http.Request, w *statusWriter, received int64) int64 {
+
+	switch r.Method {
+	case http.MethodPut:
+		if r.Header.Get("X-Amz-Copy-Source") == "" {
+			return received
+		}
+	case http.MethodGet, http.MethodHead:
+		// Range 请求的对象大小在 Content-Range 中，比如 bytes 0-99/1000
+		if cr := w.Header().Get("Content-Range"); cr != "" {
+			if i := strings.LastIndex(cr, "/"); i >= 0 {
+				if n, err := strconv.ParseInt(cr[i+1:], 10, 64); err == nil {
+					return n
+				}
+			}
+		}
+		if w.status() == http.StatusOK {
+			if n, err := strconv.ParseInt(w.Header().Get("Content-Length"), 10, 64); err == nil {
+				return n
+			}
+		}
+	}
+	return -1
+}
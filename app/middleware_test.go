@@ -0,0 +1,119 @@
+package app
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/solution9th/S3Adapter/internal/accesslog"
+	"github.com/solution9th/S3Adapter/internal/gerror"
+	"github.com/solution9th/S3Adapter/internal/metrics"
+	"github.com/solution9th/S3Adapter/internal/reqinfo"
+	"github.com/solution9th/S3Adapter/internal/trace"
+
+	"github.com/gorilla/mux"
+)
+
+func TestMetricsMiddleware(t *testing.T) {
+
+	r := mux.NewRouter()
+	r.Use(requestMiddleware)
+	r.Use(metricsMiddleware)
+	r.HandleFunc("/{bucket}/{object:.+}", func(w http.ResponseWriter, r *http.Request) {
+		ctx := newContext(w, r, "PutObject")
+		ri := reqinfo.FromContext(ctx)
+		ri.App, ri.Engine = "metrics-app", "s3"
+
+		ioutil.ReadAll(r.Body)
+		writeErrorResponseXML(ctx, w, gerror.GetError(gerror.ErrNoSuchBucket, nil))
+	})
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodPut, "/bucket/object", strings.NewReader("hello")))
+
+	if v := metrics.Requests.Value("metrics-app", "s3", "PutObject", "404"); v != 1 {
+		t.Fatalf("requests: got %v, want 1", v)
+	}
+	if v := metrics.Errors.Value("metrics-app", "s3", "PutObject", "NoSuchBucket"); v != 1 {
+		t.Fatalf("errors: got %v, want 1", v)
+	}
+	if v := metrics.ReceivedBytes.Value("metrics-app", "s3", "PutObject"); v != 5 {
+		t.Fatalf("received bytes: got %v, want 5", v)
+	}
+	if v := metrics.SentBytes.Value("metrics-app", "s3", "PutObject"); v != float64(w.Body.Len()) {
+		t.Fatalf("sent bytes: got %v, want %d", v, w.Body.Len())
+	}
+	if n := metrics.RequestDuration.Count("metrics-app", "s3", "PutObject"); n != 1 {
+		t.Fatalf("duration count: got %d, want 1", n)
+	}
+}
+
+func TestTraceMiddleware(t *testing.T) {
+
+	trace.SetExporter(trace.NewExporter("http://127.0.0.1:1", "s3adapter", time.Hour))
+	defer trace.SetExporter(nil)
+
+	var sc trace.SpanContext
+
+	r := mux.NewRouter()
+	r.Use(requestMiddleware)
+	r.Use(traceMiddleware)
+	r.HandleFunc("/{bucket}", func(w http.ResponseWriter, r *http.Request) {
+		newContext(w, r, "HeadBucket")
+		sc = trace.SpanContextFrom(r.Context())
+	})
+
+	req := httptest.NewRequest(http.MethodHead, "/bucket", nil)
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	r.ServeHTTP(httptest.NewRecorder(), req)
+
+	if sc.TraceID.String() != "4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Fatalf("trace id: got %s", sc.TraceID)
+	}
+	if sc.SpanID.String() == "00f067aa0ba902b7" {
+		t.Fatal("server span not started")
+	}
+}
+
+func TestAccessLogMiddleware(t *testing.T) {
+
+	var buf bytes.Buffer
+	a := &API{AccessLog: accesslog.New(&buf)}
+
+	r := mux.NewRouter()
+	r.Use(requestMiddleware)
+	r.Use(a.accessLogMiddleware)
+	r.HandleFunc("/{bucket}/{object:.+}", func(w http.ResponseWriter, r *http.Request) {
+		ctx := newContext(w, r, "GetObject")
+		reqinfo.FromContext(ctx).AccessKey = "AKIDEXAMPLE"
+		w.Header().Set("Content-Range", "bytes 0-4/1000")
+		w.WriteHeader(http.StatusPartialContent)
+		w.Write([]byte("hello"))
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/bucket/a/b.txt", nil)
+	req.RemoteAddr = "192.0.2.3:51234"
+	req.Header.Set("User-Agent", "aws-sdk-go/1.19.0")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	fields := strings.Fields(buf.String())
+	want := []string{"AKIDEXAMPLE", "bucket"}
+	if fields[0] != want[0] || fields[1] != want[1] {
+		t.Fatalf("log: %s", buf.String())
+	}
+	want = []string{"192.0.2.3", "AKIDEXAMPLE", w.Header().Get(responseRequestIDKey), "REST.GET.OBJECT", "a/b.txt",
+		`"GET`, "/bucket/a/b.txt", `HTTP/1.1"`, "206", "-", "5", "1000"}
+	for i, f := range want {
+		if fields[4+i] != f {
+			t.Fatalf("field %d: got %s, want %s\n%s", 4+i, fields[4+i], f, buf.String())
+		}
+	}
+	if !strings.HasSuffix(buf.String(), "\"-\" \"aws-sdk-go/1.19.0\" -\n") {
+		t.Fatalf("log: %s", buf.String())
+	}
+}
@@ -0,0 +1,85 @@
+package app
+
+import (
+	"github.com/solution9th/S3Adapter/internal"
+	"github.com/solution9th/S3Adapter/internal/auth"
+	"github.com/solution9th/S3Adapter/internal/db"
+	"github.com/solution9th/S3Adapter/internal/gateway"
+	"github.com/solution9th/S3Adapter/internal/gateway/mirror"
+)
+
+// MirrorConfiguration 镜像引擎配置，主引擎为应用本身的引擎
+type MirrorConfiguration struct {
+	AccessKey string `xml:"AccessKey"`
+	SecretKey string `xml:"SecretKey"`
+	Region    string `xml:"Region"`
+	Engine    string `xml:"Engine"`
+}
+
+func (m *MirrorConfiguration) validate() bool {
+
+	if m.Engine == "" || m.AccessKey == "" || m.SecretKey == "" || m.Region == "" {
+		return false
+	}
+
+	_, ok := internal.GatewayMap[m.Engine]
+	return ok
+}
+
+func (a *API) saveMirror(oak string, m *MirrorConfiguration) error {
+
+	data := make(map[string]interface{})
+	data["os_access_key"] = oak
+	data["engine"] = m.Engine
+	data["region"] = m.Region
+	data["access_key"] = m.AccessKey
+	data["secret_key"] = m.SecretKey
+
+	_, err := a.DB.SaveMirror(data)
+	return err
+}
+
+// newMirror 应用有镜像配置时，把主引擎和镜像引擎组合成镜像存储
+func (a *API) newMirror(oak string, primary gateway.S3Protocol) (gateway.S3Protocol, error) {
+
+	m, err := a.DB.GetMirror(oak)
+	if err == db.ErrNotFound {
+		return primary, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	secondary, err := a.Pool.Get(oak, m.Engine, auth.Credentials{
+		AccessKey: m.AccessKey, SecretKey: m.SecretKey},
+		m.Region)
+	if err != nil {
+		return nil, err
+	}
+
+	return mirror.New(oak, primary, secondary, a.DB), nil
+}
+
+// resolveMirror 后台修复时根据 oak 获得镜像存储，没有镜像配置时返回 nil
+func (a *API) resolveMirror(oak string) (*mirror.Mirror, error) {
+
+	_, ak, sk, engine, region := a.getSecretKeyEngine(oak)
+	if engine == "" {
+		return nil, errApplicationNotFound
+	}
+
+	primary, err := a.Pool.Get(oak, engine, auth.Credentials{
+		AccessKey: ak, SecretKey: sk},
+		region)
+	if err != nil {
+		return nil, err
+	}
+
+	g, err := a.newMirror(oak, primary)
+	if err != nil {
+		return nil, err
+	}
+
+	m, _ := g.(*mirror.Mirror)
+	return m, nil
+}
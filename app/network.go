@@ -0,0 +1,304 @@
+package app
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/solution9th/S3Adapter/internal/config"
+	"github.com/solution9th/S3Adapter/internal/db"
+	"github.com/solution9th/S3Adapter/internal/gerror"
+	"github.com/solution9th/S3Adapter/internal/metrics"
+	"github.com/solution9th/S3Adapter/internal/reqinfo"
+
+	"github.com/haozibi/zlog"
+)
+
+// Network 可信代理，请求来自可信代理时从 X-Forwarded-For 读取客户端 IP
+type Network struct {
+	trustedProxies []*net.IPNet
+}
+
+// NewNetwork 解析可信代理，没有配置时返回 nil，直接使用连接的地址
+func NewNetwork(cfg config.Network) (*Network, error) {
+
+	if len(cfg.TrustedProxies) == 0 {
+		return nil, nil
+	}
+
+	nets, err := parseCIDRs(cfg.TrustedProxies)
+	if err != nil {
+		return nil, err
+	}
+	return &Network{trustedProxies: nets}, nil
+}
+
+// clientIP 客户端的 IP，连接来自可信代理时从右向左取 X-Forwarded-For 中第一个不是可信代理的地址
+func (n *Network) clientIP(r *http.Request) string {
+
+	ip := remoteIP(r)
+	if n == nil || !containsIP(n.trustedProxies, ip) {
+		return ip
+	}
+
+	var hops []string
+	for _, v := range r.Header.Values("X-Forwarded-For") {
+		for _, hop := range strings.Split(v, ",") {
+			hops = append(hops, strings.TrimSpace(hop))
+		}
+	}
+
+	for i := len(hops) - 1; i >= 0; i-- {
+		// 格式不正确时不再相信更前面的地址
+		if net.ParseIP(hops[i]) == nil {
+			break
+		}
+		ip = hops[i]
+		if !containsIP(n.trustedProxies, ip) {
+			break
+		}
+	}
+	return ip
+}
+
+// parseCIDRs 解析 CIDR 列表，单个 IP 作为 /32 或者 /128
+func parseCIDRs(list []string) ([]*net.IPNet, error) {
+
+	nets := make([]*net.IPNet, 0, len(list))
+	for _, s := range list {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		if !strings.Contains(s, "/") {
+			ip := net.ParseIP(s)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid ip %q", s)
+			}
+			bits := 8 * net.IPv6len
+			if ip4 := ip.To4(); ip4 != nil {
+				ip, bits = ip4, 8*net.IPv4len
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, n, err := net.ParseCIDR(s)
+		if err != nil {
+			return nil, err
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+func containsIP(nets []*net.IPNet, s string) bool {
+
+	ip := net.ParseIP(s)
+	if ip == nil {
+		return false
+	}
+	for _, n := range nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// splitCIDRs 数据库中逗号分隔的 CIDR
+func splitCIDRs(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(s, ",")
+}
+
+// checkNetwork 检查客户端 IP 是否满足应用的网络限制，先检查 deny，allow 不为空时必须在其中，
+// 拒绝时记录审计日志
+func (a *API) checkNetwork(r *http.Request, info *authInfo) gerror.APIErrorCode {
+
+	if info.allow == "" && info.deny == "" {
+		return gerror.ErrNone
+	}
+
+	ip := a.Network.clientIP(r)
+
+	allow, err := parseCIDRs(splitCIDRs(info.allow))
+	if err != nil {
+		zlog.ZError().Str("OAK", info.id).Msg("[Network] invalid rule: " + err.Error())
+		return gerror.ErrAccessDenied
+	}
+	deny, err := parseCIDRs(splitCIDRs(info.deny))
+	if err != nil {
+		zlog.ZError().Str("OAK", info.id).Msg("[Network] invalid rule: " + err.Error())
+		return gerror.ErrAccessDenied
+	}
+
+	reason := ""
+	if containsIP(deny, ip) {
+		reason = "deny"
+	} else if len(allow) > 0 && !containsIP(allow, ip) {
+		reason = "not_allowed"
+	}
+	if reason == "" {
+		return gerror.ErrNone
+	}
+
+	metrics.NetworkDenied.Inc(info.app)
+	reqinfo.ZWarn(r.Context()).
+		Str("App", info.app).
+		Str("OAK", info.id).
+		Str("AK", info.oak).
+		Str("User", info.user).
+		Str("ClientIP", ip).
+		Str("RemoteAddr", r.RemoteAddr).
+		Str("XForwardedFor", r.Header.Get("X-Forwarded-For")).
+		Str("Reason", reason).
+		Msg("[Audit] network denied")
+	return gerror.ErrAccessDenied
+}
+
+// NetworkConfiguration 应用的网络限制，Allow 和 Deny 都为空时不限制
+type NetworkConfiguration struct {
+	// ApplicationAccessKey 应用任意一个 key，GetNetwork 不返回
+	ApplicationAccessKey string `xml:"ApplicationAccessKey,omitempty"`
+
+	Allow []string `xml:"Allow"`
+	Deny  []string `xml:"Deny"`
+}
+
+// PutNetwork 设置应用允许和拒绝的客户端 CIDR，覆盖之前的设置，需要使用管理 key 签名
+//
+// 请求:
+//
+//	PUT /?network
+//	<NetworkConfiguration>
+//		<ApplicationAccessKey></ApplicationAccessKey>
+//		<Allow>10.0.0.0/8</Allow>
+//		<Deny>10.0.1.0/24</Deny>
+//	</NetworkConfiguration>
+func (a *API) PutNetwork(w http.ResponseWriter, r *http.Request) {
+
+	ctx := newContext(w, r, "PutNetwork")
+
+	reqinfo.ZDebug(ctx).Str("Method", "PutNetwork").Msg("[debug]")
+
+	if errCode := a.authAdmin(r); errCode != gerror.ErrNone {
+		writeErrorResponseXML(ctx, w,
+			gerror.GetError(errCode, nil))
+		return
+	}
+
+	var c NetworkConfiguration
+	if r.Body != nil {
+		err := xml.NewDecoder(r.Body).Decode(&c)
+		if err != nil {
+			reqinfo.ZError(ctx).Str("Method", "XMLDecode").Msg(err.Error())
+			writeErrorResponseXML(ctx, w,
+				gerror.GetError(gerror.ErrMalformedXML, err))
+			return
+		}
+	}
+
+	if errCode := a.putNetwork(ctx, c); errCode != gerror.ErrNone {
+		writeErrorResponseXML(ctx, w,
+			gerror.GetError(errCode, nil))
+		return
+	}
+
+	writeSuccessResponseHeadersOnly(w)
+}
+
+// GetNetwork 查看应用的网络限制，需要使用管理 key 签名
+//
+// 请求:
+//
+//	GET /?network&accessKey=<应用任意一个 key>
+func (a *API) GetNetwork(w http.ResponseWriter, r *http.Request) {
+
+	ctx := newContext(w, r, "GetNetwork")
+
+	reqinfo.ZDebug(ctx).Str("Method", "GetNetwork").Msg("[debug]")
+
+	if errCode := a.authAdmin(r); errCode != gerror.ErrNone {
+		writeErrorResponseXML(ctx, w,
+			gerror.GetError(errCode, nil))
+		return
+	}
+
+	id, errCode := a.lookupAppID(ctx, r.URL.Query().Get("accessKey"))
+	if errCode != gerror.ErrNone {
+		writeErrorResponseXML(ctx, w,
+			gerror.GetError(errCode, nil))
+		return
+	}
+
+	var result NetworkConfiguration
+	n, err := db.WithContext(ctx, a.DB).GetNetwork(id)
+	if err != nil && err != db.ErrNotFound {
+		reqinfo.ZError(ctx).Str("Method", "GetNetwork").Msg(err.Error())
+		writeErrorResponseXML(ctx, w,
+			gerror.GetError(gerror.ErrInternalError, nil))
+		return
+	}
+	result.Allow, result.Deny = splitCIDRs(n.Allow), splitCIDRs(n.Deny)
+
+	formatWriteXML(w, http.StatusOK, "", result, true)
+}
+
+func (a *API) putNetwork(ctx context.Context, c NetworkConfiguration) gerror.APIErrorCode {
+
+	id, errCode := a.lookupAppID(ctx, c.ApplicationAccessKey)
+	if errCode != gerror.ErrNone {
+		return errCode
+	}
+
+	allow, err := normalizeCIDRs(c.Allow)
+	if err != nil {
+		zlog.ZWarn().Str("OAK", id).Msg("[Admin] invalid network rule: " + err.Error())
+		return gerror.ErrInvalidRequestParameter
+	}
+	deny, err := normalizeCIDRs(c.Deny)
+	if err != nil {
+		zlog.ZWarn().Str("OAK", id).Msg("[Admin] invalid network rule: " + err.Error())
+		return gerror.ErrInvalidRequestParameter
+	}
+
+	keys, err := a.appKeys(ctx, id)
+	if err != nil {
+		return gerror.ErrInternalError
+	}
+
+	err = db.WithContext(ctx, a.DB).SaveNetwork(db.Network{OsAccessKey: id, Allow: allow, Deny: deny})
+	if err != nil {
+		zlog.ZError().Str("Method", "saveNetwork").Msg(err.Error())
+		return gerror.ErrInternalError
+	}
+
+	// 缓存的应用信息中有网络限制
+	a.Cache.Remove(id)
+	for _, k := range keys {
+		a.Cache.Remove(k)
+	}
+
+	zlog.ZInfo().Str("OAK", id).Str("Allow", allow).Str("Deny", deny).Msg("[Admin] put network")
+	return gerror.ErrNone
+}
+
+// normalizeCIDRs 检查 CIDR 并转换为数据库中逗号分隔的格式
+func normalizeCIDRs(list []string) (string, error) {
+
+	nets, err := parseCIDRs(list)
+	if err != nil {
+		return "", err
+	}
+
+	s := make([]string, 0, len(nets))
+	for _, n := range nets {
+		s = append(s, n.String())
+	}
+	return strings.Join(s, ","), nil
+}
@@ -0,0 +1,143 @@
+package app
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/solution9th/S3Adapter/internal/config"
+	"github.com/solution9th/S3Adapter/internal/db"
+	"github.com/solution9th/S3Adapter/internal/db/mysql"
+	"github.com/solution9th/S3Adapter/internal/gerror"
+	"github.com/solution9th/S3Adapter/internal/metrics"
+	"github.com/solution9th/S3Adapter/mocks/mock_db"
+
+	"github.com/golang/mock/gomock"
+	"github.com/gorilla/mux"
+	"github.com/smartystreets/goconvey/convey"
+)
+
+func TestClientIP(t *testing.T) {
+
+	convey.Convey("clientIP", t, func() {
+
+		n, err := NewNetwork(config.Network{TrustedProxies: []string{"10.0.0.1", "172.16.0.0/12"}})
+		convey.So(err, convey.ShouldBeNil)
+
+		cases := []struct {
+			remote string
+			xff    []string
+			want   string
+		}{
+			{"192.0.2.3:1234", nil, "192.0.2.3"},
+			// 不是可信代理时不读取 X-Forwarded-For
+			{"192.0.2.3:1234", []string{"198.51.100.1"}, "192.0.2.3"},
+			{"10.0.0.1:1234", []string{"198.51.100.1"}, "198.51.100.1"},
+			{"10.0.0.1:1234", []string{"1.1.1.1, 198.51.100.1, 172.16.0.5"}, "198.51.100.1"},
+			{"10.0.0.1:1234", []string{"1.1.1.1", "198.51.100.1, 172.16.0.5"}, "198.51.100.1"},
+			{"10.0.0.1:1234", []string{"198.51.100.1, bad, 172.16.0.5"}, "172.16.0.5"},
+			{"10.0.0.1:1234", []string{"172.16.0.5"}, "172.16.0.5"},
+			{"10.0.0.1:1234", nil, "10.0.0.1"},
+		}
+		for _, c := range cases {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.RemoteAddr = c.remote
+			for _, v := range c.xff {
+				req.Header.Add("X-Forwarded-For", v)
+			}
+			convey.So(n.clientIP(req), convey.ShouldEqual, c.want)
+		}
+
+		// 没有配置可信代理
+		var none *Network
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.RemoteAddr = "10.0.0.1:1234"
+		req.Header.Set("X-Forwarded-For", "198.51.100.1")
+		convey.So(none.clientIP(req), convey.ShouldEqual, "10.0.0.1")
+
+		_, err = NewNetwork(config.Network{TrustedProxies: []string{"10.0.0.300"}})
+		convey.So(err, convey.ShouldNotBeNil)
+	})
+}
+
+func TestNetworkRules(t *testing.T) {
+
+	convey.Convey("network rules", t, func() {
+
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		mockDB := mock_db.NewMockDB(ctrl)
+		network, _ := NewNetwork(config.Network{TrustedProxies: []string{"10.0.0.1"}})
+		a := &API{DB: mockDB, Network: network, Admin: NewAdmin(config.Admin{AccessKey: "admin", SecretKey: "admin-secret"})}
+
+		app := mysql.Info{OsAccessKey: "oak", OsScrectKey: "osk", EngineType: "s3", EngineAccessKey: "ak", EngineSecretKey: "sk", AppName: "photos",
+			NetworkAllow: "192.168.0.0/16,10.0.0.0/8", NetworkDeny: "192.168.1.0/24"}
+		mockDB.EXPECT().GetInfo("oak").Return(app, nil).AnyTimes()
+
+		convey.Convey("Auth", func() {
+			request := func(remote, xff string) *http.Request {
+				req := objectRequest("1.jpg", "oak", "osk", "")
+				req.RemoteAddr = remote
+				if xff != "" {
+					req.Header.Set("X-Forwarded-For", xff)
+				}
+				return req
+			}
+
+			denied := metrics.NetworkDenied.Value("photos")
+
+			convey.So(a.Auth(request("192.168.2.3:1234", "")), convey.ShouldEqual, gerror.ErrNone)
+			convey.So(a.Auth(request("10.0.0.1:1234", "192.168.2.3")), convey.ShouldEqual, gerror.ErrNone)
+
+			convey.So(a.Auth(request("192.168.1.3:1234", "")), convey.ShouldEqual, gerror.ErrAccessDenied)
+			convey.So(a.Auth(request("203.0.113.1:1234", "")), convey.ShouldEqual, gerror.ErrAccessDenied)
+			convey.So(a.Auth(request("10.0.0.1:1234", "203.0.113.1")), convey.ShouldEqual, gerror.ErrAccessDenied)
+			// 不是可信代理时伪造的 X-Forwarded-For 不起作用
+			convey.So(a.Auth(request("203.0.113.1:1234", "192.168.2.3")), convey.ShouldEqual, gerror.ErrAccessDenied)
+
+			convey.So(metrics.NetworkDenied.Value("photos")-denied, convey.ShouldEqual, 4)
+		})
+
+		convey.Convey("PutNetwork and GetNetwork", func() {
+			r := mux.NewRouter()
+			NewAPIRouter(r, a)
+
+			do := func(method, target, body string) *httptest.ResponseRecorder {
+				req := httptest.NewRequest(method, target, strings.NewReader(body))
+				signRequest(req, "admin", "admin-secret")
+				w := httptest.NewRecorder()
+				r.ServeHTTP(w, req)
+				return w
+			}
+
+			mockDB.EXPECT().ListKey("oak").Return(nil, nil)
+			mockDB.EXPECT().ListUser("oak").Return(nil, nil)
+			mockDB.EXPECT().ListSession("oak").Return(nil, nil)
+			mockDB.EXPECT().ListClientCert("oak").Return(nil, nil)
+			mockDB.EXPECT().SaveNetwork(db.Network{OsAccessKey: "oak", Allow: "10.0.0.0/8,192.0.2.7/32", Deny: ""}).Return(nil)
+
+			w := do(http.MethodPut, "/?network", "<NetworkConfiguration><ApplicationAccessKey>oak</ApplicationAccessKey>"+
+				"<Allow>10.1.2.3/8</Allow><Allow>192.0.2.7</Allow></NetworkConfiguration>")
+			convey.So(w.Code, convey.ShouldEqual, http.StatusOK)
+
+			w = do(http.MethodPut, "/?network", "<NetworkConfiguration><ApplicationAccessKey>oak</ApplicationAccessKey><Deny>10.0.0.0/33</Deny></NetworkConfiguration>")
+			convey.So(w.Code, convey.ShouldEqual, http.StatusBadRequest)
+
+			mockDB.EXPECT().GetNetwork("oak").Return(db.Network{OsAccessKey: "oak", Allow: "10.0.0.0/8,192.0.2.7/32"}, nil)
+			w = do(http.MethodGet, "/?network&accessKey=oak", "")
+			convey.So(w.Code, convey.ShouldEqual, http.StatusOK)
+			convey.So(w.Body.String(), convey.ShouldContainSubstring, "<Allow>10.0.0.0/8</Allow><Allow>192.0.2.7/32</Allow>")
+			convey.So(w.Body.String(), convey.ShouldNotContainSubstring, "<Deny>")
+			convey.So(w.Body.String(), convey.ShouldNotContainSubstring, "ApplicationAccessKey")
+
+			// 需要管理 key
+			req := httptest.NewRequest(http.MethodGet, "/?network&accessKey=oak", nil)
+			signRequest(req, "oak", "osk")
+			w = httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+			convey.So(w.Code, convey.ShouldEqual, http.StatusForbidden)
+		})
+	})
+}
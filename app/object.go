@@ -4,12 +4,12 @@ import (
 	"net/http"
 
 	"github.com/solution9th/S3Adapter/internal/gerror"
+	"github.com/solution9th/S3Adapter/internal/reqinfo"
 	"github.com/solution9th/S3Adapter/internal/to"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/gorilla/mux"
-	"github.com/haozibi/zlog"
 )
 
 // HeadObject https://docs.aws.amazon.com/zh_cn/AmazonS3/latest/API/RESTObjectHEAD.html
@@ -26,7 +26,7 @@ func (a *API) HeadObject(w http.ResponseWriter, r *http.Request) {
 	bucket := vars["bucket"]
 	object := vars["object"]
 
-	zlog.ZDebug().Str("Object", bucket).Str("Method", "HeadObject").Msg("[debug]")
+	reqinfo.ZDebug(ctx).Str("Object", bucket).Str("Method", "HeadObject").Msg("[debug]")
 
 	if errCode := a.Auth(r); errCode != gerror.ErrNone {
 		if errCode == gerror.ErrRequestTimeTooSkewed {
@@ -48,7 +48,7 @@ func (a *API) HeadObject(w http.ResponseWriter, r *http.Request) {
 	input := &s3.HeadObjectInput{}
 	errName, err := to.UnmarshalRequest(ctx, r, input)
 	if err != nil {
-		zlog.ZInfo().Str("Method", "UnmarshalRequest").Str("Field", errName).Msg("[To]" + err.Error())
+		reqinfo.ZInfo(ctx).Str("Method", "UnmarshalRequest").Str("Field", errName).Msg("[To]" + err.Error())
 		// writeErrorResponseXML(ctx, w,
 		// 	gerror.GetError(gerror.ErrInvalidRequestParameter, nil))
 		// return
@@ -87,7 +87,7 @@ func (a *API) PutObject(w http.ResponseWriter, r *http.Request) {
 	bucket := vars["bucket"]
 	object := vars["object"]
 
-	zlog.ZDebug().Str("Object", bucket).Str("Method", "PutObject").Msg("[debug]")
+	reqinfo.ZDebug(ctx).Str("Object", bucket).Str("Method", "PutObject").Msg("[debug]")
 
 	if errCode := a.Auth(r); errCode != gerror.ErrNone {
 		if errCode == gerror.ErrRequestTimeTooSkewed {
@@ -142,7 +142,7 @@ func (a *API) GetObject(w http.ResponseWriter, r *http.Request) {
 	bucket := vars["bucket"]
 	object := vars["object"]
 
-	zlog.ZDebug().Str("Object", bucket).Str("Method", "GetObject").Msg("[debug]")
+	reqinfo.ZDebug(ctx).Str("Object", bucket).Str("Method", "GetObject").Msg("[debug]")
 
 	if errCode := a.Auth(r); errCode != gerror.ErrNone {
 		if errCode == gerror.ErrRequestTimeTooSkewed {
@@ -164,7 +164,7 @@ func (a *API) GetObject(w http.ResponseWriter, r *http.Request) {
 	input := &s3.GetObjectInput{}
 	errName, err := to.UnmarshalRequest(ctx, r, input)
 	if err != nil {
-		zlog.ZInfo().Str("Method", "UnmarshalRequest").Str("Field", errName).Msg("[To]" + err.Error())
+		reqinfo.ZInfo(ctx).Str("Method", "UnmarshalRequest").Str("Field", errName).Msg("[To]" + err.Error())
 		// writeErrorResponseXML(ctx, w,
 		// 	gerror.GetError(gerror.ErrInvalidRequestParameter, nil))
 		// return
@@ -200,7 +200,7 @@ func (a *API) DeleteObject(w http.ResponseWriter, r *http.Request) {
 	bucket := vars["bucket"]
 	object := vars["object"]
 
-	zlog.ZDebug().Str("Object", bucket).Str("Method", "DeleteObject").Msg("[debug]")
+	reqinfo.ZDebug(ctx).Str("Object", bucket).Str("Method", "DeleteObject").Msg("[debug]")
 
 	if errCode := a.Auth(r); errCode != gerror.ErrNone {
 		if errCode == gerror.ErrRequestTimeTooSkewed {
@@ -222,7 +222,7 @@ func (a *API) DeleteObject(w http.ResponseWriter, r *http.Request) {
 	input := &s3.DeleteObjectInput{}
 	errName, err := to.UnmarshalRequest(ctx, r, input)
 	if err != nil {
-		zlog.ZInfo().Str("Method", "UnmarshalRequest").Str("Field", errName).Msg("[To]" + err.Error())
+		reqinfo.ZInfo(ctx).Str("Method", "UnmarshalRequest").Str("Field", errName).Msg("[To]" + err.Error())
 	}
 	input.Bucket = aws.String(bucket)
 	input.Key = aws.String(object)
@@ -257,7 +257,7 @@ func (a *API) CopyObject(w http.ResponseWriter, r *http.Request) {
 	bucket := vars["bucket"]
 	object := vars["object"]
 
-	zlog.ZDebug().Str("Object", bucket).Str("Method", "CopyObject").Msg("[debug]")
+	reqinfo.ZDebug(ctx).Str("Object", bucket).Str("Method", "CopyObject").Msg("[debug]")
 
 	if errCode := a.Auth(r); errCode != gerror.ErrNone {
 		if errCode == gerror.ErrRequestTimeTooSkewed {
@@ -279,7 +279,7 @@ func (a *API) CopyObject(w http.ResponseWriter, r *http.Request) {
 	input := &s3.CopyObjectInput{}
 	errName, err := to.UnmarshalRequest(ctx, r, input)
 	if err != nil {
-		zlog.ZInfo().Str("Method", "UnmarshalRequest").Str("Field", errName).Msg("[To]" + err.Error())
+		reqinfo.ZInfo(ctx).Str("Method", "UnmarshalRequest").Str("Field", errName).Msg("[To]" + err.Error())
 	}
 	input.Bucket = aws.String(bucket)
 	input.Key = aws.String(object)
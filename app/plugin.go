@@ -0,0 +1,28 @@
+package app
+
+import (
+	"errors"
+
+	"github.com/solution9th/S3Adapter/internal"
+	"github.com/solution9th/S3Adapter/internal/config"
+	"github.com/solution9th/S3Adapter/internal/gateway/plugin"
+
+	"github.com/haozibi/zlog"
+)
+
+var errInvalidPlugin = errors.New("plugin need name and address")
+
+// registerPlugins 把配置文件中的插件注册为引擎
+func registerPlugins(plugins []config.Plugin) error {
+
+	for _, p := range plugins {
+		if p.Name == "" || p.Address == "" {
+			return errInvalidPlugin
+		}
+		if err := internal.RegisterGateway(p.Name, plugin.New(p.Name, p.Address, p.Timeout)); err != nil {
+			return errors.New(p.Name + ": " + err.Error())
+		}
+		zlog.ZInfo().Str("Name", p.Name).Str("Address", p.Address).Msg("[Plugin] register")
+	}
+	return nil
+}
@@ -0,0 +1,116 @@
+package app
+
+import (
+	"net/http"
+
+	"github.com/solution9th/S3Adapter/internal/gateway"
+	"github.com/solution9th/S3Adapter/internal/gerror"
+	"github.com/solution9th/S3Adapter/internal/policy"
+	"github.com/solution9th/S3Adapter/internal/reqinfo"
+
+	"github.com/gorilla/mux"
+	"github.com/haozibi/zlog"
+)
+
+// permission 请求需要的一个权限，允许 resources 中任意一个即可
+type permission struct {
+	action    string
+	resources []string
+}
+
+// permissions 返回方法 api 需要的权限，不支持的方法返回 false，
+// 比如删除应用只能使用应用自己的 key
+func permissions(api string, r *http.Request) ([]permission, bool) {
+
+	vars := mux.Vars(r)
+	bucket, object := vars["bucket"], vars["object"]
+
+	one := func(action string, resources ...string) ([]permission, bool) {
+		return []permission{{action, resources}}, true
+	}
+
+	switch api {
+	case "GetCapabilities":
+		return nil, true
+	case "ListBuckets":
+		return one("s3:ListAllMyBuckets", policy.Resource("", ""))
+	case "HeadBucket", "GetBucketV1", "GetBucketV2":
+		// 列出前缀时，允许访问前缀下对象的用户也可以列出
+		resources := []string{policy.Resource(bucket, "")}
+		if prefix := r.URL.Query().Get("prefix"); prefix != "" {
+			resources = append(resources, policy.Resource(bucket, prefix))
+		}
+		return one("s3:ListBucket", resources...)
+	case "PutBucket":
+		return one("s3:CreateBucket", policy.Resource(bucket, ""))
+	case "DeleteBucket":
+		return one("s3:DeleteBucket", policy.Resource(bucket, ""))
+	case "HeadObject", "GetObject":
+		return one("s3:GetObject", policy.Resource(bucket, object))
+	case "PutObject":
+		return one("s3:PutObject", policy.Resource(bucket, object))
+	case "DeleteObject":
+		return one("s3:DeleteObject", policy.Resource(bucket, object))
+	case "CopyObject":
+		srcBucket, srcObject, ok := gateway.ParseCopySource(r.Header.Get("X-Amz-Copy-Source"))
+		if !ok {
+			return nil, false
+		}
+		return []permission{
+			{"s3:GetObject", []string{policy.Resource(srcBucket, srcObject)}},
+			{"s3:PutObject", []string{policy.Resource(bucket, object)}},
+		}, true
+	}
+	return nil, false
+}
+
+// authorize 签名验证通过后检查应用下用户的身份策略和 STS 临时凭证的会话策略，
+// 两个都有时需要同时允许，应用自己的 key 不限制
+//
+// 用户、临时凭证和客户端证书只能调用 permissions 支持的方法，没有策略时也不能删除应用，
+// 用户(包括用户的临时凭证)没有身份策略时拒绝所有请求
+func (a *API) authorize(r *http.Request, info *authInfo) gerror.APIErrorCode {
+
+	if info.user == "" && info.token == "" && info.identity == "" {
+		return gerror.ErrNone
+	}
+
+	if info.user != "" && info.policy == "" {
+		zlog.ZWarn().Str("OAK", info.oak).Str("User", info.user).Msg("[Policy] user has no policy")
+		return gerror.ErrAccessDenied
+	}
+
+	var policies []*policy.Policy
+	for _, doc := range []string{info.policy, info.sessionPolicy} {
+		if doc == "" {
+			continue
+		}
+		p, err := policy.Parse(doc)
+		if err != nil {
+			zlog.ZError().Str("OAK", info.oak).Str("User", info.user).Msg("[Policy] error: " + err.Error())
+			return gerror.ErrAccessDenied
+		}
+		policies = append(policies, p)
+	}
+
+	var api string
+	if ri := reqinfo.FromContext(r.Context()); ri != nil {
+		api = ri.API
+	}
+
+	perms, ok := permissions(api, r)
+	if !ok {
+		zlog.ZWarn().Str("OAK", info.oak).Str("User", info.user).Str("API", api).Msg("[Policy] api not allowed")
+		return gerror.ErrAccessDenied
+	}
+
+	for _, perm := range perms {
+		for _, p := range policies {
+			if !p.IsAllowed(perm.action, perm.resources...) {
+				zlog.ZWarn().Str("OAK", info.oak).Str("User", info.user).Str("Action", perm.action).Strs("Resource", perm.resources).Msg("[Policy] access denied")
+				return gerror.ErrAccessDenied
+			}
+		}
+	}
+	return gerror.ErrNone
+}
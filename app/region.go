@@ -0,0 +1,87 @@
+package app
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/solution9th/S3Adapter/internal/db"
+	"github.com/solution9th/S3Adapter/internal/sign"
+)
+
+// RegionMapping 以 Region 签名的请求使用 EngineRegion 访问应用的后端引擎
+type RegionMapping struct {
+	Region       string `xml:"Region"`
+	EngineRegion string `xml:"EngineRegion"`
+}
+
+// validRegion 请求签名的地区是否为 GlobalRegion 或者 Regions 中的地区
+func validRegion(region string) bool {
+
+	if region == GlobalRegion {
+		return true
+	}
+	for _, r := range Regions {
+		if r == region {
+			return true
+		}
+	}
+	return false
+}
+
+// validateRegionMappings 映射的地区需要在 Regions 中，不能映射 GlobalRegion，也不能重复
+func validateRegionMappings(list []RegionMapping) bool {
+
+	seen := make(map[string]bool, len(list))
+	for _, m := range list {
+		if m.Region == GlobalRegion || !validRegion(m.Region) || m.EngineRegion == "" || seen[m.Region] {
+			return false
+		}
+		seen[m.Region] = true
+	}
+	return true
+}
+
+func (a *API) saveRegions(oak string, list []RegionMapping) error {
+
+	for _, m := range list {
+		data := make(map[string]interface{})
+		data["os_access_key"] = oak
+		data["region"] = m.Region
+		data["engine_region"] = m.EngineRegion
+
+		if _, err := a.DB.SaveRegion(data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// requestRegion 请求 Authorization 中签名使用的地区
+func requestRegion(r *http.Request) string {
+
+	auth, err := sign.NewAuthSign(r.Header.Get("Authorization"))
+	if err != nil {
+		return ""
+	}
+	return auth.GetRegion()
+}
+
+// engineRegion 以 region 签名的请求访问的后端地区，region 为 GlobalRegion
+// 或者应用没有配置这个地区的映射时为应用本身的地区
+func (a *API) engineRegion(ctx context.Context, info *authInfo, region string) (string, error) {
+
+	if region == "" || region == GlobalRegion {
+		return info.region, nil
+	}
+
+	list, err := db.WithContext(ctx, a.DB).ListRegion(info.id)
+	if err != nil {
+		return "", err
+	}
+	for _, m := range list {
+		if m.Region == region {
+			return m.EngineRegion, nil
+		}
+	}
+	return info.region, nil
+}
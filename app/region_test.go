@@ -0,0 +1,123 @@
+package app
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/solution9th/S3Adapter/internal/config"
+	"github.com/solution9th/S3Adapter/internal/db"
+	"github.com/solution9th/S3Adapter/internal/db/mysql"
+	"github.com/solution9th/S3Adapter/internal/gerror"
+	"github.com/solution9th/S3Adapter/internal/reqinfo"
+	"github.com/solution9th/S3Adapter/internal/sign"
+	"github.com/solution9th/S3Adapter/mocks/mock_db"
+
+	"github.com/golang/mock/gomock"
+	"github.com/gorilla/mux"
+	"github.com/smartystreets/goconvey/convey"
+)
+
+// regionRequest 以 region 签名的 GetObject 请求
+func regionRequest(ak, sk, region string) *http.Request {
+	req := httptest.NewRequest(http.MethodGet, "/photos/1.jpg", nil)
+	now := time.Now().UTC()
+	req.Header.Set("X-Amz-Date", now.Format(sign.TimeISO8601BasicFormat))
+	authStr, _ := sign.NewSignV4(ak, sk, region, req).Signature(now)
+	req.Header.Set("Authorization", authStr)
+	req = mux.SetURLVars(req, map[string]string{"bucket": "photos", "object": "1.jpg"})
+	return req.WithContext(reqinfo.NewContext(req.Context(), &reqinfo.ReqInfo{API: "GetObject"}))
+}
+
+func TestRegions(t *testing.T) {
+
+	convey.Convey("regions", t, func() {
+
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		global, regions := GlobalRegion, Regions
+		GlobalRegion, Regions = "osbeijing", []string{"us-east-1", "osguangzhou"}
+		defer func() { GlobalRegion, Regions = global, regions }()
+
+		mockDB := mock_db.NewMockDB(ctrl)
+		a := &API{DB: mockDB, Admin: NewAdmin(config.Admin{AccessKey: "admin", SecretKey: "admin-secret", Regions: []string{"ap-beijing", "ap-guangzhou"}})}
+
+		app := mysql.Info{OsAccessKey: "oak", OsScrectKey: "osk", EngineType: "cos", EngineAccessKey: "ak", EngineSecretKey: "sk", EngineRegion: "ap-beijing"}
+		mockDB.EXPECT().GetInfo("oak").Return(app, nil).AnyTimes()
+
+		convey.Convey("Auth accepts every configured region", func() {
+			for _, region := range []string{"osbeijing", "us-east-1", "osguangzhou"} {
+				convey.So(a.Auth(regionRequest("oak", "osk", region)), convey.ShouldEqual, gerror.ErrNone)
+			}
+			convey.So(a.Auth(regionRequest("oak", "osk", "eu-west-1")), convey.ShouldEqual, gerror.ErrInvalidRegion)
+		})
+
+		convey.Convey("engineRegion", func() {
+			mockDB.EXPECT().ListRegion("oak").Return([]db.Region{{OsAccessKey: "oak", Region: "osguangzhou", EngineRegion: "ap-guangzhou"}}, nil).Times(2)
+
+			info := a.getAppInfo(context.Background(), "oak")
+			for region, want := range map[string]string{
+				"osbeijing":   "ap-beijing",
+				"":            "ap-beijing",
+				"osguangzhou": "ap-guangzhou",
+				// 没有映射时使用应用本身的地区
+				"us-east-1": "ap-beijing",
+			} {
+				got, err := a.engineRegion(context.Background(), info, region)
+				convey.So(err, convey.ShouldBeNil)
+				convey.So(got, convey.ShouldEqual, want)
+			}
+
+			convey.So(requestRegion(regionRequest("oak", "osk", "osguangzhou")), convey.ShouldEqual, "osguangzhou")
+		})
+
+		convey.Convey("PutApplication with region mappings", func() {
+			r := mux.NewRouter()
+			NewAPIRouter(r, a)
+
+			do := func(mappings string) *httptest.ResponseRecorder {
+				body := "<CreateApplicationConfiguration><AccessKey>123</AccessKey><SecretKey>123</SecretKey><Engine>cos</Engine><Region>ap-beijing</Region>" +
+					mappings + "</CreateApplicationConfiguration>"
+				req := httptest.NewRequest(http.MethodPut, "/", strings.NewReader(body))
+				signRequest(req, "admin", "admin-secret")
+				w := httptest.NewRecorder()
+				r.ServeHTTP(w, req)
+				return w
+			}
+
+			mockDB.EXPECT().CountInfo("123", "123", "cos").Return(0, nil).AnyTimes()
+
+			var saved []map[string]interface{}
+			mockDB.EXPECT().SaveInfo(gomock.Any()).Return(1, nil)
+			mockDB.EXPECT().SaveRegion(gomock.Any()).Do(func(data map[string]interface{}) {
+				saved = append(saved, data)
+			}).Return(1, nil).Times(2)
+
+			w := do("<RegionMapping><Region>osguangzhou</Region><EngineRegion>ap-guangzhou</EngineRegion></RegionMapping>" +
+				"<RegionMapping><Region>us-east-1</Region><EngineRegion>ap-beijing</EngineRegion></RegionMapping>")
+			convey.So(w.Code, convey.ShouldEqual, http.StatusOK)
+			convey.So(saved, convey.ShouldHaveLength, 2)
+			convey.So(saved[0]["region"], convey.ShouldEqual, "osguangzhou")
+			convey.So(saved[0]["engine_region"], convey.ShouldEqual, "ap-guangzhou")
+
+			for _, mappings := range []string{
+				// 不能映射默认地区
+				"<RegionMapping><Region>osbeijing</Region><EngineRegion>ap-guangzhou</EngineRegion></RegionMapping>",
+				"<RegionMapping><Region>eu-west-1</Region><EngineRegion>ap-guangzhou</EngineRegion></RegionMapping>",
+				"<RegionMapping><Region>osguangzhou</Region></RegionMapping>",
+				"<RegionMapping><Region>osguangzhou</Region><EngineRegion>ap-guangzhou</EngineRegion></RegionMapping>" +
+					"<RegionMapping><Region>osguangzhou</Region><EngineRegion>ap-beijing</EngineRegion></RegionMapping>",
+			} {
+				convey.So(do(mappings).Code, convey.ShouldEqual, http.StatusBadRequest)
+			}
+
+			// 后端地区同样受 admin.regions 限制
+			w = do("<RegionMapping><Region>osguangzhou</Region><EngineRegion>ap-shanghai</EngineRegion></RegionMapping>")
+			convey.So(w.Code, convey.ShouldEqual, http.StatusForbidden)
+		})
+	})
+}
@@ -12,6 +12,7 @@ import (
 	"time"
 
 	"github.com/solution9th/S3Adapter/internal/gerror"
+	"github.com/solution9th/S3Adapter/internal/reqinfo"
 	"github.com/solution9th/S3Adapter/internal/sign"
 
 	"github.com/aws/aws-sdk-go/aws/awserr"
@@ -154,6 +155,9 @@ func writeErrorResponseXML(ctx context.Context, w http.ResponseWriter, err error
 
 	re, ok := err.(awserr.RequestFailure)
 	if ok {
+		if info := reqinfo.FromContext(ctx); info != nil {
+			info.ErrorCode = re.Code()
+		}
 		xe := &XMLResponseError{
 			Code:      re.Code(),
 			Message:   re.Message(),
@@ -169,6 +173,14 @@ func writeErrorResponseXML(ctx context.Context, w http.ResponseWriter, err error
 
 	ee, ok := err.(awserr.Error)
 	if ok {
+		// 引擎返回的不支持错误没有状态码，转换为标准的 501 NotImplemented
+		if ee.Code() == gerror.ErrUnsupported {
+			writeErrorResponseXML(ctx, w, gerror.GetError(gerror.ErrNotImplemented, ee), args...)
+			return
+		}
+		if info := reqinfo.FromContext(ctx); info != nil {
+			info.ErrorCode = ee.Code()
+		}
 		xe := &XMLResponseError{
 			Code:      ee.Code(),
 			Message:   ee.Message(),
@@ -178,7 +190,7 @@ func writeErrorResponseXML(ctx context.Context, w http.ResponseWriter, err error
 		for _, a := range args {
 			a(xe)
 		}
-		formatWriteXML(w, 200, "Error", xe, false)
+		formatWriteXML(w, http.StatusInternalServerError, "Error", xe, false)
 		return
 	}
 	formatWriteXML(w, 300, "Error", nil, false)
@@ -205,6 +217,10 @@ func writeSuccessResponseHeadersOnly(w http.ResponseWriter) {
 func writeS3Header(w http.ResponseWriter, h http.Header) {
 	for k, v := range h {
 		for _, j := range v {
+			// 后端没有返回请求 ID 时保留 newContext 生成的
+			if j == "" && http.CanonicalHeaderKey(k) == http.CanonicalHeaderKey(responseRequestIDKey) {
+				continue
+			}
 			// 替换之前的 Header
 			w.Header().Set(k, j)
 		}
@@ -4,9 +4,13 @@ import (
 	"context"
 	"fmt"
 	"io/ioutil"
+	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 
+	"github.com/solution9th/S3Adapter/internal/gerror"
+
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/service/s3"
@@ -45,3 +49,17 @@ func TestWriteErrorResponseXML(t *testing.T) {
 	body, err := ioutil.ReadAll(w.Result().Body)
 	fmt.Println(string(body), err)
 }
+
+func TestWriteErrorResponseXMLUnsupported(t *testing.T) {
+
+	w := httptest.NewRecorder()
+
+	writeErrorResponseXML(context.Background(), w, gerror.AWSErrUnsupported)
+
+	if w.Code != http.StatusNotImplemented {
+		t.Fatalf("status code: got %d, want %d", w.Code, http.StatusNotImplemented)
+	}
+	if !strings.Contains(w.Body.String(), "<Code>NotImplemented</Code>") {
+		t.Fatalf("body: %s", w.Body.String())
+	}
+}
@@ -7,6 +7,10 @@ import (
 // NewAPIRouter router list
 func NewAPIRouter(r *mux.Router, api *API) {
 
+	r.Use(requestMiddleware)
+	r.Use(traceMiddleware)
+	r.Use(api.accessLogMiddleware)
+	r.Use(metricsMiddleware)
 	r.Use(loggingMiddleware)
 
 	apiRouter := r.PathPrefix("/").Subrouter()
@@ -14,6 +18,10 @@ func NewAPIRouter(r *mux.Router, api *API) {
 	// version, notice: router order
 	apiRouter.Methods("GET").Path("/version").HandlerFunc(Version)
 
+	// 存活和就绪检查
+	apiRouter.Methods("GET").Path("/healthz").HandlerFunc(api.Healthz)
+	apiRouter.Methods("GET").Path("/readyz").HandlerFunc(api.Readyz)
+
 	routers := make([]*mux.Router, 0)
 	routers = append(routers, apiRouter.Host("{bucket:.+}."+EndPointDomain).Subrouter())
 	routers = append(routers, apiRouter.Host("{bucket:.+}."+EndPointDomain+":{port:.*}").Subrouter())
@@ -47,6 +55,25 @@ func NewAPIRouter(r *mux.Router, api *API) {
 
 	}
 
+	// 管理接口，需要使用管理 key 签名
+	apiRouter.Methods("POST").Path("/").Queries("rotateKey", "").HandlerFunc(api.RotateKey)
+	apiRouter.Methods("GET").Path("/").Queries("keys", "").HandlerFunc(api.ListKeys)
+	apiRouter.Methods("POST").Path("/").Queries("updateBackend", "").HandlerFunc(api.UpdateBackend)
+	apiRouter.Methods("POST").Path("/").Queries("createUser", "").HandlerFunc(api.CreateUser)
+	apiRouter.Methods("GET").Path("/").Queries("users", "").HandlerFunc(api.ListUsers)
+	apiRouter.Methods("DELETE").Path("/").Queries("user", "").HandlerFunc(api.DeleteUser)
+	apiRouter.Methods("PUT").Path("/").Queries("network", "").HandlerFunc(api.PutNetwork)
+	apiRouter.Methods("GET").Path("/").Queries("network", "").HandlerFunc(api.GetNetwork)
+	apiRouter.Methods("POST").Path("/").Queries("createClientCert", "").HandlerFunc(api.CreateClientCert)
+	apiRouter.Methods("GET").Path("/").Queries("clientCerts", "").HandlerFunc(api.ListClientCerts)
+	apiRouter.Methods("DELETE").Path("/").Queries("clientCert", "").HandlerFunc(api.DeleteClientCert)
+
+	// STS 临时凭证，需要在管理接口之后，它们的 POST 请求也可能是表单格式
+	apiRouter.Methods("GET", "POST").Path("/").MatcherFunc(isSTSRequest).HandlerFunc(api.SecurityTokenService)
+
+	// GetCapabilities 应用的引擎支持的方法和特性
+	apiRouter.Methods("GET").Path("/").Queries("capabilities", "").HandlerFunc(api.GetCapabilities)
+
 	// ListBuckets
 	apiRouter.Methods("GET").Path("/").HandlerFunc(api.ListBuckets)
 
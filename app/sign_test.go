@@ -0,0 +1,76 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/solution9th/S3Adapter/internal/cache"
+	"github.com/solution9th/S3Adapter/internal/db"
+	"github.com/solution9th/S3Adapter/internal/db/mysql"
+	"github.com/solution9th/S3Adapter/mocks/mock_db"
+
+	"github.com/golang/mock/gomock"
+	"github.com/smartystreets/goconvey/convey"
+)
+
+func TestGetInfoCache(t *testing.T) {
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	convey.Convey("getInfo cache", t, func() {
+
+		mockDB := mock_db.NewMockDB(ctrl)
+		a := &API{DB: mockDB, Cache: cache.New(10, time.Minute, time.Minute)}
+
+		info := mysql.Info{OsAccessKey: "oak", OsScrectKey: "osk", EngineType: "s3", EngineAccessKey: "ak", EngineSecretKey: "sk"}
+
+		convey.Convey("hit", func() {
+			mockDB.EXPECT().GetInfo("oak").Return(info, nil).Times(1)
+
+			for i := 0; i < 3; i++ {
+				osk, ak, sk, engine, _ := a.getSecretKeyEngine("oak")
+				convey.So([]string{osk, ak, sk, engine}, convey.ShouldResemble, []string{"osk", "ak", "sk", "s3"})
+			}
+		})
+
+		convey.Convey("negative", func() {
+			mockDB.EXPECT().GetInfo("none").Return(mysql.Info{}, db.ErrNotFound).Times(1)
+
+			for i := 0; i < 3; i++ {
+				_, err := a.getInfo(context.Background(), "none")
+				convey.So(err, convey.ShouldEqual, db.ErrNotFound)
+			}
+		})
+
+		convey.Convey("db error is not cached", func() {
+			mockDB.EXPECT().GetInfo("oak").Return(mysql.Info{}, errors.New("timeout")).Times(1)
+			mockDB.EXPECT().GetInfo("oak").Return(info, nil).Times(1)
+
+			_, err := a.getInfo(context.Background(), "oak")
+			convey.So(err, convey.ShouldNotBeNil)
+			_, err = a.getInfo(context.Background(), "oak")
+			convey.So(err, convey.ShouldBeNil)
+		})
+
+		convey.Convey("invalidate on delete", func() {
+			mockDB.EXPECT().GetInfo("oak").Return(info, nil).Times(1)
+			mockDB.EXPECT().ListKey("oak").Return(nil, nil).Times(1)
+			mockDB.EXPECT().ListUser("oak").Return(nil, nil).Times(1)
+			mockDB.EXPECT().ListSession("oak").Return(nil, nil).Times(1)
+			mockDB.EXPECT().ListClientCert("oak").Return(nil, nil).Times(1)
+			mockDB.EXPECT().DeleteInfo("oak", "osk").Return(nil).Times(1)
+			mockDB.EXPECT().GetInfo("oak").Return(mysql.Info{}, db.ErrNotFound).Times(1)
+
+			_, err := a.getInfo(context.Background(), "oak")
+			convey.So(err, convey.ShouldBeNil)
+
+			convey.So(a.deleteInfo("oak", "osk"), convey.ShouldBeNil)
+
+			_, err = a.getInfo(context.Background(), "oak")
+			convey.So(err, convey.ShouldEqual, db.ErrNotFound)
+		})
+	})
+}
@@ -0,0 +1,628 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/solution9th/S3Adapter/internal/config"
+	"github.com/solution9th/S3Adapter/internal/db"
+	"github.com/solution9th/S3Adapter/internal/gerror"
+	"github.com/solution9th/S3Adapter/internal/policy"
+	"github.com/solution9th/S3Adapter/internal/reqinfo"
+	"github.com/solution9th/S3Adapter/internal/sign"
+	"github.com/solution9th/S3Adapter/internal/webidentity"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/gorilla/mux"
+	"github.com/haozibi/zlog"
+)
+
+const (
+	// stsServiceName STS 请求签名使用的服务名
+	stsServiceName = "sts"
+
+	// tempAccessKeyPrefix 临时凭证 AccessKey 的前缀，与 AWS 相同
+	tempAccessKeyPrefix = "ASIA"
+
+	// securityTokenHeader 使用临时凭证时带上 token 的请求头，预签名时为同名的 URL 参数
+	securityTokenHeader = "X-Amz-Security-Token"
+
+	minSessionDuration             = 15 * time.Minute
+	defaultAssumeRoleDuration      = time.Hour
+	defaultGetSessionTokenDuration = 12 * time.Hour
+	defaultMaxSessionDuration      = 12 * time.Hour
+)
+
+// roleSessionNameRegexp RoleSessionName，与 AWS 的规则相同
+var roleSessionNameRegexp = regexp.MustCompile(`^[\w+=,.@-]{2,64}$`)
+
+// STS 临时凭证的配置
+type STS struct {
+	// maxDuration 临时凭证的最长有效时间
+	maxDuration time.Duration
+	// webIdentity 验证 AssumeRoleWithWebIdentity 的 token
+	webIdentity *webidentity.Verifier
+}
+
+// NewSTS 创建 STS 配置，没有配置 MaxDuration 时为 12 小时
+func NewSTS(cfg config.STS) (*STS, error) {
+
+	max := cfg.MaxDuration
+	if max <= 0 {
+		max = defaultMaxSessionDuration
+	}
+
+	v, err := webidentity.New(cfg.WebIdentity)
+	if err != nil {
+		return nil, err
+	}
+	for _, i := range cfg.WebIdentity {
+		for _, role := range i.Roles {
+			if errCode := checkSessionPolicy(role.Policy); errCode != gerror.ErrNone {
+				return nil, fmt.Errorf("sts: invalid policy of role %q", role.Name)
+			}
+		}
+	}
+
+	return &STS{maxDuration: max, webIdentity: v}, nil
+}
+
+// duration 根据 DurationSeconds 参数计算有效时间，为空时使用 def，不能超过 maxDuration
+func (s *STS) duration(param string, def time.Duration) (time.Duration, gerror.APIErrorCode) {
+
+	max := defaultMaxSessionDuration
+	if s != nil {
+		max = s.maxDuration
+	}
+
+	if param == "" {
+		if def > max {
+			def = max
+		}
+		return def, gerror.ErrNone
+	}
+
+	n, err := strconv.ParseInt(param, 10, 64)
+	d := time.Duration(n) * time.Second
+	if err != nil || d < minSessionDuration || d > max {
+		return 0, gerror.ErrValidationError
+	}
+	return d, gerror.ErrNone
+}
+
+// STSCredentials 临时凭证
+type STSCredentials struct {
+	AccessKeyID     string    `xml:"AccessKeyId"`
+	SecretAccessKey string    `xml:"SecretAccessKey"`
+	SessionToken    string    `xml:"SessionToken"`
+	Expiration      time.Time `xml:"Expiration"`
+}
+
+// AssumedRoleUser AssumeRole 扮演的身份
+type AssumedRoleUser struct {
+	Arn           string `xml:"Arn"`
+	AssumedRoleID string `xml:"AssumedRoleId"`
+}
+
+// STSResponseMetadata STS 响应中的请求 ID
+type STSResponseMetadata struct {
+	RequestID string `xml:"RequestId"`
+}
+
+// GetSessionTokenResponse GetSessionToken 的响应
+type GetSessionTokenResponse struct {
+	XMLName  xml.Name              `xml:"https://sts.amazonaws.com/doc/2011-06-15/ GetSessionTokenResponse"`
+	Result   GetSessionTokenResult `xml:"GetSessionTokenResult"`
+	Metadata STSResponseMetadata   `xml:"ResponseMetadata"`
+}
+
+// GetSessionTokenResult GetSessionToken 的结果
+type GetSessionTokenResult struct {
+	Credentials STSCredentials `xml:"Credentials"`
+}
+
+// AssumeRoleResponse AssumeRole 的响应
+type AssumeRoleResponse struct {
+	XMLName  xml.Name            `xml:"https://sts.amazonaws.com/doc/2011-06-15/ AssumeRoleResponse"`
+	Result   AssumeRoleResult    `xml:"AssumeRoleResult"`
+	Metadata STSResponseMetadata `xml:"ResponseMetadata"`
+}
+
+// AssumeRoleResult AssumeRole 的结果
+type AssumeRoleResult struct {
+	Credentials     STSCredentials  `xml:"Credentials"`
+	AssumedRoleUser AssumedRoleUser `xml:"AssumedRoleUser"`
+}
+
+// AssumeRoleWithWebIdentityResponse AssumeRoleWithWebIdentity 的响应
+type AssumeRoleWithWebIdentityResponse struct {
+	XMLName  xml.Name                        `xml:"https://sts.amazonaws.com/doc/2011-06-15/ AssumeRoleWithWebIdentityResponse"`
+	Result   AssumeRoleWithWebIdentityResult `xml:"AssumeRoleWithWebIdentityResult"`
+	Metadata STSResponseMetadata             `xml:"ResponseMetadata"`
+}
+
+// AssumeRoleWithWebIdentityResult AssumeRoleWithWebIdentity 的结果
+type AssumeRoleWithWebIdentityResult struct {
+	Credentials                 STSCredentials  `xml:"Credentials"`
+	AssumedRoleUser             AssumedRoleUser `xml:"AssumedRoleUser"`
+	SubjectFromWebIdentityToken string          `xml:"SubjectFromWebIdentityToken"`
+	Provider                    string          `xml:"Provider"`
+	Audience                    string          `xml:"Audience"`
+}
+
+// STSErrorResponse STS 格式的错误
+type STSErrorResponse struct {
+	XMLName   xml.Name `xml:"https://sts.amazonaws.com/doc/2011-06-15/ ErrorResponse"`
+	Error     STSError `xml:"Error"`
+	RequestID string   `xml:"RequestId"`
+}
+
+// STSError STS 错误的内容，Type 为 Sender 或者 Receiver
+type STSError struct {
+	Type    string `xml:"Type"`
+	Code    string `xml:"Code"`
+	Message string `xml:"Message"`
+}
+
+// isSTSRequest 参数 Action 在 URL 中，或者以表单 POST，与 AWS STS 相同
+func isSTSRequest(r *http.Request, rm *mux.RouteMatch) bool {
+	if _, ok := r.URL.Query()["Action"]; ok {
+		return true
+	}
+	return r.Method == http.MethodPost &&
+		strings.HasPrefix(r.Header.Get("Content-Type"), "application/x-www-form-urlencoded")
+}
+
+// stsParams 合并 URL 参数和表单参数，读取后恢复 Body，签名验证需要再次读取
+func stsParams(r *http.Request) (url.Values, error) {
+
+	params := r.URL.Query()
+	if r.Body == nil || r.Method != http.MethodPost {
+		return params, nil
+	}
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return nil, err
+	}
+	r.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+	form, err := url.ParseQuery(string(body))
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range form {
+		params[k] = append(params[k], v...)
+	}
+	return params, nil
+}
+
+// SecurityTokenService 兼容 AWS STS 的临时凭证接口，支持 GetSessionToken 和 AssumeRole，
+// 需要使用应用或者应用下用户的 key 以服务名 sts 签名；AssumeRoleWithWebIdentity 不需要签名，
+// 使用 OIDC 签发者签名的 token
+//
+// 请求:
+//
+//	POST /
+//	Content-Type: application/x-www-form-urlencoded
+//
+//	Action=AssumeRole&Version=2011-06-15&RoleArn=...&RoleSessionName=...&Policy=...&DurationSeconds=...
+func (a *API) SecurityTokenService(w http.ResponseWriter, r *http.Request) {
+
+	params, err := stsParams(r)
+	action := params.Get("Action")
+
+	api := action
+	if api == "" {
+		api = "STS"
+	}
+	ctx := newContext(w, r, api)
+
+	reqinfo.ZDebug(ctx).Str("Method", "STS").Str("Action", action).Msg("[debug]")
+
+	if err != nil {
+		reqinfo.ZError(ctx).Str("Method", "ParseForm").Msg(err.Error())
+		writeSTSError(ctx, w, gerror.GetError(gerror.ErrValidationError, err))
+		return
+	}
+
+	var response interface{}
+	var errCode gerror.APIErrorCode
+	switch action {
+	case "GetSessionToken":
+		response, errCode = a.getSessionToken(ctx, r, params)
+	case "AssumeRole":
+		response, errCode = a.assumeRole(ctx, r, params)
+	case "AssumeRoleWithWebIdentity":
+		response, errCode = a.assumeRoleWithWebIdentity(ctx, params)
+	default:
+		errCode = gerror.ErrInvalidAction
+	}
+
+	if errCode != gerror.ErrNone {
+		writeSTSError(ctx, w, gerror.GetError(errCode, nil))
+		return
+	}
+
+	formatWriteXML(w, http.StatusOK, "", response, false)
+}
+
+// authSTS 验证 STS 请求的签名，返回调用者，临时凭证不能再申请临时凭证
+func (a *API) authSTS(r *http.Request) (*authInfo, gerror.APIErrorCode) {
+
+	if sign.GetRequestAuthType(r) != sign.AuthTypeSigned {
+		return nil, gerror.ErrAccessDenied
+	}
+
+	authStr := r.Header.Get("Authorization")
+	auth, err := sign.NewAuthSign(authStr)
+	if err != nil {
+		zlog.ZError().Msg(err.Error())
+		return nil, gerror.ErrAccessDenied
+	}
+
+	region := auth.GetRegion()
+	if !validRegion(region) {
+		return nil, gerror.ErrInvalidRegion
+	}
+	if auth.GetServiceName() != stsServiceName {
+		return nil, gerror.ErrAccessDenied
+	}
+
+	info := a.getAppInfo(r.Context(), auth.GetAccessKey())
+	if info == nil || info.osk == "" {
+		return nil, gerror.ErrInvalidAccessKeyID
+	}
+	if info.expired() || info.token != "" {
+		return nil, gerror.ErrAccessDenied
+	}
+
+	sv4 := sign.NewSignV4(info.oak, info.osk, region, r).WithService(stsServiceName)
+	if errCode := sv4.Verify(time.Now(), authStr); errCode != gerror.ErrNone {
+		return nil, errCode
+	}
+	if errCode := a.checkNetwork(r, info); errCode != gerror.ErrNone {
+		return nil, errCode
+	}
+	setLabels(r, info)
+	return info, gerror.ErrNone
+}
+
+// getSessionToken 签发与调用者权限相同的临时凭证
+func (a *API) getSessionToken(ctx context.Context, r *http.Request, params url.Values) (interface{}, gerror.APIErrorCode) {
+
+	caller, errCode := a.authSTS(r)
+	if errCode != gerror.ErrNone {
+		return nil, errCode
+	}
+
+	d, errCode := a.STS.duration(params.Get("DurationSeconds"), defaultGetSessionTokenDuration)
+	if errCode != gerror.ErrNone {
+		return nil, errCode
+	}
+
+	creds, errCode := a.issueSession(ctx, caller.id, caller.user, "", d)
+	if errCode != gerror.ErrNone {
+		return nil, errCode
+	}
+
+	return GetSessionTokenResponse{
+		Result:   GetSessionTokenResult{Credentials: creds},
+		Metadata: STSResponseMetadata{RequestID: reqinfo.FromContext(ctx).RequestID},
+	}, gerror.ErrNone
+}
+
+// assumeRole 签发扮演应用或者应用下用户的临时凭证，权限再由 Policy 参数限制
+//
+// RoleArn 为 arn:aws:iam::<任意>:root 时扮演应用本身，为 arn:aws:iam::<任意>:user/<用户名>
+// 或者 role/<用户名> 时扮演应用下的用户，应用下的用户只能扮演自己
+func (a *API) assumeRole(ctx context.Context, r *http.Request, params url.Values) (interface{}, gerror.APIErrorCode) {
+
+	caller, errCode := a.authSTS(r)
+	if errCode != gerror.ErrNone {
+		return nil, errCode
+	}
+
+	sessionName := params.Get("RoleSessionName")
+	user, ok := parseRoleArn(params.Get("RoleArn"))
+	if !ok || !roleSessionNameRegexp.MatchString(sessionName) {
+		return nil, gerror.ErrValidationError
+	}
+
+	d, errCode := a.STS.duration(params.Get("DurationSeconds"), defaultAssumeRoleDuration)
+	if errCode != gerror.ErrNone {
+		return nil, errCode
+	}
+
+	doc := params.Get("Policy")
+	if errCode = checkSessionPolicy(doc); errCode != gerror.ErrNone {
+		return nil, errCode
+	}
+
+	if caller.user != "" && caller.user != user {
+		zlog.ZWarn().Str("User", caller.user).Str("RoleArn", params.Get("RoleArn")).Msg("[STS] user can only assume itself")
+		return nil, gerror.ErrAccessDenied
+	}
+	if caller.user == "" && user != "" {
+		if errCode = a.checkUser(ctx, caller.id, user); errCode != gerror.ErrNone {
+			return nil, errCode
+		}
+	}
+
+	creds, errCode := a.issueSession(ctx, caller.id, user, doc, d)
+	if errCode != gerror.ErrNone {
+		return nil, errCode
+	}
+
+	role := user
+	if role == "" {
+		role = "root"
+	}
+
+	return AssumeRoleResponse{
+		Result: AssumeRoleResult{
+			Credentials: creds,
+			AssumedRoleUser: AssumedRoleUser{
+				Arn:           "arn:aws:sts::" + caller.id + ":assumed-role/" + role + "/" + sessionName,
+				AssumedRoleID: creds.AccessKeyID + ":" + sessionName,
+			},
+		},
+		Metadata: STSResponseMetadata{RequestID: reqinfo.FromContext(ctx).RequestID},
+	}, gerror.ErrNone
+}
+
+// assumeRoleWithWebIdentity 验证 WebIdentityToken 后签发扮演配置中角色的临时凭证
+//
+// RoleArn 为 arn:aws:iam::<任意>:role/<角色名>，token 的声明需要满足角色的条件，
+// 角色配置了会话策略时不能再使用 Policy 参数
+func (a *API) assumeRoleWithWebIdentity(ctx context.Context, params url.Values) (interface{}, gerror.APIErrorCode) {
+
+	if a.STS == nil || !a.STS.webIdentity.Enabled() {
+		return nil, gerror.ErrInvalidAction
+	}
+
+	sessionName := params.Get("RoleSessionName")
+	name, ok := parseRoleArn(params.Get("RoleArn"))
+	if !ok || !strings.Contains(params.Get("RoleArn"), ":role/") || !roleSessionNameRegexp.MatchString(sessionName) {
+		return nil, gerror.ErrValidationError
+	}
+
+	d, errCode := a.STS.duration(params.Get("DurationSeconds"), defaultAssumeRoleDuration)
+	if errCode != gerror.ErrNone {
+		return nil, errCode
+	}
+
+	doc := params.Get("Policy")
+	if errCode = checkSessionPolicy(doc); errCode != gerror.ErrNone {
+		return nil, errCode
+	}
+
+	issuer, claims, err := a.STS.webIdentity.Verify(params.Get("WebIdentityToken"), time.Now())
+	if err == webidentity.ErrExpired {
+		return nil, gerror.ErrExpiredToken
+	}
+	if err != nil {
+		zlog.ZWarn().Str("Method", "verifyWebIdentity").Msg(err.Error())
+		return nil, gerror.ErrInvalidIdentityToken
+	}
+
+	subject := claims.String("sub")
+	role, ok := issuer.Role(name, claims)
+	if !ok {
+		zlog.ZWarn().Str("Issuer", issuer.Name()).Str("Subject", subject).Str("Role", name).Msg("[STS] web identity can not assume role")
+		return nil, gerror.ErrAccessDenied
+	}
+
+	if role.Policy != "" {
+		if doc != "" {
+			return nil, gerror.ErrValidationError
+		}
+		doc = role.Policy
+	}
+
+	m, err := a.lookupApp(ctx, role.Application)
+	if err == db.ErrNotFound {
+		zlog.ZError().Str("Role", name).Str("App", role.Application).Msg("[STS] application of role not found")
+		return nil, gerror.ErrAccessDenied
+	}
+	if err != nil {
+		return nil, gerror.ErrInternalError
+	}
+	id := m.OsAccessKey
+
+	if role.User != "" {
+		if errCode = a.checkUser(ctx, id, role.User); errCode != gerror.ErrNone {
+			return nil, errCode
+		}
+	}
+
+	creds, errCode := a.issueSession(ctx, id, role.User, doc, d)
+	if errCode != gerror.ErrNone {
+		return nil, errCode
+	}
+
+	zlog.ZInfo().Str("Issuer", issuer.Name()).Str("Subject", subject).Str("Role", name).Str("AK", creds.AccessKeyID).Msg("[STS] assume role with web identity")
+
+	var audience string
+	if aud := claims.Strings("aud"); len(aud) > 0 {
+		audience = aud[0]
+	}
+
+	return AssumeRoleWithWebIdentityResponse{
+		Result: AssumeRoleWithWebIdentityResult{
+			Credentials: creds,
+			AssumedRoleUser: AssumedRoleUser{
+				Arn:           "arn:aws:sts::" + id + ":assumed-role/" + name + "/" + sessionName,
+				AssumedRoleID: creds.AccessKeyID + ":" + sessionName,
+			},
+			SubjectFromWebIdentityToken: subject,
+			Provider:                    issuer.Name(),
+			Audience:                    audience,
+		},
+		Metadata: STSResponseMetadata{RequestID: reqinfo.FromContext(ctx).RequestID},
+	}, gerror.ErrNone
+}
+
+// parseRoleArn 返回 RoleArn 对应的应用下的用户名，扮演应用本身时为空
+func parseRoleArn(arn string) (user string, ok bool) {
+
+	if !strings.HasPrefix(arn, "arn:aws:iam::") {
+		return "", false
+	}
+	rest := strings.TrimPrefix(arn, "arn:aws:iam::")
+	i := strings.Index(rest, ":")
+	if i < 0 {
+		return "", false
+	}
+
+	resource := rest[i+1:]
+	if resource == "root" {
+		return "", true
+	}
+	for _, prefix := range []string{"user/", "role/"} {
+		if strings.HasPrefix(resource, prefix) {
+			user = strings.TrimPrefix(resource, prefix)
+			return user, userNameRegexp.MatchString(user)
+		}
+	}
+	return "", false
+}
+
+// checkSessionPolicy 检查会话策略，为空时不限制
+func checkSessionPolicy(doc string) gerror.APIErrorCode {
+
+	if doc == "" {
+		return gerror.ErrNone
+	}
+
+	_, err := policy.Parse(doc)
+	if err == policy.ErrTooLarge {
+		return gerror.ErrValidationError
+	}
+	if err != nil {
+		zlog.ZWarn().Msg("[STS] invalid policy: " + err.Error())
+		return gerror.ErrMalformedPolicyDocument
+	}
+	return gerror.ErrNone
+}
+
+// checkUser 检查应用 id 下是否有用户 name
+func (a *API) checkUser(ctx context.Context, id, name string) gerror.APIErrorCode {
+
+	users, err := db.WithContext(ctx, a.DB).ListUser(id)
+	if err != nil {
+		zlog.ZError().Str("Method", "listUser").Msg(err.Error())
+		return gerror.ErrInternalError
+	}
+	for _, u := range users {
+		if u.UserName == name {
+			return gerror.ErrNone
+		}
+	}
+	return gerror.ErrNoSuchUser
+}
+
+// issueSession 为应用 id 签发有效时间为 d 的临时凭证，user 不为空时使用用户的身份策略
+func (a *API) issueSession(ctx context.Context, id, user, doc string, d time.Duration) (creds STSCredentials, errCode gerror.APIErrorCode) {
+
+	ak := tempAccessKeyPrefix + GenRandomString(16)
+	sk := genSecretKey()
+	token := GenRandomString(64)
+	now := time.Now()
+	expire := now.Add(d).UTC().Truncate(time.Second)
+
+	dd := db.WithContext(ctx, a.DB)
+
+	_, err := dd.SaveSession(map[string]interface{}{
+		"os_access_key": id,
+		"access_key":    ak,
+		"secret_key":    sk,
+		"session_token": hashToken(token),
+		"user_name":     user,
+		"policy":        doc,
+		"expire_time":   expire,
+	})
+	if err != nil {
+		zlog.ZError().Str("Method", "saveSession").Msg(err.Error())
+		return creds, gerror.ErrInternalError
+	}
+
+	// 可能缓存了"不存在"
+	a.Cache.Remove(ak)
+
+	// 顺便清理过期的临时凭证，失败不影响签发
+	if err = dd.DeleteExpiredSession(now); err != nil {
+		zlog.ZWarn().Str("Method", "deleteExpiredSession").Msg(err.Error())
+	}
+
+	zlog.ZInfo().Str("App", id).Str("User", user).Str("AK", ak).Str("Expiration", expire.Format(time.RFC3339)).Msg("[STS] issue session")
+
+	return STSCredentials{
+		AccessKeyID:     ak,
+		SecretAccessKey: sk,
+		SessionToken:    token,
+		Expiration:      expire,
+	}, gerror.ErrNone
+}
+
+// hashToken 数据库中只保存 token 的 SHA256
+func hashToken(token string) string {
+	h := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(h[:])
+}
+
+// verifyToken 使用临时凭证时检查 x-amz-security-token，其他 key 不能带 token
+func verifyToken(r *http.Request, info *authInfo) gerror.APIErrorCode {
+
+	token := r.Header.Get(securityTokenHeader)
+	if token == "" {
+		token = r.URL.Query().Get(securityTokenHeader)
+	}
+
+	if info.token == "" {
+		if token != "" {
+			return gerror.ErrInvalidToken
+		}
+		return gerror.ErrNone
+	}
+
+	if token == "" || subtle.ConstantTimeCompare([]byte(hashToken(token)), []byte(info.token)) != 1 {
+		zlog.ZDebug().Str("OAK", info.oak).Msg("[STS] invalid security token")
+		return gerror.ErrInvalidToken
+	}
+	return gerror.ErrNone
+}
+
+// writeSTSError 以 STS 的格式返回错误，SDK 按这个格式解析
+func writeSTSError(ctx context.Context, w http.ResponseWriter, err awserr.RequestFailure) {
+
+	if info := reqinfo.FromContext(ctx); info != nil {
+		info.ErrorCode = err.Code()
+	}
+
+	typ := "Sender"
+	if err.StatusCode() >= http.StatusInternalServerError {
+		typ = "Receiver"
+	}
+
+	formatWriteXML(w, err.StatusCode(), "", STSErrorResponse{
+		Error: STSError{
+			Type:    typ,
+			Code:    err.Code(),
+			Message: err.Message(),
+		},
+		RequestID: w.Header().Get(responseRequestIDKey),
+	}, false)
+}
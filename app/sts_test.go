@@ -0,0 +1,417 @@
+package app
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/xml"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/solution9th/S3Adapter/internal/config"
+	"github.com/solution9th/S3Adapter/internal/db"
+	"github.com/solution9th/S3Adapter/internal/db/mysql"
+	"github.com/solution9th/S3Adapter/internal/gerror"
+	"github.com/solution9th/S3Adapter/internal/reqinfo"
+	"github.com/solution9th/S3Adapter/internal/sign"
+	"github.com/solution9th/S3Adapter/mocks/mock_db"
+
+	"github.com/golang/mock/gomock"
+	"github.com/gorilla/mux"
+	"github.com/smartystreets/goconvey/convey"
+)
+
+// stsRequest 以服务名 sts 签名的表单请求
+func stsRequest(params url.Values, ak, sk, service string) *http.Request {
+	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(params.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	now := time.Now().UTC()
+	req.Header.Set("X-Amz-Date", now.Format(sign.TimeISO8601BasicFormat))
+	authStr, _ := sign.NewSignV4(ak, sk, GlobalRegion, req).WithService(service).Signature(now)
+	req.Header.Set("Authorization", authStr)
+	return req
+}
+
+// objectRequest 经过路由后的 GetObject 请求
+func objectRequest(object, ak, sk, token string) *http.Request {
+	req := httptest.NewRequest(http.MethodGet, "/photos/"+object, nil)
+	if token != "" {
+		req.Header.Set(securityTokenHeader, token)
+	}
+	signRequest(req, ak, sk)
+	req = mux.SetURLVars(req, map[string]string{"bucket": "photos", "object": object})
+	return req.WithContext(reqinfo.NewContext(req.Context(), &reqinfo.ReqInfo{API: "GetObject"}))
+}
+
+func TestSecurityTokenService(t *testing.T) {
+
+	convey.Convey("STS", t, func() {
+
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		mockDB := mock_db.NewMockDB(ctrl)
+		sts, _ := NewSTS(config.STS{MaxDuration: 2 * time.Hour})
+		a := &API{DB: mockDB, STS: sts}
+
+		r := mux.NewRouter()
+		NewAPIRouter(r, a)
+
+		app := mysql.Info{OsAccessKey: "oak", OsScrectKey: "osk", EngineType: "s3", EngineAccessKey: "ak", EngineSecretKey: "sk"}
+		mockDB.EXPECT().GetInfo("oak").Return(app, nil).AnyTimes()
+
+		var saved map[string]interface{}
+		saveSession := func() {
+			mockDB.EXPECT().SaveSession(gomock.Any()).Do(func(data map[string]interface{}) {
+				saved = data
+			}).Return(1, nil)
+			mockDB.EXPECT().DeleteExpiredSession(gomock.Any()).Return(nil)
+		}
+
+		// session 签发的临时凭证在数据库中的样子
+		session := func(creds STSCredentials) mysql.Info {
+			m := app
+			m.OsScrectKey = creds.SecretAccessKey
+			m.UserName, _ = saved["user_name"].(string)
+			if m.UserName != "" {
+				m.Policy = testUserPolicy
+			}
+			m.SessionToken = saved["session_token"].(string)
+			m.SessionPolicy = saved["policy"].(string)
+			m.KeyExpireTime = &creds.Expiration
+			return m
+		}
+
+		convey.Convey("GetSessionToken", func() {
+			saveSession()
+
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, stsRequest(url.Values{"Action": {"GetSessionToken"}, "Version": {"2011-06-15"}, "DurationSeconds": {"900"}}, "oak", "osk", "sts"))
+			convey.So(w.Code, convey.ShouldEqual, http.StatusOK)
+			convey.So(w.Body.String(), convey.ShouldContainSubstring, `<GetSessionTokenResponse xmlns="https://sts.amazonaws.com/doc/2011-06-15/">`)
+
+			var resp GetSessionTokenResponse
+			convey.So(xml.Unmarshal(w.Body.Bytes(), &resp), convey.ShouldBeNil)
+			creds := resp.Result.Credentials
+			convey.So(creds.AccessKeyID, convey.ShouldStartWith, tempAccessKeyPrefix)
+			convey.So(creds.Expiration, convey.ShouldHappenWithin, time.Minute, time.Now().Add(15*time.Minute))
+			convey.So(saved["secret_key"], convey.ShouldEqual, creds.SecretAccessKey)
+			convey.So(saved["session_token"], convey.ShouldEqual, hashToken(creds.SessionToken))
+
+			mockDB.EXPECT().GetInfo(creds.AccessKeyID).Return(session(creds), nil).AnyTimes()
+
+			ak, sk := creds.AccessKeyID, creds.SecretAccessKey
+			convey.So(a.Auth(objectRequest("any/1.jpg", ak, sk, creds.SessionToken)), convey.ShouldEqual, gerror.ErrNone)
+			convey.So(a.Auth(objectRequest("any/1.jpg", ak, sk, "")), convey.ShouldEqual, gerror.ErrInvalidToken)
+			convey.So(a.Auth(objectRequest("any/1.jpg", ak, sk, "wrong")), convey.ShouldEqual, gerror.ErrInvalidToken)
+
+			// 应用自己的 key 不能带 token
+			convey.So(a.Auth(objectRequest("any/1.jpg", "oak", "osk", creds.SessionToken)), convey.ShouldEqual, gerror.ErrInvalidToken)
+
+			// 临时凭证不能再申请临时凭证
+			w = httptest.NewRecorder()
+			req := stsRequest(url.Values{"Action": {"GetSessionToken"}}, ak, sk, "sts")
+			r.ServeHTTP(w, req)
+			convey.So(w.Code, convey.ShouldEqual, http.StatusForbidden)
+
+			// 没有会话策略的临时凭证也不能删除应用
+			w = httptest.NewRecorder()
+			req = httptest.NewRequest(http.MethodDelete, "/", nil)
+			req.Header.Set(securityTokenHeader, creds.SessionToken)
+			signRequest(req, ak, sk)
+			r.ServeHTTP(w, req)
+			convey.So(w.Code, convey.ShouldEqual, http.StatusForbidden)
+			convey.So(w.Body.String(), convey.ShouldContainSubstring, "<Code>AccessDenied</Code>")
+		})
+
+		convey.Convey("AssumeRole with user and session policy", func() {
+			mockDB.EXPECT().ListUser("oak").Return([]db.User{{OsAccessKey: "oak", UserName: "team-a"}}, nil)
+			saveSession()
+
+			sessionPolicy := `{"Statement":[{"Effect":"Allow","Action":"s3:GetObject","Resource":"arn:aws:s3:::photos/team-a/1.jpg"}]}`
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, stsRequest(url.Values{
+				"Action":          {"AssumeRole"},
+				"RoleArn":         {"arn:aws:iam::123456789012:user/team-a"},
+				"RoleSessionName": {"mobile"},
+				"Policy":          {sessionPolicy},
+			}, "oak", "osk", "sts"))
+			convey.So(w.Code, convey.ShouldEqual, http.StatusOK)
+
+			var resp AssumeRoleResponse
+			convey.So(xml.Unmarshal(w.Body.Bytes(), &resp), convey.ShouldBeNil)
+			creds := resp.Result.Credentials
+			convey.So(resp.Result.AssumedRoleUser.Arn, convey.ShouldEqual, "arn:aws:sts::oak:assumed-role/team-a/mobile")
+			convey.So(creds.Expiration, convey.ShouldHappenWithin, time.Minute, time.Now().Add(time.Hour))
+			convey.So(saved["user_name"], convey.ShouldEqual, "team-a")
+			convey.So(saved["policy"], convey.ShouldEqual, sessionPolicy)
+
+			mockDB.EXPECT().GetInfo(creds.AccessKeyID).Return(session(creds), nil).AnyTimes()
+
+			// 需要同时满足用户的身份策略和会话策略
+			ak, sk, token := creds.AccessKeyID, creds.SecretAccessKey, creds.SessionToken
+			convey.So(a.Auth(objectRequest("team-a/1.jpg", ak, sk, token)), convey.ShouldEqual, gerror.ErrNone)
+			convey.So(a.Auth(objectRequest("team-a/2.jpg", ak, sk, token)), convey.ShouldEqual, gerror.ErrAccessDenied)
+		})
+
+		convey.Convey("errors", func() {
+			cases := []struct {
+				params  url.Values
+				service string
+				code    int
+				want    string
+			}{
+				{url.Values{"Action": {"GetSessionToken"}}, "s3", http.StatusForbidden, "AccessDenied"},
+				{url.Values{"Action": {"GetSessionToken"}, "DurationSeconds": {"60"}}, "sts", http.StatusBadRequest, "ValidationError"},
+				{url.Values{"Action": {"GetSessionToken"}, "DurationSeconds": {"86400"}}, "sts", http.StatusBadRequest, "ValidationError"},
+				{url.Values{"Action": {"AssumeRole"}, "RoleArn": {"bad"}, "RoleSessionName": {"s1"}}, "sts", http.StatusBadRequest, "ValidationError"},
+				{url.Values{"Action": {"AssumeRole"}, "RoleArn": {"arn:aws:iam::1:root"}, "RoleSessionName": {"s1"}, "Policy": {"{}"}}, "sts", http.StatusBadRequest, "MalformedPolicyDocument"},
+				{url.Values{"Action": {"DeleteEverything"}}, "sts", http.StatusBadRequest, "InvalidAction"},
+			}
+
+			for _, c := range cases {
+				w := httptest.NewRecorder()
+				r.ServeHTTP(w, stsRequest(c.params, "oak", "osk", c.service))
+				convey.So(w.Code, convey.ShouldEqual, c.code)
+				convey.So(w.Body.String(), convey.ShouldContainSubstring, "<ErrorResponse")
+				convey.So(w.Body.String(), convey.ShouldContainSubstring, "<Code>"+c.want+"</Code>")
+			}
+		})
+
+		convey.Convey("user can only assume itself", func() {
+			mockDB.EXPECT().GetInfo("uak").Return(mysql.Info{OsAccessKey: "oak", OsScrectKey: "usk", EngineType: "s3",
+				UserName: "team-a", Policy: testUserPolicy}, nil).AnyTimes()
+
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, stsRequest(url.Values{"Action": {"AssumeRole"}, "RoleArn": {"arn:aws:iam::1:root"}, "RoleSessionName": {"s1"}}, "uak", "usk", "sts"))
+			convey.So(w.Code, convey.ShouldEqual, http.StatusForbidden)
+		})
+	})
+}
+
+// webIdentityToken 使用 key 以 RS256 签名的 JWT
+func webIdentityToken(key *rsa.PrivateKey, claims map[string]interface{}) string {
+	enc := base64.RawURLEncoding.EncodeToString
+	h, _ := json.Marshal(map[string]string{"alg": "RS256", "kid": "k1"})
+	c, _ := json.Marshal(claims)
+	signed := enc(h) + "." + enc(c)
+	digest := sha256.Sum256([]byte(signed))
+	sig, _ := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	return signed + "." + enc(sig)
+}
+
+func TestAssumeRoleWithWebIdentity(t *testing.T) {
+
+	convey.Convey("AssumeRoleWithWebIdentity", t, func() {
+
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		key, _ := rsa.GenerateKey(rand.Reader, 2048)
+		jwks, _ := json.Marshal(map[string]interface{}{"keys": []map[string]string{{
+			"kty": "RSA", "kid": "k1",
+			"n": base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e": base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write(jwks)
+		}))
+		defer ts.Close()
+
+		readOnly := `{"Statement":[{"Effect":"Allow","Action":"s3:GetObject","Resource":"arn:aws:s3:::photos/*"}]}`
+		sts, err := NewSTS(config.STS{WebIdentity: []config.WebIdentity{{
+			Issuer:   "https://sso.example.com",
+			Audience: []string{"s3adapter"},
+			JWKSURL:  ts.URL,
+			Roles: []config.WebIdentityRole{
+				{Name: "team-a", Claim: "groups", Values: []string{"team-a"}, Application: "oak", User: "team-a"},
+				{Name: "reader", Application: "oak", Policy: readOnly},
+				{Name: "all", Application: "oak"},
+			},
+		}}})
+		convey.So(err, convey.ShouldBeNil)
+
+		mockDB := mock_db.NewMockDB(ctrl)
+		a := &API{DB: mockDB, STS: sts}
+
+		r := mux.NewRouter()
+		NewAPIRouter(r, a)
+
+		app := mysql.Info{OsAccessKey: "oak", OsScrectKey: "osk", EngineType: "s3", EngineAccessKey: "ak", EngineSecretKey: "sk"}
+		mockDB.EXPECT().GetInfo("oak").Return(app, nil).AnyTimes()
+
+		var saved map[string]interface{}
+		saveSession := func() {
+			mockDB.EXPECT().SaveSession(gomock.Any()).Do(func(data map[string]interface{}) {
+				saved = data
+			}).Return(1, nil)
+			mockDB.EXPECT().DeleteExpiredSession(gomock.Any()).Return(nil)
+		}
+
+		claims := map[string]interface{}{
+			"iss":    "https://sso.example.com",
+			"aud":    "s3adapter",
+			"sub":    "alice",
+			"groups": []string{"team-a"},
+			"exp":    time.Now().Add(time.Hour).Unix(),
+		}
+
+		// 不需要签名
+		request := func(params url.Values) *httptest.ResponseRecorder {
+			params.Set("Action", "AssumeRoleWithWebIdentity")
+			params.Set("Version", "2011-06-15")
+			req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(params.Encode()))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+			return w
+		}
+
+		convey.Convey("role with user", func() {
+			mockDB.EXPECT().ListUser("oak").Return([]db.User{{OsAccessKey: "oak", UserName: "team-a"}}, nil)
+			saveSession()
+
+			w := request(url.Values{
+				"RoleArn":          {"arn:aws:iam::123456789012:role/team-a"},
+				"RoleSessionName":  {"alice"},
+				"WebIdentityToken": {webIdentityToken(key, claims)},
+				"DurationSeconds":  {"900"},
+			})
+			convey.So(w.Code, convey.ShouldEqual, http.StatusOK)
+
+			var resp AssumeRoleWithWebIdentityResponse
+			convey.So(xml.Unmarshal(w.Body.Bytes(), &resp), convey.ShouldBeNil)
+			convey.So(resp.Result.SubjectFromWebIdentityToken, convey.ShouldEqual, "alice")
+			convey.So(resp.Result.Provider, convey.ShouldEqual, "https://sso.example.com")
+			convey.So(resp.Result.Audience, convey.ShouldEqual, "s3adapter")
+			convey.So(resp.Result.AssumedRoleUser.Arn, convey.ShouldEqual, "arn:aws:sts::oak:assumed-role/team-a/alice")
+			convey.So(resp.Result.Credentials.AccessKeyID, convey.ShouldStartWith, tempAccessKeyPrefix)
+			convey.So(saved["os_access_key"], convey.ShouldEqual, "oak")
+			convey.So(saved["user_name"], convey.ShouldEqual, "team-a")
+			convey.So(saved["policy"], convey.ShouldEqual, "")
+		})
+
+		convey.Convey("role with policy", func() {
+			saveSession()
+
+			w := request(url.Values{
+				"RoleArn":          {"arn:aws:iam::1:role/reader"},
+				"RoleSessionName":  {"alice"},
+				"WebIdentityToken": {webIdentityToken(key, claims)},
+			})
+			convey.So(w.Code, convey.ShouldEqual, http.StatusOK)
+			convey.So(saved["user_name"], convey.ShouldEqual, "")
+			convey.So(saved["policy"], convey.ShouldEqual, readOnly)
+
+			// 角色已经有会话策略
+			w = request(url.Values{
+				"RoleArn":          {"arn:aws:iam::1:role/reader"},
+				"RoleSessionName":  {"alice"},
+				"WebIdentityToken": {webIdentityToken(key, claims)},
+				"Policy":           {readOnly},
+			})
+			convey.So(w.Code, convey.ShouldEqual, http.StatusBadRequest)
+		})
+
+		convey.Convey("role without policy", func() {
+			saveSession()
+
+			w := request(url.Values{
+				"RoleArn":          {"arn:aws:iam::1:role/all"},
+				"RoleSessionName":  {"alice"},
+				"WebIdentityToken": {webIdentityToken(key, claims)},
+			})
+			convey.So(w.Code, convey.ShouldEqual, http.StatusOK)
+			convey.So(saved["policy"], convey.ShouldEqual, "")
+
+			var resp AssumeRoleWithWebIdentityResponse
+			convey.So(xml.Unmarshal(w.Body.Bytes(), &resp), convey.ShouldBeNil)
+			creds := resp.Result.Credentials
+
+			m := app
+			m.OsScrectKey = creds.SecretAccessKey
+			m.SessionToken = saved["session_token"].(string)
+			m.KeyExpireTime = &creds.Expiration
+			mockDB.EXPECT().GetInfo(creds.AccessKeyID).Return(m, nil).AnyTimes()
+
+			ak, sk, token := creds.AccessKeyID, creds.SecretAccessKey, creds.SessionToken
+			convey.So(a.Auth(objectRequest("any/1.jpg", ak, sk, token)), convey.ShouldEqual, gerror.ErrNone)
+
+			// 可以访问对象，但是不能删除应用
+			w = httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodDelete, "/", nil)
+			req.Header.Set(securityTokenHeader, token)
+			signRequest(req, ak, sk)
+			r.ServeHTTP(w, req)
+			convey.So(w.Code, convey.ShouldEqual, http.StatusForbidden)
+			convey.So(w.Body.String(), convey.ShouldContainSubstring, "<Code>AccessDenied</Code>")
+		})
+
+		convey.Convey("errors", func() {
+			expired := map[string]interface{}{}
+			for k, v := range claims {
+				expired[k] = v
+			}
+			expired["exp"] = time.Now().Add(-time.Hour).Unix()
+
+			other := map[string]interface{}{}
+			for k, v := range claims {
+				other[k] = v
+			}
+			other["groups"] = []string{"team-b"}
+
+			cases := []struct {
+				role  string
+				token string
+				code  int
+				want  string
+			}{
+				{"arn:aws:iam::1:role/team-a", webIdentityToken(key, expired), http.StatusBadRequest, "ExpiredTokenException"},
+				{"arn:aws:iam::1:role/team-a", "not.a.token", http.StatusBadRequest, "InvalidIdentityToken"},
+				{"arn:aws:iam::1:role/team-a", webIdentityToken(key, other), http.StatusForbidden, "AccessDenied"},
+				{"arn:aws:iam::1:role/unknown", webIdentityToken(key, claims), http.StatusForbidden, "AccessDenied"},
+				{"arn:aws:iam::1:user/team-a", webIdentityToken(key, claims), http.StatusBadRequest, "ValidationError"},
+			}
+			for _, c := range cases {
+				w := request(url.Values{"RoleArn": {c.role}, "RoleSessionName": {"alice"}, "WebIdentityToken": {c.token}})
+				convey.So(w.Code, convey.ShouldEqual, c.code)
+				convey.So(w.Body.String(), convey.ShouldContainSubstring, "<Code>"+c.want+"</Code>")
+			}
+		})
+
+		convey.Convey("not configured", func() {
+			a.STS, _ = NewSTS(config.STS{})
+			w := request(url.Values{"RoleArn": {"arn:aws:iam::1:role/team-a"}, "RoleSessionName": {"alice"}, "WebIdentityToken": {webIdentityToken(key, claims)}})
+			convey.So(w.Code, convey.ShouldEqual, http.StatusBadRequest)
+			convey.So(w.Body.String(), convey.ShouldContainSubstring, "<Code>InvalidAction</Code>")
+		})
+	})
+}
+
+func TestParseRoleArn(t *testing.T) {
+
+	convey.Convey("parseRoleArn", t, func() {
+		for arn, want := range map[string]string{
+			"arn:aws:iam::123:root":        "",
+			"arn:aws:iam::123:user/team-a": "team-a",
+			"arn:aws:iam:::role/team-b":    "team-b",
+		} {
+			user, ok := parseRoleArn(arn)
+			convey.So(ok, convey.ShouldBeTrue)
+			convey.So(user, convey.ShouldEqual, want)
+		}
+
+		for _, arn := range []string{"", "arn:aws:s3:::b", "arn:aws:iam::123:group/g", "arn:aws:iam::123:user/"} {
+			_, ok := parseRoleArn(arn)
+			convey.So(ok, convey.ShouldBeFalse)
+		}
+	})
+}
@@ -0,0 +1,97 @@
+package app
+
+import (
+	"errors"
+
+	"github.com/solution9th/S3Adapter/internal"
+	"github.com/solution9th/S3Adapter/internal/auth"
+	"github.com/solution9th/S3Adapter/internal/db"
+	"github.com/solution9th/S3Adapter/internal/gateway"
+	"github.com/solution9th/S3Adapter/internal/gateway/tiered"
+)
+
+var errApplicationNotFound = errors.New("application not found")
+
+// TierConfiguration 冷存储配置，热存储为应用本身的引擎
+type TierConfiguration struct {
+	AccessKey    string        `xml:"AccessKey"`
+	SecretKey    string        `xml:"SecretKey"`
+	Region       string        `xml:"Region"`
+	Engine       string        `xml:"Engine"`
+	StorageClass string        `xml:"StorageClass"`
+	Rules        []tiered.Rule `xml:"Rule"`
+}
+
+func (t *TierConfiguration) validate() bool {
+
+	if t.Engine == "" || t.AccessKey == "" || t.SecretKey == "" || t.Region == "" {
+		return false
+	}
+
+	if _, ok := internal.GatewayMap[t.Engine]; !ok {
+		return false
+	}
+
+	if len(t.Rules) == 0 {
+		return false
+	}
+	for _, r := range t.Rules {
+		if r.Validate() != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// newTiered 应用有冷存储配置时，把热存储和冷存储组合成分层存储
+func (a *API) newTiered(oak string, hot gateway.S3Protocol) (gateway.S3Protocol, error) {
+
+	t, err := a.DB.GetTier(oak)
+	if err == db.ErrNotFound {
+		return hot, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	rules, err := tiered.ParseRules(t.Rules)
+	if err != nil {
+		return nil, err
+	}
+
+	cold, err := a.Pool.Get(oak, t.ColdEngine, auth.Credentials{
+		AccessKey: t.ColdAccessKey, SecretKey: t.ColdSecretKey},
+		t.ColdRegion)
+	if err != nil {
+		return nil, err
+	}
+
+	return tiered.New(oak, hot, cold, tiered.Config{
+		Rules:            rules,
+		ColdStorageClass: t.ColdStorageClass,
+	}, a.DB), nil
+}
+
+// resolveTiered 后台迁移时根据 oak 获得分层存储，没有冷存储配置时返回 nil
+func (a *API) resolveTiered(oak string) (*tiered.Tiered, error) {
+
+	_, ak, sk, engine, region := a.getSecretKeyEngine(oak)
+	if engine == "" {
+		return nil, errApplicationNotFound
+	}
+
+	hot, err := a.Pool.Get(oak, engine, auth.Credentials{
+		AccessKey: ak, SecretKey: sk},
+		region)
+	if err != nil {
+		return nil, err
+	}
+
+	g, err := a.newTiered(oak, hot)
+	if err != nil {
+		return nil, err
+	}
+
+	t, _ := g.(*tiered.Tiered)
+	return t, nil
+}
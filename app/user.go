@@ -0,0 +1,295 @@
+package app
+
+import (
+	"context"
+	"encoding/xml"
+	"net/http"
+	"regexp"
+	"time"
+
+	"github.com/solution9th/S3Adapter/internal/db"
+	"github.com/solution9th/S3Adapter/internal/gerror"
+	"github.com/solution9th/S3Adapter/internal/policy"
+	"github.com/solution9th/S3Adapter/internal/reqinfo"
+
+	"github.com/haozibi/zlog"
+)
+
+// userNameRegexp 用户名，与 IAM 用户名的规则相同
+var userNameRegexp = regexp.MustCompile(`^[\w+=,.@-]{1,64}$`)
+
+// CreateUserConfiguration 在应用下创建用户的请求
+type CreateUserConfiguration struct {
+	// ApplicationAccessKey 应用任意一个 key
+	ApplicationAccessKey string `xml:"ApplicationAccessKey"`
+
+	UserName string `xml:"UserName"`
+
+	// Policy JSON 格式的身份策略
+	Policy string `xml:"Policy"`
+}
+
+// CreateUserResult 创建用户的结果
+type CreateUserResult struct {
+	UserName  string `xml:"UserName"`
+	AccessKey string `xml:"AccessKey"`
+	SecretKey string `xml:"SecretKey"`
+}
+
+// ListUsersResult 应用下所有的用户，不包括 SecretKey
+type ListUsersResult struct {
+	Users []UserInfo `xml:"User"`
+}
+
+// UserInfo 应用下的一个用户
+type UserInfo struct {
+	UserName   string    `xml:"UserName"`
+	AccessKey  string    `xml:"AccessKey"`
+	Policy     string    `xml:"Policy"`
+	CreateTime time.Time `xml:"CreateTime"`
+}
+
+// CreateUser 在应用下创建用户，用户使用自己的 key 访问应用的后端，
+// 权限由身份策略限制，需要使用管理 key 签名
+//
+// 请求:
+//
+//	POST /?createUser
+//	<CreateUserConfiguration>
+//		<ApplicationAccessKey></ApplicationAccessKey>
+//		<UserName></UserName>
+//		<Policy></Policy>
+//	</CreateUserConfiguration>
+//
+// 响应:
+//
+//	<CreateUserResult xmlns="http://s3.amazonaws.com/doc/2006-03-01/">
+//		<UserName></UserName>
+//		<AccessKey></AccessKey>
+//		<SecretKey></SecretKey>
+//	</CreateUserResult>
+func (a *API) CreateUser(w http.ResponseWriter, r *http.Request) {
+
+	ctx := newContext(w, r, "CreateUser")
+
+	reqinfo.ZDebug(ctx).Str("Method", "CreateUser").Msg("[debug]")
+
+	if errCode := a.authAdmin(r); errCode != gerror.ErrNone {
+		writeErrorResponseXML(ctx, w,
+			gerror.GetError(errCode, nil))
+		return
+	}
+
+	var c CreateUserConfiguration
+	if r.Body != nil {
+		err := xml.NewDecoder(r.Body).Decode(&c)
+		if err != nil {
+			reqinfo.ZError(ctx).Str("Method", "XMLDecode").Msg(err.Error())
+			writeErrorResponseXML(ctx, w,
+				gerror.GetError(gerror.ErrMalformedXML, err))
+			return
+		}
+	}
+
+	result, errCode := a.createUser(ctx, c)
+	if errCode != gerror.ErrNone {
+		writeErrorResponseXML(ctx, w,
+			gerror.GetError(errCode, nil))
+		return
+	}
+
+	formatWriteXML(w, http.StatusOK, "", result, true)
+}
+
+// ListUsers 列出应用下所有的用户，需要使用管理 key 签名
+//
+// 请求:
+//
+//	GET /?users&accessKey=<应用任意一个 key>
+func (a *API) ListUsers(w http.ResponseWriter, r *http.Request) {
+
+	ctx := newContext(w, r, "ListUsers")
+
+	reqinfo.ZDebug(ctx).Str("Method", "ListUsers").Msg("[debug]")
+
+	if errCode := a.authAdmin(r); errCode != gerror.ErrNone {
+		writeErrorResponseXML(ctx, w,
+			gerror.GetError(errCode, nil))
+		return
+	}
+
+	id, errCode := a.lookupAppID(ctx, r.URL.Query().Get("accessKey"))
+	if errCode != gerror.ErrNone {
+		writeErrorResponseXML(ctx, w,
+			gerror.GetError(errCode, nil))
+		return
+	}
+
+	users, err := db.WithContext(ctx, a.DB).ListUser(id)
+	if err != nil {
+		reqinfo.ZError(ctx).Str("Method", "ListUser").Msg(err.Error())
+		writeErrorResponseXML(ctx, w,
+			gerror.GetError(gerror.ErrInternalError, nil))
+		return
+	}
+
+	var result ListUsersResult
+	for _, u := range users {
+		result.Users = append(result.Users, UserInfo{
+			UserName:   u.UserName,
+			AccessKey:  u.AccessKey,
+			Policy:     u.Policy,
+			CreateTime: u.CreateTime,
+		})
+	}
+
+	formatWriteXML(w, http.StatusOK, "", result, true)
+}
+
+// DeleteUser 删除应用下的用户，用户的 key 立即失效，需要使用管理 key 签名
+//
+// 请求:
+//
+//	DELETE /?user&accessKey=<应用任意一个 key>&userName=<用户名>
+func (a *API) DeleteUser(w http.ResponseWriter, r *http.Request) {
+
+	ctx := newContext(w, r, "DeleteUser")
+
+	reqinfo.ZDebug(ctx).Str("Method", "DeleteUser").Msg("[debug]")
+
+	if errCode := a.authAdmin(r); errCode != gerror.ErrNone {
+		writeErrorResponseXML(ctx, w,
+			gerror.GetError(errCode, nil))
+		return
+	}
+
+	errCode := a.deleteUser(ctx, r.URL.Query().Get("accessKey"), r.URL.Query().Get("userName"))
+	if errCode != gerror.ErrNone {
+		writeErrorResponseXML(ctx, w,
+			gerror.GetError(errCode, nil))
+		return
+	}
+
+	writeSuccessResponseHeadersOnly(w)
+}
+
+// lookupAppID 不使用缓存查找 oak 所属应用创建时的 key
+func (a *API) lookupAppID(ctx context.Context, oak string) (string, gerror.APIErrorCode) {
+
+	if oak == "" {
+		return "", gerror.ErrInvalidRequestParameter
+	}
+
+	m, err := a.lookupApp(ctx, oak)
+	if err == db.ErrNotFound {
+		return "", gerror.ErrInvalidAccessKeyID
+	}
+	if err != nil {
+		return "", gerror.ErrInternalError
+	}
+	return m.OsAccessKey, gerror.ErrNone
+}
+
+func (a *API) createUser(ctx context.Context, c CreateUserConfiguration) (result CreateUserResult, errCode gerror.APIErrorCode) {
+
+	if !userNameRegexp.MatchString(c.UserName) {
+		return result, gerror.ErrInvalidRequestParameter
+	}
+
+	if _, err := policy.Parse(c.Policy); err != nil {
+		zlog.ZWarn().Str("User", c.UserName).Msg("[Admin] invalid policy: " + err.Error())
+		if err == policy.ErrTooLarge {
+			return result, gerror.ErrPolicyTooLarge
+		}
+		return result, gerror.ErrMalformedPolicy
+	}
+
+	id, errCode := a.lookupAppID(ctx, c.ApplicationAccessKey)
+	if errCode != gerror.ErrNone {
+		return result, errCode
+	}
+
+	d := db.WithContext(ctx, a.DB)
+
+	users, err := d.ListUser(id)
+	if err != nil {
+		zlog.ZError().Str("Method", "listUser").Msg(err.Error())
+		return result, gerror.ErrInternalError
+	}
+	for _, u := range users {
+		if u.UserName == c.UserName {
+			return result, gerror.ErrUserAlreadyExists
+		}
+	}
+
+	ak, sk := genAccessKey(), genSecretKey()
+	_, err = d.SaveUser(map[string]interface{}{
+		"os_access_key": id,
+		"user_name":     c.UserName,
+		"access_key":    ak,
+		"secret_key":    sk,
+		"policy":        c.Policy,
+	})
+	if err != nil {
+		zlog.ZError().Str("Method", "saveUser").Msg(err.Error())
+		return result, gerror.ErrInternalError
+	}
+
+	// 可能缓存了"不存在"
+	a.Cache.Remove(ak)
+
+	zlog.ZInfo().Str("App", id).Str("User", c.UserName).Str("AK", ak).Msg("[Admin] create user")
+
+	return CreateUserResult{UserName: c.UserName, AccessKey: ak, SecretKey: sk}, gerror.ErrNone
+}
+
+func (a *API) deleteUser(ctx context.Context, oak, name string) gerror.APIErrorCode {
+
+	if name == "" {
+		return gerror.ErrInvalidRequestParameter
+	}
+
+	id, errCode := a.lookupAppID(ctx, oak)
+	if errCode != gerror.ErrNone {
+		return errCode
+	}
+
+	d := db.WithContext(ctx, a.DB)
+
+	users, err := d.ListUser(id)
+	if err != nil {
+		zlog.ZError().Str("Method", "listUser").Msg(err.Error())
+		return gerror.ErrInternalError
+	}
+
+	sessions, err := d.ListSession(id)
+	if err != nil {
+		zlog.ZError().Str("Method", "listSession").Msg(err.Error())
+		return gerror.ErrInternalError
+	}
+
+	err = d.DeleteUser(id, name)
+	if err == db.ErrNotFound {
+		return gerror.ErrNoSuchUser
+	}
+	if err != nil {
+		zlog.ZError().Str("Method", "deleteUser").Msg(err.Error())
+		return gerror.ErrInternalError
+	}
+
+	for _, u := range users {
+		if u.UserName == name {
+			a.Cache.Remove(u.AccessKey)
+		}
+	}
+	// 签发给用户的临时凭证缓存了用户的策略，同时失效
+	for _, s := range sessions {
+		if s.UserName == name {
+			a.Cache.Remove(s.AccessKey)
+		}
+	}
+
+	zlog.ZInfo().Str("App", id).Str("User", name).Msg("[Admin] delete user")
+
+	return gerror.ErrNone
+}
@@ -0,0 +1,243 @@
+package app
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/solution9th/S3Adapter/internal/cache"
+	"github.com/solution9th/S3Adapter/internal/config"
+	"github.com/solution9th/S3Adapter/internal/db"
+	"github.com/solution9th/S3Adapter/internal/db/mysql"
+	"github.com/solution9th/S3Adapter/internal/gerror"
+	"github.com/solution9th/S3Adapter/internal/reqinfo"
+	"github.com/solution9th/S3Adapter/mocks/mock_db"
+
+	"github.com/golang/mock/gomock"
+	"github.com/gorilla/mux"
+	"github.com/smartystreets/goconvey/convey"
+)
+
+const testUserPolicy = `{"Statement":[
+	{"Effect":"Allow","Action":["s3:GetObject","s3:PutObject"],"Resource":"arn:aws:s3:::photos/team-a/*"},
+	{"Effect":"Allow","Action":"s3:ListBucket","Resource":"arn:aws:s3:::photos"}]}`
+
+func TestCreateUser(t *testing.T) {
+
+	convey.Convey("createUser", t, func() {
+
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		mockDB := mock_db.NewMockDB(ctrl)
+		a := &API{DB: mockDB}
+
+		info := mysql.Info{OsAccessKey: "oak", OsScrectKey: "osk", EngineType: "s3"}
+
+		convey.Convey("success", func() {
+			var saved map[string]interface{}
+
+			mockDB.EXPECT().GetInfo("new").Return(info, nil)
+			mockDB.EXPECT().ListUser("oak").Return([]db.User{{UserName: "other"}}, nil)
+			mockDB.EXPECT().SaveUser(gomock.Any()).Do(func(data map[string]interface{}) {
+				saved = data
+			}).Return(1, nil)
+
+			result, errCode := a.createUser(context.Background(), CreateUserConfiguration{
+				ApplicationAccessKey: "new", UserName: "team-a", Policy: testUserPolicy})
+			convey.So(errCode, convey.ShouldEqual, gerror.ErrNone)
+			convey.So(saved["os_access_key"], convey.ShouldEqual, "oak")
+			convey.So(saved["user_name"], convey.ShouldEqual, "team-a")
+			convey.So(saved["access_key"], convey.ShouldEqual, result.AccessKey)
+			convey.So(saved["secret_key"], convey.ShouldEqual, result.SecretKey)
+			convey.So(saved["policy"], convey.ShouldEqual, testUserPolicy)
+		})
+
+		convey.Convey("err: user exists", func() {
+			mockDB.EXPECT().GetInfo("oak").Return(info, nil)
+			mockDB.EXPECT().ListUser("oak").Return([]db.User{{UserName: "team-a"}}, nil)
+
+			_, errCode := a.createUser(context.Background(), CreateUserConfiguration{
+				ApplicationAccessKey: "oak", UserName: "team-a", Policy: testUserPolicy})
+			convey.So(errCode, convey.ShouldEqual, gerror.ErrUserAlreadyExists)
+		})
+
+		convey.Convey("err: user key is not an application key", func() {
+			user := info
+			user.UserName = "team-a"
+			mockDB.EXPECT().GetInfo("uak").Return(user, nil)
+
+			_, errCode := a.createUser(context.Background(), CreateUserConfiguration{
+				ApplicationAccessKey: "uak", UserName: "team-b", Policy: testUserPolicy})
+			convey.So(errCode, convey.ShouldEqual, gerror.ErrInvalidAccessKeyID)
+		})
+
+		convey.Convey("err: invalid params", func() {
+			_, errCode := a.createUser(context.Background(), CreateUserConfiguration{
+				ApplicationAccessKey: "oak", UserName: "team a", Policy: testUserPolicy})
+			convey.So(errCode, convey.ShouldEqual, gerror.ErrInvalidRequestParameter)
+
+			_, errCode = a.createUser(context.Background(), CreateUserConfiguration{
+				ApplicationAccessKey: "oak", UserName: "team-a", Policy: `{"Statement":[]}`})
+			convey.So(errCode, convey.ShouldEqual, gerror.ErrMalformedPolicy)
+
+			// 用户必须有身份策略
+			_, errCode = a.createUser(context.Background(), CreateUserConfiguration{
+				ApplicationAccessKey: "oak", UserName: "team-a"})
+			convey.So(errCode, convey.ShouldEqual, gerror.ErrMalformedPolicy)
+		})
+	})
+}
+
+func TestUserAPI(t *testing.T) {
+
+	convey.Convey("user api", t, func() {
+
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		mockDB := mock_db.NewMockDB(ctrl)
+		a := &API{DB: mockDB, Admin: NewAdmin(config.Admin{AccessKey: "admin", SecretKey: "admin-secret"})}
+
+		r := mux.NewRouter()
+		NewAPIRouter(r, a)
+
+		info := mysql.Info{OsAccessKey: "oak", OsScrectKey: "osk", EngineType: "s3"}
+
+		convey.Convey("list users", func() {
+			mockDB.EXPECT().GetInfo("oak").Return(info, nil)
+			mockDB.EXPECT().ListUser("oak").Return([]db.User{
+				{OsAccessKey: "oak", UserName: "team-a", AccessKey: "uak", SecretKey: "usk", Policy: testUserPolicy}}, nil)
+
+			req := httptest.NewRequest(http.MethodGet, "/?users&accessKey=oak", nil)
+			signRequest(req, "admin", "admin-secret")
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+
+			convey.So(w.Code, convey.ShouldEqual, http.StatusOK)
+			body := w.Body.String()
+			convey.So(body, convey.ShouldContainSubstring, "<UserName>team-a</UserName>")
+			convey.So(body, convey.ShouldNotContainSubstring, "usk")
+		})
+
+		convey.Convey("delete user", func() {
+			a.Cache = cache.New(10, time.Minute, time.Minute)
+			for _, ak := range []string{"uak", "tak1", "tak2"} {
+				a.Cache.Add(ak, mysql.Info{OsAccessKey: "oak"})
+			}
+
+			mockDB.EXPECT().GetInfo("oak").Return(info, nil)
+			mockDB.EXPECT().ListUser("oak").Return([]db.User{{OsAccessKey: "oak", UserName: "team-a", AccessKey: "uak"}}, nil)
+			mockDB.EXPECT().ListSession("oak").Return([]db.Session{
+				{OsAccessKey: "oak", AccessKey: "tak1", UserName: "team-a"},
+				{OsAccessKey: "oak", AccessKey: "tak2", UserName: "team-b"},
+			}, nil)
+			mockDB.EXPECT().DeleteUser("oak", "team-a").Return(nil)
+
+			req := httptest.NewRequest(http.MethodDelete, "/?user&accessKey=oak&userName=team-a", nil)
+			signRequest(req, "admin", "admin-secret")
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+
+			convey.So(w.Code, convey.ShouldEqual, http.StatusOK)
+
+			// 用户的 key 和签发给用户的临时凭证不再使用缓存
+			for ak, cached := range map[string]bool{"uak": false, "tak1": false, "tak2": true} {
+				_, _, hit := a.Cache.Get(ak)
+				convey.So(hit, convey.ShouldEqual, cached)
+			}
+		})
+
+		convey.Convey("delete unknown user", func() {
+			mockDB.EXPECT().GetInfo("oak").Return(info, nil)
+			mockDB.EXPECT().ListUser("oak").Return(nil, nil)
+			mockDB.EXPECT().ListSession("oak").Return(nil, nil)
+			mockDB.EXPECT().DeleteUser("oak", "none").Return(db.ErrNotFound)
+
+			req := httptest.NewRequest(http.MethodDelete, "/?user&accessKey=oak&userName=none", nil)
+			signRequest(req, "admin", "admin-secret")
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+
+			convey.So(w.Code, convey.ShouldEqual, http.StatusNotFound)
+		})
+
+		convey.Convey("err: not admin", func() {
+			req := httptest.NewRequest(http.MethodPost, "/?createUser", strings.NewReader(
+				"<CreateUserConfiguration><ApplicationAccessKey>oak</ApplicationAccessKey></CreateUserConfiguration>"))
+			signRequest(req, "oak", "osk")
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+
+			convey.So(w.Code, convey.ShouldEqual, http.StatusForbidden)
+		})
+	})
+}
+
+func TestUserPolicy(t *testing.T) {
+
+	convey.Convey("user policy", t, func() {
+
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		mockDB := mock_db.NewMockDB(ctrl)
+		a := &API{DB: mockDB}
+
+		mockDB.EXPECT().GetInfo("uak").Return(mysql.Info{
+			OsAccessKey: "oak", OsScrectKey: "usk", EngineType: "s3", EngineAccessKey: "ak", EngineSecretKey: "sk",
+			UserName: "team-a", Policy: testUserPolicy}, nil).AnyTimes()
+		mockDB.EXPECT().GetInfo("oak").Return(mysql.Info{
+			OsAccessKey: "oak", OsScrectKey: "osk", EngineType: "s3", EngineAccessKey: "ak", EngineSecretKey: "sk"}, nil).AnyTimes()
+		mockDB.EXPECT().GetInfo("nak").Return(mysql.Info{
+			OsAccessKey: "oak", OsScrectKey: "nsk", EngineType: "s3", EngineAccessKey: "ak", EngineSecretKey: "sk",
+			UserName: "team-c"}, nil).AnyTimes()
+
+		request := func(method, target, api string, vars map[string]string, ak, sk string) *http.Request {
+			req := httptest.NewRequest(method, target, nil)
+			if api == "CopyObject" {
+				req.Header.Set("X-Amz-Copy-Source", "/photos/team-b/1.jpg")
+			}
+			signRequest(req, ak, sk)
+			req = mux.SetURLVars(req, vars)
+			return req.WithContext(reqinfo.NewContext(req.Context(), &reqinfo.ReqInfo{API: api}))
+		}
+
+		object := func(key string) map[string]string {
+			return map[string]string{"bucket": "photos", "object": key}
+		}
+		bucket := map[string]string{"bucket": "photos"}
+
+		cases := []struct {
+			method, target, api string
+			vars                map[string]string
+			want                gerror.APIErrorCode
+		}{
+			{http.MethodGet, "/photos/team-a/1.jpg", "GetObject", object("team-a/1.jpg"), gerror.ErrNone},
+			{http.MethodPut, "/photos/team-a/2.jpg", "PutObject", object("team-a/2.jpg"), gerror.ErrNone},
+			{http.MethodGet, "/photos/team-b/1.jpg", "GetObject", object("team-b/1.jpg"), gerror.ErrAccessDenied},
+			{http.MethodDelete, "/photos/team-a/1.jpg", "DeleteObject", object("team-a/1.jpg"), gerror.ErrAccessDenied},
+			{http.MethodGet, "/photos?prefix=team-a/", "GetBucketV1", bucket, gerror.ErrNone},
+			{http.MethodGet, "/", "ListBuckets", nil, gerror.ErrAccessDenied},
+			{http.MethodDelete, "/", "DeleteApplication", nil, gerror.ErrAccessDenied},
+			// 复制需要源对象的读权限
+			{http.MethodPut, "/photos/team-a/3.jpg", "CopyObject", object("team-a/3.jpg"), gerror.ErrAccessDenied},
+		}
+
+		for _, c := range cases {
+			req := request(c.method, c.target, c.api, c.vars, "uak", "usk")
+			convey.So(a.Auth(req), convey.ShouldEqual, c.want)
+		}
+
+		// 应用自己的 key 不受限制
+		req := request(http.MethodGet, "/photos/team-b/1.jpg", "GetObject", object("team-b/1.jpg"), "oak", "osk")
+		convey.So(a.Auth(req), convey.ShouldEqual, gerror.ErrNone)
+
+		// 没有身份策略的用户不能访问任何资源
+		req = request(http.MethodGet, "/photos/team-a/1.jpg", "GetObject", object("team-a/1.jpg"), "nak", "nsk")
+		convey.So(a.Auth(req), convey.ShouldEqual, gerror.ErrAccessDenied)
+	})
+}
@@ -5,8 +5,11 @@ import (
 	"fmt"
 	"math/rand"
 	"net/http"
+	"sync/atomic"
 	"time"
 
+	"github.com/solution9th/S3Adapter/internal/reqinfo"
+
 	"github.com/gorilla/mux"
 )
 
@@ -18,8 +21,11 @@ func init() {
 	rand.Seed(time.Now().UnixNano())
 }
 
+var requestSeq uint32
+
+// getRequestID 生成请求 ID，同一纳秒内的请求通过序号区分
 func getRequestID() string {
-	return fmt.Sprintf("%X", time.Now().UnixNano())
+	return fmt.Sprintf("%X%04X", time.Now().UnixNano(), atomic.AddUint32(&requestSeq, 1)&0xFFFF)
 }
 
 func genAccessKey() string {
@@ -53,13 +59,28 @@ func genRandomBytes(size int, base string) []byte {
 	return bts
 }
 
-// todo, 生成 request id 等跟踪信息
+// newContext 返回请求的 context，包含请求 ID、API 名称、bucket 和 object，
+// 客户端断开连接时 context 会被取消，后端的请求也随之取消
 func newContext(w http.ResponseWriter, r *http.Request, api string) context.Context {
 
+	ctx := r.Context()
+
+	info := reqinfo.FromContext(ctx)
+	if info == nil {
+		// 没有经过 requestMiddleware，比如测试中直接调用 handler
+		info = &reqinfo.ReqInfo{RequestID: getRequestID(), RemoteAddr: r.RemoteAddr}
+		ctx = reqinfo.NewContext(ctx, info)
+	}
+
+	// 后端返回的请求 ID 会覆盖这里的值
+	if w.Header().Get(responseRequestIDKey) == "" {
+		w.Header().Set(responseRequestIDKey, info.RequestID)
+	}
+
 	vars := mux.Vars(r)
-	bucket, object, prefix := vars["bucket"], vars["object"], vars["prefix"]
+	info.API = api
+	info.Bucket = vars["bucket"]
+	info.Object = vars["object"]
 
-	_, _, _ = bucket, object, prefix
-	// ReqInfo
-	return context.Background()
+	return ctx
 }
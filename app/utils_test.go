@@ -1,8 +1,15 @@
 package app
 
 import (
+	"context"
 	"fmt"
+	"net/http"
+	"net/http/httptest"
 	"testing"
+
+	"github.com/solution9th/S3Adapter/internal/reqinfo"
+
+	"github.com/gorilla/mux"
 )
 
 func TestGenRandomString(t *testing.T) {
@@ -19,3 +26,55 @@ func TestGetRequestID(t *testing.T) {
 
 	getRequestID()
 }
+
+func TestNewContext(t *testing.T) {
+
+	var (
+		ctx context.Context
+		id  string
+	)
+
+	r := mux.NewRouter()
+	r.Use(requestMiddleware)
+	r.HandleFunc("/{bucket}/{object:.+}", func(w http.ResponseWriter, r *http.Request) {
+		ctx = newContext(w, r, "GetObject")
+		id = w.Header().Get(responseRequestIDKey)
+		writeS3Header(w, http.Header{responseRequestIDKey: []string{""}})
+	})
+
+	parent, cancel := context.WithCancel(context.Background())
+	req := httptest.NewRequest(http.MethodGet, "/bucket/a/b", nil).WithContext(parent)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	info := reqinfo.FromContext(ctx)
+	if info == nil {
+		t.Fatal("request info not found")
+	}
+	if info.API != "GetObject" || info.Bucket != "bucket" || info.Object != "a/b" {
+		t.Fatalf("request info: %+v", info)
+	}
+	if id == "" || info.RequestID != id || w.Header().Get(responseRequestIDKey) != id {
+		t.Fatalf("request id: %q %q %q", info.RequestID, id, w.Header().Get(responseRequestIDKey))
+	}
+
+	// 客户端断开连接，后端的请求随之取消
+	cancel()
+	select {
+	case <-ctx.Done():
+	default:
+		t.Fatal("context not canceled")
+	}
+}
+
+func TestRequestIDUnique(t *testing.T) {
+
+	ids := make(map[string]bool)
+	for i := 0; i < 10000; i++ {
+		id := getRequestID()
+		if ids[id] {
+			t.Fatalf("duplicate request id %s", id)
+		}
+		ids[id] = true
+	}
+}
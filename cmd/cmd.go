@@ -48,6 +48,8 @@ func init() {
 	rootCmd.AddCommand(versionCMD)
 	rootCmd.AddCommand(webCMD)
 	rootCmd.AddCommand(configCMD)
+	rootCmd.AddCommand(healCMD)
+	rootCmd.AddCommand(rekeyCMD)
 }
 
 func initConfig() {
@@ -102,6 +104,38 @@ func initConfig() {
 	viper.BindEnv("mysql.passwd")
 	viper.BindEnv("mysql.host")
 	viper.BindEnv("mysql.dbname")
+	viper.BindEnv("tiering.interval")
+	viper.BindEnv("tiering.batch")
+	viper.BindEnv("mirror.interval")
+	viper.BindEnv("mirror.batch")
+	viper.BindEnv("client.idletimeout")
+	viper.BindEnv("client.maxidleconns")
+	viper.BindEnv("client.maxidleconnsperhost")
+	viper.BindEnv("client.idleconntimeout")
+	viper.BindEnv("client.dialtimeout")
+	viper.BindEnv("client.tlshandshaketimeout")
+	viper.BindEnv("client.responseheadertimeout")
+	viper.BindEnv("cache.size")
+	viper.BindEnv("cache.ttl")
+	viper.BindEnv("cache.negativettl")
+	viper.BindEnv("tracing.endpoint")
+	viper.BindEnv("tracing.servicename")
+	viper.BindEnv("tracing.interval")
+	viper.BindEnv("accesslog.path")
+	viper.BindEnv("accesslog.maxsize")
+	viper.BindEnv("accesslog.maxbackups")
+	viper.BindEnv("health.timeout")
+	viper.BindEnv("health.shutdowndelay")
+	viper.BindEnv("admin.accesskey")
+	viper.BindEnv("admin.secretkey")
+	viper.BindEnv("admin.keygraceperiod")
+	viper.BindEnv("encryption.masterkey")
+	viper.BindEnv("encryption.keyfile")
+	viper.BindEnv("sts.maxduration")
+	viper.BindEnv("tls.certfile")
+	viper.BindEnv("tls.keyfile")
+	viper.BindEnv("tls.clientcafile")
+	viper.BindEnv("tls.requireclientcert")
 	viper.AutomaticEnv()
 
 	if err := viper.ReadInConfig(); err == nil {
@@ -0,0 +1,58 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/solution9th/S3Adapter/app"
+	"github.com/solution9th/S3Adapter/internal/config"
+
+	"github.com/haozibi/zlog"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var healApp, healBucket string
+
+func init() {
+
+	healCMD.Flags().StringVarP(&healApp, "app", "", "", "application access key")
+	healCMD.Flags().StringVarP(&healBucket, "bucket", "", "", "only heal this bucket")
+}
+
+var healCMD = &cobra.Command{
+	Use:   "heal",
+	Short: "Rebuild missing erasure shards",
+	Run: func(cmd *cobra.Command, args []string) {
+
+		var p config.Config
+		err := viper.Unmarshal(&p)
+		if err != nil {
+			panic(err)
+		}
+
+		if healApp == "" {
+			zlog.ZError().Msg("[heal] --app is required")
+			os.Exit(1)
+		}
+
+		if p.Server.LogPath == "" {
+			p.Server.LogPath = "-"
+		}
+
+		if v := isNil(p.MySQL); v != "" {
+			zlog.ZError().Str("Field", v).Msg("[config] value is nil")
+			os.Exit(1)
+		}
+
+		result, err := app.Heal(p, healApp, healBucket)
+		fmt.Printf("objects: %d, shards: %d, failed: %d\n", result.Objects, result.Shards, result.Failed)
+		if err != nil {
+			zlog.ZError().Msg("[heal] error:" + err.Error())
+			os.Exit(1)
+		}
+		if result.Failed > 0 {
+			os.Exit(1)
+		}
+	},
+}
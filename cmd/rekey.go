@@ -0,0 +1,46 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/solution9th/S3Adapter/app"
+	"github.com/solution9th/S3Adapter/internal/config"
+
+	"github.com/haozibi/zlog"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var rekeyOldKey, rekeyOldKeyFile string
+
+func init() {
+
+	rekeyCMD.Flags().StringVarP(&rekeyOldKey, "old-key", "", "", "old base64 master key, empty if keys are stored in plaintext")
+	rekeyCMD.Flags().StringVarP(&rekeyOldKeyFile, "old-key-file", "", "", "file containing the old base64 master key")
+}
+
+var rekeyCMD = &cobra.Command{
+	Use:   "rekey",
+	Short: "Re-encrypt stored keys with the configured master key",
+	Run: func(cmd *cobra.Command, args []string) {
+
+		var p config.Config
+		err := viper.Unmarshal(&p)
+		if err != nil {
+			panic(err)
+		}
+
+		if v := isNil(p.MySQL); v != "" {
+			zlog.ZError().Str("Field", v).Msg("[config] value is nil")
+			os.Exit(1)
+		}
+
+		n, err := app.Rekey(p, rekeyOldKey, rekeyOldKeyFile)
+		fmt.Printf("rows: %d\n", n)
+		if err != nil {
+			zlog.ZError().Msg("[rekey] error:" + err.Error())
+			os.Exit(1)
+		}
+	},
+}
@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS `%s`  (
+  `id` int(11) NOT NULL AUTO_INCREMENT,
+  `os_access_key` char(30) NOT NULL COMMENT '本地key',
+  `identity` varchar(255) NOT NULL COMMENT '客户端证书的 SAN 或者 subject，比如 uri:spiffe://example.org/svc-a',
+  `create_time` timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP COMMENT '添加时间',
+  PRIMARY KEY (`id`),
+  UNIQUE KEY `uk_identity` (`identity`),
+  KEY `idx_os_access_key` (`os_access_key`)
+) ENGINE=InnoDB AUTO_INCREMENT=1 DEFAULT CHARSET=utf8mb4
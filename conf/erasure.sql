@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS `%s`  (
+  `id` int(11) NOT NULL AUTO_INCREMENT,
+  `os_access_key` char(30) NOT NULL COMMENT '本地key',
+  `data_shards` int(11) NOT NULL COMMENT '数据块数量',
+  `parity_shards` int(11) NOT NULL COMMENT '校验块数量',
+  `backends` text NOT NULL COMMENT '保存分片的引擎，JSON 格式',
+  `create_time` timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP COMMENT '添加时间',
+  PRIMARY KEY (`id`),
+  UNIQUE KEY `uk_os_access_key` (`os_access_key`)
+) ENGINE=InnoDB AUTO_INCREMENT=1 DEFAULT CHARSET=utf8mb4
@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS `%s`  (
+  `id` int(11) NOT NULL AUTO_INCREMENT,
+  `os_access_key` char(30) NOT NULL COMMENT '应用的本地key',
+  `access_key` char(30) NOT NULL COMMENT '轮换后的本地key',
+  `secret_key` varchar(255) NOT NULL COMMENT '轮换后的本地key',
+  `expire_time` timestamp NULL DEFAULT NULL COMMENT '过期时间，为空则不过期',
+  `create_time` timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP COMMENT '添加时间',
+  PRIMARY KEY (`id`),
+  UNIQUE KEY `uk_access_key` (`access_key`),
+  KEY `idx_os_access_key` (`os_access_key`)
+) ENGINE=InnoDB AUTO_INCREMENT=1 DEFAULT CHARSET=utf8mb4
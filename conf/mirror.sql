@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS `%s`  (
+  `id` int(11) NOT NULL AUTO_INCREMENT,
+  `os_access_key` char(30) NOT NULL COMMENT '本地key',
+  `engine` char(10) NOT NULL COMMENT '镜像引擎',
+  `region` varchar(255) NOT NULL COMMENT '镜像引擎具体region',
+  `access_key` char(40) NOT NULL COMMENT '镜像引擎具体的key',
+  `secret_key` varchar(255) NOT NULL COMMENT '镜像引擎具体的key',
+  `create_time` timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP COMMENT '添加时间',
+  PRIMARY KEY (`id`),
+  UNIQUE KEY `uk_os_access_key` (`os_access_key`)
+) ENGINE=InnoDB AUTO_INCREMENT=1 DEFAULT CHARSET=utf8mb4
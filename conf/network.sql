@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS `%s`  (
+  `id` int(11) NOT NULL AUTO_INCREMENT,
+  `os_access_key` char(30) NOT NULL COMMENT '本地key',
+  `allow` text NOT NULL COMMENT '允许的客户端 CIDR，逗号分隔，为空则不限制',
+  `deny` text NOT NULL COMMENT '拒绝的客户端 CIDR，逗号分隔',
+  `update_time` timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP COMMENT '修改时间',
+  PRIMARY KEY (`id`),
+  UNIQUE KEY `uk_os_access_key` (`os_access_key`)
+) ENGINE=InnoDB AUTO_INCREMENT=1 DEFAULT CHARSET=utf8mb4
@@ -0,0 +1,13 @@
+CREATE TABLE IF NOT EXISTS `%s`  (
+  `id` bigint(20) NOT NULL AUTO_INCREMENT,
+  `os_access_key` char(30) NOT NULL COMMENT '本地key',
+  `bucket` varchar(63) NOT NULL COMMENT 'bucket',
+  `object` varchar(1024) NOT NULL COMMENT 'object',
+  `object_md5` char(32) NOT NULL COMMENT 'object 的 md5，用于唯一索引',
+  `tier` char(10) NOT NULL COMMENT '所在存储层',
+  `size` bigint(20) NOT NULL DEFAULT 0 COMMENT '对象大小',
+  `modify_time` timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP COMMENT '写入时间',
+  PRIMARY KEY (`id`),
+  UNIQUE KEY `uk_object` (`os_access_key`, `bucket`, `object_md5`),
+  KEY `idx_tier` (`tier`, `id`)
+) ENGINE=InnoDB AUTO_INCREMENT=1 DEFAULT CHARSET=utf8mb4
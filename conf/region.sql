@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS `%s`  (
+  `id` int(11) NOT NULL AUTO_INCREMENT,
+  `os_access_key` char(30) NOT NULL COMMENT '本地key',
+  `region` varchar(64) NOT NULL COMMENT '请求签名使用的地区',
+  `engine_region` varchar(255) NOT NULL COMMENT '对应的后端引擎地区',
+  `create_time` timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP COMMENT '添加时间',
+  PRIMARY KEY (`id`),
+  UNIQUE KEY `uk_os_access_key_region` (`os_access_key`, `region`)
+) ENGINE=InnoDB AUTO_INCREMENT=1 DEFAULT CHARSET=utf8mb4
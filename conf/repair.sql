@@ -0,0 +1,14 @@
+CREATE TABLE IF NOT EXISTS `%s`  (
+  `id` bigint(20) NOT NULL AUTO_INCREMENT,
+  `os_access_key` char(30) NOT NULL COMMENT '本地key',
+  `bucket` varchar(63) NOT NULL COMMENT 'bucket',
+  `object` varchar(1024) NOT NULL DEFAULT '' COMMENT 'object，为空时修复 bucket',
+  `object_md5` char(32) NOT NULL COMMENT 'object 的 md5，用于唯一索引',
+  `attempts` int(11) NOT NULL DEFAULT 0 COMMENT '重试次数',
+  `next_retry_time` timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP COMMENT '下次重试时间',
+  `last_error` varchar(255) NOT NULL DEFAULT '' COMMENT '最后一次失败的原因',
+  `create_time` timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP COMMENT '添加时间',
+  PRIMARY KEY (`id`),
+  UNIQUE KEY `uk_object` (`os_access_key`, `bucket`, `object_md5`),
+  KEY `idx_next_retry_time` (`next_retry_time`)
+) ENGINE=InnoDB AUTO_INCREMENT=1 DEFAULT CHARSET=utf8mb4
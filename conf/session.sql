@@ -0,0 +1,15 @@
+CREATE TABLE IF NOT EXISTS `%s`  (
+  `id` int(11) NOT NULL AUTO_INCREMENT,
+  `os_access_key` char(30) NOT NULL COMMENT '应用的本地key',
+  `access_key` char(30) NOT NULL COMMENT '临时的本地key',
+  `secret_key` varchar(255) NOT NULL COMMENT '临时的本地key',
+  `session_token` char(64) NOT NULL COMMENT 'token 的 SHA256',
+  `user_name` varchar(64) NOT NULL DEFAULT '' COMMENT '应用下的用户名，为空则为应用本身',
+  `policy` text NOT NULL COMMENT '会话策略',
+  `expire_time` timestamp NOT NULL COMMENT '过期时间',
+  `create_time` timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP COMMENT '添加时间',
+  PRIMARY KEY (`id`),
+  UNIQUE KEY `uk_access_key` (`access_key`),
+  KEY `idx_os_access_key` (`os_access_key`),
+  KEY `idx_expire_time` (`expire_time`)
+) ENGINE=InnoDB AUTO_INCREMENT=1 DEFAULT CHARSET=utf8mb4
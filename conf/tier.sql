@@ -0,0 +1,13 @@
+CREATE TABLE IF NOT EXISTS `%s`  (
+  `id` int(11) NOT NULL AUTO_INCREMENT,
+  `os_access_key` char(30) NOT NULL COMMENT '本地key',
+  `cold_engine` char(10) NOT NULL COMMENT '冷存储引擎',
+  `cold_region` varchar(255) NOT NULL COMMENT '冷存储引擎具体region',
+  `cold_access_key` char(40) NOT NULL COMMENT '冷存储引擎具体的key',
+  `cold_secret_key` varchar(255) NOT NULL COMMENT '冷存储引擎具体的key',
+  `cold_storage_class` varchar(20) NOT NULL DEFAULT '' COMMENT '冷存储的存储类型',
+  `rules` text NOT NULL COMMENT '迁移规则',
+  `create_time` timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP COMMENT '添加时间',
+  PRIMARY KEY (`id`),
+  UNIQUE KEY `uk_os_access_key` (`os_access_key`)
+) ENGINE=InnoDB AUTO_INCREMENT=1 DEFAULT CHARSET=utf8mb4
@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS `%s`  (
+  `id` int(11) NOT NULL AUTO_INCREMENT,
+  `os_access_key` char(30) NOT NULL COMMENT '应用的本地key',
+  `user_name` varchar(64) NOT NULL COMMENT '用户名',
+  `access_key` char(30) NOT NULL COMMENT '用户的本地key',
+  `secret_key` varchar(255) NOT NULL COMMENT '用户的本地key',
+  `policy` text NOT NULL COMMENT '身份策略',
+  `create_time` timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP COMMENT '添加时间',
+  PRIMARY KEY (`id`),
+  UNIQUE KEY `uk_access_key` (`access_key`),
+  UNIQUE KEY `uk_user_name` (`os_access_key`, `user_name`)
+) ENGINE=InnoDB AUTO_INCREMENT=1 DEFAULT CHARSET=utf8mb4
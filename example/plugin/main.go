@@ -0,0 +1,37 @@
+// 参考插件，基于内置的 s3 引擎，可以用来测试插件协议
+//
+//	$ go run ./example/plugin --listen 127.0.0.1:9300
+//
+// osconfig.yml:
+//
+//	plugins:
+//	  - name: s3plugin
+//	    address: http://127.0.0.1:9300
+//	    timeout: 5m
+package main
+
+import (
+	"flag"
+	"log"
+	"net/http"
+
+	"github.com/solution9th/S3Adapter/internal/auth"
+	"github.com/solution9th/S3Adapter/internal/gateway"
+	"github.com/solution9th/S3Adapter/internal/gateway/plugin"
+	s3gw "github.com/solution9th/S3Adapter/internal/gateway/s3"
+)
+
+func main() {
+
+	listen := flag.String("listen", "127.0.0.1:9300", "listen address")
+	flag.Parse()
+
+	g := s3gw.New()
+
+	handler := plugin.NewHandler(func(creds auth.Credentials, region string) (gateway.S3Protocol, error) {
+		return g.NewS3Protocol(creds, region, false)
+	}, g.Capabilities())
+
+	log.Printf("s3 plugin listen on %s", *listen)
+	log.Fatal(http.ListenAndServe(*listen, handler))
+}
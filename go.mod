@@ -29,7 +29,7 @@ require (
 	github.com/onsi/gomega v1.5.0 // indirect
 	github.com/pengsrc/go-shared v0.2.0 // indirect
 	github.com/prashantv/gostub v1.0.0
-	github.com/rs/zerolog v1.14.3 // indirect
+	github.com/rs/zerolog v1.14.3
 	github.com/satori/go.uuid v1.2.0 // indirect
 	github.com/sergi/go-diff v1.0.0 // indirect
 	github.com/smartystreets/goconvey v0.0.0-20190330032615-68dc04aab96a
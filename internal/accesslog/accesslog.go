@@ -0,0 +1,150 @@
+// Package accesslog 以 S3 服务器访问日志的格式记录请求，
+// 可以直接使用分析 S3 访问日志的工具
+//
+// https://docs.aws.amazon.com/AmazonS3/latest/dev/LogFormat.html
+package accesslog
+
+import (
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// timeFormat 日志中请求时间的格式，比如 [06/Feb/2019:00:00:38 +0000]
+const timeFormat = "02/Jan/2006:15:04:05 -0700"
+
+// Entry 一条访问日志，字符串为空、Status 和 BytesSent 为 0 时输出 "-"
+type Entry struct {
+	// BucketOwner bucket 所属的应用
+	BucketOwner string
+	Bucket      string
+	Time        time.Time
+	RemoteIP    string
+	// Requester 签名请求的 AccessKey，匿名请求为空
+	Requester string
+	RequestID string
+	// Operation 比如 REST.GET.OBJECT，使用 Operation 函数生成
+	Operation string
+	Key       string
+	// RequestURI 比如 GET /bucket/key HTTP/1.1
+	RequestURI string
+	Status     int
+	ErrorCode  string
+	BytesSent  int64
+	// ObjectSize 对象的大小，没有时为 -1
+	ObjectSize int64
+	// TotalTime 处理请求的时间
+	TotalTime time.Duration
+	// TurnAroundTime 收到请求到开始发送响应的时间，未知时为负数
+	TurnAroundTime time.Duration
+	Referrer       string
+	UserAgent      string
+	VersionID      string
+}
+
+// Logger 把访问日志写入 w，并发安全
+type Logger struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// New 创建写入 w 的 Logger
+func New(w io.Writer) *Logger {
+	return &Logger{w: w}
+}
+
+// Log 写入一条日志，l 为 nil 时不做任何事情
+func (l *Logger) Log(e Entry) error {
+
+	if l == nil {
+		return nil
+	}
+
+	line := e.Format()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	_, err := io.WriteString(l.w, line)
+	return err
+}
+
+// Format 按 S3 访问日志格式输出一行，以换行结尾
+func (e Entry) Format() string {
+
+	fields := []string{
+		field(e.BucketOwner),
+		field(e.Bucket),
+		"[" + e.Time.Format(timeFormat) + "]",
+		field(e.RemoteIP),
+		field(e.Requester),
+		field(e.RequestID),
+		field(e.Operation),
+		field(e.Key),
+		quoted(e.RequestURI),
+		number(int64(e.Status)),
+		field(e.ErrorCode),
+		number(e.BytesSent),
+		size(e.ObjectSize),
+		milliseconds(e.TotalTime),
+		milliseconds(e.TurnAroundTime),
+		quoted(e.Referrer),
+		quoted(e.UserAgent),
+		field(e.VersionID),
+	}
+	return strings.Join(fields, " ") + "\n"
+}
+
+// Operation 生成 S3 日志中的操作名称，比如 REST.GET.OBJECT、REST.PUT.BUCKET、REST.GET.SERVICE，
+// copy 为 true 时为 REST.COPY.OBJECT
+func Operation(method, bucket, key string, copy bool) string {
+
+	resource := "SERVICE"
+	switch {
+	case key != "":
+		resource = "OBJECT"
+	case bucket != "":
+		resource = "BUCKET"
+	}
+	if copy && key != "" {
+		method = "COPY"
+	}
+	return "REST." + strings.ToUpper(method) + "." + resource
+}
+
+func field(s string) string {
+	if s == "" {
+		return "-"
+	}
+	// 字段之间使用空格分隔，key 中的空格需要转义
+	return strings.Replace(s, " ", "%20", -1)
+}
+
+func quoted(s string) string {
+	if s == "" {
+		return `"-"`
+	}
+	return strconv.Quote(s)
+}
+
+func number(n int64) string {
+	if n <= 0 {
+		return "-"
+	}
+	return strconv.FormatInt(n, 10)
+}
+
+func size(n int64) string {
+	if n < 0 {
+		return "-"
+	}
+	return strconv.FormatInt(n, 10)
+}
+
+func milliseconds(d time.Duration) string {
+	if d < 0 {
+		return "-"
+	}
+	return strconv.FormatInt(int64(d/time.Millisecond), 10)
+}
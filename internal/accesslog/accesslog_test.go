@@ -0,0 +1,100 @@
+package accesslog
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/smartystreets/goconvey/convey"
+)
+
+func TestFormat(t *testing.T) {
+
+	convey.Convey("format", t, func() {
+
+		e := Entry{
+			BucketOwner:    "AKIDEXAMPLE",
+			Bucket:         "bucket",
+			Time:           time.Date(2019, 2, 6, 0, 0, 38, 0, time.UTC),
+			RemoteIP:       "192.0.2.3",
+			Requester:      "AKIDEXAMPLE",
+			RequestID:      "3E57427F3EXAMPLE",
+			Operation:      Operation("GET", "bucket", "a b.jpg", false),
+			Key:            "a b.jpg",
+			RequestURI:     "GET /bucket/a%20b.jpg HTTP/1.1",
+			Status:         200,
+			BytesSent:      113,
+			ObjectSize:     113,
+			TotalTime:      7 * time.Millisecond,
+			TurnAroundTime: -1,
+			UserAgent:      `aws-sdk-go/1.19.0 "test"`,
+		}
+
+		var buf bytes.Buffer
+		New(&buf).Log(e)
+
+		convey.So(buf.String(), convey.ShouldEqual,
+			`AKIDEXAMPLE bucket [06/Feb/2019:00:00:38 +0000] 192.0.2.3 AKIDEXAMPLE 3E57427F3EXAMPLE REST.GET.OBJECT a%20b.jpg "GET /bucket/a%20b.jpg HTTP/1.1" 200 - 113 113 7 - "-" "aws-sdk-go/1.19.0 \"test\"" -`+"\n")
+
+		// 匿名、出错的请求
+		e = Entry{Bucket: "bucket", Time: e.Time, Operation: Operation("PUT", "bucket", "", false), Status: 403, ErrorCode: "AccessDenied", ObjectSize: -1}
+		convey.So(e.Format(), convey.ShouldEqual,
+			`- bucket [06/Feb/2019:00:00:38 +0000] - - - REST.PUT.BUCKET - "-" 403 AccessDenied - - 0 0 "-" "-" -`+"\n")
+
+		var nl *Logger
+		convey.So(nl.Log(e), convey.ShouldBeNil)
+	})
+
+	convey.Convey("operation", t, func() {
+		convey.So(Operation("GET", "", "", false), convey.ShouldEqual, "REST.GET.SERVICE")
+		convey.So(Operation("head", "bucket", "", false), convey.ShouldEqual, "REST.HEAD.BUCKET")
+		convey.So(Operation("PUT", "bucket", "key", true), convey.ShouldEqual, "REST.COPY.OBJECT")
+		convey.So(Operation("DELETE", "bucket", "key", false), convey.ShouldEqual, "REST.DELETE.OBJECT")
+	})
+}
+
+func TestRotatingFile(t *testing.T) {
+
+	convey.Convey("rotate", t, func() {
+
+		dir, err := ioutil.TempDir("", "accesslog")
+		convey.So(err, convey.ShouldBeNil)
+		defer os.RemoveAll(dir)
+
+		path := filepath.Join(dir, "access.log")
+		now := time.Date(2019, 2, 6, 0, 0, 0, 0, time.UTC)
+
+		f, err := NewRotatingFile(path, 10, 2)
+		convey.So(err, convey.ShouldBeNil)
+		f.now = func() time.Time {
+			now = now.Add(time.Second)
+			return now
+		}
+
+		for _, s := range []string{"0123456\n", "abc\n", "def\n", "ghi\n", "0123456789ABCDEF\n"} {
+			_, err := f.Write([]byte(s))
+			convey.So(err, convey.ShouldBeNil)
+		}
+		convey.So(f.Close(), convey.ShouldBeNil)
+
+		body, _ := ioutil.ReadFile(path)
+		convey.So(string(body), convey.ShouldEqual, "0123456789ABCDEF\n")
+
+		// 最早的 0123456 已经被删除
+		backups, _ := filepath.Glob(path + ".*")
+		convey.So(len(backups), convey.ShouldEqual, 2)
+		body, _ = ioutil.ReadFile(backups[0])
+		convey.So(string(body), convey.ShouldEqual, "abc\ndef\n")
+		body, _ = ioutil.ReadFile(backups[1])
+		convey.So(string(body), convey.ShouldEqual, "ghi\n")
+
+		// 重新打开时从已有的大小开始计算
+		f, err = NewRotatingFile(path, 100, 0)
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(f.size, convey.ShouldEqual, 17)
+		f.Close()
+	})
+}
@@ -0,0 +1,117 @@
+package accesslog
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// backupTimeFormat 轮转后的文件名后缀，按字典序排序即为时间顺序
+const backupTimeFormat = "20060102T150405.000"
+
+// RotatingFile 写入文件，超过 maxSize 后重命名为 path.<时间> 并创建新文件，
+// 最多保留 maxBackups 个旧文件
+type RotatingFile struct {
+	path       string
+	maxSize    int64
+	maxBackups int
+
+	mu   sync.Mutex
+	f    *os.File
+	size int64
+
+	// now 测试时替换
+	now func() time.Time
+}
+
+// NewRotatingFile 打开 path，maxSize 为 0 时不轮转，maxBackups 为 0 时保留所有旧文件
+func NewRotatingFile(path string, maxSize int64, maxBackups int) (*RotatingFile, error) {
+
+	r := &RotatingFile{
+		path:       path,
+		maxSize:    maxSize,
+		maxBackups: maxBackups,
+		now:        time.Now,
+	}
+	if err := r.open(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *RotatingFile) open() error {
+
+	f, err := os.OpenFile(r.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	r.f, r.size = f, info.Size()
+	return nil
+}
+
+// Write 写入 p，写入后超过 maxSize 时先轮转，一次写入的内容不会被拆分到两个文件
+func (r *RotatingFile) Write(p []byte) (int, error) {
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.maxSize > 0 && r.size > 0 && r.size+int64(len(p)) > r.maxSize {
+		if err := r.rotate(); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := r.f.Write(p)
+	r.size += int64(n)
+	return n, err
+}
+
+func (r *RotatingFile) rotate() error {
+
+	if err := r.f.Close(); err != nil {
+		return err
+	}
+
+	backup := r.path + "." + r.now().UTC().Format(backupTimeFormat)
+	if err := os.Rename(r.path, backup); err != nil {
+		return err
+	}
+	if err := r.open(); err != nil {
+		return err
+	}
+
+	r.removeBackups()
+	return nil
+}
+
+// removeBackups 删除多余的旧文件，失败时忽略
+func (r *RotatingFile) removeBackups() {
+
+	if r.maxBackups <= 0 {
+		return
+	}
+
+	backups, err := filepath.Glob(r.path + ".*")
+	if err != nil || len(backups) <= r.maxBackups {
+		return
+	}
+
+	sort.Strings(backups)
+	for _, name := range backups[:len(backups)-r.maxBackups] {
+		os.Remove(name)
+	}
+}
+
+// Close 关闭文件
+func (r *RotatingFile) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.f.Close()
+}
@@ -0,0 +1,141 @@
+// Package cache 进程内的 LRU 缓存，每个条目有过期时间
+package cache
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// LRU 并发安全的 LRU 缓存
+//
+// 除了正常的值，还可以缓存"不存在"(negative)，避免不存在的 key 每次都查询数据库，
+// 不存在的条目使用单独的、通常更短的过期时间
+type LRU struct {
+	size        int
+	ttl         time.Duration
+	negativeTTL time.Duration
+
+	mu    sync.Mutex
+	ll    *list.List
+	items map[string]*list.Element
+
+	// now 测试时替换
+	now func() time.Time
+}
+
+type entry struct {
+	key      string
+	value    interface{}
+	negative bool
+	expires  time.Time
+}
+
+// New 创建最多保存 size 个条目的缓存，ttl、negativeTTL 分别为存在和不存在的条目的过期时间
+func New(size int, ttl, negativeTTL time.Duration) *LRU {
+	return &LRU{
+		size:        size,
+		ttl:         ttl,
+		negativeTTL: negativeTTL,
+		ll:          list.New(),
+		items:       make(map[string]*list.Element),
+		now:         time.Now,
+	}
+}
+
+// Get 查找 key，hit 为 false 时没有缓存，需要查询数据源；
+// hit 为 true 且 negative 为 true 时，缓存的是"不存在"
+func (c *LRU) Get(key string) (value interface{}, negative, hit bool) {
+
+	if c == nil {
+		return nil, false, false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.items[key]
+	if !ok {
+		return nil, false, false
+	}
+
+	e := el.Value.(*entry)
+	if !c.now().Before(e.expires) {
+		c.remove(el)
+		return nil, false, false
+	}
+
+	c.ll.MoveToFront(el)
+	return e.value, e.negative, true
+}
+
+// Add 缓存 key 的值
+func (c *LRU) Add(key string, value interface{}) {
+	if c != nil {
+		c.add(key, value, false, c.ttl)
+	}
+}
+
+// AddNegative 缓存 key 不存在
+func (c *LRU) AddNegative(key string) {
+	if c != nil {
+		c.add(key, nil, true, c.negativeTTL)
+	}
+}
+
+func (c *LRU) add(key string, value interface{}, negative bool, ttl time.Duration) {
+
+	if c.size <= 0 || ttl <= 0 {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	expires := c.now().Add(ttl)
+
+	if el, ok := c.items[key]; ok {
+		e := el.Value.(*entry)
+		e.value, e.negative, e.expires = value, negative, expires
+		c.ll.MoveToFront(el)
+		return
+	}
+
+	c.items[key] = c.ll.PushFront(&entry{key: key, value: value, negative: negative, expires: expires})
+
+	for c.ll.Len() > c.size {
+		c.remove(c.ll.Back())
+	}
+}
+
+// Remove 删除 key，数据源中的记录变化时调用
+func (c *LRU) Remove(key string) {
+
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.items[key]; ok {
+		c.remove(el)
+	}
+}
+
+// Len 缓存的条目数量，包括已经过期但还没有删除的
+func (c *LRU) Len() int {
+
+	if c == nil {
+		return 0
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.ll.Len()
+}
+
+func (c *LRU) remove(el *list.Element) {
+	c.ll.Remove(el)
+	delete(c.items, el.Value.(*entry).key)
+}
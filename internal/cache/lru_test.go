@@ -0,0 +1,109 @@
+package cache
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/smartystreets/goconvey/convey"
+)
+
+func TestLRU(t *testing.T) {
+
+	convey.Convey("LRU", t, func() {
+
+		now := time.Unix(1560000000, 0)
+		c := New(2, time.Minute, 10*time.Second)
+		c.now = func() time.Time { return now }
+
+		convey.Convey("get and evict", func() {
+			c.Add("a", 1)
+			c.Add("b", 2)
+
+			v, negative, hit := c.Get("a")
+			convey.So(hit, convey.ShouldBeTrue)
+			convey.So(negative, convey.ShouldBeFalse)
+			convey.So(v, convey.ShouldEqual, 1)
+
+			// a 刚被访问过，淘汰 b
+			c.Add("c", 3)
+			convey.So(c.Len(), convey.ShouldEqual, 2)
+			_, _, hit = c.Get("b")
+			convey.So(hit, convey.ShouldBeFalse)
+			_, _, hit = c.Get("a")
+			convey.So(hit, convey.ShouldBeTrue)
+		})
+
+		convey.Convey("ttl", func() {
+			c.Add("a", 1)
+			c.AddNegative("b")
+
+			_, negative, hit := c.Get("b")
+			convey.So(hit, convey.ShouldBeTrue)
+			convey.So(negative, convey.ShouldBeTrue)
+
+			now = now.Add(10 * time.Second)
+			_, _, hit = c.Get("b")
+			convey.So(hit, convey.ShouldBeFalse)
+			_, _, hit = c.Get("a")
+			convey.So(hit, convey.ShouldBeTrue)
+
+			now = now.Add(time.Minute)
+			_, _, hit = c.Get("a")
+			convey.So(hit, convey.ShouldBeFalse)
+			convey.So(c.Len(), convey.ShouldEqual, 0)
+		})
+
+		convey.Convey("overwrite and remove", func() {
+			c.AddNegative("a")
+			c.Add("a", 1)
+			v, negative, hit := c.Get("a")
+			convey.So(hit, convey.ShouldBeTrue)
+			convey.So(negative, convey.ShouldBeFalse)
+			convey.So(v, convey.ShouldEqual, 1)
+
+			c.Remove("a")
+			_, _, hit = c.Get("a")
+			convey.So(hit, convey.ShouldBeFalse)
+		})
+
+		convey.Convey("disabled", func() {
+			var nc *LRU
+			nc.Add("a", 1)
+			nc.Remove("a")
+			_, _, hit := nc.Get("a")
+			convey.So(hit, convey.ShouldBeFalse)
+
+			zero := New(0, time.Minute, time.Minute)
+			zero.Add("a", 1)
+			convey.So(zero.Len(), convey.ShouldEqual, 0)
+		})
+	})
+}
+
+func TestLRUConcurrent(t *testing.T) {
+
+	c := New(100, time.Minute, time.Minute)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				k := strconv.Itoa((i * j) % 300)
+				c.Add(k, j)
+				c.Get(k)
+				if j%7 == 0 {
+					c.Remove(k)
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if c.Len() > 100 {
+		t.Fatalf("len %d > size 100", c.Len())
+	}
+}
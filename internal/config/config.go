@@ -1,9 +1,24 @@
 package config
 
+import "time"
+
 // Config config struct
 type Config struct {
-	Server Server
-	MySQL  MySQL
+	Server     Server
+	MySQL      MySQL
+	Tiering    Tiering
+	Mirror     Mirror
+	Client     Client
+	Cache      Cache
+	Tracing    Tracing
+	AccessLog  AccessLog
+	Health     Health
+	Admin      Admin
+	Encryption Encryption
+	STS        STS
+	Network    Network
+	TLS        TLS
+	Plugins    []Plugin
 }
 
 // Server server config
@@ -12,8 +27,10 @@ type Server struct {
 	PprofPort string
 	EndPoint  string
 	Region    string
-	LogPath   string
-	IsDebug   bool
+	// Regions 除 Region 外签名可以使用的地区
+	Regions []string
+	LogPath string
+	IsDebug bool
 }
 
 // MySQL mysql config
@@ -24,3 +41,176 @@ type MySQL struct {
 	Passwd string
 	DBName string
 }
+
+// Tiering 冷热分层的后台迁移配置
+type Tiering struct {
+	// Interval 迁移间隔，为 0 时不迁移
+	Interval time.Duration
+	// Batch 每次从数据库读取的数量
+	Batch int
+}
+
+// Mirror 镜像引擎的后台修复配置
+type Mirror struct {
+	// Interval 修复间隔，为 0 时不修复
+	Interval time.Duration
+	// Batch 每次从数据库读取的数量
+	Batch int
+}
+
+// Client 后端客户端的缓存和连接配置
+type Client struct {
+	// IdleTimeout 客户端空闲多久后从缓存中移除，为 0 时不缓存，每个请求创建新的客户端
+	IdleTimeout time.Duration
+	// MaxIdleConns 所有后端的最大空闲连接数
+	MaxIdleConns int
+	// MaxIdleConnsPerHost 每个后端地址的最大空闲连接数
+	MaxIdleConnsPerHost int
+	// IdleConnTimeout 空闲连接的超时时间
+	IdleConnTimeout time.Duration
+	// DialTimeout 建立连接的超时时间
+	DialTimeout time.Duration
+	// TLSHandshakeTimeout TLS 握手的超时时间
+	TLSHandshakeTimeout time.Duration
+	// ResponseHeaderTimeout 发送请求后等待响应头的超时时间，为 0 时不超时
+	ResponseHeaderTimeout time.Duration
+}
+
+// Cache 应用信息的进程内缓存，多实例部署时其他实例删除的应用最多在 TTL 后失效
+type Cache struct {
+	// Size 最多缓存的应用数量，为 0 时不缓存
+	Size int
+	// TTL 应用信息的过期时间
+	TTL time.Duration
+	// NegativeTTL 不存在的应用的过期时间
+	NegativeTTL time.Duration
+}
+
+// Tracing 分布式跟踪，span 以 OTLP/HTTP JSON 格式发送到 collector
+type Tracing struct {
+	// Endpoint collector 的地址，比如 http://127.0.0.1:4318/v1/traces，为空时不记录 span
+	Endpoint string
+	// ServiceName 上报的 service.name
+	ServiceName string
+	// Interval 发送间隔
+	Interval time.Duration
+}
+
+// AccessLog S3 服务器访问日志格式的请求日志
+type AccessLog struct {
+	// Path 日志文件，为空时不记录
+	Path string
+	// MaxSize 单个文件的大小上限，单位 MB，超过后轮转，为 0 时不轮转
+	MaxSize int64
+	// MaxBackups 保留的旧文件数量，为 0 时全部保留
+	MaxBackups int
+}
+
+// Health 就绪检查 /readyz 的配置
+type Health struct {
+	// Timeout 每个依赖的检查超时时间
+	Timeout time.Duration
+	// ShutdownDelay 收到退出信号后，先报告未就绪，等待这段时间再停止接收请求
+	ShutdownDelay time.Duration
+	// Canaries 需要检查的后端引擎，为空时只检查 MySQL
+	Canaries []Canary
+}
+
+// Canary 就绪检查时对 Bucket 调用 HeadBucket，确认可以访问后端引擎
+type Canary struct {
+	// Name 检查结果中的名称
+	Name      string
+	Engine    string
+	Region    string
+	AccessKey string
+	SecretKey string
+	Bucket    string
+}
+
+// Admin 管理接口的凭证，创建应用需要使用这对 key 以 AWS Sign V4 签名
+type Admin struct {
+	AccessKey string
+	SecretKey string
+	// Engines 允许创建应用的后端引擎，为空时不限制
+	Engines []string
+	// Regions 允许创建应用的后端地区，为空时不限制
+	Regions []string
+	// KeyGracePeriod 轮换应用的 key 后旧 key 继续有效的时间
+	KeyGracePeriod time.Duration
+}
+
+// Encryption 加密保存在数据库中的 key，MasterKey 和 KeyFile 都为空时明文保存
+type Encryption struct {
+	// MasterKey base64 编码的 32 字节主密钥
+	MasterKey string
+	// KeyFile 保存 base64 主密钥的文件，MasterKey 为空时使用
+	KeyFile string
+}
+
+// STS 临时凭证的配置
+type STS struct {
+	// MaxDuration 临时凭证的最长有效时间
+	MaxDuration time.Duration
+	// WebIdentity AssumeRoleWithWebIdentity 信任的 OIDC 签发者
+	WebIdentity []WebIdentity
+}
+
+// WebIdentity 信任的 OIDC 签发者，JWKSFile 和 JWKSURL 二选一
+type WebIdentity struct {
+	// Issuer token 中的 iss
+	Issuer string
+	// Audience token 中的 aud 至少要有一个在列表中
+	Audience []string
+	// JWKSFile 保存签发者公钥 JWKS 的文件
+	JWKSFile string
+	// JWKSURL 获取签发者公钥 JWKS 的地址，比如 http://127.0.0.1:5556/keys
+	JWKSURL string
+	// Refresh 重新读取 JWKS 的间隔，为 0 时为 10 分钟
+	Refresh time.Duration
+	// Roles 可以扮演的角色
+	Roles []WebIdentityRole
+}
+
+// WebIdentityRole 通过 RoleArn arn:aws:iam::<任意>:role/<Name> 扮演的角色，
+// token 的 Claim 声明中有 Values 中的值时映射到应用 Application
+type WebIdentityRole struct {
+	// Name 角色名
+	Name string
+	// Claim 需要检查的声明，比如 sub、groups，为空时不检查
+	Claim string
+	// Values Claim 声明允许的值
+	Values []string
+	// Application 应用的 AccessKey
+	Application string
+	// User 使用应用下这个用户的身份策略，为空时拥有应用的全部权限
+	User string
+	// Policy 会话策略，进一步限制权限
+	Policy string
+}
+
+// Network 客户端网络
+type Network struct {
+	// TrustedProxies 可信代理的 IP 或者 CIDR，请求来自这些地址时从 X-Forwarded-For 读取客户端 IP
+	TrustedProxies []string
+}
+
+// TLS HTTPS 配置，CertFile 和 KeyFile 都不为空时使用 HTTPS
+type TLS struct {
+	// CertFile, KeyFile 服务端证书和私钥，PEM 格式
+	CertFile string
+	KeyFile  string
+	// ClientCAFile 验证客户端证书的 CA，为空时不请求客户端证书
+	ClientCAFile string
+	// RequireClientCert 为 true 时必须使用客户端证书，否则没有证书的客户端可以继续使用签名
+	RequireClientCert bool
+}
+
+// Plugin 进程外的后端引擎
+type Plugin struct {
+	// Name 引擎名称，创建应用时作为 Engine 使用
+	Name string
+	// Address 插件的 HTTP 地址，比如 http://127.0.0.1:9300
+	Address string
+	// Timeout 每个请求的超时时间，包括读取对象内容，为 0 时不超时
+	Timeout time.Duration
+}
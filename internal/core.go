@@ -5,8 +5,10 @@ import (
 
 	"github.com/solution9th/S3Adapter/internal/auth"
 	"github.com/solution9th/S3Adapter/internal/gateway"
+	"github.com/solution9th/S3Adapter/internal/gateway/azure"
 	"github.com/solution9th/S3Adapter/internal/gateway/cos"
 	"github.com/solution9th/S3Adapter/internal/gateway/s3"
+	"github.com/solution9th/S3Adapter/internal/gateway/webhdfs"
 
 	"github.com/haozibi/zlog"
 )
@@ -14,6 +16,8 @@ import (
 var (
 	// ErrGatewayNotFound gateway not found
 	ErrGatewayNotFound = errors.New("gateway not found")
+	// ErrGatewayExists gateway already registered
+	ErrGatewayExists = errors.New("gateway already exists")
 )
 
 var (
@@ -25,6 +29,8 @@ func init() {
 	GatewayMap = make(map[string]func() gateway.Gateway)
 	GatewayMap[s3.Backend] = s3.New
 	GatewayMap[cos.Backend] = cos.New
+	GatewayMap[azure.Backend] = azure.New
+	GatewayMap[webhdfs.Backend] = webhdfs.New
 }
 
 // NewGateway new gateway by accessKey, secretKey and region
@@ -43,5 +49,21 @@ func NewGateway(gatewayName string, creds auth.Credentials, region string) (pro
 
 	zlog.ZInfo().Str("gateway", g.Name()).Str("region", region).Msg("[core]")
 
-	return g.NewS3Protocol(creds, region, true)
+	p, err := g.NewS3Protocol(creds, region, true)
+	if err != nil {
+		return nil, err
+	}
+
+	// 不支持的方法和特性在调用后端之前返回 501，不计入后端的调用时间
+	return gateway.WithCapabilities(gateway.Instrument(p, g.Name()), g.Capabilities()), nil
+}
+
+// RegisterGateway 注册引擎，比如配置文件中的插件，不能覆盖已经存在的引擎
+func RegisterGateway(name string, f func() gateway.Gateway) error {
+
+	if _, ok := GatewayMap[name]; ok {
+		return ErrGatewayExists
+	}
+	GatewayMap[name] = f
+	return nil
 }
@@ -32,6 +32,7 @@ func TestNewGateway(t *testing.T) {
 
 					gt.EXPECT().Name().Return("succss").AnyTimes()
 					gt.EXPECT().Production().Return(true).AnyTimes()
+					gt.EXPECT().Capabilities().Return(gateway.FullCapabilities()).AnyTimes()
 
 					gt.EXPECT().NewS3Protocol(gomock.Any(), gomock.Any(), gomock.Any()).Return(nil, nil).AnyTimes()
 
@@ -64,3 +65,19 @@ func TestNewGateway(t *testing.T) {
 
 	})
 }
+
+func TestRegisterGateway(t *testing.T) {
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	convey.Convey("RegisterGateway", t, func() {
+
+		f := func() gateway.Gateway { return mock_gateway.NewMockGateway(ctrl) }
+
+		convey.So(RegisterGateway("s3", f), convey.ShouldEqual, ErrGatewayExists)
+		convey.So(RegisterGateway("testRegister", f), convey.ShouldBeNil)
+		convey.So(GatewayMap, convey.ShouldContainKey, "testRegister")
+		convey.So(RegisterGateway("testRegister", f), convey.ShouldEqual, ErrGatewayExists)
+	})
+}
@@ -1,11 +1,311 @@
 package db
 
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+var (
+	// ErrNotFound record not found
+	ErrNotFound = errors.New("record not found")
+)
+
 // DB DB function
 type DB interface {
 	LinkDB(config map[string]interface{}) error
 	AddTable() (err error)
+	// Ping 检查数据库是否可以访问，用于就绪检查
+	Ping(ctx context.Context) error
 	CountInfo(ak, sk, engine string) (int, error)
 	GetInfo(ak string) (m interface{}, err error)
 	SaveInfo(data map[string]interface{}) (id int, err error)
 	DeleteInfo(oak, osk string) error
+	UpdateInfo(oak string, data map[string]interface{}) error
+
+	GetKey(ak string) (Key, error)
+	ListKey(oak string) ([]Key, error)
+	SaveKey(data map[string]interface{}) (id int, err error)
+	ExpireKey(oak string, expire time.Time) error
+
+	GetUser(ak string) (User, error)
+	ListUser(oak string) ([]User, error)
+	SaveUser(data map[string]interface{}) (id int, err error)
+	DeleteUser(oak, name string) error
+
+	GetSession(ak string) (Session, error)
+	ListSession(oak string) ([]Session, error)
+	SaveSession(data map[string]interface{}) (id int, err error)
+	DeleteExpiredSession(before time.Time) error
+
+	ListRegion(oak string) ([]Region, error)
+	SaveRegion(data map[string]interface{}) (id int, err error)
+
+	GetNetwork(oak string) (Network, error)
+	SaveNetwork(n Network) error
+
+	GetClientCert(identities []string) ([]ClientCert, error)
+	ListClientCert(oak string) ([]ClientCert, error)
+	SaveClientCert(data map[string]interface{}) (id int, err error)
+	DeleteClientCert(oak, identity string) error
+
+	GetTier(oak string) (Tier, error)
+	SaveTier(data map[string]interface{}) (id int, err error)
+
+	GetPlacement(oak, bucket, object string) (Placement, error)
+	SavePlacement(p Placement) error
+	DeletePlacement(oak, bucket, object string) error
+	DemotePlacement(p Placement) (bool, error)
+	ListPlacement(tier string, afterID int64, limit int) ([]Placement, error)
+
+	GetMirror(oak string) (Mirror, error)
+	SaveMirror(data map[string]interface{}) (id int, err error)
+
+	GetErasure(oak string) (Erasure, error)
+	SaveErasure(data map[string]interface{}) (id int, err error)
+
+	AddRepair(r Repair) error
+	ListRepair(before time.Time, limit int) ([]Repair, error)
+	RetryRepair(id int64, attempts int, next time.Time, lastError string) error
+	DeleteRepair(id int64) error
+}
+
+// Key 应用轮换后的本地 key，一个应用可以同时有多个有效的 key
+//
+// 第一次轮换时创建应用时的 key 也会保存到这里，AccessKey 与 OsAccessKey 相同
+type Key struct {
+	ID int64 `json:"id"`
+
+	// OsAccessKey 应用创建时的本地key，用于关联应用的配置
+	OsAccessKey string `json:"os_access_key"`
+
+	// AccessKey 本地key
+	AccessKey string `json:"access_key"`
+
+	// SecretKey 本地key
+	SecretKey string `json:"secret_key"`
+
+	// ExpireTime 过期时间，为 nil 时不过期
+	ExpireTime *time.Time `json:"expire_time"`
+
+	// CreateTime 添加时间
+	CreateTime time.Time `json:"create_time"`
+}
+
+// Expired key 在 now 时是否已经过期
+func (k Key) Expired(now time.Time) bool {
+	return k.ExpireTime != nil && !now.Before(*k.ExpireTime)
+}
+
+// User 应用下的用户，使用自己的 key 访问应用的后端，权限由 Policy 限制
+type User struct {
+	ID int64 `json:"id"`
+
+	// OsAccessKey 所属应用创建时的本地key
+	OsAccessKey string `json:"os_access_key"`
+
+	// UserName 用户名，应用内唯一
+	UserName string `json:"user_name"`
+
+	// AccessKey 用户的本地key
+	AccessKey string `json:"access_key"`
+
+	// SecretKey 用户的本地key
+	SecretKey string `json:"secret_key"`
+
+	// Policy JSON 格式的身份策略
+	Policy string `json:"policy"`
+
+	// CreateTime 添加时间
+	CreateTime time.Time `json:"create_time"`
+}
+
+// Session STS 签发的临时凭证，过期后不能使用
+type Session struct {
+	ID int64 `json:"id"`
+
+	// OsAccessKey 所属应用创建时的本地key
+	OsAccessKey string `json:"os_access_key"`
+
+	// AccessKey, SecretKey 临时的本地key
+	AccessKey string `json:"access_key"`
+	SecretKey string `json:"secret_key"`
+
+	// SessionToken 临时凭证 token 的 SHA256，请求时需要在 x-amz-security-token 中带上原文
+	SessionToken string `json:"session_token"`
+
+	// UserName 签发给应用下的用户时为用户名，为空时为应用本身
+	UserName string `json:"user_name"`
+
+	// Policy JSON 格式的会话策略，为空时不额外限制
+	Policy string `json:"policy"`
+
+	// ExpireTime 过期时间
+	ExpireTime time.Time `json:"expire_time"`
+
+	// CreateTime 添加时间
+	CreateTime time.Time `json:"create_time"`
+}
+
+// Region 应用的地区映射，以 Region 签名的请求使用 EngineRegion 访问后端引擎
+type Region struct {
+	ID int64 `json:"id"`
+
+	// OsAccessKey 应用创建时的本地key
+	OsAccessKey string `json:"os_access_key"`
+
+	// Region 请求签名使用的地区，需要在 server.regions 中
+	Region string `json:"region"`
+
+	// EngineRegion 后端引擎的地区
+	EngineRegion string `json:"engine_region"`
+
+	// CreateTime 添加时间
+	CreateTime time.Time `json:"create_time"`
+}
+
+// Network 应用的网络限制，客户端 IP 在 Deny 中或者 Allow 不为空且不在其中时拒绝请求
+type Network struct {
+	ID int64 `json:"id"`
+
+	// OsAccessKey 应用创建时的本地key
+	OsAccessKey string `json:"os_access_key"`
+
+	// Allow, Deny 逗号分隔的 CIDR
+	Allow string `json:"allow"`
+	Deny  string `json:"deny"`
+
+	// UpdateTime 修改时间
+	UpdateTime time.Time `json:"update_time"`
+}
+
+// ClientCert 客户端证书到应用的映射，使用验证通过的客户端证书的请求不需要签名
+type ClientCert struct {
+	ID int64 `json:"id"`
+
+	// OsAccessKey 应用创建时的本地key
+	OsAccessKey string `json:"os_access_key"`
+
+	// Identity 证书的 SAN 或者 subject，比如 uri:spiffe://example.org/svc-a、dns:svc-a.internal、subject:CN=svc-a,O=Example
+	Identity string `json:"identity"`
+
+	// CreateTime 添加时间
+	CreateTime time.Time `json:"create_time"`
+}
+
+// Tier 应用的冷存储配置，热存储为应用本身的引擎
+type Tier struct {
+	ID int64 `json:"id"`
+
+	// OsAccessKey 本地key
+	OsAccessKey string `json:"os_access_key"`
+
+	// ColdEngine 冷存储引擎
+	ColdEngine string `json:"cold_engine"`
+
+	// ColdRegion 冷存储引擎的 region
+	ColdRegion string `json:"cold_region"`
+
+	// ColdAccessKey 冷存储引擎的 key
+	ColdAccessKey string `json:"cold_access_key"`
+
+	// ColdSecretKey 冷存储引擎的 key
+	ColdSecretKey string `json:"cold_secret_key"`
+
+	// ColdStorageClass 写入冷存储时使用的存储类型，比如 ARCHIVE
+	ColdStorageClass string `json:"cold_storage_class"`
+
+	// Rules JSON 格式的迁移规则
+	Rules string `json:"rules"`
+
+	// CreateTime 添加时间
+	CreateTime time.Time `json:"create_time"`
+}
+
+// Placement 对象所在的存储层
+type Placement struct {
+	ID int64 `json:"id"`
+
+	// OsAccessKey 本地key
+	OsAccessKey string `json:"os_access_key"`
+
+	Bucket string `json:"bucket"`
+
+	Object string `json:"object"`
+
+	// Tier hot 或者 cold
+	Tier string `json:"tier"`
+
+	// Size 对象大小
+	Size int64 `json:"size"`
+
+	// ModifyTime 对象写入时间，用于按时间迁移
+	ModifyTime time.Time `json:"modify_time"`
+}
+
+// Mirror 应用的镜像引擎配置，主引擎为应用本身的引擎
+type Mirror struct {
+	ID int64 `json:"id"`
+
+	// OsAccessKey 本地key
+	OsAccessKey string `json:"os_access_key"`
+
+	// Engine 镜像引擎
+	Engine string `json:"engine"`
+
+	// Region 镜像引擎的 region
+	Region string `json:"region"`
+
+	// AccessKey 镜像引擎的 key
+	AccessKey string `json:"access_key"`
+
+	// SecretKey 镜像引擎的 key
+	SecretKey string `json:"secret_key"`
+
+	// CreateTime 添加时间
+	CreateTime time.Time `json:"create_time"`
+}
+
+// Repair 镜像引擎写入失败后等待修复的记录，Object 为空时修复 bucket
+type Repair struct {
+	ID int64 `json:"id"`
+
+	// OsAccessKey 本地key
+	OsAccessKey string `json:"os_access_key"`
+
+	Bucket string `json:"bucket"`
+
+	Object string `json:"object"`
+
+	// Attempts 已经重试的次数
+	Attempts int `json:"attempts"`
+
+	// NextRetryTime 下次重试时间
+	NextRetryTime time.Time `json:"next_retry_time"`
+
+	// LastError 最后一次失败的原因
+	LastError string `json:"last_error"`
+
+	// CreateTime 添加时间
+	CreateTime time.Time `json:"create_time"`
+}
+
+// Erasure 应用的纠删码配置
+type Erasure struct {
+	ID int64 `json:"id"`
+
+	// OsAccessKey 本地key
+	OsAccessKey string `json:"os_access_key"`
+
+	// DataShards 数据块数量
+	DataShards int `json:"data_shards"`
+
+	// ParityShards 校验块数量
+	ParityShards int `json:"parity_shards"`
+
+	// Backends 保存分片的引擎，JSON 格式，第一个分片保存在应用本身的引擎
+	Backends string `json:"backends"`
+
+	// CreateTime 添加时间
+	CreateTime time.Time `json:"create_time"`
 }
@@ -0,0 +1,344 @@
+package db
+
+import (
+	"context"
+	"time"
+
+	"github.com/solution9th/S3Adapter/internal/metrics"
+	"github.com/solution9th/S3Adapter/internal/trace"
+)
+
+// Instrument 记录每次查询的时间，LinkDB、AddTable 只在启动时调用，不记录
+func Instrument(d DB) DB {
+	return &instrumented{DB: d}
+}
+
+// WithContext 返回在 ctx 的 trace 下记录查询 span 的 DB，d 不是 Instrument 返回的时候原样返回
+//
+// DB 的方法没有 context 参数，需要记录 span 的调用方使用这个函数
+func WithContext(ctx context.Context, d DB) DB {
+	if m, ok := d.(*instrumented); ok {
+		return &instrumented{DB: m.DB, ctx: ctx}
+	}
+	return d
+}
+
+type instrumented struct {
+	DB
+
+	// ctx 中没有 span 时不记录，避免没有父节点的 span
+	ctx context.Context
+}
+
+func (d *instrumented) start(method string) (*trace.Span, time.Time) {
+	if d.ctx == nil || !trace.SpanContextFrom(d.ctx).IsValid() {
+		return nil, time.Now()
+	}
+	_, span := trace.Start(d.ctx, "mysql."+method, trace.KindClient)
+	span.SetAttribute("db.system", "mysql")
+	return span, time.Now()
+}
+
+func (d *instrumented) finish(span *trace.Span, method string, start time.Time, err error) {
+
+	result := "ok"
+	switch {
+	case err == ErrNotFound:
+		result = "not_found"
+	case err != nil:
+		result = "error"
+	}
+	metrics.DBDuration.Observe(metrics.Since(start), method, result)
+
+	if err != ErrNotFound {
+		span.SetError(err)
+	}
+	span.End()
+}
+
+func (d *instrumented) Ping(ctx context.Context) error {
+	span, start := d.start("Ping")
+	err := d.DB.Ping(ctx)
+	d.finish(span, "Ping", start, err)
+	return err
+}
+
+func (d *instrumented) CountInfo(ak, sk, engine string) (int, error) {
+	span, start := d.start("CountInfo")
+	v, err := d.DB.CountInfo(ak, sk, engine)
+	d.finish(span, "CountInfo", start, err)
+	return v, err
+}
+
+func (d *instrumented) GetInfo(ak string) (interface{}, error) {
+	span, start := d.start("GetInfo")
+	v, err := d.DB.GetInfo(ak)
+	d.finish(span, "GetInfo", start, err)
+	return v, err
+}
+
+func (d *instrumented) SaveInfo(data map[string]interface{}) (int, error) {
+	span, start := d.start("SaveInfo")
+	v, err := d.DB.SaveInfo(data)
+	d.finish(span, "SaveInfo", start, err)
+	return v, err
+}
+
+func (d *instrumented) DeleteInfo(oak, osk string) error {
+	span, start := d.start("DeleteInfo")
+	err := d.DB.DeleteInfo(oak, osk)
+	d.finish(span, "DeleteInfo", start, err)
+	return err
+}
+
+func (d *instrumented) GetTier(oak string) (Tier, error) {
+	span, start := d.start("GetTier")
+	v, err := d.DB.GetTier(oak)
+	d.finish(span, "GetTier", start, err)
+	return v, err
+}
+
+func (d *instrumented) SaveTier(data map[string]interface{}) (int, error) {
+	span, start := d.start("SaveTier")
+	v, err := d.DB.SaveTier(data)
+	d.finish(span, "SaveTier", start, err)
+	return v, err
+}
+
+func (d *instrumented) GetPlacement(oak, bucket, object string) (Placement, error) {
+	span, start := d.start("GetPlacement")
+	v, err := d.DB.GetPlacement(oak, bucket, object)
+	d.finish(span, "GetPlacement", start, err)
+	return v, err
+}
+
+func (d *instrumented) SavePlacement(p Placement) error {
+	span, start := d.start("SavePlacement")
+	err := d.DB.SavePlacement(p)
+	d.finish(span, "SavePlacement", start, err)
+	return err
+}
+
+func (d *instrumented) DeletePlacement(oak, bucket, object string) error {
+	span, start := d.start("DeletePlacement")
+	err := d.DB.DeletePlacement(oak, bucket, object)
+	d.finish(span, "DeletePlacement", start, err)
+	return err
+}
+
+func (d *instrumented) DemotePlacement(p Placement) (bool, error) {
+	span, start := d.start("DemotePlacement")
+	v, err := d.DB.DemotePlacement(p)
+	d.finish(span, "DemotePlacement", start, err)
+	return v, err
+}
+
+func (d *instrumented) ListPlacement(tier string, afterID int64, limit int) ([]Placement, error) {
+	span, start := d.start("ListPlacement")
+	v, err := d.DB.ListPlacement(tier, afterID, limit)
+	d.finish(span, "ListPlacement", start, err)
+	return v, err
+}
+
+func (d *instrumented) UpdateInfo(oak string, data map[string]interface{}) error {
+	span, start := d.start("UpdateInfo")
+	err := d.DB.UpdateInfo(oak, data)
+	d.finish(span, "UpdateInfo", start, err)
+	return err
+}
+
+func (d *instrumented) GetUser(ak string) (User, error) {
+	span, start := d.start("GetUser")
+	v, err := d.DB.GetUser(ak)
+	d.finish(span, "GetUser", start, err)
+	return v, err
+}
+
+func (d *instrumented) ListUser(oak string) ([]User, error) {
+	span, start := d.start("ListUser")
+	v, err := d.DB.ListUser(oak)
+	d.finish(span, "ListUser", start, err)
+	return v, err
+}
+
+func (d *instrumented) SaveUser(data map[string]interface{}) (int, error) {
+	span, start := d.start("SaveUser")
+	v, err := d.DB.SaveUser(data)
+	d.finish(span, "SaveUser", start, err)
+	return v, err
+}
+
+func (d *instrumented) DeleteUser(oak, name string) error {
+	span, start := d.start("DeleteUser")
+	err := d.DB.DeleteUser(oak, name)
+	d.finish(span, "DeleteUser", start, err)
+	return err
+}
+
+func (d *instrumented) GetSession(ak string) (Session, error) {
+	span, start := d.start("GetSession")
+	v, err := d.DB.GetSession(ak)
+	d.finish(span, "GetSession", start, err)
+	return v, err
+}
+
+func (d *instrumented) ListSession(oak string) ([]Session, error) {
+	span, start := d.start("ListSession")
+	v, err := d.DB.ListSession(oak)
+	d.finish(span, "ListSession", start, err)
+	return v, err
+}
+
+func (d *instrumented) SaveSession(data map[string]interface{}) (int, error) {
+	span, start := d.start("SaveSession")
+	v, err := d.DB.SaveSession(data)
+	d.finish(span, "SaveSession", start, err)
+	return v, err
+}
+
+func (d *instrumented) DeleteExpiredSession(before time.Time) error {
+	span, start := d.start("DeleteExpiredSession")
+	err := d.DB.DeleteExpiredSession(before)
+	d.finish(span, "DeleteExpiredSession", start, err)
+	return err
+}
+
+func (d *instrumented) ListRegion(oak string) ([]Region, error) {
+	span, start := d.start("ListRegion")
+	v, err := d.DB.ListRegion(oak)
+	d.finish(span, "ListRegion", start, err)
+	return v, err
+}
+
+func (d *instrumented) SaveRegion(data map[string]interface{}) (int, error) {
+	span, start := d.start("SaveRegion")
+	v, err := d.DB.SaveRegion(data)
+	d.finish(span, "SaveRegion", start, err)
+	return v, err
+}
+
+func (d *instrumented) GetNetwork(oak string) (Network, error) {
+	span, start := d.start("GetNetwork")
+	v, err := d.DB.GetNetwork(oak)
+	d.finish(span, "GetNetwork", start, err)
+	return v, err
+}
+
+func (d *instrumented) SaveNetwork(n Network) error {
+	span, start := d.start("SaveNetwork")
+	err := d.DB.SaveNetwork(n)
+	d.finish(span, "SaveNetwork", start, err)
+	return err
+}
+
+func (d *instrumented) GetClientCert(identities []string) ([]ClientCert, error) {
+	span, start := d.start("GetClientCert")
+	v, err := d.DB.GetClientCert(identities)
+	d.finish(span, "GetClientCert", start, err)
+	return v, err
+}
+
+func (d *instrumented) ListClientCert(oak string) ([]ClientCert, error) {
+	span, start := d.start("ListClientCert")
+	v, err := d.DB.ListClientCert(oak)
+	d.finish(span, "ListClientCert", start, err)
+	return v, err
+}
+
+func (d *instrumented) SaveClientCert(data map[string]interface{}) (int, error) {
+	span, start := d.start("SaveClientCert")
+	v, err := d.DB.SaveClientCert(data)
+	d.finish(span, "SaveClientCert", start, err)
+	return v, err
+}
+
+func (d *instrumented) DeleteClientCert(oak, identity string) error {
+	span, start := d.start("DeleteClientCert")
+	err := d.DB.DeleteClientCert(oak, identity)
+	d.finish(span, "DeleteClientCert", start, err)
+	return err
+}
+
+func (d *instrumented) GetKey(ak string) (Key, error) {
+	span, start := d.start("GetKey")
+	v, err := d.DB.GetKey(ak)
+	d.finish(span, "GetKey", start, err)
+	return v, err
+}
+
+func (d *instrumented) ListKey(oak string) ([]Key, error) {
+	span, start := d.start("ListKey")
+	v, err := d.DB.ListKey(oak)
+	d.finish(span, "ListKey", start, err)
+	return v, err
+}
+
+func (d *instrumented) SaveKey(data map[string]interface{}) (int, error) {
+	span, start := d.start("SaveKey")
+	v, err := d.DB.SaveKey(data)
+	d.finish(span, "SaveKey", start, err)
+	return v, err
+}
+
+func (d *instrumented) ExpireKey(oak string, expire time.Time) error {
+	span, start := d.start("ExpireKey")
+	err := d.DB.ExpireKey(oak, expire)
+	d.finish(span, "ExpireKey", start, err)
+	return err
+}
+
+func (d *instrumented) GetMirror(oak string) (Mirror, error) {
+	span, start := d.start("GetMirror")
+	v, err := d.DB.GetMirror(oak)
+	d.finish(span, "GetMirror", start, err)
+	return v, err
+}
+
+func (d *instrumented) SaveMirror(data map[string]interface{}) (int, error) {
+	span, start := d.start("SaveMirror")
+	v, err := d.DB.SaveMirror(data)
+	d.finish(span, "SaveMirror", start, err)
+	return v, err
+}
+
+func (d *instrumented) GetErasure(oak string) (Erasure, error) {
+	span, start := d.start("GetErasure")
+	v, err := d.DB.GetErasure(oak)
+	d.finish(span, "GetErasure", start, err)
+	return v, err
+}
+
+func (d *instrumented) SaveErasure(data map[string]interface{}) (int, error) {
+	span, start := d.start("SaveErasure")
+	v, err := d.DB.SaveErasure(data)
+	d.finish(span, "SaveErasure", start, err)
+	return v, err
+}
+
+func (d *instrumented) AddRepair(r Repair) error {
+	span, start := d.start("AddRepair")
+	err := d.DB.AddRepair(r)
+	d.finish(span, "AddRepair", start, err)
+	return err
+}
+
+func (d *instrumented) ListRepair(before time.Time, limit int) ([]Repair, error) {
+	span, start := d.start("ListRepair")
+	v, err := d.DB.ListRepair(before, limit)
+	d.finish(span, "ListRepair", start, err)
+	return v, err
+}
+
+func (d *instrumented) RetryRepair(id int64, attempts int, next time.Time, lastError string) error {
+	span, start := d.start("RetryRepair")
+	err := d.DB.RetryRepair(id, attempts, next, lastError)
+	d.finish(span, "RetryRepair", start, err)
+	return err
+}
+
+func (d *instrumented) DeleteRepair(id int64) error {
+	span, start := d.start("DeleteRepair")
+	err := d.DB.DeleteRepair(id)
+	d.finish(span, "DeleteRepair", start, err)
+	return err
+}
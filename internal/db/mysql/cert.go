@@ -0,0 +1,86 @@
+package mysql
+
+import (
+	"github.com/solution9th/S3Adapter/internal/db"
+
+	"github.com/haozibi/gendry/builder"
+	"github.com/haozibi/gendry/scanner"
+)
+
+// GetClientCert 查找 identities 对应的客户端证书映射，没有时返回空列表
+func (d *MySQLFunc) GetClientCert(identities []string) ([]db.ClientCert, error) {
+
+	var m []db.ClientCert
+
+	if len(identities) == 0 {
+		return m, ErrMissParams
+	}
+
+	in := make([]interface{}, 0, len(identities))
+	for _, id := range identities {
+		in = append(in, id)
+	}
+
+	where := map[string]interface{}{
+		"identity in": in,
+	}
+
+	err := d.query(d.tableNameCert, where, &m)
+	if err == scanner.ErrEmptyResult {
+		return m, nil
+	}
+	return m, err
+}
+
+// ListClientCert 列出应用的客户端证书映射
+func (d *MySQLFunc) ListClientCert(oak string) ([]db.ClientCert, error) {
+
+	var m []db.ClientCert
+
+	if oak == "" {
+		return m, ErrMissParams
+	}
+
+	where := map[string]interface{}{
+		"os_access_key": oak,
+		"_orderby":      "id asc",
+	}
+
+	err := d.query(d.tableNameCert, where, &m)
+	if err == scanner.ErrEmptyResult {
+		return m, nil
+	}
+	return m, err
+}
+
+// SaveClientCert 保存客户端证书映射
+func (d *MySQLFunc) SaveClientCert(data map[string]interface{}) (id int, err error) {
+	return d.save(d.tableNameCert, data)
+}
+
+// DeleteClientCert 删除应用的客户端证书映射，不存在时返回 db.ErrNotFound
+func (d *MySQLFunc) DeleteClientCert(oak, identity string) error {
+
+	if oak == "" || identity == "" {
+		return ErrMissParams
+	}
+
+	cond, val, err := builder.BuildDelete(d.tableNameCert, map[string]interface{}{
+		"os_access_key": oak,
+		"identity":      identity,
+	})
+	if err != nil {
+		return err
+	}
+
+	r, err := d.client.Exec(cond, val...)
+	if err != nil {
+		return err
+	}
+
+	n, err := r.RowsAffected()
+	if err == nil && n == 0 {
+		return db.ErrNotFound
+	}
+	return err
+}
@@ -0,0 +1,39 @@
+package mysql
+
+import (
+	"github.com/solution9th/S3Adapter/internal/db"
+
+	"github.com/haozibi/gendry/scanner"
+)
+
+// GetErasure 根据 OsAccessKey 查找纠删码配置
+func (d *MySQLFunc) GetErasure(oak string) (db.Erasure, error) {
+
+	var e db.Erasure
+
+	if oak == "" {
+		return e, ErrMissParams
+	}
+
+	where := map[string]interface{}{
+		"os_access_key": oak,
+	}
+
+	err := d.query(d.tableNameErasure, where, &e)
+	if err == scanner.ErrEmptyResult {
+		return e, db.ErrNotFound
+	}
+	if err != nil {
+		return e, err
+	}
+	return e, d.decrypt(&e.Backends)
+}
+
+// SaveErasure 保存纠删码配置，backends 中有后端的 key，整体加密
+func (d *MySQLFunc) SaveErasure(data map[string]interface{}) (id int, err error) {
+
+	if err = d.encrypt(data, "backends"); err != nil {
+		return 0, err
+	}
+	return d.save(d.tableNameErasure, data)
+}
@@ -10,8 +10,10 @@ import (
 	"time"
 
 	"github.com/solution9th/S3Adapter/conf"
+	"github.com/solution9th/S3Adapter/internal/db"
 
 	"github.com/haozibi/gendry/builder"
+	"github.com/haozibi/gendry/scanner"
 )
 
 // const (
@@ -49,17 +51,60 @@ type Info struct {
 
 	// AppRemark 应用备注
 	AppRemark string `json:"app_remark" `
+
+	// KeyExpireTime 使用轮换后的 key 查找时为 key 的过期时间，为 nil 时不过期，不是 info 表的字段
+	KeyExpireTime *time.Time `json:"-"`
+
+	// UserName, Policy 使用应用下用户的 key 查找时为用户名和身份策略，不是 info 表的字段
+	UserName string `json:"-"`
+	Policy   string `json:"-"`
+
+	// SessionToken, SessionPolicy 使用 STS 临时凭证查找时为 token 的 SHA256 和会话策略，
+	// KeyExpireTime 为临时凭证的过期时间，不是 info 表的字段
+	SessionToken  string `json:"-"`
+	SessionPolicy string `json:"-"`
+
+	// NetworkAllow, NetworkDeny 应用允许和拒绝的客户端 CIDR，逗号分隔，不是 info 表的字段
+	NetworkAllow string `json:"-"`
+	NetworkDeny  string `json:"-"`
 }
 
-// AddTable 如果表不存在则创建
+// AddTable 如果表不存在则创建，已经存在时修改长度不够的字段
 func (d *MySQLFunc) AddTable() (err error) {
 
-	body, err := conf.Asset("conf/info.sql")
+	tables := []struct {
+		asset, name string
+	}{
+		{"conf/info.sql", d.tableNameInfo},
+		{"conf/tier.sql", d.tableNameTier},
+		{"conf/placement.sql", d.tableNamePlacement},
+		{"conf/mirror.sql", d.tableNameMirror},
+		{"conf/repair.sql", d.tableNameRepair},
+		{"conf/erasure.sql", d.tableNameErasure},
+		{"conf/key.sql", d.tableNameKey},
+		{"conf/user.sql", d.tableNameUser},
+		{"conf/session.sql", d.tableNameSession},
+		{"conf/region.sql", d.tableNameRegion},
+		{"conf/network.sql", d.tableNameNetwork},
+		{"conf/cert.sql", d.tableNameCert},
+	}
+
+	for _, t := range tables {
+		if err = d.addTable(t.asset, t.name); err != nil {
+			return err
+		}
+	}
+	return d.widenColumns()
+}
+
+func (d *MySQLFunc) addTable(asset, tableName string) error {
+
+	body, err := conf.Asset(asset)
 	if err != nil {
 		return err
 	}
 
-	sql := fmt.Sprintf(string(body), d.tableNameInfo)
+	sql := fmt.Sprintf(string(body), tableName)
 
 	cond, val, err := builder.NamedQuery(sql, nil)
 	if err != nil {
@@ -70,57 +115,267 @@ func (d *MySQLFunc) AddTable() (err error) {
 	return err
 }
 
-// CountInfo 统计 info 数量
+// CountInfo 统计 info 数量，engine_secret_key 加密保存，解密后比较
 func (d *MySQLFunc) CountInfo(ak, sk, engine string) (int, error) {
 
-	sql := fmt.Sprintf("SELECT COUNT(*) AS `count` FROM %v WHERE engine_access_key = {{ak}} AND engine_secret_key = {{sk}} AND engine_type = {{engine}}", d.tableNameInfo)
+	var list []Info
 
-	cond, val, err := builder.NamedQuery(sql, map[string]interface{}{
-		"ak":     ak,
-		"sk":     sk,
-		"engine": engine,
-	})
+	where := map[string]interface{}{
+		"engine_access_key": ak,
+		"engine_type":       engine,
+	}
+
+	err := d.query(d.tableNameInfo, where, &list)
+	if err == scanner.ErrEmptyResult {
+		return 0, nil
+	}
 	if err != nil {
 		return 0, err
 	}
 
-	return d.count(cond, val...)
+	num := 0
+	for _, m := range list {
+		if err = d.decrypt(&m.EngineSecretKey); err != nil {
+			return 0, err
+		}
+		if m.EngineSecretKey == sk {
+			num++
+		}
+	}
+	return num, nil
 }
 
-// GetInfo 根据 OsAccessKey 查找具体 info
+// GetInfo 根据本地 key 查找具体 info，ak 为轮换后的 key 时，
+// OsAccessKey 为应用创建时的 key，OsScrectKey 和 KeyExpireTime 为 ak 对应的 key，不检查是否过期；
+// ak 为应用下用户的 key 时，OsScrectKey、UserName 和 Policy 为用户的，
+// ak 为 STS 临时凭证时还会填写 SessionToken、SessionPolicy 和 KeyExpireTime；
+// 应用配置了网络限制时填写 NetworkAllow 和 NetworkDeny
 func (d *MySQLFunc) GetInfo(ak string) (interface{}, error) {
 
+	m, err := d.getInfo(ak)
+	if err != nil {
+		return m, err
+	}
+
+	n, err := d.GetNetwork(m.OsAccessKey)
+	if err == db.ErrNotFound {
+		return m, nil
+	}
+	if err != nil {
+		return Info{}, err
+	}
+	m.NetworkAllow, m.NetworkDeny = n.Allow, n.Deny
+	return m, nil
+}
+
+func (d *MySQLFunc) getInfo(ak string) (Info, error) {
+
 	var m Info
 
 	if ak == "" {
 		return m, ErrMissParams
 	}
 
+	k, err := d.GetKey(ak)
+	if err != nil && err != db.ErrNotFound {
+		return m, err
+	}
+	rotated := err == nil
+
+	oak := ak
+	if rotated {
+		oak = k.OsAccessKey
+	}
+
 	where := map[string]interface{}{
-		"os_access_key": ak,
+		"os_access_key": oak,
+	}
+
+	err = d.query(d.tableNameInfo, where, &m)
+	if err == scanner.ErrEmptyResult && !rotated {
+		m, err = d.getUserInfo(ak)
+		if err == db.ErrNotFound {
+			return d.getSessionInfo(ak)
+		}
+		return m, err
+	}
+	if err == scanner.ErrEmptyResult {
+		return m, db.ErrNotFound
+	}
+	if err != nil {
+		return m, err
+	}
+
+	if err = d.decrypt(&m.OsScrectKey, &m.EngineSecretKey); err != nil {
+		return m, err
+	}
+	if rotated {
+		m.OsScrectKey, m.KeyExpireTime = k.SecretKey, k.ExpireTime
+	}
+	return m, nil
+}
+
+// getUserInfo 根据应用下用户的 key 查找应用
+func (d *MySQLFunc) getUserInfo(ak string) (Info, error) {
+
+	var m Info
+
+	u, err := d.GetUser(ak)
+	if err != nil {
+		return m, err
+	}
+
+	err = d.query(d.tableNameInfo, map[string]interface{}{"os_access_key": u.OsAccessKey}, &m)
+	if err == scanner.ErrEmptyResult {
+		return m, db.ErrNotFound
+	}
+	if err != nil {
+		return m, err
 	}
 
-	err := d.query(d.tableNameInfo, where, &m)
-	return m, err
+	if err = d.decrypt(&m.EngineSecretKey); err != nil {
+		return m, err
+	}
+	m.OsScrectKey, m.UserName, m.Policy = u.SecretKey, u.UserName, u.Policy
+	return m, nil
 }
 
-// SaveInfo 保存信息
+// getSessionInfo 根据 STS 临时凭证查找应用，签发给用户的临时凭证使用用户当前的策略，
+// 用户已经删除时返回 db.ErrNotFound
+func (d *MySQLFunc) getSessionInfo(ak string) (Info, error) {
+
+	var m Info
+
+	s, err := d.GetSession(ak)
+	if err != nil {
+		return m, err
+	}
+
+	err = d.query(d.tableNameInfo, map[string]interface{}{"os_access_key": s.OsAccessKey}, &m)
+	if err == scanner.ErrEmptyResult {
+		return m, db.ErrNotFound
+	}
+	if err != nil {
+		return m, err
+	}
+
+	if err = d.decrypt(&m.EngineSecretKey); err != nil {
+		return m, err
+	}
+
+	if s.UserName != "" {
+		var u db.User
+		err = d.query(d.tableNameUser, map[string]interface{}{
+			"os_access_key": s.OsAccessKey,
+			"user_name":     s.UserName,
+		}, &u)
+		if err == scanner.ErrEmptyResult {
+			return Info{}, db.ErrNotFound
+		}
+		if err != nil {
+			return Info{}, err
+		}
+		m.UserName, m.Policy = u.UserName, u.Policy
+	}
+
+	expire := s.ExpireTime
+	m.OsScrectKey, m.KeyExpireTime = s.SecretKey, &expire
+	m.SessionToken, m.SessionPolicy = s.SessionToken, s.Policy
+	return m, nil
+}
+
+// SaveInfo 保存信息，加密 os_screct_key 和 engine_secret_key
 func (d *MySQLFunc) SaveInfo(data map[string]interface{}) (id int, err error) {
 
+	if err = d.encrypt(data, "os_screct_key", "engine_secret_key"); err != nil {
+		return 0, err
+	}
 	return d.save(d.tableNameInfo, data)
 }
 
-// DeleteInfo 删除 info
+// UpdateInfo 修改应用创建时的 key 为 oak 的 info，加密 os_screct_key 和 engine_secret_key，
+// 应用不存在时返回 db.ErrNotFound
+func (d *MySQLFunc) UpdateInfo(oak string, data map[string]interface{}) error {
+
+	if oak == "" || len(data) == 0 {
+		return ErrMissParams
+	}
+
+	if err := d.encrypt(data, "os_screct_key", "engine_secret_key"); err != nil {
+		return err
+	}
+
+	cond, val, err := builder.BuildUpdate(d.tableNameInfo, map[string]interface{}{
+		"os_access_key": oak,
+	}, data)
+	if err != nil {
+		return err
+	}
+
+	r, err := d.client.Exec(cond, val...)
+	if err != nil {
+		return err
+	}
+
+	// 值没有变化时 RowsAffected 也为 0，再确认应用是否存在
+	if n, err := r.RowsAffected(); err != nil || n > 0 {
+		return err
+	}
+
+	var m Info
+	err = d.query(d.tableNameInfo, map[string]interface{}{"os_access_key": oak}, &m)
+	if err == scanner.ErrEmptyResult {
+		return db.ErrNotFound
+	}
+	return err
+}
+
+// DeleteInfo 删除 info，同时删除对应的 key、用户、临时凭证、分层、镜像、纠删码配置和修复队列
 func (d *MySQLFunc) DeleteInfo(oak, osk string) error {
 
+	// os_screct_key 加密保存，不能直接作为删除条件
+	var m Info
+	err := d.query(d.tableNameInfo, map[string]interface{}{"os_access_key": oak}, &m)
+	if err == scanner.ErrEmptyResult {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if err = d.decrypt(&m.OsScrectKey); err != nil {
+		return err
+	}
+	if m.OsScrectKey != osk {
+		return nil
+	}
+
 	cond, val, err := builder.BuildDelete(d.tableNameInfo, map[string]interface{}{
-		"os_access_key": oak,
-		"os_screct_key": osk,
+		"id": m.ID,
 	})
 	if err != nil {
 		return err
 	}
 
-	_, err = d.client.Exec(cond, val...)
-	return err
+	r, err := d.client.Exec(cond, val...)
+	if err != nil {
+		return err
+	}
+
+	if n, err := r.RowsAffected(); err != nil || n == 0 {
+		return err
+	}
+
+	for _, table := range []string{d.tableNameKey, d.tableNameUser, d.tableNameSession, d.tableNameRegion, d.tableNameNetwork, d.tableNameCert, d.tableNameTier, d.tableNamePlacement, d.tableNameMirror, d.tableNameRepair, d.tableNameErasure} {
+		cond, val, err = builder.BuildDelete(table, map[string]interface{}{
+			"os_access_key": oak,
+		})
+		if err != nil {
+			return err
+		}
+
+		if _, err = d.client.Exec(cond, val...); err != nil {
+			return err
+		}
+	}
+	return nil
 }
@@ -0,0 +1,89 @@
+package mysql
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/solution9th/S3Adapter/internal/db"
+
+	"github.com/haozibi/gendry/builder"
+	"github.com/haozibi/gendry/scanner"
+)
+
+// GetKey 根据轮换后的 AccessKey 查找 key，包括已经过期的
+func (d *MySQLFunc) GetKey(ak string) (db.Key, error) {
+
+	var k db.Key
+
+	if ak == "" {
+		return k, ErrMissParams
+	}
+
+	where := map[string]interface{}{
+		"access_key": ak,
+	}
+
+	err := d.query(d.tableNameKey, where, &k)
+	if err == scanner.ErrEmptyResult {
+		return k, db.ErrNotFound
+	}
+	if err != nil {
+		return k, err
+	}
+	return k, d.decrypt(&k.SecretKey)
+}
+
+// ListKey 列出应用所有的 key，包括已经过期的
+func (d *MySQLFunc) ListKey(oak string) ([]db.Key, error) {
+
+	var m []db.Key
+
+	if oak == "" {
+		return m, ErrMissParams
+	}
+
+	where := map[string]interface{}{
+		"os_access_key": oak,
+		"_orderby":      "id asc",
+	}
+
+	err := d.query(d.tableNameKey, where, &m)
+	if err == scanner.ErrEmptyResult {
+		return m, nil
+	}
+	if err != nil {
+		return m, err
+	}
+	for i := range m {
+		if err = d.decrypt(&m[i].SecretKey); err != nil {
+			return m, err
+		}
+	}
+	return m, nil
+}
+
+// SaveKey 保存 key，加密 secret_key
+func (d *MySQLFunc) SaveKey(data map[string]interface{}) (id int, err error) {
+
+	if err = d.encrypt(data, "secret_key"); err != nil {
+		return 0, err
+	}
+	return d.save(d.tableNameKey, data)
+}
+
+// ExpireKey 应用所有没有过期时间或者过期时间晚于 expire 的 key 在 expire 过期
+func (d *MySQLFunc) ExpireKey(oak string, expire time.Time) error {
+
+	sql := fmt.Sprintf("UPDATE %v SET expire_time = {{expire}} WHERE os_access_key = {{oak}} AND (expire_time IS NULL OR expire_time > {{expire}})", d.tableNameKey)
+
+	cond, val, err := builder.NamedQuery(sql, map[string]interface{}{
+		"oak":    oak,
+		"expire": expire,
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = d.client.Exec(cond, val...)
+	return err
+}
@@ -0,0 +1,49 @@
+package mysql
+
+import (
+	"fmt"
+
+	"github.com/haozibi/zlog"
+)
+
+// widenColumns 把早期建表时较短的字段改为 varchar(255)，
+// azure 的账户密钥为 88 个字符的 base64，region 可以是服务地址，加密保存的密文也比明文长
+func (d *MySQLFunc) widenColumns() error {
+
+	columns := []struct {
+		table, column, comment string
+	}{
+		{d.tableNameInfo, "os_screct_key", "本地key"},
+		{d.tableNameInfo, "engine_region", "引擎具体region"},
+		{d.tableNameInfo, "engine_secret_key", "引擎具体的key"},
+		{d.tableNameKey, "secret_key", "轮换后的本地key"},
+	}
+
+	for _, c := range columns {
+
+		rows, err := d.client.Query("SELECT CHARACTER_MAXIMUM_LENGTH FROM information_schema.COLUMNS "+
+			"WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = ? AND COLUMN_NAME = ?", c.table, c.column)
+		if err != nil {
+			return err
+		}
+		var size int
+		for rows.Next() {
+			if err = rows.Scan(&size); err != nil {
+				rows.Close()
+				return err
+			}
+		}
+		rows.Close()
+
+		if size == 0 || size >= 255 {
+			continue
+		}
+
+		zlog.ZInfo().Str("Table", c.table).Str("Column", c.column).Msg("[MySQL] widen column")
+		sql := fmt.Sprintf("ALTER TABLE `%s` MODIFY `%s` varchar(255) NOT NULL COMMENT '%s'", c.table, c.column, c.comment)
+		if _, err = d.client.Exec(sql); err != nil {
+			return err
+		}
+	}
+	return nil
+}
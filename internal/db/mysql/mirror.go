@@ -0,0 +1,127 @@
+package mysql
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/solution9th/S3Adapter/internal/db"
+
+	"github.com/haozibi/gendry/builder"
+	"github.com/haozibi/gendry/scanner"
+)
+
+const (
+	maxLastErrorLength = 255
+)
+
+// GetMirror 根据 OsAccessKey 查找镜像引擎配置
+func (d *MySQLFunc) GetMirror(oak string) (db.Mirror, error) {
+
+	var m db.Mirror
+
+	if oak == "" {
+		return m, ErrMissParams
+	}
+
+	where := map[string]interface{}{
+		"os_access_key": oak,
+	}
+
+	err := d.query(d.tableNameMirror, where, &m)
+	if err == scanner.ErrEmptyResult {
+		return m, db.ErrNotFound
+	}
+	if err != nil {
+		return m, err
+	}
+	return m, d.decrypt(&m.SecretKey)
+}
+
+// SaveMirror 保存镜像引擎配置，加密 secret_key
+func (d *MySQLFunc) SaveMirror(data map[string]interface{}) (id int, err error) {
+
+	if err = d.encrypt(data, "secret_key"); err != nil {
+		return 0, err
+	}
+	return d.save(d.tableNameMirror, data)
+}
+
+// AddRepair 添加修复记录，同一个对象已经存在时重置重试次数
+func (d *MySQLFunc) AddRepair(r db.Repair) error {
+
+	sql := fmt.Sprintf("INSERT INTO %v (os_access_key, bucket, object, object_md5, attempts, next_retry_time, last_error) "+
+		"VALUES ({{oak}}, {{bucket}}, {{object}}, {{md5}}, 0, {{next}}, {{error}}) "+
+		"ON DUPLICATE KEY UPDATE attempts = 0, next_retry_time = VALUES(next_retry_time), last_error = VALUES(last_error)", d.tableNameRepair)
+
+	cond, val, err := builder.NamedQuery(sql, map[string]interface{}{
+		"oak":    r.OsAccessKey,
+		"bucket": r.Bucket,
+		"object": r.Object,
+		"md5":    objectMD5(r.Object),
+		"next":   r.NextRetryTime,
+		"error":  truncate(r.LastError, maxLastErrorLength),
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = d.client.Exec(cond, val...)
+	return err
+}
+
+// ListRepair 列出需要重试的修复记录
+func (d *MySQLFunc) ListRepair(before time.Time, limit int) ([]db.Repair, error) {
+
+	var m []db.Repair
+
+	where := map[string]interface{}{
+		"next_retry_time <=": before,
+		"_orderby":           "next_retry_time asc",
+		"_limit":             []uint{0, uint(limit)},
+	}
+
+	err := d.query(d.tableNameRepair, where, &m)
+	if err == scanner.ErrEmptyResult {
+		err = nil
+	}
+	return m, err
+}
+
+// RetryRepair 修复失败，更新重试次数和下次重试时间
+func (d *MySQLFunc) RetryRepair(id int64, attempts int, next time.Time, lastError string) error {
+
+	cond, val, err := builder.BuildUpdate(d.tableNameRepair, map[string]interface{}{
+		"id": id,
+	}, map[string]interface{}{
+		"attempts":        attempts,
+		"next_retry_time": next,
+		"last_error":      truncate(lastError, maxLastErrorLength),
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = d.client.Exec(cond, val...)
+	return err
+}
+
+// DeleteRepair 修复成功，删除记录
+func (d *MySQLFunc) DeleteRepair(id int64) error {
+
+	cond, val, err := builder.BuildDelete(d.tableNameRepair, map[string]interface{}{
+		"id": id,
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = d.client.Exec(cond, val...)
+	return err
+}
+
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return s[:n]
+}
@@ -0,0 +1,53 @@
+package mysql
+
+import (
+	"fmt"
+
+	"github.com/solution9th/S3Adapter/internal/db"
+
+	"github.com/haozibi/gendry/builder"
+	"github.com/haozibi/gendry/scanner"
+)
+
+// GetNetwork 根据 OsAccessKey 查找应用的网络限制
+func (d *MySQLFunc) GetNetwork(oak string) (db.Network, error) {
+
+	var n db.Network
+
+	if oak == "" {
+		return n, ErrMissParams
+	}
+
+	where := map[string]interface{}{
+		"os_access_key": oak,
+	}
+
+	err := d.query(d.tableNameNetwork, where, &n)
+	if err == scanner.ErrEmptyResult {
+		return n, db.ErrNotFound
+	}
+	return n, err
+}
+
+// SaveNetwork 保存应用的网络限制，已经存在时覆盖
+func (d *MySQLFunc) SaveNetwork(n db.Network) error {
+
+	if n.OsAccessKey == "" {
+		return ErrMissParams
+	}
+
+	sql := fmt.Sprintf("INSERT INTO %v (os_access_key, allow, deny) VALUES ({{oak}}, {{allow}}, {{deny}}) "+
+		"ON DUPLICATE KEY UPDATE allow = VALUES(allow), deny = VALUES(deny)", d.tableNameNetwork)
+
+	cond, val, err := builder.NamedQuery(sql, map[string]interface{}{
+		"oak":   n.OsAccessKey,
+		"allow": n.Allow,
+		"deny":  n.Deny,
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = d.client.Exec(cond, val...)
+	return err
+}
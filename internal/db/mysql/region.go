@@ -0,0 +1,33 @@
+package mysql
+
+import (
+	"github.com/solution9th/S3Adapter/internal/db"
+
+	"github.com/haozibi/gendry/scanner"
+)
+
+// ListRegion 列出应用的地区映射
+func (d *MySQLFunc) ListRegion(oak string) ([]db.Region, error) {
+
+	var m []db.Region
+
+	if oak == "" {
+		return m, ErrMissParams
+	}
+
+	where := map[string]interface{}{
+		"os_access_key": oak,
+		"_orderby":      "id asc",
+	}
+
+	err := d.query(d.tableNameRegion, where, &m)
+	if err == scanner.ErrEmptyResult {
+		return m, nil
+	}
+	return m, err
+}
+
+// SaveRegion 保存应用的地区映射
+func (d *MySQLFunc) SaveRegion(data map[string]interface{}) (id int, err error) {
+	return d.save(d.tableNameRegion, data)
+}
@@ -0,0 +1,170 @@
+package mysql
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/solution9th/S3Adapter/internal/secret"
+
+	"github.com/haozibi/gendry/builder"
+)
+
+// rekeyBatch rekey 每次读取的行数
+const rekeyBatch = 100
+
+// secretColumn 需要加密的字段
+type secretColumn struct {
+	table   string
+	columns []string
+}
+
+// secretColumns 所有加密保存的字段
+func (d *MySQLFunc) secretColumns() []secretColumn {
+	return []secretColumn{
+		{d.tableNameInfo, []string{"os_screct_key", "engine_secret_key"}},
+		{d.tableNameKey, []string{"secret_key"}},
+		{d.tableNameUser, []string{"secret_key"}},
+		{d.tableNameSession, []string{"secret_key"}},
+		{d.tableNameTier, []string{"cold_secret_key"}},
+		{d.tableNameMirror, []string{"secret_key"}},
+		{d.tableNameErasure, []string{"backends"}},
+	}
+}
+
+// encrypt 加密 data 中的 keys，不存在或者不是字符串的忽略
+func (d *MySQLFunc) encrypt(data map[string]interface{}, keys ...string) error {
+
+	for _, k := range keys {
+		s, ok := data[k].(string)
+		if !ok {
+			continue
+		}
+		v, err := d.cipher.Encrypt(s)
+		if err != nil {
+			return err
+		}
+		data[k] = v
+	}
+	return nil
+}
+
+// decrypt 原地解密
+func (d *MySQLFunc) decrypt(values ...*string) error {
+
+	for _, p := range values {
+		v, err := d.cipher.Decrypt(*p)
+		if err != nil {
+			return err
+		}
+		*p = v
+	}
+	return nil
+}
+
+// Rekey 把所有加密字段从 old 加密改为使用当前的主密钥加密，返回修改的行数
+//
+// old 为 nil 时原来的数据为明文，当前没有主密钥时解密为明文保存，
+// 已经使用当前主密钥加密的字段跳过，中断后可以重新执行
+func (d *MySQLFunc) Rekey(old *secret.Cipher) (int, error) {
+
+	total := 0
+	for _, t := range d.secretColumns() {
+		n, err := d.rekeyTable(t, old)
+		total += n
+		if err != nil {
+			return total, fmt.Errorf("rekey %s: %v", t.table, err)
+		}
+	}
+	return total, nil
+}
+
+func (d *MySQLFunc) rekeyTable(t secretColumn, old *secret.Cipher) (int, error) {
+
+	query := fmt.Sprintf("SELECT id, %s FROM %s WHERE id > ? ORDER BY id ASC LIMIT %d",
+		strings.Join(t.columns, ", "), t.table, rekeyBatch)
+
+	var lastID int64
+	total := 0
+	for {
+		rows, err := d.client.Query(query, lastID)
+		if err != nil {
+			return total, err
+		}
+
+		type row struct {
+			id     int64
+			values []string
+		}
+		var list []row
+		for rows.Next() {
+			r := row{values: make([]string, len(t.columns))}
+			dest := []interface{}{&r.id}
+			for i := range r.values {
+				dest = append(dest, &r.values[i])
+			}
+			if err = rows.Scan(dest...); err != nil {
+				rows.Close()
+				return total, err
+			}
+			list = append(list, r)
+		}
+		rows.Close()
+		if err = rows.Err(); err != nil {
+			return total, err
+		}
+
+		for _, r := range list {
+			lastID = r.id
+
+			update := make(map[string]interface{})
+			for i, v := range r.values {
+				s, changed, err := d.reencrypt(v, old)
+				if err != nil {
+					return total, fmt.Errorf("id %d %s: %v", r.id, t.columns[i], err)
+				}
+				if changed {
+					update[t.columns[i]] = s
+				}
+			}
+			if len(update) == 0 {
+				continue
+			}
+
+			cond, val, err := builder.BuildUpdate(t.table, map[string]interface{}{"id": r.id}, update)
+			if err != nil {
+				return total, err
+			}
+			if _, err = d.client.Exec(cond, val...); err != nil {
+				return total, err
+			}
+			total++
+		}
+
+		if len(list) < rekeyBatch {
+			return total, nil
+		}
+	}
+}
+
+// reencrypt 使用 old 解密 v 后使用当前的主密钥加密，v 已经使用当前的主密钥加密时不修改
+func (d *MySQLFunc) reencrypt(v string, old *secret.Cipher) (string, bool, error) {
+
+	if v == "" {
+		return v, false, nil
+	}
+	if secret.IsEncrypted(v) && d.cipher != nil {
+		if _, err := d.cipher.Decrypt(v); err == nil {
+			return v, false, nil
+		}
+	}
+	if !secret.IsEncrypted(v) && d.cipher == nil {
+		return v, false, nil
+	}
+
+	plain, err := old.Decrypt(v)
+	if err != nil {
+		return "", false, err
+	}
+	s, err := d.cipher.Encrypt(plain)
+	return s, true, err
+}
@@ -0,0 +1,62 @@
+package mysql
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/solution9th/S3Adapter/internal/secret"
+
+	"github.com/smartystreets/goconvey/convey"
+)
+
+func TestReencrypt(t *testing.T) {
+
+	convey.Convey("reencrypt", t, func() {
+
+		oldCipher, _ := secret.New(bytes.Repeat([]byte{1}, secret.KeySize))
+		newCipher, _ := secret.New(bytes.Repeat([]byte{2}, secret.KeySize))
+
+		d := &MySQLFunc{cipher: newCipher}
+
+		convey.Convey("plaintext to new key", func() {
+			s, changed, err := d.reencrypt("sksk", nil)
+			convey.So(err, convey.ShouldBeNil)
+			convey.So(changed, convey.ShouldBeTrue)
+			plain, _ := newCipher.Decrypt(s)
+			convey.So(plain, convey.ShouldEqual, "sksk")
+		})
+
+		convey.Convey("old key to new key", func() {
+			v, _ := oldCipher.Encrypt("sksk")
+			s, changed, err := d.reencrypt(v, oldCipher)
+			convey.So(err, convey.ShouldBeNil)
+			convey.So(changed, convey.ShouldBeTrue)
+			plain, _ := newCipher.Decrypt(s)
+			convey.So(plain, convey.ShouldEqual, "sksk")
+		})
+
+		convey.Convey("already rekeyed", func() {
+			v, _ := newCipher.Encrypt("sksk")
+			s, changed, err := d.reencrypt(v, oldCipher)
+			convey.So(err, convey.ShouldBeNil)
+			convey.So(changed, convey.ShouldBeFalse)
+			convey.So(s, convey.ShouldEqual, v)
+		})
+
+		convey.Convey("wrong old key", func() {
+			other, _ := secret.New(bytes.Repeat([]byte{3}, secret.KeySize))
+			v, _ := other.Encrypt("sksk")
+			_, _, err := d.reencrypt(v, oldCipher)
+			convey.So(err, convey.ShouldEqual, secret.ErrDecrypt)
+		})
+
+		convey.Convey("decrypt to plaintext", func() {
+			v, _ := oldCipher.Encrypt("sksk")
+			plain := &MySQLFunc{}
+			s, changed, err := plain.reencrypt(v, oldCipher)
+			convey.So(err, convey.ShouldBeNil)
+			convey.So(changed, convey.ShouldBeTrue)
+			convey.So(s, convey.ShouldEqual, "sksk")
+		})
+	})
+}
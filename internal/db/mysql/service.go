@@ -1,6 +1,7 @@
 package mysql
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 	"fmt"
@@ -8,6 +9,7 @@ import (
 	"time"
 
 	"github.com/solution9th/S3Adapter/internal/db"
+	"github.com/solution9th/S3Adapter/internal/secret"
 
 	_ "github.com/go-sql-driver/mysql"
 	"github.com/haozibi/gendry/builder"
@@ -27,17 +29,43 @@ func init() {
 
 // MySQLFunc database operation
 type MySQLFunc struct {
-	tableNameInfo string
-	client        *sql.DB
+	tableNameInfo      string
+	tableNameTier      string
+	tableNamePlacement string
+	tableNameMirror    string
+	tableNameRepair    string
+	tableNameErasure   string
+	tableNameKey       string
+	tableNameUser      string
+	tableNameSession   string
+	tableNameRegion    string
+	tableNameNetwork   string
+	tableNameCert      string
+	client             *sql.DB
+
+	// cipher 加密 key 的主密钥，为 nil 时明文保存
+	cipher *secret.Cipher
 }
 
 var defaultDB *sql.DB
 
-// NewDB new MySQLFunc
-func NewDB(table string) db.DB {
+// NewDB new MySQLFunc，c 不为 nil 时使用它加密保存 key
+func NewDB(table string, c *secret.Cipher) db.DB {
 	return &MySQLFunc{
-		tableNameInfo: table,
-		client:        defaultDB,
+		tableNameInfo:      table,
+		tableNameTier:      table + "_tier",
+		tableNamePlacement: table + "_placement",
+		tableNameMirror:    table + "_mirror",
+		tableNameRepair:    table + "_repair",
+		tableNameErasure:   table + "_erasure",
+		tableNameKey:       table + "_key",
+		tableNameUser:      table + "_user",
+		tableNameSession:   table + "_session",
+		tableNameRegion:    table + "_region",
+		tableNameNetwork:   table + "_network",
+		tableNameCert:      table + "_cert",
+		client:             defaultDB,
+		cipher:             c,
 	}
 }
 
@@ -101,6 +129,14 @@ func (d *MySQLFunc) link(dbName, user, passwd, host string, port int) (err error
 	return nil
 }
 
+// Ping 检查连接是否可用
+func (d *MySQLFunc) Ping(ctx context.Context) error {
+	if d.client == nil {
+		return errors.New("mysql not linked")
+	}
+	return d.client.PingContext(ctx)
+}
+
 func (d *MySQLFunc) query(tableName string, where map[string]interface{}, ptr interface{}) error {
 
 	// if d.db == nil {
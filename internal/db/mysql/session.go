@@ -0,0 +1,86 @@
+package mysql
+
+import (
+	"time"
+
+	"github.com/solution9th/S3Adapter/internal/db"
+
+	"github.com/haozibi/gendry/builder"
+	"github.com/haozibi/gendry/scanner"
+)
+
+// GetSession 根据临时的 AccessKey 查找 STS 临时凭证，包括已经过期的
+func (d *MySQLFunc) GetSession(ak string) (db.Session, error) {
+
+	var s db.Session
+
+	if ak == "" {
+		return s, ErrMissParams
+	}
+
+	where := map[string]interface{}{
+		"access_key": ak,
+	}
+
+	err := d.query(d.tableNameSession, where, &s)
+	if err == scanner.ErrEmptyResult {
+		return s, db.ErrNotFound
+	}
+	if err != nil {
+		return s, err
+	}
+	return s, d.decrypt(&s.SecretKey)
+}
+
+// ListSession 列出应用没有过期的临时凭证
+func (d *MySQLFunc) ListSession(oak string) ([]db.Session, error) {
+
+	var m []db.Session
+
+	if oak == "" {
+		return m, ErrMissParams
+	}
+
+	where := map[string]interface{}{
+		"os_access_key": oak,
+		"expire_time >": time.Now(),
+		"_orderby":      "id asc",
+	}
+
+	err := d.query(d.tableNameSession, where, &m)
+	if err == scanner.ErrEmptyResult {
+		return m, nil
+	}
+	if err != nil {
+		return m, err
+	}
+	for i := range m {
+		if err = d.decrypt(&m[i].SecretKey); err != nil {
+			return m, err
+		}
+	}
+	return m, nil
+}
+
+// SaveSession 保存临时凭证，加密 secret_key
+func (d *MySQLFunc) SaveSession(data map[string]interface{}) (id int, err error) {
+
+	if err = d.encrypt(data, "secret_key"); err != nil {
+		return 0, err
+	}
+	return d.save(d.tableNameSession, data)
+}
+
+// DeleteExpiredSession 删除在 before 之前过期的临时凭证
+func (d *MySQLFunc) DeleteExpiredSession(before time.Time) error {
+
+	cond, val, err := builder.BuildDelete(d.tableNameSession, map[string]interface{}{
+		"expire_time <": before,
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = d.client.Exec(cond, val...)
+	return err
+}
@@ -0,0 +1,153 @@
+package mysql
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+
+	"github.com/solution9th/S3Adapter/internal/db"
+
+	"github.com/haozibi/gendry/builder"
+	"github.com/haozibi/gendry/scanner"
+)
+
+// GetTier 根据 OsAccessKey 查找冷存储配置
+func (d *MySQLFunc) GetTier(oak string) (db.Tier, error) {
+
+	var m db.Tier
+
+	if oak == "" {
+		return m, ErrMissParams
+	}
+
+	where := map[string]interface{}{
+		"os_access_key": oak,
+	}
+
+	err := d.query(d.tableNameTier, where, &m)
+	if err == scanner.ErrEmptyResult {
+		return m, db.ErrNotFound
+	}
+	if err != nil {
+		return m, err
+	}
+	return m, d.decrypt(&m.ColdSecretKey)
+}
+
+// SaveTier 保存冷存储配置，加密 cold_secret_key
+func (d *MySQLFunc) SaveTier(data map[string]interface{}) (id int, err error) {
+
+	if err = d.encrypt(data, "cold_secret_key"); err != nil {
+		return 0, err
+	}
+	return d.save(d.tableNameTier, data)
+}
+
+func objectMD5(object string) string {
+	sum := md5.Sum([]byte(object))
+	return hex.EncodeToString(sum[:])
+}
+
+// GetPlacement 查找对象所在的存储层
+func (d *MySQLFunc) GetPlacement(oak, bucket, object string) (db.Placement, error) {
+
+	var m db.Placement
+
+	where := map[string]interface{}{
+		"os_access_key": oak,
+		"bucket":        bucket,
+		"object_md5":    objectMD5(object),
+	}
+
+	err := d.query(d.tableNamePlacement, where, &m)
+	if err == scanner.ErrEmptyResult {
+		err = db.ErrNotFound
+	}
+	return m, err
+}
+
+// SavePlacement 保存对象所在的存储层，已经存在则更新
+func (d *MySQLFunc) SavePlacement(p db.Placement) error {
+
+	sql := fmt.Sprintf("INSERT INTO %v (os_access_key, bucket, object, object_md5, tier, size, modify_time) "+
+		"VALUES ({{oak}}, {{bucket}}, {{object}}, {{md5}}, {{tier}}, {{size}}, {{time}}) "+
+		"ON DUPLICATE KEY UPDATE tier = VALUES(tier), size = VALUES(size), modify_time = VALUES(modify_time)", d.tableNamePlacement)
+
+	cond, val, err := builder.NamedQuery(sql, map[string]interface{}{
+		"oak":    p.OsAccessKey,
+		"bucket": p.Bucket,
+		"object": p.Object,
+		"md5":    objectMD5(p.Object),
+		"tier":   p.Tier,
+		"size":   p.Size,
+		"time":   p.ModifyTime,
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = d.client.Exec(cond, val...)
+	return err
+}
+
+// DeletePlacement 删除对象的存储层记录
+func (d *MySQLFunc) DeletePlacement(oak, bucket, object string) error {
+
+	cond, val, err := builder.BuildDelete(d.tableNamePlacement, map[string]interface{}{
+		"os_access_key": oak,
+		"bucket":        bucket,
+		"object_md5":    objectMD5(object),
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = d.client.Exec(cond, val...)
+	return err
+}
+
+// DemotePlacement 把对象的记录从 hot 改为 cold，记录的 modify_time 与 p 相同时才修改，
+// 返回是否修改，迁移期间对象被重新写入时返回 false
+func (d *MySQLFunc) DemotePlacement(p db.Placement) (bool, error) {
+
+	cond, val, err := builder.BuildUpdate(d.tableNamePlacement, map[string]interface{}{
+		"os_access_key": p.OsAccessKey,
+		"bucket":        p.Bucket,
+		"object_md5":    objectMD5(p.Object),
+		"tier":          "hot",
+		"modify_time":   p.ModifyTime,
+	}, map[string]interface{}{
+		"tier": "cold",
+		"size": p.Size,
+	})
+	if err != nil {
+		return false, err
+	}
+
+	r, err := d.client.Exec(cond, val...)
+	if err != nil {
+		return false, err
+	}
+
+	n, err := r.RowsAffected()
+	return n > 0, err
+}
+
+// ListPlacement 按照 id 顺序列出某一存储层的对象
+func (d *MySQLFunc) ListPlacement(tier string, afterID int64, limit int) ([]db.Placement, error) {
+
+	var m []db.Placement
+
+	where := map[string]interface{}{
+		"tier":     tier,
+		"id >":     afterID,
+		"_orderby": "id asc",
+		"_limit":   []uint{0, uint(limit)},
+	}
+
+	err := d.query(d.tableNamePlacement, where, &m)
+	if err == scanner.ErrEmptyResult {
+		err = nil
+	}
+	return m, err
+}
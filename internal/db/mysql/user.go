@@ -0,0 +1,109 @@
+package mysql
+
+import (
+	"github.com/solution9th/S3Adapter/internal/db"
+
+	"github.com/haozibi/gendry/builder"
+	"github.com/haozibi/gendry/scanner"
+)
+
+// GetUser 根据用户的 AccessKey 查找应用下的用户
+func (d *MySQLFunc) GetUser(ak string) (db.User, error) {
+
+	var u db.User
+
+	if ak == "" {
+		return u, ErrMissParams
+	}
+
+	where := map[string]interface{}{
+		"access_key": ak,
+	}
+
+	err := d.query(d.tableNameUser, where, &u)
+	if err == scanner.ErrEmptyResult {
+		return u, db.ErrNotFound
+	}
+	if err != nil {
+		return u, err
+	}
+	return u, d.decrypt(&u.SecretKey)
+}
+
+// ListUser 列出应用下所有的用户
+func (d *MySQLFunc) ListUser(oak string) ([]db.User, error) {
+
+	var m []db.User
+
+	if oak == "" {
+		return m, ErrMissParams
+	}
+
+	where := map[string]interface{}{
+		"os_access_key": oak,
+		"_orderby":      "id asc",
+	}
+
+	err := d.query(d.tableNameUser, where, &m)
+	if err == scanner.ErrEmptyResult {
+		return m, nil
+	}
+	if err != nil {
+		return m, err
+	}
+	for i := range m {
+		if err = d.decrypt(&m[i].SecretKey); err != nil {
+			return m, err
+		}
+	}
+	return m, nil
+}
+
+// SaveUser 保存用户，加密 secret_key
+func (d *MySQLFunc) SaveUser(data map[string]interface{}) (id int, err error) {
+
+	if err = d.encrypt(data, "secret_key"); err != nil {
+		return 0, err
+	}
+	return d.save(d.tableNameUser, data)
+}
+
+// DeleteUser 删除应用下的用户和签发给用户的临时凭证，用户不存在时返回 db.ErrNotFound
+func (d *MySQLFunc) DeleteUser(oak, name string) error {
+
+	if oak == "" || name == "" {
+		return ErrMissParams
+	}
+
+	cond, val, err := builder.BuildDelete(d.tableNameUser, map[string]interface{}{
+		"os_access_key": oak,
+		"user_name":     name,
+	})
+	if err != nil {
+		return err
+	}
+
+	r, err := d.client.Exec(cond, val...)
+	if err != nil {
+		return err
+	}
+
+	n, err := r.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return db.ErrNotFound
+	}
+
+	cond, val, err = builder.BuildDelete(d.tableNameSession, map[string]interface{}{
+		"os_access_key": oak,
+		"user_name":     name,
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = d.client.Exec(cond, val...)
+	return err
+}
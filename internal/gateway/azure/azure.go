@@ -0,0 +1,881 @@
+package azure
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/solution9th/S3Adapter/internal/auth"
+	"github.com/solution9th/S3Adapter/internal/gateway"
+	"github.com/solution9th/S3Adapter/internal/reqinfo"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/dustin/go-humanize"
+	"github.com/haozibi/zlog"
+)
+
+const (
+	// Backend azure backend name
+	Backend = "azure"
+)
+
+const (
+	azureBlockSize            = 4 * humanize.MiByte
+	azureMaxKeys              = 5000
+	s3DefaultMaxKeys          = 1000
+	HTTPHeaderAzureMetaPrefix = "X-Ms-Meta-"
+	HTTPHeaderS3MetaPrefix    = "X-Amz-Meta-"
+)
+
+var (
+	defaultEndpointFormat = "https://%s.blob.core.windows.net"
+
+	// copyPollInterval 异步复制时查询复制状态的间隔
+	copyPollInterval = time.Second
+)
+
+// New new Gateway
+func New() gateway.Gateway { return &azuregw{} }
+
+type azuregw struct{}
+
+func (s *azuregw) Name() string     { return Backend }
+func (s *azuregw) Production() bool { return true }
+
+// Capabilities azure 支持全部方法和特性，存储类型映射为 Access Tier
+func (s *azuregw) Capabilities() gateway.Capabilities { return gateway.FullCapabilities() }
+
+// NewS3Protocol AccessKey 为存储账户名称，SecretKey 为 base64 编码的账户密钥
+//
+// region 为 http(s) 地址时作为服务地址使用，比如 Azurite:
+// http://127.0.0.1:10000/devstoreaccount1
+func (s *azuregw) NewS3Protocol(creds auth.Credentials, region string, isDebug bool) (gateway.S3Protocol, error) {
+
+	key, err := base64.StdEncoding.DecodeString(creds.SecretKey)
+	if err != nil {
+		return nil, fmt.Errorf("azure: account key must be base64: %v", err)
+	}
+
+	endpoint := fmt.Sprintf(defaultEndpointFormat, creds.AccessKey)
+	if strings.HasPrefix(region, "http://") || strings.HasPrefix(region, "https://") {
+		endpoint = region
+	}
+
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, err
+	}
+
+	return &azureProto{
+		client: &sharedKeyClient{
+			account:  creds.AccessKey,
+			key:      key,
+			endpoint: u,
+			client:   &http.Client{Transport: gateway.Transport()},
+		},
+	}, nil
+}
+
+type azureProto struct {
+	gateway.GatewayUnsupported
+	client *sharedKeyClient
+}
+
+// =================
+// Bucket operations
+// =================
+
+func (s *azureProto) CreateBucketWithContext(ctx context.Context, input *s3.CreateBucketInput, opts ...request.Option) (*s3.CreateBucketOutput, *http.Response, error) {
+
+	bucket := aws.StringValue(input.Bucket)
+
+	header := make(http.Header)
+	switch aws.StringValue(input.ACL) {
+	case s3.BucketCannedACLPublicRead:
+		header.Set("x-ms-blob-public-access", "blob")
+	case s3.BucketCannedACLPublicReadWrite:
+		header.Set("x-ms-blob-public-access", "container")
+	}
+
+	u := s.client.blobURL(bucket, "", url.Values{"restype": {"container"}})
+	resp, err := s.client.do(ctx, http.MethodPut, u, header, nil, 0)
+	if err != nil {
+		return nil, setS3Header(nil), toS3ErrNotResponse(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusCreated {
+		reqinfo.ZError(ctx).Str("Method", "CreateBucketWithContext").Str("Bucket", bucket).Int("Status", resp.StatusCode).Msg("[Azure] error")
+		return nil, setS3Header(resp), toS3Err(resp, "NoSuchBucket")
+	}
+
+	return &s3.CreateBucketOutput{
+		Location: aws.String("/" + bucket),
+	}, setS3Header(resp), nil
+}
+
+func (s *azureProto) HeadBucketWithContext(ctx context.Context, input *s3.HeadBucketInput, opts ...request.Option) (*s3.HeadBucketOutput, *http.Response, error) {
+
+	bucket := aws.StringValue(input.Bucket)
+
+	u := s.client.blobURL(bucket, "", url.Values{"restype": {"container"}})
+	resp, err := s.client.do(ctx, http.MethodHead, u, nil, nil, 0)
+	if err != nil {
+		return nil, setS3Header(nil), toS3ErrNotResponse(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		reqinfo.ZError(ctx).Str("Method", "HeadBucketWithContext").Str("Bucket", bucket).Int("Status", resp.StatusCode).Msg("[Azure] error")
+		return nil, setS3Header(resp), toS3Err(resp, "NoSuchBucket")
+	}
+
+	return &s3.HeadBucketOutput{}, setS3Header(resp), nil
+}
+
+func (s *azureProto) DeleteBucketWithContext(ctx context.Context, input *s3.DeleteBucketInput, opts ...request.Option) (*s3.DeleteBucketOutput, *http.Response, error) {
+
+	bucket := aws.StringValue(input.Bucket)
+
+	u := s.client.blobURL(bucket, "", url.Values{"restype": {"container"}})
+	resp, err := s.client.do(ctx, http.MethodDelete, u, nil, nil, 0)
+	if err != nil {
+		return nil, setS3Header(nil), toS3ErrNotResponse(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusAccepted {
+		reqinfo.ZError(ctx).Str("Method", "DeleteBucketWithContext").Str("Bucket", bucket).Int("Status", resp.StatusCode).Msg("[Azure] error")
+		return nil, setS3Header(resp), toS3Err(resp, "NoSuchBucket")
+	}
+
+	return &s3.DeleteBucketOutput{}, setS3Header(resp), nil
+}
+
+// containerEnumerationResults List Containers 的响应
+type containerEnumerationResults struct {
+	XMLName    xml.Name `xml:"EnumerationResults"`
+	Containers []struct {
+		Name       string `xml:"Name"`
+		Properties struct {
+			LastModified string `xml:"Last-Modified"`
+		} `xml:"Properties"`
+	} `xml:"Containers>Container"`
+	NextMarker string `xml:"NextMarker"`
+}
+
+func (s *azureProto) ListBucketsWithContext(ctx context.Context, input *s3.ListBucketsInput, opts ...request.Option) (*s3.ListBucketsOutput, *http.Response, error) {
+
+	output := &s3.ListBucketsOutput{
+		Owner: &s3.Owner{
+			DisplayName: aws.String(s.client.account),
+			ID:          aws.String(s.client.account),
+		},
+		Buckets: make([]*s3.Bucket, 0),
+	}
+
+	var lastResp *http.Response
+	marker := ""
+	for {
+		query := url.Values{"comp": {"list"}}
+		if marker != "" {
+			query.Set("marker", marker)
+		}
+
+		u := s.client.blobURL("", "", query)
+		resp, err := s.client.do(ctx, http.MethodGet, u, nil, nil, 0)
+		if err != nil {
+			return nil, setS3Header(nil), toS3ErrNotResponse(err)
+		}
+
+		if resp.StatusCode != http.StatusOK {
+			reqinfo.ZError(ctx).Str("Method", "ListBucketsWithContext").Int("Status", resp.StatusCode).Msg("[Azure] error")
+			return nil, setS3Header(resp), toS3Err(resp, "NoSuchBucket")
+		}
+
+		var result containerEnumerationResults
+		err = xml.NewDecoder(resp.Body).Decode(&result)
+		resp.Body.Close()
+		if err != nil {
+			return nil, setS3Header(resp), toS3ErrNotResponse(err)
+		}
+
+		for _, c := range result.Containers {
+			lmt, _ := time.Parse(http.TimeFormat, c.Properties.LastModified)
+			output.Buckets = append(output.Buckets, &s3.Bucket{
+				Name:         aws.String(c.Name),
+				CreationDate: aws.Time(lmt),
+			})
+		}
+
+		lastResp = resp
+		marker = result.NextMarker
+		if marker == "" {
+			break
+		}
+	}
+
+	return output, setS3Header(lastResp), nil
+}
+
+// blobEnumerationResults List Blobs 的响应
+type blobEnumerationResults struct {
+	XMLName    xml.Name `xml:"EnumerationResults"`
+	Prefix     string   `xml:"Prefix"`
+	Marker     string   `xml:"Marker"`
+	MaxResults int64    `xml:"MaxResults"`
+	Delimiter  string   `xml:"Delimiter"`
+	Blobs      []struct {
+		Name       string `xml:"Name"`
+		Properties struct {
+			LastModified  string `xml:"Last-Modified"`
+			Etag          string `xml:"Etag"`
+			ContentLength int64  `xml:"Content-Length"`
+			AccessTier    string `xml:"AccessTier"`
+		} `xml:"Properties"`
+	} `xml:"Blobs>Blob"`
+	BlobPrefixes []struct {
+		Name string `xml:"Name"`
+	} `xml:"Blobs>BlobPrefix"`
+	NextMarker string `xml:"NextMarker"`
+}
+
+// listBlobs List Blobs，marker 为 Azure 的续传标记
+func (s *azureProto) listBlobs(ctx context.Context, bucket, prefix, delimiter, marker string, maxKeys int64) (*blobEnumerationResults, *http.Response, error) {
+
+	query := url.Values{
+		"restype": {"container"},
+		"comp":    {"list"},
+	}
+	if prefix != "" {
+		query.Set("prefix", prefix)
+	}
+	if delimiter != "" {
+		query.Set("delimiter", delimiter)
+	}
+	if marker != "" {
+		query.Set("marker", marker)
+	}
+	if maxKeys > 0 {
+		if maxKeys > azureMaxKeys {
+			maxKeys = azureMaxKeys
+		}
+		query.Set("maxresults", strconv.FormatInt(maxKeys, 10))
+	}
+
+	u := s.client.blobURL(bucket, "", query)
+	resp, err := s.client.do(ctx, http.MethodGet, u, nil, nil, 0)
+	if err != nil {
+		return nil, setS3Header(nil), toS3ErrNotResponse(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		reqinfo.ZError(ctx).Str("Method", "listBlobs").Str("Bucket", bucket).Int("Status", resp.StatusCode).Msg("[Azure] error")
+		return nil, setS3Header(resp), toS3Err(resp, "NoSuchBucket")
+	}
+
+	var result blobEnumerationResults
+	if err := xml.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, setS3Header(resp), toS3ErrNotResponse(err)
+	}
+
+	return &result, setS3Header(resp), nil
+}
+
+func (s *azureProto) toS3Objects(result *blobEnumerationResults) ([]*s3.Object, []*s3.CommonPrefix) {
+
+	contents := make([]*s3.Object, 0, len(result.Blobs))
+	for _, b := range result.Blobs {
+		lmt, err := time.Parse(http.TimeFormat, b.Properties.LastModified)
+		if err != nil {
+			zlog.ZError().Msg(err.Error())
+		}
+
+		contents = append(contents, &s3.Object{
+			ETag:         aws.String(b.Properties.Etag),
+			Key:          aws.String(b.Name),
+			LastModified: aws.Time(lmt),
+			Size:         aws.Int64(b.Properties.ContentLength),
+			StorageClass: aws.String(toS3StorageClass(b.Properties.AccessTier)),
+			Owner: &s3.Owner{
+				DisplayName: aws.String(s.client.account),
+				ID:          aws.String(s.client.account),
+			},
+		})
+	}
+
+	prefixes := make([]*s3.CommonPrefix, 0, len(result.BlobPrefixes))
+	for _, p := range result.BlobPrefixes {
+		prefixes = append(prefixes, &s3.CommonPrefix{
+			Prefix: aws.String(p.Name),
+		})
+	}
+
+	return contents, prefixes
+}
+
+// maxKeys 请求的 max-keys，没有时和 S3 一样默认为 1000
+func maxKeys(v *int64) int64 {
+	if n := aws.Int64Value(v); n > 0 {
+		return n
+	}
+	return s3DefaultMaxKeys
+}
+
+func (s *azureProto) ListObjectsWithContext(ctx context.Context, input *s3.ListObjectsInput, opts ...request.Option) (*s3.ListObjectsOutput, *http.Response, error) {
+
+	limit := maxKeys(input.MaxKeys)
+
+	result, resp, err := s.listBlobs(ctx,
+		aws.StringValue(input.Bucket),
+		aws.StringValue(input.Prefix),
+		aws.StringValue(input.Delimiter),
+		aws.StringValue(input.Marker),
+		limit,
+	)
+	if err != nil {
+		return nil, resp, err
+	}
+
+	contents, prefixes := s.toS3Objects(result)
+
+	output := &s3.ListObjectsOutput{
+		Name:           input.Bucket,
+		Prefix:         aws.String(result.Prefix),
+		Marker:         aws.String(result.Marker),
+		NextMarker:     aws.String(result.NextMarker),
+		Delimiter:      aws.String(result.Delimiter),
+		MaxKeys:        aws.Int64(limit),
+		IsTruncated:    aws.Bool(result.NextMarker != ""),
+		EncodingType:   input.EncodingType,
+		Contents:       contents,
+		CommonPrefixes: prefixes,
+	}
+
+	return output, resp, nil
+}
+
+// afterKey 过滤掉 startAfter 及之前的对象和前缀，startAfter 在前缀内时保留前缀，前缀下可能还有更大的 key
+func afterKey(contents []*s3.Object, prefixes []*s3.CommonPrefix, startAfter string) ([]*s3.Object, []*s3.CommonPrefix) {
+
+	objects := contents[:0]
+	for _, o := range contents {
+		if aws.StringValue(o.Key) > startAfter {
+			objects = append(objects, o)
+		}
+	}
+
+	common := prefixes[:0]
+	for _, p := range prefixes {
+		v := aws.StringValue(p.Prefix)
+		if v > startAfter || strings.HasPrefix(startAfter, v) {
+			common = append(common, p)
+		}
+	}
+	return objects, common
+}
+
+// ListObjectsWithContextV2 Azure 不支持 start-after，只能在返回的结果中过滤，
+// 过滤后为空时继续获取下一页，直到有结果或者列完
+func (s *azureProto) ListObjectsWithContextV2(ctx context.Context, input *s3.ListObjectsV2Input, opts ...request.Option) (*s3.ListObjectsV2Output, *http.Response, error) {
+
+	limit := maxKeys(input.MaxKeys)
+	startAfter := aws.StringValue(input.StartAfter)
+	marker := aws.StringValue(input.ContinuationToken)
+
+	var (
+		result   *blobEnumerationResults
+		resp     *http.Response
+		err      error
+		contents []*s3.Object
+		prefixes []*s3.CommonPrefix
+	)
+	for {
+		result, resp, err = s.listBlobs(ctx,
+			aws.StringValue(input.Bucket),
+			aws.StringValue(input.Prefix),
+			aws.StringValue(input.Delimiter),
+			marker,
+			limit,
+		)
+		if err != nil {
+			return nil, resp, err
+		}
+
+		contents, prefixes = s.toS3Objects(result)
+		if startAfter == "" {
+			break
+		}
+
+		contents, prefixes = afterKey(contents, prefixes, startAfter)
+		if len(contents)+len(prefixes) > 0 || result.NextMarker == "" {
+			break
+		}
+		marker = result.NextMarker
+	}
+
+	if !aws.BoolValue(input.FetchOwner) {
+		for _, o := range contents {
+			o.Owner = nil
+		}
+	}
+
+	output := &s3.ListObjectsV2Output{
+		Name:           input.Bucket,
+		Prefix:         aws.String(result.Prefix),
+		Delimiter:      aws.String(result.Delimiter),
+		MaxKeys:        aws.Int64(limit),
+		IsTruncated:    aws.Bool(result.NextMarker != ""),
+		EncodingType:   input.EncodingType,
+		StartAfter:     input.StartAfter,
+		Contents:       contents,
+		CommonPrefixes: prefixes,
+		KeyCount:       aws.Int64(int64(len(contents) + len(prefixes))),
+	}
+
+	if input.ContinuationToken != nil {
+		output.ContinuationToken = input.ContinuationToken
+	}
+	if result.NextMarker != "" {
+		output.NextContinuationToken = aws.String(result.NextMarker)
+	}
+
+	return output, resp, nil
+}
+
+// =================
+// Object operations
+// =================
+
+// s3MetaToAzureHeader S3 元数据转换为 x-ms-meta-*
+//
+// Azure 元数据名称需要满足 C# 标识符规则，不能包含 "-"，所以转换为 "_"
+func s3MetaToAzureHeader(s3Metadata map[string]*string, header http.Header) {
+
+	for k, v := range s3Metadata {
+		k = http.CanonicalHeaderKey(k)
+		k = strings.TrimPrefix(k, HTTPHeaderS3MetaPrefix)
+		k = strings.Replace(k, "-", "_", -1)
+		header.Set(HTTPHeaderAzureMetaPrefix+k, aws.StringValue(v))
+	}
+}
+
+// azureHeaderToS3Meta x-ms-meta-* 转换为 S3 元数据
+func azureHeaderToS3Meta(header http.Header) map[string]*string {
+
+	s3Metadata := make(map[string]*string)
+	for k := range header {
+		k = http.CanonicalHeaderKey(k)
+		if !strings.HasPrefix(k, HTTPHeaderAzureMetaPrefix) {
+			continue
+		}
+		metaKey := k[len(HTTPHeaderAzureMetaPrefix):]
+		metaKey = http.CanonicalHeaderKey(strings.Replace(metaKey, "_", "-", -1))
+		s3Metadata[metaKey] = aws.String(header.Get(k))
+	}
+	return s3Metadata
+}
+
+func toS3StorageClass(tier string) string {
+	switch tier {
+	case "Cool":
+		return s3.StorageClassStandardIa
+	case "Archive":
+		return s3.StorageClassGlacier
+	}
+	return s3.StorageClassStandard
+}
+
+func toAzureAccessTier(storageClass string) string {
+	switch storageClass {
+	case s3.StorageClassStandardIa, s3.StorageClassOnezoneIa:
+		return "Cool"
+	case s3.StorageClassGlacier, s3.StorageClassDeepArchive:
+		return "Archive"
+	case s3.StorageClassStandard:
+		return "Hot"
+	}
+	return ""
+}
+
+func blockID(i int) string {
+	return base64.StdEncoding.EncodeToString([]byte(fmt.Sprintf("%08d", i)))
+}
+
+// putBlock 上传一个块，失败时返回 S3 的错误
+func (s *azureProto) putBlock(ctx context.Context, bucket, object, id string, data []byte) (*http.Response, error) {
+
+	u := s.client.blobURL(bucket, object, url.Values{
+		"comp":    {"block"},
+		"blockid": {id},
+	})
+	resp, err := s.client.do(ctx, http.MethodPut, u, nil, bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return setS3Header(nil), toS3ErrNotResponse(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusCreated {
+		reqinfo.ZError(ctx).Str("method", "PutBlock").Str("bucket", bucket).Str("object", object).Int("Status", resp.StatusCode).Msg("[Azure] error")
+		return setS3Header(resp), toS3Err(resp, "NoSuchBucket")
+	}
+	io.Copy(ioutil.Discard, resp.Body)
+	return setS3Header(resp), nil
+}
+
+// PutObjectWithContext 通过 Put Block 和 Put Block List 上传
+func (s *azureProto) PutObjectWithContext(ctx context.Context, input *s3.PutObjectInput, opts ...request.Option) (*s3.PutObjectOutput, *http.Response, error) {
+
+	bucket := aws.StringValue(input.Bucket)
+	object := aws.StringValue(input.Key)
+
+	var ids []string
+	if input.Body != nil {
+		buf := make([]byte, azureBlockSize)
+		for i := 0; ; i++ {
+			n, rerr := io.ReadFull(input.Body, buf)
+			if n == 0 && rerr != nil {
+				if rerr == io.EOF {
+					break
+				}
+				return nil, setS3Header(nil), toS3ErrNotResponse(rerr)
+			}
+
+			id := blockID(i)
+			if resp, err := s.putBlock(ctx, bucket, object, id, buf[:n]); err != nil {
+				return nil, resp, err
+			}
+			ids = append(ids, id)
+
+			if rerr == io.ErrUnexpectedEOF || rerr == io.EOF {
+				break
+			}
+		}
+	}
+
+	body := &bytes.Buffer{}
+	body.WriteString(xml.Header)
+	body.WriteString("<BlockList>")
+	for _, id := range ids {
+		body.WriteString("<Latest>" + id + "</Latest>")
+	}
+	body.WriteString("</BlockList>")
+
+	header := make(http.Header)
+	s3MetaToAzureHeader(input.Metadata, header)
+
+	if input.ContentType != nil {
+		header.Set("x-ms-blob-content-type", aws.StringValue(input.ContentType))
+	}
+	if input.ContentEncoding != nil {
+		header.Set("x-ms-blob-content-encoding", aws.StringValue(input.ContentEncoding))
+	}
+	if input.ContentLanguage != nil {
+		header.Set("x-ms-blob-content-language", aws.StringValue(input.ContentLanguage))
+	}
+	if input.ContentDisposition != nil {
+		header.Set("x-ms-blob-content-disposition", aws.StringValue(input.ContentDisposition))
+	}
+	if input.CacheControl != nil {
+		header.Set("x-ms-blob-cache-control", aws.StringValue(input.CacheControl))
+	}
+	if tier := toAzureAccessTier(aws.StringValue(input.StorageClass)); tier != "" {
+		header.Set("x-ms-access-tier", tier)
+	}
+
+	u := s.client.blobURL(bucket, object, url.Values{"comp": {"blocklist"}})
+	resp, err := s.client.do(ctx, http.MethodPut, u, header, bytes.NewReader(body.Bytes()), int64(body.Len()))
+	if err != nil {
+		return nil, setS3Header(nil), toS3ErrNotResponse(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusCreated {
+		reqinfo.ZError(ctx).Str("method", "PutBlockList").Str("bucket", bucket).Str("object", object).Int("Status", resp.StatusCode).Msg("[Azure] error")
+		return nil, setS3Header(resp), toS3Err(resp, "NoSuchBucket")
+	}
+
+	return &s3.PutObjectOutput{
+		ETag: awsString(resp.Header.Get("ETag")),
+	}, setS3Header(resp), nil
+}
+
+func setConditionHeader(header http.Header, ifMatch, ifNoneMatch *string, ifModifiedSince, ifUnmodifiedSince *time.Time) {
+
+	if ifMatch != nil {
+		header.Set("If-Match", aws.StringValue(ifMatch))
+	}
+	if ifNoneMatch != nil {
+		header.Set("If-None-Match", aws.StringValue(ifNoneMatch))
+	}
+	if ifModifiedSince != nil {
+		header.Set("If-Modified-Since", ifModifiedSince.UTC().Format(http.TimeFormat))
+	}
+	if ifUnmodifiedSince != nil {
+		header.Set("If-Unmodified-Since", ifUnmodifiedSince.UTC().Format(http.TimeFormat))
+	}
+}
+
+func (s *azureProto) GetObjectWithContext(ctx context.Context, input *s3.GetObjectInput, opts ...request.Option) (*s3.GetObjectOutput, *http.Response, error) {
+
+	bucket := aws.StringValue(input.Bucket)
+	object := aws.StringValue(input.Key)
+
+	header := make(http.Header)
+	if input.Range != nil {
+		header.Set("x-ms-range", aws.StringValue(input.Range))
+	}
+	setConditionHeader(header, input.IfMatch, input.IfNoneMatch, input.IfModifiedSince, input.IfUnmodifiedSince)
+
+	u := s.client.blobURL(bucket, object, nil)
+	resp, err := s.client.do(ctx, http.MethodGet, u, header, nil, 0)
+	if err != nil {
+		return nil, setS3Header(nil), toS3ErrNotResponse(err)
+	}
+
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusPartialContent {
+		reqinfo.ZError(ctx).Str("method", "GetObject").Str("bucket", bucket).Str("object", object).Int("Status", resp.StatusCode).Msg("[Azure] error")
+		return nil, setS3Header(resp), toS3Err(resp, "NoSuchKey")
+	}
+
+	h := resp.Header
+	lastModified, _ := time.Parse(http.TimeFormat, h.Get("Last-Modified"))
+
+	output := &s3.GetObjectOutput{
+		Body:               resp.Body,
+		AcceptRanges:       awsString(h.Get("Accept-Ranges")),
+		ContentLength:      aws.Int64(resp.ContentLength),
+		ContentRange:       awsString(h.Get("Content-Range")),
+		ContentType:        awsString(h.Get("Content-Type")),
+		ContentEncoding:    awsString(h.Get("Content-Encoding")),
+		ContentLanguage:    awsString(h.Get("Content-Language")),
+		ContentDisposition: awsString(h.Get("Content-Disposition")),
+		CacheControl:       awsString(h.Get("Cache-Control")),
+		ETag:               awsString(h.Get("ETag")),
+		LastModified:       aws.Time(lastModified),
+		Metadata:           azureHeaderToS3Meta(h),
+		StorageClass:       awsString(toS3StorageClass(h.Get("x-ms-access-tier"))),
+	}
+
+	// SharedKey 请求不支持 rscc 等参数，在这里覆盖
+	if input.ResponseContentType != nil {
+		output.ContentType = input.ResponseContentType
+	}
+	if input.ResponseContentEncoding != nil {
+		output.ContentEncoding = input.ResponseContentEncoding
+	}
+	if input.ResponseContentLanguage != nil {
+		output.ContentLanguage = input.ResponseContentLanguage
+	}
+	if input.ResponseContentDisposition != nil {
+		output.ContentDisposition = input.ResponseContentDisposition
+	}
+	if input.ResponseCacheControl != nil {
+		output.CacheControl = input.ResponseCacheControl
+	}
+
+	return output, setS3Header(resp), nil
+}
+
+func (s *azureProto) HeadObjectWithContext(ctx context.Context, input *s3.HeadObjectInput, opts ...request.Option) (*s3.HeadObjectOutput, *http.Response, error) {
+
+	bucket := aws.StringValue(input.Bucket)
+	object := aws.StringValue(input.Key)
+
+	header := make(http.Header)
+	setConditionHeader(header, input.IfMatch, input.IfNoneMatch, input.IfModifiedSince, input.IfUnmodifiedSince)
+
+	u := s.client.blobURL(bucket, object, nil)
+	resp, err := s.client.do(ctx, http.MethodHead, u, header, nil, 0)
+	if err != nil {
+		return nil, setS3Header(nil), toS3ErrNotResponse(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		reqinfo.ZError(ctx).Str("method", "HeadObject").Str("bucket", bucket).Str("object", object).Int("Status", resp.StatusCode).Msg("[Azure] error")
+		return nil, setS3Header(resp), toS3Err(resp, "NoSuchKey")
+	}
+
+	h := resp.Header
+	lastModified, _ := time.Parse(http.TimeFormat, h.Get("Last-Modified"))
+	size, _ := strconv.ParseInt(h.Get("Content-Length"), 10, 64)
+
+	return &s3.HeadObjectOutput{
+		AcceptRanges:       awsString(h.Get("Accept-Ranges")),
+		ContentLength:      aws.Int64(size),
+		ContentType:        awsString(h.Get("Content-Type")),
+		ContentEncoding:    awsString(h.Get("Content-Encoding")),
+		ContentLanguage:    awsString(h.Get("Content-Language")),
+		ContentDisposition: awsString(h.Get("Content-Disposition")),
+		CacheControl:       awsString(h.Get("Cache-Control")),
+		ETag:               awsString(h.Get("ETag")),
+		LastModified:       aws.Time(lastModified),
+		Metadata:           azureHeaderToS3Meta(h),
+		StorageClass:       awsString(toS3StorageClass(h.Get("x-ms-access-tier"))),
+	}, setS3Header(resp), nil
+}
+
+func (s *azureProto) DeleteObjectWithContext(ctx context.Context, input *s3.DeleteObjectInput, opts ...request.Option) (*s3.DeleteObjectOutput, *http.Response, error) {
+
+	bucket := aws.StringValue(input.Bucket)
+	object := aws.StringValue(input.Key)
+
+	u := s.client.blobURL(bucket, object, nil)
+	resp, err := s.client.do(ctx, http.MethodDelete, u, nil, nil, 0)
+	if err != nil {
+		return nil, setS3Header(nil), toS3ErrNotResponse(err)
+	}
+	defer resp.Body.Close()
+
+	// S3 删除不存在的对象也返回成功
+	if resp.StatusCode != http.StatusAccepted && resp.StatusCode != http.StatusNotFound {
+		reqinfo.ZError(ctx).Str("method", "DeleteObject").Str("bucket", bucket).Str("object", object).Int("Status", resp.StatusCode).Msg("[Azure] error")
+		return nil, setS3Header(resp), toS3Err(resp, "NoSuchKey")
+	}
+
+	return &s3.DeleteObjectOutput{}, setS3Header(resp), nil
+}
+
+func (s *azureProto) CopyObjectWithContext(ctx context.Context, input *s3.CopyObjectInput, opts ...request.Option) (*s3.CopyObjectOutput, *http.Response, error) {
+
+	bucket := aws.StringValue(input.Bucket)
+	object := aws.StringValue(input.Key)
+
+	srcBucket, srcObject, ok := gateway.ParseCopySource(aws.StringValue(input.CopySource))
+	if !ok {
+		return nil, setS3Header(nil), toS3Err(&http.Response{
+			StatusCode: http.StatusBadRequest,
+			Header:     http.Header{"X-Ms-Error-Code": {"InvalidHeaderValue"}},
+		}, "NoSuchKey")
+	}
+
+	header := make(http.Header)
+	header.Set("x-ms-copy-source", s.client.blobURL(srcBucket, srcObject, nil).String())
+
+	// 不指定元数据时 Azure 会复制源对象的元数据
+	if aws.StringValue(input.MetadataDirective) == s3.MetadataDirectiveReplace {
+		s3MetaToAzureHeader(input.Metadata, header)
+	}
+	if tier := toAzureAccessTier(aws.StringValue(input.StorageClass)); tier != "" {
+		header.Set("x-ms-access-tier", tier)
+	}
+
+	if input.CopySourceIfMatch != nil {
+		header.Set("x-ms-source-if-match", aws.StringValue(input.CopySourceIfMatch))
+	}
+	if input.CopySourceIfNoneMatch != nil {
+		header.Set("x-ms-source-if-none-match", aws.StringValue(input.CopySourceIfNoneMatch))
+	}
+	if input.CopySourceIfModifiedSince != nil {
+		header.Set("x-ms-source-if-modified-since", input.CopySourceIfModifiedSince.UTC().Format(http.TimeFormat))
+	}
+	if input.CopySourceIfUnmodifiedSince != nil {
+		header.Set("x-ms-source-if-unmodified-since", input.CopySourceIfUnmodifiedSince.UTC().Format(http.TimeFormat))
+	}
+
+	u := s.client.blobURL(bucket, object, nil)
+	resp, err := s.client.do(ctx, http.MethodPut, u, header, nil, 0)
+	if err != nil {
+		return nil, setS3Header(nil), toS3ErrNotResponse(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusAccepted {
+		reqinfo.ZError(ctx).Str("method", "CopyObject").Str("bucket", bucket).Str("object", object).Int("Status", resp.StatusCode).Msg("[Azure] error")
+		return nil, setS3Header(resp), toS3Err(resp, "NoSuchKey")
+	}
+
+	// 跨账户或者大对象的复制是异步的，S3 的复制是同步的，等待复制完成
+	h := resp.Header
+	if h.Get("x-ms-copy-status") != "success" {
+		h, err = s.waitCopy(ctx, bucket, object, h.Get("x-ms-copy-id"))
+		if err != nil {
+			reqinfo.ZError(ctx).Str("method", "CopyObject").Str("bucket", bucket).Str("object", object).Msg("[Azure] copy error:" + err.Error())
+			return nil, setS3Header(resp), err
+		}
+	}
+
+	lastModified, _ := time.Parse(http.TimeFormat, h.Get("Last-Modified"))
+
+	return &s3.CopyObjectOutput{
+		CopyObjectResult: &s3.CopyObjectResult{
+			ETag:         awsString(h.Get("ETag")),
+			LastModified: aws.Time(lastModified),
+		},
+	}, setS3Header(resp), nil
+}
+
+// waitCopy 轮询目标对象的复制状态，直到复制成功或者失败，成功时返回目标对象的属性
+func (s *azureProto) waitCopy(ctx context.Context, bucket, object, copyID string) (http.Header, error) {
+
+	ticker := time.NewTicker(copyPollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil, toS3ErrNotResponse(ctx.Err())
+		case <-ticker.C:
+		}
+
+		h, err := s.copyStatus(ctx, bucket, object)
+		if err != nil {
+			return nil, err
+		}
+
+		requestID := h.Get("x-ms-request-id")
+		if h.Get("x-ms-copy-id") != copyID {
+			return nil, awserr.NewRequestFailure(awserr.New("OperationAborted", "The object was modified while it was being copied", nil), http.StatusConflict, requestID)
+		}
+
+		switch status := h.Get("x-ms-copy-status"); status {
+		case "success":
+			return h, nil
+		case "pending":
+		default:
+			// failed 或者 aborted
+			message := "Copy " + status
+			if desc := h.Get("x-ms-copy-status-description"); desc != "" {
+				message += ": " + desc
+			}
+			return nil, awserr.NewRequestFailure(awserr.New("InternalError", message, nil), http.StatusInternalServerError, requestID)
+		}
+	}
+}
+
+// copyStatus 读取目标对象的属性，其中包含复制状态
+func (s *azureProto) copyStatus(ctx context.Context, bucket, object string) (http.Header, error) {
+
+	u := s.client.blobURL(bucket, object, nil)
+	resp, err := s.client.do(ctx, http.MethodHead, u, nil, nil, 0)
+	if err != nil {
+		return nil, toS3ErrNotResponse(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, toS3Err(resp, "NoSuchKey")
+	}
+	return resp.Header, nil
+}
+
+func awsString(v string) *string {
+	if v != "" {
+		return &v
+	}
+	return nil
+}
@@ -0,0 +1,589 @@
+package azure
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/solution9th/S3Adapter/internal/auth"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/smartystreets/goconvey/convey"
+)
+
+const (
+	testAccount = "devstoreaccount1"
+)
+
+var testKey = base64.StdEncoding.EncodeToString([]byte("azurite-test-key"))
+
+type fakeBlob struct {
+	data     []byte
+	header   http.Header
+	modified time.Time
+
+	// polls 复制完成前还会返回 pending 的读取次数
+	polls int
+}
+
+// fakeAzure Azurite 风格的 Blob 服务，路径为 /account/container/blob
+type fakeAzure struct {
+	mu         sync.Mutex
+	client     *sharedKeyClient
+	containers map[string]map[string]*fakeBlob
+	blocks     map[string][]byte
+
+	// copyPolls 大于 0 时复制是异步的，读取 copyPolls 次后复制状态变为 copyResult
+	copyPolls  int
+	copyResult string
+	copies     int
+}
+
+func newFakeAzure() *fakeAzure {
+	key, _ := base64.StdEncoding.DecodeString(testKey)
+	return &fakeAzure{
+		client:     &sharedKeyClient{account: testAccount, key: key},
+		containers: make(map[string]map[string]*fakeBlob),
+		blocks:     make(map[string][]byte),
+	}
+}
+
+func (f *fakeAzure) writeError(w http.ResponseWriter, status int, code string) {
+	w.Header().Set("x-ms-request-id", "fake-request-id")
+	w.Header().Set("x-ms-error-code", code)
+	w.WriteHeader(status)
+	fmt.Fprintf(w, "<?xml version=\"1.0\" encoding=\"utf-8\"?><Error><Code>%s</Code><Message>%s</Message></Error>", code, code)
+}
+
+func (f *fakeAzure) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	want := "SharedKey " + testAccount + ":" + f.client.signature(r)
+	if r.Header.Get("Authorization") != want {
+		f.writeError(w, http.StatusForbidden, "AuthenticationFailed")
+		return
+	}
+
+	p := strings.TrimPrefix(r.URL.Path, "/"+testAccount)
+	p = strings.TrimPrefix(p, "/")
+	container, blob := p, ""
+	if i := strings.Index(p, "/"); i >= 0 {
+		container, blob = p[:i], p[i+1:]
+	}
+	q := r.URL.Query()
+
+	w.Header().Set("x-ms-request-id", "fake-request-id")
+
+	if container == "" {
+		f.listContainers(w)
+		return
+	}
+
+	if blob == "" {
+		f.serveContainer(w, r, container, q)
+		return
+	}
+
+	blobs, ok := f.containers[container]
+	if !ok {
+		f.writeError(w, http.StatusNotFound, "ContainerNotFound")
+		return
+	}
+
+	switch {
+	case r.Method == http.MethodPut && q.Get("comp") == "block":
+		body, _ := ioutil.ReadAll(r.Body)
+		f.blocks[container+"/"+blob+"/"+q.Get("blockid")] = body
+		w.WriteHeader(http.StatusCreated)
+	case r.Method == http.MethodPut && q.Get("comp") == "blocklist":
+		var list struct {
+			Latest []string `xml:"Latest"`
+		}
+		body, _ := ioutil.ReadAll(r.Body)
+		if err := xml.Unmarshal(body, &list); err != nil {
+			f.writeError(w, http.StatusBadRequest, "InvalidXmlDocument")
+			return
+		}
+		data := &bytes.Buffer{}
+		for _, id := range list.Latest {
+			b, ok := f.blocks[container+"/"+blob+"/"+id]
+			if !ok {
+				f.writeError(w, http.StatusBadRequest, "InvalidBlockList")
+				return
+			}
+			data.Write(b)
+		}
+		header := make(http.Header)
+		for k, v := range r.Header {
+			if strings.HasPrefix(k, "X-Ms-Meta-") {
+				header[k] = v
+			}
+		}
+		header.Set("Content-Type", r.Header.Get("x-ms-blob-content-type"))
+		blobs[blob] = &fakeBlob{data: data.Bytes(), header: header, modified: time.Now()}
+		w.Header().Set("ETag", fmt.Sprintf("\"0x%X\"", data.Len()))
+		w.WriteHeader(http.StatusCreated)
+	case r.Method == http.MethodPut && r.Header.Get("x-ms-copy-source") != "":
+		u, _ := url.Parse(r.Header.Get("x-ms-copy-source"))
+		sp := strings.TrimPrefix(u.Path, "/"+testAccount+"/")
+		i := strings.Index(sp, "/")
+		src, ok := f.containers[sp[:i]][sp[i+1:]]
+		if !ok {
+			f.writeError(w, http.StatusNotFound, "BlobNotFound")
+			return
+		}
+		f.copies++
+		cp := &fakeBlob{data: src.data, header: make(http.Header), modified: time.Now(), polls: f.copyPolls}
+		for k, v := range src.header {
+			cp.header[k] = v
+		}
+		cp.header.Set("ETag", "\"copied\"")
+		cp.header.Set("x-ms-copy-id", fmt.Sprintf("copy-%d", f.copies))
+		cp.header.Set("x-ms-copy-status", "success")
+		if cp.polls > 0 {
+			cp.header.Set("x-ms-copy-status", "pending")
+		}
+		blobs[blob] = cp
+		w.Header().Set("ETag", "\"copied\"")
+		w.Header().Set("Last-Modified", cp.modified.UTC().Format(http.TimeFormat))
+		w.Header().Set("x-ms-copy-id", cp.header.Get("x-ms-copy-id"))
+		w.Header().Set("x-ms-copy-status", cp.header.Get("x-ms-copy-status"))
+		w.WriteHeader(http.StatusAccepted)
+	case r.Method == http.MethodGet || r.Method == http.MethodHead:
+		b, ok := blobs[blob]
+		if !ok {
+			if r.Method == http.MethodHead {
+				w.Header().Set("x-ms-error-code", "BlobNotFound")
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			f.writeError(w, http.StatusNotFound, "BlobNotFound")
+			return
+		}
+		if b.polls > 0 {
+			if b.polls--; b.polls == 0 {
+				b.header.Set("x-ms-copy-status", f.copyResult)
+			}
+		}
+		for k, v := range b.header {
+			w.Header()[k] = v
+		}
+		w.Header().Set("Last-Modified", b.modified.UTC().Format(http.TimeFormat))
+		data := b.data
+		status := http.StatusOK
+		if rg := r.Header.Get("x-ms-range"); rg != "" {
+			var start, end int
+			fmt.Sscanf(rg, "bytes=%d-%d", &start, &end)
+			data = data[start : end+1]
+			w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, len(b.data)))
+			status = http.StatusPartialContent
+		}
+		w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+		w.WriteHeader(status)
+		if r.Method == http.MethodGet {
+			w.Write(data)
+		}
+	case r.Method == http.MethodDelete:
+		if _, ok := blobs[blob]; !ok {
+			f.writeError(w, http.StatusNotFound, "BlobNotFound")
+			return
+		}
+		delete(blobs, blob)
+		w.WriteHeader(http.StatusAccepted)
+	default:
+		f.writeError(w, http.StatusBadRequest, "UnsupportedHttpVerb")
+	}
+}
+
+func (f *fakeAzure) listContainers(w http.ResponseWriter) {
+	names := make([]string, 0, len(f.containers))
+	for k := range f.containers {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+
+	b := &strings.Builder{}
+	b.WriteString("<EnumerationResults><Containers>")
+	for _, n := range names {
+		b.WriteString("<Container><Name>" + n + "</Name><Properties><Last-Modified>" + time.Now().UTC().Format(http.TimeFormat) + "</Last-Modified></Properties></Container>")
+	}
+	b.WriteString("</Containers><NextMarker/></EnumerationResults>")
+	w.Write([]byte(b.String()))
+}
+
+func (f *fakeAzure) serveContainer(w http.ResponseWriter, r *http.Request, container string, q url.Values) {
+
+	blobs, ok := f.containers[container]
+
+	switch r.Method {
+	case http.MethodPut:
+		if ok {
+			f.writeError(w, http.StatusConflict, "ContainerAlreadyExists")
+			return
+		}
+		f.containers[container] = make(map[string]*fakeBlob)
+		w.WriteHeader(http.StatusCreated)
+		return
+	case http.MethodDelete:
+		if !ok {
+			f.writeError(w, http.StatusNotFound, "ContainerNotFound")
+			return
+		}
+		delete(f.containers, container)
+		w.WriteHeader(http.StatusAccepted)
+		return
+	case http.MethodHead:
+		if !ok {
+			w.Header().Set("x-ms-error-code", "ContainerNotFound")
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	if !ok {
+		f.writeError(w, http.StatusNotFound, "ContainerNotFound")
+		return
+	}
+
+	prefix, delimiter, marker := q.Get("prefix"), q.Get("delimiter"), q.Get("marker")
+	maxResults, _ := strconv.Atoi(q.Get("maxresults"))
+
+	names := make([]string, 0, len(blobs))
+	for k := range blobs {
+		if strings.HasPrefix(k, prefix) && k > marker {
+			names = append(names, k)
+		}
+	}
+	sort.Strings(names)
+
+	b := &strings.Builder{}
+	b.WriteString("<EnumerationResults><Prefix>" + prefix + "</Prefix><Marker>" + marker + "</Marker><MaxResults>" + strconv.Itoa(maxResults) + "</MaxResults><Delimiter>" + delimiter + "</Delimiter><Blobs>")
+	seen := make(map[string]bool)
+	next := ""
+	count := 0
+	for _, n := range names {
+		if maxResults > 0 && count == maxResults {
+			next = names[count-1]
+			break
+		}
+		rest := n[len(prefix):]
+		if delimiter != "" {
+			if i := strings.Index(rest, delimiter); i >= 0 {
+				cp := prefix + rest[:i+len(delimiter)]
+				if !seen[cp] {
+					seen[cp] = true
+					b.WriteString("<BlobPrefix><Name>" + cp + "</Name></BlobPrefix>")
+					count++
+				}
+				continue
+			}
+		}
+		b.WriteString("<Blob><Name>" + n + "</Name><Properties><Last-Modified>" + blobs[n].modified.UTC().Format(http.TimeFormat) + "</Last-Modified><Etag>etag</Etag><Content-Length>" + strconv.Itoa(len(blobs[n].data)) + "</Content-Length></Properties></Blob>")
+		count++
+	}
+	b.WriteString("</Blobs><NextMarker>" + next + "</NextMarker></EnumerationResults>")
+	w.Write([]byte(b.String()))
+}
+
+func newTestProto(t *testing.T) (*azureProto, *httptest.Server) {
+	server := httptest.NewServer(newFakeAzure())
+
+	p, err := New().NewS3Protocol(auth.Credentials{
+		AccessKey: testAccount,
+		SecretKey: testKey,
+	}, server.URL+"/"+testAccount, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return p.(*azureProto), server
+}
+
+func TestAzureBucket(t *testing.T) {
+
+	convey.Convey("Azure bucket", t, func() {
+
+		p, server := newTestProto(t)
+		defer server.Close()
+
+		ctx := context.Background()
+
+		_, _, err := p.CreateBucketWithContext(ctx, &s3.CreateBucketInput{Bucket: aws.String("bk1")})
+		convey.So(err, convey.ShouldBeNil)
+
+		convey.Convey("create exists bucket", func() {
+			_, _, err := p.CreateBucketWithContext(ctx, &s3.CreateBucketInput{Bucket: aws.String("bk1")})
+			convey.So(err.(awserr.RequestFailure).Code(), convey.ShouldEqual, "BucketAlreadyOwnedByYou")
+		})
+
+		convey.Convey("head bucket", func() {
+			_, resp, err := p.HeadBucketWithContext(ctx, &s3.HeadBucketInput{Bucket: aws.String("bk1")})
+			convey.So(err, convey.ShouldBeNil)
+			convey.So(resp.Header.Get(responseRequestIDKey), convey.ShouldEqual, "fake-request-id")
+
+			_, _, err = p.HeadBucketWithContext(ctx, &s3.HeadBucketInput{Bucket: aws.String("none")})
+			convey.So(err.(awserr.RequestFailure).StatusCode(), convey.ShouldEqual, http.StatusNotFound)
+			convey.So(err.(awserr.RequestFailure).Code(), convey.ShouldEqual, "NoSuchBucket")
+		})
+
+		convey.Convey("list buckets", func() {
+			output, _, err := p.ListBucketsWithContext(ctx, &s3.ListBucketsInput{})
+			convey.So(err, convey.ShouldBeNil)
+			convey.So(len(output.Buckets), convey.ShouldEqual, 1)
+			convey.So(aws.StringValue(output.Buckets[0].Name), convey.ShouldEqual, "bk1")
+		})
+
+		convey.Convey("delete bucket", func() {
+			_, _, err := p.DeleteBucketWithContext(ctx, &s3.DeleteBucketInput{Bucket: aws.String("bk1")})
+			convey.So(err, convey.ShouldBeNil)
+
+			_, _, err = p.DeleteBucketWithContext(ctx, &s3.DeleteBucketInput{Bucket: aws.String("bk1")})
+			convey.So(err.(awserr.RequestFailure).Code(), convey.ShouldEqual, "NoSuchBucket")
+		})
+
+		convey.Convey("bad key", func() {
+			p.client.key = []byte("wrong")
+			_, _, err := p.HeadBucketWithContext(ctx, &s3.HeadBucketInput{Bucket: aws.String("bk1")})
+			convey.So(err.(awserr.RequestFailure).StatusCode(), convey.ShouldEqual, http.StatusForbidden)
+		})
+	})
+}
+
+func TestAzureObject(t *testing.T) {
+
+	convey.Convey("Azure object", t, func() {
+
+		p, server := newTestProto(t)
+		defer server.Close()
+
+		ctx := context.Background()
+
+		_, _, err := p.CreateBucketWithContext(ctx, &s3.CreateBucketInput{Bucket: aws.String("bk")})
+		convey.So(err, convey.ShouldBeNil)
+
+		// 超过一个 block 的大小
+		data := bytes.Repeat([]byte("0123456789"), azureBlockSize/10+7)
+
+		_, _, err = p.PutObjectWithContext(ctx, &s3.PutObjectInput{
+			Bucket:      aws.String("bk"),
+			Key:         aws.String("dir/a.txt"),
+			Body:        bytes.NewReader(data),
+			ContentType: aws.String("text/plain"),
+			Metadata:    map[string]*string{"Foo-Bar": aws.String("baz")},
+		})
+		convey.So(err, convey.ShouldBeNil)
+
+		convey.Convey("get object", func() {
+			output, _, err := p.GetObjectWithContext(ctx, &s3.GetObjectInput{
+				Bucket: aws.String("bk"),
+				Key:    aws.String("dir/a.txt"),
+			})
+			convey.So(err, convey.ShouldBeNil)
+			body, _ := ioutil.ReadAll(output.Body)
+			convey.So(bytes.Equal(body, data), convey.ShouldBeTrue)
+			convey.So(aws.StringValue(output.ContentType), convey.ShouldEqual, "text/plain")
+			convey.So(aws.StringValue(output.Metadata["Foo-Bar"]), convey.ShouldEqual, "baz")
+		})
+
+		convey.Convey("put object into missing container", func() {
+			_, _, err := p.PutObjectWithContext(ctx, &s3.PutObjectInput{
+				Bucket: aws.String("none"),
+				Key:    aws.String("a.txt"),
+				Body:   bytes.NewReader([]byte("a")),
+			})
+			convey.So(err.(awserr.Error).Code(), convey.ShouldEqual, "NoSuchBucket")
+		})
+
+		convey.Convey("get object range", func() {
+			output, _, err := p.GetObjectWithContext(ctx, &s3.GetObjectInput{
+				Bucket: aws.String("bk"),
+				Key:    aws.String("dir/a.txt"),
+				Range:  aws.String("bytes=2-5"),
+			})
+			convey.So(err, convey.ShouldBeNil)
+			body, _ := ioutil.ReadAll(output.Body)
+			convey.So(string(body), convey.ShouldEqual, "2345")
+			convey.So(aws.StringValue(output.ContentRange), convey.ShouldEqual, fmt.Sprintf("bytes 2-5/%d", len(data)))
+		})
+
+		convey.Convey("head object", func() {
+			output, _, err := p.HeadObjectWithContext(ctx, &s3.HeadObjectInput{
+				Bucket: aws.String("bk"),
+				Key:    aws.String("dir/a.txt"),
+			})
+			convey.So(err, convey.ShouldBeNil)
+			convey.So(aws.Int64Value(output.ContentLength), convey.ShouldEqual, len(data))
+
+			_, _, err = p.HeadObjectWithContext(ctx, &s3.HeadObjectInput{
+				Bucket: aws.String("bk"),
+				Key:    aws.String("none"),
+			})
+			convey.So(err.(awserr.RequestFailure).Code(), convey.ShouldEqual, "NoSuchKey")
+		})
+
+		convey.Convey("list objects", func() {
+			for _, k := range []string{"b.txt", "dir/c.txt", "e/f.txt"} {
+				_, _, err := p.PutObjectWithContext(ctx, &s3.PutObjectInput{
+					Bucket: aws.String("bk"),
+					Key:    aws.String(k),
+					Body:   bytes.NewReader([]byte(k)),
+				})
+				convey.So(err, convey.ShouldBeNil)
+			}
+
+			output, _, err := p.ListObjectsWithContext(ctx, &s3.ListObjectsInput{
+				Bucket:    aws.String("bk"),
+				Delimiter: aws.String("/"),
+			})
+			convey.So(err, convey.ShouldBeNil)
+			convey.So(len(output.Contents), convey.ShouldEqual, 1)
+			convey.So(len(output.CommonPrefixes), convey.ShouldEqual, 2)
+
+			output, _, err = p.ListObjectsWithContext(ctx, &s3.ListObjectsInput{
+				Bucket: aws.String("bk"),
+				Prefix: aws.String("dir/"),
+			})
+			convey.So(err, convey.ShouldBeNil)
+			convey.So(len(output.Contents), convey.ShouldEqual, 2)
+
+			output2, _, err := p.ListObjectsWithContextV2(ctx, &s3.ListObjectsV2Input{
+				Bucket:  aws.String("bk"),
+				MaxKeys: aws.Int64(2),
+			})
+			convey.So(err, convey.ShouldBeNil)
+			convey.So(aws.BoolValue(output2.IsTruncated), convey.ShouldBeTrue)
+			convey.So(aws.Int64Value(output2.KeyCount), convey.ShouldEqual, 2)
+
+			output2, _, err = p.ListObjectsWithContextV2(ctx, &s3.ListObjectsV2Input{
+				Bucket:            aws.String("bk"),
+				ContinuationToken: output2.NextContinuationToken,
+			})
+			convey.So(err, convey.ShouldBeNil)
+			convey.So(aws.BoolValue(output2.IsTruncated), convey.ShouldBeFalse)
+			convey.So(aws.Int64Value(output2.KeyCount), convey.ShouldEqual, 2)
+			convey.So(aws.Int64Value(output2.MaxKeys), convey.ShouldEqual, 1000)
+
+			// start-after 过滤后为空的页继续获取
+			output2, _, err = p.ListObjectsWithContextV2(ctx, &s3.ListObjectsV2Input{
+				Bucket:     aws.String("bk"),
+				MaxKeys:    aws.Int64(1),
+				StartAfter: aws.String("dir/c.txt"),
+			})
+			convey.So(err, convey.ShouldBeNil)
+			convey.So(len(output2.Contents), convey.ShouldEqual, 1)
+			convey.So(aws.StringValue(output2.Contents[0].Key), convey.ShouldEqual, "e/f.txt")
+			convey.So(aws.Int64Value(output2.KeyCount), convey.ShouldEqual, 1)
+			convey.So(aws.Int64Value(output2.MaxKeys), convey.ShouldEqual, 1)
+			convey.So(aws.BoolValue(output2.IsTruncated), convey.ShouldBeFalse)
+
+			cases := []struct {
+				startAfter string
+				contents   int
+				prefixes   []string
+			}{
+				{"d", 0, []string{"dir/", "e/"}},
+				{"dir/", 0, []string{"dir/", "e/"}},
+				{"dir/a.txt", 0, []string{"dir/", "e/"}},
+				{"e/", 0, []string{"e/"}},
+				// 不知道前缀下还有没有更大的 key，保留前缀
+				{"e/f.txt", 0, []string{"e/"}},
+				{"f", 0, nil},
+				{"a", 1, []string{"dir/", "e/"}},
+			}
+			for _, c := range cases {
+				output2, _, err = p.ListObjectsWithContextV2(ctx, &s3.ListObjectsV2Input{
+					Bucket:     aws.String("bk"),
+					Delimiter:  aws.String("/"),
+					StartAfter: aws.String(c.startAfter),
+				})
+				convey.So(err, convey.ShouldBeNil)
+				convey.So(len(output2.Contents), convey.ShouldEqual, c.contents)
+
+				var prefixes []string
+				for _, p := range output2.CommonPrefixes {
+					prefixes = append(prefixes, aws.StringValue(p.Prefix))
+				}
+				convey.So(prefixes, convey.ShouldResemble, c.prefixes)
+				convey.So(aws.Int64Value(output2.KeyCount), convey.ShouldEqual, c.contents+len(c.prefixes))
+				convey.So(aws.BoolValue(output2.IsTruncated), convey.ShouldBeFalse)
+			}
+		})
+
+		convey.Convey("copy object", func() {
+			output, _, err := p.CopyObjectWithContext(ctx, &s3.CopyObjectInput{
+				Bucket:     aws.String("bk"),
+				Key:        aws.String("copy.txt"),
+				CopySource: aws.String("/bk/dir%2Fa.txt"),
+			})
+			convey.So(err, convey.ShouldBeNil)
+			convey.So(aws.StringValue(output.CopyObjectResult.ETag), convey.ShouldEqual, "\"copied\"")
+
+			_, _, err = p.CopyObjectWithContext(ctx, &s3.CopyObjectInput{
+				Bucket:     aws.String("bk"),
+				Key:        aws.String("copy.txt"),
+				CopySource: aws.String("bk"),
+			})
+			convey.So(err.(awserr.RequestFailure).StatusCode(), convey.ShouldEqual, http.StatusBadRequest)
+		})
+
+		convey.Convey("copy object asynchronously", func() {
+			interval := copyPollInterval
+			copyPollInterval = time.Millisecond
+			defer func() { copyPollInterval = interval }()
+
+			fake := server.Config.Handler.(*fakeAzure)
+			setCopy := func(result string) {
+				fake.mu.Lock()
+				fake.copyPolls, fake.copyResult = 2, result
+				fake.mu.Unlock()
+			}
+
+			input := &s3.CopyObjectInput{
+				Bucket:     aws.String("bk"),
+				Key:        aws.String("copy.txt"),
+				CopySource: aws.String("/bk/dir%2Fa.txt"),
+			}
+
+			setCopy("success")
+			output, _, err := p.CopyObjectWithContext(ctx, input)
+			convey.So(err, convey.ShouldBeNil)
+			convey.So(aws.StringValue(output.CopyObjectResult.ETag), convey.ShouldEqual, "\"copied\"")
+
+			setCopy("failed")
+			_, _, err = p.CopyObjectWithContext(ctx, input)
+			convey.So(err.(awserr.RequestFailure).StatusCode(), convey.ShouldEqual, http.StatusInternalServerError)
+		})
+
+		convey.Convey("delete object", func() {
+			_, _, err := p.DeleteObjectWithContext(ctx, &s3.DeleteObjectInput{
+				Bucket: aws.String("bk"),
+				Key:    aws.String("dir/a.txt"),
+			})
+			convey.So(err, convey.ShouldBeNil)
+
+			_, _, err = p.GetObjectWithContext(ctx, &s3.GetObjectInput{
+				Bucket: aws.String("bk"),
+				Key:    aws.String("dir/a.txt"),
+			})
+			convey.So(err.(awserr.RequestFailure).Code(), convey.ShouldEqual, "NoSuchKey")
+		})
+	})
+}
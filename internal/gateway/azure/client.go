@@ -0,0 +1,146 @@
+package azure
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// azureAPIVersion x-ms-version 请求头
+	azureAPIVersion = "2019-02-02"
+
+	headerMSPrefix = "x-ms-"
+)
+
+// sharedKeyClient 使用 SharedKey 签名访问 Blob 服务
+// https://docs.microsoft.com/en-us/rest/api/storageservices/authorize-with-shared-key
+type sharedKeyClient struct {
+	account  string
+	key      []byte
+	endpoint *url.URL
+	client   *http.Client
+}
+
+// blobURL 生成 container 或 blob 的访问地址，container 与 blob 都为空时为服务地址
+func (c *sharedKeyClient) blobURL(container, blob string, query url.Values) *url.URL {
+
+	u := *c.endpoint
+	p := strings.TrimSuffix(u.Path, "/") + "/"
+	if container != "" {
+		p += container
+		if blob != "" {
+			p += "/" + blob
+		}
+	}
+	u.Path = p
+	u.RawPath = ""
+	u.RawQuery = query.Encode()
+	return &u
+}
+
+// do 签名并发送请求
+func (c *sharedKeyClient) do(ctx context.Context, method string, u *url.URL, header http.Header, body io.Reader, length int64) (*http.Response, error) {
+
+	req, err := http.NewRequest(method, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+
+	for k, v := range header {
+		req.Header[k] = v
+	}
+
+	if body != nil {
+		req.ContentLength = length
+	}
+
+	req.Header.Set("x-ms-date", time.Now().UTC().Format(http.TimeFormat))
+	req.Header.Set("x-ms-version", azureAPIVersion)
+	req.Header.Set("Authorization", "SharedKey "+c.account+":"+c.signature(req))
+
+	return c.client.Do(req)
+}
+
+func (c *sharedKeyClient) signature(req *http.Request) string {
+
+	h := hmac.New(sha256.New, c.key)
+	h.Write([]byte(c.stringToSign(req)))
+	return base64.StdEncoding.EncodeToString(h.Sum(nil))
+}
+
+func (c *sharedKeyClient) stringToSign(req *http.Request) string {
+
+	contentLength := ""
+	if req.ContentLength > 0 {
+		contentLength = strconv.FormatInt(req.ContentLength, 10)
+	}
+
+	b := &strings.Builder{}
+	b.WriteString(req.Method + "\n")
+	b.WriteString(req.Header.Get("Content-Encoding") + "\n")
+	b.WriteString(req.Header.Get("Content-Language") + "\n")
+	b.WriteString(contentLength + "\n")
+	b.WriteString(req.Header.Get("Content-MD5") + "\n")
+	b.WriteString(req.Header.Get("Content-Type") + "\n")
+	// 已经使用 x-ms-date，Date 留空
+	b.WriteString("\n")
+	b.WriteString(req.Header.Get("If-Modified-Since") + "\n")
+	b.WriteString(req.Header.Get("If-Match") + "\n")
+	b.WriteString(req.Header.Get("If-None-Match") + "\n")
+	b.WriteString(req.Header.Get("If-Unmodified-Since") + "\n")
+	b.WriteString(req.Header.Get("Range") + "\n")
+	b.WriteString(canonicalizedHeaders(req.Header))
+	b.WriteString(c.canonicalizedResource(req.URL))
+	return b.String()
+}
+
+func canonicalizedHeaders(header http.Header) string {
+
+	keys := make([]string, 0, len(header))
+	values := make(map[string]string, len(header))
+	for k, v := range header {
+		lk := strings.ToLower(strings.TrimSpace(k))
+		if !strings.HasPrefix(lk, headerMSPrefix) {
+			continue
+		}
+		keys = append(keys, lk)
+		values[lk] = strings.TrimSpace(strings.Join(v, ","))
+	}
+	sort.Strings(keys)
+
+	b := &strings.Builder{}
+	for _, k := range keys {
+		b.WriteString(k + ":" + values[k] + "\n")
+	}
+	return b.String()
+}
+
+func (c *sharedKeyClient) canonicalizedResource(u *url.URL) string {
+
+	b := &strings.Builder{}
+	b.WriteString("/" + c.account + u.EscapedPath())
+
+	query := u.Query()
+	keys := make([]string, 0, len(query))
+	for k := range query {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		v := query[k]
+		sort.Strings(v)
+		b.WriteString("\n" + strings.ToLower(k) + ":" + strings.Join(v, ","))
+	}
+	return b.String()
+}
@@ -0,0 +1,112 @@
+package azure
+
+import (
+	"encoding/xml"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/haozibi/zlog"
+)
+
+const (
+	responseRequestIDKey = "x-amz-request-id"
+)
+
+// azureErrorResponse Blob 服务的错误信息
+type azureErrorResponse struct {
+	XMLName xml.Name `xml:"Error"`
+	Code    string   `xml:"Code"`
+	Message string   `xml:"Message"`
+}
+
+// azureToS3Code Azure 错误码对应的 S3 错误码
+var azureToS3Code = map[string]string{
+	"ContainerNotFound":              "NoSuchBucket",
+	"ContainerAlreadyExists":         "BucketAlreadyOwnedByYou",
+	"ContainerBeingDeleted":          "OperationAborted",
+	"BlobNotFound":                   "NoSuchKey",
+	"InvalidBlockList":               "InvalidPart",
+	"InvalidRange":                   "InvalidRange",
+	"AuthenticationFailed":           "SignatureDoesNotMatch",
+	"AuthorizationFailure":           "AccessDenied",
+	"InvalidResourceName":            "InvalidBucketName",
+	"OutOfRangeInput":                "InvalidBucketName",
+	"ConditionNotMet":                "PreconditionFailed",
+	"TargetConditionNotMet":          "PreconditionFailed",
+	"ServerBusy":                     "SlowDown",
+	"InternalError":                  "InternalError",
+	"OperationTimedOut":              "RequestTimeout",
+	"RequestBodyTooLarge":            "EntityTooLarge",
+	"InvalidMetadata":                "InvalidArgument",
+	"InvalidHeaderValue":             "InvalidArgument",
+	"InvalidQueryParameterValue":     "InvalidArgument",
+	"UnsupportedHeader":              "NotImplemented",
+	"CannotVerifyCopySource":         "AccessDenied",
+	"PendingCopyOperation":           "OperationAborted",
+	"ContainerDisabled":              "AllAccessDisabled",
+	"AccountIsDisabled":              "AllAccessDisabled",
+	"InsufficientAccountPermissions": "AccessDenied",
+}
+
+// toS3Err 错误处理，把 Azure 错误转变成 s3
+//
+// HEAD 请求没有 body，只能通过 x-ms-error-code 或者状态码判断，
+// notFound 为 404 时使用的 S3 错误码
+func toS3Err(resp *http.Response, notFound string) awserr.RequestFailure {
+
+	requestID := resp.Header.Get("x-ms-request-id")
+
+	var e azureErrorResponse
+	if resp.Body != nil {
+		body, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err == nil && len(body) > 0 {
+			if err := xml.Unmarshal(body, &e); err != nil {
+				zlog.ZDebug().Str("Body", string(body)).Msg("[Azure] error body:" + err.Error())
+			}
+		}
+	}
+
+	if e.Code == "" {
+		e.Code = resp.Header.Get("x-ms-error-code")
+	}
+
+	code, ok := azureToS3Code[e.Code]
+	if !ok {
+		switch resp.StatusCode {
+		case http.StatusNotFound:
+			code = notFound
+		case http.StatusForbidden:
+			code = "AccessDenied"
+		case http.StatusConflict:
+			code = "OperationAborted"
+		case http.StatusPreconditionFailed:
+			code = "PreconditionFailed"
+		default:
+			code = "InternalError"
+		}
+	}
+
+	message := e.Message
+	if message == "" {
+		message = http.StatusText(resp.StatusCode)
+	}
+
+	return awserr.NewRequestFailure(awserr.New(code, message, nil), resp.StatusCode, requestID)
+}
+
+// toS3ErrNotResponse 请求没有得到响应，比如网络错误
+func toS3ErrNotResponse(err error) awserr.RequestFailure {
+	zlog.ZError().Msg("[Azure] error:" + err.Error())
+	return awserr.NewRequestFailure(awserr.New("InternalError", err.Error(), err), http.StatusInternalServerError, "")
+}
+
+// setS3Header 把 Azure 的请求 ID 写入 S3 请求头
+func setS3Header(resp *http.Response) *http.Response {
+	if resp == nil {
+		return &http.Response{Header: make(http.Header)}
+	}
+	resp.Header.Set(responseRequestIDKey, resp.Header.Get("x-ms-request-id"))
+	return resp
+}
@@ -0,0 +1,244 @@
+package gateway
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/solution9th/S3Adapter/internal/gerror"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// S3Protocol 中的方法，名称和 S3 API 一致
+const (
+	OpCreateBucket  = "CreateBucket"
+	OpHeadBucket    = "HeadBucket"
+	OpListBuckets   = "ListBuckets"
+	OpListObjects   = "ListObjects"
+	OpListObjectsV2 = "ListObjectsV2"
+	OpDeleteBucket  = "DeleteBucket"
+	OpPutObject     = "PutObject"
+	OpHeadObject    = "HeadObject"
+	OpGetObject     = "GetObject"
+	OpDeleteObject  = "DeleteObject"
+	OpCopyObject    = "CopyObject"
+)
+
+// 方法之外的可选特性
+const (
+	// FeatureRange GetObject 支持 Range
+	FeatureRange = "Range"
+	// FeatureConditional 支持 If-Match、If-None-Match、If-Unmodified-Since 等条件请求
+	FeatureConditional = "Conditional"
+	// FeatureUserMetadata 支持 x-amz-meta-* 自定义元数据
+	FeatureUserMetadata = "UserMetadata"
+	// FeatureStorageClass 支持 STANDARD 之外的存储类型
+	FeatureStorageClass = "StorageClass"
+)
+
+// AllOperations S3Protocol 的全部方法
+var AllOperations = []string{
+	OpCreateBucket, OpHeadBucket, OpListBuckets, OpListObjects, OpListObjectsV2, OpDeleteBucket,
+	OpPutObject, OpHeadObject, OpGetObject, OpDeleteObject, OpCopyObject,
+}
+
+// AllFeatures 全部可选特性
+var AllFeatures = []string{FeatureRange, FeatureConditional, FeatureUserMetadata, FeatureStorageClass}
+
+// Capabilities 引擎支持的方法和特性
+type Capabilities struct {
+	Operations []string `json:"operations" xml:"Operation"`
+	Features   []string `json:"features" xml:"Feature"`
+}
+
+// FullCapabilities 支持全部方法和特性
+func FullCapabilities() Capabilities {
+	return Capabilities{
+		Operations: append([]string(nil), AllOperations...),
+		Features:   append([]string(nil), AllFeatures...),
+	}
+}
+
+// Supports 是否支持方法 op
+func (c Capabilities) Supports(op string) bool {
+	return contains(c.Operations, op)
+}
+
+// Has 是否支持特性 feature
+func (c Capabilities) Has(feature string) bool {
+	return contains(c.Features, feature)
+}
+
+// Intersect 两者都支持的方法和特性，组合引擎(分层、镜像等)的能力取决于每个后端
+func (c Capabilities) Intersect(o Capabilities) Capabilities {
+	return Capabilities{
+		Operations: intersect(c.Operations, o.Operations),
+		Features:   intersect(c.Features, o.Features),
+	}
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+func intersect(a, b []string) []string {
+	out := make([]string, 0, len(a))
+	for _, v := range a {
+		if contains(b, v) {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+// CapabilityReporter 能够报告自身能力的 S3Protocol
+type CapabilityReporter interface {
+	Capabilities() Capabilities
+}
+
+// CapabilitiesOf 返回 p 的能力，没有实现 CapabilityReporter 的认为支持全部
+func CapabilitiesOf(p S3Protocol) Capabilities {
+	if r, ok := p.(CapabilityReporter); ok {
+		return r.Capabilities()
+	}
+	return FullCapabilities()
+}
+
+// WithCapabilities 在调用后端之前检查方法和特性，不支持时直接返回 501 NotImplemented
+func WithCapabilities(p S3Protocol, caps Capabilities) S3Protocol {
+	return &guard{S3Protocol: p, caps: caps}
+}
+
+type guard struct {
+	S3Protocol
+	caps Capabilities
+}
+
+func (g *guard) Capabilities() Capabilities { return g.caps }
+
+// check 检查方法和请求中用到的特性，features 为空字符串的忽略
+func (g *guard) check(op string, features ...string) error {
+
+	if !g.caps.Supports(op) {
+		return gerror.GetError(gerror.ErrNotImplemented, nil)
+	}
+	for _, f := range features {
+		if f != "" && !g.caps.Has(f) {
+			return gerror.GetError(gerror.ErrNotImplemented, nil)
+		}
+	}
+	return nil
+}
+
+func emptyResponse() *http.Response {
+	return &http.Response{StatusCode: http.StatusNotImplemented, Header: make(http.Header)}
+}
+
+func when(ok bool, feature string) string {
+	if ok {
+		return feature
+	}
+	return ""
+}
+
+func conditional(ifMatch, ifNoneMatch *string, ifModifiedSince, ifUnmodifiedSince *time.Time) bool {
+	return aws.StringValue(ifMatch) != "" || aws.StringValue(ifNoneMatch) != "" || ifModifiedSince != nil || ifUnmodifiedSince != nil
+}
+
+func storageClass(s *string) bool {
+	v := aws.StringValue(s)
+	return v != "" && v != s3.StorageClassStandard
+}
+
+func (g *guard) CreateBucketWithContext(ctx context.Context, input *s3.CreateBucketInput, opts ...request.Option) (*s3.CreateBucketOutput, *http.Response, error) {
+	if err := g.check(OpCreateBucket); err != nil {
+		return nil, emptyResponse(), err
+	}
+	return g.S3Protocol.CreateBucketWithContext(ctx, input, opts...)
+}
+
+func (g *guard) HeadBucketWithContext(ctx context.Context, input *s3.HeadBucketInput, opts ...request.Option) (*s3.HeadBucketOutput, *http.Response, error) {
+	if err := g.check(OpHeadBucket); err != nil {
+		return nil, emptyResponse(), err
+	}
+	return g.S3Protocol.HeadBucketWithContext(ctx, input, opts...)
+}
+
+func (g *guard) ListBucketsWithContext(ctx context.Context, input *s3.ListBucketsInput, opts ...request.Option) (*s3.ListBucketsOutput, *http.Response, error) {
+	if err := g.check(OpListBuckets); err != nil {
+		return nil, emptyResponse(), err
+	}
+	return g.S3Protocol.ListBucketsWithContext(ctx, input, opts...)
+}
+
+func (g *guard) ListObjectsWithContext(ctx context.Context, input *s3.ListObjectsInput, opts ...request.Option) (*s3.ListObjectsOutput, *http.Response, error) {
+	if err := g.check(OpListObjects); err != nil {
+		return nil, emptyResponse(), err
+	}
+	return g.S3Protocol.ListObjectsWithContext(ctx, input, opts...)
+}
+
+func (g *guard) ListObjectsWithContextV2(ctx context.Context, input *s3.ListObjectsV2Input, opts ...request.Option) (*s3.ListObjectsV2Output, *http.Response, error) {
+	if err := g.check(OpListObjectsV2); err != nil {
+		return nil, emptyResponse(), err
+	}
+	return g.S3Protocol.ListObjectsWithContextV2(ctx, input, opts...)
+}
+
+func (g *guard) DeleteBucketWithContext(ctx context.Context, input *s3.DeleteBucketInput, opts ...request.Option) (*s3.DeleteBucketOutput, *http.Response, error) {
+	if err := g.check(OpDeleteBucket); err != nil {
+		return nil, emptyResponse(), err
+	}
+	return g.S3Protocol.DeleteBucketWithContext(ctx, input, opts...)
+}
+
+func (g *guard) PutObjectWithContext(ctx context.Context, input *s3.PutObjectInput, opts ...request.Option) (*s3.PutObjectOutput, *http.Response, error) {
+	if err := g.check(OpPutObject,
+		when(len(input.Metadata) > 0, FeatureUserMetadata),
+		when(storageClass(input.StorageClass), FeatureStorageClass)); err != nil {
+		return nil, emptyResponse(), err
+	}
+	return g.S3Protocol.PutObjectWithContext(ctx, input, opts...)
+}
+
+func (g *guard) HeadObjectWithContext(ctx context.Context, input *s3.HeadObjectInput, opts ...request.Option) (*s3.HeadObjectOutput, *http.Response, error) {
+	if err := g.check(OpHeadObject,
+		when(conditional(input.IfMatch, input.IfNoneMatch, input.IfModifiedSince, input.IfUnmodifiedSince), FeatureConditional)); err != nil {
+		return nil, emptyResponse(), err
+	}
+	return g.S3Protocol.HeadObjectWithContext(ctx, input, opts...)
+}
+
+func (g *guard) GetObjectWithContext(ctx context.Context, input *s3.GetObjectInput, opts ...request.Option) (*s3.GetObjectOutput, *http.Response, error) {
+	if err := g.check(OpGetObject,
+		when(aws.StringValue(input.Range) != "", FeatureRange),
+		when(conditional(input.IfMatch, input.IfNoneMatch, input.IfModifiedSince, input.IfUnmodifiedSince), FeatureConditional)); err != nil {
+		return nil, emptyResponse(), err
+	}
+	return g.S3Protocol.GetObjectWithContext(ctx, input, opts...)
+}
+
+func (g *guard) DeleteObjectWithContext(ctx context.Context, input *s3.DeleteObjectInput, opts ...request.Option) (*s3.DeleteObjectOutput, *http.Response, error) {
+	if err := g.check(OpDeleteObject); err != nil {
+		return nil, emptyResponse(), err
+	}
+	return g.S3Protocol.DeleteObjectWithContext(ctx, input, opts...)
+}
+
+func (g *guard) CopyObjectWithContext(ctx context.Context, input *s3.CopyObjectInput, opts ...request.Option) (*s3.CopyObjectOutput, *http.Response, error) {
+	if err := g.check(OpCopyObject,
+		when(aws.StringValue(input.MetadataDirective) == s3.MetadataDirectiveReplace && len(input.Metadata) > 0, FeatureUserMetadata),
+		when(storageClass(input.StorageClass), FeatureStorageClass),
+		when(conditional(input.CopySourceIfMatch, input.CopySourceIfNoneMatch, input.CopySourceIfModifiedSince, input.CopySourceIfUnmodifiedSince), FeatureConditional)); err != nil {
+		return nil, emptyResponse(), err
+	}
+	return g.S3Protocol.CopyObjectWithContext(ctx, input, opts...)
+}
@@ -0,0 +1,102 @@
+package gateway
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// countProto 只实现测试用到的方法，其他方法被调用时会 panic
+type countProto struct {
+	S3Protocol
+	calls int
+}
+
+func (c *countProto) GetObjectWithContext(ctx context.Context, input *s3.GetObjectInput, opts ...request.Option) (*s3.GetObjectOutput, *http.Response, error) {
+	c.calls++
+	return &s3.GetObjectOutput{}, &http.Response{Header: make(http.Header)}, nil
+}
+
+func (c *countProto) PutObjectWithContext(ctx context.Context, input *s3.PutObjectInput, opts ...request.Option) (*s3.PutObjectOutput, *http.Response, error) {
+	c.calls++
+	return &s3.PutObjectOutput{}, &http.Response{Header: make(http.Header)}, nil
+}
+
+func TestWithCapabilities(t *testing.T) {
+
+	ctx := context.Background()
+	backend := &countProto{}
+	p := WithCapabilities(backend, Capabilities{
+		Operations: []string{OpGetObject, OpPutObject},
+		Features:   []string{FeatureRange},
+	})
+
+	notImplemented := func(err error) bool {
+		e, ok := err.(awserr.RequestFailure)
+		return ok && e.Code() == "NotImplemented" && e.StatusCode() == http.StatusNotImplemented
+	}
+
+	_, resp, err := p.CopyObjectWithContext(ctx, &s3.CopyObjectInput{})
+	if !notImplemented(err) || resp == nil {
+		t.Fatalf("CopyObject: %v", err)
+	}
+
+	_, _, err = p.PutObjectWithContext(ctx, &s3.PutObjectInput{StorageClass: aws.String(s3.StorageClassGlacier)})
+	if !notImplemented(err) {
+		t.Fatalf("PutObject with storage class: %v", err)
+	}
+
+	_, _, err = p.PutObjectWithContext(ctx, &s3.PutObjectInput{StorageClass: aws.String(s3.StorageClassStandard)})
+	if err != nil {
+		t.Fatalf("PutObject: %v", err)
+	}
+
+	since := aws.Time(time.Now())
+	for name, input := range map[string]*s3.GetObjectInput{
+		"If-Match":            {IfMatch: aws.String(`"etag"`)},
+		"If-None-Match":       {IfNoneMatch: aws.String(`"etag"`)},
+		"If-Modified-Since":   {IfModifiedSince: since},
+		"If-Unmodified-Since": {IfUnmodifiedSince: since},
+	} {
+		_, _, err = p.GetObjectWithContext(ctx, input)
+		if !notImplemented(err) {
+			t.Fatalf("GetObject with %s: %v", name, err)
+		}
+	}
+
+	_, _, err = p.GetObjectWithContext(ctx, &s3.GetObjectInput{Range: aws.String("bytes=0-1")})
+	if err != nil {
+		t.Fatalf("GetObject with range: %v", err)
+	}
+
+	if backend.calls != 2 {
+		t.Fatalf("backend calls: got %d, want 2", backend.calls)
+	}
+
+	if !CapabilitiesOf(p).Supports(OpGetObject) || !CapabilitiesOf(backend).Supports(OpCopyObject) {
+		t.Fatal("CapabilitiesOf")
+	}
+}
+
+func TestCapabilitiesIntersect(t *testing.T) {
+
+	a := FullCapabilities()
+	b := Capabilities{
+		Operations: []string{OpListObjects, OpGetObject, "Unknown"},
+		Features:   []string{FeatureRange},
+	}
+
+	got := a.Intersect(b)
+	if len(got.Operations) != 2 || !got.Supports(OpListObjects) || !got.Supports(OpGetObject) {
+		t.Fatalf("operations: %v", got.Operations)
+	}
+	if len(got.Features) != 1 || !got.Has(FeatureRange) {
+		t.Fatalf("features: %v", got.Features)
+	}
+}
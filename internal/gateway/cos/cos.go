@@ -11,6 +11,7 @@ import (
 
 	"github.com/solution9th/S3Adapter/internal/auth"
 	"github.com/solution9th/S3Adapter/internal/gateway"
+	"github.com/solution9th/S3Adapter/internal/reqinfo"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/request"
@@ -45,12 +46,24 @@ type cosgw struct{}
 
 func (s *cosgw) Name() string     { return Backend }
 func (s *cosgw) Production() bool { return true }
+
+// Capabilities cos 没有实现 ListObjectsV2，条件请求只支持 If-Modified-Since
+func (s *cosgw) Capabilities() gateway.Capabilities {
+	return gateway.Capabilities{
+		Operations: []string{
+			gateway.OpCreateBucket, gateway.OpHeadBucket, gateway.OpListBuckets, gateway.OpListObjects, gateway.OpDeleteBucket,
+			gateway.OpPutObject, gateway.OpHeadObject, gateway.OpGetObject, gateway.OpDeleteObject, gateway.OpCopyObject,
+		},
+		Features: []string{gateway.FeatureRange, gateway.FeatureUserMetadata, gateway.FeatureStorageClass},
+	}
+}
 func (s *cosgw) NewS3Protocol(creds auth.Credentials, region string, isDebug bool) (gateway.S3Protocol, error) {
 
 	cfg := &cos.AuthorizationTransport{
 		SecretID:     creds.AccessKey,
 		SecretKey:    creds.SecretKey,
 		SessionToken: creds.SessionToken,
+		Transport:    gateway.Transport(),
 	}
 
 	if isDebug {
@@ -59,27 +72,45 @@ func (s *cosgw) NewS3Protocol(creds auth.Credentials, region string, isDebug boo
 			RequestBody:    true,
 			ResponseHeader: true,
 			ResponseBody:   true,
+			Transport:      gateway.Transport(),
 		}
 	}
 
-	c := cos.NewClient(nil, &http.Client{
-		Transport: cfg,
-	})
+	serviceURL, _ := url.Parse(defaultServiceBaseURL)
 
 	return &cosProto{
-		cosClient: c,
-		region:    region,
-		appid:     creds.AccessKey,
-		cosURI:    "https://%v.cos.%s.myqcloud.com",
+		httpClient: &http.Client{
+			Transport: cfg,
+		},
+		region:     region,
+		appid:      creds.AccessKey,
+		cosURI:     "https://%v.cos.%s.myqcloud.com",
+		serviceURL: serviceURL,
 	}, nil
 }
 
+// cosProto 可以被多个请求并发使用，创建后不再修改
 type cosProto struct {
 	gateway.GatewayUnsupported
-	cosClient *cos.Client
-	region    string
-	appid     string
-	cosURI    string
+	httpClient *http.Client
+	region     string
+	appid      string
+	cosURI     string
+	serviceURL *url.URL
+}
+
+// bucketClient 创建访问 bucket 的客户端
+//
+// cos.Client 的 BaseURL 是共享的，不能修改，每个请求使用自己的客户端，
+// 所有客户端共用 httpClient，创建的开销很小
+func (s *cosProto) bucketClient(bucket string) *cos.Client {
+	u, _ := url.Parse(fmt.Sprintf(s.cosURI, bucket, s.region))
+	return cos.NewClient(&cos.BaseURL{BucketURL: u, ServiceURL: s.serviceURL}, s.httpClient)
+}
+
+// serviceClient 创建访问服务(比如 ListBuckets)的客户端
+func (s *cosProto) serviceClient() *cos.Client {
+	return cos.NewClient(&cos.BaseURL{ServiceURL: s.serviceURL}, s.httpClient)
 }
 
 // =================
@@ -106,61 +137,48 @@ func (s *cosProto) CreateBucketWithContext(ctx context.Context, input *s3.Create
 		cosInput.XCosGrantFullControl = aws.StringValue(input.GrantFullControl)
 	}
 
-	// u, _ := url.Parse("http://<BucketName-APPID>.cos.<region>.myqcloud.com")
-
-	u, _ := url.Parse(fmt.Sprintf(s.cosURI, aws.StringValue(input.Bucket), s.region))
-	s.cosClient.BaseURL.BucketURL = u
+	// http://<BucketName-APPID>.cos.<region>.myqcloud.com
+	c := s.bucketClient(aws.StringValue(input.Bucket))
 
-	resp, err := s.cosClient.Bucket.Put(ctx, cosInput)
+	resp, err := c.Bucket.Put(ctx, cosInput)
 	if err != nil {
-		zlog.ZError().Str("Method", "CreateBucketWithContext").Str("Bucket", aws.StringValue(input.Bucket)).Msg("[COS] error:" + err.Error())
-		return nil, getErrResponse(err), toS3Err(err)
+		reqinfo.ZError(ctx).Str("Method", "CreateBucketWithContext").Str("Bucket", aws.StringValue(input.Bucket)).Msg("[COS] error:" + err.Error())
+		return nil, response(resp), toS3Err(err)
 	}
 
-	resp.Response.Header.Set(responseRequestIDKey, resp.Response.Header.Get("x-cos-request-id"))
-	resp.Response.Header.Set(responseAMZIDKey, resp.Response.Header.Get("x-cos-trace-id"))
-
 	return &s3.CreateBucketOutput{
 		Location: aws.String(s.region),
-	}, resp.Response, nil
+	}, response(resp), nil
 }
 
 func (s *cosProto) HeadBucketWithContext(ctx context.Context, input *s3.HeadBucketInput, opts ...request.Option) (*s3.HeadBucketOutput, *http.Response, error) {
 
-	u, _ := url.Parse(fmt.Sprintf(s.cosURI, aws.StringValue(input.Bucket), s.region))
-	s.cosClient.BaseURL.BucketURL = u
+	c := s.bucketClient(aws.StringValue(input.Bucket))
 
-	resp, err := s.cosClient.Bucket.Head(ctx)
+	resp, err := c.Bucket.Head(ctx)
 	if err != nil {
-		zlog.ZError().Str("Method", "HeadBucketWithContext").Str("Bucket", aws.StringValue(input.Bucket)).Msg("[COS] error:" + err.Error())
-		return nil, getErrResponse(err), toS3Err(err)
+		reqinfo.ZError(ctx).Str("Method", "HeadBucketWithContext").Str("Bucket", aws.StringValue(input.Bucket)).Msg("[COS] error:" + err.Error())
+		return nil, response(resp), toS3Err(err)
 	}
 
-	resp.Response.Header.Set(responseRequestIDKey, resp.Response.Header.Get("x-cos-request-id"))
-	resp.Response.Header.Set(responseAMZIDKey, resp.Response.Header.Get("x-cos-trace-id"))
-
-	return &s3.HeadBucketOutput{}, resp.Response, nil
+	return &s3.HeadBucketOutput{}, response(resp), nil
 }
 
 func (s *cosProto) DeleteBucketWithContext(ctx context.Context, input *s3.DeleteBucketInput, opts ...request.Option) (*s3.DeleteBucketOutput, *http.Response, error) {
 
-	u, _ := url.Parse(fmt.Sprintf(s.cosURI, aws.StringValue(input.Bucket), s.region))
-	s.cosClient.BaseURL.BucketURL = u
+	c := s.bucketClient(aws.StringValue(input.Bucket))
 
-	resp, err := s.cosClient.Bucket.Delete(ctx)
+	resp, err := c.Bucket.Delete(ctx)
 	if err != nil {
-		zlog.ZError().Str("Method", "DeleteBucketWithContext").Str("Bucket", aws.StringValue(input.Bucket)).Msg("[COS] error:" + err.Error())
-		return nil, getErrResponse(err), toS3Err(err)
+		reqinfo.ZError(ctx).Str("Method", "DeleteBucketWithContext").Str("Bucket", aws.StringValue(input.Bucket)).Msg("[COS] error:" + err.Error())
+		return nil, response(resp), toS3Err(err)
 	}
-	resp.Response.Header.Set(responseRequestIDKey, resp.Response.Header.Get("x-cos-request-id"))
-	resp.Response.Header.Set(responseAMZIDKey, resp.Response.Header.Get("x-cos-trace-id"))
-	return &s3.DeleteBucketOutput{}, resp.Response, nil
+	return &s3.DeleteBucketOutput{}, response(resp), nil
 }
 
 func (s *cosProto) ListObjectsWithContext(ctx context.Context, input *s3.ListObjectsInput, opts ...request.Option) (*s3.ListObjectsOutput, *http.Response, error) {
 
-	u, _ := url.Parse(fmt.Sprintf(s.cosURI, aws.StringValue(input.Bucket), s.region))
-	s.cosClient.BaseURL.BucketURL = u
+	c := s.bucketClient(aws.StringValue(input.Bucket))
 
 	cosInput := &cos.BucketGetOptions{}
 	if input.Delimiter != nil {
@@ -183,12 +201,10 @@ func (s *cosProto) ListObjectsWithContext(ctx context.Context, input *s3.ListObj
 		cosInput.MaxKeys = int(aws.Int64Value(input.MaxKeys))
 	}
 
-	cosOutput, resp, err := s.cosClient.Bucket.Get(ctx, cosInput)
+	cosOutput, resp, err := c.Bucket.Get(ctx, cosInput)
 	if err != nil {
-		zlog.ZError().Str("Method", "ListObjectsWithContext").Str("Bucket", aws.StringValue(input.Bucket)).Msg("[COS] error:" + err.Error())
-		resp.Response.Header.Set(responseRequestIDKey, resp.Response.Header.Get("x-cos-request-id"))
-		resp.Response.Header.Set(responseAMZIDKey, resp.Response.Header.Get("x-cos-trace-id"))
-		return nil, resp.Response, toS3Err(err)
+		reqinfo.ZError(ctx).Str("Method", "ListObjectsWithContext").Str("Bucket", aws.StringValue(input.Bucket)).Msg("[COS] error:" + err.Error())
+		return nil, response(resp), toS3Err(err)
 	}
 
 	output := &s3.ListObjectsOutput{
@@ -204,7 +220,7 @@ func (s *cosProto) ListObjectsWithContext(ctx context.Context, input *s3.ListObj
 
 	output.CommonPrefixes = make([]*s3.CommonPrefix, len(cosOutput.CommonPrefixes))
 	for i := 0; i < len(cosOutput.CommonPrefixes); i++ {
-		output.CommonPrefixes[i].Prefix = aws.String(cosOutput.CommonPrefixes[i])
+		output.CommonPrefixes[i] = &s3.CommonPrefix{Prefix: aws.String(cosOutput.CommonPrefixes[i])}
 	}
 
 	output.Contents = make([]*s3.Object, len(cosOutput.Contents))
@@ -213,7 +229,7 @@ func (s *cosProto) ListObjectsWithContext(ctx context.Context, input *s3.ListObj
 		// 2019-06-13T08:30:15.000Z
 		lmt, err := time.Parse(time.RFC3339, cosOutput.Contents[i].LastModified)
 		if err != nil {
-			zlog.ZError().Msg(err.Error())
+			reqinfo.ZError(ctx).Msg(err.Error())
 		}
 
 		output.Contents[i] = &s3.Object{
@@ -224,26 +240,25 @@ func (s *cosProto) ListObjectsWithContext(ctx context.Context, input *s3.ListObj
 			StorageClass: aws.String(cosOutput.Contents[i].StorageClass),
 		}
 
-		output.Contents[i].Owner = &s3.Owner{
-			DisplayName: aws.String(cosOutput.Contents[i].Owner.DisplayName),
-			ID:          aws.String(cosOutput.Contents[i].Owner.ID),
+		if owner := cosOutput.Contents[i].Owner; owner != nil {
+			output.Contents[i].Owner = &s3.Owner{
+				DisplayName: aws.String(owner.DisplayName),
+				ID:          aws.String(owner.ID),
+			}
 		}
 	}
 
-	resp.Response.Header.Set(responseRequestIDKey, resp.Response.Header.Get("x-cos-request-id"))
-	resp.Response.Header.Set(responseAMZIDKey, resp.Response.Header.Get("x-cos-trace-id"))
-
-	return output, resp.Response, nil
+	return output, response(resp), nil
 }
 
 func (s *cosProto) ListBucketsWithContext(ctx context.Context, input *s3.ListBucketsInput, opts ...request.Option) (*s3.ListBucketsOutput, *http.Response, error) {
 
-	s.cosClient.BaseURL.BucketURL, _ = url.Parse(defaultServiceBaseURL)
+	c := s.serviceClient()
 
-	cosOutput, resp, err := s.cosClient.Service.Get(ctx)
+	cosOutput, resp, err := c.Service.Get(ctx)
 	if err != nil {
-		zlog.ZError().Str("Method", "ListBucketsWithContext").Msg("[COS] error:" + err.Error())
-		return nil, getErrResponse(err), toS3Err(err)
+		reqinfo.ZError(ctx).Str("Method", "ListBucketsWithContext").Msg("[COS] error:" + err.Error())
+		return nil, response(resp), toS3Err(err)
 	}
 
 	output := &s3.ListBucketsOutput{
@@ -264,10 +279,7 @@ func (s *cosProto) ListBucketsWithContext(ctx context.Context, input *s3.ListBuc
 		}
 	}
 
-	resp.Response.Header.Set(responseRequestIDKey, resp.Response.Header.Get("x-cos-request-id"))
-	resp.Response.Header.Set(responseAMZIDKey, resp.Response.Header.Get("x-cos-trace-id"))
-
-	return output, resp.Response, nil
+	return output, response(resp), nil
 }
 
 // =================
@@ -317,8 +329,7 @@ func (s *cosProto) GetObjectWithContext(ctx context.Context, input *s3.GetObject
 	bucket := *input.Bucket
 	object := *input.Key
 
-	u, _ := url.Parse(fmt.Sprintf(s.cosURI, aws.StringValue(input.Bucket), s.region))
-	s.cosClient.BaseURL.BucketURL = u
+	c := s.bucketClient(aws.StringValue(input.Bucket))
 
 	opt := &cos.ObjectGetOptions{}
 	if input.ResponseContentType != nil {
@@ -354,12 +365,10 @@ func (s *cosProto) GetObjectWithContext(ctx context.Context, input *s3.GetObject
 	}
 
 	//opt可选，无特殊设置可设为nil
-	resp, err := s.cosClient.Object.Get(ctx, object, opt)
-	resp.Response.Header.Set(responseRequestIDKey, resp.Response.Header.Get("x-cos-request-id"))
-	resp.Response.Header.Set(responseAMZIDKey, resp.Response.Header.Get("x-cos-trace-id"))
+	resp, err := c.Object.Get(ctx, object, opt)
 	if err != nil {
-		zlog.ZError().Str("method", "Object.Get").Str("bucket", bucket).Msg(err.Error())
-		return nil, resp.Response, toS3Err(err)
+		reqinfo.ZError(ctx).Str("method", "Object.Get").Str("bucket", bucket).Msg(err.Error())
+		return nil, response(resp), toS3Err(err)
 	}
 
 	header := resp.Header
@@ -378,27 +387,24 @@ func (s *cosProto) GetObjectWithContext(ctx context.Context, input *s3.GetObject
 		StorageClass:         awsString(header.Get("x-cos-storage-class")),
 		Metadata:             cosHeaderToS3Header(header),
 		ServerSideEncryption: awsString(header.Get("x-cos-server-side-encryption")),
-	}, resp.Response, nil
+	}, response(resp), nil
 }
 
 func (s *cosProto) HeadObjectWithContext(ctx context.Context, input *s3.HeadObjectInput, opts ...request.Option) (*s3.HeadObjectOutput, *http.Response, error) {
 	bucket := *input.Bucket
 	object := *input.Key
 
-	u, _ := url.Parse(fmt.Sprintf(s.cosURI, aws.StringValue(input.Bucket), s.region))
-	s.cosClient.BaseURL.BucketURL = u
+	c := s.bucketClient(aws.StringValue(input.Bucket))
 
 	opt := &cos.ObjectHeadOptions{}
 	if input.IfModifiedSince != nil {
 		opt.IfModifiedSince = aws.TimeValue(input.IfModifiedSince).Format(http.TimeFormat)
 	}
-	resp, err := s.cosClient.Object.Head(ctx, object, opt)
-	resp.Response.Header.Set(responseRequestIDKey, resp.Response.Header.Get("x-cos-request-id"))
-	resp.Response.Header.Set(responseAMZIDKey, resp.Response.Header.Get("x-cos-trace-id"))
+	resp, err := c.Object.Head(ctx, object, opt)
 	if err != nil {
 		fmt.Println(err)
-		zlog.ZError().Str("method", "Object.Get").Str("bucket", bucket).Str("object", object).Msg(err.Error())
-		return nil, resp.Response, toS3Err(err)
+		reqinfo.ZError(ctx).Str("method", "Object.Get").Str("bucket", bucket).Str("object", object).Msg(err.Error())
+		return nil, response(resp), toS3Err(err)
 	}
 
 	header := resp.Header
@@ -409,11 +415,11 @@ func (s *cosProto) HeadObjectWithContext(ctx context.Context, input *s3.HeadObje
 	// modTime, err := time.Parse(time.RFC3339, header.Get("Last-Modified"))
 	modTime, err := time.Parse(http.TimeFormat, header.Get("Last-Modified"))
 	if err != nil {
-		return nil, resp.Response, toS3Err(err)
+		return nil, response(resp), toS3Err(err)
 	}
 	size, err := strconv.ParseInt(header.Get("Content-Length"), 10, 64)
 	if err != nil {
-		return nil, resp.Response, toS3Err(err)
+		return nil, response(resp), toS3Err(err)
 	}
 
 	return &s3.HeadObjectOutput{
@@ -423,7 +429,7 @@ func (s *cosProto) HeadObjectWithContext(ctx context.Context, input *s3.HeadObje
 		ContentType:     awsString(header.Get("Content-Type")),
 		ContentLength:   &size,
 		ContentEncoding: awsString(header.Get("Content-Encoding")),
-	}, resp.Response, nil
+	}, response(resp), nil
 }
 
 func awsString(v string) *string {
@@ -437,8 +443,7 @@ func (s *cosProto) PutObjectWithContext(ctx context.Context, input *s3.PutObject
 	bucket := *input.Bucket
 	object := *input.Key
 
-	u, _ := url.Parse(fmt.Sprintf(s.cosURI, aws.StringValue(input.Bucket), s.region))
-	s.cosClient.BaseURL.BucketURL = u
+	c := s.bucketClient(aws.StringValue(input.Bucket))
 
 	// Build COS metadata
 	opt := &cos.ObjectPutOptions{
@@ -491,12 +496,10 @@ func (s *cosProto) PutObjectWithContext(ctx context.Context, input *s3.PutObject
 		opt.XCosStorageClass = aws.StringValue(input.StorageClass)
 	}
 
-	resp, err := s.cosClient.Object.Put(ctx, object, input.Body, opt)
-	resp.Response.Header.Set(responseRequestIDKey, resp.Response.Header.Get("x-cos-request-id"))
-	resp.Response.Header.Set(responseAMZIDKey, resp.Response.Header.Get("x-cos-trace-id"))
+	resp, err := c.Object.Put(ctx, object, input.Body, opt)
 	if err != nil {
-		zlog.ZError().Str("method", "PutObject").Str("bucket", bucket).Str("object", object).Msg(err.Error())
-		return nil, resp.Response, toS3Err(err)
+		reqinfo.ZError(ctx).Str("method", "PutObject").Str("bucket", bucket).Str("object", object).Msg(err.Error())
+		return nil, response(resp), toS3Err(err)
 	}
 
 	header := resp.Header
@@ -509,36 +512,32 @@ func (s *cosProto) PutObjectWithContext(ctx context.Context, input *s3.PutObject
 		SSEKMSKeyId:          nil,
 		ServerSideEncryption: awsString(header.Get("x-cos-server-side-encryption")),
 		VersionId:            awsString(header.Get("x-cos-version-id")),
-	}, resp.Response, nil
+	}, response(resp), nil
 }
 
 func (s *cosProto) DeleteObjectWithContext(ctx context.Context, input *s3.DeleteObjectInput, opts ...request.Option) (*s3.DeleteObjectOutput, *http.Response, error) {
 	bucket := *input.Bucket
 	object := *input.Key
 
-	u, _ := url.Parse(fmt.Sprintf(s.cosURI, aws.StringValue(input.Bucket), s.region))
-	s.cosClient.BaseURL.BucketURL = u
+	c := s.bucketClient(aws.StringValue(input.Bucket))
 
-	resp, err := s.cosClient.Object.Delete(ctx, object)
-	resp.Response.Header.Set(responseRequestIDKey, resp.Response.Header.Get("x-cos-request-id"))
-	resp.Response.Header.Set(responseAMZIDKey, resp.Response.Header.Get("x-cos-trace-id"))
+	resp, err := c.Object.Delete(ctx, object)
 	if err != nil {
-		zlog.ZError().Str("method", "DeleteObject").Str("bucket", bucket).Str("object", object).Msg(err.Error())
-		return nil, resp.Response, toS3Err(err)
+		reqinfo.ZError(ctx).Str("method", "DeleteObject").Str("bucket", bucket).Str("object", object).Msg(err.Error())
+		return nil, response(resp), toS3Err(err)
 	}
 	return &s3.DeleteObjectOutput{
 		DeleteMarker:   nil,
 		RequestCharged: nil,
 		VersionId:      nil,
-	}, resp.Response, nil
+	}, response(resp), nil
 }
 
 func (s *cosProto) CopyObjectWithContext(ctx context.Context, input *s3.CopyObjectInput, opts ...request.Option) (*s3.CopyObjectOutput, *http.Response, error) {
 	bucket := *input.Bucket
 	object := *input.Key
 
-	u, _ := url.Parse(fmt.Sprintf(s.cosURI, aws.StringValue(input.Bucket), s.region))
-	s.cosClient.BaseURL.BucketURL = u
+	c := s.bucketClient(aws.StringValue(input.Bucket))
 
 	opt := &cos.ObjectCopyOptions{
 		ObjectCopyHeaderOptions: &cos.ObjectCopyHeaderOptions{
@@ -589,16 +588,16 @@ func (s *cosProto) CopyObjectWithContext(ctx context.Context, input *s3.CopyObje
 		opt.XCosMetadataDirective = aws.StringValue(input.MetadataDirective)
 	}
 
-	res, resp, err := s.cosClient.Object.Copy(ctx, object, opt.XCosCopySource, opt)
+	res, resp, err := c.Object.Copy(ctx, object, opt.XCosCopySource, opt)
 	if err != nil {
-		zlog.ZError().Str("method", "CopyObject").Str("bucket", bucket).Str("object", object).Msg(err.Error())
-		return nil, resp.Response, toS3Err(err)
+		reqinfo.ZError(ctx).Str("method", "CopyObject").Str("bucket", bucket).Str("object", object).Msg(err.Error())
+		return nil, response(resp), toS3Err(err)
 	}
 	header := resp.Header
 	lastMod, err := time.Parse(time.RFC3339, res.LastModified)
 	if err != nil {
-		zlog.ZError().Str("method", "CopyObject").Str("bucket", bucket).Str("object", object).Msg(err.Error())
-		return nil, resp.Response, toS3Err(err)
+		reqinfo.ZError(ctx).Str("method", "CopyObject").Str("bucket", bucket).Str("object", object).Msg(err.Error())
+		return nil, response(resp), toS3Err(err)
 	}
 	return &s3.CopyObjectOutput{
 		CopyObjectResult: &s3.CopyObjectResult{
@@ -607,5 +606,5 @@ func (s *cosProto) CopyObjectWithContext(ctx context.Context, input *s3.CopyObje
 		},
 		ServerSideEncryption: awsString(header.Get("x-cos-server-side-encryption")),
 		VersionId:            awsString(header.Get("x-cos-version-id")),
-	}, resp.Response, nil
+	}, response(resp), nil
 }
@@ -37,24 +37,14 @@ func toS3Err(err error) awserr.RequestFailure {
 	return awserr.NewRequestFailure(awserr.New(e.Code, e.Message, err), stausCode, RequestID)
 }
 
-func getErrResponse(err error) *http.Response {
+// response 把 cos 的请求 ID 转换为 s3 的头，网络错误时 resp 为 nil，返回空的 Response
+func response(resp *cos.Response) *http.Response {
 
-	e, ok := err.(*cos.ErrorResponse)
-	if !ok {
-		return nil
-	}
-	RequestID := e.RequestID
-	if RequestID == "" {
-		RequestID = e.Response.Header.Get("X-Cos-Request-Id")
+	if resp == nil || resp.Response == nil {
+		return &http.Response{Header: make(http.Header)}
 	}
 
-	TraceID := e.TraceID
-	if TraceID == "" {
-		TraceID = e.Response.Header.Get("X-Cos-Trace-Id")
-	}
-
-	e.Response.Header.Set(responseAMZIDKey, TraceID)
-	e.Response.Header.Set(responseRequestIDKey, RequestID)
-
-	return e.Response
+	resp.Response.Header.Set(responseRequestIDKey, resp.Response.Header.Get("x-cos-request-id"))
+	resp.Response.Header.Set(responseAMZIDKey, resp.Response.Header.Get("x-cos-trace-id"))
+	return resp.Response
 }
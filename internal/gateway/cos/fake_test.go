@@ -0,0 +1,296 @@
+package cos
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/solution9th/S3Adapter/internal/auth"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/smartystreets/goconvey/convey"
+	"github.com/tencentyun/cos-go-sdk-v5"
+)
+
+const (
+	fakeRegion      = "ap-test"
+	fakeBucketHost  = ".cos." + fakeRegion + ".myqcloud.com"
+	fakeServiceHost = "service.cos.myqcloud.com"
+)
+
+// fakeCOS 按 Host 区分 bucket 的 COS 服务，只实现测试用到的接口
+type fakeCOS struct {
+	mu      sync.Mutex
+	buckets map[string]map[string][]byte
+	reqID   int64
+}
+
+func newFakeCOS() *fakeCOS {
+	return &fakeCOS{buckets: make(map[string]map[string][]byte)}
+}
+
+func (f *fakeCOS) writeError(w http.ResponseWriter, status int, code string) {
+	w.WriteHeader(status)
+	fmt.Fprintf(w, "<Error><Code>%s</Code><Message>%s</Message></Error>", code, code)
+}
+
+func (f *fakeCOS) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+
+	w.Header().Set("x-cos-request-id", strconv.FormatInt(atomic.AddInt64(&f.reqID, 1), 10))
+
+	host := strings.Split(r.Host, ":")[0]
+	if host == fakeServiceHost {
+		f.listBuckets(w)
+		return
+	}
+	if !strings.HasSuffix(host, fakeBucketHost) {
+		f.writeError(w, http.StatusBadRequest, "InvalidURI")
+		return
+	}
+	bucket := strings.TrimSuffix(host, fakeBucketHost)
+	key := strings.TrimPrefix(r.URL.Path, "/")
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	objects, ok := f.buckets[bucket]
+	if key == "" {
+		switch r.Method {
+		case http.MethodPut:
+			if ok {
+				f.writeError(w, http.StatusConflict, "BucketAlreadyExists")
+				return
+			}
+			f.buckets[bucket] = make(map[string][]byte)
+		case http.MethodHead:
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+			}
+		case http.MethodDelete:
+			if !ok {
+				f.writeError(w, http.StatusNotFound, "NoSuchBucket")
+				return
+			}
+			delete(f.buckets, bucket)
+			w.WriteHeader(http.StatusNoContent)
+		case http.MethodGet:
+			if !ok {
+				f.writeError(w, http.StatusNotFound, "NoSuchBucket")
+				return
+			}
+			f.listObjects(w, bucket, objects, r.URL.Query().Get("prefix"))
+		}
+		return
+	}
+
+	if !ok {
+		f.writeError(w, http.StatusNotFound, "NoSuchBucket")
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPut:
+		body, _ := ioutil.ReadAll(r.Body)
+		objects[key] = body
+		w.Header().Set("ETag", etag(body))
+	case http.MethodGet, http.MethodHead:
+		body, ok := objects[key]
+		if !ok {
+			f.writeError(w, http.StatusNotFound, "NoSuchKey")
+			return
+		}
+		w.Header().Set("ETag", etag(body))
+		w.Header().Set("Last-Modified", time.Now().UTC().Format(http.TimeFormat))
+		w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+		if r.Method == http.MethodGet {
+			w.Write(body)
+		}
+	case http.MethodDelete:
+		delete(objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func (f *fakeCOS) listBuckets(w http.ResponseWriter) {
+
+	f.mu.Lock()
+	result := cos.ServiceGetResult{Owner: &cos.Owner{ID: "owner"}}
+	for name := range f.buckets {
+		result.Buckets = append(result.Buckets, cos.Bucket{Name: name, CreationDate: time.Now().UTC().Format(time.RFC3339)})
+	}
+	f.mu.Unlock()
+
+	xml.NewEncoder(w).Encode(result)
+}
+
+func (f *fakeCOS) listObjects(w http.ResponseWriter, bucket string, objects map[string][]byte, prefix string) {
+
+	result := cos.BucketGetResult{Name: bucket, Prefix: prefix, MaxKeys: 1000}
+	for k, v := range objects {
+		if strings.HasPrefix(k, prefix) {
+			result.Contents = append(result.Contents, cos.Object{
+				Key:          k,
+				ETag:         etag(v),
+				Size:         len(v),
+				LastModified: time.Now().UTC().Format(time.RFC3339),
+			})
+		}
+	}
+	sort.Slice(result.Contents, func(i, j int) bool { return result.Contents[i].Key < result.Contents[j].Key })
+	xml.NewEncoder(w).Encode(result)
+}
+
+func etag(b []byte) string {
+	sum := md5.Sum(b)
+	return `"` + hex.EncodeToString(sum[:]) + `"`
+}
+
+// newFakeProto 创建连接到 server 的 cosProto，所有域名都解析到 server
+func newFakeProto(t *testing.T, server *httptest.Server) *cosProto {
+
+	p, err := New().NewS3Protocol(auth.Credentials{AccessKey: "id", SecretKey: "key"}, fakeRegion, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	proto := p.(*cosProto)
+	proto.cosURI = "http://%v.cos.%s.myqcloud.com"
+	proto.serviceURL, _ = proto.serviceURL.Parse("http://" + fakeServiceHost)
+
+	addr := server.Listener.Addr().String()
+	proto.httpClient.Transport.(*cos.AuthorizationTransport).Transport = &http.Transport{
+		DialContext: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, network, addr)
+		},
+	}
+	return proto
+}
+
+func TestCOSConcurrentBuckets(t *testing.T) {
+
+	convey.Convey("cos concurrent buckets", t, func() {
+
+		server := httptest.NewServer(newFakeCOS())
+		defer server.Close()
+
+		p := newFakeProto(t, server)
+		ctx := context.Background()
+
+		const buckets, objects = 8, 16
+
+		errs := make(chan error, buckets*objects*4)
+		var wg sync.WaitGroup
+
+		for b := 0; b < buckets; b++ {
+			bucket := fmt.Sprintf("bk%d", b)
+			_, _, err := p.CreateBucketWithContext(ctx, &s3.CreateBucketInput{Bucket: aws.String(bucket)})
+			convey.So(err, convey.ShouldBeNil)
+
+			for o := 0; o < objects; o++ {
+				wg.Add(1)
+				go func(bucket, key string) {
+					defer wg.Done()
+
+					body := bucket + "/" + key
+					_, _, err := p.PutObjectWithContext(ctx, &s3.PutObjectInput{
+						Bucket: aws.String(bucket),
+						Key:    aws.String(key),
+						Body:   strings.NewReader(body),
+					})
+					if err != nil {
+						errs <- err
+						return
+					}
+
+					head, _, err := p.HeadObjectWithContext(ctx, &s3.HeadObjectInput{Bucket: aws.String(bucket), Key: aws.String(key)})
+					if err != nil {
+						errs <- err
+						return
+					}
+					if aws.Int64Value(head.ContentLength) != int64(len(body)) {
+						errs <- fmt.Errorf("head %s: size %d", body, aws.Int64Value(head.ContentLength))
+					}
+
+					get, _, err := p.GetObjectWithContext(ctx, &s3.GetObjectInput{Bucket: aws.String(bucket), Key: aws.String(key)})
+					if err != nil {
+						errs <- err
+						return
+					}
+					got, _ := ioutil.ReadAll(get.Body)
+					get.Body.Close()
+					if string(got) != body {
+						errs <- fmt.Errorf("get %s: got %q", body, got)
+					}
+
+					if _, _, err := p.ListBucketsWithContext(ctx, &s3.ListBucketsInput{}); err != nil {
+						errs <- err
+					}
+				}(bucket, fmt.Sprintf("obj%d", o))
+			}
+		}
+		wg.Wait()
+		close(errs)
+
+		for err := range errs {
+			convey.So(err, convey.ShouldBeNil)
+		}
+
+		var lwg sync.WaitGroup
+		counts := make([]int, buckets)
+		for b := 0; b < buckets; b++ {
+			lwg.Add(1)
+			go func(b int) {
+				defer lwg.Done()
+				list, _, err := p.ListObjectsWithContext(ctx, &s3.ListObjectsInput{Bucket: aws.String(fmt.Sprintf("bk%d", b))})
+				if err == nil {
+					counts[b] = len(list.Contents)
+				}
+			}(b)
+		}
+		lwg.Wait()
+		for b := 0; b < buckets; b++ {
+			convey.So(counts[b], convey.ShouldEqual, objects)
+		}
+
+		list, _, err := p.ListBucketsWithContext(ctx, &s3.ListBucketsInput{})
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(len(list.Buckets), convey.ShouldEqual, buckets)
+	})
+}
+
+func TestCOSErrors(t *testing.T) {
+
+	convey.Convey("cos errors", t, func() {
+
+		server := httptest.NewServer(newFakeCOS())
+		p := newFakeProto(t, server)
+		ctx := context.Background()
+
+		_, resp, err := p.GetObjectWithContext(ctx, &s3.GetObjectInput{Bucket: aws.String("none"), Key: aws.String("a")})
+		convey.So(resp, convey.ShouldNotBeNil)
+		convey.So(resp.Header.Get(responseRequestIDKey), convey.ShouldNotBeEmpty)
+		convey.So(err.(awserr.RequestFailure).StatusCode(), convey.ShouldEqual, http.StatusNotFound)
+		convey.So(err.(awserr.Error).Code(), convey.ShouldEqual, "NoSuchBucket")
+
+		// 服务不可用时也要返回非 nil 的 Response
+		server.Close()
+		_, resp, err = p.GetObjectWithContext(ctx, &s3.GetObjectInput{Bucket: aws.String("none"), Key: aws.String("a")})
+		convey.So(err, convey.ShouldNotBeNil)
+		convey.So(resp, convey.ShouldNotBeNil)
+	})
+}
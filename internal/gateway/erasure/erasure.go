@@ -0,0 +1,754 @@
+package erasure
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/solution9th/S3Adapter/internal/gateway"
+	"github.com/solution9th/S3Adapter/internal/reqinfo"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// 分片清单保存在每个分片的元数据中，任意一个分片都可以得到完整的清单
+const (
+	metaPrefix  = "Ec-"
+	metaVersion = "Ec-Version"
+	metaData    = "Ec-Data"
+	metaParity  = "Ec-Parity"
+	metaIndex   = "Ec-Index"
+	metaSize    = "Ec-Size"
+	metaETag    = "Ec-Etag"
+
+	manifestVersion = "1"
+
+	// listHeadConcurrency 列举时并发读取分片清单的数量
+	listHeadConcurrency = 16
+)
+
+// manifest 分片清单
+type manifest struct {
+	data, parity int
+	index        int
+	size         int64
+	etag         string
+}
+
+func (m manifest) metadata(user map[string]*string) map[string]*string {
+
+	meta := make(map[string]*string, len(user)+6)
+	for k, v := range user {
+		if !isManifestKey(k) {
+			meta[k] = v
+		}
+	}
+	meta[metaVersion] = aws.String(manifestVersion)
+	meta[metaData] = aws.String(strconv.Itoa(m.data))
+	meta[metaParity] = aws.String(strconv.Itoa(m.parity))
+	meta[metaIndex] = aws.String(strconv.Itoa(m.index))
+	meta[metaSize] = aws.String(strconv.FormatInt(m.size, 10))
+	meta[metaETag] = aws.String(m.etag)
+	return meta
+}
+
+func isManifestKey(k string) bool {
+	return len(k) >= len(metaPrefix) && strings.EqualFold(k[:len(metaPrefix)], metaPrefix)
+}
+
+// parseManifest 从分片的元数据中解析清单，同时返回用户的元数据
+//
+// 不同引擎返回的元数据 key 大小写不同，统一不区分大小写
+func parseManifest(meta map[string]*string) (manifest, map[string]*string, bool) {
+
+	var (
+		m    manifest
+		user = make(map[string]*string)
+		get  = make(map[string]string)
+	)
+	for k, v := range meta {
+		if isManifestKey(k) {
+			get[http.CanonicalHeaderKey(k)] = aws.StringValue(v)
+			continue
+		}
+		user[k] = v
+	}
+
+	if get[metaVersion] != manifestVersion {
+		return m, user, false
+	}
+
+	var err error
+	if m.data, err = strconv.Atoi(get[metaData]); err != nil {
+		return m, user, false
+	}
+	if m.parity, err = strconv.Atoi(get[metaParity]); err != nil {
+		return m, user, false
+	}
+	if m.index, err = strconv.Atoi(get[metaIndex]); err != nil {
+		return m, user, false
+	}
+	if m.size, err = strconv.ParseInt(get[metaSize], 10, 64); err != nil {
+		return m, user, false
+	}
+	m.etag = get[metaETag]
+	return m, user, m.etag != ""
+}
+
+func newS3Err(code string, statusCode int, message string) awserr.RequestFailure {
+	return awserr.NewRequestFailure(awserr.New(code, message, nil), statusCode, "")
+}
+
+func errTooFewShards() error {
+	return newS3Err("ServiceUnavailable", http.StatusServiceUnavailable, "Not enough shards are available")
+}
+
+// Erasure 把对象用 Reed-Solomon 编码为 k 个数据块和 m 个校验块，
+// 第 i 个块保存在第 i 个引擎中相同的 bucket 和 key 下，最多 m 个引擎不可用时仍然可以读取
+type Erasure struct {
+	backends []gateway.S3Protocol
+	coder    *Coder
+
+	// orphanGrace 修复时残留分片的保留时间
+	orphanGrace time.Duration
+}
+
+// New 创建纠删码存储，backends 的数量必须为 dataShards+parityShards
+func New(backends []gateway.S3Protocol, dataShards, parityShards int) (*Erasure, error) {
+
+	coder, err := NewCoder(dataShards, parityShards)
+	if err != nil {
+		return nil, err
+	}
+	if len(backends) != dataShards+parityShards {
+		return nil, ErrInvalidShards
+	}
+
+	return &Erasure{
+		backends:    backends,
+		coder:       coder,
+		orphanGrace: orphanGrace,
+	}, nil
+}
+
+// Capabilities 所有引擎都支持的方法和特性，Range、条件请求和复制由纠删码层自己实现
+func (e *Erasure) Capabilities() gateway.Capabilities {
+
+	caps := gateway.FullCapabilities()
+	for _, p := range e.backends {
+		caps = caps.Intersect(gateway.CapabilitiesOf(p))
+	}
+	if caps.Supports(gateway.OpGetObject) && caps.Supports(gateway.OpPutObject) && !caps.Supports(gateway.OpCopyObject) {
+		caps.Operations = append(caps.Operations, gateway.OpCopyObject)
+	}
+	for _, f := range []string{gateway.FeatureRange, gateway.FeatureConditional} {
+		if !caps.Has(f) {
+			caps.Features = append(caps.Features, f)
+		}
+	}
+	return caps
+}
+
+// writeQuorum 写入成功至少需要的分片数，必须超过半数，
+// 避免两个并发写入的不同版本同时成功，并且至少 k 个分片才能恢复
+func (e *Erasure) writeQuorum() int {
+	k, m := e.coder.DataShards(), e.coder.ParityShards()
+	quorum := (k+m)/2 + 1
+	if quorum < k {
+		quorum = k
+	}
+	return quorum
+}
+
+// fanout 并发在每个引擎上执行 f，返回每个引擎的错误
+func (e *Erasure) fanout(f func(i int, p gateway.S3Protocol) error) []error {
+
+	errs := make([]error, len(e.backends))
+
+	var wg sync.WaitGroup
+	for i, p := range e.backends {
+		wg.Add(1)
+		go func(i int, p gateway.S3Protocol) {
+			defer wg.Done()
+			errs[i] = f(i, p)
+		}(i, p)
+	}
+	wg.Wait()
+	return errs
+}
+
+// reduce 成功的数量达到 quorum 时返回 nil，否则返回第一个错误，
+// ignore 为真的错误视为成功，但是全部引擎都是这种错误时返回该错误
+func reduce(errs []error, quorum int, ignore func(error) bool) error {
+
+	var (
+		ok, ignored int
+		first       error
+	)
+	for _, err := range errs {
+		switch {
+		case err == nil:
+			ok++
+		case ignore != nil && ignore(err):
+			ignored++
+			if first == nil {
+				first = err
+			}
+		default:
+			if first == nil || (ignore != nil && ignore(first)) {
+				first = err
+			}
+		}
+	}
+
+	if ok == 0 && ignored == len(errs) {
+		return first
+	}
+	if ok+ignored >= quorum {
+		return nil
+	}
+	if first == nil {
+		return errTooFewShards()
+	}
+	return first
+}
+
+// =================
+// Bucket operations
+// =================
+
+func (e *Erasure) CreateBucketWithContext(ctx context.Context, input *s3.CreateBucketInput, opts ...request.Option) (*s3.CreateBucketOutput, *http.Response, error) {
+
+	errs := e.fanout(func(i int, p gateway.S3Protocol) error {
+		_, _, err := p.CreateBucketWithContext(ctx, input, opts...)
+		return err
+	})
+
+	err := reduce(errs, e.writeQuorum(), func(err error) bool { return gateway.IsCode(err, "BucketAlreadyOwnedByYou") })
+	if err != nil {
+		return nil, gateway.Response(nil), err
+	}
+	return &s3.CreateBucketOutput{Location: aws.String("/" + aws.StringValue(input.Bucket))}, gateway.Response(nil), nil
+}
+
+func (e *Erasure) HeadBucketWithContext(ctx context.Context, input *s3.HeadBucketInput, opts ...request.Option) (*s3.HeadBucketOutput, *http.Response, error) {
+
+	errs := e.fanout(func(i int, p gateway.S3Protocol) error {
+		_, _, err := p.HeadBucketWithContext(ctx, input, opts...)
+		return err
+	})
+
+	if err := reduce(errs, e.coder.DataShards(), nil); err != nil {
+		return nil, gateway.Response(nil), err
+	}
+	return &s3.HeadBucketOutput{}, gateway.Response(nil), nil
+}
+
+// ListBucketsWithContext 从第一个可用的引擎列举
+func (e *Erasure) ListBucketsWithContext(ctx context.Context, input *s3.ListBucketsInput, opts ...request.Option) (*s3.ListBucketsOutput, *http.Response, error) {
+
+	var err error
+	for _, p := range e.backends {
+		var output *s3.ListBucketsOutput
+		var resp *http.Response
+		output, resp, err = p.ListBucketsWithContext(ctx, input, opts...)
+		if err == nil {
+			return output, gateway.Response(resp), nil
+		}
+	}
+	return nil, gateway.Response(nil), err
+}
+
+func (e *Erasure) DeleteBucketWithContext(ctx context.Context, input *s3.DeleteBucketInput, opts ...request.Option) (*s3.DeleteBucketOutput, *http.Response, error) {
+
+	errs := e.fanout(func(i int, p gateway.S3Protocol) error {
+		_, _, err := p.DeleteBucketWithContext(ctx, &s3.DeleteBucketInput{Bucket: input.Bucket}, opts...)
+		return err
+	})
+
+	if err := reduce(errs, e.writeQuorum(), gateway.IsNotFound); err != nil {
+		return nil, gateway.Response(nil), err
+	}
+	return &s3.DeleteBucketOutput{}, gateway.Response(nil), nil
+}
+
+// fixListing 引擎中保存的是分片，根据分片清单修正对象的大小和 ETag
+func fixListing(ctx context.Context, p gateway.S3Protocol, bucket *string, contents []*s3.Object) {
+
+	sem := make(chan struct{}, listHeadConcurrency)
+	var wg sync.WaitGroup
+	for _, v := range contents {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(v *s3.Object) {
+			defer func() { <-sem; wg.Done() }()
+			head, _, err := p.HeadObjectWithContext(ctx, &s3.HeadObjectInput{Bucket: bucket, Key: v.Key})
+			if err != nil {
+				return
+			}
+			if m, _, ok := parseManifest(head.Metadata); ok {
+				v.Size = aws.Int64(m.size)
+				v.ETag = aws.String(strconv.Quote(m.etag))
+			}
+		}(v)
+	}
+	wg.Wait()
+}
+
+// ListObjectsWithContext 从第一个可用的引擎列举
+func (e *Erasure) ListObjectsWithContext(ctx context.Context, input *s3.ListObjectsInput, opts ...request.Option) (*s3.ListObjectsOutput, *http.Response, error) {
+
+	var err error
+	for _, p := range e.backends {
+		var output *s3.ListObjectsOutput
+		var resp *http.Response
+		output, resp, err = p.ListObjectsWithContext(ctx, input, opts...)
+		if err == nil {
+			fixListing(ctx, p, input.Bucket, output.Contents)
+			return output, gateway.Response(resp), nil
+		}
+		if gateway.IsNotFound(err) {
+			break
+		}
+	}
+	return nil, gateway.Response(nil), err
+}
+
+// ListObjectsWithContextV2 不同引擎的 ContinuationToken 不能通用，统一转换为 StartAfter
+func (e *Erasure) ListObjectsWithContextV2(ctx context.Context, input *s3.ListObjectsV2Input, opts ...request.Option) (*s3.ListObjectsV2Output, *http.Response, error) {
+
+	startAfter := aws.StringValue(input.StartAfter)
+	if input.ContinuationToken != nil {
+		token, err := base64.StdEncoding.DecodeString(aws.StringValue(input.ContinuationToken))
+		if err != nil {
+			return nil, gateway.Response(nil), newS3Err("InvalidArgument", http.StatusBadRequest, "The continuation token provided is incorrect")
+		}
+		if string(token) > startAfter {
+			startAfter = string(token)
+		}
+	}
+
+	shardInput := *input
+	shardInput.ContinuationToken = nil
+	if startAfter != "" {
+		shardInput.StartAfter = aws.String(startAfter)
+	}
+
+	var err error
+	for _, p := range e.backends {
+		var output *s3.ListObjectsV2Output
+		var resp *http.Response
+		output, resp, err = p.ListObjectsWithContextV2(ctx, &shardInput, opts...)
+		if err != nil {
+			if gateway.IsNotFound(err) {
+				break
+			}
+			continue
+		}
+
+		fixListing(ctx, p, input.Bucket, output.Contents)
+
+		output.StartAfter = input.StartAfter
+		output.ContinuationToken = input.ContinuationToken
+		output.NextContinuationToken = nil
+		if aws.BoolValue(output.IsTruncated) {
+			var last string
+			for _, v := range output.Contents {
+				if k := aws.StringValue(v.Key); k > last {
+					last = k
+				}
+			}
+			for _, v := range output.CommonPrefixes {
+				if k := aws.StringValue(v.Prefix); k > last {
+					last = k
+				}
+			}
+			output.NextContinuationToken = aws.String(base64.StdEncoding.EncodeToString([]byte(last)))
+		}
+		return output, gateway.Response(resp), nil
+	}
+	return nil, gateway.Response(nil), err
+}
+
+// =================
+// Object operations
+// =================
+
+// object 读取并恢复后的对象，分片保存在临时文件中，使用后需要关闭
+type object struct {
+	manifest
+	shards       shardFiles
+	shardSize    int64
+	user         map[string]*string
+	contentType  *string
+	lastModified *time.Time
+
+	// stale 缺失或者版本不一致，需要修复的分片
+	stale []int
+	// errs 每个分片读取的错误
+	errs []error
+}
+
+// Close 删除分片的临时文件
+func (o *object) Close() {
+	o.shards.Close()
+}
+
+// read 读取所有分片，选择版本一致且数量最多的分片恢复对象
+func (e *Erasure) read(ctx context.Context, bucket, key string, opts ...request.Option) (*object, error) {
+
+	n := len(e.backends)
+	type shard struct {
+		m           manifest
+		ok          bool
+		size        int64
+		user        map[string]*string
+		contentType *string
+		modified    *time.Time
+	}
+	shards := make([]shard, n)
+	files := make(shardFiles, n)
+
+	errs := e.fanout(func(i int, p gateway.S3Protocol) error {
+		get, _, err := p.GetObjectWithContext(ctx, &s3.GetObjectInput{
+			Bucket: aws.String(bucket),
+			Key:    aws.String(key),
+		}, opts...)
+		if err != nil {
+			return err
+		}
+		f, size, err := gateway.Spool(get.Body)
+		if err != nil {
+			return err
+		}
+		files[i] = f
+		m, user, ok := parseManifest(get.Metadata)
+		shards[i] = shard{m: m, ok: ok && m.index == i, size: size, user: user, contentType: get.ContentType, modified: get.LastModified}
+		return nil
+	})
+
+	// 按照 etag 分组，选择数量最多的一组
+	groups := make(map[string][]int)
+	var best string
+	for i, s := range shards {
+		if errs[i] != nil || !s.ok {
+			continue
+		}
+		groups[s.m.etag] = append(groups[s.m.etag], i)
+		if g := groups[s.m.etag]; len(g) > len(groups[best]) {
+			best = s.m.etag
+		}
+	}
+
+	k := e.coder.DataShards()
+	group := groups[best]
+	if len(group) < k {
+		files.Close()
+		o := &object{errs: errs}
+		if !allNotFound(errs) {
+			return o, errTooFewShards()
+		}
+		// 存在的分片不足以恢复，说明对象已经被删除或者写入失败
+		for _, err := range errs {
+			if gateway.IsCode(err, "NoSuchBucket") {
+				return o, err
+			}
+		}
+		return o, newS3Err("NoSuchKey", http.StatusNotFound, "The specified key does not exist.")
+	}
+
+	first := shards[group[0]]
+	if first.m.data != k || first.m.parity != e.coder.ParityShards() {
+		files.Close()
+		return nil, newS3Err("InternalError", http.StatusInternalServerError, "Shard layout does not match")
+	}
+
+	o := &object{
+		manifest:     first.m,
+		shards:       make(shardFiles, n),
+		shardSize:    e.coder.ShardSize(first.m.size),
+		user:         first.user,
+		contentType:  first.contentType,
+		lastModified: first.modified,
+		errs:         errs,
+	}
+	for _, i := range group {
+		if shards[i].size == o.shardSize {
+			o.shards[i], files[i] = files[i], nil
+		}
+	}
+	files.Close()
+	for i, f := range o.shards {
+		if f == nil {
+			o.stale = append(o.stale, i)
+		}
+	}
+
+	if len(o.stale) > n-k {
+		o.Close()
+		return o, errTooFewShards()
+	}
+	if err := e.reconstruct(o.shards, o.shardSize); err != nil {
+		o.Close()
+		return o, err
+	}
+	return o, nil
+}
+
+// allNotFound 除了成功读取的分片，其余分片都不存在
+func allNotFound(errs []error) bool {
+	for _, err := range errs {
+		if err != nil && !gateway.IsNotFound(err) {
+			return false
+		}
+	}
+	return true
+}
+
+func (o *object) quotedETag() string {
+	return strconv.Quote(o.etag)
+}
+
+// PutObjectWithContext 先写入临时文件，按条带编码后并发写入每个引擎，成功数量达到 writeQuorum 即成功
+func (e *Erasure) PutObjectWithContext(ctx context.Context, input *s3.PutObjectInput, opts ...request.Option) (*s3.PutObjectOutput, *http.Response, error) {
+
+	var body io.Reader = bytes.NewReader(nil)
+	if input.Body != nil {
+		body = input.Body
+	}
+
+	hash := md5.New()
+	spool, size, err := gateway.Spool(ioutil.NopCloser(io.TeeReader(body, hash)))
+	if err != nil {
+		return nil, gateway.Response(nil), err
+	}
+	defer spool.Close()
+	etag := hex.EncodeToString(hash.Sum(nil))
+
+	shards, err := e.encode(spool, size)
+	if err != nil {
+		return nil, gateway.Response(nil), err
+	}
+	defer shards.Close()
+
+	if err := e.writeShards(ctx, input, shards, size, etag, seq(len(e.backends)), opts...); err != nil {
+		return nil, gateway.Response(nil), err
+	}
+
+	return &s3.PutObjectOutput{ETag: aws.String(strconv.Quote(etag))}, gateway.Response(nil), nil
+}
+
+// writeShards 写入 indexes 指定的分片
+func (e *Erasure) writeShards(ctx context.Context, input *s3.PutObjectInput, shards shardFiles, size int64, etag string, indexes []int, opts ...request.Option) error {
+
+	want := make(map[int]bool, len(indexes))
+	for _, i := range indexes {
+		want[i] = true
+	}
+	shardSize := e.coder.ShardSize(size)
+
+	errs := e.fanout(func(i int, p gateway.S3Protocol) error {
+		if !want[i] {
+			return nil
+		}
+		shardInput := *input
+		shardInput.Body = shards.reader(i, shardSize)
+		shardInput.ContentLength = aws.Int64(shardSize)
+		shardInput.ContentMD5 = nil
+		shardInput.Metadata = manifest{
+			data:   e.coder.DataShards(),
+			parity: e.coder.ParityShards(),
+			index:  i,
+			size:   size,
+			etag:   etag,
+		}.metadata(input.Metadata)
+		_, _, err := p.PutObjectWithContext(ctx, &shardInput, opts...)
+		if err != nil {
+			reqinfo.ZError(ctx).Str("Bucket", aws.StringValue(input.Bucket)).Str("Object", aws.StringValue(input.Key)).Int("Shard", i).Msg("[Erasure] put shard error:" + err.Error())
+		}
+		return err
+	})
+
+	quorum := e.writeQuorum()
+	if len(indexes) < len(e.backends) {
+		quorum = len(indexes)
+	}
+	return reduce(errs, quorum, nil)
+}
+
+func (e *Erasure) GetObjectWithContext(ctx context.Context, input *s3.GetObjectInput, opts ...request.Option) (*s3.GetObjectOutput, *http.Response, error) {
+
+	o, err := e.read(ctx, aws.StringValue(input.Bucket), aws.StringValue(input.Key), opts...)
+	if err != nil {
+		return nil, gateway.Response(nil), err
+	}
+	defer o.Close()
+	if len(o.stale) > 0 {
+		reqinfo.ZWarn(ctx).Str("Bucket", aws.StringValue(input.Bucket)).Str("Object", aws.StringValue(input.Key)).Int("Stale", len(o.stale)).Msg("[Erasure] degraded read")
+	}
+
+	var modified time.Time
+	if o.lastModified != nil {
+		modified = *o.lastModified
+	}
+	if err := gateway.CheckPreconditions(o.quotedETag(), modified, input.IfMatch, input.IfNoneMatch, input.IfModifiedSince, input.IfUnmodifiedSince); err != nil {
+		return nil, gateway.Response(nil), err
+	}
+
+	output := &s3.GetObjectOutput{
+		AcceptRanges:  aws.String("bytes"),
+		ContentLength: aws.Int64(o.size),
+		ContentType:   o.contentType,
+		ETag:          aws.String(o.quotedETag()),
+		LastModified:  o.lastModified,
+		Metadata:      o.user,
+	}
+
+	offset, length := int64(0), o.size
+	if input.Range != nil {
+		offset, length, err = gateway.ParseRange(aws.StringValue(input.Range), o.size)
+		if err != nil {
+			return nil, gateway.Response(nil), err
+		}
+		output.ContentLength = aws.Int64(length)
+		output.ContentRange = aws.String(fmt.Sprintf("bytes %d-%d/%d", offset, offset+length-1, o.size))
+	}
+
+	// 分片的临时文件交给 Body，关闭 Body 时删除
+	output.Body = o.body(e.coder.DataShards(), offset, length)
+	return output, gateway.Response(nil), nil
+}
+
+// HeadObjectWithContext 只读取分片清单，至少 k 个分片的清单一致时对象可以读取
+func (e *Erasure) HeadObjectWithContext(ctx context.Context, input *s3.HeadObjectInput, opts ...request.Option) (*s3.HeadObjectOutput, *http.Response, error) {
+
+	heads := make([]*s3.HeadObjectOutput, len(e.backends))
+	errs := e.fanout(func(i int, p gateway.S3Protocol) error {
+		head, _, err := p.HeadObjectWithContext(ctx, input, opts...)
+		heads[i] = head
+		return err
+	})
+
+	var (
+		groups = make(map[string][]int)
+		best   string
+	)
+	for i, head := range heads {
+		if errs[i] != nil {
+			continue
+		}
+		m, _, ok := parseManifest(head.Metadata)
+		if !ok || m.index != i {
+			continue
+		}
+		groups[m.etag] = append(groups[m.etag], i)
+		if len(groups[m.etag]) > len(groups[best]) {
+			best = m.etag
+		}
+	}
+
+	if len(groups[best]) < e.coder.DataShards() {
+		if allNotFound(errs) {
+			return nil, gateway.Response(nil), newS3Err("NotFound", http.StatusNotFound, "Not Found")
+		}
+		return nil, gateway.Response(nil), errTooFewShards()
+	}
+
+	head := heads[groups[best][0]]
+	m, user, _ := parseManifest(head.Metadata)
+
+	var modified time.Time
+	if head.LastModified != nil {
+		modified = *head.LastModified
+	}
+	if err := gateway.CheckPreconditions(strconv.Quote(m.etag), modified, input.IfMatch, input.IfNoneMatch, input.IfModifiedSince, input.IfUnmodifiedSince); err != nil {
+		return nil, gateway.Response(nil), err
+	}
+
+	return &s3.HeadObjectOutput{
+		AcceptRanges:  aws.String("bytes"),
+		ContentLength: aws.Int64(m.size),
+		ContentType:   head.ContentType,
+		ETag:          aws.String(strconv.Quote(m.etag)),
+		LastModified:  head.LastModified,
+		Metadata:      user,
+	}, gateway.Response(nil), nil
+}
+
+func (e *Erasure) DeleteObjectWithContext(ctx context.Context, input *s3.DeleteObjectInput, opts ...request.Option) (*s3.DeleteObjectOutput, *http.Response, error) {
+
+	errs := e.fanout(func(i int, p gateway.S3Protocol) error {
+		shardInput := *input
+		_, _, err := p.DeleteObjectWithContext(ctx, &shardInput, opts...)
+		return err
+	})
+
+	if err := reduce(errs, e.writeQuorum(), gateway.IsNotFound); err != nil && !gateway.IsCode(err, "NoSuchKey") {
+		return nil, gateway.Response(nil), err
+	}
+	return &s3.DeleteObjectOutput{}, gateway.Response(nil), nil
+}
+
+// CopyObjectWithContext 读取并恢复源对象后重新编码写入
+func (e *Erasure) CopyObjectWithContext(ctx context.Context, input *s3.CopyObjectInput, opts ...request.Option) (*s3.CopyObjectOutput, *http.Response, error) {
+
+	srcBucket, srcObject, ok := gateway.ParseCopySource(aws.StringValue(input.CopySource))
+	if !ok {
+		return nil, gateway.Response(nil), newS3Err("InvalidArgument", http.StatusBadRequest, "Copy Source must mention the source bucket and key: sourcebucket/sourcekey")
+	}
+
+	o, err := e.read(ctx, srcBucket, srcObject, opts...)
+	if err != nil {
+		return nil, gateway.Response(nil), err
+	}
+	defer o.Close()
+
+	var modified time.Time
+	if o.lastModified != nil {
+		modified = *o.lastModified
+	}
+	if err := gateway.CheckPreconditions(o.quotedETag(), modified, input.CopySourceIfMatch, input.CopySourceIfNoneMatch,
+		input.CopySourceIfModifiedSince, input.CopySourceIfUnmodifiedSince); err != nil {
+		if gateway.IsCode(err, "NotModified") {
+			err = newS3Err("PreconditionFailed", http.StatusPreconditionFailed, "At least one of the pre-conditions you specified did not hold")
+		}
+		return nil, gateway.Response(nil), err
+	}
+
+	putInput := &s3.PutObjectInput{
+		Bucket:      input.Bucket,
+		Key:         input.Key,
+		Metadata:    o.user,
+		ContentType: o.contentType,
+	}
+	if aws.StringValue(input.MetadataDirective) == s3.MetadataDirectiveReplace {
+		putInput.Metadata = input.Metadata
+		putInput.ContentType = input.ContentType
+	}
+
+	if err := e.writeShards(ctx, putInput, o.shards, o.size, o.etag, seq(len(e.backends)), opts...); err != nil {
+		return nil, gateway.Response(nil), err
+	}
+
+	return &s3.CopyObjectOutput{
+		CopyObjectResult: &s3.CopyObjectResult{
+			ETag:         aws.String(o.quotedETag()),
+			LastModified: aws.Time(time.Now().UTC()),
+		},
+	}, gateway.Response(nil), nil
+}
+
+var _ gateway.S3Protocol = (*Erasure)(nil)
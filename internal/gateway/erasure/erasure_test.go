@@ -0,0 +1,515 @@
+package erasure
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/solution9th/S3Adapter/internal/gateway"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/smartystreets/goconvey/convey"
+)
+
+type memObject struct {
+	data        []byte
+	metadata    map[string]*string
+	contentType *string
+	modified    time.Time
+}
+
+// memProto 内存中的 S3Protocol，down 时所有请求返回 503
+type memProto struct {
+	gateway.GatewayUnsupported
+
+	mu      sync.Mutex
+	down    bool
+	buckets map[string]map[string]*memObject
+}
+
+func newMemProto() *memProto {
+	return &memProto{buckets: make(map[string]map[string]*memObject)}
+}
+
+func emptyResp() *http.Response { return &http.Response{Header: make(http.Header)} }
+
+func memErr(code string, status int) error {
+	return awserr.NewRequestFailure(awserr.New(code, code, nil), status, "")
+}
+
+func (m *memProto) setDown(down bool) {
+	m.mu.Lock()
+	m.down = down
+	m.mu.Unlock()
+}
+
+func (m *memProto) lock() error {
+	m.mu.Lock()
+	if m.down {
+		m.mu.Unlock()
+		return memErr("ServiceUnavailable", http.StatusServiceUnavailable)
+	}
+	return nil
+}
+
+func (m *memProto) CreateBucketWithContext(ctx context.Context, input *s3.CreateBucketInput, opts ...request.Option) (*s3.CreateBucketOutput, *http.Response, error) {
+	if err := m.lock(); err != nil {
+		return nil, emptyResp(), err
+	}
+	defer m.mu.Unlock()
+	if _, ok := m.buckets[*input.Bucket]; ok {
+		return nil, emptyResp(), memErr("BucketAlreadyOwnedByYou", http.StatusConflict)
+	}
+	m.buckets[*input.Bucket] = make(map[string]*memObject)
+	return &s3.CreateBucketOutput{}, emptyResp(), nil
+}
+
+func (m *memProto) HeadBucketWithContext(ctx context.Context, input *s3.HeadBucketInput, opts ...request.Option) (*s3.HeadBucketOutput, *http.Response, error) {
+	if err := m.lock(); err != nil {
+		return nil, emptyResp(), err
+	}
+	defer m.mu.Unlock()
+	if _, ok := m.buckets[*input.Bucket]; !ok {
+		return nil, emptyResp(), memErr("NotFound", http.StatusNotFound)
+	}
+	return &s3.HeadBucketOutput{}, emptyResp(), nil
+}
+
+func (m *memProto) ListBucketsWithContext(ctx context.Context, input *s3.ListBucketsInput, opts ...request.Option) (*s3.ListBucketsOutput, *http.Response, error) {
+	if err := m.lock(); err != nil {
+		return nil, emptyResp(), err
+	}
+	defer m.mu.Unlock()
+	output := &s3.ListBucketsOutput{}
+	for k := range m.buckets {
+		output.Buckets = append(output.Buckets, &s3.Bucket{Name: aws.String(k)})
+	}
+	return output, emptyResp(), nil
+}
+
+func (m *memProto) DeleteBucketWithContext(ctx context.Context, input *s3.DeleteBucketInput, opts ...request.Option) (*s3.DeleteBucketOutput, *http.Response, error) {
+	if err := m.lock(); err != nil {
+		return nil, emptyResp(), err
+	}
+	defer m.mu.Unlock()
+	b, ok := m.buckets[*input.Bucket]
+	if !ok {
+		return nil, emptyResp(), memErr("NoSuchBucket", http.StatusNotFound)
+	}
+	if len(b) > 0 {
+		return nil, emptyResp(), memErr("BucketNotEmpty", http.StatusConflict)
+	}
+	delete(m.buckets, *input.Bucket)
+	return &s3.DeleteBucketOutput{}, emptyResp(), nil
+}
+
+func (m *memProto) ListObjectsWithContextV2(ctx context.Context, input *s3.ListObjectsV2Input, opts ...request.Option) (*s3.ListObjectsV2Output, *http.Response, error) {
+	if err := m.lock(); err != nil {
+		return nil, emptyResp(), err
+	}
+	defer m.mu.Unlock()
+	b, ok := m.buckets[*input.Bucket]
+	if !ok {
+		return nil, emptyResp(), memErr("NoSuchBucket", http.StatusNotFound)
+	}
+	maxKeys := aws.Int64Value(input.MaxKeys)
+	if maxKeys <= 0 {
+		maxKeys = 1000
+	}
+	keys := make([]string, 0, len(b))
+	for k := range b {
+		if k > aws.StringValue(input.StartAfter) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	output := &s3.ListObjectsV2Output{IsTruncated: aws.Bool(int64(len(keys)) > maxKeys)}
+	if int64(len(keys)) > maxKeys {
+		keys = keys[:maxKeys]
+	}
+	for _, k := range keys {
+		output.Contents = append(output.Contents, &s3.Object{Key: aws.String(k), Size: aws.Int64(int64(len(b[k].data)))})
+	}
+	output.KeyCount = aws.Int64(int64(len(keys)))
+	return output, emptyResp(), nil
+}
+
+func (m *memProto) ListObjectsWithContext(ctx context.Context, input *s3.ListObjectsInput, opts ...request.Option) (*s3.ListObjectsOutput, *http.Response, error) {
+	output, resp, err := m.ListObjectsWithContextV2(ctx, &s3.ListObjectsV2Input{Bucket: input.Bucket, StartAfter: input.Marker, MaxKeys: input.MaxKeys})
+	if err != nil {
+		return nil, resp, err
+	}
+	return &s3.ListObjectsOutput{Contents: output.Contents, IsTruncated: output.IsTruncated}, resp, nil
+}
+
+func (m *memProto) PutObjectWithContext(ctx context.Context, input *s3.PutObjectInput, opts ...request.Option) (*s3.PutObjectOutput, *http.Response, error) {
+	data, _ := ioutil.ReadAll(input.Body)
+	if err := m.lock(); err != nil {
+		return nil, emptyResp(), err
+	}
+	defer m.mu.Unlock()
+	b, ok := m.buckets[*input.Bucket]
+	if !ok {
+		return nil, emptyResp(), memErr("NoSuchBucket", http.StatusNotFound)
+	}
+	// 模拟后端返回小写的元数据 key
+	meta := make(map[string]*string)
+	for k, v := range input.Metadata {
+		meta[strings.ToLower(k)] = v
+	}
+	b[*input.Key] = &memObject{data: data, metadata: meta, contentType: input.ContentType, modified: time.Now().UTC().Truncate(time.Second)}
+	return &s3.PutObjectOutput{}, emptyResp(), nil
+}
+
+func (m *memProto) get(bucket, key string) (*memObject, error) {
+	if err := m.lock(); err != nil {
+		return nil, err
+	}
+	defer m.mu.Unlock()
+	b, ok := m.buckets[bucket]
+	if !ok {
+		return nil, memErr("NoSuchBucket", http.StatusNotFound)
+	}
+	o, ok := b[key]
+	if !ok {
+		return nil, memErr("NoSuchKey", http.StatusNotFound)
+	}
+	return o, nil
+}
+
+func (m *memProto) GetObjectWithContext(ctx context.Context, input *s3.GetObjectInput, opts ...request.Option) (*s3.GetObjectOutput, *http.Response, error) {
+	o, err := m.get(*input.Bucket, *input.Key)
+	if err != nil {
+		return nil, emptyResp(), err
+	}
+	return &s3.GetObjectOutput{
+		Body:          ioutil.NopCloser(bytes.NewReader(o.data)),
+		ContentLength: aws.Int64(int64(len(o.data))),
+		ContentType:   o.contentType,
+		Metadata:      o.metadata,
+		LastModified:  aws.Time(o.modified),
+	}, emptyResp(), nil
+}
+
+func (m *memProto) HeadObjectWithContext(ctx context.Context, input *s3.HeadObjectInput, opts ...request.Option) (*s3.HeadObjectOutput, *http.Response, error) {
+	o, err := m.get(*input.Bucket, *input.Key)
+	if err != nil {
+		return nil, emptyResp(), memErr("NotFound", http.StatusNotFound)
+	}
+	return &s3.HeadObjectOutput{
+		ContentLength: aws.Int64(int64(len(o.data))),
+		ContentType:   o.contentType,
+		Metadata:      o.metadata,
+		LastModified:  aws.Time(o.modified),
+	}, emptyResp(), nil
+}
+
+func (m *memProto) DeleteObjectWithContext(ctx context.Context, input *s3.DeleteObjectInput, opts ...request.Option) (*s3.DeleteObjectOutput, *http.Response, error) {
+	if err := m.lock(); err != nil {
+		return nil, emptyResp(), err
+	}
+	defer m.mu.Unlock()
+	if b, ok := m.buckets[*input.Bucket]; ok {
+		delete(b, *input.Key)
+	}
+	return &s3.DeleteObjectOutput{}, emptyResp(), nil
+}
+
+func (m *memProto) has(bucket, key string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, ok := m.buckets[bucket][key]
+	return ok
+}
+
+// age 把对象的修改时间提前 d
+func (m *memProto) age(bucket, key string, d time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if o, ok := m.buckets[bucket][key]; ok {
+		o.modified = o.modified.Add(-d)
+	}
+}
+
+func (m *memProto) remove(bucket, key string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.buckets[bucket], key)
+}
+
+func readBody(output *s3.GetObjectOutput) string {
+	b, _ := ioutil.ReadAll(output.Body)
+	output.Body.Close()
+	return string(b)
+}
+
+func TestErasure(t *testing.T) {
+
+	convey.Convey("erasure", t, func() {
+
+		ctx := context.Background()
+
+		mems := []*memProto{newMemProto(), newMemProto(), newMemProto(), newMemProto(), newMemProto()}
+		backends := make([]gateway.S3Protocol, len(mems))
+		for i, m := range mems {
+			backends[i] = m
+		}
+
+		_, err := New(backends, 3, 1)
+		convey.So(err, convey.ShouldEqual, ErrInvalidShards)
+
+		e, err := New(backends, 3, 2)
+		convey.So(err, convey.ShouldBeNil)
+
+		bucket := aws.String("bk")
+		_, resp, err := e.CreateBucketWithContext(ctx, &s3.CreateBucketInput{Bucket: bucket})
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(resp, convey.ShouldNotBeNil)
+
+		_, _, err = e.CreateBucketWithContext(ctx, &s3.CreateBucketInput{Bucket: bucket})
+		convey.So(err, convey.ShouldNotBeNil)
+
+		data := make([]byte, 1000)
+		rand.New(rand.NewSource(1)).Read(data)
+
+		put, _, err := e.PutObjectWithContext(ctx, &s3.PutObjectInput{
+			Bucket:      bucket,
+			Key:         aws.String("a.bin"),
+			Body:        bytes.NewReader(data),
+			ContentType: aws.String("application/octet-stream"),
+			Metadata:    map[string]*string{"Foo": aws.String("bar")},
+		})
+		convey.So(err, convey.ShouldBeNil)
+
+		for _, m := range mems {
+			convey.So(len(m.buckets["bk"]["a.bin"].data), convey.ShouldEqual, 334)
+		}
+
+		convey.Convey("read", func() {
+			get, _, err := e.GetObjectWithContext(ctx, &s3.GetObjectInput{Bucket: bucket, Key: aws.String("a.bin")})
+			convey.So(err, convey.ShouldBeNil)
+			convey.So(readBody(get), convey.ShouldEqual, string(data))
+			convey.So(aws.StringValue(get.ETag), convey.ShouldEqual, aws.StringValue(put.ETag))
+			convey.So(aws.StringValue(get.ContentType), convey.ShouldEqual, "application/octet-stream")
+			convey.So(aws.StringValue(get.Metadata["foo"]), convey.ShouldEqual, "bar")
+			convey.So(len(get.Metadata), convey.ShouldEqual, 1)
+
+			get, _, err = e.GetObjectWithContext(ctx, &s3.GetObjectInput{Bucket: bucket, Key: aws.String("a.bin"), Range: aws.String("bytes=990-")})
+			convey.So(err, convey.ShouldBeNil)
+			convey.So(readBody(get), convey.ShouldEqual, string(data[990:]))
+			convey.So(aws.StringValue(get.ContentRange), convey.ShouldEqual, "bytes 990-999/1000")
+
+			_, _, err = e.GetObjectWithContext(ctx, &s3.GetObjectInput{Bucket: bucket, Key: aws.String("a.bin"), IfNoneMatch: put.ETag})
+			convey.So(err.(awserr.Error).Code(), convey.ShouldEqual, "NotModified")
+
+			head, _, err := e.HeadObjectWithContext(ctx, &s3.HeadObjectInput{Bucket: bucket, Key: aws.String("a.bin")})
+			convey.So(err, convey.ShouldBeNil)
+			convey.So(aws.Int64Value(head.ContentLength), convey.ShouldEqual, 1000)
+
+			list, _, err := e.ListObjectsWithContextV2(ctx, &s3.ListObjectsV2Input{Bucket: bucket})
+			convey.So(err, convey.ShouldBeNil)
+			convey.So(len(list.Contents), convey.ShouldEqual, 1)
+			convey.So(aws.Int64Value(list.Contents[0].Size), convey.ShouldEqual, 1000)
+
+			_, _, err = e.GetObjectWithContext(ctx, &s3.GetObjectInput{Bucket: bucket, Key: aws.String("none")})
+			convey.So(err.(awserr.Error).Code(), convey.ShouldEqual, "NoSuchKey")
+			_, _, err = e.HeadObjectWithContext(ctx, &s3.HeadObjectInput{Bucket: bucket, Key: aws.String("none")})
+			convey.So(err.(awserr.RequestFailure).StatusCode(), convey.ShouldEqual, http.StatusNotFound)
+		})
+
+		convey.Convey("degraded read", func() {
+			mems[0].setDown(true)
+			mems[3].setDown(true)
+
+			get, _, err := e.GetObjectWithContext(ctx, &s3.GetObjectInput{Bucket: bucket, Key: aws.String("a.bin")})
+			convey.So(err, convey.ShouldBeNil)
+			convey.So(readBody(get), convey.ShouldEqual, string(data))
+
+			head, _, err := e.HeadObjectWithContext(ctx, &s3.HeadObjectInput{Bucket: bucket, Key: aws.String("a.bin")})
+			convey.So(err, convey.ShouldBeNil)
+			convey.So(aws.StringValue(head.ETag), convey.ShouldEqual, aws.StringValue(put.ETag))
+
+			list, _, err := e.ListObjectsWithContext(ctx, &s3.ListObjectsInput{Bucket: bucket})
+			convey.So(err, convey.ShouldBeNil)
+			convey.So(aws.Int64Value(list.Contents[0].Size), convey.ShouldEqual, 1000)
+
+			mems[1].setDown(true)
+			_, _, err = e.GetObjectWithContext(ctx, &s3.GetObjectInput{Bucket: bucket, Key: aws.String("a.bin")})
+			convey.So(err.(awserr.RequestFailure).StatusCode(), convey.ShouldEqual, http.StatusServiceUnavailable)
+
+			// 写入需要至少 k 个分片
+			_, _, err = e.PutObjectWithContext(ctx, &s3.PutObjectInput{Bucket: bucket, Key: aws.String("b.bin"), Body: strings.NewReader("b")})
+			convey.So(err, convey.ShouldNotBeNil)
+		})
+
+		convey.Convey("stale shard is ignored", func() {
+			mems[4].setDown(true)
+			_, _, err := e.PutObjectWithContext(ctx, &s3.PutObjectInput{Bucket: bucket, Key: aws.String("a.bin"), Body: strings.NewReader("new content")})
+			convey.So(err, convey.ShouldBeNil)
+			mems[4].setDown(false)
+
+			get, _, err := e.GetObjectWithContext(ctx, &s3.GetObjectInput{Bucket: bucket, Key: aws.String("a.bin")})
+			convey.So(err, convey.ShouldBeNil)
+			convey.So(readBody(get), convey.ShouldEqual, "new content")
+
+			n, err := e.HealObject(ctx, "bk", "a.bin")
+			convey.So(err, convey.ShouldBeNil)
+			convey.So(n, convey.ShouldEqual, 1)
+			shards := e.coder.Split([]byte("new content"))
+			convey.So(e.coder.Encode(shards), convey.ShouldBeNil)
+			convey.So(mems[4].buckets["bk"]["a.bin"].data, convey.ShouldResemble, shards[4])
+		})
+
+		convey.Convey("copy and delete", func() {
+			_, _, err := e.CopyObjectWithContext(ctx, &s3.CopyObjectInput{Bucket: bucket, Key: aws.String("c.bin"), CopySource: aws.String("bk/a.bin")})
+			convey.So(err, convey.ShouldBeNil)
+
+			get, _, err := e.GetObjectWithContext(ctx, &s3.GetObjectInput{Bucket: bucket, Key: aws.String("c.bin")})
+			convey.So(err, convey.ShouldBeNil)
+			convey.So(readBody(get), convey.ShouldEqual, string(data))
+			convey.So(aws.StringValue(get.Metadata["foo"]), convey.ShouldEqual, "bar")
+
+			_, _, err = e.DeleteObjectWithContext(ctx, &s3.DeleteObjectInput{Bucket: bucket, Key: aws.String("c.bin")})
+			convey.So(err, convey.ShouldBeNil)
+			for _, m := range mems {
+				convey.So(m.has("bk", "c.bin"), convey.ShouldBeFalse)
+			}
+
+			_, _, err = e.DeleteObjectWithContext(ctx, &s3.DeleteObjectInput{Bucket: bucket, Key: aws.String("c.bin")})
+			convey.So(err, convey.ShouldBeNil)
+		})
+
+		convey.Convey("heal", func() {
+			for i := 0; i < 5; i++ {
+				_, _, err := e.PutObjectWithContext(ctx, &s3.PutObjectInput{Bucket: bucket, Key: aws.String(fmt.Sprintf("k%d", i)), Body: strings.NewReader(fmt.Sprintf("value %d", i))})
+				convey.So(err, convey.ShouldBeNil)
+			}
+
+			// 丢失分片，残留的分片，缺少 bucket
+			mems[1].remove("bk", "a.bin")
+			mems[2].remove("bk", "k1")
+			mems[0].remove("bk", "k2")
+			mems[1].remove("bk", "k2")
+			mems[2].remove("bk", "k2")
+			mems[3].remove("bk", "k2")
+
+			_, _, err := e.CreateBucketWithContext(ctx, &s3.CreateBucketInput{Bucket: aws.String("bk2")})
+			convey.So(err, convey.ShouldBeNil)
+			mems[3].mu.Lock()
+			delete(mems[3].buckets, "bk2")
+			mems[3].mu.Unlock()
+
+			result, err := e.Heal(ctx)
+			convey.So(err, convey.ShouldBeNil)
+			convey.So(result.Objects, convey.ShouldEqual, 6)
+			convey.So(result.Shards, convey.ShouldEqual, 2)
+			convey.So(result.Failed, convey.ShouldEqual, 0)
+
+			convey.So(mems[1].has("bk", "a.bin"), convey.ShouldBeTrue)
+			convey.So(mems[2].has("bk", "k1"), convey.ShouldBeTrue)
+			convey.So(mems[3].buckets, convey.ShouldContainKey, "bk2")
+
+			// 刚写入的残留分片可能属于正在写入的对象，超过 orphanGrace 才删除
+			convey.So(mems[4].has("bk", "k2"), convey.ShouldBeTrue)
+			mems[4].age("bk", "k2", orphanGrace)
+
+			result, err = e.Heal(ctx)
+			convey.So(err, convey.ShouldBeNil)
+			convey.So(result.Shards, convey.ShouldEqual, 1)
+			convey.So(mems[4].has("bk", "k2"), convey.ShouldBeFalse)
+
+			result, err = e.Heal(ctx)
+			convey.So(err, convey.ShouldBeNil)
+			convey.So(result.Shards, convey.ShouldEqual, 0)
+		})
+	})
+}
+
+func TestWriteQuorum(t *testing.T) {
+
+	convey.Convey("write quorum is a strict majority and at least k", t, func() {
+		for _, c := range []struct{ k, m, quorum int }{
+			{3, 2, 3},
+			{2, 2, 3},
+			{2, 4, 4},
+			{4, 2, 4},
+			{6, 3, 6},
+		} {
+			backends := make([]gateway.S3Protocol, c.k+c.m)
+			for i := range backends {
+				backends[i] = newMemProto()
+			}
+			e, err := New(backends, c.k, c.m)
+			convey.So(err, convey.ShouldBeNil)
+			convey.So(e.writeQuorum(), convey.ShouldEqual, c.quorum)
+		}
+	})
+}
+
+func TestStream(t *testing.T) {
+
+	convey.Convey("objects larger than a stripe are encoded through temp files", t, func() {
+
+		tmp, err := ioutil.TempDir("", "erasure-test")
+		convey.So(err, convey.ShouldBeNil)
+		defer os.RemoveAll(tmp)
+		old := os.Getenv("TMPDIR")
+		os.Setenv("TMPDIR", tmp)
+		defer os.Setenv("TMPDIR", old)
+
+		ctx := context.Background()
+		mems := []*memProto{newMemProto(), newMemProto(), newMemProto(), newMemProto(), newMemProto()}
+		backends := make([]gateway.S3Protocol, len(mems))
+		for i, m := range mems {
+			backends[i] = m
+		}
+		e, err := New(backends, 3, 2)
+		convey.So(err, convey.ShouldBeNil)
+
+		bucket := aws.String("bk")
+		_, _, err = e.CreateBucketWithContext(ctx, &s3.CreateBucketInput{Bucket: bucket})
+		convey.So(err, convey.ShouldBeNil)
+
+		data := make([]byte, 3*stripeSize+1234)
+		rand.New(rand.NewSource(2)).Read(data)
+		_, _, err = e.PutObjectWithContext(ctx, &s3.PutObjectInput{Bucket: bucket, Key: aws.String("big"), Body: bytes.NewReader(data)})
+		convey.So(err, convey.ShouldBeNil)
+
+		// 与一次编码整个对象的结果相同
+		shards := e.coder.Split(data)
+		convey.So(e.coder.Encode(shards), convey.ShouldBeNil)
+		for i, m := range mems {
+			convey.So(bytes.Equal(m.buckets["bk"]["big"].data, shards[i]), convey.ShouldBeTrue)
+		}
+
+		mems[0].setDown(true)
+		mems[2].setDown(true)
+
+		get, _, err := e.GetObjectWithContext(ctx, &s3.GetObjectInput{Bucket: bucket, Key: aws.String("big")})
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(readBody(get) == string(data), convey.ShouldBeTrue)
+
+		// 跨越两个数据块的范围
+		start, end := len(data)/3-10, 2*len(data)/3
+		get, _, err = e.GetObjectWithContext(ctx, &s3.GetObjectInput{Bucket: bucket, Key: aws.String("big"), Range: aws.String(fmt.Sprintf("bytes=%d-%d", start, end))})
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(readBody(get) == string(data[start:end+1]), convey.ShouldBeTrue)
+
+		// 临时文件在请求结束或者 Body 关闭后删除
+		files, err := ioutil.ReadDir(tmp)
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(len(files), convey.ShouldEqual, 0)
+	})
+}
@@ -0,0 +1,155 @@
+package erasure
+
+import "errors"
+
+var errSingular = errors.New("erasure: matrix is singular")
+
+// GF(2^8)，本原多项式 x^8 + x^4 + x^3 + x^2 + 1
+const gfPolynomial = 0x11d
+
+var (
+	gfExp [512]byte
+	gfLog [256]byte
+)
+
+func init() {
+	x := 1
+	for i := 0; i < 255; i++ {
+		gfExp[i] = byte(x)
+		gfLog[x] = byte(i)
+		x <<= 1
+		if x&0x100 != 0 {
+			x ^= gfPolynomial
+		}
+	}
+	for i := 255; i < len(gfExp); i++ {
+		gfExp[i] = gfExp[i-255]
+	}
+}
+
+func gfMul(a, b byte) byte {
+	if a == 0 || b == 0 {
+		return 0
+	}
+	return gfExp[int(gfLog[a])+int(gfLog[b])]
+}
+
+func gfDiv(a, b byte) byte {
+	if a == 0 {
+		return 0
+	}
+	if b == 0 {
+		panic("erasure: divide by zero")
+	}
+	return gfExp[int(gfLog[a])+255-int(gfLog[b])]
+}
+
+// gfPow a 的 n 次方
+func gfPow(a byte, n int) byte {
+	if n == 0 {
+		return 1
+	}
+	if a == 0 {
+		return 0
+	}
+	return gfExp[(int(gfLog[a])*n)%255]
+}
+
+// matrix GF(2^8) 上的矩阵
+type matrix [][]byte
+
+func newMatrix(rows, cols int) matrix {
+	m := make(matrix, rows)
+	for i := range m {
+		m[i] = make([]byte, cols)
+	}
+	return m
+}
+
+func identity(n int) matrix {
+	m := newMatrix(n, n)
+	for i := range m {
+		m[i][i] = 1
+	}
+	return m
+}
+
+// vandermonde rows x cols 的范德蒙矩阵，任意 cols 行线性无关
+func vandermonde(rows, cols int) matrix {
+	m := newMatrix(rows, cols)
+	for r := range m {
+		for c := range m[r] {
+			m[r][c] = gfPow(byte(r), c)
+		}
+	}
+	return m
+}
+
+func (m matrix) multiply(o matrix) matrix {
+	out := newMatrix(len(m), len(o[0]))
+	for r := range out {
+		for c := range out[r] {
+			var v byte
+			for i := range o {
+				v ^= gfMul(m[r][i], o[i][c])
+			}
+			out[r][c] = v
+		}
+	}
+	return out
+}
+
+func (m matrix) subRows(rows []int) matrix {
+	out := make(matrix, len(rows))
+	for i, r := range rows {
+		out[i] = append([]byte(nil), m[r]...)
+	}
+	return out
+}
+
+// invert 高斯消元求逆矩阵
+func (m matrix) invert() (matrix, error) {
+
+	n := len(m)
+	work := newMatrix(n, 2*n)
+	for r := range m {
+		copy(work[r], m[r])
+		work[r][n+r] = 1
+	}
+
+	for c := 0; c < n; c++ {
+		pivot := -1
+		for r := c; r < n; r++ {
+			if work[r][c] != 0 {
+				pivot = r
+				break
+			}
+		}
+		if pivot < 0 {
+			return nil, errSingular
+		}
+		work[c], work[pivot] = work[pivot], work[c]
+
+		if v := work[c][c]; v != 1 {
+			for i := range work[c] {
+				work[c][i] = gfDiv(work[c][i], v)
+			}
+		}
+
+		for r := 0; r < n; r++ {
+			if r == c || work[r][c] == 0 {
+				continue
+			}
+			f := work[r][c]
+			for i := range work[r] {
+				work[r][i] ^= gfMul(f, work[c][i])
+			}
+		}
+	}
+
+	out := make(matrix, n)
+	for r := range work {
+		out[r] = work[r][n:]
+	}
+	return out, nil
+}
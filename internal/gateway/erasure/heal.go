@@ -0,0 +1,255 @@
+package erasure
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/solution9th/S3Adapter/internal/gateway"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/haozibi/zlog"
+)
+
+// orphanGrace 残留分片至少存在这么久才删除，避免删除正在写入的对象已经写入的分片
+const orphanGrace = 15 * time.Minute
+
+// HealResult 修复结果
+type HealResult struct {
+	// Objects 检查的对象数量
+	Objects int
+	// Shards 重新写入或者删除的分片数量
+	Shards int
+	// Failed 修复失败的对象数量
+	Failed int
+}
+
+func (r *HealResult) add(o HealResult) {
+	r.Objects += o.Objects
+	r.Shards += o.Shards
+	r.Failed += o.Failed
+}
+
+// HealObject 重新写入缺失或者版本不一致的分片，返回修复的分片数量
+//
+// 存在的分片不足以恢复对象且其余分片都不存在时，说明对象已经删除或者正在写入，
+// 只删除超过 orphanGrace 的残留分片
+func (e *Erasure) HealObject(ctx context.Context, bucket, key string) (int, error) {
+
+	o, err := e.read(ctx, bucket, key)
+	if err != nil {
+		if !gateway.IsNotFound(err) {
+			return 0, err
+		}
+		return e.deleteOrphans(ctx, bucket, key, o.errs)
+	}
+	defer o.Close()
+
+	if len(o.stale) == 0 {
+		return 0, nil
+	}
+
+	input := &s3.PutObjectInput{
+		Bucket:      aws.String(bucket),
+		Key:         aws.String(key),
+		Metadata:    o.user,
+		ContentType: o.contentType,
+	}
+	if err := e.writeShards(ctx, input, o.shards, o.size, o.etag, o.stale); err != nil {
+		return 0, err
+	}
+
+	zlog.ZInfo().Str("Bucket", bucket).Str("Object", key).Int("Shards", len(o.stale)).Msg("[Erasure] heal")
+	return len(o.stale), nil
+}
+
+func (e *Erasure) deleteOrphans(ctx context.Context, bucket, key string, errs []error) (int, error) {
+
+	var orphans []int
+	for i, err := range errs {
+		if err != nil {
+			continue
+		}
+		// 重新读取修改时间，写入中的分片可能在 read 之后才出现
+		head, _, err := e.backends[i].HeadObjectWithContext(ctx, &s3.HeadObjectInput{
+			Bucket: aws.String(bucket),
+			Key:    aws.String(key),
+		})
+		if err != nil {
+			if gateway.IsNotFound(err) {
+				continue
+			}
+			return 0, err
+		}
+		if head.LastModified == nil || time.Since(*head.LastModified) < e.orphanGrace {
+			continue
+		}
+		orphans = append(orphans, i)
+	}
+
+	for _, i := range orphans {
+		_, _, err := e.backends[i].DeleteObjectWithContext(ctx, &s3.DeleteObjectInput{
+			Bucket: aws.String(bucket),
+			Key:    aws.String(key),
+		})
+		if err != nil && !gateway.IsNotFound(err) {
+			return 0, err
+		}
+	}
+
+	if len(orphans) > 0 {
+		zlog.ZInfo().Str("Bucket", bucket).Str("Object", key).Int("Shards", len(orphans)).Msg("[Erasure] delete orphan shards")
+	}
+	return len(orphans), nil
+}
+
+// HealBucket 修复 bucket 中所有引擎上出现过的对象
+//
+// 至少 k 个引擎存在 bucket 时在其余引擎上创建，否则说明 bucket 已经删除，
+// 修复对象(删除残留的分片)后删除残留的 bucket
+func (e *Erasure) HealBucket(ctx context.Context, bucket string) (HealResult, error) {
+
+	var result HealResult
+
+	exists := make([]bool, len(e.backends))
+	errs := e.fanout(func(i int, p gateway.S3Protocol) error {
+		_, _, err := p.HeadBucketWithContext(ctx, &s3.HeadBucketInput{Bucket: aws.String(bucket)})
+		exists[i] = err == nil
+		if gateway.IsNotFound(err) {
+			return nil
+		}
+		return err
+	})
+	if err := reduce(errs, len(e.backends), nil); err != nil {
+		return result, err
+	}
+
+	var count int
+	for _, ok := range exists {
+		if ok {
+			count++
+		}
+	}
+	deleted := count < e.coder.DataShards()
+
+	if !deleted {
+		errs = e.fanout(func(i int, p gateway.S3Protocol) error {
+			if exists[i] {
+				return nil
+			}
+			_, _, err := p.CreateBucketWithContext(ctx, &s3.CreateBucketInput{Bucket: aws.String(bucket)})
+			if err != nil && !gateway.IsCode(err, "BucketAlreadyOwnedByYou") {
+				return err
+			}
+			return nil
+		})
+		if err := reduce(errs, len(e.backends), nil); err != nil {
+			return result, err
+		}
+	}
+
+	keys, err := e.listAll(ctx, bucket, exists)
+	if err != nil {
+		return result, err
+	}
+
+	for _, key := range keys {
+		if ctx.Err() != nil {
+			return result, ctx.Err()
+		}
+		result.Objects++
+
+		n, err := e.HealObject(ctx, bucket, key)
+		if err != nil {
+			zlog.ZError().Str("Bucket", bucket).Str("Object", key).Msg("[Erasure] heal error:" + err.Error())
+			result.Failed++
+			continue
+		}
+		result.Shards += n
+	}
+
+	if deleted && result.Failed == 0 {
+		errs = e.fanout(func(i int, p gateway.S3Protocol) error {
+			if !exists[i] {
+				return nil
+			}
+			_, _, err := p.DeleteBucketWithContext(ctx, &s3.DeleteBucketInput{Bucket: aws.String(bucket)})
+			if err != nil && !gateway.IsNotFound(err) {
+				return err
+			}
+			return nil
+		})
+		if err := reduce(errs, len(e.backends), nil); err != nil {
+			return result, err
+		}
+		zlog.ZInfo().Str("Bucket", bucket).Int("Backends", count).Msg("[Erasure] delete orphan bucket")
+	}
+	return result, nil
+}
+
+// listAll 列举存在 bucket 的引擎中的对象，返回去重排序后的 key
+func (e *Erasure) listAll(ctx context.Context, bucket string, exists []bool) ([]string, error) {
+
+	seen := make(map[string]bool)
+	for i, p := range e.backends {
+		if !exists[i] {
+			continue
+		}
+		input := &s3.ListObjectsV2Input{Bucket: aws.String(bucket)}
+		for {
+			output, _, err := p.ListObjectsWithContextV2(ctx, input)
+			if err != nil {
+				return nil, err
+			}
+			var last string
+			for _, v := range output.Contents {
+				last = aws.StringValue(v.Key)
+				seen[last] = true
+			}
+			if !aws.BoolValue(output.IsTruncated) || last == "" {
+				break
+			}
+			input.StartAfter = aws.String(last)
+		}
+	}
+
+	keys := make([]string, 0, len(seen))
+	for k := range seen {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+// Heal 修复所有引擎上出现过的 bucket
+func (e *Erasure) Heal(ctx context.Context) (HealResult, error) {
+
+	var result HealResult
+
+	seen := make(map[string]bool)
+	for _, p := range e.backends {
+		output, _, err := p.ListBucketsWithContext(ctx, &s3.ListBucketsInput{})
+		if err != nil {
+			return result, err
+		}
+		for _, v := range output.Buckets {
+			seen[aws.StringValue(v.Name)] = true
+		}
+	}
+
+	buckets := make([]string, 0, len(seen))
+	for k := range seen {
+		buckets = append(buckets, k)
+	}
+	sort.Strings(buckets)
+
+	for _, bucket := range buckets {
+		r, err := e.HealBucket(ctx, bucket)
+		result.add(r)
+		if err != nil {
+			return result, err
+		}
+	}
+	return result, nil
+}
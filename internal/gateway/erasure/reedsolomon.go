@@ -0,0 +1,194 @@
+package erasure
+
+import (
+	"errors"
+)
+
+const (
+	// MaxShards 数据块和校验块的总数上限
+	MaxShards = 256
+)
+
+var (
+	// ErrInvalidShards invalid data or parity shard count
+	ErrInvalidShards = errors.New("erasure: invalid number of data or parity shards")
+	// ErrTooFewShards not enough shards to reconstruct
+	ErrTooFewShards = errors.New("erasure: too few shards to reconstruct")
+	// ErrShardSize shards have different size
+	ErrShardSize = errors.New("erasure: shards have different size")
+)
+
+// Coder Reed-Solomon 编码，k 个数据块和 m 个校验块，任意 k 个块可以恢复全部数据
+type Coder struct {
+	k, m int
+
+	// encode (k+m) x k 的编码矩阵，前 k 行为单位矩阵
+	encode matrix
+}
+
+// NewCoder 创建编码器，k 为数据块数量，m 为校验块数量
+func NewCoder(k, m int) (*Coder, error) {
+
+	if k <= 0 || m < 0 || k+m > MaxShards {
+		return nil, ErrInvalidShards
+	}
+
+	v := vandermonde(k+m, k)
+	top, err := v.subRows(seq(k)).invert()
+	if err != nil {
+		return nil, err
+	}
+
+	return &Coder{k: k, m: m, encode: v.multiply(top)}, nil
+}
+
+// DataShards 数据块数量
+func (c *Coder) DataShards() int { return c.k }
+
+// ParityShards 校验块数量
+func (c *Coder) ParityShards() int { return c.m }
+
+// ShardSize 长度为 size 的数据每个块的大小
+func (c *Coder) ShardSize(size int64) int64 {
+	return (size + int64(c.k) - 1) / int64(c.k)
+}
+
+// Split 把数据切分为 k 个数据块，最后一块补零，并分配 m 个校验块
+func (c *Coder) Split(data []byte) [][]byte {
+
+	size := int(c.ShardSize(int64(len(data))))
+	shards := make([][]byte, c.k+c.m)
+	for i := range shards {
+		shards[i] = make([]byte, size)
+		if i < c.k && i*size < len(data) {
+			copy(shards[i], data[i*size:])
+		}
+	}
+	return shards
+}
+
+// Encode 根据数据块计算校验块
+func (c *Coder) Encode(shards [][]byte) error {
+
+	if len(shards) != c.k+c.m {
+		return ErrInvalidShards
+	}
+	size := len(shards[0])
+	for _, s := range shards {
+		if len(s) != size {
+			return ErrShardSize
+		}
+	}
+
+	c.codeRows(c.encode[c.k:], shards[:c.k], shards[c.k:])
+	return nil
+}
+
+// codeRows out[i] = sum(rows[i][j] * in[j])
+func (c *Coder) codeRows(rows matrix, in, out [][]byte) {
+	for i, row := range rows {
+		o := out[i]
+		for b := range o {
+			o[b] = 0
+		}
+		for j, f := range row {
+			if f == 0 {
+				continue
+			}
+			for b, v := range in[j] {
+				o[b] ^= gfMul(f, v)
+			}
+		}
+	}
+}
+
+// Reconstruct 恢复缺失的块，缺失的块为 nil，至少需要 k 个块
+func (c *Coder) Reconstruct(shards [][]byte) error {
+
+	if len(shards) != c.k+c.m {
+		return ErrInvalidShards
+	}
+
+	var (
+		present []int
+		size    = -1
+	)
+	for i, s := range shards {
+		if s == nil {
+			continue
+		}
+		if size >= 0 && len(s) != size {
+			return ErrShardSize
+		}
+		size = len(s)
+		present = append(present, i)
+	}
+	if len(present) < c.k {
+		return ErrTooFewShards
+	}
+	if len(present) == len(shards) {
+		return nil
+	}
+
+	// 用任意 k 个块恢复数据块
+	rows := present[:c.k]
+	decode, err := c.encode.subRows(rows).invert()
+	if err != nil {
+		return err
+	}
+
+	in := make([][]byte, c.k)
+	for i, r := range rows {
+		in[i] = shards[r]
+	}
+
+	var (
+		missing    []int
+		missingOut [][]byte
+	)
+	for i := 0; i < c.k; i++ {
+		if shards[i] == nil {
+			shards[i] = make([]byte, size)
+			missing = append(missing, i)
+			missingOut = append(missingOut, shards[i])
+		}
+	}
+	if len(missing) > 0 {
+		c.codeRows(decode.subRows(missing), in, missingOut)
+	}
+
+	// 用数据块重新计算缺失的校验块
+	missing, missingOut = nil, nil
+	for i := c.k; i < c.k+c.m; i++ {
+		if shards[i] == nil {
+			shards[i] = make([]byte, size)
+			missing = append(missing, i)
+			missingOut = append(missingOut, shards[i])
+		}
+	}
+	if len(missing) > 0 {
+		c.codeRows(c.encode.subRows(missing), shards[:c.k], missingOut)
+	}
+	return nil
+}
+
+// Join 把数据块拼接为原始数据
+func (c *Coder) Join(shards [][]byte, size int64) []byte {
+
+	data := make([]byte, 0, size)
+	for i := 0; i < c.k && int64(len(data)) < size; i++ {
+		data = append(data, shards[i]...)
+	}
+	if int64(len(data)) > size {
+		data = data[:size]
+	}
+	return data
+}
+
+func seq(n int) []int {
+	s := make([]int, n)
+	for i := range s {
+		s[i] = i
+	}
+	return s
+}
@@ -0,0 +1,78 @@
+package erasure
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+
+	"github.com/smartystreets/goconvey/convey"
+)
+
+func TestGalois(t *testing.T) {
+
+	convey.Convey("galois", t, func() {
+		for a := 1; a < 256; a++ {
+			for b := 1; b < 256; b++ {
+				convey.So(gfDiv(gfMul(byte(a), byte(b)), byte(b)), convey.ShouldEqual, byte(a))
+			}
+		}
+		convey.So(gfMul(0, 7), convey.ShouldEqual, 0)
+		convey.So(gfPow(2, 8), convey.ShouldEqual, byte(gfPolynomial&0xff))
+
+		m := vandermonde(4, 4)
+		inv, err := m.invert()
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(m.multiply(inv), convey.ShouldResemble, identity(4))
+
+		_, err = matrix{{1, 1}, {1, 1}}.invert()
+		convey.So(err, convey.ShouldEqual, errSingular)
+	})
+}
+
+func TestCoder(t *testing.T) {
+
+	convey.Convey("reed solomon", t, func() {
+
+		_, err := NewCoder(0, 1)
+		convey.So(err, convey.ShouldEqual, ErrInvalidShards)
+		_, err = NewCoder(200, 57)
+		convey.So(err, convey.ShouldEqual, ErrInvalidShards)
+
+		r := rand.New(rand.NewSource(1))
+
+		for _, v := range []struct{ k, m, size int }{
+			{1, 1, 10}, {2, 1, 0}, {3, 2, 1000}, {4, 2, 1}, {6, 3, 4097},
+		} {
+			c, err := NewCoder(v.k, v.m)
+			convey.So(err, convey.ShouldBeNil)
+
+			data := make([]byte, v.size)
+			r.Read(data)
+
+			shards := c.Split(data)
+			convey.So(len(shards), convey.ShouldEqual, v.k+v.m)
+			convey.So(c.Encode(shards), convey.ShouldBeNil)
+			convey.So(c.Join(shards, int64(v.size)), convey.ShouldResemble, data)
+
+			// 任意丢失 m 个块都可以恢复
+			for n := 0; n < 20; n++ {
+				broken := make([][]byte, len(shards))
+				copy(broken, shards)
+				for _, i := range r.Perm(len(shards))[:v.m] {
+					broken[i] = nil
+				}
+				convey.So(c.Reconstruct(broken), convey.ShouldBeNil)
+				for i := range shards {
+					convey.So(bytes.Equal(broken[i], shards[i]), convey.ShouldBeTrue)
+				}
+			}
+
+			broken := make([][]byte, len(shards))
+			copy(broken, shards)
+			for _, i := range r.Perm(len(shards))[:v.m+1] {
+				broken[i] = nil
+			}
+			convey.So(c.Reconstruct(broken), convey.ShouldEqual, ErrTooFewShards)
+		}
+	})
+}
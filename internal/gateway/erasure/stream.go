@@ -0,0 +1,211 @@
+package erasure
+
+import (
+	"io"
+
+	"github.com/solution9th/S3Adapter/internal/gateway"
+)
+
+// stripeSize 编码和恢复时每个分片一次处理的字节数，内存中最多保存 (k+m) 个条带
+const stripeSize = 256 << 10
+
+// shardFiles 保存在临时文件中的分片，缺失的分片为 nil
+type shardFiles []*gateway.TempBody
+
+// Close 删除所有分片的临时文件
+func (s shardFiles) Close() {
+	for i, f := range s {
+		if f != nil {
+			f.Close()
+			s[i] = nil
+		}
+	}
+}
+
+// reader 分片 i 的内容，每次调用返回独立的读取位置
+func (s shardFiles) reader(i int, shardSize int64) io.ReadSeeker {
+	return io.NewSectionReader(s[i], 0, shardSize)
+}
+
+// readPadded 从 r 的 off 处读满 p，超过 size 的部分补零
+func readPadded(r io.ReaderAt, p []byte, off, size int64) error {
+
+	var n int
+	if off < size {
+		want := p
+		if int64(len(want)) > size-off {
+			want = want[:size-off]
+		}
+		var err error
+		n, err = r.ReadAt(want, off)
+		if n < len(want) {
+			if err == nil || err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return err
+		}
+	}
+	for i := n; i < len(p); i++ {
+		p[i] = 0
+	}
+	return nil
+}
+
+// stripes 按条带遍历长度为 shardSize 的分片，f 的参数为条带在分片中的偏移和长度
+func stripes(shardSize int64, f func(off int64, n int) error) error {
+
+	for off := int64(0); off < shardSize; off += stripeSize {
+		n := int64(stripeSize)
+		if shardSize-off < n {
+			n = shardSize - off
+		}
+		if err := f(off, int(n)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// encode 把 r 中长度为 size 的数据按条带编码，k 个数据块和 m 个校验块分别写入临时文件
+func (e *Erasure) encode(r io.ReaderAt, size int64) (shardFiles, error) {
+
+	k := e.coder.DataShards()
+	shardSize := e.coder.ShardSize(size)
+
+	files := make(shardFiles, len(e.backends))
+	for i := range files {
+		f, err := gateway.NewTempBody()
+		if err != nil {
+			files.Close()
+			return nil, err
+		}
+		files[i] = f
+	}
+
+	bufs := make([][]byte, len(files))
+	for i := range bufs {
+		bufs[i] = make([]byte, stripeSize)
+	}
+	chunks := make([][]byte, len(files))
+
+	err := stripes(shardSize, func(off int64, n int) error {
+		for i := range chunks {
+			chunks[i] = bufs[i][:n]
+		}
+		for i := 0; i < k; i++ {
+			if err := readPadded(r, chunks[i], int64(i)*shardSize+off, size); err != nil {
+				return err
+			}
+		}
+		if err := e.coder.Encode(chunks); err != nil {
+			return err
+		}
+		for i, f := range files {
+			if _, err := f.Write(chunks[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		files.Close()
+		return nil, err
+	}
+	return files, nil
+}
+
+// reconstruct 按条带恢复缺失的分片并写入新的临时文件，至少需要 k 个分片
+func (e *Erasure) reconstruct(files shardFiles, shardSize int64) error {
+
+	var missing []int
+	for i, f := range files {
+		if f == nil {
+			missing = append(missing, i)
+		}
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+	if len(files)-len(missing) < e.coder.DataShards() {
+		return ErrTooFewShards
+	}
+
+	for _, i := range missing {
+		f, err := gateway.NewTempBody()
+		if err != nil {
+			return err
+		}
+		files[i] = f
+	}
+
+	bufs := make([][]byte, len(files))
+	chunks := make([][]byte, len(files))
+	isMissing := make([]bool, len(files))
+	for _, i := range missing {
+		isMissing[i] = true
+	}
+	for i := range bufs {
+		if !isMissing[i] {
+			bufs[i] = make([]byte, stripeSize)
+		}
+	}
+
+	return stripes(shardSize, func(off int64, n int) error {
+		for i, f := range files {
+			if isMissing[i] {
+				chunks[i] = nil
+				continue
+			}
+			chunks[i] = bufs[i][:n]
+			if err := readPadded(f, chunks[i], off, shardSize); err != nil {
+				return err
+			}
+		}
+		if err := e.coder.Reconstruct(chunks); err != nil {
+			return err
+		}
+		for _, i := range missing {
+			if _, err := files[i].Write(chunks[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// objectBody 按顺序读取数据块中的对象内容，关闭时删除分片的临时文件
+type objectBody struct {
+	io.Reader
+	files shardFiles
+}
+
+func (b *objectBody) Close() error {
+	b.files.Close()
+	return nil
+}
+
+// body 返回对象 [offset, offset+length) 的内容，读取完成后需要关闭
+func (o *object) body(k int, offset, length int64) io.ReadCloser {
+
+	shardSize := o.shardSize
+
+	var readers []io.Reader
+	end := offset + length
+	for i := 0; i < k; i++ {
+		start, stop := int64(i)*shardSize, int64(i+1)*shardSize
+		if start < offset {
+			start = offset
+		}
+		if stop > end {
+			stop = end
+		}
+		if start >= stop {
+			continue
+		}
+		readers = append(readers, io.NewSectionReader(o.shards[i], start-int64(i)*shardSize, stop-start))
+	}
+
+	files := o.shards
+	o.shards = nil
+	return &objectBody{Reader: io.MultiReader(readers...), files: files}
+}
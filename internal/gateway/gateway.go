@@ -15,6 +15,9 @@ type Gateway interface {
 
 	Production() bool
 
+	// Capabilities 引擎支持的方法和特性，不支持的请求在调用后端之前返回 501
+	Capabilities() Capabilities
+
 	NewS3Protocol(creds auth.Credentials, region string, isDebug bool) (S3Protocol, error)
 }
 
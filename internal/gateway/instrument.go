@@ -0,0 +1,118 @@
+package gateway
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/solution9th/S3Adapter/internal/metrics"
+	"github.com/solution9th/S3Adapter/internal/reqinfo"
+	"github.com/solution9th/S3Adapter/internal/trace"
+
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// Instrument 记录调用后端的时间和 span，engine 为后端引擎的名称，应用名称从 ctx 中获得
+//
+// GetObject 只记录到后端返回响应头的时间，不包括读取 Body
+func Instrument(p S3Protocol, engine string) S3Protocol {
+	return &instrumented{S3Protocol: p, engine: engine}
+}
+
+type instrumented struct {
+	S3Protocol
+	engine string
+}
+
+// Capabilities 与被包装的后端相同
+func (m *instrumented) Capabilities() Capabilities { return CapabilitiesOf(m.S3Protocol) }
+
+func (m *instrumented) start(ctx context.Context, op string) (context.Context, *trace.Span, time.Time) {
+	ctx, span := trace.Start(ctx, m.engine+"."+op, trace.KindInternal)
+	span.SetAttribute("s3adapter.engine", m.engine)
+	return ctx, span, time.Now()
+}
+
+func (m *instrumented) finish(ctx context.Context, span *trace.Span, op string, start time.Time, err error) {
+	metrics.BackendDuration.Observe(metrics.Since(start), reqinfo.App(ctx), m.engine, op, metrics.Result(err))
+	span.SetError(err)
+	span.End()
+}
+
+func (m *instrumented) CreateBucketWithContext(ctx context.Context, input *s3.CreateBucketInput, opts ...request.Option) (*s3.CreateBucketOutput, *http.Response, error) {
+	ctx, span, start := m.start(ctx, OpCreateBucket)
+	output, resp, err := m.S3Protocol.CreateBucketWithContext(ctx, input, opts...)
+	m.finish(ctx, span, OpCreateBucket, start, err)
+	return output, resp, err
+}
+
+func (m *instrumented) HeadBucketWithContext(ctx context.Context, input *s3.HeadBucketInput, opts ...request.Option) (*s3.HeadBucketOutput, *http.Response, error) {
+	ctx, span, start := m.start(ctx, OpHeadBucket)
+	output, resp, err := m.S3Protocol.HeadBucketWithContext(ctx, input, opts...)
+	m.finish(ctx, span, OpHeadBucket, start, err)
+	return output, resp, err
+}
+
+func (m *instrumented) ListBucketsWithContext(ctx context.Context, input *s3.ListBucketsInput, opts ...request.Option) (*s3.ListBucketsOutput, *http.Response, error) {
+	ctx, span, start := m.start(ctx, OpListBuckets)
+	output, resp, err := m.S3Protocol.ListBucketsWithContext(ctx, input, opts...)
+	m.finish(ctx, span, OpListBuckets, start, err)
+	return output, resp, err
+}
+
+func (m *instrumented) ListObjectsWithContext(ctx context.Context, input *s3.ListObjectsInput, opts ...request.Option) (*s3.ListObjectsOutput, *http.Response, error) {
+	ctx, span, start := m.start(ctx, OpListObjects)
+	output, resp, err := m.S3Protocol.ListObjectsWithContext(ctx, input, opts...)
+	m.finish(ctx, span, OpListObjects, start, err)
+	return output, resp, err
+}
+
+func (m *instrumented) ListObjectsWithContextV2(ctx context.Context, input *s3.ListObjectsV2Input, opts ...request.Option) (*s3.ListObjectsV2Output, *http.Response, error) {
+	ctx, span, start := m.start(ctx, OpListObjectsV2)
+	output, resp, err := m.S3Protocol.ListObjectsWithContextV2(ctx, input, opts...)
+	m.finish(ctx, span, OpListObjectsV2, start, err)
+	return output, resp, err
+}
+
+func (m *instrumented) DeleteBucketWithContext(ctx context.Context, input *s3.DeleteBucketInput, opts ...request.Option) (*s3.DeleteBucketOutput, *http.Response, error) {
+	ctx, span, start := m.start(ctx, OpDeleteBucket)
+	output, resp, err := m.S3Protocol.DeleteBucketWithContext(ctx, input, opts...)
+	m.finish(ctx, span, OpDeleteBucket, start, err)
+	return output, resp, err
+}
+
+func (m *instrumented) PutObjectWithContext(ctx context.Context, input *s3.PutObjectInput, opts ...request.Option) (*s3.PutObjectOutput, *http.Response, error) {
+	ctx, span, start := m.start(ctx, OpPutObject)
+	output, resp, err := m.S3Protocol.PutObjectWithContext(ctx, input, opts...)
+	m.finish(ctx, span, OpPutObject, start, err)
+	return output, resp, err
+}
+
+func (m *instrumented) HeadObjectWithContext(ctx context.Context, input *s3.HeadObjectInput, opts ...request.Option) (*s3.HeadObjectOutput, *http.Response, error) {
+	ctx, span, start := m.start(ctx, OpHeadObject)
+	output, resp, err := m.S3Protocol.HeadObjectWithContext(ctx, input, opts...)
+	m.finish(ctx, span, OpHeadObject, start, err)
+	return output, resp, err
+}
+
+func (m *instrumented) GetObjectWithContext(ctx context.Context, input *s3.GetObjectInput, opts ...request.Option) (*s3.GetObjectOutput, *http.Response, error) {
+	ctx, span, start := m.start(ctx, OpGetObject)
+	output, resp, err := m.S3Protocol.GetObjectWithContext(ctx, input, opts...)
+	m.finish(ctx, span, OpGetObject, start, err)
+	return output, resp, err
+}
+
+func (m *instrumented) DeleteObjectWithContext(ctx context.Context, input *s3.DeleteObjectInput, opts ...request.Option) (*s3.DeleteObjectOutput, *http.Response, error) {
+	ctx, span, start := m.start(ctx, OpDeleteObject)
+	output, resp, err := m.S3Protocol.DeleteObjectWithContext(ctx, input, opts...)
+	m.finish(ctx, span, OpDeleteObject, start, err)
+	return output, resp, err
+}
+
+func (m *instrumented) CopyObjectWithContext(ctx context.Context, input *s3.CopyObjectInput, opts ...request.Option) (*s3.CopyObjectOutput, *http.Response, error) {
+	ctx, span, start := m.start(ctx, OpCopyObject)
+	output, resp, err := m.S3Protocol.CopyObjectWithContext(ctx, input, opts...)
+	m.finish(ctx, span, OpCopyObject, start, err)
+	return output, resp, err
+}
@@ -0,0 +1,31 @@
+package gateway
+
+import (
+	"context"
+	"testing"
+
+	"github.com/solution9th/S3Adapter/internal/metrics"
+	"github.com/solution9th/S3Adapter/internal/reqinfo"
+
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+func TestInstrument(t *testing.T) {
+
+	ctx := reqinfo.NewContext(context.Background(), &reqinfo.ReqInfo{App: "metrics-app"})
+	backend := &countProto{}
+	p := Instrument(backend, "fake")
+
+	p.GetObjectWithContext(ctx, &s3.GetObjectInput{})
+	p.GetObjectWithContext(ctx, &s3.GetObjectInput{})
+
+	if backend.calls != 2 {
+		t.Fatalf("calls: got %d, want 2", backend.calls)
+	}
+	if n := metrics.BackendDuration.Count("metrics-app", "fake", OpGetObject, "ok"); n != 2 {
+		t.Fatalf("count: got %d, want 2", n)
+	}
+	if !CapabilitiesOf(p).Supports(OpCopyObject) {
+		t.Fatal("capabilities should pass through")
+	}
+}
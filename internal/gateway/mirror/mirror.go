@@ -0,0 +1,250 @@
+package mirror
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/solution9th/S3Adapter/internal/db"
+	"github.com/solution9th/S3Adapter/internal/gateway"
+	"github.com/solution9th/S3Adapter/internal/reqinfo"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// Store 镜像引擎写入失败时记录需要修复的对象
+type Store interface {
+	AddRepair(r db.Repair) error
+}
+
+// Mirror 组合主引擎和镜像引擎两个 S3Protocol
+//
+// 写入和删除同时作用于两个引擎，以主引擎的结果为准，镜像引擎失败时记录到修复队列，
+// 由后台修复；读取优先从主引擎读取，主引擎不可用时从镜像引擎读取
+type Mirror struct {
+	oak       string
+	primary   gateway.S3Protocol
+	secondary gateway.S3Protocol
+	store     Store
+}
+
+// New 创建镜像存储，oak 为应用的 key，用于记录修复队列
+func New(oak string, primary, secondary gateway.S3Protocol, store Store) *Mirror {
+	return &Mirror{
+		oak:       oak,
+		primary:   primary,
+		secondary: secondary,
+		store:     store,
+	}
+}
+
+// Capabilities 写操作同时发往两端，读操作可能回退到备端，只有两端都支持的才可用
+func (m *Mirror) Capabilities() gateway.Capabilities {
+	return gateway.CapabilitiesOf(m.primary).Intersect(gateway.CapabilitiesOf(m.secondary))
+}
+
+// enqueue 镜像引擎写入失败，加入修复队列，object 为空时修复 bucket
+func (m *Mirror) enqueue(ctx context.Context, bucket, object string, cause error) {
+
+	reqinfo.ZError(ctx).Str("Bucket", bucket).Str("Object", object).Msg("[Mirror] secondary error:" + cause.Error())
+
+	err := m.store.AddRepair(db.Repair{
+		OsAccessKey:   m.oak,
+		Bucket:        bucket,
+		Object:        object,
+		NextRetryTime: time.Now(),
+		LastError:     cause.Error(),
+	})
+	if err != nil {
+		reqinfo.ZError(ctx).Str("Bucket", bucket).Str("Object", object).Msg("[Mirror] add repair error:" + err.Error())
+	}
+}
+
+// unavailable 主引擎是否不可用，只有不可用时才从镜像引擎读取，
+// 客户端错误(比如对象不存在)以主引擎为准
+func unavailable(ctx context.Context, err error) bool {
+	if err == nil || ctx.Err() != nil {
+		return false
+	}
+	if e, ok := err.(awserr.RequestFailure); ok && e.StatusCode() > 0 {
+		return e.StatusCode() >= http.StatusInternalServerError
+	}
+	return true
+}
+
+// =================
+// Bucket operations
+// =================
+
+func (m *Mirror) CreateBucketWithContext(ctx context.Context, input *s3.CreateBucketInput, opts ...request.Option) (*s3.CreateBucketOutput, *http.Response, error) {
+
+	output, resp, err := m.primary.CreateBucketWithContext(ctx, input, opts...)
+	if err != nil {
+		return output, gateway.Response(resp), err
+	}
+
+	_, _, err = m.secondary.CreateBucketWithContext(ctx, input, opts...)
+	if err != nil && !gateway.IsCode(err, "BucketAlreadyOwnedByYou") {
+		m.enqueue(ctx, aws.StringValue(input.Bucket), "", err)
+	}
+
+	return output, gateway.Response(resp), nil
+}
+
+func (m *Mirror) HeadBucketWithContext(ctx context.Context, input *s3.HeadBucketInput, opts ...request.Option) (*s3.HeadBucketOutput, *http.Response, error) {
+
+	output, resp, err := m.primary.HeadBucketWithContext(ctx, input, opts...)
+	if unavailable(ctx, err) {
+		if o, r, e := m.secondary.HeadBucketWithContext(ctx, input, opts...); e == nil {
+			return o, gateway.Response(r), nil
+		}
+	}
+	return output, gateway.Response(resp), err
+}
+
+func (m *Mirror) ListBucketsWithContext(ctx context.Context, input *s3.ListBucketsInput, opts ...request.Option) (*s3.ListBucketsOutput, *http.Response, error) {
+
+	output, resp, err := m.primary.ListBucketsWithContext(ctx, input, opts...)
+	if unavailable(ctx, err) {
+		if o, r, e := m.secondary.ListBucketsWithContext(ctx, input, opts...); e == nil {
+			return o, gateway.Response(r), nil
+		}
+	}
+	return output, gateway.Response(resp), err
+}
+
+func (m *Mirror) DeleteBucketWithContext(ctx context.Context, input *s3.DeleteBucketInput, opts ...request.Option) (*s3.DeleteBucketOutput, *http.Response, error) {
+
+	output, resp, err := m.primary.DeleteBucketWithContext(ctx, input, opts...)
+	if err != nil {
+		return output, gateway.Response(resp), err
+	}
+
+	_, _, err = m.secondary.DeleteBucketWithContext(ctx, &s3.DeleteBucketInput{Bucket: input.Bucket}, opts...)
+	if err != nil && !gateway.IsNotFound(err) {
+		m.enqueue(ctx, aws.StringValue(input.Bucket), "", err)
+	}
+
+	return output, gateway.Response(resp), nil
+}
+
+func (m *Mirror) ListObjectsWithContext(ctx context.Context, input *s3.ListObjectsInput, opts ...request.Option) (*s3.ListObjectsOutput, *http.Response, error) {
+
+	output, resp, err := m.primary.ListObjectsWithContext(ctx, input, opts...)
+	if unavailable(ctx, err) {
+		if o, r, e := m.secondary.ListObjectsWithContext(ctx, input, opts...); e == nil {
+			return o, gateway.Response(r), nil
+		}
+	}
+	return output, gateway.Response(resp), err
+}
+
+// ListObjectsWithContextV2 ContinuationToken 在两个引擎之间不能通用，有 token 时不切换引擎
+func (m *Mirror) ListObjectsWithContextV2(ctx context.Context, input *s3.ListObjectsV2Input, opts ...request.Option) (*s3.ListObjectsV2Output, *http.Response, error) {
+
+	output, resp, err := m.primary.ListObjectsWithContextV2(ctx, input, opts...)
+	if input.ContinuationToken == nil && unavailable(ctx, err) {
+		if o, r, e := m.secondary.ListObjectsWithContextV2(ctx, input, opts...); e == nil {
+			return o, gateway.Response(r), nil
+		}
+	}
+	return output, gateway.Response(resp), err
+}
+
+// =================
+// Object operations
+// =================
+
+// PutObjectWithContext 先写入主引擎，成功后重新读取 Body 写入镜像引擎
+func (m *Mirror) PutObjectWithContext(ctx context.Context, input *s3.PutObjectInput, opts ...request.Option) (*s3.PutObjectOutput, *http.Response, error) {
+
+	bucket, object := aws.StringValue(input.Bucket), aws.StringValue(input.Key)
+
+	var start int64
+	if input.Body != nil {
+		var err error
+		start, err = input.Body.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return nil, gateway.Response(nil), err
+		}
+	}
+
+	output, resp, err := m.primary.PutObjectWithContext(ctx, input, opts...)
+	if err != nil {
+		return output, gateway.Response(resp), err
+	}
+
+	if input.Body != nil {
+		if _, err := input.Body.Seek(start, io.SeekStart); err != nil {
+			m.enqueue(ctx, bucket, object, err)
+			return output, gateway.Response(resp), nil
+		}
+	}
+
+	secondary := *input
+	if _, _, err := m.secondary.PutObjectWithContext(ctx, &secondary, opts...); err != nil {
+		m.enqueue(ctx, bucket, object, err)
+	}
+
+	return output, gateway.Response(resp), nil
+}
+
+func (m *Mirror) GetObjectWithContext(ctx context.Context, input *s3.GetObjectInput, opts ...request.Option) (*s3.GetObjectOutput, *http.Response, error) {
+
+	output, resp, err := m.primary.GetObjectWithContext(ctx, input, opts...)
+	if unavailable(ctx, err) {
+		if o, r, e := m.secondary.GetObjectWithContext(ctx, input, opts...); e == nil {
+			reqinfo.ZWarn(ctx).Str("Bucket", aws.StringValue(input.Bucket)).Str("Object", aws.StringValue(input.Key)).Msg("[Mirror] read from secondary:" + err.Error())
+			return o, gateway.Response(r), nil
+		}
+	}
+	return output, gateway.Response(resp), err
+}
+
+func (m *Mirror) HeadObjectWithContext(ctx context.Context, input *s3.HeadObjectInput, opts ...request.Option) (*s3.HeadObjectOutput, *http.Response, error) {
+
+	output, resp, err := m.primary.HeadObjectWithContext(ctx, input, opts...)
+	if unavailable(ctx, err) {
+		if o, r, e := m.secondary.HeadObjectWithContext(ctx, input, opts...); e == nil {
+			return o, gateway.Response(r), nil
+		}
+	}
+	return output, gateway.Response(resp), err
+}
+
+func (m *Mirror) DeleteObjectWithContext(ctx context.Context, input *s3.DeleteObjectInput, opts ...request.Option) (*s3.DeleteObjectOutput, *http.Response, error) {
+
+	output, resp, err := m.primary.DeleteObjectWithContext(ctx, input, opts...)
+	if err != nil {
+		return output, gateway.Response(resp), err
+	}
+
+	secondary := *input
+	if _, _, err := m.secondary.DeleteObjectWithContext(ctx, &secondary, opts...); err != nil && !gateway.IsNotFound(err) {
+		m.enqueue(ctx, aws.StringValue(input.Bucket), aws.StringValue(input.Key), err)
+	}
+
+	return output, gateway.Response(resp), nil
+}
+
+// CopyObjectWithContext 两个引擎分别在内部复制，镜像引擎失败时由后台从主引擎修复
+func (m *Mirror) CopyObjectWithContext(ctx context.Context, input *s3.CopyObjectInput, opts ...request.Option) (*s3.CopyObjectOutput, *http.Response, error) {
+
+	output, resp, err := m.primary.CopyObjectWithContext(ctx, input, opts...)
+	if err != nil {
+		return output, gateway.Response(resp), err
+	}
+
+	secondary := *input
+	if _, _, err := m.secondary.CopyObjectWithContext(ctx, &secondary, opts...); err != nil {
+		m.enqueue(ctx, aws.StringValue(input.Bucket), aws.StringValue(input.Key), err)
+	}
+
+	return output, gateway.Response(resp), nil
+}
+
+var _ gateway.S3Protocol = (*Mirror)(nil)
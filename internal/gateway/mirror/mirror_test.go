@@ -0,0 +1,403 @@
+package mirror
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/solution9th/S3Adapter/internal/db"
+	"github.com/solution9th/S3Adapter/internal/gateway"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/smartystreets/goconvey/convey"
+)
+
+// memProto 内存中的 S3Protocol，down 时所有请求返回 503
+type memProto struct {
+	gateway.GatewayUnsupported
+
+	mu      sync.Mutex
+	down    bool
+	buckets map[string]map[string][]byte
+}
+
+func newMemProto() *memProto {
+	return &memProto{buckets: make(map[string]map[string][]byte)}
+}
+
+func emptyResp() *http.Response { return &http.Response{Header: make(http.Header)} }
+
+func memErr(code string, status int) error {
+	return awserr.NewRequestFailure(awserr.New(code, code, nil), status, "")
+}
+
+func (m *memProto) setDown(down bool) {
+	m.mu.Lock()
+	m.down = down
+	m.mu.Unlock()
+}
+
+// lock 加锁，down 时返回错误并解锁
+func (m *memProto) lock() error {
+	m.mu.Lock()
+	if m.down {
+		m.mu.Unlock()
+		return memErr("ServiceUnavailable", http.StatusServiceUnavailable)
+	}
+	return nil
+}
+
+func (m *memProto) CreateBucketWithContext(ctx context.Context, input *s3.CreateBucketInput, opts ...request.Option) (*s3.CreateBucketOutput, *http.Response, error) {
+	if err := m.lock(); err != nil {
+		return nil, emptyResp(), err
+	}
+	defer m.mu.Unlock()
+	if _, ok := m.buckets[*input.Bucket]; ok {
+		return nil, emptyResp(), memErr("BucketAlreadyOwnedByYou", http.StatusConflict)
+	}
+	m.buckets[*input.Bucket] = make(map[string][]byte)
+	return &s3.CreateBucketOutput{}, emptyResp(), nil
+}
+
+func (m *memProto) HeadBucketWithContext(ctx context.Context, input *s3.HeadBucketInput, opts ...request.Option) (*s3.HeadBucketOutput, *http.Response, error) {
+	if err := m.lock(); err != nil {
+		return nil, emptyResp(), err
+	}
+	defer m.mu.Unlock()
+	if _, ok := m.buckets[*input.Bucket]; !ok {
+		return nil, emptyResp(), memErr("NotFound", http.StatusNotFound)
+	}
+	return &s3.HeadBucketOutput{}, emptyResp(), nil
+}
+
+func (m *memProto) ListBucketsWithContext(ctx context.Context, input *s3.ListBucketsInput, opts ...request.Option) (*s3.ListBucketsOutput, *http.Response, error) {
+	if err := m.lock(); err != nil {
+		return nil, emptyResp(), err
+	}
+	defer m.mu.Unlock()
+	output := &s3.ListBucketsOutput{}
+	for k := range m.buckets {
+		output.Buckets = append(output.Buckets, &s3.Bucket{Name: aws.String(k)})
+	}
+	return output, emptyResp(), nil
+}
+
+func (m *memProto) DeleteBucketWithContext(ctx context.Context, input *s3.DeleteBucketInput, opts ...request.Option) (*s3.DeleteBucketOutput, *http.Response, error) {
+	if err := m.lock(); err != nil {
+		return nil, emptyResp(), err
+	}
+	defer m.mu.Unlock()
+	if _, ok := m.buckets[*input.Bucket]; !ok {
+		return nil, emptyResp(), memErr("NoSuchBucket", http.StatusNotFound)
+	}
+	delete(m.buckets, *input.Bucket)
+	return &s3.DeleteBucketOutput{}, emptyResp(), nil
+}
+
+func (m *memProto) ListObjectsWithContext(ctx context.Context, input *s3.ListObjectsInput, opts ...request.Option) (*s3.ListObjectsOutput, *http.Response, error) {
+	if err := m.lock(); err != nil {
+		return nil, emptyResp(), err
+	}
+	defer m.mu.Unlock()
+	b, ok := m.buckets[*input.Bucket]
+	if !ok {
+		return nil, emptyResp(), memErr("NoSuchBucket", http.StatusNotFound)
+	}
+	output := &s3.ListObjectsOutput{IsTruncated: aws.Bool(false)}
+	for k := range b {
+		output.Contents = append(output.Contents, &s3.Object{Key: aws.String(k)})
+	}
+	sort.Slice(output.Contents, func(i, j int) bool { return *output.Contents[i].Key < *output.Contents[j].Key })
+	return output, emptyResp(), nil
+}
+
+func (m *memProto) PutObjectWithContext(ctx context.Context, input *s3.PutObjectInput, opts ...request.Option) (*s3.PutObjectOutput, *http.Response, error) {
+	data, _ := ioutil.ReadAll(input.Body)
+	if err := m.lock(); err != nil {
+		return nil, emptyResp(), err
+	}
+	defer m.mu.Unlock()
+	b, ok := m.buckets[*input.Bucket]
+	if !ok {
+		return nil, emptyResp(), memErr("NoSuchBucket", http.StatusNotFound)
+	}
+	b[*input.Key] = data
+	return &s3.PutObjectOutput{}, emptyResp(), nil
+}
+
+func (m *memProto) get(bucket, key string) ([]byte, error) {
+	if err := m.lock(); err != nil {
+		return nil, err
+	}
+	defer m.mu.Unlock()
+	b, ok := m.buckets[bucket]
+	if !ok {
+		return nil, memErr("NoSuchBucket", http.StatusNotFound)
+	}
+	data, ok := b[key]
+	if !ok {
+		return nil, memErr("NoSuchKey", http.StatusNotFound)
+	}
+	return data, nil
+}
+
+func (m *memProto) GetObjectWithContext(ctx context.Context, input *s3.GetObjectInput, opts ...request.Option) (*s3.GetObjectOutput, *http.Response, error) {
+	data, err := m.get(*input.Bucket, *input.Key)
+	if err != nil {
+		return nil, emptyResp(), err
+	}
+	return &s3.GetObjectOutput{Body: ioutil.NopCloser(bytes.NewReader(data))}, emptyResp(), nil
+}
+
+func (m *memProto) HeadObjectWithContext(ctx context.Context, input *s3.HeadObjectInput, opts ...request.Option) (*s3.HeadObjectOutput, *http.Response, error) {
+	data, err := m.get(*input.Bucket, *input.Key)
+	if err != nil {
+		return nil, emptyResp(), err
+	}
+	return &s3.HeadObjectOutput{ContentLength: aws.Int64(int64(len(data)))}, emptyResp(), nil
+}
+
+func (m *memProto) DeleteObjectWithContext(ctx context.Context, input *s3.DeleteObjectInput, opts ...request.Option) (*s3.DeleteObjectOutput, *http.Response, error) {
+	if err := m.lock(); err != nil {
+		return nil, emptyResp(), err
+	}
+	defer m.mu.Unlock()
+	if b, ok := m.buckets[*input.Bucket]; ok {
+		delete(b, *input.Key)
+	}
+	return &s3.DeleteObjectOutput{}, emptyResp(), nil
+}
+
+func (m *memProto) CopyObjectWithContext(ctx context.Context, input *s3.CopyObjectInput, opts ...request.Option) (*s3.CopyObjectOutput, *http.Response, error) {
+	bucket, object, _ := gateway.ParseCopySource(*input.CopySource)
+	data, err := m.get(bucket, object)
+	if err != nil {
+		return nil, emptyResp(), err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.buckets[*input.Bucket][*input.Key] = data
+	return &s3.CopyObjectOutput{CopyObjectResult: &s3.CopyObjectResult{}}, emptyResp(), nil
+}
+
+func (m *memProto) object(bucket, key string) (string, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	data, ok := m.buckets[bucket][key]
+	return string(data), ok
+}
+
+// memQueue 内存中的修复队列
+type memQueue struct {
+	mu     sync.Mutex
+	nextID int64
+	data   map[string]db.Repair
+}
+
+func newMemQueue() *memQueue {
+	return &memQueue{data: make(map[string]db.Repair)}
+}
+
+func (q *memQueue) AddRepair(r db.Repair) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	k := r.OsAccessKey + "/" + r.Bucket + "/" + r.Object
+	if old, ok := q.data[k]; ok {
+		r.ID = old.ID
+	} else {
+		q.nextID++
+		r.ID = q.nextID
+	}
+	q.data[k] = r
+	return nil
+}
+
+func (q *memQueue) ListRepair(before time.Time, limit int) ([]db.Repair, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	var list []db.Repair
+	for _, r := range q.data {
+		if !r.NextRetryTime.After(before) {
+			list = append(list, r)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+	if len(list) > limit {
+		list = list[:limit]
+	}
+	return list, nil
+}
+
+func (q *memQueue) RetryRepair(id int64, attempts int, next time.Time, lastError string) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for k, r := range q.data {
+		if r.ID == id {
+			r.Attempts, r.NextRetryTime, r.LastError = attempts, next, lastError
+			q.data[k] = r
+		}
+	}
+	return nil
+}
+
+func (q *memQueue) DeleteRepair(id int64) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for k, r := range q.data {
+		if r.ID == id {
+			delete(q.data, k)
+		}
+	}
+	return nil
+}
+
+func (q *memQueue) len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.data)
+}
+
+// due 让所有记录立即到达重试时间
+func (q *memQueue) due() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for k, r := range q.data {
+		r.NextRetryTime = time.Time{}
+		q.data[k] = r
+	}
+}
+
+func TestMirror(t *testing.T) {
+
+	convey.Convey("mirror", t, func() {
+
+		ctx := context.Background()
+		primary, secondary, queue := newMemProto(), newMemProto(), newMemQueue()
+
+		m := New("oak", primary, secondary, queue)
+		repairer := NewRepairer(queue, func(oak string) (*Mirror, error) { return m, nil }, time.Minute, 10)
+
+		bucket := aws.String("bk")
+		_, resp, err := m.CreateBucketWithContext(ctx, &s3.CreateBucketInput{Bucket: bucket})
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(resp, convey.ShouldNotBeNil)
+		convey.So(secondary.buckets, convey.ShouldContainKey, "bk")
+
+		put := func(key, body string) error {
+			_, _, err := m.PutObjectWithContext(ctx, &s3.PutObjectInput{
+				Bucket: bucket,
+				Key:    aws.String(key),
+				Body:   strings.NewReader(body),
+			})
+			return err
+		}
+
+		convey.Convey("write to both", func() {
+			convey.So(put("a.txt", "hello"), convey.ShouldBeNil)
+
+			data, ok := secondary.object("bk", "a.txt")
+			convey.So(ok, convey.ShouldBeTrue)
+			convey.So(data, convey.ShouldEqual, "hello")
+
+			_, _, err := m.CopyObjectWithContext(ctx, &s3.CopyObjectInput{Bucket: bucket, Key: aws.String("b.txt"), CopySource: aws.String("/bk/a.txt")})
+			convey.So(err, convey.ShouldBeNil)
+			_, ok = secondary.object("bk", "b.txt")
+			convey.So(ok, convey.ShouldBeTrue)
+
+			_, _, err = m.DeleteObjectWithContext(ctx, &s3.DeleteObjectInput{Bucket: bucket, Key: aws.String("a.txt")})
+			convey.So(err, convey.ShouldBeNil)
+			_, ok = secondary.object("bk", "a.txt")
+			convey.So(ok, convey.ShouldBeFalse)
+			convey.So(queue.len(), convey.ShouldEqual, 0)
+		})
+
+		convey.Convey("primary error is returned", func() {
+			primary.setDown(true)
+			convey.So(put("a.txt", "hello"), convey.ShouldNotBeNil)
+			_, ok := secondary.object("bk", "a.txt")
+			convey.So(ok, convey.ShouldBeFalse)
+			convey.So(queue.len(), convey.ShouldEqual, 0)
+		})
+
+		convey.Convey("read fallback", func() {
+			convey.So(put("a.txt", "hello"), convey.ShouldBeNil)
+
+			// 对象不存在以主引擎为准
+			_, _, err := m.GetObjectWithContext(ctx, &s3.GetObjectInput{Bucket: bucket, Key: aws.String("none")})
+			convey.So(err, convey.ShouldNotBeNil)
+
+			primary.setDown(true)
+			get, resp, err := m.GetObjectWithContext(ctx, &s3.GetObjectInput{Bucket: bucket, Key: aws.String("a.txt")})
+			convey.So(err, convey.ShouldBeNil)
+			convey.So(resp, convey.ShouldNotBeNil)
+			b, _ := ioutil.ReadAll(get.Body)
+			convey.So(string(b), convey.ShouldEqual, "hello")
+
+			head, _, err := m.HeadObjectWithContext(ctx, &s3.HeadObjectInput{Bucket: bucket, Key: aws.String("a.txt")})
+			convey.So(err, convey.ShouldBeNil)
+			convey.So(aws.Int64Value(head.ContentLength), convey.ShouldEqual, 5)
+
+			list, _, err := m.ListObjectsWithContext(ctx, &s3.ListObjectsInput{Bucket: bucket})
+			convey.So(err, convey.ShouldBeNil)
+			convey.So(len(list.Contents), convey.ShouldEqual, 1)
+		})
+
+		convey.Convey("repair failed secondary writes", func() {
+			convey.So(put("gone.txt", "old"), convey.ShouldBeNil)
+
+			secondary.setDown(true)
+			convey.So(put("a.txt", "hello"), convey.ShouldBeNil)
+			convey.So(put("a.txt", "hello world"), convey.ShouldBeNil)
+			_, _, err := m.DeleteObjectWithContext(ctx, &s3.DeleteObjectInput{Bucket: bucket, Key: aws.String("gone.txt")})
+			convey.So(err, convey.ShouldBeNil)
+			_, _, err = m.CreateBucketWithContext(ctx, &s3.CreateBucketInput{Bucket: aws.String("bk2")})
+			convey.So(err, convey.ShouldBeNil)
+			convey.So(queue.len(), convey.ShouldEqual, 3)
+
+			// 镜像引擎仍然不可用，记录重试次数
+			repaired, err := repairer.RunOnce(ctx)
+			convey.So(err, convey.ShouldBeNil)
+			convey.So(repaired, convey.ShouldEqual, 0)
+			list, _ := queue.ListRepair(time.Now().Add(time.Minute), 10)
+			convey.So(len(list), convey.ShouldEqual, 3)
+			convey.So(list[0].Attempts, convey.ShouldEqual, 1)
+			convey.So(list[0].LastError, convey.ShouldContainSubstring, "ServiceUnavailable")
+
+			repaired, err = repairer.RunOnce(ctx)
+			convey.So(err, convey.ShouldBeNil)
+			convey.So(repaired, convey.ShouldEqual, 0)
+
+			secondary.setDown(false)
+			queue.due()
+			repaired, err = repairer.RunOnce(ctx)
+			convey.So(err, convey.ShouldBeNil)
+			convey.So(repaired, convey.ShouldEqual, 3)
+			convey.So(queue.len(), convey.ShouldEqual, 0)
+
+			data, ok := secondary.object("bk", "a.txt")
+			convey.So(ok, convey.ShouldBeTrue)
+			convey.So(data, convey.ShouldEqual, "hello world")
+			_, ok = secondary.object("bk", "gone.txt")
+			convey.So(ok, convey.ShouldBeFalse)
+			convey.So(secondary.buckets, convey.ShouldContainKey, "bk2")
+		})
+	})
+}
+
+func TestBackoff(t *testing.T) {
+
+	convey.Convey("backoff", t, func() {
+		convey.So(backoff(time.Minute, 1), convey.ShouldEqual, time.Minute)
+		convey.So(backoff(time.Minute, 3), convey.ShouldEqual, 4*time.Minute)
+		convey.So(backoff(time.Minute, 100), convey.ShouldEqual, maxBackoff)
+	})
+}
@@ -0,0 +1,230 @@
+package mirror
+
+import (
+	"context"
+	"io"
+	"time"
+
+	"github.com/solution9th/S3Adapter/internal/db"
+	"github.com/solution9th/S3Adapter/internal/gateway"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/haozibi/zlog"
+)
+
+const (
+	defaultBatch = 100
+
+	// maxBackoff 重试间隔的上限
+	maxBackoff = time.Hour
+)
+
+// Queue 修复队列
+type Queue interface {
+	ListRepair(before time.Time, limit int) ([]db.Repair, error)
+	RetryRepair(id int64, attempts int, next time.Time, lastError string) error
+	DeleteRepair(id int64) error
+}
+
+// Resolver 根据应用的 key 获得镜像存储，应用没有镜像配置时返回 nil
+type Resolver func(oak string) (*Mirror, error)
+
+// Repairer 后台修复，定期把修复队列中的对象从主引擎同步到镜像引擎
+type Repairer struct {
+	queue    Queue
+	resolve  Resolver
+	interval time.Duration
+	batch    int
+}
+
+// NewRepairer 创建后台修复，batch 为每次从数据库读取的数量
+func NewRepairer(queue Queue, resolve Resolver, interval time.Duration, batch int) *Repairer {
+	if batch <= 0 {
+		batch = defaultBatch
+	}
+	return &Repairer{
+		queue:    queue,
+		resolve:  resolve,
+		interval: interval,
+		batch:    batch,
+	}
+}
+
+// Run 每隔 interval 执行一次修复，直到 ctx 结束
+func (r *Repairer) Run(ctx context.Context) {
+
+	zlog.ZInfo().Str("Interval", r.interval.String()).Msg("[Mirror] repairer start")
+
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			repaired, err := r.RunOnce(ctx)
+			if err != nil {
+				zlog.ZError().Int("Repaired", repaired).Msg("[Mirror] repairer error:" + err.Error())
+				continue
+			}
+			if repaired > 0 {
+				zlog.ZInfo().Int("Repaired", repaired).Msg("[Mirror] repairer")
+			}
+		}
+	}
+}
+
+// RunOnce 处理一批到达重试时间的记录，返回修复成功的数量
+func (r *Repairer) RunOnce(ctx context.Context) (int, error) {
+
+	now := time.Now()
+
+	list, err := r.queue.ListRepair(now, r.batch)
+	if err != nil {
+		return 0, err
+	}
+
+	var (
+		repaired int
+		cache    = make(map[string]*Mirror)
+	)
+	for _, v := range list {
+		if ctx.Err() != nil {
+			return repaired, ctx.Err()
+		}
+
+		m, ok := cache[v.OsAccessKey]
+		if !ok {
+			m, err = r.resolve(v.OsAccessKey)
+			if err != nil {
+				r.retry(v, now, err)
+				continue
+			}
+			cache[v.OsAccessKey] = m
+		}
+
+		// 应用已经没有镜像配置，不再需要修复
+		if m != nil {
+			if err := m.Repair(ctx, v.Bucket, v.Object); err != nil {
+				r.retry(v, now, err)
+				continue
+			}
+		}
+
+		if err := r.queue.DeleteRepair(v.ID); err != nil {
+			return repaired, err
+		}
+		repaired++
+	}
+	return repaired, nil
+}
+
+func (r *Repairer) retry(v db.Repair, now time.Time, cause error) {
+
+	zlog.ZError().Str("Bucket", v.Bucket).Str("Object", v.Object).Int("Attempts", v.Attempts+1).Msg("[Mirror] repair error:" + cause.Error())
+
+	attempts := v.Attempts + 1
+	err := r.queue.RetryRepair(v.ID, attempts, now.Add(backoff(r.interval, attempts)), cause.Error())
+	if err != nil {
+		zlog.ZError().Int64("ID", v.ID).Msg("[Mirror] update repair error:" + err.Error())
+	}
+}
+
+// backoff 第 attempts 次失败后的重试间隔，指数增长，最大为 maxBackoff
+func backoff(base time.Duration, attempts int) time.Duration {
+	if base <= 0 {
+		base = time.Second
+	}
+	d := base
+	for i := 1; i < attempts; i++ {
+		d *= 2
+		if d >= maxBackoff {
+			return maxBackoff
+		}
+	}
+	return d
+}
+
+// Repair 把主引擎中对象的当前状态同步到镜像引擎，object 为空时同步 bucket
+//
+// 修复总是以主引擎为准，所以同一个对象多次失败只需要修复一次
+func (m *Mirror) Repair(ctx context.Context, bucket, object string) error {
+
+	if object == "" {
+		return m.repairBucket(ctx, bucket)
+	}
+
+	get, _, err := m.primary.GetObjectWithContext(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(object),
+	})
+	if err != nil {
+		if !gateway.IsNotFound(err) {
+			return err
+		}
+		_, _, err = m.secondary.DeleteObjectWithContext(ctx, &s3.DeleteObjectInput{
+			Bucket: aws.String(bucket),
+			Key:    aws.String(object),
+		})
+		if err != nil && !gateway.IsNotFound(err) {
+			return err
+		}
+		return nil
+	}
+
+	body, size, err := gateway.Spool(get.Body)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+
+	input := &s3.PutObjectInput{
+		Bucket:        aws.String(bucket),
+		Key:           aws.String(object),
+		Body:          body,
+		ContentLength: aws.Int64(size),
+		Metadata:      get.Metadata,
+		ContentType:   get.ContentType,
+	}
+
+	_, _, err = m.secondary.PutObjectWithContext(ctx, input)
+	if err != nil && gateway.IsCode(err, "NoSuchBucket") {
+		_, _, err = m.secondary.CreateBucketWithContext(ctx, &s3.CreateBucketInput{Bucket: aws.String(bucket)})
+		if err != nil && !gateway.IsCode(err, "BucketAlreadyOwnedByYou") {
+			return err
+		}
+		if _, err = body.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		_, _, err = m.secondary.PutObjectWithContext(ctx, input)
+	}
+	if err != nil {
+		return err
+	}
+
+	zlog.ZInfo().Str("Bucket", bucket).Str("Object", object).Int64("Size", size).Msg("[Mirror] repair")
+	return nil
+}
+
+func (m *Mirror) repairBucket(ctx context.Context, bucket string) error {
+
+	_, _, err := m.primary.HeadBucketWithContext(ctx, &s3.HeadBucketInput{Bucket: aws.String(bucket)})
+	if err == nil {
+		_, _, err = m.secondary.CreateBucketWithContext(ctx, &s3.CreateBucketInput{Bucket: aws.String(bucket)})
+		if err != nil && !gateway.IsCode(err, "BucketAlreadyOwnedByYou") {
+			return err
+		}
+		return nil
+	}
+	if !gateway.IsNotFound(err) {
+		return err
+	}
+
+	_, _, err = m.secondary.DeleteBucketWithContext(ctx, &s3.DeleteBucketInput{Bucket: aws.String(bucket)})
+	if err != nil && !gateway.IsNotFound(err) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,344 @@
+package plugin
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/solution9th/S3Adapter/internal/auth"
+	"github.com/solution9th/S3Adapter/internal/gateway"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/haozibi/zlog"
+)
+
+// Backend 插件引擎的名称前缀，日志中使用
+const Backend = "plugin"
+
+// New 创建转发到 address 的插件引擎，name 为注册的引擎名称
+func New(name, address string, timeout time.Duration) func() gateway.Gateway {
+
+	g := &pluginGateway{
+		name:    name,
+		address: strings.TrimRight(address, "/"),
+		client:  &http.Client{Transport: gateway.Transport(), Timeout: timeout},
+	}
+	return func() gateway.Gateway { return g }
+}
+
+type pluginGateway struct {
+	name    string
+	address string
+	client  *http.Client
+
+	mu   sync.Mutex
+	caps *gateway.Capabilities
+}
+
+func (g *pluginGateway) Name() string     { return Backend + ":" + g.name }
+func (g *pluginGateway) Production() bool { return true }
+
+// Capabilities 向插件查询支持的方法和特性，成功后缓存
+//
+// 查询失败时(比如插件还没启动)不缓存，认为支持全部，由插件自己返回错误
+func (g *pluginGateway) Capabilities() gateway.Capabilities {
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.caps != nil {
+		return *g.caps
+	}
+
+	caps, err := g.fetchCapabilities()
+	if err != nil {
+		zlog.ZWarn().Str("Plugin", g.name).Msg("[Plugin] capabilities error:" + err.Error())
+		return gateway.FullCapabilities()
+	}
+	g.caps = &caps
+	return caps
+}
+
+func (g *pluginGateway) fetchCapabilities() (gateway.Capabilities, error) {
+
+	var caps gateway.Capabilities
+
+	resp, err := g.client.Get(g.address + "/" + Version + "/" + OpCapabilities)
+	if err != nil {
+		return caps, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return caps, decodeError(resp)
+	}
+	err = json.NewDecoder(resp.Body).Decode(&caps)
+	return caps, err
+}
+func (g *pluginGateway) NewS3Protocol(creds auth.Credentials, region string, isDebug bool) (gateway.S3Protocol, error) {
+	return &pluginProto{
+		gw:     g,
+		creds:  creds,
+		region: region,
+	}, nil
+}
+
+type pluginProto struct {
+	gw     *pluginGateway
+	creds  auth.Credentials
+	region string
+}
+
+func newS3Err(code string, statusCode int, message string) awserr.RequestFailure {
+	return awserr.NewRequestFailure(awserr.New(code, message, nil), statusCode, "")
+}
+
+// toS3ErrNotResponse 请求没有得到响应，比如插件进程不可用
+func toS3ErrNotResponse(err error) awserr.RequestFailure {
+	zlog.ZError().Msg("[Plugin] error:" + err.Error())
+	return awserr.NewRequestFailure(awserr.New("ServiceUnavailable", err.Error(), err), http.StatusServiceUnavailable, "")
+}
+
+// response 只保留插件返回的 x-amz-* 头，其他头(比如 Content-Length)属于插件协议本身
+func response(resp *http.Response) *http.Response {
+
+	out := &http.Response{Header: make(http.Header)}
+	if resp == nil {
+		return out
+	}
+	out.StatusCode = resp.StatusCode
+	for k, v := range resp.Header {
+		if strings.HasPrefix(strings.ToLower(k), "x-amz-") {
+			out.Header[k] = v
+		}
+	}
+	return out
+}
+
+// call 调用插件的 op 方法
+//
+// body 不为空时为流式请求，output 为 nil 时不读取响应体，由调用方关闭
+func (p *pluginProto) call(ctx context.Context, op string, input interface{}, body io.Reader, size int64, output interface{}) (*http.Response, error) {
+
+	in, err := json.Marshal(input)
+	if err != nil {
+		return nil, err
+	}
+
+	envelope := Request{
+		AccessKey:    p.creds.AccessKey,
+		SecretKey:    p.creds.SecretKey,
+		SessionToken: p.creds.SessionToken,
+		Region:       p.region,
+		Input:        in,
+	}
+
+	var req *http.Request
+	url := p.gw.address + "/" + Version + "/" + op
+	if body != nil {
+		h, err := encodeHeader(envelope)
+		if err != nil {
+			return nil, err
+		}
+		req, err = http.NewRequest(http.MethodPost, url, body)
+		if err != nil {
+			return nil, err
+		}
+		req.ContentLength = size
+		req.Header.Set(HeaderRequest, h)
+		req.Header.Set("Content-Type", "application/octet-stream")
+	} else {
+		b, err := json.Marshal(envelope)
+		if err != nil {
+			return nil, err
+		}
+		req, err = http.NewRequest(http.MethodPost, url, bytes.NewReader(b))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", contentTypeJSON)
+	}
+
+	resp, err := p.gw.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, toS3ErrNotResponse(err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		return resp, decodeError(resp)
+	}
+
+	if output == nil {
+		return resp, nil
+	}
+
+	defer resp.Body.Close()
+	if err := json.NewDecoder(resp.Body).Decode(output); err != nil {
+		return resp, newS3Err("InternalError", http.StatusInternalServerError, "invalid plugin response: "+err.Error())
+	}
+	return resp, nil
+}
+
+func decodeError(resp *http.Response) error {
+
+	b, _ := ioutil.ReadAll(io.LimitReader(resp.Body, maxErrorBodyBytes))
+
+	var e Error
+	if err := json.Unmarshal(b, &e); err != nil || e.Code == "" {
+		return newS3Err(http.StatusText(resp.StatusCode), resp.StatusCode, strings.TrimSpace(string(b)))
+	}
+	if e.StatusCode == 0 {
+		e.StatusCode = resp.StatusCode
+	}
+	return awserr.NewRequestFailure(awserr.New(e.Code, e.Message, nil), e.StatusCode, e.RequestID)
+}
+
+// =================
+// Bucket operations
+// =================
+
+func (p *pluginProto) CreateBucketWithContext(ctx context.Context, input *s3.CreateBucketInput, opts ...request.Option) (*s3.CreateBucketOutput, *http.Response, error) {
+	output := &s3.CreateBucketOutput{}
+	resp, err := p.call(ctx, OpCreateBucket, input, nil, 0, output)
+	if err != nil {
+		return nil, response(resp), err
+	}
+	return output, response(resp), nil
+}
+
+func (p *pluginProto) HeadBucketWithContext(ctx context.Context, input *s3.HeadBucketInput, opts ...request.Option) (*s3.HeadBucketOutput, *http.Response, error) {
+	output := &s3.HeadBucketOutput{}
+	resp, err := p.call(ctx, OpHeadBucket, input, nil, 0, output)
+	if err != nil {
+		return nil, response(resp), err
+	}
+	return output, response(resp), nil
+}
+
+func (p *pluginProto) ListBucketsWithContext(ctx context.Context, input *s3.ListBucketsInput, opts ...request.Option) (*s3.ListBucketsOutput, *http.Response, error) {
+	output := &s3.ListBucketsOutput{}
+	resp, err := p.call(ctx, OpListBuckets, input, nil, 0, output)
+	if err != nil {
+		return nil, response(resp), err
+	}
+	return output, response(resp), nil
+}
+
+func (p *pluginProto) ListObjectsWithContext(ctx context.Context, input *s3.ListObjectsInput, opts ...request.Option) (*s3.ListObjectsOutput, *http.Response, error) {
+	output := &s3.ListObjectsOutput{}
+	resp, err := p.call(ctx, OpListObjects, input, nil, 0, output)
+	if err != nil {
+		return nil, response(resp), err
+	}
+	return output, response(resp), nil
+}
+
+func (p *pluginProto) ListObjectsWithContextV2(ctx context.Context, input *s3.ListObjectsV2Input, opts ...request.Option) (*s3.ListObjectsV2Output, *http.Response, error) {
+	output := &s3.ListObjectsV2Output{}
+	resp, err := p.call(ctx, OpListObjectsV2, input, nil, 0, output)
+	if err != nil {
+		return nil, response(resp), err
+	}
+	return output, response(resp), nil
+}
+
+func (p *pluginProto) DeleteBucketWithContext(ctx context.Context, input *s3.DeleteBucketInput, opts ...request.Option) (*s3.DeleteBucketOutput, *http.Response, error) {
+	output := &s3.DeleteBucketOutput{}
+	resp, err := p.call(ctx, OpDeleteBucket, input, nil, 0, output)
+	if err != nil {
+		return nil, response(resp), err
+	}
+	return output, response(resp), nil
+}
+
+// =================
+// Object operations
+// =================
+
+// PutObjectWithContext 对象内容作为请求体流式发送
+func (p *pluginProto) PutObjectWithContext(ctx context.Context, input *s3.PutObjectInput, opts ...request.Option) (*s3.PutObjectOutput, *http.Response, error) {
+
+	meta := *input
+	meta.Body = nil
+
+	var (
+		body io.Reader = http.NoBody
+		size int64
+	)
+	if input.Body != nil {
+		cur, err := input.Body.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return nil, response(nil), err
+		}
+		end, err := input.Body.Seek(0, io.SeekEnd)
+		if err != nil {
+			return nil, response(nil), err
+		}
+		if _, err := input.Body.Seek(cur, io.SeekStart); err != nil {
+			return nil, response(nil), err
+		}
+		body, size = input.Body, end-cur
+	}
+
+	output := &s3.PutObjectOutput{}
+	resp, err := p.call(ctx, OpPutObject, &meta, body, size, output)
+	if err != nil {
+		return nil, response(resp), err
+	}
+	return output, response(resp), nil
+}
+
+func (p *pluginProto) HeadObjectWithContext(ctx context.Context, input *s3.HeadObjectInput, opts ...request.Option) (*s3.HeadObjectOutput, *http.Response, error) {
+	output := &s3.HeadObjectOutput{}
+	resp, err := p.call(ctx, OpHeadObject, input, nil, 0, output)
+	if err != nil {
+		return nil, response(resp), err
+	}
+	return output, response(resp), nil
+}
+
+// GetObjectWithContext 对象内容为响应体，Output 在响应头中
+func (p *pluginProto) GetObjectWithContext(ctx context.Context, input *s3.GetObjectInput, opts ...request.Option) (*s3.GetObjectOutput, *http.Response, error) {
+
+	resp, err := p.call(ctx, OpGetObject, input, nil, 0, nil)
+	if err != nil {
+		return nil, response(resp), err
+	}
+
+	output := &s3.GetObjectOutput{}
+	if err := decodeHeader(resp.Header.Get(HeaderResponse), output); err != nil {
+		resp.Body.Close()
+		return nil, response(resp), newS3Err("InternalError", http.StatusInternalServerError, "invalid plugin response: "+err.Error())
+	}
+	output.Body = resp.Body
+	return output, response(resp), nil
+}
+
+func (p *pluginProto) DeleteObjectWithContext(ctx context.Context, input *s3.DeleteObjectInput, opts ...request.Option) (*s3.DeleteObjectOutput, *http.Response, error) {
+	output := &s3.DeleteObjectOutput{}
+	resp, err := p.call(ctx, OpDeleteObject, input, nil, 0, output)
+	if err != nil {
+		return nil, response(resp), err
+	}
+	return output, response(resp), nil
+}
+
+func (p *pluginProto) CopyObjectWithContext(ctx context.Context, input *s3.CopyObjectInput, opts ...request.Option) (*s3.CopyObjectOutput, *http.Response, error) {
+	output := &s3.CopyObjectOutput{}
+	resp, err := p.call(ctx, OpCopyObject, input, nil, 0, output)
+	if err != nil {
+		return nil, response(resp), err
+	}
+	return output, response(resp), nil
+}
+
+var _ gateway.S3Protocol = (*pluginProto)(nil)
@@ -0,0 +1,253 @@
+package plugin
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/solution9th/S3Adapter/internal/auth"
+	"github.com/solution9th/S3Adapter/internal/gateway"
+	"github.com/solution9th/S3Adapter/internal/gerror"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/smartystreets/goconvey/convey"
+)
+
+type memObject struct {
+	data     []byte
+	metadata map[string]*string
+}
+
+// memProto 内存中的 S3Protocol，只实现测试需要的部分
+type memProto struct {
+	gateway.GatewayUnsupported
+
+	mu      sync.Mutex
+	creds   auth.Credentials
+	region  string
+	buckets map[string]map[string]*memObject
+}
+
+func emptyResp() *http.Response {
+	return &http.Response{Header: http.Header{"X-Amz-Request-Id": {"mem"}}}
+}
+
+func memErr(code string, status int) error {
+	return awserr.NewRequestFailure(awserr.New(code, code+" message", nil), status, "req-1")
+}
+
+func (m *memProto) CreateBucketWithContext(ctx context.Context, input *s3.CreateBucketInput, opts ...request.Option) (*s3.CreateBucketOutput, *http.Response, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.buckets[*input.Bucket] = make(map[string]*memObject)
+	return &s3.CreateBucketOutput{Location: aws.String("/" + *input.Bucket)}, emptyResp(), nil
+}
+
+func (m *memProto) HeadBucketWithContext(ctx context.Context, input *s3.HeadBucketInput, opts ...request.Option) (*s3.HeadBucketOutput, *http.Response, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.buckets[*input.Bucket]; !ok {
+		return nil, emptyResp(), memErr("NotFound", http.StatusNotFound)
+	}
+	return &s3.HeadBucketOutput{}, emptyResp(), nil
+}
+
+func (m *memProto) ListBucketsWithContext(ctx context.Context, input *s3.ListBucketsInput, opts ...request.Option) (*s3.ListBucketsOutput, *http.Response, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	output := &s3.ListBucketsOutput{Owner: &s3.Owner{ID: aws.String(m.creds.AccessKey), DisplayName: aws.String(m.region)}}
+	for k := range m.buckets {
+		output.Buckets = append(output.Buckets, &s3.Bucket{Name: aws.String(k), CreationDate: aws.Time(time.Unix(0, 0).UTC())})
+	}
+	return output, emptyResp(), nil
+}
+
+func (m *memProto) ListObjectsWithContext(ctx context.Context, input *s3.ListObjectsInput, opts ...request.Option) (*s3.ListObjectsOutput, *http.Response, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	output := &s3.ListObjectsOutput{Name: input.Bucket, IsTruncated: aws.Bool(false)}
+	for k, v := range m.buckets[*input.Bucket] {
+		output.Contents = append(output.Contents, &s3.Object{Key: aws.String(k), Size: aws.Int64(int64(len(v.data)))})
+	}
+	return output, emptyResp(), nil
+}
+
+func (m *memProto) DeleteBucketWithContext(ctx context.Context, input *s3.DeleteBucketInput, opts ...request.Option) (*s3.DeleteBucketOutput, *http.Response, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.buckets, *input.Bucket)
+	return &s3.DeleteBucketOutput{}, emptyResp(), nil
+}
+
+func (m *memProto) PutObjectWithContext(ctx context.Context, input *s3.PutObjectInput, opts ...request.Option) (*s3.PutObjectOutput, *http.Response, error) {
+	data, _ := ioutil.ReadAll(input.Body)
+	if int64(len(data)) != aws.Int64Value(input.ContentLength) {
+		return nil, emptyResp(), memErr("IncompleteBody", http.StatusBadRequest)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	b, ok := m.buckets[*input.Bucket]
+	if !ok {
+		return nil, emptyResp(), memErr("NoSuchBucket", http.StatusNotFound)
+	}
+	b[*input.Key] = &memObject{data: data, metadata: input.Metadata}
+	return &s3.PutObjectOutput{ETag: aws.String(fmt.Sprintf("\"%x\"", md5.Sum(data)))}, emptyResp(), nil
+}
+
+func (m *memProto) get(bucket, key string) (*memObject, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	o, ok := m.buckets[bucket][key]
+	if !ok {
+		return nil, memErr("NoSuchKey", http.StatusNotFound)
+	}
+	return o, nil
+}
+
+func (m *memProto) HeadObjectWithContext(ctx context.Context, input *s3.HeadObjectInput, opts ...request.Option) (*s3.HeadObjectOutput, *http.Response, error) {
+	o, err := m.get(*input.Bucket, *input.Key)
+	if err != nil {
+		return nil, emptyResp(), err
+	}
+	return &s3.HeadObjectOutput{ContentLength: aws.Int64(int64(len(o.data))), Metadata: o.metadata}, emptyResp(), nil
+}
+
+func (m *memProto) GetObjectWithContext(ctx context.Context, input *s3.GetObjectInput, opts ...request.Option) (*s3.GetObjectOutput, *http.Response, error) {
+	o, err := m.get(*input.Bucket, *input.Key)
+	if err != nil {
+		return nil, emptyResp(), err
+	}
+	return &s3.GetObjectOutput{
+		Body:          ioutil.NopCloser(bytes.NewReader(o.data)),
+		ContentLength: aws.Int64(int64(len(o.data))),
+		LastModified:  aws.Time(time.Date(2019, 1, 2, 3, 4, 5, 0, time.UTC)),
+		Metadata:      o.metadata,
+	}, emptyResp(), nil
+}
+
+func (m *memProto) DeleteObjectWithContext(ctx context.Context, input *s3.DeleteObjectInput, opts ...request.Option) (*s3.DeleteObjectOutput, *http.Response, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.buckets[*input.Bucket], *input.Key)
+	return &s3.DeleteObjectOutput{}, emptyResp(), nil
+}
+
+func TestPlugin(t *testing.T) {
+
+	convey.Convey("plugin", t, func() {
+
+		ctx := context.Background()
+		mem := &memProto{buckets: make(map[string]map[string]*memObject)}
+
+		server := httptest.NewServer(NewHandler(func(creds auth.Credentials, region string) (gateway.S3Protocol, error) {
+			if creds.AccessKey != "ak" {
+				return nil, awserr.NewRequestFailure(awserr.New("InvalidAccessKeyId", "bad key", nil), http.StatusForbidden, "")
+			}
+			mem.creds, mem.region = creds, region
+			return mem, nil
+		}, gateway.Capabilities{
+			Operations: []string{OpCreateBucket, OpListBuckets, OpPutObject, OpGetObject},
+			Features:   []string{gateway.FeatureRange},
+		}))
+		defer server.Close()
+
+		g := New("mem", server.URL+"/", time.Minute)()
+		convey.So(g.Name(), convey.ShouldEqual, "plugin:mem")
+		convey.So(g.Production(), convey.ShouldBeTrue)
+
+		caps := g.Capabilities()
+		convey.So(caps.Supports(OpGetObject), convey.ShouldBeTrue)
+		convey.So(caps.Supports(OpCopyObject), convey.ShouldBeFalse)
+		convey.So(caps.Has(gateway.FeatureRange), convey.ShouldBeTrue)
+		convey.So(caps.Has(gateway.FeatureStorageClass), convey.ShouldBeFalse)
+
+		p, err := g.NewS3Protocol(auth.Credentials{AccessKey: "ak", SecretKey: "sk"}, "mem-region", false)
+		convey.So(err, convey.ShouldBeNil)
+
+		bucket := aws.String("bk")
+		create, resp, err := p.CreateBucketWithContext(ctx, &s3.CreateBucketInput{Bucket: bucket})
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(aws.StringValue(create.Location), convey.ShouldEqual, "/bk")
+		convey.So(resp.Header.Get("X-Amz-Request-Id"), convey.ShouldEqual, "mem")
+		convey.So(resp.Header.Get("Content-Type"), convey.ShouldEqual, "")
+
+		list, _, err := p.ListBucketsWithContext(ctx, &s3.ListBucketsInput{})
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(aws.StringValue(list.Owner.DisplayName), convey.ShouldEqual, "mem-region")
+		convey.So(list.Buckets[0].CreationDate.Equal(time.Unix(0, 0)), convey.ShouldBeTrue)
+
+		data := bytes.Repeat([]byte("0123456789"), 100000)
+		put, _, err := p.PutObjectWithContext(ctx, &s3.PutObjectInput{
+			Bucket:   bucket,
+			Key:      aws.String("dir/a.bin"),
+			Body:     bytes.NewReader(data),
+			Metadata: map[string]*string{"Foo": aws.String("bar")},
+		})
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(aws.StringValue(put.ETag), convey.ShouldEqual, fmt.Sprintf("\"%x\"", md5.Sum(data)))
+
+		_, _, err = p.PutObjectWithContext(ctx, &s3.PutObjectInput{Bucket: bucket, Key: aws.String("empty")})
+		convey.So(err, convey.ShouldBeNil)
+
+		get, _, err := p.GetObjectWithContext(ctx, &s3.GetObjectInput{Bucket: bucket, Key: aws.String("dir/a.bin")})
+		convey.So(err, convey.ShouldBeNil)
+		body, _ := ioutil.ReadAll(get.Body)
+		get.Body.Close()
+		convey.So(bytes.Equal(body, data), convey.ShouldBeTrue)
+		convey.So(aws.Int64Value(get.ContentLength), convey.ShouldEqual, len(data))
+		convey.So(aws.StringValue(get.Metadata["Foo"]), convey.ShouldEqual, "bar")
+		convey.So(get.LastModified.Equal(time.Date(2019, 1, 2, 3, 4, 5, 0, time.UTC)), convey.ShouldBeTrue)
+
+		head, _, err := p.HeadObjectWithContext(ctx, &s3.HeadObjectInput{Bucket: bucket, Key: aws.String("empty")})
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(aws.Int64Value(head.ContentLength), convey.ShouldEqual, 0)
+
+		objects, _, err := p.ListObjectsWithContext(ctx, &s3.ListObjectsInput{Bucket: bucket})
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(len(objects.Contents), convey.ShouldEqual, 2)
+
+		_, resp, err = p.GetObjectWithContext(ctx, &s3.GetObjectInput{Bucket: bucket, Key: aws.String("none")})
+		convey.So(resp, convey.ShouldNotBeNil)
+		e, ok := err.(awserr.RequestFailure)
+		convey.So(ok, convey.ShouldBeTrue)
+		convey.So(e.Code(), convey.ShouldEqual, "NoSuchKey")
+		convey.So(e.Message(), convey.ShouldEqual, "NoSuchKey message")
+		convey.So(e.StatusCode(), convey.ShouldEqual, http.StatusNotFound)
+		convey.So(e.RequestID(), convey.ShouldEqual, "req-1")
+
+		_, _, err = p.CopyObjectWithContext(ctx, &s3.CopyObjectInput{Bucket: bucket, Key: aws.String("b"), CopySource: aws.String("bk/dir/a.bin")})
+		convey.So(err.(awserr.Error).Code(), convey.ShouldEqual, gerror.ErrUnsupported)
+
+		_, _, err = p.DeleteObjectWithContext(ctx, &s3.DeleteObjectInput{Bucket: bucket, Key: aws.String("dir/a.bin")})
+		convey.So(err, convey.ShouldBeNil)
+		_, err = mem.get("bk", "dir/a.bin")
+		convey.So(err, convey.ShouldNotBeNil)
+
+		convey.Convey("factory error", func() {
+			bad, _ := g.NewS3Protocol(auth.Credentials{AccessKey: "bad"}, "", false)
+			_, _, err := bad.HeadBucketWithContext(ctx, &s3.HeadBucketInput{Bucket: bucket})
+			convey.So(err.(awserr.RequestFailure).StatusCode(), convey.ShouldEqual, http.StatusForbidden)
+			convey.So(err.(awserr.Error).Code(), convey.ShouldEqual, "InvalidAccessKeyId")
+		})
+
+		convey.Convey("plugin unavailable", func() {
+			dg := New("down", "http://127.0.0.1:1", time.Second)()
+			convey.So(dg.Capabilities(), convey.ShouldResemble, gateway.FullCapabilities())
+
+			down, _ := dg.NewS3Protocol(auth.Credentials{}, "", false)
+			_, resp, err := down.HeadBucketWithContext(ctx, &s3.HeadBucketInput{Bucket: bucket})
+			convey.So(resp, convey.ShouldNotBeNil)
+			convey.So(err.(awserr.RequestFailure).StatusCode(), convey.ShouldEqual, http.StatusServiceUnavailable)
+		})
+	})
+}
@@ -0,0 +1,86 @@
+// Package plugin 进程外的后端引擎
+//
+// 协议基于 HTTP 和 JSON，每个 S3Protocol 方法对应一个 POST /v1/<Operation> 请求：
+//
+// - 请求信封 Request 包含应用的后端 key、region 和 JSON 编码的 s3 Input
+// - PutObject 的请求体为对象内容，信封 base64 编码后放在 X-S3adapter-Plugin-Request 头中，
+// 其他方法的请求体为信封本身
+// - 成功时返回 200，GetObject 的响应体为对象内容，Output 去掉 Body 后 base64 编码放在
+// X-S3adapter-Plugin-Response 头中，其他方法的响应体为 JSON 编码的 s3 Output
+// - 失败时返回对应的 HTTP 状态码，响应体为 JSON 编码的 Error
+// - GET /v1/Capabilities 返回插件支持的方法和特性，不支持的请求不会转发到插件
+package plugin
+
+import (
+	"encoding/base64"
+	"encoding/json"
+
+	"github.com/solution9th/S3Adapter/internal/gateway"
+)
+
+const (
+	// Version 协议版本，也是请求路径的前缀
+	Version = "v1"
+
+	// HeaderRequest 流式请求时保存请求信封的头
+	HeaderRequest = "X-S3adapter-Plugin-Request"
+	// HeaderResponse 流式响应时保存 Output 的头
+	HeaderResponse = "X-S3adapter-Plugin-Response"
+
+	contentTypeJSON = "application/json"
+
+	// maxErrorBodyBytes 读取错误响应体的上限
+	maxErrorBodyBytes = 64 << 10
+)
+
+// 协议支持的方法，和 gateway.S3Protocol 一一对应
+const (
+	OpCreateBucket  = gateway.OpCreateBucket
+	OpHeadBucket    = gateway.OpHeadBucket
+	OpListBuckets   = gateway.OpListBuckets
+	OpListObjects   = gateway.OpListObjects
+	OpListObjectsV2 = gateway.OpListObjectsV2
+	OpDeleteBucket  = gateway.OpDeleteBucket
+	OpPutObject     = gateway.OpPutObject
+	OpHeadObject    = gateway.OpHeadObject
+	OpGetObject     = gateway.OpGetObject
+	OpDeleteObject  = gateway.OpDeleteObject
+	OpCopyObject    = gateway.OpCopyObject
+
+	// OpCapabilities GET 请求，响应体为 JSON 编码的 gateway.Capabilities
+	OpCapabilities = "Capabilities"
+)
+
+// Request 请求信封
+type Request struct {
+	AccessKey    string          `json:"access_key"`
+	SecretKey    string          `json:"secret_key"`
+	SessionToken string          `json:"session_token,omitempty"`
+	Region       string          `json:"region"`
+	Input        json.RawMessage `json:"input"`
+}
+
+// Error 失败时的响应体
+type Error struct {
+	Code       string `json:"code"`
+	Message    string `json:"message"`
+	StatusCode int    `json:"status_code"`
+	RequestID  string `json:"request_id,omitempty"`
+}
+
+// encodeHeader 把 v 编码为可以放在 HTTP 头中的字符串
+func encodeHeader(v interface{}) (string, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(b), nil
+}
+
+func decodeHeader(s string, v interface{}) error {
+	b, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
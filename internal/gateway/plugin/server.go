@@ -0,0 +1,275 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/solution9th/S3Adapter/internal/auth"
+	"github.com/solution9th/S3Adapter/internal/gateway"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/haozibi/zlog"
+)
+
+// Factory 插件根据请求中的 key 和 region 创建后端
+type Factory func(creds auth.Credentials, region string) (gateway.S3Protocol, error)
+
+// NewHandler 插件进程使用，把协议请求转换为 S3Protocol 调用，caps 为插件支持的方法和特性
+func NewHandler(factory Factory, caps gateway.Capabilities) http.Handler {
+	return &handler{factory: factory, caps: caps}
+}
+
+type handler struct {
+	factory Factory
+	caps    gateway.Capabilities
+}
+
+// writeError 把 S3Protocol 返回的错误转换为协议的错误响应
+func writeError(w http.ResponseWriter, err error) {
+
+	e := Error{
+		Code:       "InternalError",
+		Message:    err.Error(),
+		StatusCode: http.StatusInternalServerError,
+	}
+	if ae, ok := err.(awserr.Error); ok {
+		e.Code, e.Message = ae.Code(), ae.Message()
+	}
+	if rf, ok := err.(awserr.RequestFailure); ok {
+		if rf.StatusCode() > 0 {
+			e.StatusCode = rf.StatusCode()
+		}
+		e.RequestID = rf.RequestID()
+	}
+
+	b, _ := json.Marshal(e)
+	w.Header().Set("Content-Type", contentTypeJSON)
+	w.WriteHeader(e.StatusCode)
+	w.Write(b)
+}
+
+// writeOutput 写入 JSON 编码的 Output，resp 中的 x-amz-* 头会一起返回
+func writeOutput(w http.ResponseWriter, resp *http.Response, output interface{}) {
+
+	b, err := json.Marshal(output)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	copyAmzHeader(w, resp)
+	w.Header().Set("Content-Type", contentTypeJSON)
+	w.Header().Set("Content-Length", strconv.Itoa(len(b)))
+	w.WriteHeader(http.StatusOK)
+	w.Write(b)
+}
+
+func copyAmzHeader(w http.ResponseWriter, resp *http.Response) {
+	if resp == nil {
+		return
+	}
+	for k, v := range resp.Header {
+		if strings.HasPrefix(strings.ToLower(k), "x-amz-") {
+			w.Header()[k] = v
+		}
+	}
+}
+
+func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+
+	prefix := "/" + Version + "/"
+	if r.Method == http.MethodGet && r.URL.Path == prefix+OpCapabilities {
+		writeOutput(w, nil, h.caps)
+		return
+	}
+	if r.Method != http.MethodPost || !strings.HasPrefix(r.URL.Path, prefix) {
+		writeError(w, awserr.NewRequestFailure(awserr.New("MethodNotAllowed", "The specified method is not allowed against this resource.", nil), http.StatusMethodNotAllowed, ""))
+		return
+	}
+	op := strings.TrimPrefix(r.URL.Path, prefix)
+
+	var envelope Request
+	var err error
+	if op == OpPutObject {
+		err = decodeHeader(r.Header.Get(HeaderRequest), &envelope)
+	} else {
+		err = json.NewDecoder(r.Body).Decode(&envelope)
+	}
+	if err != nil {
+		writeError(w, awserr.NewRequestFailure(awserr.New("InvalidRequest", "invalid plugin request: "+err.Error(), nil), http.StatusBadRequest, ""))
+		return
+	}
+
+	proto, err := h.factory(auth.Credentials{
+		AccessKey:    envelope.AccessKey,
+		SecretKey:    envelope.SecretKey,
+		SessionToken: envelope.SessionToken,
+	}, envelope.Region)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	ctx := r.Context()
+	if err := h.serve(ctx, w, r, proto, op, envelope.Input); err != nil {
+		zlog.ZDebug().Str("Op", op).Msg("[Plugin] error:" + err.Error())
+		writeError(w, err)
+	}
+}
+
+// serve 调用 op 对应的方法，成功时已经写入响应，失败时返回错误
+func (h *handler) serve(ctx context.Context, w http.ResponseWriter, r *http.Request, proto gateway.S3Protocol, op string, in json.RawMessage) error {
+
+	decode := func(v interface{}) error {
+		if err := json.Unmarshal(in, v); err != nil {
+			return awserr.NewRequestFailure(awserr.New("InvalidRequest", "invalid plugin input: "+err.Error(), nil), http.StatusBadRequest, "")
+		}
+		return nil
+	}
+
+	switch op {
+	case OpCreateBucket:
+		input := &s3.CreateBucketInput{}
+		if err := decode(input); err != nil {
+			return err
+		}
+		output, resp, err := proto.CreateBucketWithContext(ctx, input)
+		if err != nil {
+			return err
+		}
+		writeOutput(w, resp, output)
+	case OpHeadBucket:
+		input := &s3.HeadBucketInput{}
+		if err := decode(input); err != nil {
+			return err
+		}
+		output, resp, err := proto.HeadBucketWithContext(ctx, input)
+		if err != nil {
+			return err
+		}
+		writeOutput(w, resp, output)
+	case OpListBuckets:
+		input := &s3.ListBucketsInput{}
+		if err := decode(input); err != nil {
+			return err
+		}
+		output, resp, err := proto.ListBucketsWithContext(ctx, input)
+		if err != nil {
+			return err
+		}
+		writeOutput(w, resp, output)
+	case OpListObjects:
+		input := &s3.ListObjectsInput{}
+		if err := decode(input); err != nil {
+			return err
+		}
+		output, resp, err := proto.ListObjectsWithContext(ctx, input)
+		if err != nil {
+			return err
+		}
+		writeOutput(w, resp, output)
+	case OpListObjectsV2:
+		input := &s3.ListObjectsV2Input{}
+		if err := decode(input); err != nil {
+			return err
+		}
+		output, resp, err := proto.ListObjectsWithContextV2(ctx, input)
+		if err != nil {
+			return err
+		}
+		writeOutput(w, resp, output)
+	case OpDeleteBucket:
+		input := &s3.DeleteBucketInput{}
+		if err := decode(input); err != nil {
+			return err
+		}
+		output, resp, err := proto.DeleteBucketWithContext(ctx, input)
+		if err != nil {
+			return err
+		}
+		writeOutput(w, resp, output)
+	case OpPutObject:
+		input := &s3.PutObjectInput{}
+		if err := decode(input); err != nil {
+			return err
+		}
+		// 后端需要 io.ReadSeeker，先写入临时文件
+		body, size, err := gateway.Spool(r.Body)
+		if err != nil {
+			return err
+		}
+		defer body.Close()
+		input.Body = body
+		input.ContentLength = aws.Int64(size)
+		output, resp, err := proto.PutObjectWithContext(ctx, input)
+		if err != nil {
+			return err
+		}
+		writeOutput(w, resp, output)
+	case OpHeadObject:
+		input := &s3.HeadObjectInput{}
+		if err := decode(input); err != nil {
+			return err
+		}
+		output, resp, err := proto.HeadObjectWithContext(ctx, input)
+		if err != nil {
+			return err
+		}
+		writeOutput(w, resp, output)
+	case OpGetObject:
+		input := &s3.GetObjectInput{}
+		if err := decode(input); err != nil {
+			return err
+		}
+		output, resp, err := proto.GetObjectWithContext(ctx, input)
+		if err != nil {
+			return err
+		}
+		body := output.Body
+		defer body.Close()
+		output.Body = nil
+		meta, err := encodeHeader(output)
+		if err != nil {
+			return err
+		}
+		copyAmzHeader(w, resp)
+		w.Header().Set(HeaderResponse, meta)
+		w.Header().Set("Content-Type", "application/octet-stream")
+		if output.ContentLength != nil {
+			w.Header().Set("Content-Length", strconv.FormatInt(aws.Int64Value(output.ContentLength), 10))
+		}
+		w.WriteHeader(http.StatusOK)
+		if _, err := io.Copy(w, body); err != nil {
+			// 响应头已经写入，只能中断连接
+			zlog.ZError().Str("Op", op).Msg("[Plugin] write body error:" + err.Error())
+		}
+	case OpDeleteObject:
+		input := &s3.DeleteObjectInput{}
+		if err := decode(input); err != nil {
+			return err
+		}
+		output, resp, err := proto.DeleteObjectWithContext(ctx, input)
+		if err != nil {
+			return err
+		}
+		writeOutput(w, resp, output)
+	case OpCopyObject:
+		input := &s3.CopyObjectInput{}
+		if err := decode(input); err != nil {
+			return err
+		}
+		output, resp, err := proto.CopyObjectWithContext(ctx, input)
+		if err != nil {
+			return err
+		}
+		writeOutput(w, resp, output)
+	default:
+		return awserr.NewRequestFailure(awserr.New("NotImplemented", "A header you provided implies functionality that is not implemented", nil), http.StatusNotImplemented, "")
+	}
+	return nil
+}
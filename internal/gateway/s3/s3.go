@@ -30,11 +30,15 @@ type s3gw struct{}
 
 func (s *s3gw) Name() string     { return Backend }
 func (s *s3gw) Production() bool { return true }
+
+// Capabilities s3 支持全部方法和特性
+func (s *s3gw) Capabilities() gateway.Capabilities { return gateway.FullCapabilities() }
 func (s *s3gw) NewS3Protocol(creds auth.Credentials, region string, isDebug bool) (gateway.S3Protocol, error) {
 
 	cfg := &aws.Config{
 		Region:      aws.String(region),
 		Credentials: credentials.NewStaticCredentials(creds.AccessKey, creds.SecretKey, creds.SessionToken),
+		HTTPClient:  &http.Client{Transport: gateway.Transport()},
 	}
 
 	if isDebug {
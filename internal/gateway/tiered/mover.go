@@ -0,0 +1,118 @@
+package tiered
+
+import (
+	"context"
+	"time"
+
+	"github.com/solution9th/S3Adapter/internal/db"
+
+	"github.com/haozibi/zlog"
+)
+
+const (
+	defaultBatch = 100
+)
+
+// Lister 按照 id 顺序列出某一存储层的对象
+type Lister interface {
+	ListPlacement(tier string, afterID int64, limit int) ([]db.Placement, error)
+}
+
+// Resolver 根据应用的 key 获得分层存储，应用没有分层配置时返回 nil
+type Resolver func(oak string) (*Tiered, error)
+
+// Mover 后台迁移，定期扫描热存储中的对象，满足规则的迁移到冷存储
+type Mover struct {
+	lister   Lister
+	resolve  Resolver
+	interval time.Duration
+	batch    int
+}
+
+// NewMover 创建后台迁移，batch 为每次从数据库读取的数量
+func NewMover(lister Lister, resolve Resolver, interval time.Duration, batch int) *Mover {
+	if batch <= 0 {
+		batch = defaultBatch
+	}
+	return &Mover{
+		lister:   lister,
+		resolve:  resolve,
+		interval: interval,
+		batch:    batch,
+	}
+}
+
+// Run 每隔 interval 执行一次迁移，直到 ctx 结束
+func (m *Mover) Run(ctx context.Context) {
+
+	zlog.ZInfo().Str("Interval", m.interval.String()).Msg("[Tiered] mover start")
+
+	ticker := time.NewTicker(m.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			moved, err := m.RunOnce(ctx)
+			if err != nil {
+				zlog.ZError().Int("Moved", moved).Msg("[Tiered] mover error:" + err.Error())
+				continue
+			}
+			if moved > 0 {
+				zlog.ZInfo().Int("Moved", moved).Msg("[Tiered] mover")
+			}
+		}
+	}
+}
+
+// RunOnce 扫描一遍热存储中的对象，返回迁移的数量
+func (m *Mover) RunOnce(ctx context.Context) (int, error) {
+
+	var (
+		afterID int64
+		moved   int
+		now     = time.Now()
+		cache   = make(map[string]*Tiered)
+	)
+
+	for {
+		list, err := m.lister.ListPlacement(TierHot, afterID, m.batch)
+		if err != nil {
+			return moved, err
+		}
+
+		for _, p := range list {
+			if ctx.Err() != nil {
+				return moved, ctx.Err()
+			}
+			afterID = p.ID
+
+			t, ok := cache[p.OsAccessKey]
+			if !ok {
+				t, err = m.resolve(p.OsAccessKey)
+				if err != nil {
+					zlog.ZError().Str("OAK", p.OsAccessKey).Msg("[Tiered] resolve error:" + err.Error())
+				}
+				cache[p.OsAccessKey] = t
+			}
+			if t == nil || !t.ShouldDemote(p, now) {
+				continue
+			}
+
+			if err := t.Demote(ctx, p); err != nil {
+				if err == ErrObjectChanged {
+					continue
+				}
+				zlog.ZError().Str("Bucket", p.Bucket).Str("Object", p.Object).Msg("[Tiered] demote error:" + err.Error())
+				continue
+			}
+			moved++
+		}
+
+		if len(list) < m.batch {
+			return moved, nil
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package tiered
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/solution9th/S3Adapter/internal/db"
+)
+
+var (
+	// ErrInvalidRule rule without any condition
+	ErrInvalidRule = errors.New("tiered: rule need prefix, size or age")
+)
+
+// Rule 迁移规则，所有设置的条件都满足时对象会被迁移到冷存储
+type Rule struct {
+	// Prefix 对象名称前缀
+	Prefix string `xml:"Prefix" json:"prefix,omitempty"`
+
+	// MinSize 对象大小不小于 MinSize 字节
+	MinSize int64 `xml:"MinSize" json:"min_size,omitempty"`
+
+	// MinAgeDays 对象写入超过 MinAgeDays 天
+	MinAgeDays int `xml:"MinAgeDays" json:"min_age_days,omitempty"`
+}
+
+// Validate 规则至少需要一个条件
+func (r Rule) Validate() error {
+	if r.Prefix == "" && r.MinSize <= 0 && r.MinAgeDays <= 0 {
+		return ErrInvalidRule
+	}
+	if r.MinSize < 0 || r.MinAgeDays < 0 {
+		return ErrInvalidRule
+	}
+	return nil
+}
+
+// Match 对象是否满足规则
+func (r Rule) Match(p db.Placement, now time.Time) bool {
+
+	if r.Prefix != "" && !strings.HasPrefix(p.Object, r.Prefix) {
+		return false
+	}
+	if r.MinSize > 0 && p.Size < r.MinSize {
+		return false
+	}
+	if r.MinAgeDays > 0 && now.Sub(p.ModifyTime) < time.Duration(r.MinAgeDays)*24*time.Hour {
+		return false
+	}
+	return true
+}
+
+// Rules 多个规则满足任意一个即可
+type Rules []Rule
+
+// Match 对象是否满足任意一个规则
+func (rs Rules) Match(p db.Placement, now time.Time) bool {
+	for _, r := range rs {
+		if r.Match(p, now) {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseRules 解析数据库中保存的 JSON 规则
+func ParseRules(s string) (Rules, error) {
+
+	var rs Rules
+	if err := json.Unmarshal([]byte(s), &rs); err != nil {
+		return nil, err
+	}
+
+	for _, r := range rs {
+		if err := r.Validate(); err != nil {
+			return nil, err
+		}
+	}
+	return rs, nil
+}
+
+// String JSON 格式，保存到数据库
+func (rs Rules) String() string {
+	b, _ := json.Marshal(rs)
+	return string(b)
+}
@@ -0,0 +1,643 @@
+package tiered
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"io"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/solution9th/S3Adapter/internal/db"
+	"github.com/solution9th/S3Adapter/internal/gateway"
+	"github.com/solution9th/S3Adapter/internal/reqinfo"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+const (
+	// TierHot 热存储，所有写入都在热存储
+	TierHot = "hot"
+	// TierCold 冷存储，由后台迁移写入
+	TierCold = "cold"
+
+	defaultMaxKeys = 1000
+)
+
+// Store 对象所在存储层的记录
+type Store interface {
+	GetPlacement(oak, bucket, object string) (db.Placement, error)
+	SavePlacement(p db.Placement) error
+	DeletePlacement(oak, bucket, object string) error
+
+	// DemotePlacement 记录仍然是 hot 并且 ModifyTime 没有变化时改为 cold，返回是否修改
+	DemotePlacement(p db.Placement) (bool, error)
+}
+
+// Config 分层配置
+type Config struct {
+	// Rules 迁移到冷存储的规则
+	Rules Rules
+
+	// ColdStorageClass 写入冷存储时使用的存储类型，为空使用引擎默认值
+	ColdStorageClass string
+}
+
+// Tiered 组合热存储和冷存储两个引擎的 S3Protocol
+//
+// 写入都在热存储，后台按照规则把对象迁移到冷存储，读取时根据数据库中的记录
+// 从对应的存储层读取
+type Tiered struct {
+	oak   string
+	hot   gateway.S3Protocol
+	cold  gateway.S3Protocol
+	cfg   Config
+	store Store
+}
+
+// New 创建分层存储，oak 为应用的 key，用于记录对象所在的存储层
+func New(oak string, hot, cold gateway.S3Protocol, cfg Config, store Store) *Tiered {
+	return &Tiered{
+		oak:   oak,
+		hot:   hot,
+		cold:  cold,
+		cfg:   cfg,
+		store: store,
+	}
+}
+
+// Capabilities 对象可能在任一层，只有两层都支持的方法和特性才可用
+func (t *Tiered) Capabilities() gateway.Capabilities {
+	return gateway.CapabilitiesOf(t.hot).Intersect(gateway.CapabilitiesOf(t.cold))
+}
+
+func (t *Tiered) proto(tier string) gateway.S3Protocol {
+	if tier == TierCold {
+		return t.cold
+	}
+	return t.hot
+}
+
+// tierOf 对象所在的存储层，没有记录时返回空
+func (t *Tiered) tierOf(ctx context.Context, bucket, object string) string {
+
+	p, err := t.store.GetPlacement(t.oak, bucket, object)
+	if err != nil {
+		if err != db.ErrNotFound {
+			reqinfo.ZError(ctx).Str("Bucket", bucket).Str("Object", object).Msg("[Tiered] get placement error:" + err.Error())
+		}
+		return ""
+	}
+	return p.Tier
+}
+
+func (t *Tiered) savePlacement(ctx context.Context, bucket, object, tier string, size int64, modify time.Time) {
+
+	err := t.store.SavePlacement(db.Placement{
+		OsAccessKey: t.oak,
+		Bucket:      bucket,
+		Object:      object,
+		Tier:        tier,
+		Size:        size,
+		ModifyTime:  modify,
+	})
+	if err != nil {
+		reqinfo.ZError(ctx).Str("Bucket", bucket).Str("Object", object).Str("Tier", tier).Msg("[Tiered] save placement error:" + err.Error())
+	}
+}
+
+// =================
+// Bucket operations
+// =================
+
+// CreateBucketWithContext 同时在两个存储层创建 bucket
+func (t *Tiered) CreateBucketWithContext(ctx context.Context, input *s3.CreateBucketInput, opts ...request.Option) (*s3.CreateBucketOutput, *http.Response, error) {
+
+	output, resp, err := t.hot.CreateBucketWithContext(ctx, input, opts...)
+	if err != nil {
+		return output, gateway.Response(resp), err
+	}
+
+	_, _, err = t.cold.CreateBucketWithContext(ctx, input, opts...)
+	if err != nil && !gateway.IsCode(err, "BucketAlreadyOwnedByYou") {
+		reqinfo.ZError(ctx).Str("Bucket", aws.StringValue(input.Bucket)).Msg("[Tiered] create cold bucket error:" + err.Error())
+		return nil, gateway.Response(resp), err
+	}
+
+	return output, gateway.Response(resp), nil
+}
+
+func (t *Tiered) HeadBucketWithContext(ctx context.Context, input *s3.HeadBucketInput, opts ...request.Option) (*s3.HeadBucketOutput, *http.Response, error) {
+	output, resp, err := t.hot.HeadBucketWithContext(ctx, input, opts...)
+	return output, gateway.Response(resp), err
+}
+
+func (t *Tiered) ListBucketsWithContext(ctx context.Context, input *s3.ListBucketsInput, opts ...request.Option) (*s3.ListBucketsOutput, *http.Response, error) {
+	output, resp, err := t.hot.ListBucketsWithContext(ctx, input, opts...)
+	return output, gateway.Response(resp), err
+}
+
+// DeleteBucketWithContext 冷存储的 bucket 不存在时忽略，迁移时会重新创建
+func (t *Tiered) DeleteBucketWithContext(ctx context.Context, input *s3.DeleteBucketInput, opts ...request.Option) (*s3.DeleteBucketOutput, *http.Response, error) {
+
+	output, resp, err := t.hot.DeleteBucketWithContext(ctx, input, opts...)
+	if err != nil {
+		return output, gateway.Response(resp), err
+	}
+
+	_, _, err = t.cold.DeleteBucketWithContext(ctx, &s3.DeleteBucketInput{Bucket: input.Bucket}, opts...)
+	if err != nil && !gateway.IsNotFound(err) {
+		return nil, gateway.Response(resp), err
+	}
+
+	return output, gateway.Response(resp), nil
+}
+
+// listing 一个存储层的列举结果
+type listing struct {
+	contents  []*s3.Object
+	prefixes  []*s3.CommonPrefix
+	truncated bool
+}
+
+type listEntry struct {
+	key    string
+	object *s3.Object
+}
+
+// last 列举结果中最大的 key
+func (l listing) last() string {
+	var last string
+	for _, v := range l.contents {
+		if k := aws.StringValue(v.Key); k > last {
+			last = k
+		}
+	}
+	for _, v := range l.prefixes {
+		if k := aws.StringValue(v.Prefix); k > last {
+			last = k
+		}
+	}
+	return last
+}
+
+// mergeListing 合并两个存储层的列举结果
+//
+// 被截断的列举结果只能保证到最后一个 key 之前是完整的，所以合并后只保留
+// 不大于所有被截断结果最后一个 key 的部分
+func mergeListing(maxKeys int64, lists ...listing) ([]*s3.Object, []*s3.CommonPrefix, string, bool) {
+
+	if maxKeys <= 0 {
+		maxKeys = defaultMaxKeys
+	}
+
+	var (
+		cutoff    string
+		hasCutoff bool
+		truncated bool
+	)
+	for _, l := range lists {
+		if !l.truncated {
+			continue
+		}
+		truncated = true
+		if last := l.last(); !hasCutoff || last < cutoff {
+			cutoff, hasCutoff = last, true
+		}
+	}
+
+	seen := make(map[string]bool)
+	var entries []listEntry
+	for _, l := range lists {
+		for _, v := range l.contents {
+			k := aws.StringValue(v.Key)
+			if !seen[k] {
+				seen[k] = true
+				entries = append(entries, listEntry{key: k, object: v})
+			}
+		}
+		for _, v := range l.prefixes {
+			k := aws.StringValue(v.Prefix)
+			if !seen[k] {
+				seen[k] = true
+				entries = append(entries, listEntry{key: k})
+			}
+		}
+	}
+
+	sort.Slice(entries, func(i, j int) bool { return entries[i].key < entries[j].key })
+
+	if hasCutoff {
+		n := sort.Search(len(entries), func(i int) bool { return entries[i].key > cutoff })
+		entries = entries[:n]
+	}
+
+	if int64(len(entries)) > maxKeys {
+		entries = entries[:maxKeys]
+		truncated = true
+	}
+
+	contents := make([]*s3.Object, 0, len(entries))
+	prefixes := make([]*s3.CommonPrefix, 0)
+	var next string
+	for _, e := range entries {
+		next = e.key
+		if e.object != nil {
+			contents = append(contents, e.object)
+			continue
+		}
+		prefixes = append(prefixes, &s3.CommonPrefix{Prefix: aws.String(e.key)})
+	}
+
+	if !truncated {
+		next = ""
+	}
+	return contents, prefixes, next, truncated
+}
+
+// ListObjectsWithContext 合并两个存储层的结果
+func (t *Tiered) ListObjectsWithContext(ctx context.Context, input *s3.ListObjectsInput, opts ...request.Option) (*s3.ListObjectsOutput, *http.Response, error) {
+
+	hot, resp, err := t.hot.ListObjectsWithContext(ctx, input, opts...)
+	if err != nil {
+		return nil, gateway.Response(resp), err
+	}
+
+	coldInput := *input
+	cold, _, err := t.cold.ListObjectsWithContext(ctx, &coldInput, opts...)
+	if err != nil && !gateway.IsNotFound(err) {
+		return nil, gateway.Response(resp), err
+	}
+	if cold == nil {
+		cold = &s3.ListObjectsOutput{}
+	}
+
+	contents, prefixes, next, truncated := mergeListing(aws.Int64Value(input.MaxKeys),
+		listing{hot.Contents, hot.CommonPrefixes, aws.BoolValue(hot.IsTruncated)},
+		listing{cold.Contents, cold.CommonPrefixes, aws.BoolValue(cold.IsTruncated)},
+	)
+
+	hot.Contents = contents
+	hot.CommonPrefixes = prefixes
+	hot.IsTruncated = aws.Bool(truncated)
+	hot.NextMarker = nil
+	if truncated {
+		hot.NextMarker = aws.String(next)
+	}
+
+	return hot, gateway.Response(resp), nil
+}
+
+// ListObjectsWithContextV2 两个引擎的 ContinuationToken 不能通用，统一转换为 StartAfter
+func (t *Tiered) ListObjectsWithContextV2(ctx context.Context, input *s3.ListObjectsV2Input, opts ...request.Option) (*s3.ListObjectsV2Output, *http.Response, error) {
+
+	startAfter := aws.StringValue(input.StartAfter)
+	if input.ContinuationToken != nil {
+		token, err := base64.StdEncoding.DecodeString(aws.StringValue(input.ContinuationToken))
+		if err != nil {
+			return nil, gateway.Response(nil), awserr.NewRequestFailure(awserr.New("InvalidArgument", "The continuation token provided is incorrect", nil), http.StatusBadRequest, "")
+		}
+		if string(token) > startAfter {
+			startAfter = string(token)
+		}
+	}
+
+	tierInput := *input
+	tierInput.ContinuationToken = nil
+	if startAfter != "" {
+		tierInput.StartAfter = aws.String(startAfter)
+	}
+
+	hot, resp, err := t.hot.ListObjectsWithContextV2(ctx, &tierInput, opts...)
+	if err != nil {
+		return nil, gateway.Response(resp), err
+	}
+
+	coldInput := tierInput
+	cold, _, err := t.cold.ListObjectsWithContextV2(ctx, &coldInput, opts...)
+	if err != nil && !gateway.IsNotFound(err) {
+		return nil, gateway.Response(resp), err
+	}
+	if cold == nil {
+		cold = &s3.ListObjectsV2Output{}
+	}
+
+	contents, prefixes, next, truncated := mergeListing(aws.Int64Value(input.MaxKeys),
+		listing{hot.Contents, hot.CommonPrefixes, aws.BoolValue(hot.IsTruncated)},
+		listing{cold.Contents, cold.CommonPrefixes, aws.BoolValue(cold.IsTruncated)},
+	)
+
+	hot.Contents = contents
+	hot.CommonPrefixes = prefixes
+	hot.KeyCount = aws.Int64(int64(len(contents) + len(prefixes)))
+	hot.IsTruncated = aws.Bool(truncated)
+	hot.StartAfter = input.StartAfter
+	hot.ContinuationToken = input.ContinuationToken
+	hot.NextContinuationToken = nil
+	if truncated {
+		hot.NextContinuationToken = aws.String(base64.StdEncoding.EncodeToString([]byte(next)))
+	}
+
+	return hot, gateway.Response(resp), nil
+}
+
+// =================
+// Object operations
+// =================
+
+// bodySize 上传对象的大小
+func bodySize(input *s3.PutObjectInput) int64 {
+
+	if input.ContentLength != nil {
+		return aws.Int64Value(input.ContentLength)
+	}
+	if input.Body == nil {
+		return 0
+	}
+
+	cur, err := input.Body.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return 0
+	}
+	end, err := input.Body.Seek(0, io.SeekEnd)
+	if err != nil {
+		return 0
+	}
+	input.Body.Seek(cur, io.SeekStart)
+	return end - cur
+}
+
+// PutObjectWithContext 写入热存储，如果之前在冷存储则删除冷存储中的旧对象
+func (t *Tiered) PutObjectWithContext(ctx context.Context, input *s3.PutObjectInput, opts ...request.Option) (*s3.PutObjectOutput, *http.Response, error) {
+
+	bucket, object := aws.StringValue(input.Bucket), aws.StringValue(input.Key)
+	size := bodySize(input)
+	prev := t.tierOf(ctx, bucket, object)
+
+	output, resp, err := t.hot.PutObjectWithContext(ctx, input, opts...)
+	if err != nil {
+		return output, gateway.Response(resp), err
+	}
+
+	t.savePlacement(ctx, bucket, object, TierHot, size, time.Now())
+
+	if prev == TierCold {
+		t.deleteCold(ctx, bucket, object)
+	}
+
+	return output, gateway.Response(resp), nil
+}
+
+// deleteCold 对象重新写入热存储后删除冷存储中的旧对象
+func (t *Tiered) deleteCold(ctx context.Context, bucket, object string) {
+
+	_, _, err := t.cold.DeleteObjectWithContext(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(object),
+	})
+	if err != nil {
+		reqinfo.ZError(ctx).Str("Bucket", bucket).Str("Object", object).Msg("[Tiered] delete cold object error:" + err.Error())
+	}
+}
+
+// GetObjectWithContext 没有记录的对象先从热存储读取，不存在再从冷存储读取
+func (t *Tiered) GetObjectWithContext(ctx context.Context, input *s3.GetObjectInput, opts ...request.Option) (*s3.GetObjectOutput, *http.Response, error) {
+
+	tier := t.tierOf(ctx, aws.StringValue(input.Bucket), aws.StringValue(input.Key))
+	if tier != "" {
+		output, resp, err := t.proto(tier).GetObjectWithContext(ctx, input, opts...)
+		return output, gateway.Response(resp), err
+	}
+
+	output, resp, err := t.hot.GetObjectWithContext(ctx, input, opts...)
+	if err != nil && gateway.IsNotFound(err) {
+		if o, r, e := t.cold.GetObjectWithContext(ctx, input, opts...); e == nil {
+			return o, gateway.Response(r), nil
+		}
+	}
+	return output, gateway.Response(resp), err
+}
+
+func (t *Tiered) HeadObjectWithContext(ctx context.Context, input *s3.HeadObjectInput, opts ...request.Option) (*s3.HeadObjectOutput, *http.Response, error) {
+
+	tier := t.tierOf(ctx, aws.StringValue(input.Bucket), aws.StringValue(input.Key))
+	if tier != "" {
+		output, resp, err := t.proto(tier).HeadObjectWithContext(ctx, input, opts...)
+		return output, gateway.Response(resp), err
+	}
+
+	output, resp, err := t.hot.HeadObjectWithContext(ctx, input, opts...)
+	if err != nil && gateway.IsNotFound(err) {
+		if o, r, e := t.cold.HeadObjectWithContext(ctx, input, opts...); e == nil {
+			return o, gateway.Response(r), nil
+		}
+	}
+	return output, gateway.Response(resp), err
+}
+
+// DeleteObjectWithContext 没有记录的对象两个存储层都删除
+func (t *Tiered) DeleteObjectWithContext(ctx context.Context, input *s3.DeleteObjectInput, opts ...request.Option) (*s3.DeleteObjectOutput, *http.Response, error) {
+
+	bucket, object := aws.StringValue(input.Bucket), aws.StringValue(input.Key)
+
+	tier := t.tierOf(ctx, bucket, object)
+	if tier != "" {
+		output, resp, err := t.proto(tier).DeleteObjectWithContext(ctx, input, opts...)
+		if err != nil {
+			return output, gateway.Response(resp), err
+		}
+		if err := t.store.DeletePlacement(t.oak, bucket, object); err != nil {
+			reqinfo.ZError(ctx).Str("Bucket", bucket).Str("Object", object).Msg("[Tiered] delete placement error:" + err.Error())
+		}
+		return output, gateway.Response(resp), nil
+	}
+
+	output, resp, err := t.hot.DeleteObjectWithContext(ctx, input, opts...)
+	if err != nil {
+		return output, gateway.Response(resp), err
+	}
+
+	coldInput := *input
+	if _, _, err := t.cold.DeleteObjectWithContext(ctx, &coldInput, opts...); err != nil && !gateway.IsNotFound(err) {
+		reqinfo.ZError(ctx).Str("Bucket", bucket).Str("Object", object).Msg("[Tiered] delete cold object error:" + err.Error())
+	}
+
+	return output, gateway.Response(resp), nil
+}
+
+// CopyObjectWithContext 目标对象写入热存储，源对象在冷存储时通过读取再写入完成
+func (t *Tiered) CopyObjectWithContext(ctx context.Context, input *s3.CopyObjectInput, opts ...request.Option) (*s3.CopyObjectOutput, *http.Response, error) {
+
+	bucket, object := aws.StringValue(input.Bucket), aws.StringValue(input.Key)
+
+	srcBucket, srcObject, ok := gateway.ParseCopySource(aws.StringValue(input.CopySource))
+	if !ok {
+		return t.hot.CopyObjectWithContext(ctx, input, opts...)
+	}
+
+	src, err := t.store.GetPlacement(t.oak, srcBucket, srcObject)
+	if err != nil || src.Tier != TierCold {
+		prev := t.tierOf(ctx, bucket, object)
+		output, resp, err := t.hot.CopyObjectWithContext(ctx, input, opts...)
+		if err != nil {
+			return output, gateway.Response(resp), err
+		}
+		if prev == TierCold {
+			t.deleteCold(ctx, bucket, object)
+		}
+		size := src.Size
+		if src.Tier == "" {
+			if head, _, err := t.hot.HeadObjectWithContext(ctx, &s3.HeadObjectInput{Bucket: input.Bucket, Key: input.Key}); err == nil {
+				size = aws.Int64Value(head.ContentLength)
+			}
+		}
+		t.savePlacement(ctx, bucket, object, TierHot, size, time.Now())
+		return output, gateway.Response(resp), nil
+	}
+
+	get, resp, err := t.cold.GetObjectWithContext(ctx, &s3.GetObjectInput{
+		Bucket:            aws.String(srcBucket),
+		Key:               aws.String(srcObject),
+		IfMatch:           input.CopySourceIfMatch,
+		IfNoneMatch:       input.CopySourceIfNoneMatch,
+		IfModifiedSince:   input.CopySourceIfModifiedSince,
+		IfUnmodifiedSince: input.CopySourceIfUnmodifiedSince,
+	}, opts...)
+	if err != nil {
+		return nil, gateway.Response(resp), err
+	}
+
+	body, size, err := gateway.Spool(get.Body)
+	if err != nil {
+		return nil, gateway.Response(nil), err
+	}
+	defer body.Close()
+
+	putInput := &s3.PutObjectInput{
+		Bucket:        input.Bucket,
+		Key:           input.Key,
+		Body:          body,
+		ContentLength: aws.Int64(size),
+		Metadata:      get.Metadata,
+		ContentType:   get.ContentType,
+	}
+	if aws.StringValue(input.MetadataDirective) == s3.MetadataDirectiveReplace {
+		putInput.Metadata = input.Metadata
+		putInput.ContentType = input.ContentType
+	}
+
+	put, resp, err := t.PutObjectWithContext(ctx, putInput, opts...)
+	if err != nil {
+		return nil, gateway.Response(resp), err
+	}
+
+	return &s3.CopyObjectOutput{
+		CopyObjectResult: &s3.CopyObjectResult{
+			ETag:         put.ETag,
+			LastModified: aws.Time(time.Now().UTC()),
+		},
+	}, gateway.Response(resp), nil
+}
+
+// ErrObjectChanged 迁移期间对象被重新写入，没有迁移
+var ErrObjectChanged = errors.New("tiered: object changed during demote")
+
+// ShouldDemote 对象是否需要迁移到冷存储
+func (t *Tiered) ShouldDemote(p db.Placement, now time.Time) bool {
+	return p.Tier == TierHot && t.cfg.Rules.Match(p, now)
+}
+
+// Demote 把对象从热存储迁移到冷存储
+//
+// 先写入冷存储并更新记录，最后删除热存储中的对象，中途失败时对象仍然可以读取。
+// 迁移期间对象被重新写入时不修改记录，热存储中的对象 ETag 变化时不删除
+func (t *Tiered) Demote(ctx context.Context, p db.Placement) error {
+
+	get, _, err := t.hot.GetObjectWithContext(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(p.Bucket),
+		Key:    aws.String(p.Object),
+	})
+	if err != nil {
+		if gateway.IsNotFound(err) {
+			// 对象已经不存在，删除记录
+			return t.store.DeletePlacement(t.oak, p.Bucket, p.Object)
+		}
+		return err
+	}
+
+	body, size, err := gateway.Spool(get.Body)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+
+	input := &s3.PutObjectInput{
+		Bucket:        aws.String(p.Bucket),
+		Key:           aws.String(p.Object),
+		Body:          body,
+		ContentLength: aws.Int64(size),
+		Metadata:      get.Metadata,
+		ContentType:   get.ContentType,
+	}
+	if t.cfg.ColdStorageClass != "" {
+		input.StorageClass = aws.String(t.cfg.ColdStorageClass)
+	}
+
+	_, _, err = t.cold.PutObjectWithContext(ctx, input)
+	if err != nil && gateway.IsCode(err, "NoSuchBucket") {
+		_, _, err = t.cold.CreateBucketWithContext(ctx, &s3.CreateBucketInput{Bucket: aws.String(p.Bucket)})
+		if err != nil && !gateway.IsCode(err, "BucketAlreadyOwnedByYou") {
+			return err
+		}
+		if _, err = body.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		_, _, err = t.cold.PutObjectWithContext(ctx, input)
+	}
+	if err != nil {
+		return err
+	}
+
+	p.Size = size
+	ok, err := t.store.DemotePlacement(p)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		// 冷存储中的副本是旧的，下次迁移时会被覆盖
+		reqinfo.ZInfo(ctx).Str("Bucket", p.Bucket).Str("Object", p.Object).Msg("[Tiered] demote skipped, object changed")
+		return ErrObjectChanged
+	}
+
+	head, _, err := t.hot.HeadObjectWithContext(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(p.Bucket),
+		Key:    aws.String(p.Object),
+	})
+	if err != nil {
+		if !gateway.IsNotFound(err) {
+			reqinfo.ZError(ctx).Str("Bucket", p.Bucket).Str("Object", p.Object).Msg("[Tiered] head hot object error:" + err.Error())
+		}
+		return nil
+	}
+	if aws.StringValue(head.ETag) != aws.StringValue(get.ETag) {
+		reqinfo.ZInfo(ctx).Str("Bucket", p.Bucket).Str("Object", p.Object).Msg("[Tiered] hot object changed, keep it")
+		return nil
+	}
+
+	_, _, err = t.hot.DeleteObjectWithContext(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(p.Bucket),
+		Key:    aws.String(p.Object),
+	})
+	if err != nil {
+		reqinfo.ZError(ctx).Str("Bucket", p.Bucket).Str("Object", p.Object).Msg("[Tiered] delete hot object error:" + err.Error())
+	}
+
+	reqinfo.ZInfo(ctx).Str("Bucket", p.Bucket).Str("Object", p.Object).Int64("Size", size).Msg("[Tiered] demote")
+	return nil
+}
+
+var _ gateway.S3Protocol = (*Tiered)(nil)
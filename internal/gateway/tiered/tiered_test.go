@@ -0,0 +1,596 @@
+package tiered
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/solution9th/S3Adapter/internal/db"
+	"github.com/solution9th/S3Adapter/internal/gateway"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/smartystreets/goconvey/convey"
+)
+
+type memObject struct {
+	data         []byte
+	metadata     map[string]*string
+	storageClass string
+}
+
+func (o *memObject) etag() *string {
+	return aws.String(fmt.Sprintf("\"%x\"", md5.Sum(o.data)))
+}
+
+// memProto 内存中的 S3Protocol
+type memProto struct {
+	gateway.GatewayUnsupported
+
+	mu      sync.Mutex
+	buckets map[string]map[string]*memObject
+
+	// afterGet 读取对象后调用，模拟并发写入
+	afterGet func()
+}
+
+func newMemProto() *memProto {
+	return &memProto{buckets: make(map[string]map[string]*memObject)}
+}
+
+func emptyResp() *http.Response { return &http.Response{Header: make(http.Header)} }
+
+func memErr(code string, status int) error {
+	return awserr.NewRequestFailure(awserr.New(code, code, nil), status, "")
+}
+
+func (m *memProto) CreateBucketWithContext(ctx context.Context, input *s3.CreateBucketInput, opts ...request.Option) (*s3.CreateBucketOutput, *http.Response, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.buckets[*input.Bucket]; ok {
+		return nil, emptyResp(), memErr("BucketAlreadyOwnedByYou", http.StatusConflict)
+	}
+	m.buckets[*input.Bucket] = make(map[string]*memObject)
+	return &s3.CreateBucketOutput{}, emptyResp(), nil
+}
+
+func (m *memProto) HeadBucketWithContext(ctx context.Context, input *s3.HeadBucketInput, opts ...request.Option) (*s3.HeadBucketOutput, *http.Response, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.buckets[*input.Bucket]; !ok {
+		return nil, emptyResp(), memErr("NotFound", http.StatusNotFound)
+	}
+	return &s3.HeadBucketOutput{}, emptyResp(), nil
+}
+
+func (m *memProto) ListBucketsWithContext(ctx context.Context, input *s3.ListBucketsInput, opts ...request.Option) (*s3.ListBucketsOutput, *http.Response, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	output := &s3.ListBucketsOutput{}
+	for k := range m.buckets {
+		output.Buckets = append(output.Buckets, &s3.Bucket{Name: aws.String(k)})
+	}
+	return output, emptyResp(), nil
+}
+
+func (m *memProto) DeleteBucketWithContext(ctx context.Context, input *s3.DeleteBucketInput, opts ...request.Option) (*s3.DeleteBucketOutput, *http.Response, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	b, ok := m.buckets[*input.Bucket]
+	if !ok {
+		return nil, emptyResp(), memErr("NoSuchBucket", http.StatusNotFound)
+	}
+	if len(b) > 0 {
+		return nil, emptyResp(), memErr("BucketNotEmpty", http.StatusConflict)
+	}
+	delete(m.buckets, *input.Bucket)
+	return &s3.DeleteBucketOutput{}, emptyResp(), nil
+}
+
+// list 简单实现 prefix/delimiter/marker/maxKeys
+func (m *memProto) list(bucket, prefix, delimiter, marker string, maxKeys int64) ([]*s3.Object, []*s3.CommonPrefix, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	b, ok := m.buckets[bucket]
+	if !ok {
+		return nil, nil, false, memErr("NoSuchBucket", http.StatusNotFound)
+	}
+	if maxKeys <= 0 {
+		maxKeys = 1000
+	}
+
+	keys := make([]string, 0, len(b))
+	for k := range b {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var (
+		contents []*s3.Object
+		prefixes []*s3.CommonPrefix
+		seen     = make(map[string]bool)
+		count    int64
+	)
+	for _, k := range keys {
+		if !strings.HasPrefix(k, prefix) || k <= marker {
+			continue
+		}
+		if delimiter != "" {
+			if i := strings.Index(k[len(prefix):], delimiter); i >= 0 {
+				p := k[:len(prefix)+i+len(delimiter)]
+				if seen[p] || p <= marker {
+					continue
+				}
+				if count == maxKeys {
+					return contents, prefixes, true, nil
+				}
+				seen[p] = true
+				prefixes = append(prefixes, &s3.CommonPrefix{Prefix: aws.String(p)})
+				count++
+				continue
+			}
+		}
+		if count == maxKeys {
+			return contents, prefixes, true, nil
+		}
+		contents = append(contents, &s3.Object{Key: aws.String(k), Size: aws.Int64(int64(len(b[k].data)))})
+		count++
+	}
+	return contents, prefixes, false, nil
+}
+
+func (m *memProto) ListObjectsWithContext(ctx context.Context, input *s3.ListObjectsInput, opts ...request.Option) (*s3.ListObjectsOutput, *http.Response, error) {
+	contents, prefixes, truncated, err := m.list(*input.Bucket, aws.StringValue(input.Prefix), aws.StringValue(input.Delimiter), aws.StringValue(input.Marker), aws.Int64Value(input.MaxKeys))
+	if err != nil {
+		return nil, emptyResp(), err
+	}
+	return &s3.ListObjectsOutput{Contents: contents, CommonPrefixes: prefixes, IsTruncated: aws.Bool(truncated)}, emptyResp(), nil
+}
+
+func (m *memProto) ListObjectsWithContextV2(ctx context.Context, input *s3.ListObjectsV2Input, opts ...request.Option) (*s3.ListObjectsV2Output, *http.Response, error) {
+	contents, prefixes, truncated, err := m.list(*input.Bucket, aws.StringValue(input.Prefix), aws.StringValue(input.Delimiter), aws.StringValue(input.StartAfter), aws.Int64Value(input.MaxKeys))
+	if err != nil {
+		return nil, emptyResp(), err
+	}
+	return &s3.ListObjectsV2Output{Contents: contents, CommonPrefixes: prefixes, IsTruncated: aws.Bool(truncated)}, emptyResp(), nil
+}
+
+func (m *memProto) PutObjectWithContext(ctx context.Context, input *s3.PutObjectInput, opts ...request.Option) (*s3.PutObjectOutput, *http.Response, error) {
+	data, _ := ioutil.ReadAll(input.Body)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	b, ok := m.buckets[*input.Bucket]
+	if !ok {
+		return nil, emptyResp(), memErr("NoSuchBucket", http.StatusNotFound)
+	}
+	b[*input.Key] = &memObject{data: data, metadata: input.Metadata, storageClass: aws.StringValue(input.StorageClass)}
+	return &s3.PutObjectOutput{ETag: b[*input.Key].etag()}, emptyResp(), nil
+}
+
+func (m *memProto) get(bucket, key string) (*memObject, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	b, ok := m.buckets[bucket]
+	if !ok {
+		return nil, memErr("NoSuchBucket", http.StatusNotFound)
+	}
+	o, ok := b[key]
+	if !ok {
+		return nil, memErr("NoSuchKey", http.StatusNotFound)
+	}
+	return o, nil
+}
+
+func (m *memProto) HeadObjectWithContext(ctx context.Context, input *s3.HeadObjectInput, opts ...request.Option) (*s3.HeadObjectOutput, *http.Response, error) {
+	o, err := m.get(*input.Bucket, *input.Key)
+	if err != nil {
+		return nil, emptyResp(), err
+	}
+	return &s3.HeadObjectOutput{ContentLength: aws.Int64(int64(len(o.data))), Metadata: o.metadata, ETag: o.etag()}, emptyResp(), nil
+}
+
+func (m *memProto) GetObjectWithContext(ctx context.Context, input *s3.GetObjectInput, opts ...request.Option) (*s3.GetObjectOutput, *http.Response, error) {
+	o, err := m.get(*input.Bucket, *input.Key)
+	if err != nil {
+		return nil, emptyResp(), err
+	}
+	if m.afterGet != nil {
+		m.afterGet()
+	}
+	return &s3.GetObjectOutput{
+		Body:          ioutil.NopCloser(bytes.NewReader(o.data)),
+		ContentLength: aws.Int64(int64(len(o.data))),
+		Metadata:      o.metadata,
+		ETag:          o.etag(),
+	}, emptyResp(), nil
+}
+
+func (m *memProto) DeleteObjectWithContext(ctx context.Context, input *s3.DeleteObjectInput, opts ...request.Option) (*s3.DeleteObjectOutput, *http.Response, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if b, ok := m.buckets[*input.Bucket]; ok {
+		delete(b, *input.Key)
+	}
+	return &s3.DeleteObjectOutput{}, emptyResp(), nil
+}
+
+func (m *memProto) CopyObjectWithContext(ctx context.Context, input *s3.CopyObjectInput, opts ...request.Option) (*s3.CopyObjectOutput, *http.Response, error) {
+	bucket, object, _ := gateway.ParseCopySource(*input.CopySource)
+	o, err := m.get(bucket, object)
+	if err != nil {
+		return nil, emptyResp(), err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	cp := *o
+	m.buckets[*input.Bucket][*input.Key] = &cp
+	return &s3.CopyObjectOutput{CopyObjectResult: &s3.CopyObjectResult{}}, emptyResp(), nil
+}
+
+func (m *memProto) has(bucket, key string) bool {
+	_, err := m.get(bucket, key)
+	return err == nil
+}
+
+// memStore 内存中的 placement 记录
+type memStore struct {
+	mu     sync.Mutex
+	nextID int64
+	data   map[string]db.Placement
+}
+
+func newMemStore() *memStore {
+	return &memStore{data: make(map[string]db.Placement)}
+}
+
+func (s *memStore) GetPlacement(oak, bucket, object string) (db.Placement, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p, ok := s.data[oak+"/"+bucket+"/"+object]
+	if !ok {
+		return p, db.ErrNotFound
+	}
+	return p, nil
+}
+
+func (s *memStore) SavePlacement(p db.Placement) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	k := p.OsAccessKey + "/" + p.Bucket + "/" + p.Object
+	if old, ok := s.data[k]; ok {
+		p.ID = old.ID
+	} else {
+		s.nextID++
+		p.ID = s.nextID
+	}
+	s.data[k] = p
+	return nil
+}
+
+func (s *memStore) DeletePlacement(oak, bucket, object string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.data, oak+"/"+bucket+"/"+object)
+	return nil
+}
+
+func (s *memStore) DemotePlacement(p db.Placement) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	k := p.OsAccessKey + "/" + p.Bucket + "/" + p.Object
+	old, ok := s.data[k]
+	if !ok || old.Tier != TierHot || !old.ModifyTime.Equal(p.ModifyTime) {
+		return false, nil
+	}
+	old.Tier, old.Size = TierCold, p.Size
+	s.data[k] = old
+	return true, nil
+}
+
+func (s *memStore) ListPlacement(tier string, afterID int64, limit int) ([]db.Placement, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var list []db.Placement
+	for _, p := range s.data {
+		if p.Tier == tier && p.ID > afterID {
+			list = append(list, p)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+	if len(list) > limit {
+		list = list[:limit]
+	}
+	return list, nil
+}
+
+// age 修改对象的写入时间
+func (s *memStore) age(oak, bucket, object string, d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	k := oak + "/" + bucket + "/" + object
+	p := s.data[k]
+	p.ModifyTime = p.ModifyTime.Add(-d)
+	s.data[k] = p
+}
+
+func readBody(output *s3.GetObjectOutput) string {
+	b, _ := ioutil.ReadAll(output.Body)
+	output.Body.Close()
+	return string(b)
+}
+
+func TestTiered(t *testing.T) {
+
+	convey.Convey("tiered", t, func() {
+
+		ctx := context.Background()
+		hot, cold, store := newMemProto(), newMemProto(), newMemStore()
+
+		tp := New("oak", hot, cold, Config{
+			Rules: Rules{
+				{Prefix: "logs/"},
+				{MinSize: 10, MinAgeDays: 30},
+			},
+			ColdStorageClass: "ARCHIVE",
+		}, store)
+
+		resolve := func(oak string) (*Tiered, error) {
+			if oak != "oak" {
+				return nil, nil
+			}
+			return tp, nil
+		}
+		mover := NewMover(store, resolve, time.Hour, 1)
+
+		bucket := aws.String("bk")
+		_, resp, err := tp.CreateBucketWithContext(ctx, &s3.CreateBucketInput{Bucket: bucket})
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(resp, convey.ShouldNotBeNil)
+		convey.So(cold.buckets, convey.ShouldContainKey, "bk")
+
+		put := func(key, body string) {
+			_, _, err := tp.PutObjectWithContext(ctx, &s3.PutObjectInput{
+				Bucket:   bucket,
+				Key:      aws.String(key),
+				Body:     strings.NewReader(body),
+				Metadata: map[string]*string{"Foo": aws.String("bar")},
+			})
+			convey.So(err, convey.ShouldBeNil)
+		}
+
+		put("logs/1.log", "log")
+		put("big.bin", "0123456789abcdef")
+		put("small.txt", "small")
+
+		p, err := store.GetPlacement("oak", "bk", "big.bin")
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(p.Tier, convey.ShouldEqual, TierHot)
+		convey.So(p.Size, convey.ShouldEqual, 16)
+
+		convey.Convey("demote by prefix, size and age", func() {
+
+			moved, err := mover.RunOnce(ctx)
+			convey.So(err, convey.ShouldBeNil)
+			convey.So(moved, convey.ShouldEqual, 1)
+			convey.So(hot.has("bk", "logs/1.log"), convey.ShouldBeFalse)
+			convey.So(cold.has("bk", "logs/1.log"), convey.ShouldBeTrue)
+			convey.So(cold.buckets["bk"]["logs/1.log"].storageClass, convey.ShouldEqual, "ARCHIVE")
+
+			store.age("oak", "bk", "big.bin", 31*24*time.Hour)
+			store.age("oak", "bk", "small.txt", 31*24*time.Hour)
+
+			moved, err = mover.RunOnce(ctx)
+			convey.So(err, convey.ShouldBeNil)
+			convey.So(moved, convey.ShouldEqual, 1)
+			convey.So(cold.has("bk", "big.bin"), convey.ShouldBeTrue)
+			convey.So(hot.has("bk", "small.txt"), convey.ShouldBeTrue)
+
+			get, _, err := tp.GetObjectWithContext(ctx, &s3.GetObjectInput{Bucket: bucket, Key: aws.String("big.bin")})
+			convey.So(err, convey.ShouldBeNil)
+			convey.So(readBody(get), convey.ShouldEqual, "0123456789abcdef")
+			convey.So(aws.StringValue(get.Metadata["Foo"]), convey.ShouldEqual, "bar")
+
+			head, _, err := tp.HeadObjectWithContext(ctx, &s3.HeadObjectInput{Bucket: bucket, Key: aws.String("logs/1.log")})
+			convey.So(err, convey.ShouldBeNil)
+			convey.So(aws.Int64Value(head.ContentLength), convey.ShouldEqual, 3)
+
+			list, _, err := tp.ListObjectsWithContext(ctx, &s3.ListObjectsInput{Bucket: bucket})
+			convey.So(err, convey.ShouldBeNil)
+			convey.So(len(list.Contents), convey.ShouldEqual, 3)
+			convey.So(aws.StringValue(list.Contents[0].Key), convey.ShouldEqual, "big.bin")
+			convey.So(aws.StringValue(list.Contents[1].Key), convey.ShouldEqual, "logs/1.log")
+
+			list2, _, err := tp.ListObjectsWithContextV2(ctx, &s3.ListObjectsV2Input{Bucket: bucket, Delimiter: aws.String("/")})
+			convey.So(err, convey.ShouldBeNil)
+			convey.So(aws.Int64Value(list2.KeyCount), convey.ShouldEqual, 3)
+			convey.So(aws.StringValue(list2.CommonPrefixes[0].Prefix), convey.ShouldEqual, "logs/")
+
+			convey.Convey("paging across tiers", func() {
+				var got []string
+				var token *string
+				for {
+					out, _, err := tp.ListObjectsWithContextV2(ctx, &s3.ListObjectsV2Input{Bucket: bucket, MaxKeys: aws.Int64(1), ContinuationToken: token})
+					convey.So(err, convey.ShouldBeNil)
+					for _, v := range out.Contents {
+						got = append(got, aws.StringValue(v.Key))
+					}
+					if !aws.BoolValue(out.IsTruncated) {
+						break
+					}
+					token = out.NextContinuationToken
+				}
+				convey.So(got, convey.ShouldResemble, []string{"big.bin", "logs/1.log", "small.txt"})
+			})
+
+			convey.Convey("overwrite moves object back to hot", func() {
+				put("big.bin", "new")
+				convey.So(cold.has("bk", "big.bin"), convey.ShouldBeFalse)
+				get, _, err := tp.GetObjectWithContext(ctx, &s3.GetObjectInput{Bucket: bucket, Key: aws.String("big.bin")})
+				convey.So(err, convey.ShouldBeNil)
+				convey.So(readBody(get), convey.ShouldEqual, "new")
+			})
+
+			convey.Convey("copy from cold", func() {
+				_, _, err := tp.CopyObjectWithContext(ctx, &s3.CopyObjectInput{
+					Bucket:     bucket,
+					Key:        aws.String("copy.bin"),
+					CopySource: aws.String("bk/big.bin"),
+				})
+				convey.So(err, convey.ShouldBeNil)
+				convey.So(hot.has("bk", "copy.bin"), convey.ShouldBeTrue)
+				p, err := store.GetPlacement("oak", "bk", "copy.bin")
+				convey.So(err, convey.ShouldBeNil)
+				convey.So(p.Tier, convey.ShouldEqual, TierHot)
+				convey.So(p.Size, convey.ShouldEqual, 16)
+			})
+
+			convey.Convey("delete cold object", func() {
+				_, _, err := tp.DeleteObjectWithContext(ctx, &s3.DeleteObjectInput{Bucket: bucket, Key: aws.String("big.bin")})
+				convey.So(err, convey.ShouldBeNil)
+				convey.So(cold.has("bk", "big.bin"), convey.ShouldBeFalse)
+				_, err = store.GetPlacement("oak", "bk", "big.bin")
+				convey.So(err, convey.ShouldEqual, db.ErrNotFound)
+
+				_, _, err = tp.GetObjectWithContext(ctx, &s3.GetObjectInput{Bucket: bucket, Key: aws.String("big.bin")})
+				convey.So(gateway.IsNotFound(err), convey.ShouldBeTrue)
+			})
+		})
+
+		convey.Convey("object without placement", func() {
+			cold.PutObjectWithContext(ctx, &s3.PutObjectInput{Bucket: bucket, Key: aws.String("legacy"), Body: strings.NewReader("old")})
+
+			get, _, err := tp.GetObjectWithContext(ctx, &s3.GetObjectInput{Bucket: bucket, Key: aws.String("legacy")})
+			convey.So(err, convey.ShouldBeNil)
+			convey.So(readBody(get), convey.ShouldEqual, "old")
+
+			_, _, err = tp.DeleteObjectWithContext(ctx, &s3.DeleteObjectInput{Bucket: bucket, Key: aws.String("legacy")})
+			convey.So(err, convey.ShouldBeNil)
+			convey.So(cold.has("bk", "legacy"), convey.ShouldBeFalse)
+		})
+
+		convey.Convey("demote after overwrite keeps the new object", func() {
+			p, _ := store.GetPlacement("oak", "bk", "logs/1.log")
+
+			// 迁移开始后对象被重新写入
+			time.Sleep(time.Millisecond)
+			put("logs/1.log", "new log")
+
+			err := tp.Demote(ctx, p)
+			convey.So(err, convey.ShouldEqual, ErrObjectChanged)
+			np, _ := store.GetPlacement("oak", "bk", "logs/1.log")
+			convey.So(np.Tier, convey.ShouldEqual, TierHot)
+			convey.So(hot.has("bk", "logs/1.log"), convey.ShouldBeTrue)
+
+			get, _, err := tp.GetObjectWithContext(ctx, &s3.GetObjectInput{Bucket: bucket, Key: aws.String("logs/1.log")})
+			convey.So(err, convey.ShouldBeNil)
+			convey.So(readBody(get), convey.ShouldEqual, "new log")
+		})
+
+		convey.Convey("demote keeps hot object rewritten after the placement update", func() {
+			p, _ := store.GetPlacement("oak", "bk", "logs/1.log")
+
+			// 读取热存储后对象被重新写入，但是记录还没有更新
+			hot.afterGet = func() {
+				hot.afterGet = nil
+				hot.PutObjectWithContext(ctx, &s3.PutObjectInput{Bucket: bucket, Key: aws.String("logs/1.log"), Body: strings.NewReader("new log")})
+			}
+
+			convey.So(tp.Demote(ctx, p), convey.ShouldBeNil)
+			convey.So(hot.has("bk", "logs/1.log"), convey.ShouldBeTrue)
+			convey.So(string(hot.buckets["bk"]["logs/1.log"].data), convey.ShouldEqual, "new log")
+		})
+
+		convey.Convey("demote deleted object removes placement", func() {
+			hot.DeleteObjectWithContext(ctx, &s3.DeleteObjectInput{Bucket: bucket, Key: aws.String("logs/1.log")})
+			_, err := mover.RunOnce(ctx)
+			convey.So(err, convey.ShouldBeNil)
+			_, err = store.GetPlacement("oak", "bk", "logs/1.log")
+			convey.So(err, convey.ShouldEqual, db.ErrNotFound)
+		})
+	})
+}
+
+func TestMergeListing(t *testing.T) {
+
+	objects := func(keys ...string) []*s3.Object {
+		var list []*s3.Object
+		for _, k := range keys {
+			list = append(list, &s3.Object{Key: aws.String(k)})
+		}
+		return list
+	}
+
+	convey.Convey("merge listing", t, func() {
+
+		// hot 被截断到 c，cold 中大于 c 的 d 不能返回
+		contents, prefixes, next, truncated := mergeListing(10,
+			listing{contents: objects("a", "c"), truncated: true},
+			listing{contents: objects("b", "d"), prefixes: []*s3.CommonPrefix{{Prefix: aws.String("b/")}}},
+		)
+		convey.So(len(contents), convey.ShouldEqual, 3)
+		convey.So(len(prefixes), convey.ShouldEqual, 1)
+		convey.So(next, convey.ShouldEqual, "c")
+		convey.So(truncated, convey.ShouldBeTrue)
+
+		contents, _, next, truncated = mergeListing(2,
+			listing{contents: objects("a", "c")},
+			listing{contents: objects("a", "b")},
+		)
+		convey.So(len(contents), convey.ShouldEqual, 2)
+		convey.So(aws.StringValue(contents[1].Key), convey.ShouldEqual, "b")
+		convey.So(next, convey.ShouldEqual, "b")
+		convey.So(truncated, convey.ShouldBeTrue)
+
+		_, _, next, truncated = mergeListing(0, listing{contents: objects("a")}, listing{})
+		convey.So(next, convey.ShouldEqual, "")
+		convey.So(truncated, convey.ShouldBeFalse)
+	})
+}
+
+func TestRule(t *testing.T) {
+
+	now := time.Now()
+
+	tests := []struct {
+		rule  Rule
+		p     db.Placement
+		match bool
+	}{
+		{Rule{Prefix: "a/"}, db.Placement{Object: "a/b"}, true},
+		{Rule{Prefix: "a/"}, db.Placement{Object: "b/a"}, false},
+		{Rule{MinSize: 10}, db.Placement{Size: 10}, true},
+		{Rule{MinSize: 10}, db.Placement{Size: 9}, false},
+		{Rule{MinAgeDays: 1}, db.Placement{ModifyTime: now.Add(-25 * time.Hour)}, true},
+		{Rule{MinAgeDays: 1}, db.Placement{ModifyTime: now.Add(-23 * time.Hour)}, false},
+		{Rule{Prefix: "a/", MinSize: 10}, db.Placement{Object: "a/b", Size: 1}, false},
+	}
+
+	for k, v := range tests {
+		if got := v.rule.Match(v.p, now); got != v.match {
+			t.Errorf("k: %v, got: %v, want: %v", k, got, v.match)
+		}
+	}
+
+	if (Rule{}).Validate() == nil {
+		t.Errorf("empty rule should be invalid")
+	}
+
+	rs, err := ParseRules(Rules{{Prefix: "logs/"}, {MinAgeDays: 30}}.String())
+	if err != nil || len(rs) != 2 || rs[1].MinAgeDays != 30 {
+		t.Errorf("parse rules: %v %v", rs, err)
+	}
+
+	if _, err := ParseRules(`[{}]`); err != ErrInvalidRule {
+		t.Errorf("parse invalid rules: %v", err)
+	}
+}
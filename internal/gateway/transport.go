@@ -0,0 +1,65 @@
+package gateway
+
+import (
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/solution9th/S3Adapter/internal/trace"
+)
+
+// TransportConfig 访问后端的 HTTP 连接配置，为 0 的字段使用 http.DefaultTransport 的值
+type TransportConfig struct {
+	MaxIdleConns          int
+	MaxIdleConnsPerHost   int
+	IdleConnTimeout       time.Duration
+	DialTimeout           time.Duration
+	TLSHandshakeTimeout   time.Duration
+	ResponseHeaderTimeout time.Duration
+}
+
+var (
+	transportMu sync.RWMutex
+	transport   http.RoundTripper = http.DefaultTransport
+)
+
+// SetTransport 设置所有引擎共用的 HTTP 连接，启动时调用，之后创建的客户端生效
+func SetTransport(cfg TransportConfig) {
+
+	t := http.DefaultTransport.(*http.Transport).Clone()
+
+	if cfg.MaxIdleConns > 0 {
+		t.MaxIdleConns = cfg.MaxIdleConns
+	}
+	if cfg.MaxIdleConnsPerHost > 0 {
+		t.MaxIdleConnsPerHost = cfg.MaxIdleConnsPerHost
+	}
+	if cfg.IdleConnTimeout > 0 {
+		t.IdleConnTimeout = cfg.IdleConnTimeout
+	}
+	if cfg.DialTimeout > 0 {
+		t.DialContext = (&net.Dialer{
+			Timeout:   cfg.DialTimeout,
+			KeepAlive: 30 * time.Second,
+		}).DialContext
+	}
+	if cfg.TLSHandshakeTimeout > 0 {
+		t.TLSHandshakeTimeout = cfg.TLSHandshakeTimeout
+	}
+	if cfg.ResponseHeaderTimeout > 0 {
+		t.ResponseHeaderTimeout = cfg.ResponseHeaderTimeout
+	}
+
+	transportMu.Lock()
+	transport = t
+	transportMu.Unlock()
+}
+
+// Transport 引擎访问后端使用的 HTTP 连接，所有客户端共用以复用连接，
+// 请求的 context 中有 span 时传递 traceparent
+func Transport() http.RoundTripper {
+	transportMu.RLock()
+	defer transportMu.RUnlock()
+	return trace.Transport(transport)
+}
@@ -1,10 +1,54 @@
 package gateway
 
 import (
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
 	"reflect"
+	"strconv"
 	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+)
+
+var (
+	errPreconditionFailed = awserr.NewRequestFailure(awserr.New("PreconditionFailed", "At least one of the pre-conditions you specified did not hold", nil), http.StatusPreconditionFailed, "")
+	errNotModified        = awserr.NewRequestFailure(awserr.New("NotModified", "Not Modified", nil), http.StatusNotModified, "")
+	errInvalidRange       = awserr.NewRequestFailure(awserr.New("InvalidRange", "The requested range is not satisfiable", nil), http.StatusRequestedRangeNotSatisfiable, "")
 )
 
+// Response 后端没有返回响应时使用空的响应，组合多个后端的引擎使用
+func Response(resp *http.Response) *http.Response {
+	if resp == nil {
+		return &http.Response{Header: make(http.Header)}
+	}
+	return resp
+}
+
+// IsNotFound 错误是否表示 bucket 或者对象不存在
+func IsNotFound(err error) bool {
+	if e, ok := err.(awserr.RequestFailure); ok && e.StatusCode() == http.StatusNotFound {
+		return true
+	}
+	if e, ok := err.(awserr.Error); ok {
+		switch e.Code() {
+		case "NoSuchKey", "NotFound", "NoSuchBucket":
+			return true
+		}
+	}
+	return false
+}
+
+// IsCode 是否为 S3 错误码为 code 的错误
+func IsCode(err error, code string) bool {
+	e, ok := err.(awserr.Error)
+	return ok && e.Code() == code
+}
+
 // CheckName 判断 input 中 fields 中指定的字段是否存在
 // 不检查 field 中不存在的字段，默认检查"bucket", "key","object"
 func CheckName(input interface{}, fields ...string) bool {
@@ -35,3 +79,124 @@ func CheckName(input interface{}, fields ...string) bool {
 	}
 	return true
 }
+
+// ParseCopySource 解析 x-amz-copy-source，格式为 /bucket/key 或者 bucket/key，
+// 可以是 URL 编码，会忽略 ?versionId 等参数
+func ParseCopySource(source string) (bucket, object string, ok bool) {
+
+	if s, err := url.PathUnescape(source); err == nil {
+		source = s
+	}
+	if i := strings.Index(source, "?"); i >= 0 {
+		source = source[:i]
+	}
+	source = strings.TrimPrefix(source, "/")
+
+	i := strings.Index(source, "/")
+	if i <= 0 || i == len(source)-1 {
+		return "", "", false
+	}
+	return source[:i], source[i+1:], true
+}
+
+// TempBody 临时文件，跨引擎复制对象时使用，PutObject 需要 io.ReadSeeker
+type TempBody struct {
+	*os.File
+}
+
+// Close 关闭并删除临时文件
+func (b *TempBody) Close() error {
+	err := b.File.Close()
+	os.Remove(b.File.Name())
+	return err
+}
+
+// NewTempBody 创建空的临时文件
+func NewTempBody() (*TempBody, error) {
+
+	f, err := ioutil.TempFile("", "s3adapter-")
+	if err != nil {
+		return nil, err
+	}
+	return &TempBody{f}, nil
+}
+
+// Spool 把 r 的内容写入临时文件，返回定位到开头的临时文件和内容大小
+func Spool(r io.ReadCloser) (*TempBody, int64, error) {
+
+	defer r.Close()
+
+	body, err := NewTempBody()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	size, err := io.Copy(body, r)
+	if err == nil {
+		_, err = body.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		body.Close()
+		return nil, 0, err
+	}
+	return body, size, nil
+}
+
+// CheckPreconditions 处理 If-Match 等条件请求
+func CheckPreconditions(etag string, modified time.Time, ifMatch, ifNoneMatch *string, ifModifiedSince, ifUnmodifiedSince *time.Time) error {
+
+	modified = modified.Truncate(time.Second)
+
+	if ifMatch != nil && aws.StringValue(ifMatch) != etag && aws.StringValue(ifMatch) != "*" {
+		return errPreconditionFailed
+	}
+	if ifUnmodifiedSince != nil && modified.After(*ifUnmodifiedSince) {
+		return errPreconditionFailed
+	}
+	if ifNoneMatch != nil && (aws.StringValue(ifNoneMatch) == etag || aws.StringValue(ifNoneMatch) == "*") {
+		return errNotModified
+	}
+	if ifModifiedSince != nil && !modified.After(*ifModifiedSince) {
+		return errNotModified
+	}
+	return nil
+}
+
+// ParseRange 解析 Range: bytes=a-b, bytes=a-, bytes=-n
+func ParseRange(rangeHeader string, size int64) (offset, length int64, err error) {
+
+	spec := strings.TrimPrefix(rangeHeader, "bytes=")
+	i := strings.Index(spec, "-")
+	if spec == rangeHeader || i < 0 || strings.Contains(spec, ",") {
+		return 0, 0, errInvalidRange
+	}
+
+	start, end := spec[:i], spec[i+1:]
+	switch {
+	case start == "":
+		n, err := strconv.ParseInt(end, 10, 64)
+		if err != nil || n <= 0 {
+			return 0, 0, errInvalidRange
+		}
+		if n > size {
+			n = size
+		}
+		return size - n, n, nil
+	default:
+		a, err := strconv.ParseInt(start, 10, 64)
+		if err != nil || a >= size {
+			return 0, 0, errInvalidRange
+		}
+		b := size - 1
+		if end != "" {
+			b, err = strconv.ParseInt(end, 10, 64)
+			if err != nil || b < a {
+				return 0, 0, errInvalidRange
+			}
+			if b >= size {
+				b = size - 1
+			}
+		}
+		return a, b - a + 1, nil
+	}
+}
@@ -1,9 +1,12 @@
 package gateway
 
 import (
+	"errors"
+	"net/http"
 	"testing"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/service/s3"
 )
 
@@ -42,3 +45,80 @@ func TestCheckName(t *testing.T) {
 		}
 	}
 }
+
+func TestParseCopySource(t *testing.T) {
+
+	tests := []struct {
+		input          string
+		bucket, object string
+		ok             bool
+	}{
+		{"/bk/a/b.txt", "bk", "a/b.txt", true},
+		{"bk/a.txt", "bk", "a.txt", true},
+		{"bk%2Fa.txt", "bk", "a.txt", true},
+		{"bk/a.txt?versionId=1", "bk", "a.txt", true},
+		{"bk", "", "", false},
+		{"bk/", "", "", false},
+	}
+
+	for k, v := range tests {
+		b, o, ok := ParseCopySource(v.input)
+		if b != v.bucket || o != v.object || ok != v.ok {
+			t.Errorf("k: %v, got: %v %v %v, want: %v %v %v", k, b, o, ok, v.bucket, v.object, v.ok)
+		}
+	}
+}
+
+func TestParseRange(t *testing.T) {
+
+	tests := []struct {
+		input          string
+		offset, length int64
+		ok             bool
+	}{
+		{"bytes=0-4", 0, 5, true},
+		{"bytes=5-", 5, 5, true},
+		{"bytes=-3", 7, 3, true},
+		{"bytes=8-100", 8, 2, true},
+		{"bytes=10-", 0, 0, false},
+		{"bytes=4-2", 0, 0, false},
+		{"bytes=0-1,3-4", 0, 0, false},
+		{"items=0-1", 0, 0, false},
+	}
+
+	for k, v := range tests {
+		offset, length, err := ParseRange(v.input, 10)
+		if (err == nil) != v.ok || offset != v.offset || length != v.length {
+			t.Errorf("k: %v, got: %v %v %v, want: %v %v %v", k, offset, length, err, v.offset, v.length, v.ok)
+		}
+	}
+}
+
+func TestIsNotFound(t *testing.T) {
+
+	tests := []struct {
+		err      error
+		notFound bool
+	}{
+		{nil, false},
+		{errors.New("NoSuchKey"), false},
+		{awserr.New("NoSuchKey", "", nil), true},
+		{awserr.New("NoSuchBucket", "", nil), true},
+		{awserr.New("AccessDenied", "", nil), false},
+		{awserr.NewRequestFailure(awserr.New("Whatever", "", nil), http.StatusNotFound, ""), true},
+		{awserr.NewRequestFailure(awserr.New("SlowDown", "", nil), http.StatusServiceUnavailable, ""), false},
+	}
+
+	for k, v := range tests {
+		if got := IsNotFound(v.err); got != v.notFound {
+			t.Errorf("k: %v, got: %v, want: %v", k, got, v.notFound)
+		}
+	}
+
+	if !IsCode(awserr.New("BucketAlreadyOwnedByYou", "", nil), "BucketAlreadyOwnedByYou") || IsCode(errors.New("x"), "x") {
+		t.Errorf("IsCode")
+	}
+	if resp := Response(nil); resp == nil || resp.Header == nil {
+		t.Errorf("Response(nil): %v", resp)
+	}
+}
@@ -0,0 +1,248 @@
+package webhdfs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+)
+
+const (
+	webhdfsPrefix = "/webhdfs/v1"
+
+	fileTypeFile      = "FILE"
+	fileTypeDirectory = "DIRECTORY"
+
+	// xattr 的命名空间，HDFS 只允许 user 命名空间被普通用户写入
+	xattrPrefix      = "user.s3."
+	xattrMetaPrefix  = xattrPrefix + "meta."
+	xattrETag        = xattrPrefix + "etag"
+	xattrContentType = xattrPrefix + "content-type"
+)
+
+var errMissLocation = errors.New("webhdfs: redirect without location")
+
+// fileStatus GETFILESTATUS/LISTSTATUS 中的 FileStatus
+type fileStatus struct {
+	PathSuffix       string `json:"pathSuffix"`
+	Type             string `json:"type"`
+	Length           int64  `json:"length"`
+	ModificationTime int64  `json:"modificationTime"`
+	Owner            string `json:"owner"`
+}
+
+// webhdfsClient 访问 WebHDFS REST API
+// https://hadoop.apache.org/docs/stable/hadoop-project-dist/hadoop-hdfs/WebHDFS.html
+type webhdfsClient struct {
+	endpoint *url.URL
+	root     string
+	user     string
+	token    string
+	client   *http.Client
+}
+
+// hdfsPath bucket 和 object 对应的 HDFS 路径
+func (c *webhdfsClient) hdfsPath(bucket, object string) string {
+	return path.Join("/", c.root, bucket, object)
+}
+
+func (c *webhdfsClient) opURL(p, op string, query url.Values) *url.URL {
+
+	if query == nil {
+		query = make(url.Values)
+	}
+	query.Set("op", op)
+	if c.token != "" {
+		query.Set("delegation", c.token)
+	} else if c.user != "" {
+		query.Set("user.name", c.user)
+	}
+
+	u := *c.endpoint
+	u.Path = webhdfsPrefix + p
+	u.RawPath = ""
+	u.RawQuery = query.Encode()
+	return &u
+}
+
+func (c *webhdfsClient) do(ctx context.Context, method string, u *url.URL, body io.Reader, length int64) (*http.Response, error) {
+
+	req, err := http.NewRequest(method, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	if body != nil {
+		req.ContentLength = length
+		req.Header.Set("Content-Type", "application/octet-stream")
+	}
+
+	return c.client.Do(req)
+}
+
+// redirect NameNode 返回 307 后，在 DataNode 上继续请求
+func (c *webhdfsClient) redirect(ctx context.Context, method string, u *url.URL, body io.Reader, length int64) (*http.Response, error) {
+
+	resp, err := c.do(ctx, method, u, nil, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusTemporaryRedirect {
+		return resp, nil
+	}
+	resp.Body.Close()
+
+	location := resp.Header.Get("Location")
+	if location == "" {
+		return nil, errMissLocation
+	}
+
+	next, err := url.Parse(location)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.do(ctx, method, u.ResolveReference(next), body, length)
+}
+
+// decode 解析 JSON 响应
+func decode(resp *http.Response, v interface{}) error {
+	defer resp.Body.Close()
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+func (c *webhdfsClient) getFileStatus(ctx context.Context, p string) (*fileStatus, *http.Response, error) {
+
+	resp, err := c.do(ctx, http.MethodGet, c.opURL(p, "GETFILESTATUS", nil), nil, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, resp, nil
+	}
+
+	var result struct {
+		FileStatus fileStatus `json:"FileStatus"`
+	}
+	if err := decode(resp, &result); err != nil {
+		return nil, resp, err
+	}
+	return &result.FileStatus, resp, nil
+}
+
+// listStatus 使用 LISTSTATUS_BATCH 分页列出目录
+func (c *webhdfsClient) listStatus(ctx context.Context, p string) ([]fileStatus, *http.Response, error) {
+
+	var (
+		all        []fileStatus
+		startAfter string
+		resp       *http.Response
+		err        error
+	)
+
+	for {
+		query := make(url.Values)
+		if startAfter != "" {
+			query.Set("startAfter", startAfter)
+		}
+
+		resp, err = c.do(ctx, http.MethodGet, c.opURL(p, "LISTSTATUS_BATCH", query), nil, 0)
+		if err != nil {
+			return nil, nil, err
+		}
+		if resp.StatusCode != http.StatusOK {
+			return nil, resp, nil
+		}
+
+		var result struct {
+			DirectoryListing struct {
+				PartialListing struct {
+					FileStatuses struct {
+						FileStatus []fileStatus `json:"FileStatus"`
+					} `json:"FileStatuses"`
+				} `json:"partialListing"`
+				RemainingEntries int `json:"remainingEntries"`
+			} `json:"DirectoryListing"`
+		}
+		if err := decode(resp, &result); err != nil {
+			return nil, resp, err
+		}
+
+		entries := result.DirectoryListing.PartialListing.FileStatuses.FileStatus
+		all = append(all, entries...)
+
+		if result.DirectoryListing.RemainingEntries == 0 || len(entries) == 0 {
+			break
+		}
+		startAfter = entries[len(entries)-1].PathSuffix
+	}
+
+	return all, resp, nil
+}
+
+// getXAttrs 获得 user.s3.* 扩展属性
+func (c *webhdfsClient) getXAttrs(ctx context.Context, p string) (map[string]string, error) {
+
+	query := url.Values{"encoding": {"text"}}
+	resp, err := c.do(ctx, http.MethodGet, c.opURL(p, "GETXATTRS", query), nil, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		io.Copy(ioutil.Discard, resp.Body)
+		resp.Body.Close()
+		return map[string]string{}, nil
+	}
+
+	var result struct {
+		XAttrs []struct {
+			Name  string `json:"name"`
+			Value string `json:"value"`
+		} `json:"XAttrs"`
+	}
+	if err := decode(resp, &result); err != nil {
+		return nil, err
+	}
+
+	m := make(map[string]string, len(result.XAttrs))
+	for _, x := range result.XAttrs {
+		if !strings.HasPrefix(x.Name, xattrPrefix) {
+			continue
+		}
+		// encoding=text 时值带有双引号
+		if v, err := strconv.Unquote(x.Value); err == nil {
+			x.Value = v
+		}
+		m[x.Name] = x.Value
+	}
+	return m, nil
+}
+
+func (c *webhdfsClient) setXAttr(ctx context.Context, p, name, value string) error {
+
+	query := url.Values{
+		"xattr.name":  {name},
+		"xattr.value": {strconv.Quote(value)},
+		"flag":        {"CREATE"},
+	}
+
+	resp, err := c.do(ctx, http.MethodPut, c.opURL(p, "SETXATTR", query), nil, 0)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return toS3Err(resp, "NoSuchKey")
+	}
+	return nil
+}
@@ -0,0 +1,95 @@
+package webhdfs
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/haozibi/zlog"
+)
+
+// remoteException WebHDFS 的错误信息
+type remoteException struct {
+	RemoteException struct {
+		Exception     string `json:"exception"`
+		JavaClassName string `json:"javaClassName"`
+		Message       string `json:"message"`
+	} `json:"RemoteException"`
+}
+
+// hdfsToS3Code HDFS 异常对应的 S3 错误码
+var hdfsToS3Code = map[string]string{
+	"AccessControlException":           "AccessDenied",
+	"SecurityException":                "AccessDenied",
+	"FileAlreadyExistsException":       "BucketAlreadyOwnedByYou",
+	"PathIsNotEmptyDirectoryException": "BucketNotEmpty",
+	"IllegalArgumentException":         "InvalidArgument",
+	"UnsupportedOperationException":    "NotImplemented",
+	"StandbyException":                 "ServiceUnavailable",
+	"RetriableException":               "SlowDown",
+	"DSQuotaExceededException":         "EntityTooLarge",
+	"NSQuotaExceededException":         "TooManyBuckets",
+}
+
+// toS3Err 错误处理，把 WebHDFS 错误转变成 s3
+//
+// notFound 为 FileNotFoundException 或者 404 时使用的 S3 错误码
+func toS3Err(resp *http.Response, notFound string) awserr.RequestFailure {
+
+	var e remoteException
+	if resp.Body != nil {
+		body, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err == nil && len(body) > 0 {
+			if err := json.Unmarshal(body, &e); err != nil {
+				zlog.ZDebug().Str("Body", string(body)).Msg("[WebHDFS] error body:" + err.Error())
+			}
+		}
+	}
+
+	exception := e.RemoteException.Exception
+	statusCode := resp.StatusCode
+
+	code, ok := hdfsToS3Code[exception]
+	switch {
+	case ok:
+	case exception == "FileNotFoundException" || statusCode == http.StatusNotFound:
+		code = notFound
+		statusCode = http.StatusNotFound
+	case statusCode == http.StatusForbidden || statusCode == http.StatusUnauthorized:
+		code = "AccessDenied"
+	default:
+		code = "InternalError"
+	}
+
+	switch code {
+	case "AccessDenied":
+		statusCode = http.StatusForbidden
+	case "BucketAlreadyOwnedByYou", "BucketNotEmpty":
+		statusCode = http.StatusConflict
+	}
+
+	message := e.RemoteException.Message
+	if message == "" {
+		message = http.StatusText(resp.StatusCode)
+	}
+
+	return awserr.NewRequestFailure(awserr.New(code, message, nil), statusCode, "")
+}
+
+// newS3Err 本地产生的 S3 错误
+func newS3Err(code string, statusCode int, message string) awserr.RequestFailure {
+	return awserr.NewRequestFailure(awserr.New(code, message, nil), statusCode, "")
+}
+
+// toS3ErrNotResponse 请求没有得到响应，比如网络错误
+func toS3ErrNotResponse(err error) awserr.RequestFailure {
+	zlog.ZError().Msg("[WebHDFS] error:" + err.Error())
+	return awserr.NewRequestFailure(awserr.New("InternalError", err.Error(), err), http.StatusInternalServerError, "")
+}
+
+// emptyResponse WebHDFS 没有请求 ID，统一返回空响应头
+func emptyResponse() *http.Response {
+	return &http.Response{Header: make(http.Header)}
+}
@@ -0,0 +1,851 @@
+package webhdfs
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/solution9th/S3Adapter/internal/auth"
+	"github.com/solution9th/S3Adapter/internal/gateway"
+	"github.com/solution9th/S3Adapter/internal/reqinfo"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+const (
+	// Backend webhdfs backend name
+	Backend = "webhdfs"
+)
+
+const (
+	webhdfsMaxKeys = 1000
+
+	// simpleAuthSecret SecretKey 为该值时只使用 user.name 认证
+	simpleAuthSecret = "-"
+)
+
+// New new Gateway
+func New() gateway.Gateway { return &webhdfsgw{} }
+
+type webhdfsgw struct{}
+
+func (s *webhdfsgw) Name() string     { return Backend }
+func (s *webhdfsgw) Production() bool { return true }
+
+// Capabilities HDFS 没有存储类型
+func (s *webhdfsgw) Capabilities() gateway.Capabilities {
+	return gateway.Capabilities{
+		Operations: append([]string(nil), gateway.AllOperations...),
+		Features:   []string{gateway.FeatureRange, gateway.FeatureConditional, gateway.FeatureUserMetadata},
+	}
+}
+
+// NewS3Protocol AccessKey 为 HDFS 用户名(user.name)，SecretKey 为 delegation token，
+// SecretKey 为 "-" 时使用 simple 认证
+//
+// region 为 NameNode 的 HTTP 地址，路径部分作为所有 bucket 的根目录，比如:
+// http://namenode:9870/s3，则 bucket 对应 /s3/<bucket>
+func (s *webhdfsgw) NewS3Protocol(creds auth.Credentials, region string, isDebug bool) (gateway.S3Protocol, error) {
+
+	if !strings.HasPrefix(region, "http://") && !strings.HasPrefix(region, "https://") {
+		return nil, fmt.Errorf("webhdfs: region must be namenode address, got: %q", region)
+	}
+
+	u, err := url.Parse(region)
+	if err != nil {
+		return nil, err
+	}
+
+	root := path.Clean("/" + u.Path)
+	u.Path, u.RawPath = "", ""
+
+	token := creds.SecretKey
+	if token == simpleAuthSecret {
+		token = ""
+	}
+
+	return &webhdfsProto{
+		client: &webhdfsClient{
+			endpoint: u,
+			root:     root,
+			user:     creds.AccessKey,
+			token:    token,
+			client: &http.Client{
+				Transport: gateway.Transport(),
+				// 307 需要自己处理，PUT 的 body 只能发送给 DataNode
+				CheckRedirect: func(req *http.Request, via []*http.Request) error {
+					return http.ErrUseLastResponse
+				},
+			},
+		},
+	}, nil
+}
+
+type webhdfsProto struct {
+	gateway.GatewayUnsupported
+	client *webhdfsClient
+}
+
+func msToTime(ms int64) time.Time {
+	return time.Unix(0, ms*int64(time.Millisecond)).UTC()
+}
+
+// =================
+// Bucket operations
+// =================
+
+// CreateBucketWithContext bucket 对应根目录下的一级目录
+func (s *webhdfsProto) CreateBucketWithContext(ctx context.Context, input *s3.CreateBucketInput, opts ...request.Option) (*s3.CreateBucketOutput, *http.Response, error) {
+
+	bucket := aws.StringValue(input.Bucket)
+	if err := checkBucket(bucket); err != nil {
+		return nil, emptyResponse(), err
+	}
+	p := s.client.hdfsPath(bucket, "")
+
+	status, resp, err := s.client.getFileStatus(ctx, p)
+	if err != nil {
+		return nil, emptyResponse(), toS3ErrNotResponse(err)
+	}
+	if status != nil {
+		return nil, emptyResponse(), newS3Err("BucketAlreadyOwnedByYou", http.StatusConflict, "bucket already exists")
+	}
+	if resp.StatusCode != http.StatusNotFound {
+		return nil, emptyResponse(), toS3Err(resp, "NoSuchBucket")
+	}
+	resp.Body.Close()
+
+	if err := s.mkdirs(ctx, p); err != nil {
+		reqinfo.ZError(ctx).Str("Method", "CreateBucketWithContext").Str("Bucket", bucket).Msg("[WebHDFS] error:" + err.Error())
+		return nil, emptyResponse(), err
+	}
+
+	return &s3.CreateBucketOutput{
+		Location: aws.String("/" + bucket),
+	}, emptyResponse(), nil
+}
+
+func (s *webhdfsProto) mkdirs(ctx context.Context, p string) error {
+
+	query := url.Values{"permission": {"755"}}
+	resp, err := s.client.do(ctx, http.MethodPut, s.client.opURL(p, "MKDIRS", query), nil, 0)
+	if err != nil {
+		return toS3ErrNotResponse(err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return toS3Err(resp, "NoSuchBucket")
+	}
+
+	var result struct {
+		Boolean bool `json:"boolean"`
+	}
+	if err := decode(resp, &result); err != nil {
+		return toS3ErrNotResponse(err)
+	}
+	if !result.Boolean {
+		return newS3Err("InternalError", http.StatusInternalServerError, "mkdirs failed: "+p)
+	}
+	return nil
+}
+
+// headDirectory 判断 bucket 是否存在
+func (s *webhdfsProto) headDirectory(ctx context.Context, bucket string) error {
+
+	if err := checkBucket(bucket); err != nil {
+		return err
+	}
+
+	status, resp, err := s.client.getFileStatus(ctx, s.client.hdfsPath(bucket, ""))
+	if err != nil {
+		return toS3ErrNotResponse(err)
+	}
+	if status == nil {
+		return toS3Err(resp, "NoSuchBucket")
+	}
+	if status.Type != fileTypeDirectory {
+		return newS3Err("NoSuchBucket", http.StatusNotFound, "The specified bucket does not exist")
+	}
+	return nil
+}
+
+func (s *webhdfsProto) HeadBucketWithContext(ctx context.Context, input *s3.HeadBucketInput, opts ...request.Option) (*s3.HeadBucketOutput, *http.Response, error) {
+
+	if err := s.headDirectory(ctx, aws.StringValue(input.Bucket)); err != nil {
+		return nil, emptyResponse(), err
+	}
+	return &s3.HeadBucketOutput{}, emptyResponse(), nil
+}
+
+// DeleteBucketWithContext 只能删除空目录
+func (s *webhdfsProto) DeleteBucketWithContext(ctx context.Context, input *s3.DeleteBucketInput, opts ...request.Option) (*s3.DeleteBucketOutput, *http.Response, error) {
+
+	bucket := aws.StringValue(input.Bucket)
+	if err := checkBucket(bucket); err != nil {
+		return nil, emptyResponse(), err
+	}
+	p := s.client.hdfsPath(bucket, "")
+
+	entries, resp, err := s.client.listStatus(ctx, p)
+	if err != nil {
+		return nil, emptyResponse(), toS3ErrNotResponse(err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, emptyResponse(), toS3Err(resp, "NoSuchBucket")
+	}
+	if len(entries) > 0 {
+		return nil, emptyResponse(), newS3Err("BucketNotEmpty", http.StatusConflict, "The bucket you tried to delete is not empty")
+	}
+
+	if err := s.delete(ctx, p); err != nil {
+		reqinfo.ZError(ctx).Str("Method", "DeleteBucketWithContext").Str("Bucket", bucket).Msg("[WebHDFS] error:" + err.Error())
+		return nil, emptyResponse(), err
+	}
+
+	return &s3.DeleteBucketOutput{}, emptyResponse(), nil
+}
+
+func (s *webhdfsProto) delete(ctx context.Context, p string) error {
+
+	query := url.Values{"recursive": {"false"}}
+	resp, err := s.client.do(ctx, http.MethodDelete, s.client.opURL(p, "DELETE", query), nil, 0)
+	if err != nil {
+		return toS3ErrNotResponse(err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return toS3Err(resp, "NoSuchKey")
+	}
+	io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
+	return nil
+}
+
+// ListBucketsWithContext 根目录下的所有目录
+func (s *webhdfsProto) ListBucketsWithContext(ctx context.Context, input *s3.ListBucketsInput, opts ...request.Option) (*s3.ListBucketsOutput, *http.Response, error) {
+
+	entries, resp, err := s.client.listStatus(ctx, s.client.hdfsPath("", ""))
+	if err != nil {
+		return nil, emptyResponse(), toS3ErrNotResponse(err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, emptyResponse(), toS3Err(resp, "NoSuchBucket")
+	}
+
+	output := &s3.ListBucketsOutput{
+		Buckets: make([]*s3.Bucket, 0, len(entries)),
+		Owner: &s3.Owner{
+			DisplayName: aws.String(s.client.user),
+			ID:          aws.String(s.client.user),
+		},
+	}
+
+	for _, e := range entries {
+		if e.Type != fileTypeDirectory {
+			continue
+		}
+		output.Buckets = append(output.Buckets, &s3.Bucket{
+			Name:         aws.String(e.PathSuffix),
+			CreationDate: aws.Time(msToTime(e.ModificationTime)),
+		})
+	}
+
+	return output, emptyResponse(), nil
+}
+
+// listEntry 列举结果中的一项，object 或者 CommonPrefix
+type listEntry struct {
+	key      string
+	isPrefix bool
+	status   fileStatus
+}
+
+// walk 递归列出 dir 下所有 key 以 prefix 开头的文件，空目录作为 "dir/" 对象返回
+func (s *webhdfsProto) walk(ctx context.Context, bucket, dir, prefix string, result *[]listEntry) error {
+
+	entries, resp, err := s.client.listStatus(ctx, s.client.hdfsPath(bucket, dir))
+	if err != nil {
+		return toS3ErrNotResponse(err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		if resp.StatusCode == http.StatusNotFound {
+			resp.Body.Close()
+			return nil
+		}
+		return toS3Err(resp, "NoSuchKey")
+	}
+
+	if len(entries) == 0 && dir != "" && strings.HasPrefix(dir, prefix) {
+		*result = append(*result, listEntry{key: dir})
+		return nil
+	}
+
+	for _, e := range entries {
+		key := dir + e.PathSuffix
+		if e.Type != fileTypeDirectory {
+			if strings.HasPrefix(key, prefix) {
+				*result = append(*result, listEntry{key: key, status: e})
+			}
+			continue
+		}
+
+		key += "/"
+		if strings.HasPrefix(key, prefix) || strings.HasPrefix(prefix, key) {
+			if err := s.walk(ctx, bucket, key, prefix, result); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// listObjects WebHDFS 没有前缀查询，delimiter 为 "/" 时只需要列出一层目录，
+// 否则递归列出后再按照 delimiter 分组
+func (s *webhdfsProto) listObjects(ctx context.Context, bucket, prefix, delimiter, marker string, maxKeys int64) ([]listEntry, bool, error) {
+
+	if err := s.headDirectory(ctx, bucket); err != nil {
+		return nil, false, err
+	}
+
+	dir := prefix[:strings.LastIndex(prefix, "/")+1]
+	if dir != "" {
+		if err := checkKey(dir); err != nil {
+			return nil, false, err
+		}
+	}
+
+	var entries []listEntry
+	if delimiter == "/" {
+		list, resp, err := s.client.listStatus(ctx, s.client.hdfsPath(bucket, dir))
+		if err != nil {
+			return nil, false, toS3ErrNotResponse(err)
+		}
+		if resp.StatusCode == http.StatusNotFound {
+			resp.Body.Close()
+		} else if resp.StatusCode != http.StatusOK {
+			return nil, false, toS3Err(resp, "NoSuchKey")
+		}
+		for _, e := range list {
+			key := dir + e.PathSuffix
+			if !strings.HasPrefix(key, prefix) {
+				continue
+			}
+			if e.Type == fileTypeDirectory {
+				entries = append(entries, listEntry{key: key + "/", isPrefix: true})
+				continue
+			}
+			entries = append(entries, listEntry{key: key, status: e})
+		}
+	} else {
+		var all []listEntry
+		if err := s.walk(ctx, bucket, dir, prefix, &all); err != nil {
+			return nil, false, err
+		}
+
+		seen := make(map[string]bool)
+		for _, e := range all {
+			if delimiter != "" {
+				rest := e.key[len(prefix):]
+				if i := strings.Index(rest, delimiter); i >= 0 {
+					p := prefix + rest[:i+len(delimiter)]
+					if !seen[p] {
+						seen[p] = true
+						entries = append(entries, listEntry{key: p, isPrefix: true})
+					}
+					continue
+				}
+			}
+			entries = append(entries, e)
+		}
+	}
+
+	sort.Slice(entries, func(i, j int) bool { return entries[i].key < entries[j].key })
+
+	if maxKeys <= 0 || maxKeys > webhdfsMaxKeys {
+		maxKeys = webhdfsMaxKeys
+	}
+
+	result := make([]listEntry, 0, len(entries))
+	for _, e := range entries {
+		if marker != "" && (e.key <= marker || (e.isPrefix && strings.HasPrefix(marker, e.key))) {
+			continue
+		}
+		if int64(len(result)) == maxKeys {
+			return result, true, nil
+		}
+		result = append(result, e)
+	}
+
+	return result, false, nil
+}
+
+func toS3Objects(entries []listEntry) ([]*s3.Object, []*s3.CommonPrefix) {
+
+	contents := make([]*s3.Object, 0, len(entries))
+	prefixes := make([]*s3.CommonPrefix, 0)
+
+	for _, e := range entries {
+		if e.isPrefix {
+			prefixes = append(prefixes, &s3.CommonPrefix{Prefix: aws.String(e.key)})
+			continue
+		}
+		contents = append(contents, &s3.Object{
+			Key:          aws.String(e.key),
+			Size:         aws.Int64(e.status.Length),
+			LastModified: aws.Time(msToTime(e.status.ModificationTime)),
+			StorageClass: aws.String(s3.ObjectStorageClassStandard),
+		})
+	}
+	return contents, prefixes
+}
+
+func (s *webhdfsProto) ListObjectsWithContext(ctx context.Context, input *s3.ListObjectsInput, opts ...request.Option) (*s3.ListObjectsOutput, *http.Response, error) {
+
+	bucket := aws.StringValue(input.Bucket)
+
+	entries, truncated, err := s.listObjects(ctx, bucket, aws.StringValue(input.Prefix), aws.StringValue(input.Delimiter),
+		aws.StringValue(input.Marker), aws.Int64Value(input.MaxKeys))
+	if err != nil {
+		reqinfo.ZError(ctx).Str("Method", "ListObjectsWithContext").Str("Bucket", bucket).Msg("[WebHDFS] error:" + err.Error())
+		return nil, emptyResponse(), err
+	}
+
+	contents, prefixes := toS3Objects(entries)
+
+	output := &s3.ListObjectsOutput{
+		Name:           input.Bucket,
+		Prefix:         input.Prefix,
+		Delimiter:      input.Delimiter,
+		Marker:         input.Marker,
+		MaxKeys:        input.MaxKeys,
+		IsTruncated:    aws.Bool(truncated),
+		Contents:       contents,
+		CommonPrefixes: prefixes,
+	}
+	if truncated {
+		output.NextMarker = aws.String(entries[len(entries)-1].key)
+	}
+
+	return output, emptyResponse(), nil
+}
+
+func (s *webhdfsProto) ListObjectsWithContextV2(ctx context.Context, input *s3.ListObjectsV2Input, opts ...request.Option) (*s3.ListObjectsV2Output, *http.Response, error) {
+
+	bucket := aws.StringValue(input.Bucket)
+
+	marker := aws.StringValue(input.StartAfter)
+	if input.ContinuationToken != nil {
+		token, err := base64.StdEncoding.DecodeString(aws.StringValue(input.ContinuationToken))
+		if err != nil {
+			return nil, emptyResponse(), newS3Err("InvalidArgument", http.StatusBadRequest, "The continuation token provided is incorrect")
+		}
+		if string(token) > marker {
+			marker = string(token)
+		}
+	}
+
+	entries, truncated, err := s.listObjects(ctx, bucket, aws.StringValue(input.Prefix), aws.StringValue(input.Delimiter),
+		marker, aws.Int64Value(input.MaxKeys))
+	if err != nil {
+		reqinfo.ZError(ctx).Str("Method", "ListObjectsWithContextV2").Str("Bucket", bucket).Msg("[WebHDFS] error:" + err.Error())
+		return nil, emptyResponse(), err
+	}
+
+	contents, prefixes := toS3Objects(entries)
+
+	output := &s3.ListObjectsV2Output{
+		Name:              input.Bucket,
+		Prefix:            input.Prefix,
+		Delimiter:         input.Delimiter,
+		StartAfter:        input.StartAfter,
+		ContinuationToken: input.ContinuationToken,
+		MaxKeys:           input.MaxKeys,
+		KeyCount:          aws.Int64(int64(len(entries))),
+		IsTruncated:       aws.Bool(truncated),
+		Contents:          contents,
+		CommonPrefixes:    prefixes,
+	}
+	if truncated {
+		output.NextContinuationToken = aws.String(base64.StdEncoding.EncodeToString([]byte(entries[len(entries)-1].key)))
+	}
+
+	return output, emptyResponse(), nil
+}
+
+// =================
+// Object operations
+// =================
+
+// create 上传文件，同时计算 MD5 作为 ETag 保存在 xattr 中
+func (s *webhdfsProto) create(ctx context.Context, p string, body io.Reader, length int64, attrs map[string]string) (string, error) {
+
+	if body == nil {
+		body = strings.NewReader("")
+	}
+
+	hash := md5.New()
+	query := url.Values{"overwrite": {"true"}}
+
+	resp, err := s.client.redirect(ctx, http.MethodPut, s.client.opURL(p, "CREATE", query), io.TeeReader(body, hash), length)
+	if err != nil {
+		return "", toS3ErrNotResponse(err)
+	}
+	if resp.StatusCode != http.StatusCreated {
+		return "", toS3Err(resp, "NoSuchBucket")
+	}
+	io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
+
+	etag := "\"" + hex.EncodeToString(hash.Sum(nil)) + "\""
+	attrs[xattrETag] = etag
+
+	for k, v := range attrs {
+		if err := s.client.setXAttr(ctx, p, k, v); err != nil {
+			return "", err
+		}
+	}
+
+	return etag, nil
+}
+
+// bodyLength ReadSeeker 剩余的长度
+func bodyLength(body io.ReadSeeker) (int64, error) {
+
+	if body == nil {
+		return 0, nil
+	}
+
+	cur, err := body.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return 0, err
+	}
+	end, err := body.Seek(0, io.SeekEnd)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := body.Seek(cur, io.SeekStart); err != nil {
+		return 0, err
+	}
+	return end - cur, nil
+}
+
+func s3MetaToXAttrs(s3Metadata map[string]*string, contentType *string) map[string]string {
+
+	attrs := make(map[string]string, len(s3Metadata)+1)
+	for k, v := range s3Metadata {
+		attrs[xattrMetaPrefix+strings.ToLower(k)] = aws.StringValue(v)
+	}
+	if contentType != nil {
+		attrs[xattrContentType] = aws.StringValue(contentType)
+	}
+	return attrs
+}
+
+func xattrsToS3Meta(attrs map[string]string) map[string]*string {
+
+	s3Metadata := make(map[string]*string)
+	for k, v := range attrs {
+		if !strings.HasPrefix(k, xattrMetaPrefix) {
+			continue
+		}
+		s3Metadata[http.CanonicalHeaderKey(k[len(xattrMetaPrefix):])] = aws.String(v)
+	}
+	return s3Metadata
+}
+
+// checkBucket bucket 必须是根目录下的一级目录，不能是 "."、".." 或者包含 "/"
+func checkBucket(bucket string) error {
+
+	if bucket == "" || bucket == "." || bucket == ".." || strings.Contains(bucket, "/") {
+		return newS3Err("InvalidBucketName", http.StatusBadRequest, "The specified bucket is not valid: "+bucket)
+	}
+	return nil
+}
+
+// checkKey HDFS 路径不能包含 "."、".." 和空的路径段，避免访问到 bucket 以外的目录
+func checkKey(object string) error {
+
+	segments := strings.Split(strings.TrimSuffix(object, "/"), "/")
+	for _, v := range segments {
+		if v == "" || v == "." || v == ".." {
+			return newS3Err("InvalidArgument", http.StatusBadRequest, "invalid object key for hdfs: "+object)
+		}
+	}
+	return nil
+}
+
+// PutObjectWithContext 以 "/" 结尾的 key 创建目录
+func (s *webhdfsProto) PutObjectWithContext(ctx context.Context, input *s3.PutObjectInput, opts ...request.Option) (*s3.PutObjectOutput, *http.Response, error) {
+
+	bucket := aws.StringValue(input.Bucket)
+	object := aws.StringValue(input.Key)
+
+	if err := checkKey(object); err != nil {
+		return nil, emptyResponse(), err
+	}
+
+	if err := s.headDirectory(ctx, bucket); err != nil {
+		return nil, emptyResponse(), err
+	}
+
+	if strings.HasSuffix(object, "/") {
+		if err := s.mkdirs(ctx, s.client.hdfsPath(bucket, object)); err != nil {
+			return nil, emptyResponse(), err
+		}
+		return &s3.PutObjectOutput{
+			ETag: aws.String("\"" + hex.EncodeToString(md5.New().Sum(nil)) + "\""),
+		}, emptyResponse(), nil
+	}
+
+	length, err := bodyLength(input.Body)
+	if err != nil {
+		return nil, emptyResponse(), toS3ErrNotResponse(err)
+	}
+
+	var body io.Reader
+	if input.Body != nil {
+		body = input.Body
+	}
+
+	etag, err := s.create(ctx, s.client.hdfsPath(bucket, object), body, length, s3MetaToXAttrs(input.Metadata, input.ContentType))
+	if err != nil {
+		reqinfo.ZError(ctx).Str("Method", "PutObjectWithContext").Str("Bucket", bucket).Str("Object", object).Msg("[WebHDFS] error:" + err.Error())
+		return nil, emptyResponse(), err
+	}
+
+	return &s3.PutObjectOutput{
+		ETag: aws.String(etag),
+	}, emptyResponse(), nil
+}
+
+// statObject 获得文件信息和 xattr，目录不作为对象
+func (s *webhdfsProto) statObject(ctx context.Context, bucket, object string) (*fileStatus, map[string]string, error) {
+
+	if err := checkBucket(bucket); err != nil {
+		return nil, nil, err
+	}
+	if err := checkKey(object); err != nil {
+		return nil, nil, err
+	}
+
+	p := s.client.hdfsPath(bucket, object)
+
+	status, resp, err := s.client.getFileStatus(ctx, p)
+	if err != nil {
+		return nil, nil, toS3ErrNotResponse(err)
+	}
+	if status == nil {
+		if resp.StatusCode == http.StatusNotFound {
+			resp.Body.Close()
+			if err := s.headDirectory(ctx, bucket); err != nil {
+				return nil, nil, err
+			}
+			return nil, nil, newS3Err("NoSuchKey", http.StatusNotFound, "The specified key does not exist.")
+		}
+		return nil, nil, toS3Err(resp, "NoSuchKey")
+	}
+	if status.Type == fileTypeDirectory && !strings.HasSuffix(object, "/") {
+		return nil, nil, newS3Err("NoSuchKey", http.StatusNotFound, "The specified key does not exist.")
+	}
+
+	attrs, err := s.client.getXAttrs(ctx, p)
+	if err != nil {
+		return nil, nil, toS3ErrNotResponse(err)
+	}
+
+	return status, attrs, nil
+}
+
+// open 读取文件的 [offset, offset+length)
+func (s *webhdfsProto) open(ctx context.Context, p string, offset, length int64) (io.ReadCloser, error) {
+
+	query := url.Values{
+		"offset": {strconv.FormatInt(offset, 10)},
+		"length": {strconv.FormatInt(length, 10)},
+	}
+
+	resp, err := s.client.redirect(ctx, http.MethodGet, s.client.opURL(p, "OPEN", query), nil, 0)
+	if err != nil {
+		return nil, toS3ErrNotResponse(err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, toS3Err(resp, "NoSuchKey")
+	}
+	return resp.Body, nil
+}
+
+func (s *webhdfsProto) GetObjectWithContext(ctx context.Context, input *s3.GetObjectInput, opts ...request.Option) (*s3.GetObjectOutput, *http.Response, error) {
+
+	bucket := aws.StringValue(input.Bucket)
+	object := aws.StringValue(input.Key)
+
+	status, attrs, err := s.statObject(ctx, bucket, object)
+	if err != nil {
+		return nil, emptyResponse(), err
+	}
+
+	modified := msToTime(status.ModificationTime)
+	if err := gateway.CheckPreconditions(attrs[xattrETag], modified, input.IfMatch, input.IfNoneMatch, input.IfModifiedSince, input.IfUnmodifiedSince); err != nil {
+		return nil, emptyResponse(), err
+	}
+
+	offset, length := int64(0), status.Length
+	output := &s3.GetObjectOutput{
+		AcceptRanges: aws.String("bytes"),
+		LastModified: aws.Time(modified),
+		Metadata:     xattrsToS3Meta(attrs),
+		ETag:         awsString(attrs[xattrETag]),
+		ContentType:  awsString(attrs[xattrContentType]),
+	}
+
+	if input.Range != nil && status.Length > 0 {
+		offset, length, err = gateway.ParseRange(aws.StringValue(input.Range), status.Length)
+		if err != nil {
+			return nil, emptyResponse(), err
+		}
+		output.ContentRange = aws.String(fmt.Sprintf("bytes %d-%d/%d", offset, offset+length-1, status.Length))
+	}
+	output.ContentLength = aws.Int64(length)
+
+	if status.Type == fileTypeDirectory {
+		output.Body = ioutil.NopCloser(strings.NewReader(""))
+		return output, emptyResponse(), nil
+	}
+
+	output.Body, err = s.open(ctx, s.client.hdfsPath(bucket, object), offset, length)
+	if err != nil {
+		reqinfo.ZError(ctx).Str("Method", "GetObjectWithContext").Str("Bucket", bucket).Str("Object", object).Msg("[WebHDFS] error:" + err.Error())
+		return nil, emptyResponse(), err
+	}
+
+	return output, emptyResponse(), nil
+}
+
+func (s *webhdfsProto) HeadObjectWithContext(ctx context.Context, input *s3.HeadObjectInput, opts ...request.Option) (*s3.HeadObjectOutput, *http.Response, error) {
+
+	status, attrs, err := s.statObject(ctx, aws.StringValue(input.Bucket), aws.StringValue(input.Key))
+	if err != nil {
+		return nil, emptyResponse(), err
+	}
+
+	modified := msToTime(status.ModificationTime)
+	if err := gateway.CheckPreconditions(attrs[xattrETag], modified, input.IfMatch, input.IfNoneMatch, input.IfModifiedSince, input.IfUnmodifiedSince); err != nil {
+		return nil, emptyResponse(), err
+	}
+
+	return &s3.HeadObjectOutput{
+		AcceptRanges:  aws.String("bytes"),
+		ContentLength: aws.Int64(status.Length),
+		LastModified:  aws.Time(modified),
+		Metadata:      xattrsToS3Meta(attrs),
+		ETag:          awsString(attrs[xattrETag]),
+		ContentType:   awsString(attrs[xattrContentType]),
+	}, emptyResponse(), nil
+}
+
+// DeleteObjectWithContext 对象不存在时也返回成功
+func (s *webhdfsProto) DeleteObjectWithContext(ctx context.Context, input *s3.DeleteObjectInput, opts ...request.Option) (*s3.DeleteObjectOutput, *http.Response, error) {
+
+	bucket := aws.StringValue(input.Bucket)
+	object := aws.StringValue(input.Key)
+
+	if err := checkBucket(bucket); err != nil {
+		return nil, emptyResponse(), err
+	}
+	if err := checkKey(object); err != nil {
+		return nil, emptyResponse(), err
+	}
+
+	err := s.delete(ctx, s.client.hdfsPath(bucket, object))
+	if e, ok := err.(interface{ StatusCode() int }); ok && e.StatusCode() == http.StatusNotFound {
+		err = nil
+	}
+	if err != nil {
+		reqinfo.ZError(ctx).Str("Method", "DeleteObjectWithContext").Str("Bucket", bucket).Str("Object", object).Msg("[WebHDFS] error:" + err.Error())
+		return nil, emptyResponse(), err
+	}
+
+	return &s3.DeleteObjectOutput{}, emptyResponse(), nil
+}
+
+// CopyObjectWithContext WebHDFS 没有复制接口，通过 OPEN 和 CREATE 完成
+func (s *webhdfsProto) CopyObjectWithContext(ctx context.Context, input *s3.CopyObjectInput, opts ...request.Option) (*s3.CopyObjectOutput, *http.Response, error) {
+
+	bucket := aws.StringValue(input.Bucket)
+	object := aws.StringValue(input.Key)
+
+	srcBucket, srcObject, ok := gateway.ParseCopySource(aws.StringValue(input.CopySource))
+	if !ok {
+		return nil, emptyResponse(), newS3Err("InvalidArgument", http.StatusBadRequest, "Copy Source must mention the source bucket and key: sourcebucket/sourcekey")
+	}
+
+	status, attrs, err := s.statObject(ctx, srcBucket, srcObject)
+	if err != nil {
+		return nil, emptyResponse(), err
+	}
+	if status.Type == fileTypeDirectory {
+		return nil, emptyResponse(), newS3Err("InvalidArgument", http.StatusBadRequest, "copy of directory is not supported")
+	}
+
+	if err := gateway.CheckPreconditions(attrs[xattrETag], msToTime(status.ModificationTime), input.CopySourceIfMatch, input.CopySourceIfNoneMatch,
+		input.CopySourceIfModifiedSince, input.CopySourceIfUnmodifiedSince); err != nil {
+		return nil, emptyResponse(), err
+	}
+
+	if err := checkKey(object); err != nil {
+		return nil, emptyResponse(), err
+	}
+
+	if err := s.headDirectory(ctx, bucket); err != nil {
+		return nil, emptyResponse(), err
+	}
+
+	newAttrs := s3MetaToXAttrs(input.Metadata, input.ContentType)
+	if aws.StringValue(input.MetadataDirective) != s3.MetadataDirectiveReplace {
+		newAttrs = make(map[string]string, len(attrs))
+		for k, v := range attrs {
+			if k != xattrETag {
+				newAttrs[k] = v
+			}
+		}
+	}
+
+	body, err := s.open(ctx, s.client.hdfsPath(srcBucket, srcObject), 0, status.Length)
+	if err != nil {
+		return nil, emptyResponse(), err
+	}
+	defer body.Close()
+
+	etag, err := s.create(ctx, s.client.hdfsPath(bucket, object), body, status.Length, newAttrs)
+	if err != nil {
+		reqinfo.ZError(ctx).Str("Method", "CopyObjectWithContext").Str("Bucket", bucket).Str("Object", object).Msg("[WebHDFS] error:" + err.Error())
+		return nil, emptyResponse(), err
+	}
+
+	return &s3.CopyObjectOutput{
+		CopyObjectResult: &s3.CopyObjectResult{
+			ETag:         aws.String(etag),
+			LastModified: aws.Time(time.Now().UTC()),
+		},
+	}, emptyResponse(), nil
+}
+
+func awsString(v string) *string {
+	if v == "" {
+		return nil
+	}
+	return aws.String(v)
+}
@@ -0,0 +1,509 @@
+package webhdfs
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/solution9th/S3Adapter/internal/auth"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/smartystreets/goconvey/convey"
+)
+
+const (
+	testUser = "hadoop"
+)
+
+type fakeNode struct {
+	dir      bool
+	data     []byte
+	xattrs   map[string]string
+	modified time.Time
+}
+
+// fakeHDFS 内存中的 WebHDFS，CREATE 和 OPEN 会像 NameNode 一样返回 307 到 /datanode
+type fakeHDFS struct {
+	mu    sync.Mutex
+	nodes map[string]*fakeNode
+
+	// batch LISTSTATUS_BATCH 每次返回的数量，用于测试分页
+	batch int
+}
+
+func newFakeHDFS() *fakeHDFS {
+	return &fakeHDFS{
+		nodes: map[string]*fakeNode{
+			"/": {dir: true, modified: time.Now()},
+		},
+		batch: 2,
+	}
+}
+
+func (f *fakeHDFS) writeException(w http.ResponseWriter, status int, exception, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	fmt.Fprintf(w, `{"RemoteException":{"exception":%q,"javaClassName":"org.apache.hadoop.%s","message":%q}}`, exception, exception, message)
+}
+
+func (f *fakeHDFS) writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+func (f *fakeHDFS) status(name string, n *fakeNode) map[string]interface{} {
+	t := fileTypeFile
+	if n.dir {
+		t = fileTypeDirectory
+	}
+	return map[string]interface{}{
+		"pathSuffix":       name,
+		"type":             t,
+		"length":           len(n.data),
+		"modificationTime": n.modified.UnixNano() / int64(time.Millisecond),
+		"owner":            testUser,
+	}
+}
+
+func (f *fakeHDFS) children(p string) []string {
+	var names []string
+	for k := range f.nodes {
+		if k != "/" && path.Dir(k) == p {
+			names = append(names, path.Base(k))
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (f *fakeHDFS) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	q := r.URL.Query()
+	if q.Get("user.name") != testUser {
+		f.writeException(w, http.StatusUnauthorized, "SecurityException", "missing user.name")
+		return
+	}
+
+	datanode := strings.HasPrefix(r.URL.Path, "/datanode")
+	p := path.Clean("/" + strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, "/datanode"), webhdfsPrefix))
+	n, ok := f.nodes[p]
+
+	switch q.Get("op") {
+	case "GETFILESTATUS":
+		if !ok {
+			f.writeException(w, http.StatusNotFound, "FileNotFoundException", "File does not exist: "+p)
+			return
+		}
+		f.writeJSON(w, map[string]interface{}{"FileStatus": f.status("", n)})
+	case "LISTSTATUS_BATCH":
+		if !ok {
+			f.writeException(w, http.StatusNotFound, "FileNotFoundException", "File does not exist: "+p)
+			return
+		}
+		names := f.children(p)
+		after := q.Get("startAfter")
+		i := sort.SearchStrings(names, after)
+		if after != "" && i < len(names) && names[i] == after {
+			i++
+		}
+		names = names[i:]
+		remaining := 0
+		if len(names) > f.batch {
+			remaining = len(names) - f.batch
+			names = names[:f.batch]
+		}
+		list := make([]interface{}, 0, len(names))
+		for _, name := range names {
+			list = append(list, f.status(name, f.nodes[path.Join(p, name)]))
+		}
+		f.writeJSON(w, map[string]interface{}{
+			"DirectoryListing": map[string]interface{}{
+				"partialListing":   map[string]interface{}{"FileStatuses": map[string]interface{}{"FileStatus": list}},
+				"remainingEntries": remaining,
+			},
+		})
+	case "MKDIRS":
+		for d := p; d != "/"; d = path.Dir(d) {
+			if _, ok := f.nodes[d]; !ok {
+				f.nodes[d] = &fakeNode{dir: true, modified: time.Now()}
+			}
+		}
+		f.writeJSON(w, map[string]bool{"boolean": true})
+	case "CREATE":
+		if !datanode {
+			w.Header().Set("Location", "/datanode"+r.URL.RequestURI())
+			w.WriteHeader(http.StatusTemporaryRedirect)
+			return
+		}
+		// 和 HDFS 一样自动创建父目录
+		for d := path.Dir(p); d != "/"; d = path.Dir(d) {
+			if parent, ok := f.nodes[d]; !ok {
+				f.nodes[d] = &fakeNode{dir: true, modified: time.Now()}
+			} else if !parent.dir {
+				f.writeException(w, http.StatusForbidden, "ParentNotDirectoryException", "Parent path is not a directory: "+d)
+				return
+			}
+		}
+		data, _ := ioutil.ReadAll(r.Body)
+		f.nodes[p] = &fakeNode{data: data, xattrs: map[string]string{}, modified: time.Now()}
+		w.WriteHeader(http.StatusCreated)
+	case "OPEN":
+		if !ok || n.dir {
+			f.writeException(w, http.StatusNotFound, "FileNotFoundException", "File does not exist: "+p)
+			return
+		}
+		if !datanode {
+			w.Header().Set("Location", "/datanode"+r.URL.RequestURI())
+			w.WriteHeader(http.StatusTemporaryRedirect)
+			return
+		}
+		offset, _ := strconv.Atoi(q.Get("offset"))
+		length, _ := strconv.Atoi(q.Get("length"))
+		w.Write(n.data[offset : offset+length])
+	case "DELETE":
+		if !ok {
+			f.writeJSON(w, map[string]bool{"boolean": false})
+			return
+		}
+		if n.dir && len(f.children(p)) > 0 {
+			f.writeException(w, http.StatusForbidden, "PathIsNotEmptyDirectoryException", p+" is non empty")
+			return
+		}
+		delete(f.nodes, p)
+		f.writeJSON(w, map[string]bool{"boolean": true})
+	case "SETXATTR":
+		if !ok {
+			f.writeException(w, http.StatusNotFound, "FileNotFoundException", "File does not exist: "+p)
+			return
+		}
+		v, err := strconv.Unquote(q.Get("xattr.value"))
+		if err != nil {
+			f.writeException(w, http.StatusBadRequest, "IllegalArgumentException", err.Error())
+			return
+		}
+		n.xattrs[q.Get("xattr.name")] = v
+	case "GETXATTRS":
+		if !ok {
+			f.writeException(w, http.StatusNotFound, "FileNotFoundException", "File does not exist: "+p)
+			return
+		}
+		list := make([]interface{}, 0, len(n.xattrs))
+		for k, v := range n.xattrs {
+			list = append(list, map[string]string{"name": k, "value": strconv.Quote(v)})
+		}
+		f.writeJSON(w, map[string]interface{}{"XAttrs": list})
+	default:
+		f.writeException(w, http.StatusBadRequest, "IllegalArgumentException", "Invalid value for webhdfs parameter \"op\"")
+	}
+}
+
+func newTestProto(t *testing.T) (*webhdfsProto, *fakeHDFS, func()) {
+
+	fake := newFakeHDFS()
+	ts := httptest.NewServer(fake)
+
+	proto, err := New().NewS3Protocol(auth.Credentials{
+		AccessKey: testUser,
+		SecretKey: simpleAuthSecret,
+	}, ts.URL+"/s3", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p := proto.(*webhdfsProto)
+	if err := p.mkdirs(context.Background(), "/s3"); err != nil {
+		t.Fatal(err)
+	}
+
+	return p, fake, ts.Close
+}
+
+func errCode(err error) string {
+	if e, ok := err.(awserr.Error); ok {
+		return e.Code()
+	}
+	return ""
+}
+
+func TestWebHDFSBucket(t *testing.T) {
+
+	convey.Convey("webhdfs bucket", t, func() {
+
+		proto, _, closeFn := newTestProto(t)
+		defer closeFn()
+
+		ctx := context.Background()
+
+		_, resp, err := proto.CreateBucketWithContext(ctx, &s3.CreateBucketInput{Bucket: aws.String("bk1")})
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(resp, convey.ShouldNotBeNil)
+
+		_, _, err = proto.CreateBucketWithContext(ctx, &s3.CreateBucketInput{Bucket: aws.String("bk1")})
+		convey.So(errCode(err), convey.ShouldEqual, "BucketAlreadyOwnedByYou")
+
+		_, _, err = proto.HeadBucketWithContext(ctx, &s3.HeadBucketInput{Bucket: aws.String("bk1")})
+		convey.So(err, convey.ShouldBeNil)
+
+		_, resp, err = proto.HeadBucketWithContext(ctx, &s3.HeadBucketInput{Bucket: aws.String("nobucket")})
+		convey.So(errCode(err), convey.ShouldEqual, "NoSuchBucket")
+		convey.So(resp, convey.ShouldNotBeNil)
+
+		for _, b := range []string{"bk2", "bk3"} {
+			_, _, err = proto.CreateBucketWithContext(ctx, &s3.CreateBucketInput{Bucket: aws.String(b)})
+			convey.So(err, convey.ShouldBeNil)
+		}
+
+		list, _, err := proto.ListBucketsWithContext(ctx, &s3.ListBucketsInput{})
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(len(list.Buckets), convey.ShouldEqual, 3)
+		convey.So(aws.StringValue(list.Buckets[2].Name), convey.ShouldEqual, "bk3")
+
+		_, _, err = proto.PutObjectWithContext(ctx, &s3.PutObjectInput{
+			Bucket: aws.String("bk1"),
+			Key:    aws.String("a.txt"),
+			Body:   bytes.NewReader([]byte("hello")),
+		})
+		convey.So(err, convey.ShouldBeNil)
+
+		_, _, err = proto.DeleteBucketWithContext(ctx, &s3.DeleteBucketInput{Bucket: aws.String("bk1")})
+		convey.So(errCode(err), convey.ShouldEqual, "BucketNotEmpty")
+
+		_, _, err = proto.DeleteBucketWithContext(ctx, &s3.DeleteBucketInput{Bucket: aws.String("bk2")})
+		convey.So(err, convey.ShouldBeNil)
+
+		_, _, err = proto.DeleteBucketWithContext(ctx, &s3.DeleteBucketInput{Bucket: aws.String("bk2")})
+		convey.So(errCode(err), convey.ShouldEqual, "NoSuchBucket")
+
+		// bucket 不能访问根目录以外的目录
+		for _, b := range []string{"..", ".", "", "bk1/..", "../s3"} {
+			_, _, err = proto.CreateBucketWithContext(ctx, &s3.CreateBucketInput{Bucket: aws.String(b)})
+			convey.So(errCode(err), convey.ShouldEqual, "InvalidBucketName")
+
+			_, _, err = proto.HeadBucketWithContext(ctx, &s3.HeadBucketInput{Bucket: aws.String(b)})
+			convey.So(errCode(err), convey.ShouldEqual, "InvalidBucketName")
+
+			_, _, err = proto.DeleteBucketWithContext(ctx, &s3.DeleteBucketInput{Bucket: aws.String(b)})
+			convey.So(errCode(err), convey.ShouldEqual, "InvalidBucketName")
+
+			_, _, err = proto.ListObjectsWithContextV2(ctx, &s3.ListObjectsV2Input{Bucket: aws.String(b)})
+			convey.So(errCode(err), convey.ShouldEqual, "InvalidBucketName")
+
+			_, _, err = proto.PutObjectWithContext(ctx, &s3.PutObjectInput{Bucket: aws.String(b), Key: aws.String("a.txt"), Body: bytes.NewReader(nil)})
+			convey.So(errCode(err), convey.ShouldEqual, "InvalidBucketName")
+
+			_, _, err = proto.GetObjectWithContext(ctx, &s3.GetObjectInput{Bucket: aws.String(b), Key: aws.String("a.txt")})
+			convey.So(errCode(err), convey.ShouldEqual, "InvalidBucketName")
+
+			_, _, err = proto.DeleteObjectWithContext(ctx, &s3.DeleteObjectInput{Bucket: aws.String(b), Key: aws.String("a.txt")})
+			convey.So(errCode(err), convey.ShouldEqual, "InvalidBucketName")
+
+			_, _, err = proto.CopyObjectWithContext(ctx, &s3.CopyObjectInput{Bucket: aws.String(b), Key: aws.String("b.txt"), CopySource: aws.String("bk1/a.txt")})
+			convey.So(errCode(err), convey.ShouldEqual, "InvalidBucketName")
+		}
+	})
+}
+
+func TestWebHDFSObject(t *testing.T) {
+
+	convey.Convey("webhdfs object", t, func() {
+
+		proto, fake, closeFn := newTestProto(t)
+		defer closeFn()
+
+		ctx := context.Background()
+		bucket := aws.String("bk")
+
+		_, _, err := proto.CreateBucketWithContext(ctx, &s3.CreateBucketInput{Bucket: bucket})
+		convey.So(err, convey.ShouldBeNil)
+
+		put, _, err := proto.PutObjectWithContext(ctx, &s3.PutObjectInput{
+			Bucket:      bucket,
+			Key:         aws.String("dir/sub/hello.txt"),
+			Body:        bytes.NewReader([]byte("hello webhdfs")),
+			ContentType: aws.String("text/plain"),
+			Metadata:    map[string]*string{"Foo-Bar": aws.String("baz")},
+		})
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(aws.StringValue(put.ETag), convey.ShouldEqual, fmt.Sprintf("\"%x\"", md5.Sum([]byte("hello webhdfs"))))
+		convey.So(fake.nodes["/s3/bk/dir/sub/hello.txt"].xattrs[xattrETag], convey.ShouldEqual, aws.StringValue(put.ETag))
+
+		head, _, err := proto.HeadObjectWithContext(ctx, &s3.HeadObjectInput{Bucket: bucket, Key: aws.String("dir/sub/hello.txt")})
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(aws.Int64Value(head.ContentLength), convey.ShouldEqual, 13)
+		convey.So(aws.StringValue(head.ContentType), convey.ShouldEqual, "text/plain")
+		convey.So(aws.StringValue(head.ETag), convey.ShouldEqual, aws.StringValue(put.ETag))
+		convey.So(aws.StringValue(head.Metadata["Foo-Bar"]), convey.ShouldEqual, "baz")
+
+		_, _, err = proto.HeadObjectWithContext(ctx, &s3.HeadObjectInput{Bucket: bucket, Key: aws.String("dir/sub/hello.txt"), IfNoneMatch: put.ETag})
+		convey.So(errCode(err), convey.ShouldEqual, "NotModified")
+
+		_, _, err = proto.HeadObjectWithContext(ctx, &s3.HeadObjectInput{Bucket: bucket, Key: aws.String("dir")})
+		convey.So(errCode(err), convey.ShouldEqual, "NoSuchKey")
+
+		_, _, err = proto.HeadObjectWithContext(ctx, &s3.HeadObjectInput{Bucket: aws.String("nobucket"), Key: aws.String("a")})
+		convey.So(errCode(err), convey.ShouldEqual, "NoSuchBucket")
+
+		get, _, err := proto.GetObjectWithContext(ctx, &s3.GetObjectInput{Bucket: bucket, Key: aws.String("dir/sub/hello.txt")})
+		convey.So(err, convey.ShouldBeNil)
+		body, _ := ioutil.ReadAll(get.Body)
+		get.Body.Close()
+		convey.So(string(body), convey.ShouldEqual, "hello webhdfs")
+
+		get, _, err = proto.GetObjectWithContext(ctx, &s3.GetObjectInput{Bucket: bucket, Key: aws.String("dir/sub/hello.txt"), Range: aws.String("bytes=6-")})
+		convey.So(err, convey.ShouldBeNil)
+		body, _ = ioutil.ReadAll(get.Body)
+		get.Body.Close()
+		convey.So(string(body), convey.ShouldEqual, "webhdfs")
+		convey.So(aws.StringValue(get.ContentRange), convey.ShouldEqual, "bytes 6-12/13")
+
+		_, _, err = proto.GetObjectWithContext(ctx, &s3.GetObjectInput{Bucket: bucket, Key: aws.String("dir/sub/hello.txt"), Range: aws.String("bytes=100-")})
+		convey.So(errCode(err), convey.ShouldEqual, "InvalidRange")
+
+		_, _, err = proto.GetObjectWithContext(ctx, &s3.GetObjectInput{Bucket: bucket, Key: aws.String("../../etc/passwd")})
+		convey.So(errCode(err), convey.ShouldEqual, "InvalidArgument")
+
+		cp, _, err := proto.CopyObjectWithContext(ctx, &s3.CopyObjectInput{
+			Bucket:     bucket,
+			Key:        aws.String("copy.txt"),
+			CopySource: aws.String("/bk/dir/sub/hello.txt"),
+		})
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(aws.StringValue(cp.CopyObjectResult.ETag), convey.ShouldEqual, aws.StringValue(put.ETag))
+
+		head, _, err = proto.HeadObjectWithContext(ctx, &s3.HeadObjectInput{Bucket: bucket, Key: aws.String("copy.txt")})
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(aws.StringValue(head.Metadata["Foo-Bar"]), convey.ShouldEqual, "baz")
+
+		_, _, err = proto.DeleteObjectWithContext(ctx, &s3.DeleteObjectInput{Bucket: bucket, Key: aws.String("copy.txt")})
+		convey.So(err, convey.ShouldBeNil)
+
+		_, _, err = proto.DeleteObjectWithContext(ctx, &s3.DeleteObjectInput{Bucket: bucket, Key: aws.String("copy.txt")})
+		convey.So(err, convey.ShouldBeNil)
+
+		_, _, err = proto.GetObjectWithContext(ctx, &s3.GetObjectInput{Bucket: bucket, Key: aws.String("copy.txt")})
+		convey.So(errCode(err), convey.ShouldEqual, "NoSuchKey")
+	})
+}
+
+func TestWebHDFSList(t *testing.T) {
+
+	convey.Convey("webhdfs list objects", t, func() {
+
+		proto, _, closeFn := newTestProto(t)
+		defer closeFn()
+
+		ctx := context.Background()
+		bucket := aws.String("bk")
+
+		_, _, err := proto.CreateBucketWithContext(ctx, &s3.CreateBucketInput{Bucket: bucket})
+		convey.So(err, convey.ShouldBeNil)
+
+		keys := []string{"a.txt", "b/1.txt", "b/2.txt", "b/c/3.txt", "d.txt", "e/"}
+		for _, k := range keys {
+			_, _, err := proto.PutObjectWithContext(ctx, &s3.PutObjectInput{
+				Bucket: bucket,
+				Key:    aws.String(k),
+				Body:   bytes.NewReader([]byte(k)),
+			})
+			convey.So(err, convey.ShouldBeNil)
+		}
+
+		convey.Convey("delimiter", func() {
+			list, _, err := proto.ListObjectsWithContext(ctx, &s3.ListObjectsInput{Bucket: bucket, Delimiter: aws.String("/")})
+			convey.So(err, convey.ShouldBeNil)
+			convey.So(len(list.Contents), convey.ShouldEqual, 2)
+			convey.So(aws.StringValue(list.Contents[0].Key), convey.ShouldEqual, "a.txt")
+			convey.So(aws.Int64Value(list.Contents[0].Size), convey.ShouldEqual, 5)
+			convey.So(len(list.CommonPrefixes), convey.ShouldEqual, 2)
+			convey.So(aws.StringValue(list.CommonPrefixes[0].Prefix), convey.ShouldEqual, "b/")
+			convey.So(aws.StringValue(list.CommonPrefixes[1].Prefix), convey.ShouldEqual, "e/")
+
+			list, _, err = proto.ListObjectsWithContext(ctx, &s3.ListObjectsInput{Bucket: bucket, Prefix: aws.String("b/"), Delimiter: aws.String("/")})
+			convey.So(err, convey.ShouldBeNil)
+			convey.So(len(list.Contents), convey.ShouldEqual, 2)
+			convey.So(aws.StringValue(list.Contents[1].Key), convey.ShouldEqual, "b/2.txt")
+			convey.So(len(list.CommonPrefixes), convey.ShouldEqual, 1)
+			convey.So(aws.StringValue(list.CommonPrefixes[0].Prefix), convey.ShouldEqual, "b/c/")
+
+			list, _, err = proto.ListObjectsWithContext(ctx, &s3.ListObjectsInput{Bucket: bucket, Prefix: aws.String("nodir/"), Delimiter: aws.String("/")})
+			convey.So(err, convey.ShouldBeNil)
+			convey.So(len(list.Contents), convey.ShouldEqual, 0)
+		})
+
+		convey.Convey("recursive", func() {
+			list, _, err := proto.ListObjectsWithContext(ctx, &s3.ListObjectsInput{Bucket: bucket, Prefix: aws.String("b")})
+			convey.So(err, convey.ShouldBeNil)
+			convey.So(len(list.Contents), convey.ShouldEqual, 3)
+			convey.So(aws.StringValue(list.Contents[2].Key), convey.ShouldEqual, "b/c/3.txt")
+
+			list, _, err = proto.ListObjectsWithContext(ctx, &s3.ListObjectsInput{Bucket: bucket})
+			convey.So(err, convey.ShouldBeNil)
+			convey.So(len(list.Contents), convey.ShouldEqual, len(keys))
+			convey.So(aws.StringValue(list.Contents[5].Key), convey.ShouldEqual, "e/")
+		})
+
+		convey.Convey("paging v1", func() {
+			var got []string
+			marker := ""
+			for {
+				list, _, err := proto.ListObjectsWithContext(ctx, &s3.ListObjectsInput{
+					Bucket:    bucket,
+					Delimiter: aws.String("/"),
+					Marker:    aws.String(marker),
+					MaxKeys:   aws.Int64(1),
+				})
+				convey.So(err, convey.ShouldBeNil)
+				for _, v := range list.Contents {
+					got = append(got, aws.StringValue(v.Key))
+				}
+				for _, v := range list.CommonPrefixes {
+					got = append(got, aws.StringValue(v.Prefix))
+				}
+				if !aws.BoolValue(list.IsTruncated) {
+					break
+				}
+				marker = aws.StringValue(list.NextMarker)
+			}
+			convey.So(got, convey.ShouldResemble, []string{"a.txt", "b/", "d.txt", "e/"})
+		})
+
+		convey.Convey("paging v2", func() {
+			var got []string
+			var token *string
+			for {
+				list, _, err := proto.ListObjectsWithContextV2(ctx, &s3.ListObjectsV2Input{
+					Bucket:            bucket,
+					ContinuationToken: token,
+					StartAfter:        aws.String("a.txt"),
+					MaxKeys:           aws.Int64(2),
+				})
+				convey.So(err, convey.ShouldBeNil)
+				for _, v := range list.Contents {
+					got = append(got, aws.StringValue(v.Key))
+				}
+				if !aws.BoolValue(list.IsTruncated) {
+					break
+				}
+				token = list.NextContinuationToken
+			}
+			convey.So(got, convey.ShouldResemble, []string{"b/1.txt", "b/2.txt", "b/c/3.txt", "d.txt", "e/"})
+		})
+	})
+}
@@ -1196,6 +1196,56 @@ var errorCodes = errorMap{
 		Description:    "AccessKey Has Created",
 		HTTPStatusCode: http.StatusBadRequest,
 	},
+	ErrEngineNotAllowed: {
+		Code:           "EngineNotAllowed",
+		Description:    "The engine or region is not allowed to be registered.",
+		HTTPStatusCode: http.StatusForbidden,
+	},
+	ErrUserAlreadyExists: {
+		Code:           "EntityAlreadyExists",
+		Description:    "The user already exists in the application.",
+		HTTPStatusCode: http.StatusConflict,
+	},
+	ErrNoSuchUser: {
+		Code:           "NoSuchEntity",
+		Description:    "The user does not exist in the application.",
+		HTTPStatusCode: http.StatusNotFound,
+	},
+	ErrInvalidAction: {
+		Code:           "InvalidAction",
+		Description:    "The action or operation requested is invalid.",
+		HTTPStatusCode: http.StatusBadRequest,
+	},
+	ErrValidationError: {
+		Code:           "ValidationError",
+		Description:    "The input fails to satisfy the constraints specified by the service.",
+		HTTPStatusCode: http.StatusBadRequest,
+	},
+	ErrMalformedPolicyDocument: {
+		Code:           "MalformedPolicyDocument",
+		Description:    "The request was rejected because the policy document was malformed.",
+		HTTPStatusCode: http.StatusBadRequest,
+	},
+	ErrInvalidIdentityToken: {
+		Code:           "InvalidIdentityToken",
+		Description:    "The web identity token that was passed could not be validated.",
+		HTTPStatusCode: http.StatusBadRequest,
+	},
+	ErrExpiredToken: {
+		Code:           "ExpiredTokenException",
+		Description:    "The web identity token that was passed is expired.",
+		HTTPStatusCode: http.StatusBadRequest,
+	},
+	ErrClientCertAlreadyExists: {
+		Code:           "EntityAlreadyExists",
+		Description:    "The certificate identity is already mapped to an application.",
+		HTTPStatusCode: http.StatusConflict,
+	},
+	ErrNoSuchClientCert: {
+		Code:           "NoSuchEntity",
+		Description:    "The certificate identity is not mapped to the application.",
+		HTTPStatusCode: http.StatusNotFound,
+	},
 }
 
 const (
@@ -1451,4 +1501,14 @@ const (
 	ErrAddUserInvalidArgument
 
 	ErrNotFoundError
+	ErrEngineNotAllowed
+	ErrUserAlreadyExists
+	ErrNoSuchUser
+	ErrInvalidAction
+	ErrValidationError
+	ErrMalformedPolicyDocument
+	ErrInvalidIdentityToken
+	ErrExpiredToken
+	ErrClientCertAlreadyExists
+	ErrNoSuchClientCert
 )
@@ -0,0 +1,286 @@
+// Package metrics 进程内的计数器和直方图，以 Prometheus 文本格式输出
+//
+// 只实现了 counter 和 histogram，够用即可，不依赖 Prometheus 的客户端库
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefBuckets 默认的直方图分桶，单位秒
+var DefBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+type collector interface {
+	write(w io.Writer)
+}
+
+// Registry 保存所有的指标
+type Registry struct {
+	mu         sync.Mutex
+	collectors []collector
+}
+
+// NewRegistry 创建空的 Registry
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+func (r *Registry) register(c collector) {
+	r.mu.Lock()
+	r.collectors = append(r.collectors, c)
+	r.mu.Unlock()
+}
+
+// Write 按注册顺序输出所有指标
+func (r *Registry) Write(w io.Writer) {
+
+	r.mu.Lock()
+	collectors := append([]collector(nil), r.collectors...)
+	r.mu.Unlock()
+
+	bw := bufio.NewWriter(w)
+	for _, c := range collectors {
+		c.write(bw)
+	}
+	bw.Flush()
+}
+
+// Handler 输出指标的 HTTP handler
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		r.Write(w)
+	})
+}
+
+// DefaultRegistry 默认的 Registry，下面定义的指标都注册在这里
+var DefaultRegistry = NewRegistry()
+
+// Handler 输出 DefaultRegistry 的 HTTP handler
+func Handler() http.Handler {
+	return DefaultRegistry.Handler()
+}
+
+// vec 按标签值分组的指标
+type vec struct {
+	name   string
+	help   string
+	labels []string
+
+	mu     sync.Mutex
+	values map[string][]string
+}
+
+func (v *vec) key(lvs []string) string {
+	if len(lvs) != len(v.labels) {
+		panic(fmt.Sprintf("metrics: %s expects %d label values, got %d", v.name, len(v.labels), len(lvs)))
+	}
+	return strings.Join(lvs, "\xff")
+}
+
+// sortedKeys 调用时需要持有锁
+func (v *vec) sortedKeys() []string {
+	keys := make([]string, 0, len(v.values))
+	for k := range v.values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (v *vec) header(w io.Writer, typ string) {
+	fmt.Fprintf(w, "# HELP %s %s\n", v.name, v.help)
+	fmt.Fprintf(w, "# TYPE %s %s\n", v.name, typ)
+}
+
+// CounterVec 只增不减的计数器
+type CounterVec struct {
+	vec
+	counts map[string]float64
+}
+
+// NewCounterVec 创建计数器并注册到 r
+func NewCounterVec(r *Registry, name, help string, labels ...string) *CounterVec {
+	c := &CounterVec{
+		vec:    vec{name: name, help: help, labels: labels, values: make(map[string][]string)},
+		counts: make(map[string]float64),
+	}
+	r.register(c)
+	return c
+}
+
+// Add 增加 n，lvs 为标签值，顺序与创建时的标签相同
+func (c *CounterVec) Add(n float64, lvs ...string) {
+
+	k := c.key(lvs)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, ok := c.values[k]; !ok {
+		c.values[k] = append([]string(nil), lvs...)
+	}
+	c.counts[k] += n
+}
+
+// Inc 增加 1
+func (c *CounterVec) Inc(lvs ...string) {
+	c.Add(1, lvs...)
+}
+
+// Value 当前的值，主要用于测试
+func (c *CounterVec) Value(lvs ...string) float64 {
+
+	k := c.key(lvs)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.counts[k]
+}
+
+func (c *CounterVec) write(w io.Writer) {
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.header(w, "counter")
+	for _, k := range c.sortedKeys() {
+		fmt.Fprintf(w, "%s%s %s\n", c.name, formatLabels(c.labels, c.values[k], "", ""), formatFloat(c.counts[k]))
+	}
+}
+
+// HistogramVec 直方图，记录分布、总和和次数
+type HistogramVec struct {
+	vec
+	buckets []float64
+	series  map[string]*histogram
+}
+
+type histogram struct {
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+// NewHistogramVec 创建直方图并注册到 r，buckets 为空时使用 DefBuckets
+func NewHistogramVec(r *Registry, name, help string, buckets []float64, labels ...string) *HistogramVec {
+
+	if len(buckets) == 0 {
+		buckets = DefBuckets
+	}
+	buckets = append([]float64(nil), buckets...)
+	sort.Float64s(buckets)
+
+	h := &HistogramVec{
+		vec:     vec{name: name, help: help, labels: labels, values: make(map[string][]string)},
+		buckets: buckets,
+		series:  make(map[string]*histogram),
+	}
+	r.register(h)
+	return h
+}
+
+// Observe 记录一次观测值
+func (h *HistogramVec) Observe(v float64, lvs ...string) {
+
+	k := h.key(lvs)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	s, ok := h.series[k]
+	if !ok {
+		h.values[k] = append([]string(nil), lvs...)
+		s = &histogram{counts: make([]uint64, len(h.buckets))}
+		h.series[k] = s
+	}
+
+	for i, b := range h.buckets {
+		if v <= b {
+			s.counts[i]++
+		}
+	}
+	s.sum += v
+	s.count++
+}
+
+// Count 观测的次数，主要用于测试
+func (h *HistogramVec) Count(lvs ...string) uint64 {
+
+	k := h.key(lvs)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if s, ok := h.series[k]; ok {
+		return s.count
+	}
+	return 0
+}
+
+func (h *HistogramVec) write(w io.Writer) {
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.header(w, "histogram")
+	for _, k := range h.sortedKeys() {
+		lvs, s := h.values[k], h.series[k]
+		for i, b := range h.buckets {
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, formatLabels(h.labels, lvs, "le", formatFloat(b)), s.counts[i])
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, formatLabels(h.labels, lvs, "le", "+Inf"), s.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.name, formatLabels(h.labels, lvs, "", ""), formatFloat(s.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.name, formatLabels(h.labels, lvs, "", ""), s.count)
+	}
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func formatLabels(labels, values []string, extraName, extraValue string) string {
+
+	if len(labels) == 0 && extraName == "" {
+		return ""
+	}
+
+	var b strings.Builder
+	b.WriteByte('{')
+	for i, l := range labels {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(l)
+		b.WriteString(`="`)
+		b.WriteString(labelEscaper.Replace(values[i]))
+		b.WriteByte('"')
+	}
+	if extraName != "" {
+		if len(labels) > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(extraName)
+		b.WriteString(`="`)
+		b.WriteString(extraValue)
+		b.WriteByte('"')
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
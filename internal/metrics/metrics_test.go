@@ -0,0 +1,63 @@
+package metrics
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/smartystreets/goconvey/convey"
+)
+
+func TestRegistry(t *testing.T) {
+
+	convey.Convey("text format", t, func() {
+
+		r := NewRegistry()
+		c := NewCounterVec(r, "test_requests_total", "Number of requests.", "api", "code")
+		h := NewHistogramVec(r, "test_duration_seconds", "Duration.", []float64{1, 0.1}, "api")
+
+		c.Inc("GetObject", "200")
+		c.Add(2, "GetObject", "200")
+		c.Inc("PutObject", `a"b\`)
+		h.Observe(0.05, "GetObject")
+		h.Observe(0.5, "GetObject")
+		h.Observe(5, "GetObject")
+
+		convey.So(c.Value("GetObject", "200"), convey.ShouldEqual, 3)
+		convey.So(h.Count("GetObject"), convey.ShouldEqual, 3)
+
+		var buf bytes.Buffer
+		r.Write(&buf)
+
+		convey.So(buf.String(), convey.ShouldEqual, strings.Join([]string{
+			"# HELP test_requests_total Number of requests.",
+			"# TYPE test_requests_total counter",
+			`test_requests_total{api="GetObject",code="200"} 3`,
+			`test_requests_total{api="PutObject",code="a\"b\\"} 1`,
+			"# HELP test_duration_seconds Duration.",
+			"# TYPE test_duration_seconds histogram",
+			`test_duration_seconds_bucket{api="GetObject",le="0.1"} 1`,
+			`test_duration_seconds_bucket{api="GetObject",le="1"} 2`,
+			`test_duration_seconds_bucket{api="GetObject",le="+Inf"} 3`,
+			`test_duration_seconds_sum{api="GetObject"} 5.55`,
+			`test_duration_seconds_count{api="GetObject"} 3`,
+			"",
+		}, "\n"))
+	})
+
+	convey.Convey("wrong label count", t, func() {
+		c := NewCounterVec(NewRegistry(), "test_total", "Test.", "api")
+		convey.So(func() { c.Inc() }, convey.ShouldPanic)
+	})
+}
+
+func TestResult(t *testing.T) {
+
+	convey.Convey("result", t, func() {
+		convey.So(Result(nil), convey.ShouldEqual, "ok")
+		convey.So(Result(errors.New("timeout")), convey.ShouldEqual, "error")
+		convey.So(Result(awserr.New("NoSuchKey", "", nil)), convey.ShouldEqual, "NoSuchKey")
+	})
+}
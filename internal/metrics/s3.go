@@ -0,0 +1,59 @@
+package metrics
+
+import (
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+)
+
+// S3Adapter 的指标，app 为应用名称，engine 为引擎名称，
+// 认证失败等找不到应用的请求 app、engine 为空
+var (
+	// Requests 请求数，code 为 HTTP 状态码
+	Requests = NewCounterVec(DefaultRegistry, "s3adapter_requests_total",
+		"Number of S3 requests.", "app", "engine", "api", "code")
+
+	// Errors 返回错误的请求数，error 为 S3 错误码，比如 NoSuchKey
+	Errors = NewCounterVec(DefaultRegistry, "s3adapter_request_errors_total",
+		"Number of S3 requests that returned an error.", "app", "engine", "api", "error")
+
+	// RequestDuration 请求的处理时间
+	RequestDuration = NewHistogramVec(DefaultRegistry, "s3adapter_request_duration_seconds",
+		"Time taken to handle S3 requests.", nil, "app", "engine", "api")
+
+	// ReceivedBytes 接收的请求体字节数
+	ReceivedBytes = NewCounterVec(DefaultRegistry, "s3adapter_received_bytes_total",
+		"Bytes received in S3 request bodies.", "app", "engine", "api")
+
+	// SentBytes 发送的响应体字节数
+	SentBytes = NewCounterVec(DefaultRegistry, "s3adapter_sent_bytes_total",
+		"Bytes sent in S3 response bodies.", "app", "engine", "api")
+
+	// BackendDuration 调用后端引擎的时间，result 为 ok 或者后端返回的错误码
+	BackendDuration = NewHistogramVec(DefaultRegistry, "s3adapter_backend_request_duration_seconds",
+		"Time taken by backend engine calls.", nil, "app", "engine", "operation", "result")
+
+	// NetworkDenied 客户端 IP 不满足应用网络限制而拒绝的请求数
+	NetworkDenied = NewCounterVec(DefaultRegistry, "s3adapter_network_denied_total",
+		"Number of requests denied by application network rules.", "app")
+
+	// DBDuration 查询数据库的时间，result 为 ok、not_found 或 error
+	DBDuration = NewHistogramVec(DefaultRegistry, "s3adapter_db_query_duration_seconds",
+		"Time taken by database queries.", nil, "method", "result")
+)
+
+// Result 把错误转换为 result 标签的值
+func Result(err error) string {
+	if err == nil {
+		return "ok"
+	}
+	if e, ok := err.(awserr.Error); ok && e.Code() != "" {
+		return e.Code()
+	}
+	return "error"
+}
+
+// Since 从 start 到现在的秒数
+func Since(start time.Time) float64 {
+	return time.Since(start).Seconds()
+}
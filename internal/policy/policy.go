@@ -0,0 +1,194 @@
+// Package policy 解析和执行 IAM 风格的身份策略
+//
+// 只支持 Effect、Action 和 Resource，不支持 Condition、NotAction 等字段，
+// 包含不支持的字段时解析失败，避免忽略限制后放宽权限
+package policy
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+const (
+	// Allow 允许
+	Allow = "Allow"
+	// Deny 拒绝，优先于 Allow
+	Deny = "Deny"
+
+	// ResourcePrefix S3 资源 ARN 的前缀，后面为 bucket 或者 bucket/object
+	ResourcePrefix = "arn:aws:s3:::"
+
+	// MaxSize 策略文档的最大长度
+	MaxSize = 6144
+)
+
+// ErrTooLarge 策略文档超过 MaxSize
+var ErrTooLarge = errors.New("policy: document too large")
+
+// Policy 身份策略，没有语句允许的请求都拒绝
+type Policy struct {
+	Version   string      `json:"Version,omitempty"`
+	Statement []Statement `json:"Statement"`
+}
+
+// Statement 策略中的一条语句
+type Statement struct {
+	Sid      string     `json:"Sid,omitempty"`
+	Effect   string     `json:"Effect"`
+	Action   StringList `json:"Action"`
+	Resource StringList `json:"Resource"`
+}
+
+// StringList 可以是一个字符串或者字符串数组
+type StringList []string
+
+// UnmarshalJSON 同时接受字符串和数组
+func (l *StringList) UnmarshalJSON(b []byte) error {
+
+	var s string
+	if err := json.Unmarshal(b, &s); err == nil {
+		*l = StringList{s}
+		return nil
+	}
+
+	var list []string
+	if err := json.Unmarshal(b, &list); err != nil {
+		return err
+	}
+	*l = list
+	return nil
+}
+
+// Parse 解析并检查策略文档
+func Parse(doc string) (*Policy, error) {
+
+	if len(doc) > MaxSize {
+		return nil, ErrTooLarge
+	}
+
+	dec := json.NewDecoder(bytes.NewReader([]byte(doc)))
+	dec.DisallowUnknownFields()
+
+	var p Policy
+	if err := dec.Decode(&p); err != nil {
+		return nil, fmt.Errorf("policy: %v", err)
+	}
+	if err := p.validate(); err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+func (p *Policy) validate() error {
+
+	if len(p.Statement) == 0 {
+		return errors.New("policy: no statement")
+	}
+
+	for i, st := range p.Statement {
+		if st.Effect != Allow && st.Effect != Deny {
+			return fmt.Errorf("policy: statement %d: invalid effect %q", i, st.Effect)
+		}
+		if len(st.Action) == 0 || len(st.Resource) == 0 {
+			return fmt.Errorf("policy: statement %d: miss action or resource", i)
+		}
+		for _, a := range st.Action {
+			if a != "*" && !strings.HasPrefix(strings.ToLower(a), "s3:") {
+				return fmt.Errorf("policy: statement %d: invalid action %q", i, a)
+			}
+		}
+		for _, r := range st.Resource {
+			if r != "*" && !strings.HasPrefix(r, ResourcePrefix) {
+				return fmt.Errorf("policy: statement %d: invalid resource %q", i, r)
+			}
+		}
+	}
+	return nil
+}
+
+// Resource 返回 bucket 或者 bucket/object 的 ARN，bucket 为空时为所有资源
+func Resource(bucket, object string) string {
+	if bucket == "" {
+		return ResourcePrefix + "*"
+	}
+	if object == "" {
+		return ResourcePrefix + bucket
+	}
+	return ResourcePrefix + bucket + "/" + object
+}
+
+// IsAllowed 是否允许对 resources 中的任意一个执行 action，
+// 任意一个资源被 Deny 匹配时拒绝，p 为 nil 时拒绝
+func (p *Policy) IsAllowed(action string, resources ...string) bool {
+
+	if p == nil {
+		return false
+	}
+
+	allowed := false
+	for _, st := range p.Statement {
+		if !st.matchAction(action) {
+			continue
+		}
+		for _, r := range resources {
+			if !st.matchResource(r) {
+				continue
+			}
+			if st.Effect == Deny {
+				return false
+			}
+			allowed = true
+		}
+	}
+	return allowed
+}
+
+// matchAction action 不区分大小写
+func (st Statement) matchAction(action string) bool {
+	for _, a := range st.Action {
+		if match(strings.ToLower(a), strings.ToLower(action)) {
+			return true
+		}
+	}
+	return false
+}
+
+func (st Statement) matchResource(resource string) bool {
+	for _, r := range st.Resource {
+		if r == "*" || match(r, resource) {
+			return true
+		}
+	}
+	return false
+}
+
+// match 通配符匹配，* 匹配任意多个字符，? 匹配一个字符
+func match(pattern, s string) bool {
+
+	px, sx := 0, 0
+	// star 上一个 * 的位置，next 为这个 * 匹配结束后 s 的位置
+	star, next := -1, 0
+	for sx < len(s) {
+		switch {
+		case px < len(pattern) && (pattern[px] == '?' || pattern[px] == s[sx]):
+			px++
+			sx++
+		case px < len(pattern) && pattern[px] == '*':
+			star, next = px, sx
+			px++
+		case star >= 0:
+			// 回到上一个 *，多匹配一个字符
+			px, next = star+1, next+1
+			sx = next
+		default:
+			return false
+		}
+	}
+	for px < len(pattern) && pattern[px] == '*' {
+		px++
+	}
+	return px == len(pattern)
+}
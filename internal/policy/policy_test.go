@@ -0,0 +1,74 @@
+package policy
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/smartystreets/goconvey/convey"
+)
+
+func TestParse(t *testing.T) {
+
+	convey.Convey("Parse", t, func() {
+
+		p, err := Parse(`{"Version":"2012-10-17","Statement":[{"Effect":"Allow","Action":"s3:GetObject","Resource":["arn:aws:s3:::b/*"]}]}`)
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(p.Statement[0].Action, convey.ShouldResemble, StringList{"s3:GetObject"})
+
+		for _, doc := range []string{
+			`{}`,
+			`not json`,
+			`{"Statement":[{"Effect":"Maybe","Action":"s3:*","Resource":"*"}]}`,
+			`{"Statement":[{"Effect":"Allow","Action":"iam:*","Resource":"*"}]}`,
+			`{"Statement":[{"Effect":"Allow","Action":"s3:*","Resource":"b/*"}]}`,
+			`{"Statement":[{"Effect":"Allow","Action":"s3:*"}]}`,
+			// 不支持 Condition，不能忽略
+			`{"Statement":[{"Effect":"Allow","Action":"s3:*","Resource":"*","Condition":{}}]}`,
+		} {
+			_, err = Parse(doc)
+			convey.So(err, convey.ShouldNotBeNil)
+		}
+
+		_, err = Parse(strings.Repeat(" ", MaxSize+1))
+		convey.So(err, convey.ShouldEqual, ErrTooLarge)
+	})
+}
+
+func TestIsAllowed(t *testing.T) {
+
+	convey.Convey("IsAllowed", t, func() {
+
+		p, err := Parse(`{"Statement":[
+			{"Effect":"Allow","Action":["s3:Get*","s3:PutObject"],"Resource":"arn:aws:s3:::photos/team-a/*"},
+			{"Effect":"Allow","Action":"s3:ListBucket","Resource":"arn:aws:s3:::photos"},
+			{"Effect":"Deny","Action":"*","Resource":"arn:aws:s3:::photos/team-a/secret/*"}
+		]}`)
+		convey.So(err, convey.ShouldBeNil)
+
+		convey.So(p.IsAllowed("s3:GetObject", Resource("photos", "team-a/1.jpg")), convey.ShouldBeTrue)
+		convey.So(p.IsAllowed("S3:getobject", Resource("photos", "team-a/1.jpg")), convey.ShouldBeTrue)
+		convey.So(p.IsAllowed("s3:PutObject", Resource("photos", "team-a/x/y")), convey.ShouldBeTrue)
+		convey.So(p.IsAllowed("s3:DeleteObject", Resource("photos", "team-a/1.jpg")), convey.ShouldBeFalse)
+		convey.So(p.IsAllowed("s3:GetObject", Resource("photos", "team-b/1.jpg")), convey.ShouldBeFalse)
+		convey.So(p.IsAllowed("s3:GetObject", Resource("photos", "team-a/secret/1")), convey.ShouldBeFalse)
+		convey.So(p.IsAllowed("s3:ListBucket", Resource("photos", "")), convey.ShouldBeTrue)
+		convey.So(p.IsAllowed("s3:ListBucket", Resource("videos", "")), convey.ShouldBeFalse)
+		convey.So(p.IsAllowed("s3:ListAllMyBuckets", Resource("", "")), convey.ShouldBeFalse)
+
+		var nilPolicy *Policy
+		convey.So(nilPolicy.IsAllowed("s3:GetObject", "*"), convey.ShouldBeFalse)
+	})
+}
+
+func TestMatch(t *testing.T) {
+
+	convey.Convey("match", t, func() {
+		convey.So(match("*", ""), convey.ShouldBeTrue)
+		convey.So(match("a*b*c", "axxbyyc"), convey.ShouldBeTrue)
+		convey.So(match("a*b*c", "axxbyy"), convey.ShouldBeFalse)
+		convey.So(match("a?c", "abc"), convey.ShouldBeTrue)
+		convey.So(match("a?c", "ac"), convey.ShouldBeFalse)
+		convey.So(match("*/*", "a/b/c"), convey.ShouldBeTrue)
+		convey.So(match("abc", "abcd"), convey.ShouldBeFalse)
+	})
+}
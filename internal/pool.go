@@ -0,0 +1,165 @@
+package internal
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"sync"
+	"time"
+
+	"github.com/solution9th/S3Adapter/internal/auth"
+	"github.com/solution9th/S3Adapter/internal/gateway"
+
+	"github.com/haozibi/zlog"
+)
+
+// Pool 缓存后端客户端，避免每个请求都重新创建客户端和连接
+//
+// 客户端按应用分组，同一个应用可能有多个后端(比如冷热分层)，
+// 后端的 key 变化后会创建新的客户端，旧的客户端空闲后被移除
+type Pool struct {
+	idleTimeout time.Duration
+
+	mu   sync.Mutex
+	apps map[string]map[string]*poolEntry
+
+	// newGateway 测试时替换
+	newGateway func(gatewayName string, creds auth.Credentials, region string) (gateway.S3Protocol, error)
+}
+
+type poolEntry struct {
+	proto    gateway.S3Protocol
+	lastUsed time.Time
+}
+
+// NewPool 创建客户端池，idleTimeout 为客户端的最长空闲时间
+func NewPool(idleTimeout time.Duration) *Pool {
+	return &Pool{
+		idleTimeout: idleTimeout,
+		apps:        make(map[string]map[string]*poolEntry),
+		newGateway:  NewGateway,
+	}
+}
+
+// poolKey 引擎、region 和 key 相同的客户端可以复用，secret 只保存摘要
+func poolKey(gatewayName string, creds auth.Credentials, region string) string {
+	h := sha256.Sum256([]byte(creds.SecretKey + "\n" + creds.SessionToken))
+	return gatewayName + "\n" + region + "\n" + creds.AccessKey + "\n" + hex.EncodeToString(h[:])
+}
+
+// Get 返回应用 oak 的客户端，不存在时创建，p 为 nil 时每次都创建
+func (p *Pool) Get(oak, gatewayName string, creds auth.Credentials, region string) (gateway.S3Protocol, error) {
+
+	if p == nil {
+		return NewGateway(gatewayName, creds, region)
+	}
+
+	key := poolKey(gatewayName, creds, region)
+	now := time.Now()
+
+	p.mu.Lock()
+	if e, ok := p.apps[oak][key]; ok {
+		e.lastUsed = now
+		p.mu.Unlock()
+		return e.proto, nil
+	}
+	p.mu.Unlock()
+
+	// 创建客户端不持有锁，并发创建时保留先写入的
+	proto, err := p.newGateway(gatewayName, creds, region)
+	if err != nil {
+		return nil, err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	entries, ok := p.apps[oak]
+	if !ok {
+		entries = make(map[string]*poolEntry)
+		p.apps[oak] = entries
+	}
+	if e, ok := entries[key]; ok {
+		e.lastUsed = now
+		return e.proto, nil
+	}
+	entries[key] = &poolEntry{proto: proto, lastUsed: now}
+	return proto, nil
+}
+
+// Invalidate 移除应用 oak 的所有客户端，删除应用或者修改后端 key 时调用
+func (p *Pool) Invalidate(oak string) {
+
+	if p == nil {
+		return
+	}
+
+	p.mu.Lock()
+	delete(p.apps, oak)
+	p.mu.Unlock()
+}
+
+// Evict 移除 now 之前空闲超过 idleTimeout 的客户端，返回移除的数量
+func (p *Pool) Evict(now time.Time) int {
+
+	if p == nil {
+		return 0
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	n := 0
+	for oak, entries := range p.apps {
+		for key, e := range entries {
+			if now.Sub(e.lastUsed) >= p.idleTimeout {
+				delete(entries, key)
+				n++
+			}
+		}
+		if len(entries) == 0 {
+			delete(p.apps, oak)
+		}
+	}
+	return n
+}
+
+// Len 缓存的客户端数量
+func (p *Pool) Len() int {
+
+	if p == nil {
+		return 0
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	n := 0
+	for _, entries := range p.apps {
+		n += len(entries)
+	}
+	return n
+}
+
+// Run 定期移除空闲的客户端，直到 ctx 结束
+func (p *Pool) Run(ctx context.Context) {
+
+	interval := p.idleTimeout / 2
+	if interval < time.Second {
+		interval = time.Second
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			if n := p.Evict(now); n > 0 {
+				zlog.ZDebug().Int("Evicted", n).Int("Size", p.Len()).Msg("[Pool]")
+			}
+		}
+	}
+}
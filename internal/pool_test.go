@@ -0,0 +1,88 @@
+package internal
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/solution9th/S3Adapter/internal/auth"
+	"github.com/solution9th/S3Adapter/internal/gateway"
+	"github.com/solution9th/S3Adapter/mocks/mock_gateway"
+
+	"github.com/golang/mock/gomock"
+	"github.com/smartystreets/goconvey/convey"
+)
+
+func TestPool(t *testing.T) {
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	convey.Convey("Pool", t, func() {
+
+		var mu sync.Mutex
+		created := 0
+
+		p := NewPool(time.Minute)
+		p.newGateway = func(gatewayName string, creds auth.Credentials, region string) (gateway.S3Protocol, error) {
+			mu.Lock()
+			created++
+			mu.Unlock()
+			return mock_gateway.NewMockS3Protocol(ctrl), nil
+		}
+
+		creds := auth.Credentials{AccessKey: "ak", SecretKey: "sk"}
+
+		convey.Convey("reuse", func() {
+			a, err := p.Get("oak", "s3", creds, "us-east-1")
+			convey.So(err, convey.ShouldBeNil)
+			b, _ := p.Get("oak", "s3", creds, "us-east-1")
+			convey.So(b, convey.ShouldEqual, a)
+			convey.So(created, convey.ShouldEqual, 1)
+
+			// key 或 region 变化后创建新的客户端
+			c, _ := p.Get("oak", "s3", auth.Credentials{AccessKey: "ak", SecretKey: "sk2"}, "us-east-1")
+			convey.So(c, convey.ShouldNotEqual, a)
+			p.Get("oak", "s3", creds, "us-west-1")
+			convey.So(created, convey.ShouldEqual, 3)
+			convey.So(p.Len(), convey.ShouldEqual, 3)
+		})
+
+		convey.Convey("concurrent", func() {
+			var wg sync.WaitGroup
+			for i := 0; i < 50; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					p.Get("oak", "s3", creds, "us-east-1")
+				}()
+			}
+			wg.Wait()
+			convey.So(p.Len(), convey.ShouldEqual, 1)
+		})
+
+		convey.Convey("invalidate", func() {
+			p.Get("oak", "s3", creds, "us-east-1")
+			p.Get("other", "s3", creds, "us-east-1")
+			p.Invalidate("oak")
+			convey.So(p.Len(), convey.ShouldEqual, 1)
+			p.Get("oak", "s3", creds, "us-east-1")
+			convey.So(created, convey.ShouldEqual, 3)
+		})
+
+		convey.Convey("evict", func() {
+			p.Get("oak", "s3", creds, "us-east-1")
+			convey.So(p.Evict(time.Now()), convey.ShouldEqual, 0)
+			convey.So(p.Evict(time.Now().Add(time.Minute)), convey.ShouldEqual, 1)
+			convey.So(p.Len(), convey.ShouldEqual, 0)
+		})
+
+		convey.Convey("nil pool", func() {
+			var np *Pool
+			_, err := np.Get("oak", "notfound", creds, "")
+			convey.So(err, convey.ShouldEqual, ErrGatewayNotFound)
+			np.Invalidate("oak")
+			convey.So(np.Len(), convey.ShouldEqual, 0)
+		})
+	})
+}
@@ -0,0 +1,79 @@
+// Package reqinfo 请求的跟踪信息，通过 context 在 handler 和引擎之间传递
+package reqinfo
+
+import (
+	"context"
+
+	"github.com/haozibi/zlog"
+	"github.com/rs/zerolog"
+)
+
+// ReqInfo 请求信息，RequestID 在请求开始时生成，其他字段由 handler 填写
+type ReqInfo struct {
+	RequestID  string
+	API        string
+	Bucket     string
+	Object     string
+	RemoteAddr string
+
+	// App、Engine 认证通过后填写，用于指标的标签
+	App    string
+	Engine string
+
+	// AccessKey 签名请求的应用 AccessKey，认证通过后填写
+	AccessKey string
+
+	// ErrorCode 返回给客户端的 S3 错误码
+	ErrorCode string
+}
+
+type contextKey struct{}
+
+// NewContext 返回带有 info 的 context
+func NewContext(ctx context.Context, info *ReqInfo) context.Context {
+	return context.WithValue(ctx, contextKey{}, info)
+}
+
+// FromContext 返回 ctx 中的请求信息，不存在时返回 nil
+func FromContext(ctx context.Context) *ReqInfo {
+	if ctx == nil {
+		return nil
+	}
+	info, _ := ctx.Value(contextKey{}).(*ReqInfo)
+	return info
+}
+
+// RequestID 返回 ctx 中的请求 ID，不存在时返回空字符串
+func RequestID(ctx context.Context) string {
+	if info := FromContext(ctx); info != nil {
+		return info.RequestID
+	}
+	return ""
+}
+
+// App 返回 ctx 中的应用名称，不存在时返回空字符串
+func App(ctx context.Context) string {
+	if info := FromContext(ctx); info != nil {
+		return info.App
+	}
+	return ""
+}
+
+func with(ctx context.Context, e *zerolog.Event) *zerolog.Event {
+	if id := RequestID(ctx); id != "" {
+		return e.Str("RequestID", id)
+	}
+	return e
+}
+
+// ZDebug 带请求 ID 的 zlog.ZDebug
+func ZDebug(ctx context.Context) *zerolog.Event { return with(ctx, zlog.ZDebug()) }
+
+// ZInfo 带请求 ID 的 zlog.ZInfo
+func ZInfo(ctx context.Context) *zerolog.Event { return with(ctx, zlog.ZInfo()) }
+
+// ZWarn 带请求 ID 的 zlog.ZWarn
+func ZWarn(ctx context.Context) *zerolog.Event { return with(ctx, zlog.ZWarn()) }
+
+// ZError 带请求 ID 的 zlog.ZError
+func ZError(ctx context.Context) *zerolog.Event { return with(ctx, zlog.ZError()) }
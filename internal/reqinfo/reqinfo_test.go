@@ -0,0 +1,22 @@
+package reqinfo
+
+import (
+	"context"
+	"testing"
+)
+
+func TestRequestID(t *testing.T) {
+
+	if id := RequestID(context.Background()); id != "" {
+		t.Fatalf("got %q, want empty", id)
+	}
+
+	ctx := NewContext(context.Background(), &ReqInfo{RequestID: "16B0C2F1A3E4D5C60001"})
+	if id := RequestID(ctx); id != "16B0C2F1A3E4D5C60001" {
+		t.Fatalf("got %q", id)
+	}
+
+	// 没有请求信息时也可以记录日志
+	ZDebug(context.Background()).Msg("[test]")
+	ZDebug(ctx).Msg("[test]")
+}
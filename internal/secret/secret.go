@@ -0,0 +1,123 @@
+// Package secret 使用主密钥加密保存在数据库中的 key
+//
+// 密文格式为 enc:v1:<base64(nonce|AES-256-GCM 密文)>，没有前缀的值作为明文处理，
+// 所以加密之前写入的数据可以继续使用，执行 rekey 后全部加密
+package secret
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+)
+
+// prefix 密文的前缀
+const prefix = "enc:v1:"
+
+// KeySize 主密钥的长度
+const KeySize = 32
+
+var (
+	// ErrNoKey 没有配置主密钥，不能解密
+	ErrNoKey = errors.New("secret: master key is not configured")
+
+	// ErrDecrypt 密文被修改或者不是使用这个主密钥加密的
+	ErrDecrypt = errors.New("secret: decrypt failed, wrong master key")
+)
+
+// Cipher 使用主密钥加密和解密，nil 的 Cipher 不加密，解密时只接受明文
+type Cipher struct {
+	aead cipher.AEAD
+}
+
+// New 使用 32 字节的主密钥创建 Cipher
+func New(key []byte) (*Cipher, error) {
+
+	if len(key) != KeySize {
+		return nil, fmt.Errorf("secret: master key must be %d bytes, got %d", KeySize, len(key))
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &Cipher{aead: aead}, nil
+}
+
+// Load 从 base64 编码的主密钥或者保存 base64 主密钥的文件创建 Cipher，
+// 优先使用 masterKey，都为空时返回 nil，不加密
+func Load(masterKey, keyFile string) (*Cipher, error) {
+
+	if masterKey == "" && keyFile != "" {
+		body, err := ioutil.ReadFile(keyFile)
+		if err != nil {
+			return nil, err
+		}
+		masterKey = string(body)
+	}
+
+	masterKey = strings.TrimSpace(masterKey)
+	if masterKey == "" {
+		return nil, nil
+	}
+
+	key, err := base64.StdEncoding.DecodeString(masterKey)
+	if err != nil {
+		return nil, fmt.Errorf("secret: master key is not base64: %v", err)
+	}
+	return New(key)
+}
+
+// IsEncrypted s 是否为密文
+func IsEncrypted(s string) bool {
+	return strings.HasPrefix(s, prefix)
+}
+
+// Encrypt 加密 plain，c 为 nil 或者 plain 为空时原样返回
+func (c *Cipher) Encrypt(plain string) (string, error) {
+
+	if c == nil || plain == "" {
+		return plain, nil
+	}
+
+	nonce := make([]byte, c.aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", err
+	}
+
+	sealed := c.aead.Seal(nonce, nonce, []byte(plain), nil)
+	return prefix + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// Decrypt 解密 s，s 不是密文时原样返回
+func (c *Cipher) Decrypt(s string) (string, error) {
+
+	if !IsEncrypted(s) {
+		return s, nil
+	}
+	if c == nil {
+		return "", ErrNoKey
+	}
+
+	sealed, err := base64.StdEncoding.DecodeString(s[len(prefix):])
+	if err != nil || len(sealed) < c.aead.NonceSize() {
+		return "", ErrDecrypt
+	}
+
+	n := c.aead.NonceSize()
+	plain, err := c.aead.Open(nil, sealed[:n], sealed[n:], nil)
+	if err != nil {
+		return "", ErrDecrypt
+	}
+	return string(plain), nil
+}
@@ -0,0 +1,96 @@
+package secret
+
+import (
+	"bytes"
+	"encoding/base64"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/smartystreets/goconvey/convey"
+)
+
+func TestCipher(t *testing.T) {
+
+	convey.Convey("Cipher", t, func() {
+
+		c, err := New(bytes.Repeat([]byte{1}, KeySize))
+		convey.So(err, convey.ShouldBeNil)
+
+		convey.Convey("round trip", func() {
+			s, err := c.Encrypt("QLLrLCnNc14jUZ3pumD62Sjmwir2r6Ip2vhB9ury")
+			convey.So(err, convey.ShouldBeNil)
+			convey.So(IsEncrypted(s), convey.ShouldBeTrue)
+			convey.So(len(s), convey.ShouldBeLessThanOrEqualTo, 255)
+
+			plain, err := c.Decrypt(s)
+			convey.So(err, convey.ShouldBeNil)
+			convey.So(plain, convey.ShouldEqual, "QLLrLCnNc14jUZ3pumD62Sjmwir2r6Ip2vhB9ury")
+
+			// 每次加密使用不同的 nonce
+			s2, _ := c.Encrypt("QLLrLCnNc14jUZ3pumD62Sjmwir2r6Ip2vhB9ury")
+			convey.So(s2, convey.ShouldNotEqual, s)
+		})
+
+		convey.Convey("plaintext passes through", func() {
+			plain, err := c.Decrypt("sksk")
+			convey.So(err, convey.ShouldBeNil)
+			convey.So(plain, convey.ShouldEqual, "sksk")
+
+			var nilCipher *Cipher
+			s, err := nilCipher.Encrypt("sksk")
+			convey.So(err, convey.ShouldBeNil)
+			convey.So(s, convey.ShouldEqual, "sksk")
+		})
+
+		convey.Convey("wrong key", func() {
+			s, _ := c.Encrypt("sksk")
+
+			other, _ := New(bytes.Repeat([]byte{2}, KeySize))
+			_, err := other.Decrypt(s)
+			convey.So(err, convey.ShouldEqual, ErrDecrypt)
+
+			var nilCipher *Cipher
+			_, err = nilCipher.Decrypt(s)
+			convey.So(err, convey.ShouldEqual, ErrNoKey)
+		})
+
+		convey.Convey("bad key size", func() {
+			_, err := New([]byte("short"))
+			convey.So(err, convey.ShouldNotBeNil)
+		})
+	})
+}
+
+func TestLoad(t *testing.T) {
+
+	convey.Convey("Load", t, func() {
+
+		key := base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{3}, KeySize))
+
+		c, err := Load("", "")
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(c, convey.ShouldBeNil)
+
+		c, err = Load(key, "")
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(c, convey.ShouldNotBeNil)
+
+		dir, _ := ioutil.TempDir("", "secret")
+		defer os.RemoveAll(dir)
+		file := filepath.Join(dir, "master.key")
+		ioutil.WriteFile(file, []byte(key+"\n"), 0600)
+
+		fc, err := Load("", file)
+		convey.So(err, convey.ShouldBeNil)
+
+		s, _ := c.Encrypt("sksk")
+		plain, err := fc.Decrypt(s)
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(plain, convey.ShouldEqual, "sksk")
+
+		_, err = Load("not base64!", "")
+		convey.So(err, convey.ShouldNotBeNil)
+	})
+}
@@ -63,6 +63,12 @@ func NewSignV4(ak, sk, region string, r *http.Request) *SignV4 {
 	}
 }
 
+// WithService 使用 name 作为签名的服务名，比如 STS 请求为 sts，默认为 ServiceName
+func (s *SignV4) WithService(name string) *SignV4 {
+	s.name = name
+	return s
+}
+
 func (s *SignV4) creds(t time.Time) string {
 	return t.Format(TimeISO8601BasicFormatShort) + "/" + s.region + "/" + s.name + "/aws4_request"
 }
@@ -0,0 +1,243 @@
+package trace
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/haozibi/zlog"
+)
+
+const (
+	// queueSize 等待发送的 span 上限，collector 不可用时丢弃新的 span
+	queueSize = 4096
+
+	// batchSize 每次最多发送的 span 数量
+	batchSize = 512
+)
+
+// Exporter 把 span 批量发送到 OTLP/HTTP collector，比如 http://127.0.0.1:4318/v1/traces
+type Exporter struct {
+	endpoint string
+	service  string
+	interval time.Duration
+	client   *http.Client
+
+	queue chan *Span
+}
+
+// NewExporter 创建 Exporter，service 为 service.name，interval 为发送间隔
+func NewExporter(endpoint, service string, interval time.Duration) *Exporter {
+	if interval <= 0 {
+		interval = 5 * time.Second
+	}
+	return &Exporter{
+		endpoint: endpoint,
+		service:  service,
+		interval: interval,
+		client:   &http.Client{Timeout: 10 * time.Second},
+		queue:    make(chan *Span, queueSize),
+	}
+}
+
+var (
+	exporterMu sync.RWMutex
+	exporter   *Exporter
+)
+
+// SetExporter 设置全局的 Exporter，为 nil 时不记录 span
+func SetExporter(e *Exporter) {
+	exporterMu.Lock()
+	exporter = e
+	exporterMu.Unlock()
+}
+
+func getExporter() *Exporter {
+	exporterMu.RLock()
+	defer exporterMu.RUnlock()
+	return exporter
+}
+
+func (e *Exporter) enqueue(s *Span) {
+	select {
+	case e.queue <- s:
+	default:
+		// 不阻塞请求
+	}
+}
+
+// Run 定期发送 span，ctx 结束时发送剩余的 span 后返回
+func (e *Exporter) Run(ctx context.Context) {
+
+	ticker := time.NewTicker(e.interval)
+	defer ticker.Stop()
+
+	batch := make([]*Span, 0, batchSize)
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		if err := e.Export(batch); err != nil {
+			zlog.ZError().Int("Spans", len(batch)).Msg("[Trace] export error:" + err.Error())
+		}
+		batch = batch[:0]
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			for {
+				select {
+				case s := <-e.queue:
+					batch = append(batch, s)
+					if len(batch) == batchSize {
+						flush()
+					}
+				default:
+					flush()
+					return
+				}
+			}
+		case s := <-e.queue:
+			batch = append(batch, s)
+			if len(batch) == batchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		}
+	}
+}
+
+// Export 立即发送 spans
+func (e *Exporter) Export(spans []*Span) error {
+
+	body, err := json.Marshal(e.encode(spans))
+	if err != nil {
+		return err
+	}
+
+	resp, err := e.client.Post(e.endpoint, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("collector returned %s", resp.Status)
+	}
+	return nil
+}
+
+// 以下为 OTLP ExportTraceServiceRequest 的 JSON 编码，
+// trace ID 和 span ID 为十六进制字符串，64 位整数为十进制字符串
+
+type otlpRequest struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+type otlpResourceSpans struct {
+	Resource   otlpResource     `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpResource struct {
+	Attributes []otlpKeyValue `json:"attributes"`
+}
+
+type otlpScopeSpans struct {
+	Scope otlpScope  `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+type otlpSpan struct {
+	TraceID           string         `json:"traceId"`
+	SpanID            string         `json:"spanId"`
+	ParentSpanID      string         `json:"parentSpanId,omitempty"`
+	Name              string         `json:"name"`
+	Kind              Kind           `json:"kind"`
+	StartTimeUnixNano string         `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string         `json:"endTimeUnixNano"`
+	Attributes        []otlpKeyValue `json:"attributes,omitempty"`
+	Status            otlpStatus     `json:"status"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code"`
+	Message string `json:"message,omitempty"`
+}
+
+type otlpKeyValue struct {
+	Key   string    `json:"key"`
+	Value otlpValue `json:"value"`
+}
+
+type otlpValue struct {
+	StringValue *string  `json:"stringValue,omitempty"`
+	BoolValue   *bool    `json:"boolValue,omitempty"`
+	IntValue    *string  `json:"intValue,omitempty"`
+	DoubleValue *float64 `json:"doubleValue,omitempty"`
+}
+
+func toValue(v interface{}) otlpValue {
+	switch x := v.(type) {
+	case string:
+		return otlpValue{StringValue: &x}
+	case bool:
+		return otlpValue{BoolValue: &x}
+	case int:
+		s := strconv.Itoa(x)
+		return otlpValue{IntValue: &s}
+	case int64:
+		s := strconv.FormatInt(x, 10)
+		return otlpValue{IntValue: &s}
+	case float64:
+		return otlpValue{DoubleValue: &x}
+	}
+	s := fmt.Sprint(v)
+	return otlpValue{StringValue: &s}
+}
+
+func (e *Exporter) encode(spans []*Span) otlpRequest {
+
+	list := make([]otlpSpan, 0, len(spans))
+	for _, s := range spans {
+		s.mu.Lock()
+		o := otlpSpan{
+			TraceID:           s.sc.TraceID.String(),
+			SpanID:            s.sc.SpanID.String(),
+			Name:              s.name,
+			Kind:              s.kind,
+			StartTimeUnixNano: strconv.FormatInt(s.start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(s.end.UnixNano(), 10),
+			Status:            otlpStatus{Code: s.status, Message: s.message},
+		}
+		if s.parent != (SpanID{}) {
+			o.ParentSpanID = s.parent.String()
+		}
+		for _, a := range s.attrs {
+			o.Attributes = append(o.Attributes, otlpKeyValue{Key: a.key, Value: toValue(a.value)})
+		}
+		s.mu.Unlock()
+		list = append(list, o)
+	}
+
+	return otlpRequest{ResourceSpans: []otlpResourceSpans{{
+		Resource: otlpResource{Attributes: []otlpKeyValue{
+			{Key: "service.name", Value: toValue(e.service)},
+		}},
+		ScopeSpans: []otlpScopeSpans{{
+			Scope: otlpScope{Name: "github.com/solution9th/S3Adapter"},
+			Spans: list,
+		}},
+	}}}
+}
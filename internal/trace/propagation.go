@@ -0,0 +1,103 @@
+package trace
+
+import (
+	"context"
+	"encoding/hex"
+	"net/http"
+	"strings"
+)
+
+// TraceparentHeader W3C Trace Context 的请求头
+const TraceparentHeader = "traceparent"
+
+// Parse 解析 traceparent，格式为 version-traceid-spanid-flags
+func Parse(s string) (SpanContext, bool) {
+
+	var sc SpanContext
+
+	parts := strings.Split(strings.TrimSpace(s), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" {
+		return sc, false
+	}
+	// 版本 00 只有 4 个字段，更高的版本可能有更多字段
+	if parts[0] == "00" && len(parts) != 4 {
+		return sc, false
+	}
+
+	if len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+		return sc, false
+	}
+	if _, err := hex.Decode(sc.TraceID[:], []byte(parts[1])); err != nil {
+		return sc, false
+	}
+	if _, err := hex.Decode(sc.SpanID[:], []byte(parts[2])); err != nil {
+		return sc, false
+	}
+	flags, err := hex.DecodeString(parts[3])
+	if err != nil {
+		return sc, false
+	}
+	sc.Sampled = flags[0]&0x01 == 0x01
+
+	return sc, sc.IsValid()
+}
+
+// Format 生成 traceparent
+func Format(sc SpanContext) string {
+	flags := "00"
+	if sc.Sampled {
+		flags = "01"
+	}
+	return "00-" + sc.TraceID.String() + "-" + sc.SpanID.String() + "-" + flags
+}
+
+// Extract 读取请求头中的 traceparent，作为之后 span 的父节点
+func Extract(ctx context.Context, h http.Header) context.Context {
+	if sc, ok := Parse(h.Get(TraceparentHeader)); ok {
+		return ContextWithRemote(ctx, sc)
+	}
+	return ctx
+}
+
+// Inject 把 ctx 中当前的 span 写入请求头
+func Inject(ctx context.Context, h http.Header) {
+	if sc := SpanContextFrom(ctx); sc.IsValid() {
+		h.Set(TraceparentHeader, Format(sc))
+	}
+}
+
+// Transport 访问后端时记录 span 并传递 traceparent
+func Transport(base http.RoundTripper) http.RoundTripper {
+	return &transport{base: base}
+}
+
+type transport struct {
+	base http.RoundTripper
+}
+
+func (t *transport) RoundTrip(r *http.Request) (*http.Response, error) {
+
+	ctx, span := Start(r.Context(), "HTTP "+r.Method, KindClient)
+	if span != nil {
+		span.SetAttribute("http.method", r.Method)
+		span.SetAttribute("net.peer.name", r.URL.Hostname())
+	}
+
+	sc := SpanContextFrom(ctx)
+	if sc.IsValid() {
+		// RoundTripper 不能修改原来的请求
+		r = r.Clone(ctx)
+		r.Header.Set(TraceparentHeader, Format(sc))
+	}
+
+	resp, err := t.base.RoundTrip(r)
+	if span != nil {
+		if err != nil {
+			span.SetError(err)
+		} else {
+			span.SetAttribute("http.status_code", resp.StatusCode)
+		}
+		span.End()
+	}
+	return resp, err
+}
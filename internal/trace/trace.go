@@ -0,0 +1,181 @@
+// Package trace 请求的分布式跟踪，兼容 W3C Trace Context 和 OpenTelemetry
+//
+// 接收请求头中的 traceparent，在 handler、认证、数据库和后端调用中记录 span，
+// 访问后端时传递 traceparent，span 以 OTLP/HTTP JSON 格式发送到 collector，
+// 没有设置 Exporter 时不记录 span，只传递收到的 traceparent
+package trace
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"sync"
+	"time"
+)
+
+// TraceID 16 字节的跟踪 ID
+type TraceID [16]byte
+
+// SpanID 8 字节的 span ID
+type SpanID [8]byte
+
+func (t TraceID) String() string { return hex.EncodeToString(t[:]) }
+
+func (s SpanID) String() string { return hex.EncodeToString(s[:]) }
+
+// SpanContext 需要跨进程传递的 span 信息
+type SpanContext struct {
+	TraceID TraceID
+	SpanID  SpanID
+	Sampled bool
+}
+
+// IsValid TraceID 和 SpanID 都不为 0
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID != TraceID{} && sc.SpanID != SpanID{}
+}
+
+// Kind span 的类型，与 OTLP 的 SpanKind 相同
+type Kind int
+
+// span 类型
+const (
+	KindInternal Kind = 1
+	KindServer   Kind = 2
+	KindClient   Kind = 3
+)
+
+// statusError 与 OTLP 的 STATUS_CODE_ERROR 相同，没有错误时为 0 (UNSET)
+const statusError = 2
+
+// Span 一次操作，nil 的 Span 可以正常调用，不做任何事情
+type Span struct {
+	sc     SpanContext
+	parent SpanID
+	kind   Kind
+	start  time.Time
+
+	mu      sync.Mutex
+	name    string
+	end     time.Time
+	attrs   []attribute
+	status  int
+	message string
+	ended   bool
+}
+
+type attribute struct {
+	key   string
+	value interface{}
+}
+
+type spanKey struct{}
+
+type remoteKey struct{}
+
+// ContextWithRemote 返回带有远端 span 的 context，新的 span 以它为父节点
+func ContextWithRemote(ctx context.Context, sc SpanContext) context.Context {
+	return context.WithValue(ctx, remoteKey{}, sc)
+}
+
+// FromContext 返回 ctx 中的 span，不存在时返回 nil
+func FromContext(ctx context.Context) *Span {
+	s, _ := ctx.Value(spanKey{}).(*Span)
+	return s
+}
+
+// SpanContextFrom 返回 ctx 中当前的 span 信息，没有本地 span 时返回远端的
+func SpanContextFrom(ctx context.Context) SpanContext {
+	if s := FromContext(ctx); s != nil {
+		return s.sc
+	}
+	sc, _ := ctx.Value(remoteKey{}).(SpanContext)
+	return sc
+}
+
+// Start 开始一个 span，父节点为 ctx 中的 span，没有设置 Exporter
+// 或者父节点没有被采样时返回 nil
+func Start(ctx context.Context, name string, kind Kind) (context.Context, *Span) {
+
+	if getExporter() == nil {
+		return ctx, nil
+	}
+
+	parent := SpanContextFrom(ctx)
+	if parent.IsValid() && !parent.Sampled {
+		return ctx, nil
+	}
+
+	s := &Span{
+		sc:     SpanContext{TraceID: parent.TraceID, Sampled: true},
+		parent: parent.SpanID,
+		kind:   kind,
+		name:   name,
+		start:  time.Now(),
+	}
+	if !parent.IsValid() {
+		rand.Read(s.sc.TraceID[:])
+	}
+	rand.Read(s.sc.SpanID[:])
+
+	return context.WithValue(ctx, spanKey{}, s), s
+}
+
+// SpanContext 返回 span 的信息
+func (s *Span) SpanContext() SpanContext {
+	if s == nil {
+		return SpanContext{}
+	}
+	return s.sc
+}
+
+// SetName 修改 span 的名称，比如路由匹配之后
+func (s *Span) SetName(name string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	s.name = name
+	s.mu.Unlock()
+}
+
+// SetAttribute 设置属性，value 支持 string、bool、int、int64 和 float64
+func (s *Span) SetAttribute(key string, value interface{}) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	s.attrs = append(s.attrs, attribute{key: key, value: value})
+	s.mu.Unlock()
+}
+
+// SetError 记录错误，err 为 nil 时不做任何事情
+func (s *Span) SetError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+	s.mu.Lock()
+	s.status, s.message = statusError, err.Error()
+	s.mu.Unlock()
+}
+
+// End 结束 span 并交给 Exporter，重复调用只有第一次生效
+func (s *Span) End() {
+
+	if s == nil {
+		return
+	}
+
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	s.end = time.Now()
+	s.mu.Unlock()
+
+	if e := getExporter(); e != nil {
+		e.enqueue(s)
+	}
+}
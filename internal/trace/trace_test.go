@@ -0,0 +1,163 @@
+package trace
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/smartystreets/goconvey/convey"
+)
+
+const parent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+
+func TestParse(t *testing.T) {
+
+	convey.Convey("traceparent", t, func() {
+
+		sc, ok := Parse(parent)
+		convey.So(ok, convey.ShouldBeTrue)
+		convey.So(sc.TraceID.String(), convey.ShouldEqual, "4bf92f3577b34da6a3ce929d0e0e4736")
+		convey.So(sc.SpanID.String(), convey.ShouldEqual, "00f067aa0ba902b7")
+		convey.So(sc.Sampled, convey.ShouldBeTrue)
+		convey.So(Format(sc), convey.ShouldEqual, parent)
+
+		for _, s := range []string{
+			"",
+			"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7",
+			"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+			"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01",
+			"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+			"00-4bf92f3577b34da6a3ce929d0e0e473x-00f067aa0ba902b7-01",
+			"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra",
+		} {
+			_, ok := Parse(s)
+			convey.So(ok, convey.ShouldBeFalse)
+		}
+
+		// 更高的版本可以有更多字段
+		_, ok = Parse("01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00-extra")
+		convey.So(ok, convey.ShouldBeTrue)
+	})
+}
+
+// collector 记录收到的 OTLP 请求
+func collector(reqs chan<- otlpRequest) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req otlpRequest
+		body, _ := ioutil.ReadAll(r.Body)
+		json.Unmarshal(body, &req)
+		reqs <- req
+	}))
+}
+
+func TestSpan(t *testing.T) {
+
+	convey.Convey("span", t, func() {
+
+		convey.Convey("disabled", func() {
+			SetExporter(nil)
+
+			ctx := Extract(context.Background(), http.Header{"Traceparent": []string{parent}})
+			_, span := Start(ctx, "test", KindInternal)
+			convey.So(span, convey.ShouldBeNil)
+			span.SetAttribute("a", "b")
+			span.End()
+
+			// 不记录 span 也传递收到的 traceparent
+			h := make(http.Header)
+			Inject(ctx, h)
+			convey.So(h.Get(TraceparentHeader), convey.ShouldEqual, parent)
+		})
+
+		convey.Convey("export", func() {
+			reqs := make(chan otlpRequest, 1)
+			ts := collector(reqs)
+			defer ts.Close()
+
+			e := NewExporter(ts.URL, "s3adapter", time.Hour)
+			SetExporter(e)
+			defer SetExporter(nil)
+
+			ctx := Extract(context.Background(), http.Header{"Traceparent": []string{parent}})
+			ctx, root := Start(ctx, "PutObject", KindServer)
+			_, child := Start(ctx, "s3.PutObject", KindInternal)
+			child.SetAttribute("s3adapter.engine", "s3")
+			child.SetAttribute("http.status_code", 500)
+			child.SetError(errors.New("timeout"))
+			child.End()
+			root.End()
+			root.End()
+
+			convey.So(root.SpanContext().TraceID.String(), convey.ShouldEqual, "4bf92f3577b34da6a3ce929d0e0e4736")
+			convey.So(child.SpanContext().TraceID, convey.ShouldEqual, root.SpanContext().TraceID)
+
+			runCtx, cancel := context.WithCancel(context.Background())
+			cancel()
+			e.Run(runCtx)
+
+			req := <-reqs
+			convey.So(len(req.ResourceSpans), convey.ShouldEqual, 1)
+			rs := req.ResourceSpans[0]
+			convey.So(*rs.Resource.Attributes[0].Value.StringValue, convey.ShouldEqual, "s3adapter")
+
+			spans := rs.ScopeSpans[0].Spans
+			convey.So(len(spans), convey.ShouldEqual, 2)
+			convey.So(spans[0].Name, convey.ShouldEqual, "s3.PutObject")
+			convey.So(spans[0].ParentSpanID, convey.ShouldEqual, root.SpanContext().SpanID.String())
+			convey.So(spans[0].Status.Code, convey.ShouldEqual, statusError)
+			convey.So(*spans[0].Attributes[1].Value.IntValue, convey.ShouldEqual, "500")
+			convey.So(spans[1].Name, convey.ShouldEqual, "PutObject")
+			convey.So(spans[1].ParentSpanID, convey.ShouldEqual, "00f067aa0ba902b7")
+			convey.So(spans[1].Kind, convey.ShouldEqual, KindServer)
+		})
+
+		convey.Convey("not sampled", func() {
+			SetExporter(NewExporter("http://127.0.0.1:1", "s3adapter", time.Hour))
+			defer SetExporter(nil)
+
+			ctx := Extract(context.Background(), http.Header{"Traceparent": []string{
+				"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00"}})
+			_, span := Start(ctx, "test", KindInternal)
+			convey.So(span, convey.ShouldBeNil)
+		})
+	})
+}
+
+func TestTransport(t *testing.T) {
+
+	var got string
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header.Get(TraceparentHeader)
+	}))
+	defer backend.Close()
+
+	SetExporter(NewExporter("http://127.0.0.1:1", "s3adapter", time.Hour))
+	defer SetExporter(nil)
+
+	sc, _ := Parse(parent)
+	ctx, span := Start(ContextWithRemote(context.Background(), sc), "test", KindInternal)
+
+	req, _ := http.NewRequest(http.MethodGet, backend.URL, nil)
+	req = req.WithContext(ctx)
+	resp, err := (&http.Client{Transport: Transport(http.DefaultTransport)}).Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	out, ok := Parse(got)
+	if !ok {
+		t.Fatalf("traceparent: %q", got)
+	}
+	if out.TraceID != sc.TraceID || out.SpanID == span.SpanContext().SpanID || out.SpanID == sc.SpanID {
+		t.Fatalf("traceparent should carry the client span: %q", got)
+	}
+	if req.Header.Get(TraceparentHeader) != "" {
+		t.Fatal("original request modified")
+	}
+}
@@ -0,0 +1,150 @@
+package webidentity
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+
+	// 注册 SHA256、SHA384 和 SHA512
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+)
+
+// Claims JWT 中的声明
+type Claims map[string]interface{}
+
+// String 返回字符串类型的声明，不存在或者不是字符串时为空
+func (c Claims) String(name string) string {
+	s, _ := c[name].(string)
+	return s
+}
+
+// Strings 返回声明的所有值，数组中的每个元素和其他类型的值都转换为字符串
+func (c Claims) Strings(name string) []string {
+
+	switch v := c[name].(type) {
+	case nil:
+		return nil
+	case []interface{}:
+		list := make([]string, 0, len(v))
+		for _, e := range v {
+			list = append(list, fmt.Sprint(e))
+		}
+		return list
+	default:
+		return []string{fmt.Sprint(v)}
+	}
+}
+
+// time 返回数字类型的时间声明，比如 exp、nbf
+func (c Claims) time(name string) (time.Time, bool) {
+	n, ok := c[name].(json.Number)
+	if !ok {
+		return time.Time{}, false
+	}
+	f, err := n.Float64()
+	if err != nil {
+		return time.Time{}, false
+	}
+	return time.Unix(int64(f), 0), true
+}
+
+type header struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+// token 分开后还没有验证签名的 JWT
+type token struct {
+	header    header
+	claims    Claims
+	signed    []byte
+	signature []byte
+}
+
+// parse 解析 JWT，不验证签名
+func parse(raw string) (*token, error) {
+
+	parts := strings.Split(raw, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("webidentity: malformed token")
+	}
+
+	var t token
+	b, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, errors.New("webidentity: malformed token header")
+	}
+	if err = json.Unmarshal(b, &t.header); err != nil {
+		return nil, errors.New("webidentity: malformed token header")
+	}
+
+	b, err = base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, errors.New("webidentity: malformed token claims")
+	}
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	if err = dec.Decode(&t.claims); err != nil {
+		return nil, errors.New("webidentity: malformed token claims")
+	}
+
+	t.signature, err = base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, errors.New("webidentity: malformed token signature")
+	}
+	t.signed = []byte(parts[0] + "." + parts[1])
+	return &t, nil
+}
+
+// verify 使用 key 验证签名，只支持 RS256/384/512 和 ES256/384/512
+func (t *token) verify(key crypto.PublicKey) error {
+
+	var h crypto.Hash
+	switch t.header.Alg {
+	case "RS256", "ES256":
+		h = crypto.SHA256
+	case "RS384", "ES384":
+		h = crypto.SHA384
+	case "RS512", "ES512":
+		h = crypto.SHA512
+	default:
+		return fmt.Errorf("webidentity: unsupported alg %q", t.header.Alg)
+	}
+
+	hasher := h.New()
+	hasher.Write(t.signed)
+	digest := hasher.Sum(nil)
+
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		if !strings.HasPrefix(t.header.Alg, "RS") {
+			return ErrSignature
+		}
+		if rsa.VerifyPKCS1v15(k, h, digest, t.signature) != nil {
+			return ErrSignature
+		}
+		return nil
+
+	case *ecdsa.PublicKey:
+		size := (k.Curve.Params().BitSize + 7) / 8
+		if !strings.HasPrefix(t.header.Alg, "ES") || len(t.signature) != 2*size {
+			return ErrSignature
+		}
+		r := new(big.Int).SetBytes(t.signature[:size])
+		s := new(big.Int).SetBytes(t.signature[size:])
+		if !ecdsa.Verify(k, digest, r, s) {
+			return ErrSignature
+		}
+		return nil
+	}
+	return ErrSignature
+}
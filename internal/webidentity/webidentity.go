@@ -0,0 +1,275 @@
+// Package webidentity 验证 OIDC 签发者签名的 JWT，用于 AssumeRoleWithWebIdentity
+//
+// 签发者的公钥从 JWKS 文件或者 HTTP 地址读取，不支持 OIDC discovery，
+// 只支持 RS256/384/512 和 ES256/384/512 签名
+package webidentity
+
+import (
+	"crypto"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/solution9th/S3Adapter/internal/config"
+
+	"github.com/haozibi/zlog"
+)
+
+const (
+	defaultRefresh = 10 * time.Minute
+
+	// minRefetch 遇到未知 kid 时重新获取 JWKS 的最短间隔，避免被伪造的 token 打满
+	minRefetch = time.Minute
+
+	// leeway 检查 exp 和 nbf 时允许的时钟误差
+	leeway = time.Minute
+
+	maxJWKSSize = 1 << 20
+)
+
+var (
+	// ErrSignature 签名不正确
+	ErrSignature = errors.New("webidentity: invalid signature")
+	// ErrExpired token 已经过期
+	ErrExpired = errors.New("webidentity: token is expired")
+	// ErrUnknownIssuer 没有配置 token 的签发者
+	ErrUnknownIssuer = errors.New("webidentity: unknown issuer")
+)
+
+// Verifier 验证多个签发者签发的 token
+type Verifier struct {
+	issuers map[string]*Issuer
+}
+
+// New 创建 Verifier，JWKSFile 在创建时读取一次，JWKSURL 在第一次使用时获取
+func New(cfgs []config.WebIdentity) (*Verifier, error) {
+
+	v := &Verifier{issuers: make(map[string]*Issuer, len(cfgs))}
+	for _, cfg := range cfgs {
+		i, err := newIssuer(cfg)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := v.issuers[cfg.Issuer]; ok {
+			return nil, fmt.Errorf("webidentity: duplicate issuer %q", cfg.Issuer)
+		}
+		v.issuers[cfg.Issuer] = i
+	}
+	return v, nil
+}
+
+// Enabled 是否配置了签发者
+func (v *Verifier) Enabled() bool {
+	return v != nil && len(v.issuers) > 0
+}
+
+// Verify 验证 token 的签名、iss、aud、exp 和 nbf，返回签发者和声明
+func (v *Verifier) Verify(raw string, now time.Time) (*Issuer, Claims, error) {
+
+	if !v.Enabled() {
+		return nil, nil, ErrUnknownIssuer
+	}
+
+	t, err := parse(raw)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	i, ok := v.issuers[t.claims.String("iss")]
+	if !ok {
+		return nil, nil, ErrUnknownIssuer
+	}
+
+	key, err := i.key(t.header.Kid, now)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err = t.verify(key); err != nil {
+		return nil, nil, err
+	}
+
+	exp, ok := t.claims.time("exp")
+	if !ok {
+		return nil, nil, errors.New("webidentity: missing exp")
+	}
+	if now.After(exp.Add(leeway)) {
+		return nil, nil, ErrExpired
+	}
+	if nbf, ok := t.claims.time("nbf"); ok && now.Add(leeway).Before(nbf) {
+		return nil, nil, errors.New("webidentity: token is not valid yet")
+	}
+
+	if !i.checkAudience(t.claims.Strings("aud")) {
+		return nil, nil, errors.New("webidentity: invalid audience")
+	}
+
+	return i, t.claims, nil
+}
+
+// Issuer 一个签发者，缓存它的公钥
+type Issuer struct {
+	cfg    config.WebIdentity
+	client *http.Client
+
+	mu        sync.Mutex
+	keys      map[string]crypto.PublicKey
+	loaded    time.Time
+	lastFetch time.Time
+}
+
+func newIssuer(cfg config.WebIdentity) (*Issuer, error) {
+
+	if cfg.Issuer == "" {
+		return nil, errors.New("webidentity: empty issuer")
+	}
+	if len(cfg.Audience) == 0 {
+		return nil, fmt.Errorf("webidentity: issuer %q: empty audience", cfg.Issuer)
+	}
+	if (cfg.JWKSFile == "") == (cfg.JWKSURL == "") {
+		return nil, fmt.Errorf("webidentity: issuer %q: one of jwksfile and jwksurl is required", cfg.Issuer)
+	}
+	if cfg.Refresh <= 0 {
+		cfg.Refresh = defaultRefresh
+	}
+
+	names := make(map[string]bool, len(cfg.Roles))
+	for _, role := range cfg.Roles {
+		if role.Name == "" || role.Application == "" {
+			return nil, fmt.Errorf("webidentity: issuer %q: role name and application are required", cfg.Issuer)
+		}
+		if names[role.Name] {
+			return nil, fmt.Errorf("webidentity: issuer %q: duplicate role %q", cfg.Issuer, role.Name)
+		}
+		names[role.Name] = true
+	}
+
+	i := &Issuer{cfg: cfg, client: &http.Client{Timeout: 5 * time.Second}}
+
+	if cfg.JWKSFile != "" {
+		if err := i.load(time.Now()); err != nil {
+			return nil, err
+		}
+	}
+	return i, nil
+}
+
+// Name 签发者，即 iss
+func (i *Issuer) Name() string {
+	return i.cfg.Issuer
+}
+
+// Role 返回 claims 可以扮演的角色 name
+func (i *Issuer) Role(name string, claims Claims) (config.WebIdentityRole, bool) {
+
+	for _, role := range i.cfg.Roles {
+		if role.Name != name {
+			continue
+		}
+		if role.Claim == "" {
+			return role, true
+		}
+		for _, v := range claims.Strings(role.Claim) {
+			for _, want := range role.Values {
+				if v == want {
+					return role, true
+				}
+			}
+		}
+		return role, false
+	}
+	return config.WebIdentityRole{}, false
+}
+
+func (i *Issuer) checkAudience(aud []string) bool {
+	for _, a := range aud {
+		for _, want := range i.cfg.Audience {
+			if a == want {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// key 返回 kid 对应的公钥，token 没有 kid 时 JWKS 中只能有一个 key
+func (i *Issuer) key(kid string, now time.Time) (crypto.PublicKey, error) {
+
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	if i.keys == nil || now.Sub(i.loaded) > i.cfg.Refresh {
+		if err := i.load(now); err != nil {
+			// 刷新失败时继续使用之前的公钥
+			if i.keys == nil {
+				return nil, err
+			}
+			zlog.ZWarn().Str("Issuer", i.cfg.Issuer).Msg("[WebIdentity] refresh jwks: " + err.Error())
+		}
+	}
+
+	key := i.find(kid)
+	if key == nil && i.cfg.JWKSURL != "" && now.Sub(i.lastFetch) > minRefetch {
+		// 签发者可能轮换了公钥
+		if err := i.load(now); err != nil {
+			zlog.ZWarn().Str("Issuer", i.cfg.Issuer).Msg("[WebIdentity] refresh jwks: " + err.Error())
+		}
+		key = i.find(kid)
+	}
+
+	if key == nil {
+		return nil, fmt.Errorf("webidentity: unknown key %q", kid)
+	}
+	return key, nil
+}
+
+func (i *Issuer) find(kid string) crypto.PublicKey {
+	if kid == "" && len(i.keys) == 1 {
+		for _, k := range i.keys {
+			return k
+		}
+	}
+	return i.keys[kid]
+}
+
+// load 读取 JWKS，失败时保留之前的公钥
+func (i *Issuer) load(now time.Time) error {
+
+	var body []byte
+	var err error
+	if i.cfg.JWKSFile != "" {
+		body, err = ioutil.ReadFile(i.cfg.JWKSFile)
+	} else {
+		i.lastFetch = now
+		body, err = i.fetch()
+	}
+	if err != nil {
+		return fmt.Errorf("webidentity: load jwks: %v", err)
+	}
+
+	keys, err := parseJWKS(body)
+	if err != nil {
+		return err
+	}
+
+	i.keys = keys
+	i.loaded = now
+	return nil
+}
+
+func (i *Issuer) fetch() ([]byte, error) {
+
+	resp, err := i.client.Get(i.cfg.JWKSURL)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	return ioutil.ReadAll(io.LimitReader(resp.Body, maxJWKSSize))
+}
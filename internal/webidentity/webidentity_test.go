@@ -0,0 +1,211 @@
+package webidentity
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/solution9th/S3Adapter/internal/config"
+
+	"github.com/smartystreets/goconvey/convey"
+)
+
+func b64(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func rsaJWK(kid string, k *rsa.PrivateKey) map[string]string {
+	return map[string]string{"kty": "RSA", "kid": kid, "use": "sig",
+		"n": b64(k.N.Bytes()), "e": b64(big.NewInt(int64(k.E)).Bytes())}
+}
+
+func ecJWK(kid string, k *ecdsa.PrivateKey) map[string]string {
+	return map[string]string{"kty": "EC", "kid": kid, "crv": "P-256",
+		"x": b64(k.X.Bytes()), "y": b64(k.Y.Bytes())}
+}
+
+func jwks(keys ...map[string]string) []byte {
+	b, _ := json.Marshal(map[string]interface{}{"keys": keys})
+	return b
+}
+
+// sign 使用 RS256 或者 ES256 签名
+func sign(alg, kid string, key crypto.Signer, claims map[string]interface{}) string {
+
+	h, _ := json.Marshal(map[string]string{"alg": alg, "kid": kid, "typ": "JWT"})
+	c, _ := json.Marshal(claims)
+	signed := b64(h) + "." + b64(c)
+	digest := sha256.Sum256([]byte(signed))
+
+	var sig []byte
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		sig, _ = rsa.SignPKCS1v15(rand.Reader, k, crypto.SHA256, digest[:])
+	case *ecdsa.PrivateKey:
+		r, s, _ := ecdsa.Sign(rand.Reader, k, digest[:])
+		sig = make([]byte, 64)
+		r.FillBytes(sig[:32])
+		s.FillBytes(sig[32:])
+	}
+	return signed + "." + b64(sig)
+}
+
+func TestVerifier(t *testing.T) {
+
+	convey.Convey("Verifier", t, func() {
+
+		rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+		ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		otherKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+
+		dir, _ := ioutil.TempDir("", "jwks")
+		defer os.RemoveAll(dir)
+		file := filepath.Join(dir, "jwks.json")
+		ioutil.WriteFile(file, jwks(rsaJWK("r1", rsaKey), ecJWK("e1", ecKey)), 0600)
+
+		now := time.Now()
+		claims := func(mod func(map[string]interface{})) map[string]interface{} {
+			c := map[string]interface{}{
+				"iss":    "https://sso.example.com",
+				"aud":    []string{"other", "s3adapter"},
+				"sub":    "alice",
+				"groups": []string{"dev", "storage-admins"},
+				"exp":    now.Add(time.Hour).Unix(),
+				"nbf":    now.Add(-time.Minute).Unix(),
+			}
+			if mod != nil {
+				mod(c)
+			}
+			return c
+		}
+
+		v, err := New([]config.WebIdentity{{
+			Issuer:   "https://sso.example.com",
+			Audience: []string{"s3adapter"},
+			JWKSFile: file,
+			Roles: []config.WebIdentityRole{
+				{Name: "admin", Claim: "groups", Values: []string{"storage-admins"}, Application: "oak"},
+				{Name: "ops", Claim: "groups", Values: []string{"ops"}, Application: "oak"},
+			},
+		}})
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(v.Enabled(), convey.ShouldBeTrue)
+
+		convey.Convey("valid token", func() {
+			for _, token := range []string{
+				sign("RS256", "r1", rsaKey, claims(nil)),
+				sign("ES256", "e1", ecKey, claims(nil)),
+			} {
+				i, c, err := v.Verify(token, now)
+				convey.So(err, convey.ShouldBeNil)
+				convey.So(i.Name(), convey.ShouldEqual, "https://sso.example.com")
+				convey.So(c.String("sub"), convey.ShouldEqual, "alice")
+
+				role, ok := i.Role("admin", c)
+				convey.So(ok, convey.ShouldBeTrue)
+				convey.So(role.Application, convey.ShouldEqual, "oak")
+
+				_, ok = i.Role("ops", c)
+				convey.So(ok, convey.ShouldBeFalse)
+				_, ok = i.Role("nobody", c)
+				convey.So(ok, convey.ShouldBeFalse)
+			}
+		})
+
+		convey.Convey("invalid token", func() {
+			_, _, err := v.Verify(sign("RS256", "r1", rsaKey, claims(func(c map[string]interface{}) {
+				c["exp"] = now.Add(-time.Hour).Unix()
+			})), now)
+			convey.So(err, convey.ShouldEqual, ErrExpired)
+
+			_, _, err = v.Verify(sign("RS256", "r1", rsaKey, claims(func(c map[string]interface{}) {
+				c["iss"] = "https://evil.example.com"
+			})), now)
+			convey.So(err, convey.ShouldEqual, ErrUnknownIssuer)
+
+			_, _, err = v.Verify(sign("RS256", "r1", otherKey, claims(nil)), now)
+			convey.So(err, convey.ShouldEqual, ErrSignature)
+
+			// 用 RSA 的 kid 声明 ES256
+			_, _, err = v.Verify(sign("ES256", "r1", ecKey, claims(nil)), now)
+			convey.So(err, convey.ShouldEqual, ErrSignature)
+
+			for _, token := range []string{
+				"",
+				"a.b",
+				"a.b.c",
+				sign("RS256", "unknown", rsaKey, claims(nil)),
+				sign("RS256", "", rsaKey, claims(nil)),
+				sign("RS256", "r1", rsaKey, claims(func(c map[string]interface{}) { c["aud"] = "other" })),
+				sign("RS256", "r1", rsaKey, claims(func(c map[string]interface{}) { delete(c, "exp") })),
+				sign("RS256", "r1", rsaKey, claims(func(c map[string]interface{}) { c["nbf"] = now.Add(time.Hour).Unix() })),
+			} {
+				_, _, err = v.Verify(token, now)
+				convey.So(err, convey.ShouldNotBeNil)
+			}
+
+			// alg none
+			token := sign("RS256", "r1", rsaKey, claims(nil))
+			h := b64([]byte(`{"alg":"none","kid":"r1"}`))
+			_, _, err = v.Verify(h+token[len(h):], now)
+			convey.So(err, convey.ShouldNotBeNil)
+		})
+
+		convey.Convey("jwks url", func() {
+			keys := jwks(rsaJWK("r1", rsaKey))
+			var fetched int
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				fetched++
+				w.Write(keys)
+			}))
+			defer ts.Close()
+
+			v, err := New([]config.WebIdentity{{Issuer: "https://sso.example.com", Audience: []string{"s3adapter"}, JWKSURL: ts.URL}})
+			convey.So(err, convey.ShouldBeNil)
+			convey.So(fetched, convey.ShouldEqual, 0)
+
+			_, _, err = v.Verify(sign("RS256", "", rsaKey, claims(nil)), now)
+			convey.So(err, convey.ShouldBeNil)
+			convey.So(fetched, convey.ShouldEqual, 1)
+
+			// 签发者轮换了公钥
+			keys = jwks(rsaJWK("r1", rsaKey), rsaJWK("r2", otherKey))
+			_, _, err = v.Verify(sign("RS256", "r2", otherKey, claims(nil)), now)
+			convey.So(err, convey.ShouldNotBeNil)
+			_, _, err = v.Verify(sign("RS256", "r2", otherKey, claims(nil)), now.Add(2*minRefetch))
+			convey.So(err, convey.ShouldBeNil)
+			convey.So(fetched, convey.ShouldEqual, 2)
+		})
+
+		convey.Convey("config", func() {
+			for _, cfg := range []config.WebIdentity{
+				{Audience: []string{"a"}, JWKSFile: file},
+				{Issuer: "i", JWKSFile: file},
+				{Issuer: "i", Audience: []string{"a"}},
+				{Issuer: "i", Audience: []string{"a"}, JWKSFile: file, JWKSURL: "http://127.0.0.1"},
+				{Issuer: "i", Audience: []string{"a"}, JWKSFile: filepath.Join(dir, "missing.json")},
+				{Issuer: "i", Audience: []string{"a"}, JWKSFile: file, Roles: []config.WebIdentityRole{{Name: "r"}}},
+			} {
+				_, err := New([]config.WebIdentity{cfg})
+				convey.So(err, convey.ShouldNotBeNil)
+			}
+
+			v, err := New(nil)
+			convey.So(err, convey.ShouldBeNil)
+			convey.So(v.Enabled(), convey.ShouldBeFalse)
+		})
+	})
+}